@@ -2,6 +2,7 @@ package deploy
 
 import (
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -22,6 +23,14 @@ type DeploymentLogger struct {
 	// the router. Only returns on Wait() if all logs have been sent to the
 	// router. Used in Flush().
 	wg sync.WaitGroup
+
+	// Logs to the log of the Applikatoni server, not the deployment
+	server *slog.Logger
+
+	// Masks the secrets in the messages, nil if nothing is masked
+	redact func(string) string
+	// Returns the length of the longest secret redact masks
+	redactOverlap func() int
 }
 
 func NewDeploymentLogger(d *models.Deployment, r *LogRouter) *DeploymentLogger {
@@ -30,14 +39,27 @@ func NewDeploymentLogger(d *models.Deployment, r *LogRouter) *DeploymentLogger {
 		router:     r,
 		ch:         make(chan LogEntry, 100),
 		wg:         sync.WaitGroup{},
+		server:     slog.With("deployment", d),
+	}
+}
+
+// serverLog returns the logger for problems of the deployment that only
+// concern the operators of Applikatoni, e.g. lost connections.
+func (l *DeploymentLogger) serverLog() *slog.Logger {
+	if l.server == nil {
+		return slog.Default()
 	}
+	return l.server
 }
 
 func (l *DeploymentLogger) BroadcastLogs() {
 	l.router.Announce(l.deployment.Id)
 
 	go func() {
+		sequence := 0
 		for entry := range l.ch {
+			sequence++
+			entry.Sequence = sequence
 			entry.DeploymentId = l.deployment.Id
 			l.router.Broadcast <- entry
 			l.wg.Done()
@@ -46,6 +68,10 @@ func (l *DeploymentLogger) BroadcastLogs() {
 }
 
 func (l *DeploymentLogger) Log(entry LogEntry) {
+	if l.redact != nil {
+		entry.Message = l.redact(entry.Message)
+	}
+
 	l.wg.Add(1)
 	l.ch <- entry
 }
@@ -67,23 +93,37 @@ func (l *DeploymentLogger) LogCmdStart(origin, cmd string) {
 	l.Log(entry)
 }
 
-func (l *DeploymentLogger) LogCmdFail(origin, cmd string, err error) {
+func (l *DeploymentLogger) LogCmdFail(origin, cmd string, err error, duration time.Duration) {
 	entry := LogEntry{
 		Origin:    origin,
 		EntryType: COMMAND_FAIL,
 		Message:   fmt.Sprintf("cmd=\"%s\", error=\"%s\"", cmd, err),
 		Timestamp: time.Now(),
+		ExitCode:  exitCode(err),
+		Duration:  duration,
 	}
 
 	l.Log(entry)
 }
 
-func (l *DeploymentLogger) LogCmdSuccess(origin, cmd string) {
+func (l *DeploymentLogger) LogCmdSuccess(origin, cmd string, duration time.Duration) {
 	entry := LogEntry{
 		Origin:    origin,
 		EntryType: COMMAND_SUCCESS,
 		Message:   fmt.Sprintf("\"%s\"", cmd),
 		Timestamp: time.Now(),
+		Duration:  duration,
+	}
+
+	l.Log(entry)
+}
+
+func (l *DeploymentLogger) LogConnectionFail(origin string, err error) {
+	entry := LogEntry{
+		Origin:    origin,
+		EntryType: COMMAND_FAIL,
+		Message:   fmt.Sprintf("connecting via SSH failed: %s", err),
+		Timestamp: time.Now(),
 	}
 
 	l.Log(entry)
@@ -176,3 +216,36 @@ func (l *DeploymentLogger) LogKillReceived() {
 
 	l.Log(entry)
 }
+
+func (l *DeploymentLogger) LogTimeout(timeout time.Duration) {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: KILL_RECEIVED,
+		Message:   fmt.Sprintf("deployment timed out after %s, aborting", timeout),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
+
+func (l *DeploymentLogger) LogApprovalPending(stage models.DeploymentStage) {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: APPROVAL_PENDING,
+		Message:   string(stage),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
+
+func (l *DeploymentLogger) LogApprovalReceived(stage models.DeploymentStage, approver string) {
+	entry := LogEntry{
+		Origin:    "applikatoni",
+		EntryType: APPROVAL_RECEIVED,
+		Message:   fmt.Sprintf("%s approved by %s", stage, approver),
+		Timestamp: time.Now(),
+	}
+
+	l.Log(entry)
+}
@@ -1,6 +1,9 @@
 package deploy
 
 import (
+	"errors"
+	"os/exec"
+	"strings"
 	"testing"
 	"time"
 
@@ -93,6 +96,20 @@ func TestFlush(t *testing.T) {
 	<-testDone
 }
 
+func TestSequence(t *testing.T) {
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		logger.Log(testLogEntry)
+		logger.Log(testLogEntry)
+		logger.Log(testLogEntry)
+	})
+
+	for i, entry := range entries {
+		if entry.Sequence != i+1 {
+			t.Errorf("wrong sequence. expected=%d, got=%d", i+1, entry.Sequence)
+		}
+	}
+}
+
 func TestLogCmdStart(t *testing.T) {
 	router := NewLogRouter()
 	router.Announce(testId)
@@ -119,3 +136,95 @@ func TestLogCmdStart(t *testing.T) {
 		t.Errorf("wrong message. expected=%s, got=%s", "whoami", entry.Message)
 	}
 }
+
+func TestLogRedacted(t *testing.T) {
+	router := NewLogRouter()
+	router.Announce(testId)
+
+	logger := NewDeploymentLogger(deployment, router)
+	logger.redact = func(msg string) string {
+		return strings.Replace(msg, "s3cr3t", "[REDACTED]", -1)
+	}
+	logger.BroadcastLogs()
+	logger.LogCmdStart("example.org", "mysql -p s3cr3t")
+
+	entry := <-router.Broadcast
+
+	if entry.Message != "mysql -p [REDACTED]" {
+		t.Errorf("secret not masked. got=%s", entry.Message)
+	}
+}
+
+// collectLogEntries passes a broadcasting DeploymentLogger to fn and returns
+// all LogEntries that have been logged once fn returns.
+func collectLogEntries(fn func(*DeploymentLogger)) []LogEntry {
+	router := NewLogRouter()
+	router.Announce(testId)
+
+	logger := NewDeploymentLogger(deployment, router)
+	logger.BroadcastLogs()
+
+	entries := []LogEntry{}
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case entry := <-router.Broadcast:
+				entries = append(entries, entry)
+			case <-router.Done:
+				// Broadcast is buffered, the last entries can still be in it
+				for {
+					select {
+					case entry := <-router.Broadcast:
+						entries = append(entries, entry)
+					default:
+						close(done)
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	fn(logger)
+	logger.Flush()
+	<-done
+
+	return entries
+}
+
+func TestLogCmdFailExitCode(t *testing.T) {
+	cmdErr := exec.Command("/bin/sh", "-c", "exit 3").Run()
+
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		logger.LogCmdFail("example.org", "exit 3", cmdErr, 2*time.Second)
+		logger.LogCmdFail("example.org", "whoami", errors.New("connection lost"), time.Second)
+		logger.LogCmdSuccess("example.org", "whoami", 3*time.Second)
+	})
+
+	expected := []struct {
+		entryType LogEntryType
+		exitCode  int
+		duration  time.Duration
+	}{
+		{COMMAND_FAIL, 3, 2 * time.Second},
+		{COMMAND_FAIL, -1, time.Second},
+		{COMMAND_SUCCESS, 0, 3 * time.Second},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("wrong number of log entries. want=%d, got=%d", len(expected), len(entries))
+	}
+
+	for i, e := range expected {
+		if entries[i].EntryType != e.entryType {
+			t.Errorf("wrong entrytype. expected=%s, got=%s", e.entryType, entries[i].EntryType)
+		}
+		if entries[i].ExitCode != e.exitCode {
+			t.Errorf("wrong exit code. expected=%d, got=%d", e.exitCode, entries[i].ExitCode)
+		}
+		if entries[i].Duration != e.duration {
+			t.Errorf("wrong duration. expected=%s, got=%s", e.duration, entries[i].Duration)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package deploy
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// Hooks are run on the Applikatoni server itself, not on the target hosts.
+// Their output shows up in the deployment log with this origin.
+const hookOrigin = "applikatoni"
+
+// Pre-deployment hooks are part of the deployment, they're killed once it
+// timed out.
+func (m *Manager) runPreDeploymentHooks() error {
+	ctx, cancel := hookContext(m.deadline)
+	defer cancel()
+
+	for _, hook := range m.config.PreDeploymentHooks {
+		err := m.runHook(ctx, hook, hookEnv(m.config.Deployment, ""))
+		if err != nil {
+			return fmt.Errorf("pre-deployment hook vetoed deployment: %s", err)
+		}
+	}
+	return nil
+}
+
+// Post-deployment hooks are run regardless of the outcome of the deployment.
+// Their failure is logged but does not change the outcome. The deployment may
+// have timed out already, so every hook may take the deployment timeout.
+func (m *Manager) runPostDeploymentHooks(deployErr error) {
+	result := models.DEPLOYMENT_SUCCESSFUL
+	if deployErr != nil {
+		result = models.DEPLOYMENT_FAILED
+	}
+
+	for _, hook := range m.config.PostDeploymentHooks {
+		var deadline time.Time
+		if m.config.Timeout > 0 {
+			deadline = time.Now().Add(m.config.Timeout)
+		}
+
+		ctx, cancel := hookContext(deadline)
+		m.runHook(ctx, hook, hookEnv(m.config.Deployment, result))
+		cancel()
+	}
+}
+
+// hookContext returns the context of hooks that have to finish by the
+// deadline, without a deadline if it's zero.
+func hookContext(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+// runHook runs the hook until it exits or the context is done. A hook that's
+// killed takes the processes it started along, since they'd keep its output
+// open.
+func (m *Manager) runHook(ctx context.Context, hook string, env []string) error {
+	m.logger.LogCmdStart(hookOrigin, hook)
+	start := time.Now()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", hook)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		m.logger.LogCmdFail(hookOrigin, hook, err, time.Since(start))
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		m.logger.LogCmdFail(hookOrigin, hook, err, time.Since(start))
+		return err
+	}
+
+	if err = cmd.Start(); err != nil {
+		m.logger.LogCmdFail(hookOrigin, hook, err, time.Since(start))
+		return err
+	}
+
+	// All output has to be read before calling Wait()
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		logOutput(m.logger, hookOrigin, COMMAND_STDOUT_OUTPUT, stdout)
+		wg.Done()
+	}()
+	go func() {
+		logOutput(m.logger, hookOrigin, COMMAND_STDERR_OUTPUT, stderr)
+		wg.Done()
+	}()
+	wg.Wait()
+
+	if err = cmd.Wait(); err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out, the deployment timeout is %s", m.config.Timeout)
+		}
+		m.logger.LogCmdFail(hookOrigin, hook, err, time.Since(start))
+		return err
+	}
+
+	m.logger.LogCmdSuccess(hookOrigin, hook, time.Since(start))
+	return nil
+}
+
+func hookEnv(d *models.Deployment, result models.DeploymentState) []string {
+	env := []string{
+		fmt.Sprintf("APPLIKATONI_DEPLOYMENT_ID=%d", d.Id),
+		fmt.Sprintf("APPLIKATONI_APPLICATION=%s", d.ApplicationName),
+		fmt.Sprintf("APPLIKATONI_TARGET=%s", d.TargetName),
+		fmt.Sprintf("APPLIKATONI_COMMIT_SHA=%s", d.CommitSha),
+		fmt.Sprintf("APPLIKATONI_BRANCH=%s", d.Branch),
+		fmt.Sprintf("APPLIKATONI_COMMENT=%s", d.Comment),
+	}
+	if result != "" {
+		env = append(env, fmt.Sprintf("APPLIKATONI_DEPLOYMENT_RESULT=%s", result))
+	}
+	return env
+}
@@ -0,0 +1,144 @@
+package deploy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func collectHookEntries(hooks []string, pre bool) ([]LogEntry, error) {
+	config := &models.DeploymentConfig{
+		Deployment:          &models.Deployment{Id: testId, TargetName: "production"},
+		PreDeploymentHooks:  hooks,
+		PostDeploymentHooks: hooks,
+	}
+
+	var err error
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		m := &Manager{config: config, logger: logger}
+		if pre {
+			err = m.runPreDeploymentHooks()
+		} else {
+			m.runPostDeploymentHooks(nil)
+		}
+	})
+
+	return entries, err
+}
+
+func TestPreDeploymentHooks(t *testing.T) {
+	entries, err := collectHookEntries([]string{"echo $APPLIKATONI_TARGET"}, true)
+	if err != nil {
+		t.Fatalf("running hooks failed. err=%s", err)
+	}
+
+	expected := []struct {
+		entryType LogEntryType
+		message   string
+	}{
+		{COMMAND_START, "echo $APPLIKATONI_TARGET"},
+		{COMMAND_STDOUT_OUTPUT, "production\n"},
+		{COMMAND_SUCCESS, "\"echo $APPLIKATONI_TARGET\""},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("wrong number of log entries. want=%d, got=%d (%+v)", len(expected), len(entries), entries)
+	}
+
+	for i, e := range expected {
+		if entries[i].EntryType != e.entryType {
+			t.Errorf("wrong entry type. want=%s, got=%s", e.entryType, entries[i].EntryType)
+		}
+		if entries[i].Message != e.message {
+			t.Errorf("wrong message. want=%q, got=%q", e.message, entries[i].Message)
+		}
+		if entries[i].Origin != hookOrigin {
+			t.Errorf("wrong origin. want=%s, got=%s", hookOrigin, entries[i].Origin)
+		}
+	}
+}
+
+func TestPreDeploymentHooksVeto(t *testing.T) {
+	entries, err := collectHookEntries([]string{"exit 1", "echo never"}, true)
+	if err == nil {
+		t.Fatalf("expected failing hook to veto the deployment")
+	}
+
+	last := entries[len(entries)-1]
+	if last.EntryType != COMMAND_FAIL {
+		t.Errorf("wrong entry type. want=%s, got=%s", COMMAND_FAIL, last.EntryType)
+	}
+	for _, entry := range entries {
+		if entry.Message == "echo never" {
+			t.Errorf("hook after vetoing hook has been run")
+		}
+	}
+}
+
+func TestPostDeploymentHooksResult(t *testing.T) {
+	entries, _ := collectHookEntries([]string{"echo $APPLIKATONI_DEPLOYMENT_RESULT"}, false)
+
+	found := false
+	for _, entry := range entries {
+		if entry.EntryType == COMMAND_STDOUT_OUTPUT && entry.Message == "successful\n" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("post-deployment hook did not receive the deployment result. entries=%+v", entries)
+	}
+}
+
+func TestPreDeploymentHooksTimeout(t *testing.T) {
+	config := &models.DeploymentConfig{
+		Deployment:         &models.Deployment{Id: testId, TargetName: "production"},
+		PreDeploymentHooks: []string{"sleep 10; echo never"},
+		Timeout:            100 * time.Millisecond,
+	}
+
+	var err error
+	start := time.Now()
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		m := &Manager{config: config, logger: logger, deadline: time.Now().Add(config.Timeout)}
+		err = m.runPreDeploymentHooks()
+	})
+
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("hanging hook not timed out. got=%v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("hanging hook blocked the deployment for %s", elapsed)
+	}
+
+	last := entries[len(entries)-1]
+	if last.EntryType != COMMAND_FAIL {
+		t.Errorf("wrong entry type. want=%s, got=%s", COMMAND_FAIL, last.EntryType)
+	}
+	for _, entry := range entries {
+		if entry.EntryType == COMMAND_STDOUT_OUTPUT {
+			t.Errorf("hook not killed. got=%+v", entry)
+		}
+	}
+}
+
+func TestPostDeploymentHooksAfterTimeout(t *testing.T) {
+	config := &models.DeploymentConfig{
+		Deployment:          &models.Deployment{Id: testId, TargetName: "production"},
+		PostDeploymentHooks: []string{"echo $APPLIKATONI_DEPLOYMENT_RESULT"},
+		Timeout:             time.Second,
+	}
+
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		// The deployment timed out already
+		m := &Manager{config: config, logger: logger, deadline: time.Now().Add(-time.Second)}
+		m.runPostDeploymentHooks(errors.New("Deployment timed out after 1s"))
+	})
+
+	last := entries[len(entries)-1]
+	if last.EntryType != COMMAND_SUCCESS {
+		t.Errorf("post-deployment hook not run after the timeout. entries=%+v", entries)
+	}
+}
@@ -2,7 +2,7 @@ package deploy
 
 import (
 	"errors"
-	"log"
+	"log/slog"
 	"sync"
 	"time"
 )
@@ -13,8 +13,23 @@ type Listener func(<-chan LogEntry)
 
 var ErrNoDeployment = errors.New("no deployment with this ID found")
 var ErrTimeout = errors.New("sending to listener timed out")
+
+// How long the router waits for a new listener of a running deployment to
+// receive the backlog, once the buffer of the listener is full
 var ListenerTimeout = 200 * time.Millisecond
 
+var (
+	// How many log entries the deployments can broadcast before they wait
+	// for the router
+	BroadcastBuffer = 1024
+	// How many log entries are buffered for each listener. Listeners of a
+	// running deployment whose buffer is full are unsubscribed, so a slow
+	// browser doesn't hold up the others. The router waits for listeners of
+	// all deployments, e.g. the one saving the entries, only once their
+	// buffer is full, since they must not miss entries.
+	ListenerBuffer = 1024
+)
+
 const (
 	COMMAND_STDOUT_OUTPUT LogEntryType = "COMMAND_STDOUT_OUTPUT"
 	COMMAND_STDERR_OUTPUT LogEntryType = "COMMAND_STDERR_OUTPUT"
@@ -29,15 +44,64 @@ const (
 	DEPLOYMENT_SUCCESS    LogEntryType = "DEPLOYMENT_SUCCESS"
 	DEPLOYMENT_FAIL       LogEntryType = "DEPLOYMENT_FAIL"
 	KILL_RECEIVED         LogEntryType = "KILL_RECEIVED"
+	APPROVAL_PENDING      LogEntryType = "APPROVAL_PENDING"
+	APPROVAL_RECEIVED     LogEntryType = "APPROVAL_RECEIVED"
 )
 
+// LogEntryTypes contains all types of log entries, e.g. to validate filters.
+var LogEntryTypes = []LogEntryType{
+	COMMAND_STDOUT_OUTPUT,
+	COMMAND_STDERR_OUTPUT,
+	COMMAND_START,
+	COMMAND_FAIL,
+	COMMAND_SUCCESS,
+	STAGE_START,
+	STAGE_FAIL,
+	STAGE_SUCCESS,
+	STAGE_RESULT,
+	DEPLOYMENT_START,
+	DEPLOYMENT_SUCCESS,
+	DEPLOYMENT_FAIL,
+	KILL_RECEIVED,
+	APPROVAL_PENDING,
+	APPROVAL_RECEIVED,
+}
+
 type LogEntry struct {
-	Id           int          `json:"id"`
+	Id int `json:"id"`
+	// The position of the entry in the log of the deployment, starting at 1.
+	// Clients that reconnect pass the last sequence they received to only
+	// get the entries after it.
+	Sequence     int          `json:"sequence"`
 	Timestamp    time.Time    `json:"timestamp"`
 	DeploymentId int          `json:"deployment_id"`
 	Origin       string       `json:"origin"`
 	EntryType    LogEntryType `json:"entry_type"`
 	Message      string       `json:"message"`
+	// Only set for COMMAND_SUCCESS and COMMAND_FAIL. ExitCode is -1 if the
+	// command didn't exit, e.g. because the connection was lost.
+	ExitCode int           `json:"exit_code"`
+	Duration time.Duration `json:"duration"`
+}
+
+// RouterStats tell whether the listeners keep up with the log entries of the
+// running deployments, e.g. to export them as metrics.
+type RouterStats struct {
+	// The listeners of running deployments and of all deployments
+	Listeners int
+	// The entries kept to send to new listeners of running deployments
+	BacklogEntries int
+	// The entries broadcast since the router was created
+	RoutedEntries int
+	// The entries that didn't fit into the buffer of a listener of a running
+	// deployment. The listener is unsubscribed, so it misses the following
+	// entries too.
+	DroppedEntries int
+	// The time the router waited for listeners with full buffers to receive
+	// entries, in which it couldn't route other entries
+	ListenerWait time.Duration
+	// The entries waiting in the buffers of the router and the listeners
+	BufferedEntries int
 }
 
 type subscription struct {
@@ -57,20 +121,28 @@ type LogRouter struct {
 	// Send a ListenRequest on this channel to register for LogEntries
 	subscribe chan subscription
 
-	stop chan struct{}
+	// Closed to stop routing, and by the router once it has stopped
+	stop     chan struct{}
+	stopOnce sync.Once
+	stopped  chan struct{}
 
-	// The mutex around `subscriptions`
+	// The mutex around `subscriptions` and `stats`
 	mu            *sync.Mutex
 	subscriptions map[int][]subscription
 	backlog       map[int][]LogEntry
+	stats         RouterStats
+
+	// The listeners that haven't returned yet
+	listeners sync.WaitGroup
 }
 
 func NewLogRouter() *LogRouter {
 	return &LogRouter{
-		Broadcast:     make(chan LogEntry),
+		Broadcast:     make(chan LogEntry, BroadcastBuffer),
 		Done:          make(chan int),
 		subscribe:     make(chan subscription),
 		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
 		mu:            &sync.Mutex{},
 		subscriptions: make(map[int][]subscription),
 		backlog:       make(map[int][]LogEntry),
@@ -84,7 +156,7 @@ func (r *LogRouter) Start() {
 			case sub := <-r.subscribe:
 				err := r.sendBacklog(sub)
 				if err != nil && err == ErrTimeout {
-					log.Println("timeout when sending backlog, not adding subscription")
+					slog.Warn("timeout when sending backlog, not adding subscription", "deployment.id", sub.DeploymentId)
 					close(sub.Target)
 					continue
 				}
@@ -93,17 +165,78 @@ func (r *LogRouter) Start() {
 				r.saveLogEntry(logEntry)
 				r.routeLogEntry(logEntry)
 			case deploymentId := <-r.Done:
+				// The entries of the deployment were broadcast before it's
+				// done, but may still be buffered
+				r.drainBroadcast()
 				r.deleteSubscriptions(deploymentId)
 				r.deleteBacklog(deploymentId)
 			case <-r.stop:
+				r.drainBroadcast()
+				close(r.stopped)
 				return
 			}
 		}
 	}()
 }
 
+// Stop stops the router and waits until it has routed the buffered entries.
 func (r *LogRouter) Stop() {
-	r.stop <- struct{}{}
+	r.stopOnce.Do(func() { close(r.stop) })
+	<-r.stopped
+}
+
+// Close stops the router and closes the channels of all listeners, then
+// waits up to timeout for the listeners to return, e.g. after saving the last
+// log entries. Only close the router once no deployment is running anymore.
+func (r *LogRouter) Close(timeout time.Duration) error {
+	deadline := time.After(timeout)
+
+	// The router may still be sending the buffered entries to the listeners,
+	// their channels can only be closed once it's done
+	r.stopOnce.Do(func() { close(r.stop) })
+	select {
+	case <-r.stopped:
+	case <-deadline:
+		return ErrTimeout
+	}
+
+	r.mu.Lock()
+	for id := range r.subscriptions {
+		for _, sub := range r.subscriptions[id] {
+			close(sub.Target)
+		}
+		delete(r.subscriptions, id)
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.listeners.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-deadline:
+		return ErrTimeout
+	}
+}
+
+// Stats returns the current numbers of the router.
+func (r *LogRouter) Stats() RouterStats {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stats := r.stats
+	stats.BufferedEntries = len(r.Broadcast)
+	for _, subs := range r.subscriptions {
+		stats.Listeners += len(subs)
+		for _, sub := range subs {
+			stats.BufferedEntries += len(sub.Target)
+		}
+	}
+	return stats
 }
 
 func (r *LogRouter) Announce(deploymentId int) {
@@ -112,6 +245,14 @@ func (r *LogRouter) Announce(deploymentId int) {
 	r.mu.Unlock()
 }
 
+// IsRunning returns whether the deployment was announced and isn't done yet.
+func (r *LogRouter) IsRunning(deploymentId int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.subscriptions[deploymentId]
+	return ok
+}
+
 func (r *LogRouter) Subscribe(deploymentId int, l Listener) error {
 	r.mu.Lock()
 	_, ok := r.subscriptions[deploymentId]
@@ -121,9 +262,13 @@ func (r *LogRouter) Subscribe(deploymentId int, l Listener) error {
 	}
 	r.mu.Unlock()
 
-	ch := make(chan LogEntry)
+	ch := make(chan LogEntry, ListenerBuffer)
 	r.subscribe <- subscription{Target: ch, DeploymentId: deploymentId}
-	go l(ch)
+	r.listeners.Add(1)
+	go func() {
+		defer r.listeners.Done()
+		l(ch)
+	}()
 
 	return nil
 }
@@ -139,15 +284,35 @@ func (r *LogRouter) addSubscription(sub subscription) {
 	r.subscriptions[id] = append(r.subscriptions[id], sub)
 }
 
+// drainBroadcast routes the buffered entries.
+func (r *LogRouter) drainBroadcast() {
+	for {
+		select {
+		case logEntry := <-r.Broadcast:
+			r.saveLogEntry(logEntry)
+			r.routeLogEntry(logEntry)
+		default:
+			return
+		}
+	}
+}
+
 func (r *LogRouter) saveLogEntry(logEntry LogEntry) {
 	id := logEntry.DeploymentId
 	r.backlog[id] = append(r.backlog[id], logEntry)
+
+	r.mu.Lock()
+	r.stats.BacklogEntries++
+	r.stats.RoutedEntries++
+	r.mu.Unlock()
 }
 
 func (r *LogRouter) sendBacklog(sub subscription) error {
-	for _, logEntry := range r.backlog[sub.DeploymentId] {
+	backlog := r.backlog[sub.DeploymentId]
+	for i, logEntry := range backlog {
 		err := r.sendWithTimeout(sub, logEntry)
 		if err != nil {
+			r.countDropped(len(backlog) - i)
 			return err
 		}
 	}
@@ -157,26 +322,35 @@ func (r *LogRouter) sendBacklog(sub subscription) error {
 func (r *LogRouter) routeLogEntry(logEntry LogEntry) {
 	id := logEntry.DeploymentId
 	if id == 0 {
-		log.Println("ERROR routing LogEntry: DeploymentId is 0")
+		slog.Error("routing log entry failed, the deployment ID is 0", "entry_type", logEntry.EntryType)
 		return
 	}
 
 	success := []subscription{}
 
 	for _, sub := range r.subscriptions[id] {
-		err := r.sendWithTimeout(sub, logEntry)
-		if err != nil && err == ErrTimeout {
-			log.Println("timeout when routing log entry, deleting subscription")
-			close(sub.Target)
-		} else {
+		select {
+		case sub.Target <- logEntry:
 			success = append(success, sub)
+		default:
+			slog.Warn("buffer of listener full when routing log entry, deleting subscription", "deployment.id", id)
+			r.countDropped(1)
+			close(sub.Target)
 		}
 	}
 
+	r.mu.Lock()
 	r.subscriptions[id] = success
+	r.mu.Unlock()
 
 	for _, sub := range r.subscriptions[0] {
-		sub.Target <- logEntry
+		select {
+		case sub.Target <- logEntry:
+		default:
+			start := time.Now()
+			sub.Target <- logEntry
+			r.countWait(time.Since(start))
+		}
 	}
 }
 
@@ -191,10 +365,17 @@ func (r *LogRouter) deleteSubscriptions(deploymentId int) {
 }
 
 func (r *LogRouter) deleteBacklog(deploymentId int) {
+	r.mu.Lock()
+	r.stats.BacklogEntries -= len(r.backlog[deploymentId])
+	r.mu.Unlock()
+
 	delete(r.backlog, deploymentId)
 }
 
 func (r *LogRouter) sendWithTimeout(s subscription, logEntry LogEntry) error {
+	start := time.Now()
+	defer func() { r.countWait(time.Since(start)) }()
+
 	select {
 	case s.Target <- logEntry:
 	case <-time.After(ListenerTimeout):
@@ -202,3 +383,15 @@ func (r *LogRouter) sendWithTimeout(s subscription, logEntry LogEntry) error {
 	}
 	return nil
 }
+
+func (r *LogRouter) countDropped(n int) {
+	r.mu.Lock()
+	r.stats.DroppedEntries += n
+	r.mu.Unlock()
+}
+
+func (r *LogRouter) countWait(d time.Duration) {
+	r.mu.Lock()
+	r.stats.ListenerWait += d
+	r.mu.Unlock()
+}
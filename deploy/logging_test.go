@@ -1,6 +1,7 @@
 package deploy
 
 import (
+	"fmt"
 	"testing"
 	"time"
 )
@@ -77,6 +78,31 @@ func TestSubscribeAll(t *testing.T) {
 	<-testDone
 }
 
+func TestIsRunning(t *testing.T) {
+	router := NewLogRouter()
+	router.Start()
+	defer router.Stop()
+
+	if router.IsRunning(8888) {
+		t.Errorf("deployment running before it was announced")
+	}
+
+	router.Announce(8888)
+	if !router.IsRunning(8888) {
+		t.Errorf("announced deployment not running")
+	}
+
+	router.Done <- 8888
+	// The router deletes the deployment after receiving its ID
+	deadline := time.Now().Add(time.Second)
+	for router.IsRunning(8888) {
+		if time.Now().After(deadline) {
+			t.Fatalf("deployment still running after it's done")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
 func TestDoneBroadcasting(t *testing.T) {
 	router := NewLogRouter()
 	router.Start()
@@ -172,75 +198,107 @@ func TestSubscribeAfterBroadcastEnd(t *testing.T) {
 	}
 }
 
-func TestRoutingTimeout(t *testing.T) {
+// withListenerBuffer sets the ListenerBuffer until the returned function is
+// called.
+func withListenerBuffer(size int) func() {
+	original := ListenerBuffer
+	ListenerBuffer = size
+	return func() { ListenerBuffer = original }
+}
+
+func TestRoutingFullBuffer(t *testing.T) {
+	defer withListenerBuffer(1)()
+
 	router := NewLogRouter()
 	router.Start()
 	defer router.Stop()
 
 	testDone := make(chan struct{})
+	received := make(chan struct{})
+	release := make(chan struct{})
 
 	router.Announce(8888)
 
 	slowListener := func(ch <-chan LogEntry) {
-		<-ch // Receive the first message
+		<-release
 
-		time.Sleep(ListenerTimeout + 100*time.Millisecond)
-
-		// ch should be closed now since we timed out
-		_, open := <-ch
-		if open {
-			t.Errorf("channel still open after timeout!")
+		// The first entry is buffered, then ch is closed since the second
+		// didn't fit into the buffer
+		if _, open := <-ch; !open {
+			t.Errorf("buffered entry not received")
+		}
+		if _, open := <-ch; open {
+			t.Errorf("channel still open after buffer was full!")
 		}
 		testDone <- struct{}{}
 	}
 
 	goodListener := func(ch <-chan LogEntry) {
 		<-ch
+		received <- struct{}{}
 		<-ch
+		received <- struct{}{}
 		testDone <- struct{}{}
 	}
 
-	router.Subscribe(8888, slowListener) // times out
+	router.Subscribe(8888, slowListener) // buffer gets full
 	router.Subscribe(8888, goodListener) // should receive both log entries
 
-	go func() {
-		router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
-		router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
-	}()
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
+	<-received
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "two", DeploymentId: 8888}
+	<-received
+	close(release)
 
 	<-testDone
 	<-testDone
+
+	if stats := router.Stats(); stats.DroppedEntries != 1 {
+		t.Errorf("wrong number of dropped entries. want=1, got=%d", stats.DroppedEntries)
+	}
 }
 
-func TestRoutingAllTimeoutSubscriptions(t *testing.T) {
+func TestRoutingAllFullSubscriptions(t *testing.T) {
+	defer withListenerBuffer(0)()
+
 	router := NewLogRouter()
 	router.Start()
 	defer router.Stop()
 
 	testDone := make(chan struct{})
+	release := make(chan struct{})
 
 	router.Announce(8888)
 
 	// This test doesn't care about the channels being closed (since we already
-	// tested this above). What's tested is the deletion of timed out
-	// subscriptions when _every_ subscription times out (which lead to a
-	// out-of-bounds panic).
+	// tested this above). What's tested is the deletion of subscriptions when
+	// _every_ subscription's buffer is full (which lead to a out-of-bounds
+	// panic).
 	slowListener := func(ch <-chan LogEntry) {
-		time.Sleep(ListenerTimeout*2 + 50*time.Millisecond)
+		<-release
 		testDone <- struct{}{}
 	}
 
 	router.Subscribe(8888, slowListener)
 	router.Subscribe(8888, slowListener)
 
-	go func() {
-		router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
-	}()
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "two", DeploymentId: 8888}
+	// The router receives the next message once the entries have been routed
+	router.Done <- 9999
 
+	if stats := router.Stats(); stats.Listeners != 0 {
+		t.Errorf("subscriptions with full buffers not deleted. got=%d", stats.Listeners)
+	}
+
+	close(release)
 	<-testDone
 	<-testDone
 }
+
 func TestRoutingBacklogTimeout(t *testing.T) {
+	defer withListenerBuffer(1)()
+
 	router := NewLogRouter()
 	router.Start()
 	defer router.Stop()
@@ -248,33 +306,207 @@ func TestRoutingBacklogTimeout(t *testing.T) {
 	testDone := make(chan struct{})
 
 	router.Announce(8888)
-	// This gets added to the backlog and routed first
+	// These get added to the backlog and routed first. The second one doesn't
+	// fit into the buffer of the slow listener.
 	router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "two", DeploymentId: 8888}
 
 	slowListener := func(ch <-chan LogEntry) {
 		time.Sleep(ListenerTimeout + 100*time.Millisecond)
 
+		<-ch
 		// ch should be closed now since we timed out
 		_, open := <-ch
 		if open {
-			t.Errorf("channel still open after timeout when sending broadcast!")
+			t.Errorf("channel still open after timeout when sending backlog!")
 		}
 		testDone <- struct{}{}
 	}
 
 	goodListener := func(ch <-chan LogEntry) {
+		<-ch
 		<-ch
 		<-ch
 		testDone <- struct{}{}
 	}
 
 	router.Subscribe(8888, slowListener) // times out
-	router.Subscribe(8888, goodListener) // should receive both log entries
+	router.Subscribe(8888, goodListener) // should receive all log entries
 
 	go func() {
-		router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
+		router.Broadcast <- LogEntry{Origin: "example.org", Message: "three", DeploymentId: 8888}
 	}()
 
 	<-testDone
 	<-testDone
 }
+
+func TestSlowListenerDoesNotBlockBroadcast(t *testing.T) {
+	defer withListenerBuffer(2)()
+
+	router := NewLogRouter()
+	router.Start()
+	defer router.Stop()
+
+	release := make(chan struct{})
+	saved := make(chan []string)
+	router.SubscribeAll(func(ch <-chan LogEntry) {
+		// A slow listener, e.g. one saving to the database
+		<-release
+		messages := []string{}
+		for logEntry := range ch {
+			messages = append(messages, logEntry.Message)
+			if len(messages) == 5 {
+				break
+			}
+		}
+		saved <- messages
+	})
+
+	router.Announce(8888)
+
+	// The deployment can broadcast more entries than the listener buffers
+	// while the listener is busy
+	broadcast := make(chan struct{})
+	go func() {
+		for _, m := range []string{"one", "two", "three", "four", "five"} {
+			router.Broadcast <- LogEntry{Origin: "example.org", Message: m, DeploymentId: 8888}
+		}
+		close(broadcast)
+	}()
+
+	select {
+	case <-broadcast:
+	case <-time.After(time.Second):
+		t.Fatalf("broadcasting blocked by slow listener")
+	}
+
+	if stats := router.Stats(); stats.BufferedEntries == 0 {
+		t.Errorf("no buffered entries. got=%+v", stats)
+	}
+
+	// Listeners of all deployments don't miss entries
+	close(release)
+	messages := <-saved
+	if len(messages) != 5 || messages[0] != "one" || messages[4] != "five" {
+		t.Errorf("wrong entries received. got=%v", messages)
+	}
+	if stats := router.Stats(); stats.DroppedEntries != 0 {
+		t.Errorf("entries dropped. got=%d", stats.DroppedEntries)
+	}
+}
+
+func TestClose(t *testing.T) {
+	router := NewLogRouter()
+	router.Start()
+
+	var saved []string
+	router.SubscribeAll(func(ch <-chan LogEntry) {
+		for logEntry := range ch {
+			// A slow listener, e.g. one saving to the database
+			time.Sleep(10 * time.Millisecond)
+			saved = append(saved, logEntry.Message)
+		}
+	})
+
+	router.Announce(8888)
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "two", DeploymentId: 8888}
+	router.Done <- 8888
+
+	err := router.Close(time.Second)
+	if err != nil {
+		t.Fatalf("Close returned error: %s", err)
+	}
+	if len(saved) != 2 || saved[1] != "two" {
+		t.Errorf("wrong log entries saved before Close returned. want=[one two], got=%v", saved)
+	}
+}
+
+func TestCloseWhileRoutingBufferedEntries(t *testing.T) {
+	defer withListenerBuffer(1)()
+
+	router := NewLogRouter()
+	router.Start()
+
+	var saved []string
+	router.SubscribeAll(func(ch <-chan LogEntry) {
+		for logEntry := range ch {
+			// A slow listener, e.g. one saving to the database
+			time.Sleep(time.Millisecond)
+			saved = append(saved, logEntry.Message)
+		}
+	})
+
+	// More entries than the listener buffers are still waiting to be routed
+	// when the router is closed
+	router.Announce(8888)
+	for i := 0; i < 20; i++ {
+		router.Broadcast <- LogEntry{Origin: "example.org", Message: fmt.Sprint(i), DeploymentId: 8888}
+	}
+
+	err := router.Close(time.Second)
+	if err != nil {
+		t.Fatalf("Close returned error: %s", err)
+	}
+	if len(saved) != 20 || saved[19] != "19" {
+		t.Errorf("wrong log entries saved before Close returned. got=%v", saved)
+	}
+}
+
+func TestCloseTimeout(t *testing.T) {
+	router := NewLogRouter()
+	router.Start()
+
+	block := make(chan struct{})
+	defer close(block)
+	router.SubscribeAll(func(ch <-chan LogEntry) {
+		<-block
+	})
+
+	err := router.Close(10 * time.Millisecond)
+	if err != ErrTimeout {
+		t.Errorf("wrong error. want=%v, got=%v", ErrTimeout, err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	defer withListenerBuffer(0)()
+
+	router := NewLogRouter()
+	router.Start()
+	defer router.Stop()
+
+	router.Announce(8888)
+
+	release := make(chan struct{})
+	defer close(release)
+	router.Subscribe(8888, func(ch <-chan LogEntry) {
+		<-release
+		for range ch {
+		}
+	})
+	router.Done <- 9999
+
+	stats := router.Stats()
+	if stats.Listeners != 1 {
+		t.Errorf("wrong number of listeners. want=1, got=%d", stats.Listeners)
+	}
+
+	router.Broadcast <- LogEntry{Origin: "example.org", Message: "one", DeploymentId: 8888}
+	// The router receives the next message once the entry has been routed
+	router.Done <- 9999
+
+	stats = router.Stats()
+	if stats.Listeners != 0 || stats.BacklogEntries != 1 || stats.RoutedEntries != 1 || stats.DroppedEntries != 1 || stats.BufferedEntries != 0 {
+		t.Errorf("wrong stats after full buffer. got=%+v", stats)
+	}
+
+	router.Done <- 8888
+	router.Done <- 9999
+
+	stats = router.Stats()
+	if stats.BacklogEntries != 0 || stats.RoutedEntries != 1 {
+		t.Errorf("wrong stats after deployment is done. got=%+v", stats)
+	}
+}
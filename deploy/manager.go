@@ -2,6 +2,7 @@ package deploy
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 	"golang.org/x/crypto/ssh"
@@ -16,21 +17,48 @@ type Manager struct {
 	logger *DeploymentLogger
 
 	killChan chan struct{}
+	// Receives the decision of a user on continuing a pause stage
+	approvalChan chan Approval
+	// Receives once config.Timeout is exceeded. nil if there's no timeout.
+	timeout <-chan time.Time
+	// When config.Timeout is exceeded. Zero if there's no timeout.
+	deadline time.Time
+	// Set when the deployment has been stopped via killChan or rejected in a
+	// pause stage
+	killed bool
+	// Stages that have been executed successfully so far
+	completedStages []models.DeploymentStage
+	// The user who approved the deployment in the SECOND_APPROVAL_STAGE
+	approvedBy string
 }
 
-func NewManager(c *models.DeploymentConfig, r *LogRouter, kc chan struct{}) (*Manager, error) {
-	ssh, err := newSSHClientConfig(c.User, c.SshKey)
+// Approval is the decision of a user on continuing a deployment that waits in
+// a pause stage.
+type Approval struct {
+	User     string
+	Rejected bool
+}
+
+func NewManager(c *models.DeploymentConfig, r *LogRouter, kc chan struct{}, ac chan Approval) (*Manager, error) {
+	ssh, err := newSSHClientConfig(c.User, c.SshKey, c.SshKeyPassphrase)
+	if err != nil {
+		return nil, err
+	}
+	ssh.HostKeyCallback, err = newHostKeyCallback(c.Hosts, c.KnownHosts)
 	if err != nil {
 		return nil, err
 	}
 
 	logger := NewDeploymentLogger(c.Deployment, r)
+	logger.redact = c.Redact
+	logger.redactOverlap = c.RedactOverlap
 
 	m := &Manager{
-		config:    c,
-		sshConfig: ssh,
-		logger:    logger,
-		killChan:  kc,
+		config:       c,
+		sshConfig:    ssh,
+		logger:       logger,
+		killChan:     kc,
+		approvalChan: ac,
 	}
 
 	err = m.assembleWorkers()
@@ -51,23 +79,69 @@ func (m *Manager) AnnounceStart() {
 func (m *Manager) Start() error {
 	defer m.logger.Flush()
 
-	err := m.connectWorkers()
-	if err != nil {
+	if m.config.Timeout > 0 {
+		m.timeout = time.After(m.config.Timeout)
+		m.deadline = time.Now().Add(m.config.Timeout)
+	}
+
+	err := m.waitForSecondApproval()
+	if err == nil {
+		// Connect to the hosts before anything runs, so an unreachable host
+		// fails the deployment right away instead of in the middle of a
+		// stage. The connections aren't held while waiting for the approval.
+		err = m.connectWorkers()
+	}
+	if err == nil {
+		err = m.runPreDeploymentHooks()
+		if err == nil {
+			err = m.executeStages()
+		}
 		m.disconnectWorkers()
+	}
+
+	m.runPostDeploymentHooks(err)
+
+	if err != nil {
 		m.logger.LogDeploymentFail(err)
 		return err
 	}
-	defer m.disconnectWorkers()
 
+	m.logger.LogDeploymentSuccess()
+	return nil
+}
+
+// Killed returns true if the deployment has been stopped by a kill signal or
+// rejected in a pause stage.
+func (m *Manager) Killed() bool {
+	return m.killed
+}
+
+// ApprovedBy returns the name of the user who approved the deployment to
+// start if it required a second approval, empty otherwise.
+func (m *Manager) ApprovedBy() string {
+	return m.approvedBy
+}
+
+// StageCompleted returns true if the stage has been executed successfully,
+// even if the deployment failed in a later stage.
+func (m *Manager) StageCompleted(stage models.DeploymentStage) bool {
+	for _, s := range m.completedStages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Manager) executeStages() error {
 	for _, stage := range m.config.Stages {
 		err := m.executeStage(stage)
 		if err != nil {
-			m.logger.LogDeploymentFail(err)
 			return err
 		}
+		m.completedStages = append(m.completedStages, stage)
 	}
 
-	m.logger.LogDeploymentSuccess()
 	return nil
 }
 
@@ -86,14 +160,45 @@ func (m *Manager) assembleWorkers() error {
 	return nil
 }
 
+type connectResult struct {
+	worker *Worker
+	err    error
+}
+
+// connectWorkers connects to all hosts at the same time. It returns as soon
+// as connecting to one of them failed, without waiting for the others: they
+// are disconnected once they're done connecting.
 func (m *Manager) connectWorkers() error {
+	start := time.Now()
+
+	results := make(chan connectResult, len(m.workers))
 	for _, w := range m.workers {
-		err := w.Connect()
-		if err != nil {
-			return err
+		go func(w *Worker) {
+			results <- connectResult{w, w.Connect()}
+		}(w)
+	}
+
+	for i := range m.workers {
+		result := <-results
+		if result.err == nil {
+			continue
 		}
+
+		m.logger.LogConnectionFail(result.worker.host.Name, result.err)
+
+		// The log of the deployment is closed by then, so the other failures
+		// aren't logged
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				<-results
+			}
+			m.disconnectWorkers()
+		}(len(m.workers) - i - 1)
+
+		return result.err
 	}
 
+	m.logger.serverLog().Info("connected to hosts", "hosts", len(m.workers), "duration", time.Since(start))
 	return nil
 }
 
@@ -104,6 +209,10 @@ func (m *Manager) disconnectWorkers() {
 }
 
 func (m *Manager) executeStage(stage models.DeploymentStage) error {
+	if m.config.IsPauseStage(stage) {
+		return m.executePauseStage(stage)
+	}
+
 	stageName := string(stage)
 
 	m.logger.LogStageStart(stage)
@@ -139,36 +248,206 @@ func (m *Manager) executeStage(stage models.DeploymentStage) error {
 	return nil
 }
 
+// executePauseStage blocks until a user approves to continue the deployment.
+// The deployment fails if it's rejected, killed or times out in the meantime.
+func (m *Manager) executePauseStage(stage models.DeploymentStage) error {
+	m.logger.LogStageStart(stage)
+	m.logger.LogApprovalPending(stage)
+
+	var pauseTimeout <-chan time.Time
+	if m.config.PauseTimeout > 0 {
+		pauseTimeout = time.After(m.config.PauseTimeout)
+	}
+
+	var err error
+
+	select {
+	case approval := <-m.approvalChan:
+		if approval.Rejected {
+			m.killed = true
+			err = fmt.Errorf("Stage %s rejected by %s", stage, approval.User)
+		} else {
+			m.logger.LogApprovalReceived(stage, approval.User)
+		}
+	case <-pauseTimeout:
+		if m.config.PauseTimeoutContinue {
+			m.logger.LogApprovalReceived(stage, fmt.Sprintf("timeout after %s", m.config.PauseTimeout))
+		} else {
+			err = fmt.Errorf("No approval for stage %s received within %s", stage, m.config.PauseTimeout)
+		}
+	case <-m.killChan:
+		m.killed = true
+		m.logger.LogKillReceived()
+		err = fmt.Errorf("Received kill signal")
+	case <-m.timeout:
+		m.logger.LogTimeout(m.config.Timeout)
+		err = fmt.Errorf("Deployment timed out after %s", m.config.Timeout)
+	}
+
+	if err != nil {
+		m.logger.LogStageResult(fmtStageFailure(stage, ExecutionResult{origin: "applikatoni", err: err}))
+		m.logger.LogStageFail(stage)
+		return err
+	}
+
+	m.logger.LogStageSuccess(stage)
+	return nil
+}
+
+// waitForSecondApproval blocks until a second user approves the deployment
+// to start, if it requires it. Unlike in pause stages a timeout never
+// continues the deployment.
+func (m *Manager) waitForSecondApproval() error {
+	if !m.config.RequireSecondApproval {
+		return nil
+	}
+
+	stage := models.SECOND_APPROVAL_STAGE
+	m.logger.LogApprovalPending(stage)
+
+	var pauseTimeout <-chan time.Time
+	if m.config.PauseTimeout > 0 {
+		pauseTimeout = time.After(m.config.PauseTimeout)
+	}
+
+	select {
+	case approval := <-m.approvalChan:
+		if approval.Rejected {
+			m.killed = true
+			return fmt.Errorf("Deployment rejected by %s", approval.User)
+		}
+		m.approvedBy = approval.User
+		m.logger.LogApprovalReceived(stage, approval.User)
+		return nil
+	case <-pauseTimeout:
+		return fmt.Errorf("No second approval received within %s", m.config.PauseTimeout)
+	case <-m.killChan:
+		m.killed = true
+		m.logger.LogKillReceived()
+		return fmt.Errorf("Received kill signal")
+	case <-m.timeout:
+		m.logger.LogTimeout(m.config.Timeout)
+		return fmt.Errorf("Deployment timed out after %s", m.config.Timeout)
+	}
+}
+
 func (m *Manager) executeWorkersStage(stage models.DeploymentStage) []ExecutionResult {
 	results := []ExecutionResult{}
-	ch := make(chan ExecutionResult)
+	// Every worker sends exactly one result, executed or not
+	ch := make(chan ExecutionResult, len(m.workers))
+
+	// Closed when the deployment is killed or times out, so serial groups
+	// don't start the stage on their remaining hosts. stopErr is their result.
+	stop := make(chan struct{})
+	var stopErr error
+	halt := func(err error) {
+		select {
+		case <-stop:
+			// A kill may be followed by a timeout
+		default:
+			stopErr = err
+			close(stop)
+		}
+	}
 
-	exec := func(w *Worker) {
-		ch <- w.Execute(stage)
+	skip := func(workers []*Worker, err error) {
+		for _, w := range workers {
+			ch <- ExecutionResult{origin: w.host.Name, err: err}
+		}
 	}
 
-	for _, w := range m.workers {
-		go exec(w)
+	exec := func(workers []*Worker) {
+		for i, w := range workers {
+			select {
+			case <-stop:
+				skip(workers[i:], stopErr)
+				return
+			default:
+			}
+
+			result := w.Execute(stage)
+			ch <- result
+
+			if result.err != nil {
+				// Don't continue a serial stage on the remaining hosts
+				err := fmt.Errorf("not executed, since it failed on %s", w.host.Name)
+				select {
+				case <-stop:
+					// It failed because the deployment was stopped
+					err = stopErr
+				default:
+				}
+				skip(workers[i+1:], err)
+				return
+			}
+		}
 	}
 
+	for _, workers := range m.groupWorkers(stage) {
+		go exec(workers)
+	}
+
+	kill, timeout := m.killChan, m.timeout
 	for i := 0; i < len(m.workers); i++ {
 		select {
 		case result := <-ch:
 			results = append(results, result)
-		case <-m.killChan:
-			// Received kill first. Log this, add result, wait for worker
+		case <-kill:
+			// The running commands finish, but no host starts the stage
+			// anymore
+			m.killed = true
+			halt(fmt.Errorf("not executed, since the deployment was killed"))
 			m.logger.LogKillReceived()
 			errMsg := fmt.Errorf("Received kill signal")
 			results = append(results, ExecutionResult{origin: "applikatoni", err: errMsg})
 
-			result := <-ch
-			results = append(results, result)
+			kill = nil
+			i--
+		case <-timeout:
+			m.logger.LogTimeout(m.config.Timeout)
+			errMsg := fmt.Errorf("Deployment timed out after %s", m.config.Timeout)
+			results = append(results, ExecutionResult{origin: "applikatoni", err: errMsg})
+
+			// Closing the connections makes the running commands fail, so the
+			// remaining results arrive right away. They have to be received
+			// before the log of the deployment is closed.
+			halt(fmt.Errorf("not executed, since the deployment timed out"))
+			for _, w := range m.workers {
+				w.Abort()
+			}
+			timeout = nil
+			i--
 		}
 	}
 
 	return results
 }
 
+// groupWorkers groups the workers by how they execute the stage: all workers
+// of a role with the stage in its serial_stages end up in the same group, all
+// other workers get a group of their own. Groups are executed in parallel.
+func (m *Manager) groupWorkers(stage models.DeploymentStage) [][]*Worker {
+	groups := [][]*Worker{}
+	serialGroups := make(map[string]int)
+
+	for _, w := range m.workers {
+		role, serial := w.serialStages[stage]
+		if !serial {
+			groups = append(groups, []*Worker{w})
+			continue
+		}
+
+		if i, ok := serialGroups[role]; ok {
+			groups[i] = append(groups[i], w)
+		} else {
+			serialGroups[role] = len(groups)
+			groups = append(groups, []*Worker{w})
+		}
+	}
+
+	return groups
+}
+
 func (m *Manager) newWorker(h *models.Host, scriptOptions map[string]string) (*Worker, error) {
 	roles, err := findHostRoles(h, m.config.Roles)
 	if err != nil {
@@ -177,7 +456,7 @@ func (m *Manager) newWorker(h *models.Host, scriptOptions map[string]string) (*W
 
 	rolesScripts := []map[models.DeploymentStage]string{}
 	for _, r := range roles {
-		s, err := r.RenderScripts(scriptOptions)
+		s, err := r.RenderScripts(scriptOptions, m.config.TemplateFuncs())
 		if err != nil {
 			return nil, err
 		}
@@ -185,25 +464,62 @@ func (m *Manager) newWorker(h *models.Host, scriptOptions map[string]string) (*W
 	}
 
 	mergedScripts := make(map[models.DeploymentStage]string)
-	for _, s := range rolesScripts {
+	sudoStages := make(map[models.DeploymentStage]bool)
+	serialStages := make(map[models.DeploymentStage]string)
+	for i, s := range rolesScripts {
 		for stage, scriptContent := range s {
 			if _, alreadyExists := mergedScripts[stage]; alreadyExists {
 				err := fmt.Errorf("merging host scripts failed. script for %s is duplicate", stage)
 				return nil, err
 			}
 			mergedScripts[stage] = scriptContent
+			if roles[i].IsSudoStage(stage) {
+				sudoStages[stage] = true
+			}
+			if roles[i].IsSerialStage(stage) {
+				serialStages[stage] = roles[i].Name
+			}
 		}
 	}
 
+	sshConfig, err := m.hostSSHConfig(h)
+	if err != nil {
+		return nil, err
+	}
+
 	w := &Worker{
-		host:      h,
-		scripts:   mergedScripts,
-		sshConfig: m.sshConfig,
-		logger:    m.logger,
+		host:         h,
+		scripts:      mergedScripts,
+		sshConfig:    sshConfig,
+		logger:       m.logger,
+		sudoStages:   sudoStages,
+		sudoPassword: m.config.SudoPassword,
+		serialStages: serialStages,
 	}
 	return w, nil
 }
 
+// hostSSHConfig returns the SSH config of the target with the user and key
+// replaced by the ones of the host, if it has its own.
+func (m *Manager) hostSSHConfig(h *models.Host) (*ssh.ClientConfig, error) {
+	if h.DeploymentUser == "" && h.DeploymentSshKey == "" {
+		return m.sshConfig, nil
+	}
+
+	config := *m.sshConfig
+	if h.DeploymentUser != "" {
+		config.User = h.DeploymentUser
+	}
+	if h.DeploymentSshKey != "" {
+		signer, err := parsePrivateKey([]byte(h.DeploymentSshKey), h.SshKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("parsing the SSH key of host %s failed: %s", h.Name, err)
+		}
+		config.Auth = []ssh.AuthMethod{ssh.PublicKeys(signer)}
+	}
+	return &config, nil
+}
+
 func findHostRoles(h *models.Host, roles []*models.Role) ([]*models.Role, error) {
 	found := []*models.Role{}
 
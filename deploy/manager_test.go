@@ -1,9 +1,12 @@
 package deploy
 
 import (
+	"net"
 	"testing"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
 )
 
 var preDeployment = models.DeploymentStage("PRE_DEPLOYMENT")
@@ -191,7 +194,7 @@ var newWorkerTests = []struct {
 
 func TestNewWorker(t *testing.T) {
 	testLogger := &DeploymentLogger{}
-	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"))
+	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"), "")
 	testManager := &Manager{logger: testLogger, sshConfig: testSshConfig}
 
 	for _, tt := range newWorkerTests {
@@ -223,9 +226,165 @@ func TestNewWorker(t *testing.T) {
 	}
 }
 
+func TestNewWorkerHostSSHConfig(t *testing.T) {
+	testSshConfig, err := newSSHClientConfig("deploy", generatePrivateKey(t, ""), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConfig := &models.DeploymentConfig{Roles: []*models.Role{{Name: "web"}}}
+	testManager := &Manager{logger: &DeploymentLogger{}, sshConfig: testSshConfig, config: testConfig}
+
+	w, err := testManager.newWorker(&models.Host{Name: "web.applikatoni.com:22", Roles: []string{"web"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.sshConfig != testSshConfig {
+		t.Errorf("host without overrides has its own sshConfig")
+	}
+
+	legacy := &models.Host{
+		Name:             "legacy.applikatoni.com:22",
+		Roles:            []string{"web"},
+		DeploymentUser:   "root",
+		DeploymentSshKey: string(generatePrivateKey(t, "s3cret")),
+		SshKeyPassphrase: "s3cret",
+	}
+	w, err = testManager.newWorker(legacy, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.sshConfig.User != "root" {
+		t.Errorf("wrong user. want=%s, got=%s", "root", w.sshConfig.User)
+	}
+	if len(w.sshConfig.Auth) != 1 || &w.sshConfig.Auth[0] == &testSshConfig.Auth[0] {
+		t.Errorf("key of the host not used")
+	}
+	if testSshConfig.User != "deploy" {
+		t.Errorf("sshConfig of the target changed. got user=%s", testSshConfig.User)
+	}
+
+	legacy.SshKeyPassphrase = "wrong"
+	if _, err := testManager.newWorker(legacy, nil); err == nil {
+		t.Errorf("expected error for wrong passphrase of the host key")
+	}
+}
+
+func TestNewWorkerSudoStages(t *testing.T) {
+	testLogger := &DeploymentLogger{}
+	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"), "")
+	testManager := &Manager{logger: testLogger, sshConfig: testSshConfig}
+
+	roles := []*models.Role{
+		&models.Role{
+			Name: "web",
+			ScriptTemplates: map[models.DeploymentStage]string{
+				preDeployment: "/etc/init.d/unicorn stop",
+			},
+			SudoStages: []models.DeploymentStage{preDeployment},
+		},
+		&models.Role{
+			Name: "migrator",
+			ScriptTemplates: map[models.DeploymentStage]string{
+				migrate: "migrate",
+			},
+		},
+	}
+
+	host := &models.Host{
+		Name:  "webcluster.applikatoni.com",
+		Roles: []string{"web", "migrator"},
+	}
+
+	testManager.config = &models.DeploymentConfig{Roles: roles, SudoPassword: "secret"}
+
+	w, err := testManager.newWorker(host, map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !w.sudoStages[preDeployment] {
+		t.Errorf("expected stage %s to be run with sudo", preDeployment)
+	}
+	if w.sudoStages[migrate] {
+		t.Errorf("expected stage %s to not be run with sudo", migrate)
+	}
+	if w.sudoPassword != "secret" {
+		t.Errorf("worker has wrong sudo password. want=%q, got=%q", "secret", w.sudoPassword)
+	}
+}
+
+func TestGroupWorkers(t *testing.T) {
+	testLogger := &DeploymentLogger{}
+	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"), "")
+	testManager := &Manager{logger: testLogger, sshConfig: testSshConfig}
+
+	roles := []*models.Role{
+		&models.Role{
+			Name: "web",
+			ScriptTemplates: map[models.DeploymentStage]string{
+				preDeployment: "restart",
+			},
+		},
+		&models.Role{
+			Name: "migrator",
+			ScriptTemplates: map[models.DeploymentStage]string{
+				migrate: "migrate",
+			},
+			SerialStages: []models.DeploymentStage{migrate},
+		},
+	}
+	hosts := []*models.Host{
+		{Name: "web1.applikatoni.com", Roles: []string{"web", "migrator"}},
+		{Name: "web2.applikatoni.com", Roles: []string{"web", "migrator"}},
+		{Name: "web3.applikatoni.com", Roles: []string{"web"}},
+	}
+
+	testManager.config = &models.DeploymentConfig{Roles: roles}
+	for _, h := range hosts {
+		w, err := testManager.newWorker(h, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testManager.workers = append(testManager.workers, w)
+	}
+
+	tests := []struct {
+		stage          models.DeploymentStage
+		expectedGroups [][]string
+	}{
+		{
+			preDeployment,
+			[][]string{{"web1.applikatoni.com"}, {"web2.applikatoni.com"}, {"web3.applikatoni.com"}},
+		},
+		{
+			migrate,
+			[][]string{{"web1.applikatoni.com", "web2.applikatoni.com"}, {"web3.applikatoni.com"}},
+		},
+	}
+
+	for _, tt := range tests {
+		groups := testManager.groupWorkers(tt.stage)
+		if len(groups) != len(tt.expectedGroups) {
+			t.Errorf("wrong number of groups for %s. want=%d, got=%d", tt.stage, len(tt.expectedGroups), len(groups))
+			continue
+		}
+
+		for i, group := range groups {
+			if len(group) != len(tt.expectedGroups[i]) {
+				t.Errorf("wrong group size for %s. want=%d, got=%d", tt.stage, len(tt.expectedGroups[i]), len(group))
+				continue
+			}
+			for j, w := range group {
+				if w.host.Name != tt.expectedGroups[i][j] {
+					t.Errorf("wrong worker in group for %s. want=%s, got=%s", tt.stage, tt.expectedGroups[i][j], w.host.Name)
+				}
+			}
+		}
+	}
+}
+
 func TestNewWorkerError(t *testing.T) {
 	testLogger := &DeploymentLogger{}
-	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"))
+	testSshConfig, _ := newSSHClientConfig("testuser", []byte("testsshkey"), "")
 	testManager := &Manager{logger: testLogger, sshConfig: testSshConfig}
 
 	// Two roles that define scripts for the same stage
@@ -264,3 +423,295 @@ func TestNewWorkerError(t *testing.T) {
 		t.Errorf("newWorker expected to not return a new worker, but did")
 	}
 }
+
+var approval = models.DeploymentStage("APPROVAL")
+
+func runPauseStage(config *models.DeploymentConfig, decision *Approval) ([]LogEntry, *Manager, error) {
+	config.PauseStages = []models.DeploymentStage{approval}
+
+	var err error
+	m := &Manager{config: config, approvalChan: make(chan Approval)}
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		m.logger = logger
+		if decision != nil {
+			go func() { m.approvalChan <- *decision }()
+		}
+		err = m.executeStage(approval)
+	})
+
+	return entries, m, err
+}
+
+func TestPauseStageApproved(t *testing.T) {
+	entries, m, err := runPauseStage(&models.DeploymentConfig{}, &Approval{User: "mrnugget"})
+	if err != nil {
+		t.Fatalf("pause stage failed. err=%s", err)
+	}
+	if m.Killed() {
+		t.Errorf("approved deployment marked as killed")
+	}
+
+	expected := []LogEntryType{STAGE_START, APPROVAL_PENDING, APPROVAL_RECEIVED, STAGE_SUCCESS}
+	if len(entries) != len(expected) {
+		t.Fatalf("wrong number of log entries. want=%d, got=%d", len(expected), len(entries))
+	}
+	for i, entryType := range expected {
+		if entries[i].EntryType != entryType {
+			t.Errorf("wrong entry type. want=%s, got=%s", entryType, entries[i].EntryType)
+		}
+	}
+	if entries[2].Message != "APPROVAL approved by mrnugget" {
+		t.Errorf("wrong approval message. got=%q", entries[2].Message)
+	}
+}
+
+func TestPauseStageRejected(t *testing.T) {
+	entries, m, err := runPauseStage(&models.DeploymentConfig{}, &Approval{User: "mrnugget", Rejected: true})
+	if err == nil {
+		t.Fatalf("expected rejected pause stage to fail")
+	}
+	if !m.Killed() {
+		t.Errorf("rejected deployment not marked as killed")
+	}
+
+	expected := []LogEntryType{STAGE_START, APPROVAL_PENDING, STAGE_RESULT, STAGE_FAIL}
+	if len(entries) != len(expected) {
+		t.Fatalf("wrong number of log entries. want=%d, got=%d", len(expected), len(entries))
+	}
+	for i, entryType := range expected {
+		if entries[i].EntryType != entryType {
+			t.Errorf("wrong entry type. want=%s, got=%s", entryType, entries[i].EntryType)
+		}
+	}
+}
+
+func TestPauseStageTimeout(t *testing.T) {
+	config := &models.DeploymentConfig{PauseTimeout: 10 * time.Millisecond}
+	entries, _, err := runPauseStage(config, nil)
+	if err == nil {
+		t.Fatalf("expected pause stage to fail after timeout")
+	}
+	if last := entries[len(entries)-1]; last.EntryType != STAGE_FAIL {
+		t.Errorf("wrong entry type. want=%s, got=%s", STAGE_FAIL, last.EntryType)
+	}
+
+	config = &models.DeploymentConfig{PauseTimeout: 10 * time.Millisecond, PauseTimeoutContinue: true}
+	entries, _, err = runPauseStage(config, nil)
+	if err != nil {
+		t.Fatalf("expected pause stage to continue after timeout. err=%s", err)
+	}
+	if last := entries[len(entries)-1]; last.EntryType != STAGE_SUCCESS {
+		t.Errorf("wrong entry type. want=%s, got=%s", STAGE_SUCCESS, last.EntryType)
+	}
+}
+
+func waitForSecondApproval(config *models.DeploymentConfig, decision *Approval) ([]LogEntry, *Manager, error) {
+	var err error
+	m := &Manager{config: config, approvalChan: make(chan Approval)}
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		m.logger = logger
+		if decision != nil {
+			go func() { m.approvalChan <- *decision }()
+		}
+		err = m.waitForSecondApproval()
+	})
+
+	return entries, m, err
+}
+
+func TestSecondApproval(t *testing.T) {
+	config := &models.DeploymentConfig{RequireSecondApproval: true}
+	entries, m, err := waitForSecondApproval(config, &Approval{User: "fabrik42"})
+	if err != nil {
+		t.Fatalf("approved deployment failed. err=%s", err)
+	}
+	if m.ApprovedBy() != "fabrik42" {
+		t.Errorf("wrong approver. want=%s, got=%s", "fabrik42", m.ApprovedBy())
+	}
+	if len(entries) != 2 || entries[0].EntryType != APPROVAL_PENDING || entries[1].Message != "SECOND_APPROVAL approved by fabrik42" {
+		t.Errorf("wrong log entries. got=%v", entries)
+	}
+
+	_, m, err = waitForSecondApproval(config, &Approval{User: "fabrik42", Rejected: true})
+	if err == nil || !m.Killed() {
+		t.Errorf("rejected deployment not killed. err=%v", err)
+	}
+
+	// A timeout never continues the deployment
+	config = &models.DeploymentConfig{RequireSecondApproval: true, PauseTimeout: 10 * time.Millisecond, PauseTimeoutContinue: true}
+	if _, _, err = waitForSecondApproval(config, nil); err == nil {
+		t.Errorf("deployment continued without a second approval")
+	}
+
+	entries, _, err = waitForSecondApproval(&models.DeploymentConfig{}, nil)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("deployment without require_second_approval waited. err=%v, entries=%v", err, entries)
+	}
+}
+
+func TestConnectWorkersFailsFast(t *testing.T) {
+	// Accepts connections but never answers the SSH handshake
+	hanging, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hanging.Close()
+
+	// Refuses connections right away
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+
+	router := NewLogRouter()
+	logger := NewDeploymentLogger(deployment, router)
+	logger.BroadcastLogs()
+
+	m := &Manager{logger: logger}
+	for _, addr := range []string{hanging.Addr().String(), closed.Addr().String()} {
+		m.workers = append(m.workers, &Worker{
+			host:      &models.Host{Name: addr},
+			sshConfig: &ssh.ClientConfig{User: "deploy", HostKeyCallback: ssh.InsecureIgnoreHostKey()},
+			logger:    logger,
+		})
+	}
+
+	done := make(chan error)
+	go func() { done <- m.connectWorkers() }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("connecting to a closed port succeeded")
+		}
+	case <-time.After(sshConnectTimeout / 2):
+		t.Fatalf("connecting waited for the hanging host")
+	}
+
+	entry := <-router.Broadcast
+	if entry.EntryType != COMMAND_FAIL || entry.Origin != closed.Addr().String() {
+		t.Errorf("connection failure not logged. got=%+v", entry)
+	}
+}
+
+func TestExecuteWorkersStageTimeout(t *testing.T) {
+	hanging := newFakeSSHServer(t)
+	defer hanging.listener.Close()
+	hanging.hangOn("./migrate")
+
+	next := newFakeSSHServer(t)
+	defer next.listener.Close()
+
+	var results []ExecutionResult
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		m := &Manager{
+			config:  &models.DeploymentConfig{Timeout: 50 * time.Millisecond},
+			logger:  logger,
+			timeout: time.After(50 * time.Millisecond),
+		}
+		// A serial stage, the second host would run after the first
+		for _, s := range []*fakeSSHServer{hanging, next} {
+			w := newFakeSSHWorker(s)
+			w.logger = logger
+			w.serialStages = map[models.DeploymentStage]string{migrate: "migrator"}
+			m.workers = append(m.workers, w)
+		}
+
+		// The log is closed right after, like at the end of a deployment
+		results = m.executeWorkersStage(migrate)
+	})
+
+	if len(results) != 3 {
+		t.Fatalf("wrong number of results. want=%d, got=%+v", 3, results)
+	}
+	for _, r := range results {
+		if r.err == nil {
+			t.Errorf("result of %s has no error", r.origin)
+		}
+	}
+	if results[0].origin != "applikatoni" {
+		t.Errorf("timeout not reported first. got=%+v", results[0])
+	}
+	skipped := results[2]
+	if skipped.origin != next.listener.Addr().String() || skipped.err.Error() != "not executed, since the deployment timed out" {
+		t.Errorf("wrong result of the next host. got=%+v", skipped)
+	}
+	if got := next.executed(); len(got) != 0 {
+		t.Errorf("next host of the serial stage executed %v after the timeout", got)
+	}
+
+	failed := false
+	for _, e := range entries {
+		if e.EntryType == COMMAND_FAIL && e.Origin == hanging.listener.Addr().String() {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Errorf("failure of the aborted command not logged. got=%+v", entries)
+	}
+}
+
+func TestExecuteWorkersStageKill(t *testing.T) {
+	running := newFakeSSHServer(t)
+	defer running.listener.Close()
+	held, release := running.holdOn("./migrate")
+
+	next := newFakeSSHServer(t)
+	defer next.listener.Close()
+
+	router := NewLogRouter()
+	logger := NewDeploymentLogger(deployment, router)
+	logger.BroadcastLogs()
+
+	m := &Manager{
+		config:   &models.DeploymentConfig{},
+		logger:   logger,
+		killChan: make(chan struct{}),
+	}
+	// A serial stage, the second host would run after the first
+	for _, s := range []*fakeSSHServer{running, next} {
+		w := newFakeSSHWorker(s)
+		w.serialStages = map[models.DeploymentStage]string{migrate: "migrator"}
+		m.workers = append(m.workers, w)
+	}
+
+	go func() {
+		<-held
+		m.killChan <- struct{}{}
+		// The running command finishes after the kill has been received
+		for {
+			select {
+			case entry := <-router.Broadcast:
+				if entry.EntryType == KILL_RECEIVED {
+					close(release)
+				}
+			case <-router.Done:
+				return
+			}
+		}
+	}()
+
+	results := m.executeWorkersStage(migrate)
+	logger.Flush()
+
+	if len(results) != 3 {
+		t.Fatalf("wrong number of results. want=%d, got=%+v", 3, results)
+	}
+	if !m.killed {
+		t.Errorf("deployment not marked as killed")
+	}
+	if results[0].origin != "applikatoni" || results[0].err == nil {
+		t.Errorf("kill not reported first. got=%+v", results[0])
+	}
+	if results[1].origin != running.listener.Addr().String() || results[1].err != nil {
+		t.Errorf("running command not finished. got=%+v", results[1])
+	}
+	skipped := results[2]
+	if skipped.origin != next.listener.Addr().String() || skipped.err == nil || skipped.err.Error() != "not executed, since the deployment was killed" {
+		t.Errorf("wrong result of the next host. got=%+v", skipped)
+	}
+	if got := next.executed(); len(got) != 0 {
+		t.Errorf("next host of the serial stage executed %v after the kill", got)
+	}
+}
@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"bytes"
+	"io"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	// Output is logged in chunks of at most this many bytes, so very long
+	// lines (progress bars, minified assets, ...) don't stall the log
+	MaxOutputChunkSize = 4096
+	// An unfinished line is logged once no newline followed it for this long,
+	// so progress of commands that print without newlines is visible
+	OutputFlushInterval = 500 * time.Millisecond
+)
+
+// completeRunes returns the length of b without an incomplete UTF-8 encoded
+// rune at its end, so chunks of output don't split runes.
+func completeRunes(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
+
+// logOutput reads the output of a command from r and logs it as LogEntries of
+// the given type. Complete lines are logged as soon as they're read.
+func logOutput(logger *DeploymentLogger, origin string, entryType LogEntryType, r io.Reader) {
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		for {
+			buf := make([]byte, MaxOutputChunkSize)
+			n, err := r.Read(buf)
+			if n > 0 {
+				chunks <- buf[:n]
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	log := func(output []byte) {
+		if len(output) == 0 {
+			return
+		}
+
+		logger.Log(LogEntry{
+			Origin:    origin,
+			EntryType: entryType,
+			Message:   string(output),
+			Timestamp: time.Now(),
+		})
+	}
+
+	var pending []byte
+	var pendingSince time.Time
+
+	// With redaction the end of an unfinished line is held back until more
+	// output follows, so secrets split by chunks or flushes are masked as a
+	// whole
+	overlap := func() int {
+		if logger.redact == nil || logger.redactOverlap == nil {
+			return 0
+		}
+		return logger.redactOverlap()
+	}
+	redactPending := func() {
+		if logger.redact != nil {
+			pending = []byte(logger.redact(string(pending)))
+		}
+	}
+	// logPending logs the pending output but its last keep bytes
+	logPending := func(keep int) {
+		redactPending()
+		for len(pending) > keep {
+			n := len(pending) - keep
+			if n > MaxOutputChunkSize {
+				n = MaxOutputChunkSize
+			}
+			// The rest of a rune cut off by the read is logged with the
+			// next output
+			n = completeRunes(pending[:n])
+			if n == 0 {
+				return
+			}
+			log(pending[:n])
+			pending = pending[n:]
+		}
+	}
+
+	ticker := time.NewTicker(OutputFlushInterval / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				logPending(0)
+				log(pending)
+				return
+			}
+
+			if len(pending) == 0 {
+				pendingSince = time.Now()
+			}
+			pending = append(pending, chunk...)
+
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i == -1 {
+					break
+				}
+				log(pending[:i+1])
+				pending = pending[i+1:]
+				pendingSince = time.Now()
+			}
+
+			keep := overlap()
+			if len(pending) >= MaxOutputChunkSize+keep {
+				redactPending()
+			}
+			for len(pending) >= MaxOutputChunkSize+keep {
+				n := completeRunes(pending[:MaxOutputChunkSize])
+				if n == 0 {
+					n = MaxOutputChunkSize
+				}
+				log(pending[:n])
+				pending = pending[n:]
+				pendingSince = time.Now()
+			}
+		case <-ticker.C:
+			keep := overlap()
+			if len(pending) > keep && time.Since(pendingSince) >= OutputFlushInterval {
+				logPending(keep)
+			}
+		}
+	}
+}
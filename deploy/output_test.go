@@ -0,0 +1,158 @@
+package deploy
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func outputMessages(entries []LogEntry) []string {
+	messages := []string{}
+	for _, entry := range entries {
+		messages = append(messages, entry.Message)
+	}
+	return messages
+}
+
+func TestLogOutputLines(t *testing.T) {
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		r := strings.NewReader("first line\nsecond line\nno newline")
+		logOutput(logger, "example.org", COMMAND_STDOUT_OUTPUT, r)
+	})
+
+	expected := []string{"first line\n", "second line\n", "no newline"}
+	got := outputMessages(entries)
+
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong output logged. want=%q, got=%q", expected, got)
+	}
+
+	for _, entry := range entries {
+		if entry.EntryType != COMMAND_STDOUT_OUTPUT {
+			t.Errorf("wrong entry type. want=%s, got=%s", COMMAND_STDOUT_OUTPUT, entry.EntryType)
+		}
+		if entry.Origin != "example.org" {
+			t.Errorf("wrong origin. want=%s, got=%s", "example.org", entry.Origin)
+		}
+	}
+}
+
+func TestLogOutputLongLines(t *testing.T) {
+	long := strings.Repeat("x", MaxOutputChunkSize*2+10)
+
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		logOutput(logger, "example.org", COMMAND_STDOUT_OUTPUT, strings.NewReader(long))
+	})
+
+	got := outputMessages(entries)
+	if len(got) != 3 {
+		t.Fatalf("long line not split into chunks. got %d entries", len(got))
+	}
+	if len(got[0]) != MaxOutputChunkSize || len(got[2]) != 10 {
+		t.Errorf("wrong chunk sizes. got=%d, %d, %d", len(got[0]), len(got[1]), len(got[2]))
+	}
+	if strings.Join(got, "") != long {
+		t.Errorf("chunks do not add up to the output")
+	}
+}
+
+func TestLogOutputFlushesUnfinishedLines(t *testing.T) {
+	pr, pw := io.Pipe()
+	flushed := make(chan []LogEntry)
+
+	go func() {
+		flushed <- collectLogEntries(func(logger *DeploymentLogger) {
+			logOutput(logger, "example.org", COMMAND_STDOUT_OUTPUT, pr)
+		})
+	}()
+
+	pw.Write([]byte("compiling..."))
+	time.Sleep(OutputFlushInterval * 2)
+	pw.Write([]byte("done\n"))
+	pw.Close()
+
+	expected := []string{"compiling...", "done\n"}
+	got := outputMessages(<-flushed)
+
+	if strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("wrong output logged. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestLogOutputLongLinesMultiByte(t *testing.T) {
+	// The chunk boundary falls into the middle of the first "€"
+	long := strings.Repeat("x", MaxOutputChunkSize-1) + strings.Repeat("€", 10)
+
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		logOutput(logger, "example.org", COMMAND_STDOUT_OUTPUT, strings.NewReader(long))
+	})
+
+	got := outputMessages(entries)
+	if len(got) != 2 {
+		t.Fatalf("long line not split into chunks. got %d entries", len(got))
+	}
+	if len(got[0]) != MaxOutputChunkSize-1 {
+		t.Errorf("wrong chunk size. want=%d, got=%d", MaxOutputChunkSize-1, len(got[0]))
+	}
+	for _, msg := range got {
+		if !utf8.ValidString(msg) {
+			t.Errorf("chunk splits a rune: %q", msg[len(msg)-3:])
+		}
+	}
+	if strings.Join(got, "") != long {
+		t.Errorf("chunks do not add up to the output")
+	}
+}
+
+func TestLogOutputRedactsSplitSecrets(t *testing.T) {
+	redact := func(logger *DeploymentLogger) {
+		logger.redact = func(msg string) string {
+			return strings.Replace(msg, "s3cr3t", "[REDACTED]", -1)
+		}
+		logger.redactOverlap = func() int { return len("s3cr3t") }
+	}
+
+	// The secret crosses the boundary of the first chunk
+	long := strings.Repeat("x", MaxOutputChunkSize-3) + "s3cr3t" + strings.Repeat("y", 10)
+	entries := collectLogEntries(func(logger *DeploymentLogger) {
+		redact(logger)
+		logOutput(logger, "example.org", COMMAND_STDOUT_OUTPUT, strings.NewReader(long))
+	})
+
+	got := strings.Join(outputMessages(entries), "")
+	expected := strings.Repeat("x", MaxOutputChunkSize-3) + "[REDACTED]" + strings.Repeat("y", 10)
+	if got != expected {
+		t.Errorf("secret split by chunks not masked. got=%q", got[MaxOutputChunkSize-10:])
+	}
+
+	// The secret is interrupted by a flush
+	pr, pw := io.Pipe()
+	flushed := make(chan []LogEntry)
+
+	go func() {
+		flushed <- collectLogEntries(func(logger *DeploymentLogger) {
+			redact(logger)
+			logOutput(logger, "example.org", COMMAND_STDOUT_OUTPUT, pr)
+		})
+	}()
+
+	pw.Write([]byte("logging in with password s3c"))
+	time.Sleep(OutputFlushInterval * 2)
+	pw.Write([]byte("r3t\n"))
+	pw.Close()
+
+	messages := outputMessages(<-flushed)
+	for _, msg := range messages {
+		if strings.Contains(msg, "s3c") || strings.Contains(msg, "r3t") {
+			t.Errorf("secret split by a flush not masked. got=%q", messages)
+		}
+	}
+	if got := strings.Join(messages, ""); got != "logging in with password [REDACTED]\n" {
+		t.Errorf("wrong output logged. got=%q", got)
+	}
+	if len(messages) != 2 {
+		t.Errorf("output before the secret not flushed. got=%q", messages)
+	}
+}
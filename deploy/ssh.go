@@ -1,23 +1,47 @@
 package deploy
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
 
+	"github.com/applikatoni/applikatoni/models"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 )
 
+// The SSH connection to each host is kept open for the whole deployment. Keep
+// it from being dropped by firewalls/NAT while a long stage runs elsewhere.
+var sshKeepAliveInterval = 30 * time.Second
+
+// Connecting to a host, including the SSH handshake, fails after this time,
+// so an unreachable host fails the deployment quickly.
+var sshConnectTimeout = 15 * time.Second
+
 func newSSHClient(host string, sshConfig *ssh.ClientConfig) (*ssh.Client, error) {
-	client, err := ssh.Dial("tcp", host, sshConfig)
+	conn, err := net.DialTimeout("tcp", host, sshConnectTimeout)
+	if err != nil {
+		slog.Error("dialing host failed", "host", host, "err", err)
+		return nil, err
+	}
+
+	// ssh.ClientConfig.Timeout only limits the dial, a host that accepts
+	// the connection but doesn't answer would block the handshake
+	conn.SetDeadline(time.Now().Add(sshConnectTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, host, sshConfig)
 	if err != nil {
-		log.Println("ssh.Dial failed", err)
+		conn.Close()
+		slog.Error("SSH handshake failed", "host", host, "err", err)
 		return nil, err
 	}
+	conn.SetDeadline(time.Time{})
 
-	return client, nil
+	return ssh.NewClient(c, chans, reqs), nil
 }
 
-func newSSHClientConfig(user string, key []byte) (*ssh.ClientConfig, error) {
-	signer, err := ssh.ParsePrivateKey(key)
+func newSSHClientConfig(user string, key []byte, passphrase string) (*ssh.ClientConfig, error) {
+	signer, err := parsePrivateKey(key, passphrase)
 	if err != nil {
 		return nil, err
 	}
@@ -30,3 +54,87 @@ func newSSHClientConfig(user string, key []byte) (*ssh.ClientConfig, error) {
 	}
 	return config, nil
 }
+
+func parsePrivateKey(key []byte, passphrase string) (ssh.Signer, error) {
+	if passphrase != "" {
+		return ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	}
+	return ssh.ParsePrivateKey(key)
+}
+
+// IsEncryptedSSHKey returns true if the private key is protected by a
+// passphrase.
+func IsEncryptedSSHKey(key []byte) bool {
+	_, err := ssh.ParsePrivateKey(key)
+	_, missing := err.(*ssh.PassphraseMissingError)
+	return missing
+}
+
+// ValidateSSHKey returns an error if the private key can't be parsed or
+// decrypted with the passphrase.
+func ValidateSSHKey(key []byte, passphrase string) error {
+	_, err := parsePrivateKey(key, passphrase)
+	return err
+}
+
+// newHostKeyCallback verifies the host keys of the hosts against their
+// host_key_fingerprint or, if they don't have one, against the known_hosts
+// file. If neither is configured, any host key is accepted.
+func newHostKeyCallback(hosts []*models.Host, knownHostsFile string) (ssh.HostKeyCallback, error) {
+	var knownHostsCallback ssh.HostKeyCallback
+	if knownHostsFile != "" {
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, err
+		}
+		knownHostsCallback = callback
+	}
+
+	fingerprints := make(map[string]string)
+	for _, h := range hosts {
+		if h.HostKeyFingerprint != "" {
+			fingerprints[h.Address()] = h.HostKeyFingerprint
+		}
+	}
+
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if expected, ok := fingerprints[hostname]; ok {
+			got := ssh.FingerprintSHA256(key)
+			if got != expected {
+				return fmt.Errorf("host key verification failed: fingerprint of %s is %s, expected %s", hostname, got, expected)
+			}
+			return nil
+		}
+
+		if knownHostsCallback != nil {
+			if err := knownHostsCallback(hostname, remote, key); err != nil {
+				return fmt.Errorf("host key verification failed: %s", err)
+			}
+		}
+
+		return nil
+	}
+
+	return callback, nil
+}
+
+func sendKeepAlive(client *ssh.Client) error {
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+	return err
+}
+
+func keepSSHClientAlive(client *ssh.Client, done <-chan struct{}) {
+	ticker := time.NewTicker(sshKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := sendKeepAlive(client); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
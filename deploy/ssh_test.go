@@ -0,0 +1,131 @@
+package deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func generateHostKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallback(t *testing.T) {
+	hostKey := generateHostKey(t)
+	otherKey := generateHostKey(t)
+
+	knownHostsFile, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(knownHostsFile.Name())
+	fmt.Fprintln(knownHostsFile, knownhosts.Line([]string{"db.applikatoni.com:22"}, hostKey))
+	knownHostsFile.Close()
+
+	hosts := []*models.Host{
+		{Name: "web.applikatoni.com:22", HostKeyFingerprint: ssh.FingerprintSHA256(hostKey)},
+		{Name: "db.applikatoni.com:22"},
+		{Name: "legacy.applikatoni.com", Port: 2222, HostKeyFingerprint: ssh.FingerprintSHA256(hostKey)},
+	}
+
+	tests := []struct {
+		knownHostsFile string
+		hostname       string
+		key            ssh.PublicKey
+		valid          bool
+	}{
+		{"", "web.applikatoni.com:22", hostKey, true},
+		{"", "web.applikatoni.com:22", otherKey, false},
+		{"", "db.applikatoni.com:22", otherKey, true},
+		{"", "legacy.applikatoni.com:2222", hostKey, true},
+		{"", "legacy.applikatoni.com:2222", otherKey, false},
+		{knownHostsFile.Name(), "web.applikatoni.com:22", hostKey, true},
+		{knownHostsFile.Name(), "db.applikatoni.com:22", hostKey, true},
+		{knownHostsFile.Name(), "db.applikatoni.com:22", otherKey, false},
+		{knownHostsFile.Name(), "unknown.applikatoni.com:22", hostKey, false},
+	}
+
+	for _, tt := range tests {
+		callback, err := newHostKeyCallback(hosts, tt.knownHostsFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+		err = callback(tt.hostname, remote, tt.key)
+		if tt.valid && err != nil {
+			t.Errorf("host key of %s rejected. err=%s", tt.hostname, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("host key of %s accepted, expected error", tt.hostname)
+		}
+	}
+}
+
+func TestHostKeyCallbackMissingKnownHosts(t *testing.T) {
+	_, err := newHostKeyCallback(nil, "/does/not/exist/known_hosts")
+	if err == nil {
+		t.Errorf("expected error for missing known_hosts file")
+	}
+}
+
+// generatePrivateKey returns a PEM encoded private key, encrypted with the
+// passphrase if it's not empty.
+func generatePrivateKey(t *testing.T, passphrase string) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if passphrase != "" {
+		block, err = x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(passphrase), x509.PEMCipherAES256)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return pem.EncodeToMemory(block)
+}
+
+func TestEncryptedSSHKey(t *testing.T) {
+	plainKey := generatePrivateKey(t, "")
+	encryptedKey := generatePrivateKey(t, "s3cret")
+
+	if IsEncryptedSSHKey(plainKey) {
+		t.Errorf("unencrypted key reported as encrypted")
+	}
+	if !IsEncryptedSSHKey(encryptedKey) {
+		t.Errorf("encrypted key reported as unencrypted")
+	}
+
+	if err := ValidateSSHKey(encryptedKey, "s3cret"); err != nil {
+		t.Errorf("decrypting key with correct passphrase failed. err=%s", err)
+	}
+	if err := ValidateSSHKey(encryptedKey, "wrong"); err == nil {
+		t.Errorf("decrypting key with wrong passphrase did not fail")
+	}
+
+	if _, err := newSSHClientConfig("deploy", encryptedKey, "s3cret"); err != nil {
+		t.Errorf("building ssh config with encrypted key failed. err=%s", err)
+	}
+}
@@ -2,9 +2,10 @@ package deploy
 
 import (
 	"bufio"
-	"io"
-	"log"
+	"errors"
+	"os/exec"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/applikatoni/applikatoni/models"
@@ -18,30 +19,107 @@ type ExecutionResult struct {
 	skipped   bool
 }
 
+// errWorkerAborted is returned by workers that are asked to connect again
+// after the deployment aborted them.
+var errWorkerAborted = errors.New("not connecting, the deployment has been aborted")
+
 type Worker struct {
 	sshConfig *ssh.ClientConfig
+	// mu guards the connection, which the manager closes while the worker
+	// executes a stage if the deployment times out
+	mu        sync.Mutex
 	sshClient *ssh.Client
-	host      *models.Host
-	logger    *DeploymentLogger
-	scripts   map[models.DeploymentStage]string // No ScriptTemplate here, we need the rendered one
+	// Set by Abort, the worker doesn't connect again afterwards
+	aborted bool
+	// Closed to stop sending keepalive requests on sshClient
+	keepAliveDone chan struct{}
+	host          *models.Host
+	logger        *DeploymentLogger
+	scripts       map[models.DeploymentStage]string // No ScriptTemplate here, we need the rendered one
+
+	// Stages whose commands are run via sudo and the password sudo asks for.
+	// If the password is empty, sudo must not ask for one.
+	sudoStages   map[models.DeploymentStage]bool
+	sudoPassword string
+
+	// Maps stages that have to be executed serially to the name of the role
+	// defining them. Workers sharing the role execute these one at a time.
+	serialStages map[models.DeploymentStage]string
 }
 
 func (w *Worker) Connect() error {
-	client, err := newSSHClient(w.host.Name, w.sshConfig)
+	if w.isAborted() {
+		return errWorkerAborted
+	}
+
+	client, err := newSSHClient(w.host.Address(), w.sshConfig)
 	if err != nil {
 		return err
 	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.aborted {
+		client.Close()
+		return errWorkerAborted
+	}
 	w.sshClient = client
+	w.keepAliveDone = make(chan struct{})
+	go keepSSHClientAlive(client, w.keepAliveDone)
 	return nil
 }
 
 func (w *Worker) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.keepAliveDone != nil {
+		close(w.keepAliveDone)
+		w.keepAliveDone = nil
+	}
 	if w.sshClient != nil {
 		return w.sshClient.Close()
 	}
 	return nil
 }
 
+// Abort closes the connection, which makes the running command fail, and
+// keeps the worker from connecting again.
+func (w *Worker) Abort() {
+	w.mu.Lock()
+	w.aborted = true
+	w.mu.Unlock()
+
+	w.Close()
+}
+
+func (w *Worker) isAborted() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.aborted
+}
+
+func (w *Worker) client() *ssh.Client {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sshClient
+}
+
+// ensureConnected reuses the connection opened in Connect() and only dials the
+// host again if the connection has been lost since the last stage.
+func (w *Worker) ensureConnected() error {
+	if client := w.client(); client != nil && sendKeepAlive(client) == nil {
+		return nil
+	}
+	if w.isAborted() {
+		return errWorkerAborted
+	}
+
+	w.logger.serverLog().Warn("SSH connection lost, reconnecting", "host", w.host.Name)
+	w.Close()
+	return w.Connect()
+}
+
 func (w *Worker) Execute(stage models.DeploymentStage) ExecutionResult {
 	script, present := w.scripts[stage]
 	if !present {
@@ -49,13 +127,17 @@ func (w *Worker) Execute(stage models.DeploymentStage) ExecutionResult {
 	}
 
 	start := time.Now()
-	err := w.executeScript(script)
+	if err := w.ensureConnected(); err != nil {
+		return ExecutionResult{origin: w.host.Name, err: err, timeTaken: time.Since(start)}
+	}
+
+	err := w.executeScript(script, w.sudoStages[stage])
 	timeTaken := time.Since(start)
 
 	return ExecutionResult{origin: w.host.Name, err: err, timeTaken: timeTaken}
 }
 
-func (w *Worker) executeScript(script string) error {
+func (w *Worker) executeScript(script string, sudo bool) error {
 	r := strings.NewReader(script)
 	scanner := bufio.NewScanner(r)
 
@@ -64,81 +146,94 @@ func (w *Worker) executeScript(script string) error {
 
 		w.logCommandStart(line)
 
-		err := w.runCommand(line)
+		start := time.Now()
+		err := w.runCommand(line, sudo)
 		if err != nil {
-			w.logCommandFail(line, err)
+			w.logCommandFail(line, err, time.Since(start))
 			return err
 		}
-		w.logCommandSuccess(line)
+		w.logCommandSuccess(line, time.Since(start))
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Println("Scanning lines of script failed", err)
+		w.logger.serverLog().Error("scanning lines of script failed", "host", w.host.Name, "err", err)
 		return err
 	}
 
 	return nil
 }
 
-func (w *Worker) runCommand(cmd string) error {
-	session, err := w.sshClient.NewSession()
+func (w *Worker) runCommand(cmd string, sudo bool) error {
+	session, err := w.client().NewSession()
 	if err != nil {
-		log.Println("could not create new SSH session", err)
+		w.logger.serverLog().Error("could not create new SSH session", "host", w.host.Name, "err", err)
 		return err
 	}
 	defer session.Close()
 
 	sessionStderr, err := session.StderrPipe()
 	if err != nil {
-		log.Println("could not create new stderr pipe")
+		w.logger.serverLog().Error("could not create new stderr pipe", "host", w.host.Name, "err", err)
 		return err
 	}
-	go w.logOutput(COMMAND_STDERR_OUTPUT, sessionStderr)
+	go logOutput(w.logger, w.host.Name, COMMAND_STDERR_OUTPUT, sessionStderr)
 
 	sessionStdout, err := session.StdoutPipe()
 	if err != nil {
-		log.Println("could not create new stdout pipe")
+		w.logger.serverLog().Error("could not create new stdout pipe", "host", w.host.Name, "err", err)
 		return err
 	}
-	go w.logOutput(COMMAND_STDOUT_OUTPUT, sessionStdout)
+	go logOutput(w.logger, w.host.Name, COMMAND_STDOUT_OUTPUT, sessionStdout)
+
+	if sudo {
+		cmd = sudoCommand(cmd, w.sudoPassword != "")
+		if w.sudoPassword != "" {
+			session.Stdin = strings.NewReader(w.sudoPassword + "\n")
+		}
+	}
 
 	if err = session.Start(cmd); err != nil {
-		log.Println("Start failed")
+		w.logger.serverLog().Error("starting the command failed", "host", w.host.Name, "err", err)
 		return err
 	}
 
 	return session.Wait()
 }
 
-func (w *Worker) logOutput(entryType LogEntryType, r io.Reader) {
-	reader := bufio.NewReader(r)
+// sudoCommand wraps cmd so it is run by a root shell. With withPassword the
+// password is read from stdin (without a prompt), otherwise sudo is told to
+// fail instead of asking for one.
+func sudoCommand(cmd string, withPassword bool) string {
+	quoted := "'" + strings.Replace(cmd, "'", `'"'"'`, -1) + "'"
 
-	for {
-		line, err := reader.ReadBytes('\n')
-		if s := string(line); s != "" {
-			entry := LogEntry{
-				Origin:    w.host.Name,
-				EntryType: entryType,
-				Message:   s,
-				Timestamp: time.Now(),
-			}
-
-			w.logger.Log(entry)
-		}
-		if err != nil {
-			break
-		}
+	if withPassword {
+		return "sudo -S -p '' -- /bin/sh -c " + quoted
 	}
+	return "sudo -n -- /bin/sh -c " + quoted
 }
 
 func (w *Worker) logCommandStart(cmd string) {
 	w.logger.LogCmdStart(w.host.Name, cmd)
 }
 
-func (w *Worker) logCommandFail(cmd string, err error) {
-	w.logger.LogCmdFail(w.host.Name, cmd, err)
+func (w *Worker) logCommandFail(cmd string, err error, duration time.Duration) {
+	w.logger.LogCmdFail(w.host.Name, cmd, err, duration)
 }
 
-func (w *Worker) logCommandSuccess(cmd string) {
-	w.logger.LogCmdSuccess(w.host.Name, cmd)
+func (w *Worker) logCommandSuccess(cmd string, duration time.Duration) {
+	w.logger.LogCmdSuccess(w.host.Name, cmd, duration)
+}
+
+// exitCode returns the exit status of a command that failed with err or -1 if
+// the command didn't exit, e.g. because the connection was lost.
+func exitCode(err error) int {
+	switch e := err.(type) {
+	case nil:
+		return 0
+	case *ssh.ExitError:
+		return e.ExitStatus()
+	case *exec.ExitError:
+		return e.ExitCode()
+	}
+	return -1
 }
@@ -0,0 +1,244 @@
+package deploy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSudoCommand(t *testing.T) {
+	tests := []struct {
+		cmd          string
+		withPassword bool
+		expected     string
+	}{
+		{"/etc/init.d/unicorn restart", false, `sudo -n -- /bin/sh -c '/etc/init.d/unicorn restart'`},
+		{"/etc/init.d/unicorn restart", true, `sudo -S -p '' -- /bin/sh -c '/etc/init.d/unicorn restart'`},
+		{"echo 'hello' && whoami", false, `sudo -n -- /bin/sh -c 'echo '"'"'hello'"'"' && whoami'`},
+	}
+
+	for _, tt := range tests {
+		got := sudoCommand(tt.cmd, tt.withPassword)
+		if got != tt.expected {
+			t.Errorf("wrong sudo command. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+// fakeSSHServer accepts SSH connections without authentication and answers
+// every command with exit status 0, recording the commands it ran.
+type fakeSSHServer struct {
+	listener net.Listener
+	config   *ssh.ServerConfig
+
+	mu       sync.Mutex
+	conns    []*ssh.ServerConn
+	commands []string
+	// The command that never finishes, until the connection is closed
+	hang string
+	// The command that waits until it's released
+	hold    string
+	held    chan struct{}
+	release chan struct{}
+}
+
+func newFakeSSHServer(t *testing.T) *fakeSSHServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSSHServer{listener: listener, config: config}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSSHServer) serve(nConn net.Conn) {
+	conn, chans, reqs, err := ssh.NewServerConn(nConn, s.config)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	s.conns = append(s.conns, conn)
+	s.mu.Unlock()
+
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.exec(channel, requests)
+	}
+}
+
+func (s *fakeSSHServer) exec(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		ssh.Unmarshal(req.Payload, &payload)
+
+		s.mu.Lock()
+		s.commands = append(s.commands, payload.Command)
+		hang := payload.Command == s.hang
+		hold := payload.Command == s.hold
+		s.mu.Unlock()
+
+		req.Reply(true, nil)
+		if hang {
+			continue
+		}
+		if hold {
+			close(s.held)
+			<-s.release
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		channel.Close()
+	}
+}
+
+func (s *fakeSSHServer) hangOn(command string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hang = command
+}
+
+// holdOn makes the command wait until release is closed. held is closed when
+// the command has started.
+func (s *fakeSSHServer) holdOn(command string) (held <-chan struct{}, release chan<- struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hold = command
+	s.held = make(chan struct{})
+	s.release = make(chan struct{})
+	return s.held, s.release
+}
+
+// dropConnections closes the open connections like a network failure.
+func (s *fakeSSHServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+}
+
+func (s *fakeSSHServer) connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *fakeSSHServer) executed() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+func newFakeSSHWorker(s *fakeSSHServer) *Worker {
+	logger := NewDeploymentLogger(deployment, NewLogRouter())
+	logger.BroadcastLogs()
+
+	return &Worker{
+		host:      &models.Host{Name: s.listener.Addr().String()},
+		sshConfig: &ssh.ClientConfig{User: "deploy", HostKeyCallback: ssh.InsecureIgnoreHostKey()},
+		logger:    logger,
+		scripts: map[models.DeploymentStage]string{
+			preDeployment: "./pre_deployment",
+			migrate:       "./migrate",
+		},
+	}
+}
+
+func TestWorkerReusesConnection(t *testing.T) {
+	server := newFakeSSHServer(t)
+	defer server.listener.Close()
+
+	w := newFakeSSHWorker(server)
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	for _, stage := range []models.DeploymentStage{preDeployment, migrate} {
+		if result := w.Execute(stage); result.err != nil {
+			t.Fatalf("executing %s failed: %s", stage, result.err)
+		}
+	}
+
+	if got := server.connections(); got != 1 {
+		t.Errorf("wrong number of connections. want=%d, got=%d", 1, got)
+	}
+	expected := []string{"./pre_deployment", "./migrate"}
+	if got := server.executed(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong commands. want=%v, got=%v", expected, got)
+	}
+}
+
+func TestWorkerReconnects(t *testing.T) {
+	server := newFakeSSHServer(t)
+	defer server.listener.Close()
+
+	w := newFakeSSHWorker(server)
+	if err := w.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	if result := w.Execute(preDeployment); result.err != nil {
+		t.Fatalf("executing %s failed: %s", preDeployment, result.err)
+	}
+
+	server.dropConnections()
+
+	if result := w.Execute(migrate); result.err != nil {
+		t.Fatalf("executing %s after the connection dropped failed: %s", migrate, result.err)
+	}
+	if got := server.connections(); got != 2 {
+		t.Errorf("wrong number of connections. want=%d, got=%d", 2, got)
+	}
+	expected := []string{"./pre_deployment", "./migrate"}
+	if got := server.executed(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong commands. want=%v, got=%v", expected, got)
+	}
+
+	// The host can't be reached anymore
+	server.listener.Close()
+	server.dropConnections()
+
+	if result := w.Execute(migrate); result.err == nil {
+		t.Errorf("executing %s succeeded without a connection", migrate)
+	}
+}
@@ -2,22 +2,132 @@ package models
 
 import "fmt"
 
+const (
+	SCM_GITHUB    = "github"
+	SCM_GITLAB    = "gitlab"
+	SCM_BITBUCKET = "bitbucket"
+	SCM_GITEA     = "gitea"
+)
+
 type Application struct {
 	Name                 string    `json:"name"`
 	Targets              []*Target `json:"targets"`
 	ReadUsernames        []string  `json:"read_usernames"`
+	ReadGroups           []string  `json:"read_groups"`
 	GitHubOwner          string    `json:"github_owner"`
 	GitHubRepo           string    `json:"github_repo"`
 	GitHubBranches       []string  `json:"github_branches"`
+	SCM                  string    `json:"scm"`
+	SCMAccessToken       string    `json:"scm_access_token"`
 	TravisImageURL       string    `json:"travis_image_url"`
 	DailyDigestReceivers []string  `json:"daily_digest_receivers"`
 	DailyDigestTarget    string    `json:"daily_digest_target"`
+	// Files that replace the built-in templates of the digest emails,
+	// relative to the configuration file
+	DigestHtmlTemplate string `json:"digest_html_template"`
+	DigestTextTemplate string `json:"digest_text_template"`
+
+	AutoDeploy  *AutoDeploy  `json:"auto_deploy"`
+	CITrigger   *CITrigger   `json:"ci_trigger"`
+	JiraRelease *JiraRelease `json:"jira_release"`
+	// The tracker of the issues the commit messages refer to
+	IssueTracker *IssueTracker `json:"issue_tracker"`
+	// URLs every change of the state of a deployment is posted to as
+	// normalized event, like the ones of /api/v1/events
+	EventWebhooks []string `json:"event_webhooks"`
 }
 
 func (a *Application) IsReader(userName string) bool {
 	return isInList(userName, a.ReadUsernames)
 }
 
+// CanRead checks whether the user is listed in the ReadUsernames, by its
+// QualifiedName, or is a member of one of the ReadGroups.
+func (a *Application) CanRead(u *User) bool {
+	if u.ServiceAccount != nil {
+		return u.ServiceAccount.CanRead(a.Name)
+	}
+	return a.IsReader(u.QualifiedName()) || isInAnyList(u.Groups, a.ReadGroups)
+}
+
+// CanDeploy checks whether the user may deploy the application to the target.
+func (a *Application) CanDeploy(t *Target, u *User) bool {
+	if u.ServiceAccount != nil {
+		return u.ServiceAccount.CanDeploy(a.Name, t.Name)
+	}
+	if t.CanDeploy(u) {
+		return true
+	}
+	return t.DeployableByReaders && a.CanRead(u)
+}
+
+// DigestSchedule returns how often the digest of the deployments to the
+// target is sent. Without a digest_schedule only the daily_digest_target gets
+// a daily digest.
+func (a *Application) DigestSchedule(t *Target) (DigestSchedule, error) {
+	frequency := t.DigestSchedule
+	if frequency == "" {
+		frequency = string(DIGEST_DISABLED)
+		if t.Name == a.DailyDigestTarget {
+			frequency = string(DIGEST_DAILY)
+		}
+	}
+	return parseDigestSchedule(frequency, t.DigestWeekday, t.DigestTime, t.DigestTimeZone)
+}
+
+// HasDigest checks whether the digest of any target of the application is
+// sent.
+func (a *Application) HasDigest() bool {
+	for _, t := range a.Targets {
+		if s, err := a.DigestSchedule(t); err == nil && s.Frequency != DIGEST_DISABLED {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsCIOverride checks whether any target of the application accepts
+// deployments of commits without passing CI.
+func (a *Application) AllowsCIOverride() bool {
+	for _, t := range a.Targets {
+		if t.RequirePassingCI && t.AllowCIOverride {
+			return true
+		}
+	}
+	return false
+}
+
+// HasOverridableVariables checks whether deployers may override variables of
+// the script templates on any target of the application.
+func (a *Application) HasOverridableVariables() bool {
+	for _, t := range a.Targets {
+		if len(t.OverridableVariables) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasDeployWindows checks whether any target of the application only allows
+// deployments inside deploy windows.
+func (a *Application) HasDeployWindows() bool {
+	for _, t := range a.Targets {
+		if len(t.DeployWindows) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SCMName returns the code hosting service of the repository, GitHub if none
+// is configured.
+func (a *Application) SCMName() string {
+	if a.SCM == "" {
+		return SCM_GITHUB
+	}
+	return a.SCM
+}
+
 func (a *Application) RepositoryURL() string {
 	return fmt.Sprintf("git@github.com:%s/%s.git", a.GitHubOwner, a.GitHubRepo)
 }
@@ -31,3 +141,12 @@ func isInList(username string, list []string) bool {
 	}
 	return false
 }
+
+func isInAnyList(items []string, list []string) bool {
+	for _, item := range items {
+		if isInList(item, list) {
+			return true
+		}
+	}
+	return false
+}
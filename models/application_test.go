@@ -1,6 +1,9 @@
 package models
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestRepositoryURL(t *testing.T) {
 	a := &Application{GitHubOwner: "owner", GitHubRepo: "repo"}
@@ -11,3 +14,137 @@ func TestRepositoryURL(t *testing.T) {
 		t.Errorf("wrong repository URL. want=%s, got=%s", expected, got)
 	}
 }
+
+func TestApplicationCanDeploy(t *testing.T) {
+	production := &Target{Name: "production", DeployGroups: []string{"flinc/ops"}}
+	staging := &Target{Name: "staging", DeployableByReaders: true}
+
+	a := &Application{
+		Name:          "web",
+		ReadUsernames: []string{"mrnugget", "fabrik42"},
+		Targets:       []*Target{production, staging},
+	}
+
+	ops := &User{Name: "mrnugget", Groups: []string{"flinc/ops"}}
+	developer := &User{Name: "fabrik42", Groups: []string{"flinc/developers"}}
+	stranger := &User{Name: "stranger"}
+	impostor := &User{Name: "mrnugget", Provider: "gitlab"}
+	// Listing a service account in read_usernames grants it nothing
+	ci := &User{Name: "fabrik42", ServiceAccount: &ServiceAccount{
+		Name:    "ci",
+		Targets: map[string][]string{"web": []string{"staging"}},
+	}}
+
+	tests := []struct {
+		target   *Target
+		user     *User
+		expected bool
+	}{
+		{production, ops, true},
+		{production, developer, false},
+		{staging, ops, true},
+		{staging, developer, true},
+		{staging, stranger, false},
+		{staging, impostor, false},
+		{staging, ci, true},
+		{production, ci, false},
+	}
+
+	for _, tt := range tests {
+		got := a.CanDeploy(tt.target, tt.user)
+		if got != tt.expected {
+			t.Errorf("wrong result for %s on %s. want=%t, got=%t", tt.user.Name, tt.target.Name, tt.expected, got)
+		}
+	}
+}
+
+func TestApplicationDigestSchedule(t *testing.T) {
+	a := &Application{Name: "web", DailyDigestTarget: "production"}
+
+	tests := []struct {
+		target   *Target
+		expected string
+	}{
+		{&Target{Name: "production"}, "daily at 22:00 Local"},
+		{&Target{Name: "staging"}, "disabled"},
+		{&Target{Name: "production", DigestSchedule: "disabled"}, "disabled"},
+		{&Target{Name: "staging", DigestSchedule: "weekly"}, "weekly on mon at 22:00 Local"},
+		{&Target{Name: "staging", DigestSchedule: "weekly", DigestWeekday: "Fri"}, "weekly on fri at 22:00 Local"},
+		{&Target{Name: "production", DigestTime: "17:30", DigestTimeZone: "America/New_York"}, "daily at 17:30 America/New_York"},
+		{&Target{Name: "staging", DigestSchedule: "weekly", DigestWeekday: "friday"}, ""},
+		{&Target{Name: "staging", DigestSchedule: "hourly"}, ""},
+		{&Target{Name: "production", DigestTime: "5pm"}, ""},
+		{&Target{Name: "production", DigestTime: "24:00"}, ""},
+		{&Target{Name: "production", DigestTimeZone: "Mars/Olympus_Mons"}, ""},
+	}
+
+	for _, tt := range tests {
+		got, err := a.DigestSchedule(tt.target)
+		if tt.expected == "" {
+			if err == nil {
+				t.Errorf("expected error for %+v, got none", tt.target)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %+v: %s", tt.target, err)
+		}
+		if got.String() != tt.expected {
+			t.Errorf("wrong schedule for %+v. want=%s, got=%s", tt.target, tt.expected, got)
+		}
+	}
+}
+
+func TestDigestScheduleNext(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daily := DigestSchedule{Frequency: DIGEST_DAILY, TimeOfDay: 22 * 60, Location: time.UTC}
+	friday := DigestSchedule{Frequency: DIGEST_WEEKLY, Weekday: time.Friday, TimeOfDay: 22 * 60, Location: time.UTC}
+	dailyBerlin := DigestSchedule{Frequency: DIGEST_DAILY, TimeOfDay: 22 * 60, Location: berlin}
+	wednesdayNewYork := DigestSchedule{Frequency: DIGEST_WEEKLY, Weekday: time.Wednesday, TimeOfDay: 23 * 60, Location: newYork}
+	at := func(month time.Month, day, hour, min int, loc *time.Location) time.Time {
+		return time.Date(2026, month, day, hour, min, 0, 0, loc)
+	}
+
+	tests := []struct {
+		schedule DigestSchedule
+		now      time.Time
+		expected time.Time
+	}{
+		// Wednesday
+		{daily, at(10, 14, 12, 0, time.UTC), at(10, 14, 22, 0, time.UTC)},
+		{daily, at(10, 14, 22, 0, time.UTC), at(10, 15, 22, 0, time.UTC)},
+		{daily, at(10, 31, 23, 0, time.UTC), at(11, 1, 22, 0, time.UTC)},
+		{friday, at(10, 14, 12, 0, time.UTC), at(10, 16, 22, 0, time.UTC)},
+		{friday, at(10, 16, 21, 0, time.UTC), at(10, 16, 22, 0, time.UTC)},
+		{friday, at(10, 16, 22, 0, time.UTC), at(10, 23, 22, 0, time.UTC)},
+		{friday, at(10, 31, 12, 0, time.UTC), at(11, 6, 22, 0, time.UTC)},
+		// Across the end of daylight saving time on October 25
+		{dailyBerlin, at(10, 24, 22, 30, berlin), at(10, 25, 22, 0, berlin)},
+		// It's Thursday in UTC but still Wednesday in New York
+		{wednesdayNewYork, at(10, 15, 2, 0, time.UTC), at(10, 14, 23, 0, newYork)},
+		{wednesdayNewYork, at(10, 15, 3, 0, time.UTC), at(10, 21, 23, 0, newYork)},
+	}
+
+	for _, tt := range tests {
+		got := tt.schedule.Next(tt.now)
+		if !got.Equal(tt.expected) {
+			t.Errorf("wrong next %s digest after %s. want=%s, got=%s", tt.schedule, tt.now, tt.expected, got)
+		}
+	}
+
+	if since := friday.Since(at(10, 16, 22, 0, time.UTC)); !since.Equal(at(10, 9, 22, 0, time.UTC)) {
+		t.Errorf("wrong start of weekly digest. got=%s", since)
+	}
+	// The day the clocks are turned back has 25 hours
+	if since := dailyBerlin.Since(at(10, 25, 22, 0, berlin)); !since.Equal(at(10, 24, 22, 0, berlin)) {
+		t.Errorf("wrong start of digest across daylight saving time. got=%s", since)
+	}
+}
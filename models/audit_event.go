@@ -0,0 +1,62 @@
+package models
+
+import "time"
+
+type AuditAction string
+
+const (
+	AUDIT_LOGIN                  AuditAction = "login"
+	AUDIT_LOGIN_LOCKOUT          AuditAction = "login.lockout"
+	AUDIT_DEPLOYMENT_CREATE      AuditAction = "deployment.create"
+	AUDIT_DEPLOYMENT_CANCEL      AuditAction = "deployment.cancel"
+	AUDIT_DEPLOYMENT_APPROVE     AuditAction = "deployment.approve"
+	AUDIT_DEPLOYMENT_REJECT      AuditAction = "deployment.reject"
+	AUDIT_API_TOKEN_REGENERATE   AuditAction = "api_token.regenerate"
+	AUDIT_API_TOKEN_REVOKE       AuditAction = "api_token.revoke"
+	AUDIT_SESSIONS_REVOKE        AuditAction = "sessions.revoke"
+	AUDIT_USER_DEACTIVATE        AuditAction = "user.deactivate"
+	AUDIT_USER_REACTIVATE        AuditAction = "user.reactivate"
+	AUDIT_DEPLOY_FREEZE          AuditAction = "deploy.freeze"
+	AUDIT_DEPLOY_UNFREEZE        AuditAction = "deploy.unfreeze"
+	AUDIT_DEPLOY_WINDOW_OVERRIDE AuditAction = "deploy_window.override"
+	AUDIT_DEPLOY_PRESET_SAVE     AuditAction = "deploy_preset.save"
+	AUDIT_DEPLOY_PRESET_DELETE   AuditAction = "deploy_preset.delete"
+	AUDIT_APPLICATION_SAVE       AuditAction = "application.save"
+	AUDIT_APPLICATION_DELETE     AuditAction = "application.delete"
+	AUDIT_DELIVERY_RETRY         AuditAction = "delivery.retry"
+)
+
+var AuditActions = []AuditAction{
+	AUDIT_LOGIN,
+	AUDIT_LOGIN_LOCKOUT,
+	AUDIT_DEPLOYMENT_CREATE,
+	AUDIT_DEPLOYMENT_CANCEL,
+	AUDIT_DEPLOYMENT_APPROVE,
+	AUDIT_DEPLOYMENT_REJECT,
+	AUDIT_API_TOKEN_REGENERATE,
+	AUDIT_API_TOKEN_REVOKE,
+	AUDIT_SESSIONS_REVOKE,
+	AUDIT_USER_DEACTIVATE,
+	AUDIT_USER_REACTIVATE,
+	AUDIT_DEPLOY_FREEZE,
+	AUDIT_DEPLOY_UNFREEZE,
+	AUDIT_DEPLOY_WINDOW_OVERRIDE,
+	AUDIT_DEPLOY_PRESET_SAVE,
+	AUDIT_DEPLOY_PRESET_DELETE,
+	AUDIT_APPLICATION_SAVE,
+	AUDIT_APPLICATION_DELETE,
+	AUDIT_DELIVERY_RETRY,
+}
+
+// AuditEvent records who did what and when, e.g. created a deployment.
+type AuditEvent struct {
+	Id     int
+	UserId int
+	User   *User
+	Action AuditAction
+	// Subject is what the action was done to, e.g. "web/production #12" or
+	// the name of a deactivated user
+	Subject   string
+	SourceIP  string
+	CreatedAt time.Time
+}
@@ -0,0 +1,64 @@
+package models
+
+import "strings"
+
+// AutoDeploy configures the webhooks that deploy pushed branches, or the
+// commits CI pipelines succeeded on. The deployments are created as the
+// service account with the default stages of the target.
+type AutoDeploy struct {
+	Enabled        bool   `json:"enabled"`
+	WebhookSecret  string `json:"webhook_secret"`
+	ServiceAccount string `json:"service_account"`
+	// Maps branches to the name of the target pushes to them are deployed to
+	Branches map[string]string `json:"branches"`
+	// Path prefixes like "services/api/". If set, pushes are only deployed if
+	// they change a file under one of them.
+	Paths []string `json:"paths"`
+	// Deploys commits once a CI pipeline succeeded on them instead of when
+	// they're pushed
+	OnCISuccess bool `json:"on_ci_success"`
+	// The names of the pipelines that deploy when they succeed. Without
+	// Pipelines every successful pipeline deploys.
+	Pipelines []string `json:"pipelines"`
+}
+
+// TargetName returns the name of the target pushes to the branch are deployed
+// to or an empty string if the branch isn't deployed automatically.
+func (ad *AutoDeploy) TargetName(branch string) string {
+	if ad == nil || !ad.Enabled {
+		return ""
+	}
+	return ad.Branches[branch]
+}
+
+// WatchesPipeline checks whether the success of the named pipeline deploys
+// the commit it ran on.
+func (ad *AutoDeploy) WatchesPipeline(name string) bool {
+	if len(ad.Pipelines) == 0 {
+		return true
+	}
+
+	for _, p := range ad.Pipelines {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchesPaths checks whether one of the changed files is under one of the
+// Paths. Without Paths all changes match.
+func (ad *AutoDeploy) MatchesPaths(files []string) bool {
+	if len(ad.Paths) == 0 {
+		return true
+	}
+
+	for _, f := range files {
+		for _, p := range ad.Paths {
+			if strings.HasPrefix(f, p) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package models
+
+import "testing"
+
+func TestMatchesPaths(t *testing.T) {
+	ad := &AutoDeploy{Paths: []string{"services/api/", "shared/"}}
+
+	tests := []struct {
+		files    []string
+		expected bool
+	}{
+		{[]string{"services/api/main.go"}, true},
+		{[]string{"README.md", "shared/lib/util.go"}, true},
+		{[]string{"services/web/index.html"}, false},
+		{[]string{"services/api-docs/index.md"}, false},
+		{[]string{}, false},
+	}
+
+	for _, tt := range tests {
+		if got := ad.MatchesPaths(tt.files); got != tt.expected {
+			t.Errorf("wrong result for %v. want=%t, got=%t", tt.files, tt.expected, got)
+		}
+	}
+
+	if !(&AutoDeploy{}).MatchesPaths([]string{"README.md"}) {
+		t.Errorf("expected all changes to match without paths")
+	}
+}
+
+func TestWatchesPipeline(t *testing.T) {
+	ad := &AutoDeploy{Pipelines: []string{"github-actions", "build"}}
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"github-actions", true},
+		{"build", true},
+		{"circleci-checks", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := ad.WatchesPipeline(tt.name); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%t, got=%t", tt.name, tt.expected, got)
+		}
+	}
+
+	if !(&AutoDeploy{}).WatchesPipeline("build") {
+		t.Errorf("expected all pipelines to be watched without pipelines")
+	}
+}
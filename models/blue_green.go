@@ -0,0 +1,26 @@
+package models
+
+const (
+	BLUE_GROUP  = "blue"
+	GREEN_GROUP = "green"
+)
+
+// BlueGreen configures a target whose hosts are split into a blue and a green
+// group. Deployments only go to the group that's currently not live and the
+// SwitchStage makes the deployed group live.
+type BlueGreen struct {
+	SwitchStage DeploymentStage `json:"switch_stage"`
+}
+
+// InactiveGroup returns the group that's not live and the next deployment
+// goes to. If no group is live yet, that's the blue group.
+func InactiveGroup(liveGroup string) string {
+	if liveGroup == BLUE_GROUP {
+		return GREEN_GROUP
+	}
+	return BLUE_GROUP
+}
+
+func IsValidGroup(group string) bool {
+	return group == BLUE_GROUP || group == GREEN_GROUP
+}
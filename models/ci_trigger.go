@@ -0,0 +1,9 @@
+package models
+
+// CITrigger configures the webhook CI pipelines use to start deployments of
+// an application. The requests are signed with the Secret and the deployments
+// are created as the service account.
+type CITrigger struct {
+	Secret         string `json:"secret"`
+	ServiceAccount string `json:"service_account"`
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"net/url"
+	"time"
+)
+
+// Delivery is an attempt to send a notification to an external service, e.g.
+// Slack or a webhook, kept so failed deliveries can be inspected and retried.
+type Delivery struct {
+	Id           int
+	DeploymentId int
+	// Notifier is the name of the notifier, e.g. slack or webhook
+	Notifier string
+	Method   string
+	// URL, Header and Payload are encrypted in the database, since the URLs
+	// of webhooks and the headers contain secrets
+	URL     string
+	Header  map[string][]string
+	Payload []byte
+	// PayloadHash is the hex-encoded SHA-256 of the Payload
+	PayloadHash string
+	// ExpectedStatus is the status the service answers with on success, 0
+	// for any 2xx status
+	ExpectedStatus int
+	// StatusCode is 0 if the service didn't answer
+	StatusCode int
+	Error      string
+	Latency    time.Duration
+	// RetryOf is the ID of the delivery retried with this one, if it's a retry
+	RetryOf   int
+	CreatedAt time.Time
+}
+
+// Succeeded returns whether the service answered with the expected status.
+func (d *Delivery) Succeeded() bool {
+	if d.Error != "" {
+		return false
+	}
+	if d.ExpectedStatus == 0 {
+		return d.StatusCode >= 200 && d.StatusCode < 300
+	}
+	return d.StatusCode == d.ExpectedStatus
+}
+
+// Host returns the host the delivery was sent to, which can be shown without
+// giving away the secrets in the path of webhook URLs.
+func (d *Delivery) Host() string {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
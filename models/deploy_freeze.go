@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// DeployFreeze stops new deployments to a target, or to all targets of an
+// application, until an admin lifts it.
+type DeployFreeze struct {
+	Id              int
+	ApplicationName string
+	// TargetName is empty if all targets of the application are frozen
+	TargetName string
+	UserId     int
+	User       *User
+	Reason     string
+	CreatedAt  time.Time
+}
+
+// AppliesTo returns true if deployments to the target are frozen.
+func (f *DeployFreeze) AppliesTo(targetName string) bool {
+	return f.TargetName == "" || f.TargetName == targetName
+}
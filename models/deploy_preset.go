@@ -0,0 +1,42 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// DeployPreset is a saved set of values for the deploy form of an
+// application, e.g. for a release that is deployed the same way every time.
+type DeployPreset struct {
+	Id              int
+	ApplicationName string
+	Name            string
+	TargetName      string
+	// Branch is empty if the preset doesn't pick the commit to deploy
+	Branch        string
+	CommentPrefix string
+	// Stages is empty if all stages of the target are deployed
+	Stages    []DeploymentStage
+	Overrides map[string]string
+	UserId    int
+	CreatedAt time.Time
+}
+
+// FormatOverrides returns the overrides as lines like
+// "MIGRATION_TIMEOUT=600", as they're entered in the deploy form.
+func (p *DeployPreset) FormatOverrides() string {
+	return formatOverrides(p.Overrides)
+}
+
+// PrefixComment returns the comment starting with the comment prefix of the
+// preset, unless it already does.
+func (p *DeployPreset) PrefixComment(comment string) string {
+	comment = strings.TrimSpace(comment)
+	if p.CommentPrefix == "" || strings.HasPrefix(comment, p.CommentPrefix) {
+		return comment
+	}
+	if comment == "" {
+		return p.CommentPrefix
+	}
+	return p.CommentPrefix + " " + comment
+}
@@ -0,0 +1,24 @@
+package models
+
+import "testing"
+
+func TestDeployPresetPrefixComment(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		comment  string
+		expected string
+	}{
+		{"", "Fix signup", "Fix signup"},
+		{"[release]", "", "[release]"},
+		{"[release]", "Fix signup", "[release] Fix signup"},
+		{"[release]", " Fix signup ", "[release] Fix signup"},
+		{"[release]", "[release] Fix signup", "[release] Fix signup"},
+	}
+
+	for _, tt := range tests {
+		p := &DeployPreset{CommentPrefix: tt.prefix}
+		if got := p.PrefixComment(tt.comment); got != tt.expected {
+			t.Errorf("wrong comment. want=%q, got=%q", tt.expected, got)
+		}
+	}
+}
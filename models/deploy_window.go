@@ -0,0 +1,142 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DeployWindow is a weekly time span deployments to a target are allowed in,
+// e.g. Monday to Thursday from 09:00 to 17:00 in Europe/Berlin.
+type DeployWindow struct {
+	// Days are weekdays like "mon" or ranges like "mon-thu". Every day if
+	// empty.
+	Days []string `json:"days"`
+	// Start and End are times of the day like "09:00". If End is before Start
+	// the window ends on the next day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+	// TimeZone is the name of the time zone of Start and End, e.g.
+	// "Europe/Berlin". UTC if empty.
+	TimeZone string `json:"time_zone"`
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Validate returns an error if the days, times or time zone can't be parsed.
+func (w *DeployWindow) Validate() error {
+	if _, err := w.weekdays(); err != nil {
+		return err
+	}
+	if _, err := parseTimeOfDay(w.Start); err != nil {
+		return fmt.Errorf("invalid start %q", w.Start)
+	}
+	if _, err := parseTimeOfDay(w.End); err != nil {
+		return fmt.Errorf("invalid end %q", w.End)
+	}
+	if w.Start == w.End {
+		return fmt.Errorf("start and end are both %s", w.Start)
+	}
+	if _, err := time.LoadLocation(w.TimeZone); err != nil {
+		return fmt.Errorf("invalid time_zone %q", w.TimeZone)
+	}
+	return nil
+}
+
+// Contains reports whether t is inside the window. Windows that don't
+// validate contain nothing.
+func (w *DeployWindow) Contains(t time.Time) bool {
+	days, err := w.weekdays()
+	if err != nil {
+		return false
+	}
+	start, err := parseTimeOfDay(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseTimeOfDay(w.End)
+	if err != nil {
+		return false
+	}
+	loc, err := time.LoadLocation(w.TimeZone)
+	if err != nil {
+		return false
+	}
+
+	local := t.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	today := local.Weekday()
+	yesterday := (today + 6) % 7
+
+	if start < end {
+		return days[today] && minute >= start && minute < end
+	}
+	// The window spans midnight and belongs to the day it starts on
+	return (days[today] && minute >= start) || (days[yesterday] && minute < end)
+}
+
+func (w *DeployWindow) String() string {
+	days := "every day"
+	if len(w.Days) > 0 {
+		days = strings.Join(w.Days, ", ")
+	}
+	tz := w.TimeZone
+	if tz == "" {
+		tz = "UTC"
+	}
+	return fmt.Sprintf("%s %s-%s %s", days, w.Start, w.End, tz)
+}
+
+// weekdays returns the days of the window, indexed by time.Weekday.
+func (w *DeployWindow) weekdays() ([7]bool, error) {
+	var days [7]bool
+	if len(w.Days) == 0 {
+		for i := range days {
+			days[i] = true
+		}
+		return days, nil
+	}
+
+	for _, d := range w.Days {
+		parts := strings.SplitN(strings.ToLower(d), "-", 2)
+		first := weekdayIndex(parts[0])
+		last := first
+		if len(parts) == 2 {
+			last = weekdayIndex(parts[1])
+		}
+		if first == -1 || last == -1 {
+			return days, fmt.Errorf("invalid day %q", d)
+		}
+
+		// Ranges like "fri-mon" wrap around the weekend
+		for i := first; ; i = (i + 1) % 7 {
+			days[i] = true
+			if i == last {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func weekdayIndex(day string) int {
+	for i, d := range weekdays {
+		if d == day {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseTimeOfDay returns the minutes since midnight of a time like "09:30".
+// "24:00" is the end of the day.
+func parseTimeOfDay(s string) (int, error) {
+	if s == "24:00" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
@@ -1,6 +1,11 @@
 package models
 
-import "time"
+import (
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+)
 
 type DeploymentState string
 
@@ -9,8 +14,18 @@ const (
 	DEPLOYMENT_ACTIVE     DeploymentState = "active"
 	DEPLOYMENT_SUCCESSFUL DeploymentState = "successful"
 	DEPLOYMENT_FAILED     DeploymentState = "failed"
+	DEPLOYMENT_QUEUED     DeploymentState = "queued"
 )
 
+// DeploymentStates lists the states a deployment can be in.
+var DeploymentStates = []DeploymentState{
+	DEPLOYMENT_NEW,
+	DEPLOYMENT_QUEUED,
+	DEPLOYMENT_ACTIVE,
+	DEPLOYMENT_SUCCESSFUL,
+	DEPLOYMENT_FAILED,
+}
+
 type Deployment struct {
 	Id              int
 	CommitSha       string
@@ -22,4 +37,123 @@ type Deployment struct {
 	User            *User
 	ApplicationName string
 	TargetName      string
+	// The ID of the failed deployment this deployment automatically retries.
+	// 0 if it's not an automatic retry.
+	RetryOf int
+	// The blue-green host group the deployment goes to. Empty on other targets.
+	HostGroup string
+	// The reason the deployer gave for deploying a commit without passing
+	// CI. Empty if the CI status wasn't overridden.
+	CIOverrideReason string
+	// The number of the pull request whose head is deployed. 0 if the
+	// deployment wasn't created from a pull request.
+	PullRequest int
+	// The name of the tag pointing to the deployed commit, e.g. "v1.2.0".
+	// Empty if the commit wasn't selected by its tag.
+	Tag string
+	// UpdatedAt is the time of the last change of the state or host group.
+	UpdatedAt time.Time
+	// The ID of the deployment this deployment redeploys with the same
+	// commit and stages. 0 if it's not a redeploy.
+	RedeployOf int
+	// The stages the deployment runs. Empty for deployments created before
+	// the stages were saved.
+	Stages []DeploymentStage
+	// The ID of the HTTP request that created the deployment, to find its
+	// logs. Empty for automatic retries and deployments created before the
+	// IDs were saved.
+	RequestId string
+	// Changelog lists the commits deployed since the last successful
+	// deployment to the target, oldest first. Nil if it's not loaded.
+	Changelog []*ChangelogEntry
+	// Initiator describes the system that created the deployment with an API
+	// token. Nil for deployments created in the UI or if it's not loaded.
+	Initiator *DeploymentInitiator
+	// The reason an admin gave for deploying outside the deploy windows of the
+	// target. Only set while the deployment is created, it's saved in the
+	// audit log.
+	DeployWindowOverrideReason string
+	// The user who approved the deployment to start on a target with
+	// require_second_approval. Only set once the deployment finished, for
+	// the notifications, the approval is saved in the audit log.
+	ApprovedBy string
+	// The OverridableVariables of the target set for this deployment, e.g.
+	// MIGRATION_TIMEOUT=600. Nil if they're not loaded.
+	Overrides map[string]string
+}
+
+// FormatOverrides returns the overrides as lines like
+// "MIGRATION_TIMEOUT=600", sorted by name, as they're entered in the deploy
+// form.
+func (d *Deployment) FormatOverrides() string {
+	return formatOverrides(d.Overrides)
+}
+
+func formatOverrides(overrides map[string]string) string {
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := make([]string, len(names))
+	for i, name := range names {
+		lines[i] = name + "=" + overrides[name]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// DeploymentInitiator describes the system that created a deployment via the
+// API, e.g. a CI pipeline. The build and the person the deployment was made
+// on behalf of are passed by the system and optional.
+type DeploymentInitiator struct {
+	TokenName   string
+	SourceIP    string
+	BuildURL    string
+	BuildNumber string
+	OnBehalfOf  string
+}
+
+// LogValue adds the deployment to structured logs as a group of the fields
+// identifying it, e.g. deployment.id=42 in text logs. The ID of the request
+// that created it is added if it's known, so the logs of the deployment can
+// be correlated with the ones of the request.
+func (d *Deployment) LogValue() slog.Value {
+	if d == nil {
+		return slog.Value{}
+	}
+	attrs := []slog.Attr{
+		slog.Int("id", d.Id),
+		slog.String("application", d.ApplicationName),
+		slog.String("target", d.TargetName),
+		slog.String("commit_sha", d.CommitSha),
+	}
+	if d.RequestId != "" {
+		attrs = append(attrs, slog.String("request_id", d.RequestId))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// ChangelogEntry is a commit deployed since the last successful deployment.
+type ChangelogEntry struct {
+	CommitSha string `json:"commit_sha"`
+	Author    string `json:"author"`
+	Message   string `json:"message"`
+}
+
+func (e *ChangelogEntry) ShortSha() string {
+	return ShortSha(e.CommitSha)
+}
+
+// ShortSha abbreviates a commit SHA to 7 characters, like git does.
+func ShortSha(sha string) string {
+	if len(sha) < 7 {
+		return sha
+	}
+	return sha[:7]
+}
+
+// Summary returns the first line of the commit message.
+func (e *ChangelogEntry) Summary() string {
+	return strings.SplitN(e.Message, "\n", 2)[0]
 }
@@ -1,34 +1,113 @@
 package models
 
-import "time"
+import (
+	"text/template"
+	"time"
+)
 
 const assetsTimestampLayout string = "200601021504.05"
 
+// SECOND_APPROVAL_STAGE is the stage deployments to targets with
+// require_second_approval wait in for the approval of a second user before
+// the first stage.
+const SECOND_APPROVAL_STAGE DeploymentStage = "SECOND_APPROVAL"
+
 func NewDeploymentConfig(d *Deployment, t *Target, stages []DeploymentStage) *DeploymentConfig {
+	// The timeouts have been validated when reading the configuration
+	timeout, _ := t.Timeout()
+	pauseTimeout, _ := t.ApprovalTimeout()
+
+	hosts := t.DeploymentHosts(d)
+
 	return &DeploymentConfig{
-		User:       t.DeploymentUser,
-		SshKey:     []byte(t.DeploymentSshKey),
-		Stages:     stages,
-		Hosts:      t.Hosts,
-		Roles:      t.Roles,
-		StartTime:  time.Now(),
-		Deployment: d,
+		User:             t.DeploymentUser,
+		SshKey:           []byte(t.DeploymentSshKey),
+		SshKeyPassphrase: t.SshKeyPassphrase,
+		SudoPassword:     t.SudoPassword,
+		KnownHosts:       t.KnownHostsFile,
+		Stages:           stages,
+		Hosts:            hosts,
+		Roles:            t.Roles,
+		StartTime:        time.Now(),
+		Deployment:       d,
+		Timeout:          timeout,
+
+		PreDeploymentHooks:  t.PreDeploymentHooks,
+		PostDeploymentHooks: t.PostDeploymentHooks,
+
+		PauseStages:          t.PauseStages,
+		PauseTimeout:         pauseTimeout,
+		PauseTimeoutContinue: t.PauseTimeoutContinue,
+
+		// Automatic retries have been approved already
+		RequireSecondApproval: t.RequireSecondApproval && d.RetryOf == 0,
 	}
 }
 
 type DeploymentConfig struct {
-	User       string
-	SshKey     []byte
-	Stages     []DeploymentStage
-	Hosts      []*Host
-	Roles      []*Role
-	StartTime  time.Time
-	Deployment *Deployment
+	User             string
+	SshKey           []byte
+	SshKeyPassphrase string
+	SudoPassword     string
+	KnownHosts       string
+	Stages           []DeploymentStage
+	Hosts            []*Host
+	Roles            []*Role
+	StartTime        time.Time
+	Deployment       *Deployment
+	// The deployment is aborted after this duration. 0 means no timeout.
+	Timeout time.Duration
+
+	// Commands run on the Applikatoni server before and after the stages
+	PreDeploymentHooks  []string
+	PostDeploymentHooks []string
+
+	// Stages that wait for approval. If PauseTimeout is exceeded the
+	// deployment fails, unless PauseTimeoutContinue is set.
+	PauseStages          []DeploymentStage
+	PauseTimeout         time.Duration
+	PauseTimeoutContinue bool
+
+	// The deployment waits for the approval of a second user in the
+	// SECOND_APPROVAL_STAGE before the pre-deployment hooks and the first
+	// stage. It fails if it's not approved within PauseTimeout.
+	RequireSecondApproval bool
+
+	// Looks up the secrets referenced in the script templates. Secrets can't
+	// be used in the templates if it's nil.
+	LookupSecret SecretLookup
+
+	// Masks the secrets in the messages of the log entries before they're
+	// saved and broadcast. The messages are logged as they are if it's nil.
+	Redact func(string) string
+	// Returns the length of the longest secret Redact masks. This much of the
+	// output of commands is held back, so secrets split across log entries
+	// are masked too.
+	RedactOverlap func() int
 }
 
-func (dc *DeploymentConfig) ScriptOptions() map[string]string {
-	return map[string]string{
-		"CommitSha":       dc.Deployment.CommitSha,
-		"AssetsTimestamp": dc.StartTime.UTC().Format(assetsTimestampLayout),
+func (dc *DeploymentConfig) IsPauseStage(s DeploymentStage) bool {
+	for _, stage := range dc.PauseStages {
+		if stage == s {
+			return true
+		}
 	}
+	return false
+}
+
+// ScriptOptions returns the options the script templates are rendered with,
+// including the overrides of the deployment. The overrides can't replace the
+// CommitSha and the other options set by Applikatoni.
+func (dc *DeploymentConfig) ScriptOptions() map[string]string {
+	options := copyOptions(dc.Deployment.Overrides)
+	options["CommitSha"] = dc.Deployment.CommitSha
+	options["AssetsTimestamp"] = dc.StartTime.UTC().Format(assetsTimestampLayout)
+	options["HostGroup"] = dc.Deployment.HostGroup
+	return options
+}
+
+// TemplateFuncs returns the functions available in the script templates. The
+// timestamp is the start of the deployment, so it's the same on every host.
+func (dc *DeploymentConfig) TemplateFuncs() template.FuncMap {
+	return TemplateFuncs(dc.StartTime, dc.LookupSecret)
 }
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScriptOptionsOverrides(t *testing.T) {
+	config := &DeploymentConfig{
+		StartTime: time.Date(2015, 1, 26, 10, 0, 0, 0, time.UTC),
+		Deployment: &Deployment{
+			CommitSha: "f133742",
+			Overrides: map[string]string{"MIGRATION_TIMEOUT": "600", "CommitSha": "0ld"},
+		},
+	}
+
+	options := config.ScriptOptions()
+	if options["MIGRATION_TIMEOUT"] != "600" {
+		t.Errorf("override missing. got=%v", options)
+	}
+	if options["CommitSha"] != "f133742" {
+		t.Errorf("override replaced the CommitSha. got=%s", options["CommitSha"])
+	}
+
+	role := &Role{
+		ScriptTemplates: map[DeploymentStage]string{"MIGRATE": "rake db:migrate TIMEOUT={{.MIGRATION_TIMEOUT}}"},
+		Options:         map[string]string{"MIGRATION_TIMEOUT": "60"},
+	}
+	scripts, err := role.RenderScripts(options, config.TemplateFuncs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scripts["MIGRATE"] != "rake db:migrate TIMEOUT=600" {
+		t.Errorf("override doesn't replace the option of the role. got=%s", scripts["MIGRATE"])
+	}
+}
+
+func TestFormatOverrides(t *testing.T) {
+	d := &Deployment{Overrides: map[string]string{"WORKERS": "4", "MIGRATION_TIMEOUT": "600"}}
+
+	expected := "MIGRATION_TIMEOUT=600\nWORKERS=4"
+	if got := d.FormatOverrides(); got != expected {
+		t.Errorf("wrong overrides. want=%q, got=%q", expected, got)
+	}
+
+	if got := (&Deployment{}).FormatOverrides(); got != "" {
+		t.Errorf("deployment without overrides formatted as %q", got)
+	}
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type DigestFrequency string
+
+const (
+	DIGEST_DAILY    DigestFrequency = "daily"
+	DIGEST_WEEKLY   DigestFrequency = "weekly"
+	DIGEST_DISABLED DigestFrequency = "disabled"
+)
+
+// The time of the day digests are sent at by default
+const defaultDigestTime = "22:00"
+
+// DigestSchedule is how often the digest of the deployments to a target is
+// sent, e.g. weekly on Fridays at 17:00 in America/New_York.
+type DigestSchedule struct {
+	Frequency DigestFrequency
+	// The day weekly digests are sent on
+	Weekday time.Weekday
+	// The minutes after midnight the digest is sent at
+	TimeOfDay int
+	// The time zone of the day and the time. nil is the time zone of the
+	// server.
+	Location *time.Location
+}
+
+// Next returns the next time after now the digest is sent.
+func (s DigestSchedule) Next(now time.Time) time.Time {
+	local := now.In(s.location())
+	year, month, day := local.Date()
+	days := 0
+	if s.Frequency == DIGEST_WEEKLY {
+		days = (int(s.Weekday) - int(local.Weekday()) + 7) % 7
+	}
+
+	next := time.Date(year, month, day+days, 0, s.TimeOfDay, 0, 0, s.location())
+	if !next.After(now) {
+		next = time.Date(year, month, day+days+s.days(), 0, s.TimeOfDay, 0, 0, s.location())
+	}
+	return next
+}
+
+// Since returns when the period covered by the digest sent at sentAt
+// started, the same time of the day before, even across a change to or from
+// daylight saving time.
+func (s DigestSchedule) Since(sentAt time.Time) time.Time {
+	return sentAt.In(s.location()).AddDate(0, 0, -s.days())
+}
+
+// Title returns the name of the digest, like "Daily" in "Applikatoni Daily
+// Digest".
+func (s DigestSchedule) Title() string {
+	if s.Frequency == DIGEST_WEEKLY {
+		return "Weekly"
+	}
+	return "Daily"
+}
+
+// Period describes the time covered by the digest, e.g. "last 7 days".
+func (s DigestSchedule) Period() string {
+	if s.Frequency == DIGEST_WEEKLY {
+		return "last 7 days"
+	}
+	return "last 24 hours"
+}
+
+func (s DigestSchedule) String() string {
+	if s.Frequency == DIGEST_DISABLED {
+		return string(s.Frequency)
+	}
+
+	at := fmt.Sprintf("at %02d:%02d %s", s.TimeOfDay/60, s.TimeOfDay%60, s.location())
+	if s.Frequency == DIGEST_WEEKLY {
+		return fmt.Sprintf("%s on %s %s", s.Frequency, weekdays[s.Weekday], at)
+	}
+	return fmt.Sprintf("%s %s", s.Frequency, at)
+}
+
+func (s DigestSchedule) location() *time.Location {
+	if s.Location == nil {
+		return time.Local
+	}
+	return s.Location
+}
+
+func (s DigestSchedule) days() int {
+	if s.Frequency == DIGEST_WEEKLY {
+		return 7
+	}
+	return 1
+}
+
+// parseDigestSchedule parses the digest_schedule, digest_weekday,
+// digest_time and digest_time_zone of a target. Weekly digests are sent on
+// Mondays if no weekday is given, all digests at 22:00 in the time zone of
+// the server if no time and time zone are given.
+func parseDigestSchedule(frequency, weekday, timeOfDay, timeZone string) (DigestSchedule, error) {
+	s := DigestSchedule{Frequency: DigestFrequency(frequency), Weekday: time.Monday}
+
+	if timeOfDay == "" {
+		timeOfDay = defaultDigestTime
+	}
+	minutes, err := parseTimeOfDay(timeOfDay)
+	if err != nil || minutes >= 24*60 {
+		return s, fmt.Errorf("invalid digest_time %q", timeOfDay)
+	}
+	s.TimeOfDay = minutes
+
+	if timeZone != "" {
+		s.Location, err = time.LoadLocation(timeZone)
+		if err != nil {
+			return s, fmt.Errorf("invalid digest_time_zone %q", timeZone)
+		}
+	}
+
+	switch s.Frequency {
+	case DIGEST_DAILY, DIGEST_DISABLED:
+	case DIGEST_WEEKLY:
+		if weekday != "" {
+			i := weekdayIndex(strings.ToLower(weekday))
+			if i == -1 {
+				return s, fmt.Errorf("invalid digest_weekday %q", weekday)
+			}
+			s.Weekday = time.Weekday(i)
+		}
+	default:
+		return s, fmt.Errorf("invalid digest_schedule %q", frequency)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// DigestSubscription is a user who gets the digests of an application by
+// email, in addition to the daily_digest_receivers.
+type DigestSubscription struct {
+	Id              int
+	UserId          int
+	User            *User
+	ApplicationName string
+	Email           string
+	// Token identifies the subscription in the unsubscribe link of the
+	// emails, which works without logging in
+	Token     string
+	CreatedAt time.Time
+}
@@ -0,0 +1,67 @@
+package models
+
+import "time"
+
+type EventType string
+
+const (
+	EVENT_DEPLOYMENT_CREATED   EventType = "deployment.created"
+	EVENT_DEPLOYMENT_STARTED   EventType = "deployment.started"
+	EVENT_DEPLOYMENT_SUCCEEDED EventType = "deployment.succeeded"
+	EVENT_DEPLOYMENT_FAILED    EventType = "deployment.failed"
+)
+
+var deploymentEventTypes = map[DeploymentState]EventType{
+	DEPLOYMENT_NEW:        EVENT_DEPLOYMENT_CREATED,
+	DEPLOYMENT_ACTIVE:     EVENT_DEPLOYMENT_STARTED,
+	DEPLOYMENT_SUCCESSFUL: EVENT_DEPLOYMENT_SUCCEEDED,
+	DEPLOYMENT_FAILED:     EVENT_DEPLOYMENT_FAILED,
+}
+
+// EventRecord is a change of the state of a deployment, kept so automation
+// platforms can poll for the changes. The fields of the deployment are copied
+// as they were when the event happened.
+type EventRecord struct {
+	Id              int
+	Type            EventType
+	DeploymentId    int
+	ApplicationName string
+	TargetName      string
+	State           DeploymentState
+	CommitSha       string
+	Branch          string
+	Tag             string
+	Comment         string
+	UserName        string
+	CreatedAt       time.Time
+}
+
+// NewEventRecord returns the event of the deployment changing to the state.
+func NewEventRecord(d *Deployment, state DeploymentState, now time.Time) *EventRecord {
+	e := &EventRecord{
+		Type:            deploymentEventTypes[state],
+		DeploymentId:    d.Id,
+		ApplicationName: d.ApplicationName,
+		TargetName:      d.TargetName,
+		State:           state,
+		CommitSha:       d.CommitSha,
+		Branch:          d.Branch,
+		Tag:             d.Tag,
+		Comment:         d.Comment,
+		CreatedAt:       now,
+	}
+	if d.User != nil {
+		e.UserName = d.User.Name
+	}
+	return e
+}
+
+// IsEventType checks whether t is one of the known event types.
+func IsEventType(t EventType) bool {
+	for _, known := range deploymentEventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,40 @@
 package models
 
+import (
+	"net"
+	"strconv"
+)
+
 type Host struct {
 	Name  string   `json:"name"`
 	Roles []string `json:"roles"`
+	// SHA256 fingerprint of the host key as printed by `ssh-keygen -l`
+	HostKeyFingerprint string `json:"host_key_fingerprint"`
+	// "blue" or "green" on blue-green targets. Hosts without a group, e.g.
+	// load balancers, are part of every deployment.
+	Group string `json:"group"`
+
+	// Port is the SSH port, 22 if it's 0 and the Name has no port. The user
+	// and SSH key replace the ones of the target, e.g. for hosts set up
+	// differently than the others.
+	Port             int    `json:"port"`
+	DeploymentUser   string `json:"deployment_user"`
+	DeploymentSshKey string `json:"deployment_ssh_key"`
+	SshKeyPassphrase string `json:"deployment_ssh_key_passphrase"`
+}
+
+// DefaultSSHPort is used for hosts without a port.
+const DefaultSSHPort = 22
+
+// Address returns the host and port SSH connects to.
+func (h *Host) Address() string {
+	host, port, err := net.SplitHostPort(h.Name)
+	if err != nil {
+		// The Name has no port
+		host, port = h.Name, strconv.Itoa(DefaultSSHPort)
+	}
+	if h.Port != 0 {
+		port = strconv.Itoa(h.Port)
+	}
+	return net.JoinHostPort(host, port)
 }
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+type HostStageState string
+
+const (
+	HOST_STAGE_RUNNING    HostStageState = "running"
+	HOST_STAGE_SUCCESSFUL HostStageState = "successful"
+	HOST_STAGE_FAILED     HostStageState = "failed"
+	// The server stopped while the stage was running on the host
+	HOST_STAGE_INTERRUPTED HostStageState = "interrupted"
+)
+
+// HostStage is the progress of a stage of a deployment on one of its hosts.
+// It's saved while the deployment runs, so the server can tell how far every
+// host got if it crashes in the middle of a deployment.
+type HostStage struct {
+	DeploymentId int
+	Host         string
+	Stage        DeploymentStage
+	State        HostStageState
+	// The command started last on the host and whether it succeeded. The
+	// next command starts right after, so a host with a finished command in
+	// an interrupted stage might have finished the stage.
+	LastCommand     string
+	LastCommandDone bool
+	UpdatedAt       time.Time
+}
@@ -0,0 +1,23 @@
+package models
+
+import "testing"
+
+func TestHostAddress(t *testing.T) {
+	tests := []struct {
+		host     *Host
+		expected string
+	}{
+		{&Host{Name: "1.web.applikatoni.com:22"}, "1.web.applikatoni.com:22"},
+		{&Host{Name: "1.web.applikatoni.com:22", Port: 2222}, "1.web.applikatoni.com:2222"},
+		{&Host{Name: "legacy.applikatoni.com", Port: 2222}, "legacy.applikatoni.com:2222"},
+		{&Host{Name: "10.0.0.1", Port: 2222}, "10.0.0.1:2222"},
+		{&Host{Name: "legacy.applikatoni.com"}, "legacy.applikatoni.com:22"},
+		{&Host{Name: "[fd00::1]:22", Port: 2222}, "[fd00::1]:2222"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.host.Address(); got != tt.expected {
+			t.Errorf("wrong address of %s. want=%s, got=%s", tt.host.Name, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	ISSUE_TRACKER_GITHUB   = "github"
+	ISSUE_TRACKER_LINEAR   = "linear"
+	ISSUE_TRACKER_SHORTCUT = "shortcut"
+)
+
+// The references of GitHub and Shortcut. The first group is the reference as
+// written, the second the id of the issue. GitHub references may name
+// another repository, Shortcut references have the sc- prefix of branch
+// names or the ch prefix of the former Clubhouse.
+var (
+	gitHubIssuePattern   = regexp.MustCompile(`(?:^|[^\w/#])((?:[\w.-]+/[\w.-]+)?#(\d+))\b`)
+	shortcutStoryPattern = regexp.MustCompile(`(?i)\b((?:sc-|ch)(\d+))\b`)
+)
+
+// IssueTracker configures the tracker of the issues the commit messages
+// refer to, so that the references can be linked.
+type IssueTracker struct {
+	// "github", "linear" or "shortcut"
+	Type string `json:"type"`
+	// The URL key of the Linear workspace or the slug of the Shortcut
+	// workspace
+	Workspace string `json:"workspace"`
+	// The keys of the Linear teams, e.g. ["ENG"], so that words like UTF-8
+	// aren't taken for issues
+	Teams []string `json:"teams"`
+}
+
+// IssueReference is a reference to an issue in a text.
+type IssueReference struct {
+	// The reference as written, e.g. "#42", "ENG-42" or "sc-42"
+	Text string
+	// The id of the issue in the tracker, e.g. "42" or "ENG-42"
+	Id string
+	// The "owner/repo" a GitHub reference names, empty for the repository of
+	// the application
+	Repo string
+	// The position of the reference in the text
+	Start, End int
+}
+
+func (it *IssueTracker) Validate() error {
+	switch it.Type {
+	case ISSUE_TRACKER_GITHUB:
+		return nil
+	case ISSUE_TRACKER_LINEAR:
+		if len(it.Teams) == 0 {
+			return fmt.Errorf("teams are required for %s", it.Type)
+		}
+	case ISSUE_TRACKER_SHORTCUT:
+	default:
+		return fmt.Errorf("unknown type %q", it.Type)
+	}
+
+	if it.Workspace == "" {
+		return fmt.Errorf("workspace is required for %s", it.Type)
+	}
+	return nil
+}
+
+func (it *IssueTracker) pattern() *regexp.Regexp {
+	switch it.Type {
+	case ISSUE_TRACKER_GITHUB:
+		return gitHubIssuePattern
+	case ISSUE_TRACKER_LINEAR:
+		keys := make([]string, len(it.Teams))
+		for i, team := range it.Teams {
+			keys[i] = regexp.QuoteMeta(team)
+		}
+		return regexp.MustCompile(`\b((?:` + strings.Join(keys, "|") + `)-\d+)\b`)
+	case ISSUE_TRACKER_SHORTCUT:
+		return shortcutStoryPattern
+	}
+	return nil
+}
+
+// References returns the references to issues in the text, in order.
+func (it *IssueTracker) References(text string) []*IssueReference {
+	refs := []*IssueReference{}
+	if it == nil {
+		return refs
+	}
+	pattern := it.pattern()
+	if pattern == nil {
+		return refs
+	}
+
+	for _, m := range pattern.FindAllStringSubmatchIndex(text, -1) {
+		ref := &IssueReference{Text: text[m[2]:m[3]], Start: m[2], End: m[3]}
+		// Linear references are their own ids
+		ref.Id = ref.Text
+		if len(m) > 4 {
+			ref.Id = text[m[4]:m[5]]
+		}
+		if it.Type == ISSUE_TRACKER_GITHUB && ref.Text[0] != '#' {
+			ref.Repo = ref.Text[:len(ref.Text)-len(ref.Id)-1]
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
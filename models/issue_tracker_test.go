@@ -0,0 +1,71 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestIssueTrackerReferences(t *testing.T) {
+	tests := []struct {
+		tracker  *IssueTracker
+		text     string
+		expected []*IssueReference
+	}{
+		{
+			&IssueTracker{Type: ISSUE_TRACKER_GITHUB},
+			"Fix the login (#42), see shipping-co/api#7",
+			[]*IssueReference{
+				{Text: "#42", Id: "42", Start: 15, End: 18},
+				{Text: "shipping-co/api#7", Id: "7", Repo: "shipping-co/api", Start: 25, End: 42},
+			},
+		},
+		{&IssueTracker{Type: ISSUE_TRACKER_GITHUB}, "Color #fff and ##3", []*IssueReference{}},
+		{
+			&IssueTracker{Type: ISSUE_TRACKER_LINEAR, Workspace: "acme", Teams: []string{"ENG", "OPS"}},
+			"ENG-12: Fix the login, UTF-8 and eng-3",
+			[]*IssueReference{{Text: "ENG-12", Id: "ENG-12", Start: 0, End: 6}},
+		},
+		{
+			&IssueTracker{Type: ISSUE_TRACKER_SHORTCUT, Workspace: "acme"},
+			"[sc-12] Fix the login (ch34), disc-5",
+			[]*IssueReference{
+				{Text: "sc-12", Id: "12", Start: 1, End: 6},
+				{Text: "ch34", Id: "34", Start: 23, End: 27},
+			},
+		},
+		{nil, "ENG-12 #42", []*IssueReference{}},
+	}
+
+	for _, tt := range tests {
+		got := tt.tracker.References(tt.text)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("wrong references in %q.", tt.text)
+			for _, r := range got {
+				t.Logf("got %+v", r)
+			}
+		}
+	}
+}
+
+func TestIssueTrackerValidate(t *testing.T) {
+	tests := []struct {
+		tracker     *IssueTracker
+		expectedErr bool
+	}{
+		{&IssueTracker{Type: ISSUE_TRACKER_GITHUB}, false},
+		{&IssueTracker{Type: ISSUE_TRACKER_LINEAR, Workspace: "acme", Teams: []string{"ENG"}}, false},
+		{&IssueTracker{Type: ISSUE_TRACKER_LINEAR, Workspace: "acme"}, true},
+		{&IssueTracker{Type: ISSUE_TRACKER_SHORTCUT}, true},
+		{&IssueTracker{Type: "jira"}, true},
+	}
+
+	for _, tt := range tests {
+		err := tt.tracker.Validate()
+		if tt.expectedErr && err == nil {
+			t.Errorf("expected error for %+v", tt.tracker)
+		}
+		if !tt.expectedErr && err != nil {
+			t.Errorf("unexpected error for %+v: %s", tt.tracker, err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+// JiraRelease configures the Jira project whose versions are released when a
+// tag or a release branch of the application is deployed.
+type JiraRelease struct {
+	// The URL of the Jira site, e.g. "https://example.atlassian.net"
+	URL string `json:"url"`
+	// The account and its API token the requests are made with
+	Email    string `json:"email"`
+	ApiToken string `json:"api_token"`
+	// The key of the project, e.g. "WEB"
+	Project string `json:"project"`
+	// The targets whose deployments release versions, e.g. ["production"]
+	Targets []string `json:"targets"`
+	// Deployments of branches starting with the prefix, e.g. "release/",
+	// release the version named like the rest of the branch
+	ReleaseBranchPrefix string `json:"release_branch_prefix"`
+	// Stripped from tags, e.g. "v" releases the version "1.2.0" for the tag
+	// "v1.2.0"
+	TagPrefix string `json:"tag_prefix"`
+	// The status the issues of the version are moved to. Defaults to
+	// "Released".
+	ReleasedStatus string `json:"released_status"`
+}
+
+// ReleasesTarget checks whether deployments to the target release versions.
+func (j *JiraRelease) ReleasesTarget(name string) bool {
+	return j != nil && isInList(name, j.Targets)
+}
+
+// VersionName returns the name of the version the deployment releases, or an
+// empty string if it deploys neither a tag nor a release branch.
+func (j *JiraRelease) VersionName(d *Deployment) string {
+	if d.Tag != "" {
+		return strings.TrimPrefix(d.Tag, j.TagPrefix)
+	}
+	if j.ReleaseBranchPrefix != "" && strings.HasPrefix(d.Branch, j.ReleaseBranchPrefix) {
+		return strings.TrimPrefix(d.Branch, j.ReleaseBranchPrefix)
+	}
+	return ""
+}
+
+// IssueKeys returns the keys of the issues of the project mentioned in the
+// comment or the changelog of the deployment, e.g. "WEB-42", each once.
+func (j *JiraRelease) IssueKeys(d *Deployment) []string {
+	pattern := regexp.MustCompile(`\b` + regexp.QuoteMeta(j.Project) + `-[0-9]+\b`)
+
+	texts := []string{d.Comment}
+	for _, e := range d.Changelog {
+		texts = append(texts, e.Message)
+	}
+
+	keys := []string{}
+	seen := map[string]bool{}
+	for _, text := range texts {
+		for _, key := range pattern.FindAllString(text, -1) {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// ReleasedStatusName returns the status released issues are moved to.
+func (j *JiraRelease) ReleasedStatusName() string {
+	if j.ReleasedStatus == "" {
+		return "Released"
+	}
+	return j.ReleasedStatus
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestJiraReleaseVersionName(t *testing.T) {
+	j := &JiraRelease{ReleaseBranchPrefix: "release/", TagPrefix: "v"}
+
+	tests := []struct {
+		deployment *Deployment
+		expected   string
+	}{
+		{&Deployment{Branch: "master", Tag: "v1.2.0"}, "1.2.0"},
+		{&Deployment{Tag: "2026.10"}, "2026.10"},
+		{&Deployment{Branch: "release/1.3"}, "1.3"},
+		{&Deployment{Branch: "master"}, ""},
+		{&Deployment{Branch: "feature/release/1.3"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := j.VersionName(tt.deployment); got != tt.expected {
+			t.Errorf("wrong version of %+v. want=%q, got=%q", tt.deployment, tt.expected, got)
+		}
+	}
+
+	if got := (&JiraRelease{}).VersionName(&Deployment{Branch: "release/1.3"}); got != "" {
+		t.Errorf("release branch without prefix released. got=%q", got)
+	}
+}
+
+func TestJiraReleaseIssueKeys(t *testing.T) {
+	j := &JiraRelease{Project: "WEB"}
+	d := &Deployment{
+		Comment: "Release WEB-1 and WEB-2",
+		Changelog: []*ChangelogEntry{
+			{Message: "WEB-2: Fix the login\n\nSee also API-7"},
+			{Message: "Merge pull request #12 (WEB-13)"},
+			{Message: "Rename NEWWEB-3"},
+		},
+	}
+
+	expected := []string{"WEB-1", "WEB-2", "WEB-13"}
+	if got := j.IssueKeys(d); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong issue keys. want=%v, got=%v", expected, got)
+	}
+}
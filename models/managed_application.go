@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// ManagedApplication is an application added or edited by an admin at
+// runtime instead of in the configuration file. The Definition is the JSON of
+// the application, with the same keys as in the configuration file.
+type ManagedApplication struct {
+	Name       string
+	Definition string
+	// UserId is the admin who saved the definition last
+	UserId    int
+	User      *User
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -9,16 +9,41 @@ type Role struct {
 	Name            string                     `json:"name"`
 	ScriptTemplates map[DeploymentStage]string `json:"script_templates"`
 	Options         map[string]string          `json:"options"`
+	SudoStages      []DeploymentStage          `json:"sudo_stages"`
+	SerialStages    []DeploymentStage          `json:"serial_stages"`
 }
 
-func (r *Role) RenderScripts(options map[string]string) (map[DeploymentStage]string, error) {
+func (r *Role) IsSudoStage(s DeploymentStage) bool {
+	for _, stage := range r.SudoStages {
+		if stage == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Serial stages are executed on one host of the role after the other, instead
+// of on all hosts in parallel.
+func (r *Role) IsSerialStage(s DeploymentStage) bool {
+	for _, stage := range r.SerialStages {
+		if stage == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderScripts renders the script templates with the options of the role
+// and the given options, which take precedence. funcs are the functions the
+// templates can call, see TemplateFuncs.
+func (r *Role) RenderScripts(options map[string]string, funcs template.FuncMap) (map[DeploymentStage]string, error) {
 	rendered := make(map[DeploymentStage]string)
 	mergedOptions := mergeOptions(copyOptions(r.Options), options)
 
 	for stage, scriptTemplate := range r.ScriptTemplates {
 		var b bytes.Buffer
 
-		tmpl, err := template.New(string(stage)).Parse(scriptTemplate)
+		tmpl, err := template.New(string(stage)).Funcs(funcs).Parse(scriptTemplate)
 		if err != nil {
 			return nil, err
 		}
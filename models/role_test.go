@@ -54,7 +54,7 @@ var renderTests = []struct {
 func TestRender(t *testing.T) {
 	for _, tt := range renderTests {
 		role := &Role{ScriptTemplates: tt.templates, Options: tt.roleOptions}
-		result, err := role.RenderScripts(tt.optionsToMerge)
+		result, err := role.RenderScripts(tt.optionsToMerge, nil)
 		if err != nil {
 			t.Errorf("RenderScripts returned error. err=%s", err)
 		}
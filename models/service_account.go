@@ -0,0 +1,18 @@
+package models
+
+// ServiceAccount is a machine user, e.g. for a CI pipeline. It can't log into
+// the UI and may only use its API token for the targets it is scoped to.
+type ServiceAccount struct {
+	Name     string `json:"name"`
+	ApiToken string `json:"api_token"`
+	// Targets maps application names to the targets the account may deploy
+	Targets map[string][]string `json:"targets"`
+}
+
+func (s *ServiceAccount) CanRead(application string) bool {
+	return len(s.Targets[application]) > 0
+}
+
+func (s *ServiceAccount) CanDeploy(application, target string) bool {
+	return isInList(target, s.Targets[application])
+}
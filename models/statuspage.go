@@ -0,0 +1,43 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	STATUSPAGE_MAINTENANCE = "maintenance"
+	STATUSPAGE_INCIDENT    = "incident"
+)
+
+// Statuspage configures the Statuspage.io page deployments to a target are
+// announced on. A scheduled maintenance or an incident is posted when a
+// deployment starts and resolved when it finished or failed.
+type Statuspage struct {
+	// The API key of a Statuspage user and the ID of the page
+	ApiKey string `json:"api_key"`
+	PageId string `json:"page_id"`
+	// "maintenance" (default) or "incident"
+	Type string `json:"type"`
+	// The components affected by the deployments. They're shown as under
+	// maintenance during scheduled maintenances.
+	ComponentIds []string `json:"component_ids"`
+	// The subscribers of the page are notified about the deployments
+	NotifySubscribers bool `json:"notify_subscribers"`
+}
+
+// IsMaintenance checks whether deployments are posted as scheduled
+// maintenances.
+func (s *Statuspage) IsMaintenance() bool {
+	return s.Type == "" || s.Type == STATUSPAGE_MAINTENANCE
+}
+
+func (s *Statuspage) Validate() error {
+	if s.ApiKey == "" || s.PageId == "" {
+		return errors.New("api_key and page_id are required")
+	}
+	if s.Type != "" && s.Type != STATUSPAGE_MAINTENANCE && s.Type != STATUSPAGE_INCIDENT {
+		return fmt.Errorf("unknown type %q", s.Type)
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package models
+
+import "testing"
+
+func TestStatuspageValidate(t *testing.T) {
+	tests := []struct {
+		statuspage *Statuspage
+		valid      bool
+	}{
+		{&Statuspage{ApiKey: "key", PageId: "page"}, true},
+		{&Statuspage{ApiKey: "key", PageId: "page", Type: STATUSPAGE_INCIDENT}, true},
+		{&Statuspage{ApiKey: "key", PageId: "page", Type: "outage"}, false},
+		{&Statuspage{PageId: "page"}, false},
+		{&Statuspage{ApiKey: "key"}, false},
+	}
+
+	for _, tt := range tests {
+		if err := tt.statuspage.Validate(); (err == nil) != tt.valid {
+			t.Errorf("wrong validation of %+v. want valid=%t, got err=%v", tt.statuspage, tt.valid, err)
+		}
+	}
+
+	if !(&Statuspage{}).IsMaintenance() || (&Statuspage{Type: STATUSPAGE_INCIDENT}).IsMaintenance() {
+		t.Errorf("wrong default type")
+	}
+}
@@ -1,10 +1,19 @@
 package models
 
+import (
+	"path"
+	"time"
+)
+
 type Target struct {
 	Name             string            `json:"name"`
 	DeploymentUser   string            `json:"deployment_user"`
 	DeploymentSshKey string            `json:"deployment_ssh_key"`
+	SudoPassword     string            `json:"deployment_sudo_password"`
+	SshKeyPassphrase string            `json:"deployment_ssh_key_passphrase"`
+	KnownHostsFile   string            `json:"known_hosts_file"`
 	DeployUsernames  []string          `json:"deploy_usernames"`
+	DeployGroups     []string          `json:"deploy_groups"`
 	Hosts            []*Host           `json:"hosts"`
 	Roles            []*Role           `json:"roles"`
 	AvailableStages  []DeploymentStage `json:"available_stages"`
@@ -15,12 +24,181 @@ type Target struct {
 	NewRelicAppId    string            `json:"new_relic_app_id"`
 	SlackUrl         string            `json:"slack_url"`
 	Webhooks         []string          `json:"webhooks"`
+
+	// Every reader of the application may deploy to the target
+	DeployableByReaders bool `json:"deployable_by_readers"`
+	// Deployers need two-factor authentication enabled on GitHub
+	RequireTwoFactorAuth bool `json:"require_two_factor_auth"`
+	// Deployments only start once a second user who can deploy to the target
+	// approved them (four-eyes principle)
+	RequireSecondApproval bool `json:"require_second_approval"`
+	// Only commits whose CI status on GitHub is successful may be deployed
+	RequirePassingCI bool `json:"require_passing_ci"`
+	// Deployers may deploy commits without passing CI if they give a reason
+	AllowCIOverride bool `json:"allow_ci_override"`
+	// Patterns like "release/*" of the branches that may be deployed. All
+	// branches may be deployed if it's empty.
+	DeployableBranches []string `json:"deployable_branches"`
+	// Variables like "MIGRATION_TIMEOUT" deployers may set for a single
+	// deployment. They override the options of the roles in the script
+	// templates.
+	OverridableVariables []string `json:"overridable_variables"`
+
+	PreDeploymentHooks  []string `json:"pre_deployment_hooks"`
+	PostDeploymentHooks []string `json:"post_deployment_hooks"`
+
+	DeploymentTimeout string `json:"deployment_timeout"`
+
+	BlueGreen *BlueGreen `json:"blue_green"`
+
+	// Queue deployments while another deployment to the target is running,
+	// instead of rejecting them
+	QueueDeployments bool `json:"queue_deployments"`
+
+	// Failed deployments are retried up to AutoRetryAttempts times
+	AutoRetryAttempts int    `json:"auto_retry_attempts"`
+	AutoRetryDelay    string `json:"auto_retry_delay"`
+
+	// Stages that don't run any commands but pause the deployment until a
+	// deployer approves to continue
+	PauseStages          []DeploymentStage `json:"pause_stages"`
+	PauseTimeout         string            `json:"pause_timeout"`
+	PauseTimeoutContinue bool              `json:"pause_timeout_continue"`
+	// The Slack incoming webhook deployments waiting for approval are posted
+	// to, with buttons to approve or reject them
+	ApprovalSlackUrl string `json:"approval_slack_url"`
+	// The Microsoft Teams incoming webhook deployments waiting for approval
+	// are posted to as actionable message cards
+	ApprovalTeamsUrl string `json:"approval_teams_url"`
+
+	// Deployments are announced on the status page while they're running
+	Statuspage *Statuspage `json:"statuspage"`
+
+	// Everyone may see the status badge of the last deployment, without
+	// logging in
+	PublicBadge bool `json:"public_badge"`
+
+	// Deployments are only allowed inside one of the windows, unless an admin
+	// overrides them. Always allowed if it's empty.
+	DeployWindows []*DeployWindow `json:"deploy_windows"`
+
+	// How often the digest of the deployments is sent: "daily", "weekly" on
+	// the DigestWeekday, e.g. "fri", or "disabled"
+	DigestSchedule string `json:"digest_schedule"`
+	DigestWeekday  string `json:"digest_weekday"`
+	// When the digest is sent, e.g. "18:00" in "America/New_York". The
+	// deployments in the digest are shown in the DigestTimeZone too.
+	DigestTime     string `json:"digest_time"`
+	DigestTimeZone string `json:"digest_time_zone"`
+	// The digest is posted to the Slack incoming webhook and to the channel
+	// with the slack_bot_token, in addition to the email
+	DigestSlackUrl     string `json:"digest_slack_url"`
+	DigestSlackChannel string `json:"digest_slack_channel"`
 }
 
 func (t *Target) IsDeployer(userName string) bool {
 	return isInList(userName, t.DeployUsernames)
 }
 
+// CanDeploy checks whether the user is listed in the DeployUsernames, by its
+// QualifiedName, or is a member of one of the DeployGroups.
+func (t *Target) CanDeploy(u *User) bool {
+	return t.IsDeployer(u.QualifiedName()) || isInAnyList(u.Groups, t.DeployGroups)
+}
+
+// Timeout returns how long a deployment to this target may take before it's
+// aborted. 0 means no timeout.
+func (t *Target) Timeout() (time.Duration, error) {
+	if t.DeploymentTimeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(t.DeploymentTimeout)
+}
+
+// ApprovalTimeout returns how long a pause stage waits for approval. 0 means
+// it waits until the deployment is killed or times out.
+func (t *Target) ApprovalTimeout() (time.Duration, error) {
+	if t.PauseTimeout == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(t.PauseTimeout)
+}
+
+// RetryDelay returns how long to wait before automatically retrying a failed
+// deployment. Defaults to one minute.
+func (t *Target) RetryDelay() (time.Duration, error) {
+	if t.AutoRetryDelay == "" {
+		return time.Minute, nil
+	}
+	return time.ParseDuration(t.AutoRetryDelay)
+}
+
+// IsOverridable checks whether the variable is one of the
+// OverridableVariables.
+func (t *Target) IsOverridable(name string) bool {
+	return isInList(name, t.OverridableVariables)
+}
+
+// IsDeployableBranch checks whether the branch matches one of the
+// DeployableBranches. Deployments without a branch only match if the target
+// has no DeployableBranches.
+func (t *Target) IsDeployableBranch(branch string) bool {
+	if len(t.DeployableBranches) == 0 {
+		return true
+	}
+
+	for _, pattern := range t.DeployableBranches {
+		if matched, _ := path.Match(pattern, branch); matched && branch != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// InDeployWindow checks whether deployments at t are inside one of the
+// DeployWindows. It's true if the target has no DeployWindows.
+func (t *Target) InDeployWindow(at time.Time) bool {
+	if len(t.DeployWindows) == 0 {
+		return true
+	}
+
+	for _, w := range t.DeployWindows {
+		if w.Contains(at) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSlackDigest checks whether the digest is posted to Slack.
+func (t *Target) HasSlackDigest() bool {
+	return t.DigestSlackUrl != "" || t.DigestSlackChannel != ""
+}
+
+func (t *Target) IsBlueGreen() bool {
+	return t.BlueGreen != nil
+}
+
+// GroupHosts returns the hosts of the group and all hosts without a group.
+func (t *Target) GroupHosts(group string) []*Host {
+	hosts := []*Host{}
+	for _, h := range t.Hosts {
+		if h.Group == "" || h.Group == group {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// DeploymentHosts returns the hosts the deployment runs on, which are only
+// those of its group on blue-green targets.
+func (t *Target) DeploymentHosts(d *Deployment) []*Host {
+	if t.IsBlueGreen() {
+		return t.GroupHosts(d.HostGroup)
+	}
+	return t.Hosts
+}
+
 func (t *Target) IsDefaultStage(s DeploymentStage) bool {
 	for _, def := range t.DefaultStages {
 		if def == s {
@@ -1,6 +1,9 @@
 package models
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestValidStages(t *testing.T) {
 	availableStages := []DeploymentStage{"ONE", "TWO", "THREE", "FOUR"}
@@ -32,3 +35,180 @@ func TestValidStages(t *testing.T) {
 		}
 	}
 }
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		timeout  string
+		expected time.Duration
+		err      bool
+	}{
+		{"", 0, false},
+		{"30m", 30 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"thirty minutes", 0, true},
+	}
+
+	for _, tt := range tests {
+		target := &Target{DeploymentTimeout: tt.timeout}
+		got, err := target.Timeout()
+		if tt.err && err == nil {
+			t.Errorf("expected error for %q, got none", tt.timeout)
+		}
+		if !tt.err && err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.timeout, err)
+		}
+		if got != tt.expected {
+			t.Errorf("wrong timeout. want=%s, got=%s", tt.expected, got)
+		}
+	}
+}
+
+func TestIsDeployableBranch(t *testing.T) {
+	target := &Target{DeployableBranches: []string{"master", "release/*"}}
+
+	tests := []struct {
+		branch   string
+		expected bool
+	}{
+		{"master", true},
+		{"release/1.0", true},
+		{"release/1.0/hotfix", false},
+		{"feature/pizza", false},
+		{"master-backup", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := target.IsDeployableBranch(tt.branch); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%t, got=%t", tt.branch, tt.expected, got)
+		}
+	}
+
+	if !(&Target{}).IsDeployableBranch("feature/pizza") {
+		t.Errorf("expected all branches to be deployable without deployable_branches")
+	}
+}
+
+func TestCanDeploy(t *testing.T) {
+	target := &Target{
+		DeployUsernames: []string{"mrnugget"},
+		DeployGroups:    []string{"ops"},
+	}
+
+	tests := []struct {
+		user     *User
+		expected bool
+	}{
+		{&User{Name: "mrnugget"}, true},
+		{&User{Name: "fabrik42"}, false},
+		{&User{Name: "fabrik42", Groups: []string{"developers", "ops"}}, true},
+		{&User{Name: "fabrik42", Groups: []string{"developers"}}, false},
+		{&User{Name: "mrnugget", Provider: "github"}, true},
+		// Users of other login providers are listed as <provider>:<name>
+		{&User{Name: "mrnugget", Provider: "gitlab"}, false},
+	}
+
+	for _, tt := range tests {
+		got := target.CanDeploy(tt.user)
+		if got != tt.expected {
+			t.Errorf("wrong result for %s (groups %v). want=%t, got=%t", tt.user.Name, tt.user.Groups, tt.expected, got)
+		}
+	}
+}
+
+func TestDeploymentHosts(t *testing.T) {
+	hosts := []*Host{
+		{Name: "lb.example.com"},
+		{Name: "blue.example.com", Group: BLUE_GROUP},
+		{Name: "green.example.com", Group: GREEN_GROUP},
+	}
+
+	tests := []struct {
+		blueGreen *BlueGreen
+		hostGroup string
+		expected  []string
+	}{
+		{nil, "", []string{"lb.example.com", "blue.example.com", "green.example.com"}},
+		{&BlueGreen{}, BLUE_GROUP, []string{"lb.example.com", "blue.example.com"}},
+		{&BlueGreen{}, GREEN_GROUP, []string{"lb.example.com", "green.example.com"}},
+	}
+
+	for _, tt := range tests {
+		target := &Target{Hosts: hosts, BlueGreen: tt.blueGreen}
+		got := target.DeploymentHosts(&Deployment{HostGroup: tt.hostGroup})
+
+		if len(got) != len(tt.expected) {
+			t.Errorf("wrong number of hosts for %q. want=%d, got=%d", tt.hostGroup, len(tt.expected), len(got))
+			continue
+		}
+		for i, h := range got {
+			if h.Name != tt.expected[i] {
+				t.Errorf("wrong host for %q. want=%s, got=%s", tt.hostGroup, tt.expected[i], h.Name)
+			}
+		}
+	}
+}
+
+func TestInDeployWindow(t *testing.T) {
+	at := func(s string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	officeHours := &DeployWindow{Days: []string{"mon-thu"}, Start: "09:00", End: "17:00", TimeZone: "Europe/Berlin"}
+	overnight := &DeployWindow{Days: []string{"fri"}, Start: "22:00", End: "02:00"}
+	weekend := &DeployWindow{Days: []string{"fri-mon"}, Start: "00:00", End: "24:00"}
+
+	tests := []struct {
+		windows  []*DeployWindow
+		at       string
+		expected bool
+	}{
+		{nil, "2026-10-24T12:00:00Z", true},
+		{[]*DeployWindow{officeHours}, "2026-10-19T07:00:00Z", true},
+		{[]*DeployWindow{officeHours}, "2026-10-19T06:59:00Z", false},
+		{[]*DeployWindow{officeHours}, "2026-10-22T14:59:00Z", true},
+		{[]*DeployWindow{officeHours}, "2026-10-22T15:00:00Z", false},
+		{[]*DeployWindow{officeHours}, "2026-10-23T10:00:00Z", false},
+		{[]*DeployWindow{overnight}, "2026-10-23T23:00:00Z", true},
+		{[]*DeployWindow{overnight}, "2026-10-24T01:00:00Z", true},
+		{[]*DeployWindow{overnight}, "2026-10-24T23:00:00Z", false},
+		{[]*DeployWindow{overnight}, "2026-10-23T01:00:00Z", false},
+		{[]*DeployWindow{weekend}, "2026-10-25T12:00:00Z", true},
+		{[]*DeployWindow{weekend}, "2026-10-21T12:00:00Z", false},
+		{[]*DeployWindow{officeHours, weekend}, "2026-10-21T12:00:00Z", true},
+	}
+
+	for _, tt := range tests {
+		target := &Target{DeployWindows: tt.windows}
+		result := target.InDeployWindow(at(tt.at))
+		if result != tt.expected {
+			t.Errorf("wrong result for %s. want=%v, got=%v", tt.at, tt.expected, result)
+		}
+	}
+}
+
+func TestValidateDeployWindow(t *testing.T) {
+	tests := []struct {
+		window *DeployWindow
+		valid  bool
+	}{
+		{&DeployWindow{Days: []string{"mon-thu", "Sat"}, Start: "09:00", End: "17:30", TimeZone: "Europe/Berlin"}, true},
+		{&DeployWindow{Start: "22:00", End: "06:00"}, true},
+		{&DeployWindow{Days: []string{"funday"}, Start: "09:00", End: "17:00"}, false},
+		{&DeployWindow{Days: []string{"mon-"}, Start: "09:00", End: "17:00"}, false},
+		{&DeployWindow{Start: "9am", End: "17:00"}, false},
+		{&DeployWindow{Start: "09:00", End: "09:00"}, false},
+		{&DeployWindow{Start: "09:00", End: "17:00", TimeZone: "Mars/Olympus_Mons"}, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.window.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("wrong validation of %s. want valid=%v, got err=%v", tt.window, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultTimestampLayout is used by the timestamp template function if no
+// layout is given.
+const DefaultTimestampLayout = "20060102150405"
+
+// SecretLookup returns the secret a reference like
+// vault:secret/applikatoni#token points to.
+type SecretLookup func(ref string) (string, error)
+
+var errNoSecretLookup = errors.New("secrets can't be looked up here")
+
+// TemplateFuncs returns the functions available in script templates and
+// notification templates:
+//
+//	env "NAME"                 the environment variable of the Applikatoni server
+//	secret "vault:path#key"    the secret the reference points to
+//	shortSha .CommitSha        the first 7 characters of the SHA
+//	timestamp "2006-01-02"     now in UTC, formatted with the layout
+//	default "value" .Option    the value, if the option is empty
+//	join "," .List             the elements of the list joined with the separator
+//
+// Secrets can't be looked up if lookupSecret is nil.
+func TemplateFuncs(now time.Time, lookupSecret SecretLookup) template.FuncMap {
+	return template.FuncMap{
+		"env": os.Getenv,
+		"secret": func(ref string) (string, error) {
+			if lookupSecret == nil {
+				return "", errNoSecretLookup
+			}
+			return lookupSecret(ref)
+		},
+		"shortSha": ShortSha,
+		"timestamp": func(layout ...string) string {
+			if len(layout) == 0 {
+				return now.UTC().Format(DefaultTimestampLayout)
+			}
+			return now.UTC().Format(layout[0])
+		},
+		"default": defaultValue,
+		"join":    join,
+	}
+}
+
+// defaultValue returns value, or def if value is empty. The order of the
+// arguments allows {{.Option | default "value"}}.
+func defaultValue(def, value interface{}) interface{} {
+	if value == nil {
+		return def
+	}
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		if v.Len() == 0 {
+			return def
+		}
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return def
+		}
+	}
+	return value
+}
+
+// join joins the elements of a slice, formatted with fmt.Sprint, with sep.
+func join(sep string, list interface{}) (string, error) {
+	if strs, ok := list.([]string); ok {
+		return strings.Join(strs, sep), nil
+	}
+
+	v := reflect.ValueOf(list)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: can't join %T", list)
+	}
+	elements := make([]string, v.Len())
+	for i := range elements {
+		elements[i] = fmt.Sprint(v.Index(i).Interface())
+	}
+	return strings.Join(elements, sep), nil
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestTemplateFuncs(t *testing.T) {
+	os.Setenv("APPLIKATONI_TEST_REGION", "eu-west-1")
+	defer os.Unsetenv("APPLIKATONI_TEST_REGION")
+
+	now := time.Date(2026, 10, 16, 9, 30, 5, 0, time.FixedZone("CEST", 2*60*60))
+	lookupSecret := func(ref string) (string, error) {
+		if ref == "vault:secret/app#token" {
+			return "s3cret", nil
+		}
+		return "", errors.New("not found")
+	}
+	funcs := TemplateFuncs(now, lookupSecret)
+
+	data := map[string]interface{}{
+		"CommitSha": "f0e4c2f76c58916ec258f246851bea091d14d4247a2fc3e18694461b1816e13b",
+		"Empty":     "",
+		"Dir":       "/srv/app",
+		"Hosts":     []string{"web1", "web2"},
+		"Ports":     []int{80, 443},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+	}{
+		{`{{env "APPLIKATONI_TEST_REGION"}}`, "eu-west-1"},
+		{`{{secret "vault:secret/app#token"}}`, "s3cret"},
+		{`{{shortSha .CommitSha}}`, "f0e4c2f"},
+		{`{{.CommitSha | shortSha}}`, "f0e4c2f"},
+		{`{{timestamp}}`, "20261016073005"},
+		{`{{timestamp "2006-01-02"}}`, "2026-10-16"},
+		{`{{.Empty | default "/var/www"}}`, "/var/www"},
+		{`{{.Dir | default "/var/www"}}`, "/srv/app"},
+		{`{{.Missing | default "none"}}`, "none"},
+		{`{{env "APPLIKATONI_TEST_UNSET" | default "us-east-1"}}`, "us-east-1"},
+		{`{{join "," .Hosts}}`, "web1,web2"},
+		{`{{.Ports | join " "}}`, "80 443"},
+	}
+
+	for _, tt := range tests {
+		tmpl, err := template.New("test").Funcs(funcs).Parse(tt.template)
+		if err != nil {
+			t.Fatalf("parsing %s failed: %s", tt.template, err)
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			t.Errorf("executing %s failed: %s", tt.template, err)
+			continue
+		}
+		if b.String() != tt.expected {
+			t.Errorf("wrong result of %s. want=%q, got=%q", tt.template, tt.expected, b.String())
+		}
+	}
+}
+
+func TestTemplateFuncsErrors(t *testing.T) {
+	tests := []struct {
+		lookupSecret SecretLookup
+		template     string
+		err          string
+	}{
+		{nil, `{{secret "vault:secret/app#token"}}`, "secrets can't be looked up here"},
+		{func(string) (string, error) { return "", errors.New("permission denied") }, `{{secret "vault:secret/app#token"}}`, "permission denied"},
+		{nil, `{{join "," .Dir}}`, "can't join string"},
+	}
+
+	for _, tt := range tests {
+		tmpl := template.Must(template.New("test").Funcs(TemplateFuncs(time.Now(), tt.lookupSecret)).Parse(tt.template))
+		err := tmpl.Execute(&bytes.Buffer{}, map[string]string{"Dir": "/srv/app"})
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.template, tt.err, err)
+		}
+	}
+}
@@ -1,9 +1,109 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 type User struct {
 	Name        string `json:"login"`
 	Id          int    `json:"id"`
 	AccessToken string
 	AvatarUrl   string `json:"avatar_url"`
 	ApiToken    string
+	// Only loaded when managing the token. Nil if unknown or never used.
+	ApiTokenCreatedAt  *time.Time
+	ApiTokenLastUsedAt *time.Time
+	// RefreshToken and TokenExpiry are only set if the login provider issues
+	// expiring access tokens.
+	RefreshToken string
+	TokenExpiry  *time.Time
+	// Provider is the name of the OAuth2 provider the user logged in with
+	// and ProviderId the id of the user at that provider.
+	Provider   string
+	ProviderId string
+	// Groups the user is a member of, as reported by the login provider
+	Groups []string
+	// DeactivatedAt is set if an admin deactivated the user
+	DeactivatedAt *time.Time
+	// ServiceAccount is set if the user is a configured service account
+	ServiceAccount *ServiceAccount `json:"-"`
+	// Preferences of the user for the web interface
+	Preferences UserPreferences `json:"-"`
+}
+
+const THEME_DARK = "dark"
+
+const (
+	NOTIFY_DEPLOYMENTS_FAILED   = "failed"
+	NOTIFY_DEPLOYMENTS_FINISHED = "finished"
+)
+
+// UserPreferences change how the web interface is shown to a user. The zero
+// value are the defaults.
+type UserPreferences struct {
+	// Theme is empty for the light theme or THEME_DARK
+	Theme string
+	// TimeZone is the name of an IANA time zone, e.g. Europe/Berlin. Empty
+	// for the time zone of the server.
+	TimeZone string
+	// DefaultApplication is shown instead of the list of applications
+	DefaultApplication string
+	// DeploymentNotifications is empty if the user doesn't want desktop
+	// notifications about the own deployments, NOTIFY_DEPLOYMENTS_FAILED or
+	// NOTIFY_DEPLOYMENTS_FINISHED
+	DeploymentNotifications string
+	// Language is the code of the language the web interface is shown in,
+	// e.g. de. Empty for the language preferred by the browser.
+	Language string
+}
+
+// Location returns the time zone the times are shown in. Unknown time zones
+// fall back to the one of the server.
+func (p UserPreferences) Location() *time.Location {
+	if p.TimeZone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(p.TimeZone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// githubProvider is the Provider of users who logged in with GitHub. Users
+// without a Provider logged in before there were other login providers.
+const githubProvider = "github"
+
+// QualifiedName returns the name the user is listed with in the
+// admin_usernames, read_usernames and deploy_usernames: the login of GitHub
+// users and <provider>:<name> for the other login providers, e.g.
+// gitlab:mrnugget. Users of different providers with the same name can't
+// take each other's access that way.
+func (u *User) QualifiedName() string {
+	if u.Provider == "" || u.Provider == githubProvider {
+		return u.Name
+	}
+	return u.Provider + ":" + u.Name
+}
+
+// SplitQualifiedName returns the provider and the name of a QualifiedName.
+func SplitQualifiedName(qualified string) (provider, name string) {
+	if i := strings.Index(qualified, ":"); i != -1 {
+		return qualified[:i], qualified[i+1:]
+	}
+	return githubProvider, qualified
+}
+
+func (u *User) IsDeactivated() bool {
+	return u.DeactivatedAt != nil
+}
+
+// DisplayName returns the name of the user, marked if the user has been
+// deactivated.
+func (u *User) DisplayName() string {
+	if u.IsDeactivated() {
+		return u.Name + " (deactivated)"
+	}
+	return u.Name
 }
@@ -0,0 +1,14 @@
+package models
+
+import "time"
+
+// UserSession is a login of a user in a browser. Deleting it logs the
+// browser out.
+type UserSession struct {
+	Id         string
+	UserId     int
+	SourceIP   string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUserPreferencesLocation(t *testing.T) {
+	tests := []struct {
+		timeZone string
+		expected string
+	}{
+		{"", time.Local.String()},
+		{"Europe/Berlin", "Europe/Berlin"},
+		{"Mars/Olympus_Mons", time.Local.String()},
+	}
+
+	for _, tt := range tests {
+		p := UserPreferences{TimeZone: tt.timeZone}
+		if got := p.Location().String(); got != tt.expected {
+			t.Errorf("wrong location of %q. want=%s, got=%s", tt.timeZone, tt.expected, got)
+		}
+	}
+}
+
+func TestUserQualifiedName(t *testing.T) {
+	tests := []struct {
+		user     *User
+		expected string
+	}{
+		{&User{Name: "mrnugget"}, "mrnugget"},
+		{&User{Name: "mrnugget", Provider: "github"}, "mrnugget"},
+		{&User{Name: "mrnugget", Provider: "gitlab"}, "gitlab:mrnugget"},
+		{&User{Name: "mrnugget@example.com", Provider: "oidc"}, "oidc:mrnugget@example.com"},
+	}
+
+	for _, tt := range tests {
+		got := tt.user.QualifiedName()
+		if got != tt.expected {
+			t.Errorf("wrong qualified name. want=%s, got=%s", tt.expected, got)
+		}
+
+		provider, name := SplitQualifiedName(got)
+		if name != tt.user.Name || (tt.user.Provider != "" && provider != tt.user.Provider) {
+			t.Errorf("wrong split of %s. got=%s, %s", got, provider, name)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// activeDeployment is a running deployment shown on the admin page of the
+// active deployments, with its progress through its stages.
+type activeDeployment struct {
+	Deployment *models.Deployment
+	// The number of stages that succeeded so far
+	StagesDone int
+	// The stage that is running and when it started. Empty before the first
+	// stage and between stages.
+	CurrentStage models.DeploymentStage
+	StageSince   time.Time
+	// Whether the deployment waits in a pause stage for its approval
+	WaitingForApproval bool
+}
+
+// Progress returns the percentage of the stages that succeeded. Deployments
+// created before the stages were saved have no progress.
+func (a *activeDeployment) Progress() int {
+	if len(a.Deployment.Stages) == 0 {
+		return 0
+	}
+	return a.StagesDone * 100 / len(a.Deployment.Stages)
+}
+
+// getActiveDeployments loads the active deployments of all applications with
+// their progress, the longest running first.
+func getActiveDeployments(pending []PendingApproval) ([]*activeDeployment, error) {
+	deployments, err := getAllActiveDeployments(db)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadDeploymentsUsers(db, deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := getActiveStageEntries(db)
+	if err != nil {
+		return nil, err
+	}
+
+	waiting := map[int]bool{}
+	for _, p := range pending {
+		waiting[p.DeploymentId] = true
+	}
+
+	active := []*activeDeployment{}
+	byId := map[int]*activeDeployment{}
+	for _, d := range deployments {
+		a := &activeDeployment{Deployment: d, WaitingForApproval: waiting[d.Id]}
+		byId[d.Id] = a
+		active = append(active, a)
+	}
+
+	for _, e := range entries {
+		a, ok := byId[e.DeploymentId]
+		if !ok {
+			continue
+		}
+
+		switch e.EntryType {
+		case deploy.STAGE_START:
+			a.CurrentStage = models.DeploymentStage(e.Message)
+			a.StageSince = e.Timestamp
+		case deploy.STAGE_SUCCESS:
+			a.StagesDone++
+			a.CurrentStage = ""
+		}
+	}
+
+	return active, nil
+}
+
+// adminActiveDeploymentsHandler lists the active deployments of all
+// applications, e.g. to see who is shipping what during an incident.
+func adminActiveDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	deployments, err := getActiveDeployments(approvalRegistry.Pending())
+	if err != nil {
+		requestLogger(r).Error("error loading active deployments", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "admin_active_deployments.tmpl", map[string]interface{}{
+		"Applications":      getConfig().Applications,
+		"ActiveDeployments": deployments,
+		"currentUser":       getCurrentUser(r),
+	})
+}
+
+// cancelActiveDeploymentHandler cancels a deployment of any application from
+// the admin page of the active deployments.
+func cancelActiveDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["deploymentId"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = cancelDeployment(deployment)
+	if err != nil && err != errNotCancelable {
+		requestLogger(r).Error("could not cancel deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err == errNotCancelable {
+		addFlash(w, r, "Deployment %d can't be canceled, it's not running on this server.", deployment.Id)
+	} else {
+		recordAuditEvent(r, getCurrentUser(r), models.AUDIT_DEPLOYMENT_CANCEL, deploymentAuditSubject(deployment))
+		addFlash(w, r, "Deployment %d of %s to %s is being canceled.", deployment.Id, deployment.ApplicationName, deployment.TargetName)
+	}
+
+	http.Redirect(w, r, "/admin/deployments", http.StatusSeeOther)
+}
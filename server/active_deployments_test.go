@@ -0,0 +1,76 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestGetActiveDeployments(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+
+	// Finished before the running deployment to the same target started
+	finished := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, finished))
+	checkErr(t, updateDeploymentState(db, finished, models.DEPLOYMENT_SUCCESSFUL))
+
+	running := buildDeployment(user.Id)
+	running.Stages = []models.DeploymentStage{"CHECKOUT", "MIGRATE", "KICK_OFF", "APPROVAL"}
+	checkErr(t, createDeployment(db, running))
+	checkErr(t, updateDeploymentState(db, running, models.DEPLOYMENT_ACTIVE))
+
+	waiting := buildDeployment(user.Id)
+	waiting.ApplicationName = "api"
+	waiting.Stages = []models.DeploymentStage{"CHECKOUT", "APPROVAL"}
+	checkErr(t, createDeployment(db, waiting))
+	checkErr(t, updateDeploymentState(db, waiting, models.DEPLOYMENT_ACTIVE))
+
+	start := time.Now().Add(-time.Minute)
+	entries := []*deploy.LogEntry{
+		{DeploymentId: running.Id, EntryType: deploy.STAGE_START, Message: "CHECKOUT", Timestamp: start},
+		{DeploymentId: running.Id, EntryType: deploy.STAGE_SUCCESS, Message: "CHECKOUT", Timestamp: start.Add(time.Second)},
+		{DeploymentId: running.Id, EntryType: deploy.STAGE_START, Message: "MIGRATE", Timestamp: start.Add(2 * time.Second)},
+		{DeploymentId: waiting.Id, EntryType: deploy.STAGE_START, Message: "CHECKOUT", Timestamp: start},
+		{DeploymentId: waiting.Id, EntryType: deploy.STAGE_SUCCESS, Message: "CHECKOUT", Timestamp: start.Add(time.Second)},
+		{DeploymentId: finished.Id, EntryType: deploy.STAGE_START, Message: "CHECKOUT", Timestamp: start},
+	}
+	for _, e := range entries {
+		checkErr(t, createLogEntry(db, e))
+	}
+
+	pending := []PendingApproval{{DeploymentId: waiting.Id, Stage: "APPROVAL", Since: time.Now()}}
+
+	active, err := getActiveDeployments(pending)
+	checkErr(t, err)
+
+	if len(active) != 2 {
+		t.Fatalf("wrong number of active deployments. want=2, got=%d", len(active))
+	}
+
+	r, w := active[0], active[1]
+	if r.Deployment.Id != running.Id || w.Deployment.Id != waiting.Id {
+		t.Fatalf("wrong active deployments. want=%d,%d, got=%d,%d", running.Id, waiting.Id, r.Deployment.Id, w.Deployment.Id)
+	}
+	if r.Deployment.User == nil || r.Deployment.User.Name != "mrnugget" {
+		t.Errorf("user of deployment not loaded. got=%+v", r.Deployment.User)
+	}
+
+	if r.StagesDone != 1 || r.CurrentStage != "MIGRATE" || r.Progress() != 25 || r.WaitingForApproval {
+		t.Errorf("wrong progress of running deployment. got=%+v", r)
+	}
+	if r.StageSince.Unix() != start.Add(2*time.Second).Unix() {
+		t.Errorf("wrong start of current stage. want=%s, got=%s", start.Add(2*time.Second), r.StageSince)
+	}
+	if w.StagesDone != 1 || w.CurrentStage != "" || w.Progress() != 50 || !w.WaitingForApproval {
+		t.Errorf("wrong progress of waiting deployment. got=%+v", w)
+	}
+}
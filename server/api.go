@@ -0,0 +1,1057 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// The JSON API is versioned by its path. Fields may be added to the responses
+// of v1, but they are never renamed, removed or change their meaning. Breaking
+// changes go into a new version.
+const apiV1Prefix = "/api/v1"
+
+const defaultApiDeploymentsLimit = 25
+
+type apiUser struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name"`
+	AvatarUrl   string `json:"avatar_url"`
+	Provider    string `json:"provider"`
+	Deactivated bool   `json:"deactivated"`
+}
+
+type apiApplication struct {
+	Name string `json:"name"`
+	SCM  string `json:"scm"`
+	// Branches are the github_branches offered for deployment, the first
+	// one is the default
+	DefaultBranch string       `json:"default_branch"`
+	Branches      []string     `json:"branches"`
+	Targets       []*apiTarget `json:"targets"`
+}
+
+type apiTarget struct {
+	Name               string                   `json:"name"`
+	AvailableStages    []models.DeploymentStage `json:"available_stages"`
+	DefaultStages      []models.DeploymentStage `json:"default_stages"`
+	PauseStages        []models.DeploymentStage `json:"pause_stages"`
+	DeployableBranches []string                 `json:"deployable_branches"`
+	// The variables deployments can override, see apiDeploymentRequest
+	OverridableVariables []string   `json:"overridable_variables"`
+	Roles                []*apiRole `json:"roles"`
+	// Deployable is true if the current user may deploy to the target
+	Deployable bool `json:"deployable"`
+}
+
+// apiRole tells which of the available stages run commands on the hosts of
+// the role.
+type apiRole struct {
+	Name   string                   `json:"name"`
+	Stages []models.DeploymentStage `json:"stages"`
+}
+
+type apiInitiator struct {
+	TokenName   string `json:"token_name"`
+	SourceIP    string `json:"source_ip"`
+	BuildURL    string `json:"build_url"`
+	BuildNumber string `json:"build_number"`
+	OnBehalfOf  string `json:"on_behalf_of"`
+}
+
+type apiDeployment struct {
+	Id               int                      `json:"id"`
+	Application      string                   `json:"application"`
+	Target           string                   `json:"target"`
+	State            models.DeploymentState   `json:"state"`
+	CommitSha        string                   `json:"commit_sha"`
+	Branch           string                   `json:"branch"`
+	Tag              string                   `json:"tag"`
+	PullRequest      int                      `json:"pull_request"`
+	Comment          string                   `json:"comment"`
+	CreatedAt        time.Time                `json:"created_at"`
+	UpdatedAt        time.Time                `json:"updated_at"`
+	RetryOf          int                      `json:"retry_of"`
+	RedeployOf       int                      `json:"redeploy_of"`
+	Stages           []models.DeploymentStage `json:"stages"`
+	HostGroup        string                   `json:"host_group"`
+	CIOverrideReason string                   `json:"ci_override_reason"`
+	RequestId        string                   `json:"request_id"`
+	URL              string                   `json:"url"`
+	User             *apiUser                 `json:"user"`
+	Initiator        *apiInitiator            `json:"initiator,omitempty"`
+	Changelog        []*models.ChangelogEntry `json:"changelog,omitempty"`
+	Overrides        map[string]string        `json:"overrides,omitempty"`
+}
+
+type apiDeployFreeze struct {
+	Id          int    `json:"id"`
+	Application string `json:"application"`
+	// Target is empty if all targets of the application are frozen
+	Target    string    `json:"target"`
+	Reason    string    `json:"reason"`
+	User      *apiUser  `json:"user"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type apiDeployPreset struct {
+	Id            int    `json:"id"`
+	Name          string `json:"name"`
+	Target        string `json:"target"`
+	Branch        string `json:"branch"`
+	CommentPrefix string `json:"comment_prefix"`
+	// Stages is empty if all stages of the target are deployed
+	Stages    []models.DeploymentStage `json:"stages"`
+	Overrides map[string]string        `json:"overrides"`
+	CreatedAt time.Time                `json:"created_at"`
+}
+
+// apiManagedApplication is an application added by an admin at runtime.
+// Definition is the JSON of the application as it was saved.
+type apiManagedApplication struct {
+	Name       string          `json:"name"`
+	Definition json.RawMessage `json:"definition"`
+	User       *apiUser        `json:"user"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// apiDeployFreezeRequest is the JSON body accepted when freezing deployments.
+type apiDeployFreezeRequest struct {
+	Target string `json:"target"`
+	Reason string `json:"reason"`
+}
+
+// apiDeploymentRequest is the JSON body accepted when creating a deployment.
+// The same values can be sent as form values, as in the UI.
+type apiDeploymentRequest struct {
+	Target           string   `json:"target"`
+	CommitSha        string   `json:"commit_sha"`
+	Branch           string   `json:"branch"`
+	Tag              string   `json:"tag"`
+	PullRequest      int      `json:"pull_request"`
+	Comment          string   `json:"comment"`
+	Stages           []string `json:"stages"`
+	CIOverrideReason string   `json:"ci_override_reason"`
+	BuildURL         string   `json:"build_url"`
+	BuildNumber      string   `json:"build_number"`
+	OnBehalfOf       string   `json:"on_behalf_of"`
+	RedeployOf       int      `json:"redeploy_of"`
+	FreezeOverride   bool     `json:"freeze_override"`
+	// The overridable_variables of the target to set for this deployment,
+	// e.g. {"MIGRATION_TIMEOUT": "600"}
+	Overrides map[string]string `json:"overrides"`
+	// The name of the preset filling the values left empty
+	Preset string `json:"preset"`
+
+	DeployWindowOverrideReason string `json:"deploy_window_override_reason"`
+}
+
+// formValues converts the request to the form values of the deployment form.
+func (req *apiDeploymentRequest) formValues() url.Values {
+	values := url.Values{
+		"target":             {req.Target},
+		"commitsha":          {req.CommitSha},
+		"branch":             {req.Branch},
+		"tag":                {req.Tag},
+		"comment":            {req.Comment},
+		"stages[]":           req.Stages,
+		"ci_override_reason": {req.CIOverrideReason},
+		"build_url":          {req.BuildURL},
+		"build_number":       {req.BuildNumber},
+		"on_behalf_of":       {req.OnBehalfOf},
+		"preset":             {req.Preset},
+
+		"deploy_window_override_reason": {req.DeployWindowOverrideReason},
+	}
+	if req.PullRequest != 0 {
+		values.Set("pull_request", strconv.Itoa(req.PullRequest))
+	}
+	if req.RedeployOf != 0 {
+		values.Set("redeploy_of", strconv.Itoa(req.RedeployOf))
+	}
+	if req.FreezeOverride {
+		values.Set("freeze_override", "1")
+	}
+	if len(req.Overrides) > 0 {
+		d := &models.Deployment{Overrides: req.Overrides}
+		values.Set("overrides", d.FormatOverrides())
+	}
+	return values
+}
+
+func setupApiRoutes(r *mux.Router) {
+	r.NotFoundHandler = http.HandlerFunc(apiNotFoundHandler)
+
+	// GraphQL has no versions, its schema only grows
+	r.HandleFunc("/api/graphql", rateLimited(apiAuthenticated(graphQLHandler))).Methods("GET", "POST")
+
+	api := r.PathPrefix(apiV1Prefix).Subrouter()
+
+	api.HandleFunc("/user", rateLimited(apiAuthenticated(apiCurrentUserHandler))).Methods("GET")
+	api.HandleFunc("/users/{userId}", rateLimited(apiAuthenticated(apiUserHandler))).Methods("GET")
+	api.HandleFunc("/applications", rateLimited(apiAuthenticated(apiApplicationsHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}", rateLimited(apiAuthorizedReaders(apiApplicationHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/deployments", rateLimited(apiAuthorizedReaders(apiDeploymentsHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/deployments", rateLimited(apiAuthorizedReaders(apiCreateDeploymentHandler))).Methods("POST")
+	api.HandleFunc("/applications/{application}/deployments/report.{format:csv|json}", rateLimited(apiAuthorizedReaders(apiDeploymentReportHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/deployments/{deploymentId}", rateLimited(apiAuthorizedReaders(apiDeploymentHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/targets", rateLimited(apiAuthorizedReaders(apiTargetsHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/targets/{target}", rateLimited(apiAuthorizedReaders(apiTargetHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/targets/{target}/current", rateLimited(apiAuthorizedReaders(apiCurrentDeploymentHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/deployments/{deploymentId}/log", rateLimited(apiAuthorizedReaders(apiDeploymentLogHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/freezes", rateLimited(apiAuthorizedReaders(apiDeployFreezesHandler))).Methods("GET")
+	api.HandleFunc("/applications/{application}/freezes", rateLimited(apiAuthorizedReaders(apiCreateDeployFreezeHandler))).Methods("POST")
+	api.HandleFunc("/applications/{application}/freezes/{freezeId}", rateLimited(apiAuthorizedReaders(apiDeleteDeployFreezeHandler))).Methods("DELETE")
+	api.HandleFunc("/applications/{application}/presets", rateLimited(apiAuthorizedReaders(apiDeployPresetsHandler))).Methods("GET")
+	api.HandleFunc("/deployments/{deploymentId}/cancel", rateLimited(apiAuthenticated(apiCancelDeploymentHandler))).Methods("POST")
+	api.HandleFunc("/events", rateLimited(apiAuthenticated(apiEventsHandler))).Methods("GET")
+	api.HandleFunc("/admin/applications", rateLimited(apiAdmins(apiManagedApplicationsHandler))).Methods("GET")
+	api.HandleFunc("/admin/applications/{name}", rateLimited(apiAdmins(apiSaveManagedApplicationHandler))).Methods("PUT")
+	api.HandleFunc("/admin/applications/{name}", rateLimited(apiAdmins(apiDeleteManagedApplicationHandler))).Methods("DELETE")
+}
+
+// apiAuthenticated only accepts requests with a valid API token. Sessions
+// aren't accepted, so the API doesn't need CSRF protection.
+func apiAuthenticated(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		currentUser, err := loadUserWithApiToken(r)
+		if err == errLoginLockedOut {
+			seconds := lockedOutSeconds(loginLockedOut(r))
+			w.Header().Set("Retry-After", strconv.Itoa(seconds))
+			renderApiErrorDetails(w, http.StatusTooManyRequests, apiErrRateLimited, err.Error(), map[string]int{"retry_after": seconds})
+			return
+		}
+		if err != nil {
+			requestLogger(r).Error("error when trying to get current user via Api Token", "err", err)
+			currentUser = nil
+		}
+
+		currentUser = verifyUser(w, r, currentUser)
+		if currentUser == nil {
+			renderApiError(w, http.StatusUnauthorized, "missing or wrong API token")
+			return
+		}
+
+		context.Set(r, CurrentUser, currentUser)
+		context.Set(r, ApiTokenRequest, true)
+
+		fn(w, r)
+	}
+}
+
+// apiAuthorizedReaders answers with 404 for applications the user can't read,
+// so their existence isn't revealed.
+func apiAuthorizedReaders(fn http.HandlerFunc) http.HandlerFunc {
+	return apiAuthenticated(func(w http.ResponseWriter, r *http.Request) {
+		application, err := findApplication(mux.Vars(r)["application"])
+		if err != nil || !application.CanRead(getCurrentUser(r)) {
+			renderApiError(w, http.StatusNotFound, "application not found")
+			return
+		}
+
+		context.Set(r, CurrentApplication, application)
+
+		fn(w, r)
+	})
+}
+
+// apiAdmins only accepts requests of admins.
+func apiAdmins(fn http.HandlerFunc) http.HandlerFunc {
+	return apiAuthenticated(func(w http.ResponseWriter, r *http.Request) {
+		if !getConfig().IsAdmin(getCurrentUser(r)) {
+			renderApiError(w, http.StatusForbidden, "only admins can do this")
+			return
+		}
+
+		fn(w, r)
+	})
+}
+
+func apiCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	renderApiData(w, http.StatusOK, newApiUser(getCurrentUser(r)))
+}
+
+func apiUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		renderApiError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	users, err := getUsers(db, []int{id})
+	if err != nil {
+		requestLogger(r).Error("error loading user", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load user")
+		return
+	}
+	if len(users) == 0 {
+		renderApiError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	renderApiData(w, http.StatusOK, newApiUser(users[0]))
+}
+
+func apiApplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	applications := []*apiApplication{}
+	for _, a := range getConfig().Applications {
+		if a.CanRead(currentUser) {
+			applications = append(applications, newApiApplication(a, currentUser))
+		}
+	}
+
+	renderApiData(w, http.StatusOK, applications)
+}
+
+func apiApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+	renderApiData(w, http.StatusOK, newApiApplication(application, getCurrentUser(r)))
+}
+
+func apiTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+	renderApiData(w, http.StatusOK, newApiApplication(application, getCurrentUser(r)).Targets)
+}
+
+func apiTargetHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	target, err := findTarget(application, mux.Vars(r)["target"])
+	if err != nil {
+		renderApiError(w, http.StatusNotFound, "target not found")
+		return
+	}
+
+	renderApiData(w, http.StatusOK, newApiTarget(application, target, getCurrentUser(r)))
+}
+
+func apiDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	limit := defaultApiDeploymentsLimit
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			renderApiErrorDetails(w, 422, apiErrValidationFailed, "limit must be a positive number", map[string]string{"parameter": "limit"})
+			return
+		}
+	}
+
+	filter, err := parseDeploymentFilter(application, r.URL.Query(), time.Local)
+	if err != nil {
+		renderApiError(w, 422, err.Error())
+		return
+	}
+	filter.Limit = limit
+
+	lastId, lastChange, err := getLastDeploymentChange(db, application)
+	if err != nil {
+		requestLogger(r).Error("error loading last change of deployments", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployments")
+		return
+	}
+	if checkNotModified(w, r, lastChange, lastId, lastChange.UnixNano()) {
+		return
+	}
+
+	deployments, err := getFilteredApplicationDeployments(db, application, filter)
+	if err != nil {
+		requestLogger(r).Error("error loading deployments", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployments")
+		return
+	}
+
+	err = loadDeploymentsUsers(db, deployments)
+	if err != nil {
+		requestLogger(r).Error("error loading the users of the deployments", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployments")
+		return
+	}
+
+	result := []*apiDeployment{}
+	for _, d := range deployments {
+		d.ApplicationName = application.Name
+		result = append(result, newApiDeployment(application, d))
+	}
+
+	renderApiData(w, http.StatusOK, result)
+}
+
+func apiCreateDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		var req apiDeploymentRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			renderApiErrorDetails(w, http.StatusBadRequest, apiErrInvalidRequest, "invalid JSON", map[string]string{"error": err.Error()})
+			return
+		}
+		r.Form = req.formValues()
+	}
+
+	deployment, target, stages, reqErr := newDeploymentFromRequest(r, application, currentUser)
+	if reqErr != nil {
+		renderApiError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	err := startDeployment(application, target, deployment, stages)
+	if err != nil {
+		if err == errShuttingDown {
+			renderApiError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		requestLogger(r).Error("could not start deployment", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not start deployment")
+		return
+	}
+	recordDeploymentAuditEvents(r, currentUser, deployment)
+
+	deployment.User = currentUser
+	w.Header().Set("Location", apiDeploymentUrl(application, deployment))
+	renderApiData(w, http.StatusCreated, newApiDeployment(application, deployment))
+}
+
+func apiDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	deployment, ok := loadApiDeployment(w, r, application)
+	if !ok {
+		return
+	}
+	if checkNotModified(w, r, deployment.UpdatedAt, deployment.UpdatedAt.UnixNano()) {
+		return
+	}
+
+	if err := loadApiDeploymentDetails(deployment); err != nil {
+		requestLogger(r).Error("error loading deployment details", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployment")
+		return
+	}
+
+	renderApiData(w, http.StatusOK, newApiDeployment(application, deployment))
+}
+
+// apiCurrentDeploymentHandler answers with the last successful deployment to
+// the target, i.e. the commit that's currently deployed.
+func apiCurrentDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	target, err := findTarget(application, mux.Vars(r)["target"])
+	if err != nil {
+		renderApiError(w, http.StatusNotFound, "target not found")
+		return
+	}
+
+	deployment, err := getLastTargetDeployment(db, application, target.Name)
+	if err != nil {
+		requestLogger(r).Error("error loading last deployment", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployment")
+		return
+	}
+	if deployment == nil {
+		renderApiError(w, http.StatusNotFound, "target has no successful deployment")
+		return
+	}
+	if checkNotModified(w, r, deployment.UpdatedAt, deployment.Id, deployment.UpdatedAt.UnixNano()) {
+		return
+	}
+
+	if err := loadApiDeploymentDetails(deployment); err != nil {
+		requestLogger(r).Error("error loading deployment details", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployment")
+		return
+	}
+
+	renderApiData(w, http.StatusOK, newApiDeployment(application, deployment))
+}
+
+func apiDeploymentLogHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	deployment, ok := loadApiDeployment(w, r, application)
+	if !ok {
+		return
+	}
+
+	filter, err := parseLogEntryFilter(r.URL.Query())
+	if err != nil {
+		renderApiErrorDetails(w, http.StatusUnprocessableEntity, apiErrValidationFailed, err.Error(), map[string]string{"parameter": "type"})
+		return
+	}
+	before, limit, paged, err := parseLogPage(r.URL.Query())
+	if err != nil {
+		renderApiErrorDetails(w, http.StatusUnprocessableEntity, apiErrValidationFailed, err.Error(), map[string]string{"parameter": "limit"})
+		return
+	}
+
+	count, lastId, err := getLogEntriesVersion(db, deployment)
+	if err != nil {
+		requestLogger(r).Error("error loading version of logentries", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load log entries")
+		return
+	}
+	if checkNotModified(w, r, time.Time{}, count, lastId) {
+		return
+	}
+
+	if !paged {
+		renderApiLogEntries(w, r, deployment, filter)
+		return
+	}
+
+	logEntries, err := getDeploymentLogEntriesPage(db, deployment, filter, before, limit)
+	if err != nil {
+		requestLogger(r).Error("error loading logentries", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load log entries")
+		return
+	}
+	if logEntries == nil {
+		logEntries = []*deploy.LogEntry{}
+	}
+
+	renderApiData(w, http.StatusOK, logEntries)
+}
+
+// apiCancelDeploymentHandler cancels a queued or running deployment. Only
+// users who may deploy to the target can cancel deployments to it. Killing a
+// running deployment takes a moment, so it answers with 202 and clients have
+// to poll the deployment for the final state.
+func apiCancelDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	id, err := strconv.Atoi(mux.Vars(r)["deploymentId"])
+	if err != nil {
+		renderApiError(w, http.StatusNotFound, "deployment not found")
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployment")
+		return
+	}
+	if deployment == nil {
+		renderApiError(w, http.StatusNotFound, "deployment not found")
+		return
+	}
+
+	application, err := findApplication(deployment.ApplicationName)
+	if err != nil || !application.CanRead(currentUser) {
+		renderApiError(w, http.StatusNotFound, "deployment not found")
+		return
+	}
+
+	target, err := findTarget(application, deployment.TargetName)
+	if err != nil || !application.CanDeploy(target, currentUser) {
+		renderApiError(w, http.StatusForbidden, "not authorized to cancel deployments to this target")
+		return
+	}
+
+	err = cancelDeployment(deployment)
+	if err == errNotCancelable {
+		renderApiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("could not cancel deployment", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not cancel deployment")
+		return
+	}
+
+	deploymentLogger(deployment).Info("deployment canceled via the API", "user", currentUser.Name)
+	recordAuditEvent(r, currentUser, models.AUDIT_DEPLOYMENT_CANCEL, deploymentAuditSubject(deployment))
+
+	if err := loadApiDeploymentDetails(deployment); err != nil {
+		requestLogger(r).Error("error loading deployment details", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployment")
+		return
+	}
+
+	renderApiData(w, http.StatusAccepted, newApiDeployment(application, deployment))
+}
+
+func apiDeployFreezesHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	freezes, err := getDeployFreezes(db, application)
+	if err == nil {
+		err = loadDeployFreezesUsers(db, freezes)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy freezes", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load freezes")
+		return
+	}
+
+	result := []*apiDeployFreeze{}
+	for _, f := range freezes {
+		result = append(result, newApiDeployFreeze(f))
+	}
+
+	renderApiData(w, http.StatusOK, result)
+}
+
+func apiDeployPresetsHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	presets, err := getDeployPresets(db, application)
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy presets", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load presets")
+		return
+	}
+
+	result := []*apiDeployPreset{}
+	for _, p := range presets {
+		result = append(result, newApiDeployPreset(p))
+	}
+
+	renderApiData(w, http.StatusOK, result)
+}
+
+// apiCreateDeployFreezeHandler freezes deployments to a target, or to all
+// targets without a target, e.g. during an incident. Only admins can freeze.
+func apiCreateDeployFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	req := apiDeployFreezeRequest{Target: r.FormValue("target"), Reason: r.FormValue("reason")}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			renderApiErrorDetails(w, http.StatusBadRequest, apiErrInvalidRequest, "invalid JSON", map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	freeze, reqErr := createDeployFreezeFromRequest(r, application, getCurrentUser(r), req.Target, req.Reason)
+	if reqErr != nil {
+		renderApiError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	renderApiData(w, http.StatusCreated, newApiDeployFreeze(freeze))
+}
+
+func apiDeleteDeployFreezeHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	id, err := strconv.Atoi(mux.Vars(r)["freezeId"])
+	if err != nil {
+		renderApiError(w, http.StatusNotFound, "freeze not found")
+		return
+	}
+
+	freeze, reqErr := deleteDeployFreezeFromRequest(r, application, getCurrentUser(r), id)
+	if reqErr != nil {
+		renderApiError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	err = loadDeployFreezesUsers(db, []*models.DeployFreeze{freeze})
+	if err != nil {
+		requestLogger(r).Error("error loading the user of the deploy freeze", "err", err)
+	}
+
+	renderApiData(w, http.StatusOK, newApiDeployFreeze(freeze))
+}
+
+func apiManagedApplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	managed, err := getManagedApplications(db)
+	if err == nil {
+		err = loadManagedApplicationsUsers(db, managed)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading the applications", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load applications")
+		return
+	}
+
+	result := []*apiManagedApplication{}
+	for _, m := range managed {
+		result = append(result, newApiManagedApplication(m))
+	}
+
+	renderApiData(w, http.StatusOK, result)
+}
+
+// apiSaveManagedApplicationHandler adds or replaces the application. The body
+// is its JSON definition, with the name of the path.
+func apiSaveManagedApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	definition, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		renderApiError(w, http.StatusBadRequest, "could not read the definition")
+		return
+	}
+
+	managed, reqErr := saveManagedApplicationFromRequest(r, getCurrentUser(r), mux.Vars(r)["name"], string(definition))
+	if reqErr != nil {
+		renderApiError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	renderApiData(w, http.StatusOK, newApiManagedApplication(managed))
+}
+
+func apiDeleteManagedApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	reqErr := deleteManagedApplicationFromRequest(r, getCurrentUser(r), mux.Vars(r)["name"])
+	if reqErr != nil {
+		renderApiError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadApiDeploymentDetails loads the user, initiator, changelog and overrides
+// of the deployment.
+func loadApiDeploymentDetails(d *models.Deployment) error {
+	user, err := getUser(db, d.UserId)
+	if err != nil {
+		return err
+	}
+	d.User = user
+
+	err = loadDeploymentInitiator(db, d)
+	if err != nil {
+		return err
+	}
+
+	err = loadDeploymentOverrides(db, d)
+	if err != nil {
+		return err
+	}
+
+	return loadDeploymentChangelog(db, d)
+}
+
+// loadApiDeployment loads the deployment of the request and answers with 404
+// if it doesn't exist or belongs to another application.
+func loadApiDeployment(w http.ResponseWriter, r *http.Request, a *models.Application) (*models.Deployment, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)["deploymentId"])
+	if err != nil {
+		renderApiError(w, http.StatusNotFound, "deployment not found")
+		return nil, false
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load deployment")
+		return nil, false
+	}
+	if deployment == nil || deployment.ApplicationName != a.Name {
+		renderApiError(w, http.StatusNotFound, "deployment not found")
+		return nil, false
+	}
+
+	return deployment, true
+}
+
+// renderApiData answers with the data wrapped in the envelope of the API:
+// {"data": ...}
+func renderApiData(w http.ResponseWriter, status int, data interface{}) {
+	renderApiJSON(w, status, map[string]interface{}{"data": data})
+}
+
+// The codes of API errors, documented in the README. Clients should check the
+// code instead of the message, which is meant for humans and may change.
+const (
+	apiErrInvalidRequest   = "invalid_request"
+	apiErrUnauthorized     = "unauthorized"
+	apiErrForbidden        = "forbidden"
+	apiErrInvalidSignature = "invalid_signature"
+	apiErrNotFound         = "not_found"
+	apiErrConflict         = "conflict"
+	apiErrValidationFailed = "validation_failed"
+	apiErrRateLimited      = "rate_limited"
+	apiErrInternal         = "internal_error"
+)
+
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:          apiErrInvalidRequest,
+	http.StatusUnauthorized:        apiErrUnauthorized,
+	http.StatusForbidden:           apiErrForbidden,
+	http.StatusNotFound:            apiErrNotFound,
+	http.StatusConflict:            apiErrConflict,
+	422:                            apiErrValidationFailed,
+	http.StatusTooManyRequests:     apiErrRateLimited,
+	http.StatusInternalServerError: apiErrInternal,
+}
+
+type apiError struct {
+	Status    int         `json:"status"`
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestId string      `json:"request_id,omitempty"`
+}
+
+// renderApiError answers with the error wrapped in the envelope of the API:
+// {"error": {"status": 404, "code": "not_found", "message": "...",
+// "request_id": "..."}}. The code is derived from the status.
+func renderApiError(w http.ResponseWriter, status int, message string) {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = apiErrInvalidRequest
+		if status >= 500 {
+			code = apiErrInternal
+		}
+	}
+	renderApiErrorDetails(w, status, code, message, nil)
+}
+
+// renderApiErrorDetails answers with an error with the given code and
+// details, e.g. the invalid parameter.
+func renderApiErrorDetails(w http.ResponseWriter, status int, code, message string, details interface{}) {
+	renderApiJSON(w, status, map[string]interface{}{
+		"error": &apiError{
+			Status:    status,
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestId: w.Header().Get(requestIdHeader),
+		},
+	})
+}
+
+// apiNotFoundHandler answers unknown paths below /api with a JSON error
+// instead of the plain text of http.NotFound.
+func apiNotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		http.NotFound(w, r)
+		return
+	}
+	renderApiError(w, http.StatusNotFound, "no such API endpoint")
+}
+
+func renderApiJSON(w http.ResponseWriter, status int, body interface{}) {
+	js, err := json.Marshal(body)
+	if err != nil {
+		slog.Error("error encoding API response", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// renderApiLogEntries writes the log entries matching the filter as data of
+// the response while they're read from the database, so long logs aren't
+// loaded completely. Once the first entry is written, errors can only be
+// logged and end the response early, with invalid JSON.
+func renderApiLogEntries(w http.ResponseWriter, r *http.Request, d *models.Deployment, filter *logEntryFilter) {
+	written := 0
+	err := eachDeploymentLogEntry(db, d, func(e *deploy.LogEntry) error {
+		if !filter.Matches(e) {
+			return nil
+		}
+
+		js, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+
+		if written == 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			_, err = io.WriteString(w, `{"data":[`)
+		} else {
+			_, err = io.WriteString(w, ",")
+		}
+		if err == nil {
+			_, err = w.Write(js)
+		}
+		written++
+		return err
+	})
+	if err != nil {
+		requestLogger(r).Error("error streaming logentries", "err", err)
+		if written == 0 {
+			renderApiError(w, http.StatusInternalServerError, "could not load log entries")
+		}
+		return
+	}
+
+	if written == 0 {
+		renderApiData(w, http.StatusOK, []*deploy.LogEntry{})
+		return
+	}
+	io.WriteString(w, "]}")
+}
+
+func apiDeploymentUrl(a *models.Application, d *models.Deployment) string {
+	return apiV1Prefix + "/applications/" + a.Name + "/deployments/" + strconv.Itoa(d.Id)
+}
+
+func newApiUser(u *models.User) *apiUser {
+	if u == nil {
+		return nil
+	}
+	return &apiUser{
+		Id:          u.Id,
+		Name:        u.Name,
+		AvatarUrl:   u.AvatarUrl,
+		Provider:    u.Provider,
+		Deactivated: u.IsDeactivated(),
+	}
+}
+
+func newApiApplication(a *models.Application, u *models.User) *apiApplication {
+	application := &apiApplication{
+		Name:     a.Name,
+		SCM:      a.SCMName(),
+		Branches: []string{},
+		Targets:  []*apiTarget{},
+	}
+
+	if len(a.GitHubBranches) > 0 {
+		application.DefaultBranch = a.GitHubBranches[0]
+		application.Branches = a.GitHubBranches
+	}
+
+	for _, t := range a.Targets {
+		application.Targets = append(application.Targets, newApiTarget(a, t, u))
+	}
+
+	return application
+}
+
+func newApiTarget(a *models.Application, t *models.Target, u *models.User) *apiTarget {
+	target := &apiTarget{
+		Name:               t.Name,
+		AvailableStages:    t.AvailableStages,
+		DefaultStages:      t.DefaultStages,
+		PauseStages:        t.PauseStages,
+		DeployableBranches: t.DeployableBranches,
+		Roles:              []*apiRole{},
+		Deployable:         a.CanDeploy(t, u),
+
+		OverridableVariables: t.OverridableVariables,
+	}
+	if target.PauseStages == nil {
+		target.PauseStages = []models.DeploymentStage{}
+	}
+	if target.DeployableBranches == nil {
+		target.DeployableBranches = []string{}
+	}
+	if target.OverridableVariables == nil {
+		target.OverridableVariables = []string{}
+	}
+
+	for _, role := range t.Roles {
+		stages := []models.DeploymentStage{}
+		for _, stage := range t.AvailableStages {
+			if _, ok := role.ScriptTemplates[stage]; ok {
+				stages = append(stages, stage)
+			}
+		}
+		target.Roles = append(target.Roles, &apiRole{Name: role.Name, Stages: stages})
+	}
+
+	return target
+}
+
+func newApiDeployFreeze(f *models.DeployFreeze) *apiDeployFreeze {
+	return &apiDeployFreeze{
+		Id:          f.Id,
+		Application: f.ApplicationName,
+		Target:      f.TargetName,
+		Reason:      f.Reason,
+		User:        newApiUser(f.User),
+		CreatedAt:   f.CreatedAt,
+	}
+}
+
+func newApiDeployPreset(p *models.DeployPreset) *apiDeployPreset {
+	preset := &apiDeployPreset{
+		Id:            p.Id,
+		Name:          p.Name,
+		Target:        p.TargetName,
+		Branch:        p.Branch,
+		CommentPrefix: p.CommentPrefix,
+		Stages:        p.Stages,
+		Overrides:     p.Overrides,
+		CreatedAt:     p.CreatedAt,
+	}
+	if preset.Stages == nil {
+		preset.Stages = []models.DeploymentStage{}
+	}
+	if preset.Overrides == nil {
+		preset.Overrides = map[string]string{}
+	}
+	return preset
+}
+
+func newApiManagedApplication(m *models.ManagedApplication) *apiManagedApplication {
+	return &apiManagedApplication{
+		Name:       m.Name,
+		Definition: json.RawMessage(m.Definition),
+		User:       newApiUser(m.User),
+		CreatedAt:  m.CreatedAt,
+		UpdatedAt:  m.UpdatedAt,
+	}
+}
+
+func newApiDeployment(a *models.Application, d *models.Deployment) *apiDeployment {
+	deployment := &apiDeployment{
+		Id:               d.Id,
+		Application:      d.ApplicationName,
+		Target:           d.TargetName,
+		State:            d.State,
+		CommitSha:        d.CommitSha,
+		Branch:           d.Branch,
+		Tag:              d.Tag,
+		PullRequest:      d.PullRequest,
+		Comment:          d.Comment,
+		CreatedAt:        d.CreatedAt,
+		UpdatedAt:        d.UpdatedAt,
+		RetryOf:          d.RetryOf,
+		RedeployOf:       d.RedeployOf,
+		Stages:           d.Stages,
+		HostGroup:        d.HostGroup,
+		CIOverrideReason: d.CIOverrideReason,
+		RequestId:        d.RequestId,
+		URL:              deploymentUrl(a, d),
+		User:             newApiUser(d.User),
+		Changelog:        d.Changelog,
+		Overrides:        d.Overrides,
+	}
+
+	// Deployments created before the stages were saved have none
+	if deployment.Stages == nil {
+		deployment.Stages = []models.DeploymentStage{}
+	}
+
+	if i := d.Initiator; i != nil {
+		deployment.Initiator = &apiInitiator{
+			TokenName:   i.TokenName,
+			SourceIP:    i.SourceIP,
+			BuildURL:    i.BuildURL,
+			BuildNumber: i.BuildNumber,
+			OnBehalfOf:  i.OnBehalfOf,
+		}
+	}
+
+	return deployment
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func TestApiV1(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{Applications: []*models.Application{
+		{
+			Name:          "flincOnRails",
+			ReadUsernames: []string{"mrnugget"},
+			Targets: []*models.Target{
+				{Name: "production", DeployUsernames: []string{"mrnugget"}},
+				{Name: "staging"},
+			},
+		},
+		{Name: "secret", ReadUsernames: []string{"fabrik42"}},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+	checkErr(t, setApiToken(db, user, "t0k3n"))
+
+	deployment := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, deployment))
+	checkErr(t, updateDeploymentState(db, deployment, models.DEPLOYMENT_SUCCESSFUL))
+
+	other := buildDeployment(user.Id)
+	other.ApplicationName = "secret"
+	checkErr(t, createDeployment(db, other))
+
+	staging := buildDeployment(user.Id)
+	staging.TargetName = "staging"
+	checkErr(t, createDeployment(db, staging))
+
+	killRegistry = NewKillRegistry()
+	deploymentQueue = NewDeploymentQueue()
+
+	router := mux.NewRouter()
+	setupApiRoutes(router)
+
+	deploymentPath := "/api/v1/applications/flincOnRails/deployments/" + strconv.Itoa(deployment.Id)
+
+	tests := []struct {
+		method         string
+		path           string
+		token          string
+		body           string
+		expectedStatus int
+		expectedCode   string
+		expectedError  string
+	}{
+		{"GET", "/api/v1/user", "", "", 401, "unauthorized", "missing or wrong API token"},
+		{"GET", "/api/v1/user", "wrong", "", 401, "unauthorized", "missing or wrong API token"},
+		{"GET", "/api/v1/user", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/users/1", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/users/999", "t0k3n", "", 404, "not_found", "user not found"},
+		{"GET", "/api/v1/applications", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/secret", "t0k3n", "", 404, "not_found", "application not found"},
+		{"GET", "/api/v1/applications/unknown", "t0k3n", "", 404, "not_found", "application not found"},
+		{"GET", "/api/v1/applications/flincOnRails/deployments", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails/deployments?target=production&limit=1", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails/deployments?target=unknown", "t0k3n", "", 422, "validation_failed", "target not found"},
+		{"GET", "/api/v1/applications/flincOnRails/deployments?state=successful&branch=master&user=mrnugget&from=2015-01-01&sort=asc", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails/deployments?sort=random", "t0k3n", "", 422, "validation_failed", "sort must be asc or desc"},
+		{"GET", "/api/v1/applications/flincOnRails/deployments?limit=0", "t0k3n", "", 422, "validation_failed", "limit must be a positive number"},
+		{"GET", deploymentPath, "t0k3n", "", 200, "", ""},
+		{"GET", deploymentPath + "/log", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails/deployments/" + strconv.Itoa(other.Id), "t0k3n", "", 404, "not_found", "deployment not found"},
+		{"GET", "/api/v1/applications/flincOnRails/targets/production/current", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails/targets/staging/current", "t0k3n", "", 404, "not_found", "target has no successful deployment"},
+		{"GET", "/api/v1/applications/flincOnRails/targets/unknown/current", "t0k3n", "", 404, "not_found", "target not found"},
+		{"GET", "/api/v1/applications/flincOnRails/targets", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails/targets/staging", "t0k3n", "", 200, "", ""},
+		{"GET", "/api/v1/applications/flincOnRails/targets/unknown", "t0k3n", "", 404, "not_found", "target not found"},
+		{"GET", "/api/v1/applications/secret/targets", "t0k3n", "", 404, "not_found", "application not found"},
+		{"POST", "/api/v1/applications/flincOnRails/deployments", "t0k3n", `{"target": "staging"}`, 403, "forbidden", "not authorized to deploy to this target"},
+		{"POST", "/api/v1/applications/flincOnRails/deployments", "t0k3n", `{"target": "production", "stages": ["CHECKOUT"]}`, 422, "validation_failed", "comment is empty"},
+		{"POST", "/api/v1/applications/flincOnRails/deployments", "t0k3n", `{"target": `, 400, "invalid_request", ""},
+		{"POST", "/api/v1/deployments/" + strconv.Itoa(deployment.Id) + "/cancel", "t0k3n", "", 409, "conflict", "deployment is neither queued nor running"},
+		{"POST", "/api/v1/deployments/" + strconv.Itoa(other.Id) + "/cancel", "t0k3n", "", 404, "not_found", "deployment not found"},
+		{"POST", "/api/v1/deployments/" + strconv.Itoa(staging.Id) + "/cancel", "t0k3n", "", 403, "forbidden", "not authorized to cancel deployments to this target"},
+		{"GET", "/api/v1/unknown", "t0k3n", "", 404, "not_found", "no such API endpoint"},
+		{"POST", "/api/v1/deployments/999999/cancel", "t0k3n", "", 404, "not_found", "deployment not found"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		if tt.token != "" {
+			req.Header.Set("X-Api-Token", tt.token)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s. want=%d, got=%d", tt.method, tt.path, tt.expectedStatus, rec.Code)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("wrong content type for %s %s. want=%s, got=%s", tt.method, tt.path, "application/json", ct)
+		}
+
+		var envelope struct {
+			Data  json.RawMessage `json:"data"`
+			Error *struct {
+				Status  int    `json:"status"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		err := json.Unmarshal(rec.Body.Bytes(), &envelope)
+		if err != nil {
+			t.Errorf("invalid JSON for %s %s: %s", tt.method, tt.path, err)
+			continue
+		}
+
+		if rec.Code >= 400 {
+			if envelope.Error == nil || envelope.Error.Status != rec.Code || envelope.Error.Code != tt.expectedCode {
+				t.Errorf("wrong error for %s %s. got=%s", tt.method, tt.path, rec.Body.String())
+			} else if tt.expectedError != "" && envelope.Error.Message != tt.expectedError {
+				t.Errorf("wrong error message for %s %s. want=%s, got=%s", tt.method, tt.path, tt.expectedError, envelope.Error.Message)
+			}
+		} else if len(envelope.Data) == 0 {
+			t.Errorf("no data for %s %s. got=%s", tt.method, tt.path, rec.Body.String())
+		}
+	}
+}
+
+func TestApiV1DeployFreezes(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{
+		AdminUsernames: []string{"fabrik42"},
+		Applications: []*models.Application{
+			{
+				Name:          "flincOnRails",
+				ReadUsernames: []string{"mrnugget", "fabrik42"},
+				Targets:       []*models.Target{{Name: "production"}},
+			},
+		},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	admin := buildUser(1, "fabrik42")
+	checkErr(t, createUser(db, admin))
+	checkErr(t, setApiToken(db, admin, "4dm1n"))
+	deployer := buildUser(2, "mrnugget")
+	checkErr(t, createUser(db, deployer))
+	checkErr(t, setApiToken(db, deployer, "t0k3n"))
+
+	router := mux.NewRouter()
+	setupApiRoutes(router)
+
+	freezesPath := "/api/v1/applications/flincOnRails/freezes"
+
+	request := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Token", token)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		method         string
+		token          string
+		body           string
+		expectedStatus int
+	}{
+		{"GET", "t0k3n", "", 200},
+		{"POST", "t0k3n", `{"target": "production", "reason": "incident"}`, 403},
+		{"POST", "4dm1n", `{"target": "production"}`, 422},
+		{"POST", "4dm1n", `{"target": "unknown", "reason": "incident"}`, 404},
+		{"POST", "4dm1n", `{"target": "production", "reason": "incident"}`, 201},
+		{"POST", "4dm1n", `{"target": "production", "reason": "again"}`, 409},
+	}
+
+	for _, tt := range tests {
+		rec := request(tt.method, freezesPath, tt.token, tt.body)
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s %s. want=%d, got=%d (%s)", tt.method, tt.token, tt.body, tt.expectedStatus, rec.Code, rec.Body.String())
+		}
+	}
+
+	freezes, err := getDeployFreezes(db, getConfig().Applications[0])
+	checkErr(t, err)
+	if len(freezes) != 1 || freezes[0].TargetName != "production" || freezes[0].Reason != "incident" {
+		t.Fatalf("wrong freezes. got=%+v", freezes)
+	}
+
+	freezePath := freezesPath + "/" + strconv.Itoa(freezes[0].Id)
+	for _, tt := range []struct {
+		token          string
+		expectedStatus int
+	}{
+		{"t0k3n", 403},
+		{"4dm1n", 200},
+		{"4dm1n", 404},
+	} {
+		rec := request("DELETE", freezePath, tt.token, "")
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for DELETE %s. want=%d, got=%d (%s)", tt.token, tt.expectedStatus, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestApiV1HidesSecrets(t *testing.T) {
+	a := &models.Application{
+		Name:           "flincOnRails",
+		SCMAccessToken: "scm-s3cr3t",
+		Targets: []*models.Target{
+			{Name: "production", SudoPassword: "sudo-s3cr3t", DeployUsernames: []string{"mrnugget"}},
+		},
+	}
+	u := buildUser(1, "mrnugget")
+	u.ApiToken = "api-s3cr3t"
+	d := buildDeployment(u.Id)
+	d.User = u
+
+	for _, data := range []interface{}{newApiApplication(a, u), newApiDeployment(a, d)} {
+		js, err := json.Marshal(data)
+		checkErr(t, err)
+
+		for _, secret := range []string{"scm-s3cr3t", "sudo-s3cr3t", "api-s3cr3t", u.AccessToken} {
+			if strings.Contains(string(js), secret) {
+				t.Errorf("API response contains %q: %s", secret, js)
+			}
+		}
+	}
+
+	application := newApiApplication(a, u)
+	if !application.Targets[0].Deployable {
+		t.Errorf("target not deployable for %s", u.Name)
+	}
+}
+
+func TestNewApiTarget(t *testing.T) {
+	a := &models.Application{Name: "flincOnRails", GitHubBranches: []string{"master", "develop"}}
+	target := &models.Target{
+		Name:               "production",
+		AvailableStages:    []models.DeploymentStage{"CHECKOUT", "MIGRATE", "RESTART"},
+		DeployableBranches: []string{"release/*"},
+		Roles: []*models.Role{
+			{Name: "web", ScriptTemplates: map[models.DeploymentStage]string{"RESTART": "touch tmp/restart.txt", "CHECKOUT": "git pull"}},
+			{Name: "db", ScriptTemplates: map[models.DeploymentStage]string{"MIGRATE": "rake db:migrate"}},
+		},
+	}
+	a.Targets = []*models.Target{target}
+
+	application := newApiApplication(a, buildUser(1, "mrnugget"))
+	if application.DefaultBranch != "master" {
+		t.Errorf("wrong default branch. want=%s, got=%s", "master", application.DefaultBranch)
+	}
+
+	js, err := json.Marshal(application.Targets[0])
+	checkErr(t, err)
+
+	expected := `{"name":"production","available_stages":["CHECKOUT","MIGRATE","RESTART"],"default_stages":null,"pause_stages":[],"deployable_branches":["release/*"],"overridable_variables":[],"roles":[{"name":"web","stages":["CHECKOUT","RESTART"]},{"name":"db","stages":["MIGRATE"]}],"deployable":false}`
+	if string(js) != expected {
+		t.Errorf("wrong target.\nwant=%s\ngot=%s", expected, js)
+	}
+
+	target.OverridableVariables = []string{"MIGRATION_TIMEOUT", "WORKERS"}
+	js, err = json.Marshal(newApiApplication(a, buildUser(1, "mrnugget")).Targets[0])
+	checkErr(t, err)
+
+	expected = `{"name":"production","available_stages":["CHECKOUT","MIGRATE","RESTART"],"default_stages":null,"pause_stages":[],"deployable_branches":["release/*"],"overridable_variables":["MIGRATION_TIMEOUT","WORKERS"],"roles":[{"name":"web","stages":["CHECKOUT","RESTART"]},{"name":"db","stages":["MIGRATE"]}],"deployable":false}`
+	if string(js) != expected {
+		t.Errorf("wrong target with overridable variables.\nwant=%s\ngot=%s", expected, js)
+	}
+}
+
+func TestRenderApiLogEntries(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	deployment := buildDeployment(1)
+	checkErr(t, createDeployment(db, deployment))
+	for _, entryType := range []deploy.LogEntryType{deploy.COMMAND_STDOUT_OUTPUT, deploy.COMMAND_FAIL, deploy.COMMAND_STDERR_OUTPUT} {
+		checkErr(t, createLogEntry(db, &deploy.LogEntry{
+			DeploymentId: deployment.Id,
+			EntryType:    entryType,
+			Message:      string(entryType),
+			Timestamp:    time.Now(),
+		}))
+	}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"COMMAND_STDOUT_OUTPUT", "COMMAND_FAIL", "COMMAND_STDERR_OUTPUT"}},
+		{"type=failures", []string{"COMMAND_FAIL"}},
+		{"origin=web99", []string{}},
+	}
+
+	for _, tt := range tests {
+		filter, err := parseLogEntryFilter(mustParseQuery(t, tt.query))
+		checkErr(t, err)
+
+		req := httptest.NewRequest("GET", "/?"+tt.query, nil)
+		rec := httptest.NewRecorder()
+		renderApiLogEntries(rec, req, deployment, filter)
+
+		var body struct {
+			Data []*deploy.LogEntry `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON for %q: %s\n%s", tt.query, err, rec.Body.String())
+		}
+		if body.Data == nil {
+			t.Errorf("data missing for %q", tt.query)
+		}
+
+		messages := []string{}
+		for _, e := range body.Data {
+			messages = append(messages, e.Message)
+		}
+		if strings.Join(messages, ",") != strings.Join(tt.expected, ",") {
+			t.Errorf("wrong entries for %q. want=%v, got=%v", tt.query, tt.expected, messages)
+		}
+	}
+}
+
+func mustParseQuery(t *testing.T, query string) url.Values {
+	q, err := url.ParseQuery(query)
+	checkErr(t, err)
+	return q
+}
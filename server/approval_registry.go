@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+var ErrNotWaitingForApproval = errors.New("deployment is not waiting for approval")
+var ErrOwnDeployment = errors.New("the deployment needs the approval of a second user")
+
+// PendingApproval is a deployment waiting in a pause stage.
+type PendingApproval struct {
+	DeploymentId int
+	Stage        models.DeploymentStage
+	Since        time.Time
+}
+
+// ApprovalRegistry connects the deployment managers waiting in a pause stage
+// to the users approving to continue the deployment.
+type ApprovalRegistry struct {
+	sync.RWMutex
+	m map[int]chan deploy.Approval
+	// The deployments currently waiting in a pause stage, see Listener
+	pending map[int]PendingApproval
+	// The IDs of the deployers of the deployments that need a second
+	// approval before they start, who can't approve them themselves
+	deployers map[int]int
+}
+
+func NewApprovalRegistry() *ApprovalRegistry {
+	return &ApprovalRegistry{
+		m:         make(map[int]chan deploy.Approval),
+		pending:   make(map[int]PendingApproval),
+		deployers: make(map[int]int),
+	}
+}
+
+func (ar *ApprovalRegistry) Add(deploymentId int) chan deploy.Approval {
+	c := make(chan deploy.Approval)
+
+	ar.Lock()
+	ar.m[deploymentId] = c
+	ar.Unlock()
+
+	return c
+}
+
+func (ar *ApprovalRegistry) Remove(deploymentId int) {
+	ar.Lock()
+	delete(ar.m, deploymentId)
+	delete(ar.pending, deploymentId)
+	delete(ar.deployers, deploymentId)
+	ar.Unlock()
+}
+
+// RequireSecondApproval keeps the deployer from giving the next approval of
+// the deployment, the one to start it.
+func (ar *ApprovalRegistry) RequireSecondApproval(deploymentId, deployerId int) {
+	ar.Lock()
+	ar.deployers[deploymentId] = deployerId
+	ar.Unlock()
+}
+
+// CanApprove checks whether the user may approve the deployment. Only its
+// deployer can't, while it waits for the second approval.
+func (ar *ApprovalRegistry) CanApprove(deploymentId, userId int) bool {
+	ar.RLock()
+	defer ar.RUnlock()
+
+	deployerId, ok := ar.deployers[deploymentId]
+	return !ok || deployerId != userId
+}
+
+// Approve passes the name of the approving user to the manager of the
+// deployment. It doesn't block if the deployment is not currently paused.
+func (ar *ApprovalRegistry) Approve(deploymentId int, userName string) error {
+	return ar.decide(deploymentId, deploy.Approval{User: userName})
+}
+
+// Reject fails the deployment in the pause stage it's waiting in. Like
+// killed deployments, rejected ones aren't retried.
+func (ar *ApprovalRegistry) Reject(deploymentId int, userName string) error {
+	return ar.decide(deploymentId, deploy.Approval{User: userName, Rejected: true})
+}
+
+func (ar *ApprovalRegistry) decide(deploymentId int, approval deploy.Approval) error {
+	ar.RLock()
+	c, ok := ar.m[deploymentId]
+	ar.RUnlock()
+
+	if !ok {
+		return ErrNotWaitingForApproval
+	}
+
+	select {
+	case c <- approval:
+		ar.Lock()
+		delete(ar.pending, deploymentId)
+		delete(ar.deployers, deploymentId)
+		ar.Unlock()
+		return nil
+	default:
+		return ErrNotWaitingForApproval
+	}
+}
+
+// Pending returns the deployments waiting for approval, the longest waiting
+// first.
+func (ar *ApprovalRegistry) Pending() []PendingApproval {
+	ar.RLock()
+	pending := make([]PendingApproval, 0, len(ar.pending))
+	for _, p := range ar.pending {
+		pending = append(pending, p)
+	}
+	ar.RUnlock()
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].Since.Before(pending[j].Since)
+	})
+	return pending
+}
+
+// Listener keeps track of the deployments waiting for approval by watching
+// the log entries of all deployments.
+func (ar *ApprovalRegistry) Listener() deploy.Listener {
+	return func(logs <-chan deploy.LogEntry) {
+		for entry := range logs {
+			ar.trackLogEntry(entry)
+		}
+	}
+}
+
+func (ar *ApprovalRegistry) trackLogEntry(entry deploy.LogEntry) {
+	ar.Lock()
+	defer ar.Unlock()
+
+	switch entry.EntryType {
+	case deploy.APPROVAL_PENDING:
+		ar.pending[entry.DeploymentId] = PendingApproval{
+			DeploymentId: entry.DeploymentId,
+			Stage:        models.DeploymentStage(entry.Message),
+			Since:        entry.Timestamp,
+		}
+	case deploy.APPROVAL_RECEIVED, deploy.STAGE_FAIL, deploy.DEPLOYMENT_SUCCESS, deploy.DEPLOYMENT_FAIL:
+		delete(ar.pending, entry.DeploymentId)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+)
+
+func TestApprovalRegistry(t *testing.T) {
+	registry := NewApprovalRegistry()
+
+	err := registry.Approve(1, "mrnugget")
+	if err != ErrNotWaitingForApproval {
+		t.Errorf("expected ErrNotWaitingForApproval for unknown deployment. got=%v", err)
+	}
+
+	c := registry.Add(1)
+
+	// Nobody is waiting on the channel yet
+	err = registry.Approve(1, "mrnugget")
+	if err != ErrNotWaitingForApproval {
+		t.Errorf("expected ErrNotWaitingForApproval when not paused. got=%v", err)
+	}
+
+	received := make(chan deploy.Approval)
+	ready := make(chan struct{})
+	go func() {
+		close(ready)
+		received <- <-c
+	}()
+	<-ready
+
+	for {
+		if err = registry.Approve(1, "mrnugget"); err == nil {
+			break
+		}
+	}
+
+	if approval := <-received; approval.User != "mrnugget" || approval.Rejected {
+		t.Errorf("wrong approval received. want=%s, got=%+v", "mrnugget", approval)
+	}
+
+	registry.Remove(1)
+	if err = registry.Approve(1, "mrnugget"); err != ErrNotWaitingForApproval {
+		t.Errorf("expected ErrNotWaitingForApproval after removal. got=%v", err)
+	}
+}
+
+func TestApprovalRegistryReject(t *testing.T) {
+	registry := NewApprovalRegistry()
+	c := registry.Add(1)
+
+	received := make(chan deploy.Approval)
+	ready := make(chan struct{})
+	go func() {
+		close(ready)
+		received <- <-c
+	}()
+	<-ready
+
+	for registry.Reject(1, "fabrik42") != nil {
+	}
+
+	if approval := <-received; approval.User != "fabrik42" || !approval.Rejected {
+		t.Errorf("wrong rejection received. got=%+v", approval)
+	}
+}
+
+func TestApprovalRegistrySecondApproval(t *testing.T) {
+	registry := NewApprovalRegistry()
+	c := registry.Add(1)
+	registry.RequireSecondApproval(1, 42)
+
+	received := make(chan deploy.Approval)
+	ready := make(chan struct{})
+	go func() {
+		close(ready)
+		received <- <-c
+		received <- <-c
+	}()
+	<-ready
+
+	if registry.CanApprove(1, 42) {
+		t.Errorf("deployer can approve own deployment")
+	}
+	if !registry.CanApprove(1, 7) {
+		t.Errorf("second user can't approve the deployment")
+	}
+
+	for registry.Approve(1, "fabrik42") != nil {
+	}
+	if approval := <-received; approval.User != "fabrik42" {
+		t.Errorf("wrong approval received. got=%+v", approval)
+	}
+
+	// The deployer may continue the pause stages afterwards
+	if !registry.CanApprove(1, 42) {
+		t.Errorf("deployer can't approve after the second approval")
+	}
+	for registry.Reject(1, "mrnugget") != nil {
+	}
+	if approval := <-received; !approval.Rejected {
+		t.Errorf("wrong rejection received. got=%+v", approval)
+	}
+}
+
+func TestApprovalRegistryPending(t *testing.T) {
+	registry := NewApprovalRegistry()
+	start := time.Now()
+
+	entries := []deploy.LogEntry{
+		{DeploymentId: 1, EntryType: deploy.APPROVAL_PENDING, Message: "approval", Timestamp: start.Add(time.Minute)},
+		{DeploymentId: 2, EntryType: deploy.APPROVAL_PENDING, Message: "qa", Timestamp: start},
+		{DeploymentId: 3, EntryType: deploy.APPROVAL_PENDING, Message: "approval", Timestamp: start},
+		{DeploymentId: 3, EntryType: deploy.APPROVAL_RECEIVED, Message: "approval approved by mrnugget", Timestamp: start},
+		{DeploymentId: 4, EntryType: deploy.APPROVAL_PENDING, Message: "approval", Timestamp: start},
+		{DeploymentId: 4, EntryType: deploy.STAGE_FAIL, Message: "approval", Timestamp: start},
+		{DeploymentId: 5, EntryType: deploy.COMMAND_STDOUT_OUTPUT, Message: "output", Timestamp: start},
+	}
+
+	logs := make(chan deploy.LogEntry, len(entries))
+	for _, e := range entries {
+		logs <- e
+	}
+	close(logs)
+	registry.Listener()(logs)
+
+	pending := registry.Pending()
+	if len(pending) != 2 {
+		t.Fatalf("wrong number of pending approvals. want=%d, got=%d", 2, len(pending))
+	}
+	if pending[0].DeploymentId != 2 || pending[0].Stage != "qa" {
+		t.Errorf("wrong first pending approval. got=%+v", pending[0])
+	}
+	if pending[1].DeploymentId != 1 {
+		t.Errorf("wrong second pending approval. got=%+v", pending[1])
+	}
+
+	registry.Remove(2)
+	if pending := registry.Pending(); len(pending) != 1 {
+		t.Errorf("wrong number of pending approvals after removal. want=%d, got=%d", 1, len(pending))
+	}
+}
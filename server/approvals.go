@@ -0,0 +1,122 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// approvalRequest is a deployment waiting for approval shown in the queue.
+type approvalRequest struct {
+	PendingApproval
+	Application *models.Application
+	Deployment  *models.Deployment
+	// Whether the user can deploy to the target and thus approve or reject
+	CanDecide bool
+	// Whether the user can approve, which the deployer can't while the
+	// deployment waits for its second approval
+	CanApprove bool
+}
+
+// getApprovalRequests loads the deployments waiting for approval in the
+// applications the user can read. The registry tells whether the user may
+// approve them.
+func getApprovalRequests(u *models.User, registry *ApprovalRegistry, pending []PendingApproval) ([]*approvalRequest, error) {
+	requests := []*approvalRequest{}
+	deployments := []*models.Deployment{}
+
+	for _, p := range pending {
+		d, err := getDeployment(db, p.DeploymentId)
+		if err != nil {
+			return nil, err
+		}
+		if d == nil {
+			continue
+		}
+
+		a, err := findApplication(d.ApplicationName)
+		if err != nil || !a.CanRead(u) {
+			continue
+		}
+		t, err := findTarget(a, d.TargetName)
+		if err != nil {
+			continue
+		}
+
+		requests = append(requests, &approvalRequest{
+			PendingApproval: p,
+			Application:     a,
+			Deployment:      d,
+			CanDecide:       a.CanDeploy(t, u),
+			CanApprove:      registry.CanApprove(d.Id, u.Id),
+		})
+		deployments = append(deployments, d)
+	}
+
+	err := loadDeploymentsUsers(db, deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	return requests, nil
+}
+
+// approvalsHandler lists the deployments waiting in a pause stage across all
+// applications, the longest waiting first.
+func approvalsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	requests, err := getApprovalRequests(currentUser, approvalRegistry, approvalRegistry.Pending())
+	if err != nil {
+		requestLogger(r).Error("error loading the deployments waiting for approval", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "approvals.tmpl", map[string]interface{}{
+		"Applications":     getConfig().Applications,
+		"ApprovalRequests": requests,
+		"currentUser":      currentUser,
+	})
+}
+
+// approvalRequestListener posts the deployments that start waiting for
+// approval to the chats configured for their target.
+func approvalRequestListener(logs <-chan deploy.LogEntry) {
+	for entry := range logs {
+		if entry.EntryType == deploy.APPROVAL_PENDING {
+			go postApprovalRequests(entry.DeploymentId, models.DeploymentStage(entry.Message))
+		}
+	}
+}
+
+func postApprovalRequests(deploymentId int, stage models.DeploymentStage) {
+	deployment, err := getDeployment(db, deploymentId)
+	if err != nil || deployment == nil {
+		slog.Error("loading deployment waiting for approval failed", "deployment.id", deploymentId, "err", err)
+		return
+	}
+	application, err := findApplication(deployment.ApplicationName)
+	if err != nil {
+		return
+	}
+	target, err := findTarget(application, deployment.TargetName)
+	if err != nil || (target.ApprovalSlackUrl == "" && target.ApprovalTeamsUrl == "") {
+		return
+	}
+
+	deployment.User, err = getUser(db, deployment.UserId)
+	if err != nil {
+		deploymentLogger(deployment).Error("loading deployer failed", "err", err)
+		return
+	}
+
+	if target.ApprovalSlackUrl != "" {
+		postSlackApprovalRequest(application, target, deployment, stage)
+	}
+	if target.ApprovalTeamsUrl != "" {
+		postTeamsApprovalRequest(application, target, deployment, stage)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestGetApprovalRequests(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{Applications: []*models.Application{
+		{
+			Name:          "flincOnRails",
+			ReadUsernames: []string{"mrnugget", "fabrik42"},
+			Targets: []*models.Target{
+				{Name: "production", DeployUsernames: []string{"mrnugget"}},
+			},
+		},
+		{
+			Name:          "secret",
+			ReadUsernames: []string{"fabrik42"},
+			Targets:       []*models.Target{{Name: "production"}},
+		},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+
+	visible := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, visible))
+
+	secret := buildDeployment(user.Id)
+	secret.ApplicationName = "secret"
+	checkErr(t, createDeployment(db, secret))
+
+	pending := []PendingApproval{
+		{DeploymentId: visible.Id, Stage: "approval", Since: time.Now()},
+		{DeploymentId: secret.Id, Stage: "approval", Since: time.Now()},
+		{DeploymentId: 9999, Stage: "approval", Since: time.Now()},
+	}
+
+	registry := NewApprovalRegistry()
+	requests, err := getApprovalRequests(user, registry, pending)
+	checkErr(t, err)
+
+	if len(requests) != 1 {
+		t.Fatalf("wrong number of approval requests. want=%d, got=%d", 1, len(requests))
+	}
+	r := requests[0]
+	if r.Deployment.Id != visible.Id || r.Stage != "approval" {
+		t.Errorf("wrong approval request. want=%d, got=%d", visible.Id, r.Deployment.Id)
+	}
+	if !r.CanDecide {
+		t.Errorf("deployer can't decide on the approval request")
+	}
+	if !r.CanApprove {
+		t.Errorf("deployer can't approve the approval request")
+	}
+	if r.Deployment.User == nil || r.Deployment.User.Name != "mrnugget" {
+		t.Errorf("user of the deployment not loaded. got=%v", r.Deployment.User)
+	}
+
+	other := buildUser(2, "fabrik42")
+	requests, err = getApprovalRequests(other, registry, pending[:1])
+	checkErr(t, err)
+	if len(requests) != 1 || requests[0].CanDecide {
+		t.Errorf("reader can decide on the approval request")
+	}
+
+	// The deployer can't give the second approval
+	registry.RequireSecondApproval(visible.Id, user.Id)
+	requests, err = getApprovalRequests(user, registry, pending[:1])
+	checkErr(t, err)
+	if len(requests) != 1 || requests[0].CanApprove {
+		t.Errorf("deployer can give the second approval")
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// embeddedAssets are the static assets and templates built into the binary.
+//
+//go:embed assets
+var embeddedAssets embed.FS
+
+// openAssets returns the assets served under /assets/: the ones built into
+// the binary, or the ones in dir if it's set, e.g. to see changes to the
+// assets and templates without rebuilding during development.
+func openAssets(dir string) (fs.FS, error) {
+	if dir != "" {
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(embeddedAssets, "assets")
+}
+
+// openTemplates returns the templates in dir if it's set, otherwise the ones
+// in the templates directory of the assets.
+func openTemplates(assets fs.FS, dir string) (fs.FS, error) {
+	if dir != "" {
+		return os.DirFS(dir), nil
+	}
+	return fs.Sub(assets, "templates")
+}
@@ -82,6 +82,154 @@ Diff.prototype.behindBy = function() {
   return this.rawJson.behind_by;
 }
 
+// Matches the SGR sequences that set colors and styles (like "\e[1;31m"),
+// other CSI sequences, OSC sequences (like terminal titles) and the
+// remaining two-character escape sequences.
+var ansiPattern = /\u001b\[([0-9;]*)([@-~])|\u001b\][^\u0007\u001b]*(?:\u0007|\u001b\\)|\u001b[@-Z\\-_]/g;
+
+function escapeHtml(text) {
+  return text.replace(/&/g, '&amp;')
+             .replace(/</g, '&lt;')
+             .replace(/>/g, '&gt;')
+             .replace(/"/g, '&quot;')
+             .replace(/'/g, '&#39;');
+}
+
+// ansiColor returns the class suffix of the 16 standard colors, or an rgb()
+// value for the 256 colors and true colors.
+function ansiColor(codes, i) {
+  var clamp = function(c) { return Math.min(255, Math.max(0, c | 0)); };
+
+  if (codes[i + 1] === 5 && !isNaN(codes[i + 2])) {
+    var n = clamp(codes[i + 2]);
+    if (n < 16) return {value: n, length: 2};
+    if (n >= 232) {
+      var level = 8 + (n - 232) * 10;
+      return {value: 'rgb(' + level + ',' + level + ',' + level + ')', length: 2};
+    }
+    var steps = [0, 95, 135, 175, 215, 255];
+    n -= 16;
+    return {value: 'rgb(' + steps[Math.floor(n / 36)] + ',' + steps[Math.floor(n / 6) % 6] + ',' + steps[n % 6] + ')', length: 2};
+  }
+  if (codes[i + 1] === 2 && codes.length > i + 4) {
+    return {value: 'rgb(' + [codes[i + 2], codes[i + 3], codes[i + 4]].map(clamp).join(',') + ')', length: 4};
+  }
+  return {value: undefined, length: 0};
+}
+
+// ansiToHtml escapes the output of a command and converts its ANSI colors,
+// e.g. of rake, npm or cargo, to spans. Other escape sequences are dropped.
+function ansiToHtml(text) {
+  var style = {};
+  var html  = '';
+  var last  = 0;
+  var open  = false;
+  var match;
+
+  var applySGR = function(params) {
+    var codes = params.split(';').map(Number);
+    for (var i = 0; i < codes.length; i++) {
+      var c = codes[i];
+      if (c === 0)                  { style = {}; }
+      else if (c === 1)             { style.bold = true; }
+      else if (c === 3)             { style.italic = true; }
+      else if (c === 4)             { style.underline = true; }
+      else if (c === 22)            { style.bold = false; }
+      else if (c === 23)            { style.italic = false; }
+      else if (c === 24)            { style.underline = false; }
+      else if (c >= 30 && c <= 37)  { style.fg = c - 30; }
+      else if (c >= 90 && c <= 97)  { style.fg = c - 90 + 8; }
+      else if (c === 39)            { style.fg = undefined; }
+      else if (c >= 40 && c <= 47)  { style.bg = c - 40; }
+      else if (c >= 100 && c <= 107) { style.bg = c - 100 + 8; }
+      else if (c === 49)            { style.bg = undefined; }
+      else if (c === 38 || c === 48) {
+        var color = ansiColor(codes, i);
+        style[c === 38 ? 'fg' : 'bg'] = color.value;
+        i += color.length;
+      }
+    }
+  };
+
+  var openSpan = function() {
+    var classes = [];
+    var css     = [];
+
+    if (style.bold)      classes.push('ansi-bold');
+    if (style.italic)    classes.push('ansi-italic');
+    if (style.underline) classes.push('ansi-underline');
+    if (typeof style.fg === 'number') classes.push('ansi-fg-' + style.fg);
+    else if (style.fg)                css.push('color:' + style.fg);
+    if (typeof style.bg === 'number') classes.push('ansi-bg-' + style.bg);
+    else if (style.bg)                css.push('background-color:' + style.bg);
+
+    if (!classes.length && !css.length) return '';
+
+    open = true;
+    return '<span' +
+      (classes.length ? ' class="' + classes.join(' ') + '"' : '') +
+      (css.length ? ' style="' + css.join(';') + '"' : '') + '>';
+  };
+
+  ansiPattern.lastIndex = 0;
+  while ((match = ansiPattern.exec(text)) !== null) {
+    html += escapeHtml(text.slice(last, match.index));
+    last = ansiPattern.lastIndex;
+
+    if (match[2] !== 'm') continue;
+
+    if (open) {
+      html += '</span>';
+      open = false;
+    }
+    applySGR(match[1]);
+    html += openSpan();
+  }
+  html += escapeHtml(text.slice(last));
+  if (open) html += '</span>';
+
+  return html;
+}
+
+/*
+ *  -------------- TIME ZONE --------------
+ */
+
+// formatTimestamp formats unix timestamps and ISO 8601 dates in the time
+// zone the user chose in the preferences.
+function formatTimestamp(value, timeZone) {
+  var date = /^\d+$/.test(value) ? new Date(parseInt(value, 10) * 1000) : new Date(value);
+  if (isNaN(date.getTime())) {
+    return value;
+  }
+  try {
+    return date.toLocaleString(undefined, {timeZone: timeZone, timeZoneName: 'short'});
+  } catch (e) {
+    // Browsers without support for the time zone
+    return date.toString();
+  }
+}
+
+// livestamp removes data-livestamp when the page is ready, so the timestamps
+// are kept in data-time to show them in the title
+$('abbr[data-livestamp]').each(function() {
+  $(this).attr('data-time', $(this).attr('data-livestamp'));
+});
+
+$(document).on('mouseenter', 'abbr[data-time]', function() {
+  var timeZone = $('body').data('time-zone');
+  if (timeZone) {
+    $(this).attr('title', formatTimestamp(String($(this).attr('data-time')), timeZone));
+  }
+});
+
+// The POST requests have to send the CSRF token of the session along
+$.ajaxPrefilter(function(options, originalOptions, xhr) {
+  if (options.type.toUpperCase() !== 'GET') {
+    xhr.setRequestHeader('X-CSRF-Token', $('meta[name="csrf-token"]').attr('content'));
+  }
+});
+
 $(function() {
   /*
    *  -------------- DETAILS PAGE --------------
@@ -107,6 +255,8 @@ $(function() {
   var logEntryDeploymentFailTemplate    = Hogan.compile($('#logEntryDeploymentFailTemplate').text(), hoganOptions);
   var logEntryDeploymentSuccessTemplate = Hogan.compile($('#logEntryDeploymentSuccessTemplate').text(), hoganOptions);
   var logEntryKillReceivedTemplate      = Hogan.compile($('#logEntryKillReceivedTemplate').text(), hoganOptions);
+  var logEntryApprovalPendingTemplate   = Hogan.compile($('#logEntryApprovalPendingTemplate').text(), hoganOptions);
+  var logEntryApprovalReceivedTemplate  = Hogan.compile($('#logEntryApprovalReceivedTemplate').text(), hoganOptions);
 
   var logEntryTemplates = {
     'COMMAND_STDOUT_OUTPUT':   logEntryStdoutTemplate,
@@ -121,7 +271,18 @@ $(function() {
     'DEPLOYMENT_START':        logEntryDeploymentStartTemplate,
     'DEPLOYMENT_SUCCESS':      logEntryDeploymentSuccessTemplate,
     'DEPLOYMENT_FAIL':         logEntryDeploymentFailTemplate,
-    'KILL_RECEIVED':           logEntryKillReceivedTemplate
+    'KILL_RECEIVED':           logEntryKillReceivedTemplate,
+    'APPROVAL_PENDING':        logEntryApprovalPendingTemplate,
+    'APPROVAL_RECEIVED':       logEntryApprovalReceivedTemplate
+  };
+
+  // Commands taking longer than this (in seconds) are highlighted
+  var slowCommandThreshold = 30;
+
+  var addDuration = function(logEntry) {
+    var seconds = logEntry.duration / 1e9;
+    logEntry.durationText = seconds.toFixed(2) + 's';
+    logEntry.slow = seconds >= slowCommandThreshold;
   };
 
   var labelClasses = function (index, css) {
@@ -139,6 +300,7 @@ $(function() {
   var stateInfo   = $('.deployment-info').find('[data-attr="state-info"]');
   var path        = $('.deployment-info').data('log-path');
   var $killButton = $('.kill-button');
+  var $continueButton = $('.continue-button');
 
   if (path) {
     resizeLogs();
@@ -150,43 +312,387 @@ $(function() {
       $.post(window.location.protocol + '//' + $killButton.data('kill-path'));
     });
 
-    var wsScheme = window.location.protocol === 'https:' ? 'wss://': 'ws://';
-    var wsPath = wsScheme+path;
-    var conn = new WebSocket(wsPath);
+    var $dequeueButton = $('.dequeue-button');
+    $dequeueButton.click(function(event) {
+      event.preventDefault();
+
+      $dequeueButton.attr('disabled', true);
+      $.post(window.location.protocol + '//' + $dequeueButton.data('kill-path')).always(function() {
+        window.location.reload();
+      });
+    });
+
+    // Reload queued deployments until they have been started
+    if (state === 'queued') {
+      setTimeout(function() { window.location.reload(); }, 5000);
+    }
+
+    // Both continue and reject buttons are disabled until the next pause
+    // The title and the favicon show the progress of the deployment, so it
+    // can be followed in a background tab
+    var titleBase     = $('.deployment-info').data('title');
+    var stageCount    = $('.deployment-info').data('stage-count');
+    var stagesStarted = 0;
+    var setTitle = function(status) {
+      document.title = status + ' \u2013 ' + titleBase;
+    };
+
+    if (state === 'successful') {
+      setTitle('\u2714 Successful');
+    } else if (state === 'failed') {
+      setTitle('\u2716 Failed');
+    } else if (state === 'queued') {
+      setTitle('Queued');
+    }
+
+    // Desktop notifications about the own deployments, as chosen on the
+    // profile page
+    var notify = $('.deployment-info').data('notify');
+    var notifyDeploymentFinished = function(successful) {
+      if (!notify || !window.Notification || Notification.permission !== 'granted') return;
+      if (successful && notify !== 'finished') return;
+
+      new Notification('Deployment ' + (successful ? 'successful' : 'failed'), {
+        body: window.location.pathname.slice(1),
+        icon: '/assets/favicon.png'
+      });
+    };
+
+    $continueButton.click(function(event) {
+      event.preventDefault();
+
+      if ($(this).hasClass('reject-button') && !window.confirm('Reject this deployment?')) {
+        return;
+      }
+
+      $continueButton.attr('disabled', true);
+      // Deployers can't give the second approval of their own deployments
+      $.post(window.location.protocol + '//' + $(this).data('continue-path')).fail(function(xhr) {
+        window.alert(xhr.responseText);
+        $continueButton.attr('disabled', false);
+      });
+    });
+
+    // The progress matrix shows the state of every stage on every host, one
+    // column per stage as it starts. Stages without commands on a host, e.g.
+    // because none of its roles has a script for it, are shown as skipped.
+    var $progress     = $('.progress-matrix');
+    var currentStage  = null;
+    var progressCells = {};
+
+    var progressLabels = {
+      'pending': ['label-default', 'pending'],
+      'running': ['label-info',    'running'],
+      'success': ['label-success', 'success'],
+      'failed':  ['label-danger',  'failed'],
+      'skipped': ['',              '\u2013']
+    };
 
-    conn.onmessage = function(evt) {
-      var logEntry = JSON.parse(evt.data);
+    var setProgress = function($cell, progress) {
+      var label = progressLabels[progress];
+      $cell.data('progress', progress).empty().append(
+        $('<span class="label">').addClass(label[0]).text(label[1])
+      );
+      if (progress === 'failed') {
+        $cell.parent('tr').addClass('failed');
+      }
+    };
+
+    var addProgressStage = function(stage) {
+      currentStage = stage;
+      progressCells = {};
+
+      $progress.find('thead tr').append($('<th>').text(stage));
+      $progress.find('tbody tr').each(function() {
+        var $cell = $('<td>');
+        setProgress($cell, 'pending');
+        progressCells[$(this).data('host')] = $cell;
+        $(this).append($cell);
+      });
+      $progress.removeClass('hidden');
+      resizeLogs();
+    };
+
+    var finishProgressStage = function(failed) {
+      $.each(progressCells, function(host, $cell) {
+        var progress = $cell.data('progress');
+        if (progress === 'pending') {
+          setProgress($cell, 'skipped');
+        } else if (progress === 'running') {
+          setProgress($cell, failed ? 'failed' : 'success');
+        }
+      });
+      currentStage = null;
+    };
+
+    var updateProgress = function(logEntry) {
+      var type  = logEntry.entry_type;
+      var $cell = currentStage && progressCells[logEntry.origin];
+
+      if (type === 'STAGE_START') {
+        addProgressStage(logEntry.message);
+      } else if (type === 'STAGE_SUCCESS' || type === 'STAGE_FAIL') {
+        finishProgressStage(type === 'STAGE_FAIL');
+      } else if (!$cell || $cell.data('progress') === 'failed') {
+        return;
+      } else if (type === 'COMMAND_START') {
+        setProgress($cell, 'running');
+      } else if (type === 'COMMAND_SUCCESS') {
+        setProgress($cell, 'success');
+      } else if (type === 'COMMAND_FAIL') {
+        setProgress($cell, 'failed');
+      }
+    };
+
+    // The log filter hides entries of other hosts or types. The stream itself
+    // isn't filtered, the state and the progress matrix need every entry.
+    // The groups are the same as the ones of the type parameter of the server.
+    var $logFilter = $('.log-filter');
+    var logFilterGroups = {
+      'failures': ['COMMAND_FAIL', 'STAGE_FAIL', 'DEPLOYMENT_FAIL', 'KILL_RECEIVED'],
+      'output':   ['COMMAND_STDOUT_OUTPUT', 'COMMAND_STDERR_OUTPUT']
+    };
+
+    var matchesLogFilter = function(origin, type) {
+      var filterOrigin = $logFilter.find('[name="origin"]').val();
+      var filterTypes  = logFilterGroups[$logFilter.find('[name="type"]').val()];
+
+      if (filterOrigin && origin !== filterOrigin) return false;
+      if (filterTypes && $.inArray(type, filterTypes) === -1) return false;
+      return true;
+    };
+
+    var applyLogFilter = function() {
+      $logEntries.children('[data-entry-type]').each(function() {
+        var $entry = $(this);
+        $entry.toggleClass('filtered', !matchesLogFilter($entry.data('origin'), $entry.data('entry-type')));
+      });
+
+      // The downloads contain the filtered log, too
+      var query = $logFilter.find('select').filter(function() {
+        return $(this).val();
+      }).serialize();
+      $('.log-downloads a').each(function() {
+        $(this).attr('href', $(this).data('path') + (query ? '?' + query : ''));
+      });
+    };
+
+    $logFilter.find('select').change(applyLogFilter);
+
+    $progress.find('tbody th a').click(function(event) {
+      event.preventDefault();
+
+      $logFilter.find('[name="origin"]').val($(this).closest('tr').data('host'));
+      applyLogFilter();
+    });
+
+    // The sequence of the last entry received, reconnects continue after it
+    var lastSequence = 0;
+
+    // Long logs only show the entries after logOutputAfter at first. The
+    // earlier stages and commands are streamed for the progress matrix, but
+    // only shown with the earlier output, which is loaded on demand.
+    var logOutputAfter = $('.deployment-info').data('log-output-after') || 0;
+    var logEntriesPath = $('.deployment-info').data('log-entries-path');
+    var firstSequence  = logOutputAfter + 1;
+    var $loadEarlier   = $('.load-earlier-log');
+
+    var renderLogEntry = function(logEntry) {
       var type     = logEntry.entry_type;
       var template = logEntryTemplates[type];
-      var rendered = template.render(logEntry);
 
-      $logEntries.append(rendered);
+      if (type === 'COMMAND_SUCCESS' || type === 'COMMAND_FAIL') {
+        addDuration(logEntry);
+      }
+      if (type === 'COMMAND_STDOUT_OUTPUT' || type === 'COMMAND_STDERR_OUTPUT') {
+        logEntry.messageHtml = ansiToHtml(logEntry.message);
+      }
+      var $rendered = $($.parseHTML($.trim(template.render(logEntry))));
+      $rendered.attr('data-origin', logEntry.origin).attr('data-entry-type', type);
+      $rendered.toggleClass('filtered', !matchesLogFilter(logEntry.origin, type));
+      return $rendered;
+    };
+
+    $loadEarlier.click(function() {
+      $loadEarlier.attr('disabled', true);
+      $.getJSON(logEntriesPath, {before: firstSequence}).done(function(logEntries) {
+        var $chunk = $('<div>');
+        $.each(logEntries, function(i, logEntry) {
+          $chunk.append(renderLogEntry(logEntry));
+        });
+
+        // Keep the entries that were shown in place
+        var scrollHeight = $logEntries[0].scrollHeight;
+        $loadEarlier.after($chunk.children());
+        $logEntries[0].scrollTop += $logEntries[0].scrollHeight - scrollHeight;
+
+        if (!logEntries.length || logEntries[0].sequence <= 1) {
+          $loadEarlier.remove();
+          return;
+        }
+        firstSequence = logEntries[0].sequence;
+        $loadEarlier.attr('disabled', false);
+      }).fail(function() {
+        $loadEarlier.attr('disabled', false);
+      });
+    });
+
+    var addLogEntry = function(logEntry) {
+      var type = logEntry.entry_type;
+
+      lastSequence = logEntry.sequence;
+
+      if (logEntry.sequence > logOutputAfter) {
+        $logEntries.append(renderLogEntry(logEntry));
+      }
+      if ($progress.length) {
+        updateProgress(logEntry);
+      }
 
       if (state !== 'active' && state !== 'new') return;
 
       $logEntries[0].scrollTop = $logEntries[0].scrollHeight;
 
       if (type === 'DEPLOYMENT_START') {
+        setTitle('Running');
         Favicon.startRotation();
+      } else if (type === 'STAGE_START') {
+        stagesStarted++;
+        setTitle((stageCount ? '[' + stagesStarted + '/' + stageCount + '] ' : '') + logEntry.message);
       } else if (type === 'DEPLOYMENT_SUCCESS') {
-        Favicon.stopRotation();
+        notifyDeploymentFinished(true);
+        setTitle('\u2714 Successful');
+        Favicon.showState('successful');
         stateInfo.removeClass(labelClasses).addClass('label-success').text('Successful');
         $killButton.remove();
+        $continueButton.remove();
       } else if (type === 'DEPLOYMENT_FAIL') {
-        Favicon.stopRotation();
+        notifyDeploymentFinished(false);
+        setTitle('\u2716 Failed');
+        Favicon.showState('failed');
         stateInfo.removeClass(labelClasses).addClass('label-danger').text('Failed');
         $killButton.remove();
+        $continueButton.remove();
       } else if (type === 'KILL_RECEIVED') {
         $killButton.attr('disabled', true);
+      } else if (type === 'APPROVAL_PENDING') {
+        setTitle('Waiting for approval');
+        Favicon.showState('waiting');
+        $continueButton.removeClass('hidden').attr('disabled', false);
+      } else if (type === 'APPROVAL_RECEIVED') {
+        Favicon.startRotation();
+        $continueButton.addClass('hidden');
       }
     };
+
+    var eventsPath = $('.deployment-info').data('events-path');
+    var wsScheme = window.location.protocol === 'https:' ? 'wss://': 'ws://';
+    var wsPath = wsScheme+path;
+    var wsOpened = false;
+    // The server closes the connection with "Try Again Later" if the browser
+    // fell behind the log of the running deployment
+    var wsTryAgainLater = 1013;
+
+    // Reconnects continue after the last entry received
+    var streamQuery = function() {
+      if (lastSequence) return '?after=' + lastSequence;
+      if (logOutputAfter) return '?output_after=' + logOutputAfter;
+      return '';
+    };
+
+    var connect = function() {
+      var conn = new WebSocket(wsPath + streamQuery());
+
+      conn.onopen = function() {
+        wsOpened = true;
+      };
+
+      conn.onmessage = function(evt) {
+        addLogEntry(JSON.parse(evt.data));
+      };
+
+      conn.onclose = function(evt) {
+        if (evt.code === wsTryAgainLater) {
+          setTimeout(connect, 1000);
+        } else if (!wsOpened) {
+          streamEvents();
+        }
+      };
+    };
+
+    // Proxies that break websockets let the connection fail before it opens,
+    // stream the log with Server-Sent Events instead
+    var streamEvents = function() {
+      if (!window.EventSource) return;
+
+      var events = new EventSource(eventsPath + streamQuery());
+      events.addEventListener('log_entry', function(evt) {
+        addLogEntry(JSON.parse(evt.data));
+      });
+      events.addEventListener('done', function() {
+        events.close();
+      });
+    };
+
+    connect();
   }
 
 
+  /*
+   *  -------------- PROFILE PAGE --------------
+   */
+
+  $('#deployment_notifications').change(function() {
+    if ($(this).val() && window.Notification && Notification.permission === 'default') {
+      Notification.requestPermission();
+    }
+  });
+
+  /*
+   *  -------------- APPROVALS PAGE --------------
+   */
+
+  $('.approval-decision').click(function(event) {
+    event.preventDefault();
+
+    var $button = $(this);
+    var question = $button.data('confirm');
+    if (question && !window.confirm(question)) {
+      return;
+    }
+
+    $button.closest('tr').find('.approval-decision').attr('disabled', true);
+    $.post($button.data('path')).always(function() {
+      window.location.reload();
+    });
+  });
+
+  /*
+   *  -------------- ADMIN ACTIVE DEPLOYMENTS PAGE --------------
+   */
+
+  $('.admin-active-deployments-form').submit(function(event) {
+    if (!window.confirm($(this).data('confirm'))) {
+      event.preventDefault();
+    }
+  });
+
+  // Reload the page to show the progress of the deployments
+  if ($('.admin-active-deployments').length) {
+    setTimeout(function() { window.location.reload(); }, 5000);
+  }
+
   /*
    *  -------------- INDEX PAGE --------------
    */
 
+  // The access token has been rejected and the user has been logged out
+  $(document).ajaxError(function(event, xhr) {
+    if (xhr.status === 401) {
+      window.location = '/oauth2/authorize';
+    }
+  });
+
   var $pulls    = $('.pulls');
   var pullsPath = $pulls.data('pulls-path');
 
@@ -199,14 +705,17 @@ $(function() {
     $('.deploy-pull-request').click(function(event) {
       var commitSha = $(this).data('pull-request-head-sha');
       var branch    = $(this).data('pull-request-head-ref');
+      var number    = $(this).data('pull-request-number');
 
       $('input[name=commitsha]').val(commitSha).trigger('change');
       $('input[name=branch]').val(branch);
+      $('input[name=pull_request]').val(number);
+      $('select[name=tag]').val('');
     });
   };
 
   var showPullRequestsError = function(xhr, textstatus, error) {
-    var rendered = errorMessageTemplate.render({message: 'Something went wrong while fetching pull requests from GitHub'});
+    var rendered = errorMessageTemplate.render({message: 'Something went wrong while fetching pull requests'});
     $pulls.replaceWith(rendered);
   };
 
@@ -235,6 +744,8 @@ $(function() {
 
       $('input[name=commitsha]').val(commitSha).trigger('change');
       $('input[name=branch]').val(branch);
+      $('input[name=pull_request]').val('');
+      $('select[name=tag]').val('');
     });
 
     $('[data-action=toggle-full-message]').click(function(event) {
@@ -244,7 +755,7 @@ $(function() {
   };
 
   var showBranchesError = function(xhr, textstatus, error) {
-    var rendered = errorMessageTemplate.render({message: 'Something went wrong while fetching branch status from GitHub'});
+    var rendered = errorMessageTemplate.render({message: 'Something went wrong while fetching branch status'});
     $branches.replaceWith(rendered);
   };
 
@@ -257,6 +768,36 @@ $(function() {
     });
   }
 
+  var $tags    = $('.js-tags');
+  var tagsPath = $tags.data('tags-path');
+
+  var addLoadedTags = function(data) {
+    data.forEach(function(tag) {
+      $('<option>').val(tag.name).text(tag.name).data('sha', tag.commit.sha).appendTo($tags);
+    });
+    // The tag of a redeployed deployment
+    if ($tags.data('selected')) {
+      $tags.val($tags.data('selected'));
+    }
+  };
+
+  if (tagsPath) {
+    $.ajax({
+      url: tagsPath,
+      dataType: 'json',
+      success: addLoadedTags
+    });
+  }
+
+  $tags.change(function() {
+    var sha = $(this).find('option:selected').data('sha');
+    if (!sha) return;
+
+    $('input[name=commitsha]').val(sha).trigger('change');
+    $('input[name=branch]').val('');
+    $('input[name=pull_request]').val('');
+  });
+
   var activeApplication = (/^\/([^/]+)/).exec(window.location.pathname);
   if (activeApplication) {
     $('.application-list a:contains("' + activeApplication[1] + '")').parent('li').addClass('active');
@@ -276,6 +817,35 @@ $(function() {
     $('input[name=commitsha]').trigger('change');
   });
 
+  // The target of a redeploy isn't necessarily the first one, show its stages
+  if ($('input[name=redeploy_of]').length) {
+    $('select[name="target"]').trigger('change');
+  }
+
+  $('.js-deploy-preset').change(function() {
+    var preset = $(this).find('option:selected');
+    if (!preset.val()) return;
+
+    $('select[name="target"]').val(preset.data('target')).trigger('change');
+    $('input[name=branch]').val(preset.data('branch'));
+    $('textarea[name=overrides]').val(preset.data('overrides'));
+
+    var prefix = preset.data('comment-prefix');
+    var comment = $('.js-deployment-comment');
+    if (prefix && comment.val().indexOf(prefix) !== 0) {
+      comment.val($.trim(prefix + ' ' + comment.val()));
+    }
+
+    // Presets without stages deploy all stages of the target
+    var stages = preset.data('stages') ? String(preset.data('stages')).split(',') : [];
+    stagesContainer.find('input[name="stages[]"]').each(function() {
+      $(this).prop('checked', stages.length === 0 || stages.indexOf($(this).val()) !== -1);
+    });
+    if (stages.length) {
+      stagesContainer.removeClass('hidden');
+    }
+  });
+
   var showAdvancedToggle = $('.js-toggle-advanced');
   showAdvancedToggle.click(function(e) {
     e.preventDefault();
@@ -294,7 +864,7 @@ $(function() {
 
   var showDiffError = function(xhr, textstatus, error) {
     var rendered = errorMessageTemplate.render({
-      message: 'Something went wrong while fetching the diff from GitHub'
+      message: 'Something went wrong while fetching the diff'
     });
     $('.js-diff-container').empty().append(rendered);
   };
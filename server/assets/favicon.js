@@ -14,6 +14,13 @@ var Favicon = (function() {
 
   var rotationPerFrame = 4; // degrees
 
+  // The colors of the badge marking the state of the deployment
+  var stateColors = {
+    waiting:    '#5bc0de',
+    successful: '#5cb85c',
+    failed:     '#d9534f'
+  };
+
   var canvas = document.createElement('canvas');
   canvas.width = canvas.height = faviconSize;
 
@@ -47,21 +54,54 @@ var Favicon = (function() {
     }, 1000 / framesPerSecond);
   }
 
-  function startRotation() {
-    linkEl = $('link[rel=icon]');
-
+  // withImage calls fn once the original favicon has been loaded. The href
+  // of the link is replaced by the drawn frames, so it's only read once.
+  function withImage(fn) {
+    if (!linkEl) {
+      linkEl = $('link[rel=icon]');
+      img.src = linkEl.attr('href');
+    }
+
+    if (img.complete && img.naturalWidth) {
+      fn();
+    } else {
+      img.onload = fn;
+    }
+  }
 
-    img.onload = _startRotation;
-    img.src = linkEl.attr('href');
+  function startRotation() {
+    withImage(_startRotation);
   }
 
   function stopRotation() {
     return interval && clearInterval(interval);
   }
 
+  // showState stops the rotation and draws the upright favicon with a badge
+  // in the color of the state: waiting, successful or failed.
+  function showState(state) {
+    stopRotation();
+
+    withImage(function() {
+      angle = 0;
+      drawOnCanvas(angle);
+
+      ctx.beginPath();
+      ctx.arc(faviconSize - 8, faviconSize - 8, 7, 0, 2 * Math.PI);
+      ctx.fillStyle = stateColors[state];
+      ctx.fill();
+      ctx.lineWidth = 2;
+      ctx.strokeStyle = '#fff';
+      ctx.stroke();
+
+      linkEl.attr({ href: canvas.toDataURL('image/png') });
+    });
+  }
+
   return {
     startRotation: startRotation,
-    stopRotation: stopRotation
+    stopRotation: stopRotation,
+    showState: showState
   };
 
 })();
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/fs"
+	"testing"
+)
+
+func TestEmbeddedAssets(t *testing.T) {
+	assets, err := openAssets("")
+	checkErr(t, err)
+
+	for _, name := range []string{"applikatoni.js", "applikatoni.css", "favicon.ico", "templates/layout.tmpl"} {
+		if _, err := fs.Stat(assets, name); err != nil {
+			t.Errorf("asset %s not embedded: %s", name, err)
+		}
+	}
+
+	templates, err := openTemplates(assets, "")
+	checkErr(t, err)
+
+	parsed, err := parseTemplates(templates, templatesFiles)
+	checkErr(t, err)
+
+	for _, l := range languages {
+		if len(parsed[l.Code]) != len(templatesFiles) {
+			t.Errorf("wrong number of %s templates. want=%d, got=%d", l.Code, len(templatesFiles), len(parsed[l.Code]))
+		}
+	}
+}
+
+func TestOverrideAssets(t *testing.T) {
+	assets, err := openAssets("./assets")
+	checkErr(t, err)
+
+	templates, err := openTemplates(assets, "")
+	checkErr(t, err)
+
+	if _, err := fs.Stat(templates, "daily_digest.tmpl"); err != nil {
+		t.Errorf("template not found in override directory: %s", err)
+	}
+}
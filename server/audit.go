@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const defaultAuditEventsLimit = 200
+
+// auditFilter selects the audit events shown on the admin page.
+type auditFilter struct {
+	// The name of the user who did the action
+	UserName string
+	Action   models.AuditAction
+	// Events at or after From and before To
+	From  time.Time
+	To    time.Time
+	Limit int
+}
+
+// parseAuditFilter reads the filter from the query parameters user, action,
+// from and to, which are parsed like the ones of the deployments list.
+func parseAuditFilter(q url.Values, loc *time.Location) (*auditFilter, error) {
+	f := &auditFilter{
+		UserName: q.Get("user"),
+		Action:   models.AuditAction(q.Get("action")),
+		Limit:    defaultAuditEventsLimit,
+	}
+
+	if f.Action != "" && !isAuditAction(f.Action) {
+		return nil, fmt.Errorf("unknown action %q", f.Action)
+	}
+
+	var err error
+	f.From, err = parseDeploymentFilterTime("from", q.Get("from"), false, loc)
+	if err != nil {
+		return nil, err
+	}
+	f.To, err = parseDeploymentFilterTime("to", q.Get("to"), true, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func isAuditAction(action models.AuditAction) bool {
+	for _, a := range models.AuditActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+// recordAuditEvent saves that the user did the action in the request. Failing
+// to save it is only logged, the action has already been done.
+func recordAuditEvent(r *http.Request, u *models.User, action models.AuditAction, subject string) {
+	event := &models.AuditEvent{
+		UserId:   u.Id,
+		Action:   action,
+		Subject:  subject,
+		SourceIP: remoteIP(r),
+	}
+
+	err := createAuditEvent(db, event)
+	if err != nil {
+		requestLogger(r).Error("could not record audit event", "action", action, "user", u.Name, "err", err)
+	}
+}
+
+// recordDeploymentAuditEvents saves that the user created the deployment and,
+// if the user deployed outside the deploy windows of the target, the reason.
+func recordDeploymentAuditEvents(r *http.Request, u *models.User, d *models.Deployment) {
+	recordAuditEvent(r, u, models.AUDIT_DEPLOYMENT_CREATE, deploymentAuditSubject(d))
+
+	if d.DeployWindowOverrideReason != "" {
+		subject := fmt.Sprintf("%s: %s", deploymentAuditSubject(d), d.DeployWindowOverrideReason)
+		recordAuditEvent(r, u, models.AUDIT_DEPLOY_WINDOW_OVERRIDE, subject)
+	}
+}
+
+// deploymentAuditSubject names a deployment in the audit log, e.g.
+// "web/production #12".
+func deploymentAuditSubject(d *models.Deployment) string {
+	return fmt.Sprintf("%s/%s #%d", d.ApplicationName, d.TargetName, d.Id)
+}
+
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	filter, err := parseAuditFilter(r.URL.Query(), currentUser.Preferences.Location())
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	events, err := getFilteredAuditEvents(db, filter)
+	if err != nil {
+		requestLogger(r).Error("error loading audit events", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = loadAuditEventsUsers(db, events)
+	if err != nil {
+		requestLogger(r).Error("error loading the users of the audit events", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "admin_audit.tmpl", map[string]interface{}{
+		"Applications": getConfig().Applications,
+		"AuditEvents":  events,
+		"AuditActions": models.AuditActions,
+		"Limit":        filter.Limit,
+		"Query":        r.URL.Query(),
+		"currentUser":  currentUser,
+	})
+}
@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/oauth2"
+)
+
+const (
+	GITHUB_PROVIDER    = "github"
+	GITLAB_PROVIDER    = "gitlab"
+	BITBUCKET_PROVIDER = "bitbucket"
+	GITEA_PROVIDER     = "gitea"
+	OIDC_PROVIDER      = "oidc"
+)
+
+const (
+	defaultGitLabURL  = "https://gitlab.com"
+	bitbucketURL      = "https://bitbucket.org"
+	bitbucketAPI      = "https://api.bitbucket.org/2.0"
+	defaultGiteaTitle = "Gitea"
+
+	defaultOIDCTitle         = "OpenID Connect"
+	defaultOIDCUsernameClaim = "preferred_username"
+)
+
+// An AuthProvider is an OAuth2 provider users can log in with.
+type AuthProvider interface {
+	// Name is used in the login URLs and saved with the users
+	Name() string
+	// Title is shown on the login buttons
+	Title() string
+	OAuth2Config() *oauth2.Config
+	// FetchUser returns the user the token belongs to
+	FetchUser(token *oauth2.Token) (*models.User, error)
+}
+
+type GitHubAuthProvider struct {
+	config *oauth2.Config
+}
+
+func (p *GitHubAuthProvider) Name() string                 { return GITHUB_PROVIDER }
+func (p *GitHubAuthProvider) Title() string                { return "GitHub" }
+func (p *GitHubAuthProvider) OAuth2Config() *oauth2.Config { return p.config }
+
+func (p *GitHubAuthProvider) FetchUser(token *oauth2.Token) (*models.User, error) {
+	user := &models.User{AccessToken: token.AccessToken}
+
+	ghClient := NewGitHubClient(user)
+	err := ghClient.UpdateUser(user)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Groups, err = fetchGitHubGroups(ghClient)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Provider = GITHUB_PROVIDER
+	user.ProviderId = strconv.Itoa(user.Id)
+	// The id of the user in our database is not the GitHub id
+	user.Id = 0
+
+	return user, nil
+}
+
+type GitLabAuthProvider struct {
+	baseURL string
+	config  *oauth2.Config
+}
+
+func NewGitLabAuthProvider(baseURL, clientId, clientSecret, redirectURL string) *GitLabAuthProvider {
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	return &GitLabAuthProvider{
+		baseURL: baseURL,
+		config: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"read_user", "read_api"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/oauth/authorize",
+				TokenURL: baseURL + "/oauth/token",
+			},
+		},
+	}
+}
+
+func (p *GitLabAuthProvider) Name() string                 { return GITLAB_PROVIDER }
+func (p *GitLabAuthProvider) Title() string                { return "GitLab" }
+func (p *GitLabAuthProvider) OAuth2Config() *oauth2.Config { return p.config }
+
+func (p *GitLabAuthProvider) FetchUser(token *oauth2.Token) (*models.User, error) {
+	client := p.config.Client(oauth2.NoContext, token)
+
+	res, err := client.Get(p.baseURL + "/api/v4/user")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("GitLab responded with %d instead of 200", res.StatusCode)
+	}
+
+	gitLabUser := struct {
+		Id        int    `json:"id"`
+		Username  string `json:"username"`
+		AvatarUrl string `json:"avatar_url"`
+	}{}
+
+	err = json.NewDecoder(res.Body).Decode(&gitLabUser)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Name:        gitLabUser.Username,
+		AvatarUrl:   gitLabUser.AvatarUrl,
+		AccessToken: token.AccessToken,
+		Provider:    GITLAB_PROVIDER,
+		ProviderId:  strconv.Itoa(gitLabUser.Id),
+	}
+
+	return user, nil
+}
+
+type BitbucketAuthProvider struct {
+	apiURL string
+	config *oauth2.Config
+}
+
+func NewBitbucketAuthProvider(clientId, clientSecret, redirectURL string) *BitbucketAuthProvider {
+	return &BitbucketAuthProvider{
+		apiURL: bitbucketAPI,
+		config: &oauth2.Config{
+			ClientID:     clientId,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Scopes:       []string{"account", "repository", "pullrequest"},
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  bitbucketURL + "/site/oauth2/authorize",
+				TokenURL: bitbucketURL + "/site/oauth2/access_token",
+			},
+		},
+	}
+}
+
+func (p *BitbucketAuthProvider) Name() string                 { return BITBUCKET_PROVIDER }
+func (p *BitbucketAuthProvider) Title() string                { return "Bitbucket" }
+func (p *BitbucketAuthProvider) OAuth2Config() *oauth2.Config { return p.config }
+
+func (p *BitbucketAuthProvider) FetchUser(token *oauth2.Token) (*models.User, error) {
+	client := p.config.Client(oauth2.NoContext, token)
+
+	res, err := client.Get(p.apiURL + "/user")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("Bitbucket responded with %d instead of 200", res.StatusCode)
+	}
+
+	bitbucketUser := struct {
+		Uuid     string `json:"uuid"`
+		Username string `json:"username"`
+		Nickname string `json:"nickname"`
+		Links    struct {
+			Avatar struct {
+				Href string `json:"href"`
+			} `json:"avatar"`
+		} `json:"links"`
+	}{}
+
+	err = json.NewDecoder(res.Body).Decode(&bitbucketUser)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bitbucket doesn't return the username for all accounts anymore
+	name := bitbucketUser.Username
+	if name == "" {
+		name = bitbucketUser.Nickname
+	}
+
+	user := &models.User{
+		Name:        name,
+		AvatarUrl:   bitbucketUser.Links.Avatar.Href,
+		AccessToken: token.AccessToken,
+		Provider:    BITBUCKET_PROVIDER,
+		ProviderId:  bitbucketUser.Uuid,
+	}
+
+	return user, nil
+}
+
+// GiteaAuthProvider logs users in with a Gitea or Forgejo instance.
+type GiteaAuthProvider struct {
+	title   string
+	baseURL string
+	config  *oauth2.Config
+}
+
+func NewGiteaAuthProvider(c *Configuration) *GiteaAuthProvider {
+	baseURL := c.GiteaBaseURL()
+
+	title := c.GiteaTitle
+	if title == "" {
+		title = defaultGiteaTitle
+	}
+
+	return &GiteaAuthProvider{
+		title:   title,
+		baseURL: baseURL,
+		config: &oauth2.Config{
+			ClientID:     c.GiteaClientId,
+			ClientSecret: c.GiteaClientSecret,
+			RedirectURL:  c.URL("/oauth2/gitea/callback"),
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  baseURL + "/login/oauth/authorize",
+				TokenURL: baseURL + "/login/oauth/access_token",
+			},
+		},
+	}
+}
+
+func (p *GiteaAuthProvider) Name() string                 { return GITEA_PROVIDER }
+func (p *GiteaAuthProvider) Title() string                { return p.title }
+func (p *GiteaAuthProvider) OAuth2Config() *oauth2.Config { return p.config }
+
+func (p *GiteaAuthProvider) FetchUser(token *oauth2.Token) (*models.User, error) {
+	client := p.config.Client(oauth2.NoContext, token)
+
+	res, err := client.Get(p.baseURL + "/api/v1/user")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("Gitea responded with %d instead of 200", res.StatusCode)
+	}
+
+	giteaUser := struct {
+		Id        int    `json:"id"`
+		Login     string `json:"login"`
+		AvatarUrl string `json:"avatar_url"`
+	}{}
+
+	err = json.NewDecoder(res.Body).Decode(&giteaUser)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Name:        giteaUser.Login,
+		AvatarUrl:   giteaUser.AvatarUrl,
+		AccessToken: token.AccessToken,
+		Provider:    GITEA_PROVIDER,
+		ProviderId:  strconv.Itoa(giteaUser.Id),
+	}
+
+	return user, nil
+}
+
+// OIDCAuthProvider logs users in with any OpenID Connect provider, e.g.
+// Okta, Keycloak or Azure AD. The endpoints are discovered from the issuer.
+type OIDCAuthProvider struct {
+	title            string
+	usernameClaim    string
+	userinfoEndpoint string
+	config           *oauth2.Config
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	UserinfoEndpoint      string `json:"userinfo_endpoint"`
+}
+
+func NewOIDCAuthProvider(c *Configuration) (*OIDCAuthProvider, error) {
+	issuerURL := strings.TrimRight(c.OIDCIssuerURL, "/")
+
+	res, err := http.Get(issuerURL + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("OpenID Connect discovery responded with %d instead of 200", res.StatusCode)
+	}
+
+	discovery := &oidcDiscovery{}
+	err = json.NewDecoder(res.Body).Decode(discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.TrimRight(discovery.Issuer, "/") != issuerURL {
+		return nil, fmt.Errorf("issuer %q of discovery document does not match %q", discovery.Issuer, issuerURL)
+	}
+	if discovery.UserinfoEndpoint == "" {
+		return nil, fmt.Errorf("issuer %q has no userinfo endpoint", issuerURL)
+	}
+
+	scopes := c.OIDCScopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile"}
+	}
+
+	title := c.OIDCTitle
+	if title == "" {
+		title = defaultOIDCTitle
+	}
+
+	usernameClaim := c.OIDCUsernameClaim
+	if usernameClaim == "" {
+		usernameClaim = defaultOIDCUsernameClaim
+	}
+
+	provider := &OIDCAuthProvider{
+		title:            title,
+		usernameClaim:    usernameClaim,
+		userinfoEndpoint: discovery.UserinfoEndpoint,
+		config: &oauth2.Config{
+			ClientID:     c.OIDCClientId,
+			ClientSecret: c.OIDCClientSecret,
+			RedirectURL:  c.URL("/oauth2/oidc/callback"),
+			Scopes:       scopes,
+			Endpoint: oauth2.Endpoint{
+				AuthURL:  discovery.AuthorizationEndpoint,
+				TokenURL: discovery.TokenEndpoint,
+			},
+		},
+	}
+
+	return provider, nil
+}
+
+func (p *OIDCAuthProvider) Name() string                 { return OIDC_PROVIDER }
+func (p *OIDCAuthProvider) Title() string                { return p.title }
+func (p *OIDCAuthProvider) OAuth2Config() *oauth2.Config { return p.config }
+
+func (p *OIDCAuthProvider) FetchUser(token *oauth2.Token) (*models.User, error) {
+	client := p.config.Client(oauth2.NoContext, token)
+
+	res, err := client.Get(p.userinfoEndpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("OpenID Connect userinfo responded with %d instead of 200", res.StatusCode)
+	}
+
+	claims := map[string]interface{}{}
+	err = json.NewDecoder(res.Body).Decode(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return nil, errors.New("userinfo contains no sub claim")
+	}
+
+	name, _ := claims[p.usernameClaim].(string)
+	if name == "" {
+		return nil, fmt.Errorf("userinfo contains no %s claim", p.usernameClaim)
+	}
+
+	picture, _ := claims["picture"].(string)
+
+	user := &models.User{
+		Name:        name,
+		AvatarUrl:   picture,
+		AccessToken: token.AccessToken,
+		Provider:    OIDC_PROVIDER,
+		ProviderId:  sub,
+	}
+
+	return user, nil
+}
+
+// ErrLoginRequired is returned if the access token of a user can't be
+// refreshed and the user has to log in again.
+var ErrLoginRequired = errors.New("access token expired, login required")
+
+func setUserToken(u *models.User, token *oauth2.Token) {
+	u.AccessToken = token.AccessToken
+	u.RefreshToken = token.RefreshToken
+	u.TokenExpiry = nil
+	if !token.Expiry.IsZero() {
+		expiry := token.Expiry
+		u.TokenExpiry = &expiry
+	}
+}
+
+func tokenExpired(u *models.User) bool {
+	return u.TokenExpiry != nil && u.TokenExpiry.Before(time.Now())
+}
+
+// refreshUserToken gets a new access token for the user with its refresh
+// token and saves it.
+func refreshUserToken(db *sql.DB, u *models.User) error {
+	provider := findAuthProvider(u.Provider)
+	if provider == nil || u.RefreshToken == "" {
+		return ErrLoginRequired
+	}
+
+	expired := &oauth2.Token{
+		AccessToken:  u.AccessToken,
+		RefreshToken: u.RefreshToken,
+		Expiry:       *u.TokenExpiry,
+	}
+
+	token, err := provider.OAuth2Config().TokenSource(oauth2.NoContext, expired).Token()
+	if err != nil {
+		return err
+	}
+
+	setUserToken(u, token)
+	return updateUserToken(db, u)
+}
+
+// setupAuthProviders returns the providers that are configured in c, in the
+// order their login buttons are shown.
+func setupAuthProviders(c *Configuration) ([]AuthProvider, error) {
+	providers := []AuthProvider{}
+
+	if c.GitHubClientId != "" {
+		providers = append(providers, &GitHubAuthProvider{config: oauthCfg})
+	}
+
+	if c.GitLabClientId != "" {
+		redirectURL := c.URL("/oauth2/gitlab/callback")
+		gitLab := NewGitLabAuthProvider(c.GitLabBaseURL(), c.GitLabClientId, c.GitLabClientSecret, redirectURL)
+		providers = append(providers, gitLab)
+	}
+
+	if c.BitbucketClientId != "" {
+		redirectURL := c.URL("/oauth2/bitbucket/callback")
+		bitbucket := NewBitbucketAuthProvider(c.BitbucketClientId, c.BitbucketClientSecret, redirectURL)
+		providers = append(providers, bitbucket)
+	}
+
+	if c.GiteaClientId != "" {
+		providers = append(providers, NewGiteaAuthProvider(c))
+	}
+
+	if c.OIDCIssuerURL != "" {
+		oidc, err := NewOIDCAuthProvider(c)
+		if err != nil {
+			return nil, fmt.Errorf("OpenID Connect discovery failed: %s", err)
+		}
+		providers = append(providers, oidc)
+	}
+
+	return providers, nil
+}
+
+func findAuthProvider(name string) AuthProvider {
+	for _, p := range authProviders {
+		if p.Name() == name {
+			return p
+		}
+	}
+	return nil
+}
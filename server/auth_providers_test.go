@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/oauth2"
+)
+
+func TestGitLabFetchUser(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/gitlab/api/v4/user" {
+			t.Errorf("wrong path. want=%s, got=%s", "/gitlab/api/v4/user", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			t.Errorf("wrong Authorization header. got=%s", r.Header.Get("Authorization"))
+		}
+		fmt.Fprintln(w, `{"id": 42, "username": "mrnugget", "avatar_url": "http://example.com/a.png"}`)
+	}))
+	defer ts.Close()
+
+	provider := NewGitLabAuthProvider(ts.URL+"/gitlab/", "id", "secret", "http://example.com/oauth2/gitlab/callback")
+
+	if provider.OAuth2Config().Endpoint.AuthURL != ts.URL+"/gitlab/oauth/authorize" {
+		t.Errorf("wrong auth URL. got=%s", provider.OAuth2Config().Endpoint.AuthURL)
+	}
+
+	user, err := provider.FetchUser(&oauth2.Token{AccessToken: "t0k3n"})
+	if err != nil {
+		t.Fatalf("fetching user failed: %s", err)
+	}
+
+	if user.Name != "mrnugget" {
+		t.Errorf("wrong name. want=%s, got=%s", "mrnugget", user.Name)
+	}
+	if user.Provider != GITLAB_PROVIDER {
+		t.Errorf("wrong provider. want=%s, got=%s", GITLAB_PROVIDER, user.Provider)
+	}
+	if user.ProviderId != "42" {
+		t.Errorf("wrong provider id. want=%s, got=%s", "42", user.ProviderId)
+	}
+	if user.AccessToken != "t0k3n" {
+		t.Errorf("wrong access token. want=%s, got=%s", "t0k3n", user.AccessToken)
+	}
+}
+
+func TestSetupAuthProviders(t *testing.T) {
+	c := &Configuration{
+		Host:           "applikatoni.example.com",
+		SSLEnabled:     true,
+		GitHubClientId: "github",
+		GitLabClientId: "gitlab",
+	}
+
+	providers, err := setupAuthProviders(c)
+	if err != nil {
+		t.Fatalf("setting up providers failed: %s", err)
+	}
+	if len(providers) != 2 {
+		t.Fatalf("wrong number of providers. want=%d, got=%d", 2, len(providers))
+	}
+
+	gitLab := providers[1]
+	if gitLab.Name() != GITLAB_PROVIDER {
+		t.Errorf("wrong provider. want=%s, got=%s", GITLAB_PROVIDER, gitLab.Name())
+	}
+
+	expected := "https://applikatoni.example.com/oauth2/gitlab/callback"
+	if gitLab.OAuth2Config().RedirectURL != expected {
+		t.Errorf("wrong redirect URL. want=%s, got=%s", expected, gitLab.OAuth2Config().RedirectURL)
+	}
+
+	if gitLab.OAuth2Config().Endpoint.TokenURL != defaultGitLabURL+"/oauth/token" {
+		t.Errorf("wrong token URL. got=%s", gitLab.OAuth2Config().Endpoint.TokenURL)
+	}
+}
+
+func TestBitbucketFetchUser(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user" {
+			t.Errorf("wrong path. want=%s, got=%s", "/user", r.URL.Path)
+		}
+		fmt.Fprintln(w, `{"uuid": "{c0ffee}", "nickname": "mrnugget", "links": {"avatar": {"href": "http://example.com/a.png"}}}`)
+	}))
+	defer ts.Close()
+
+	provider := NewBitbucketAuthProvider("id", "secret", "http://example.com/oauth2/bitbucket/callback")
+	provider.apiURL = ts.URL
+
+	user, err := provider.FetchUser(&oauth2.Token{AccessToken: "t0k3n"})
+	if err != nil {
+		t.Fatalf("fetching user failed: %s", err)
+	}
+
+	if user.Name != "mrnugget" {
+		t.Errorf("wrong name. want=%s, got=%s", "mrnugget", user.Name)
+	}
+	if user.ProviderId != "{c0ffee}" {
+		t.Errorf("wrong provider id. want=%s, got=%s", "{c0ffee}", user.ProviderId)
+	}
+	if user.AvatarUrl != "http://example.com/a.png" {
+		t.Errorf("wrong avatar url. want=%s, got=%s", "http://example.com/a.png", user.AvatarUrl)
+	}
+
+	// The nickname doesn't match the GitHub user with that name
+	target := &models.Target{DeployUsernames: []string{"mrnugget"}}
+	if target.CanDeploy(user) {
+		t.Errorf("Bitbucket user can deploy as GitHub user %s", user.Name)
+	}
+	target.DeployUsernames = []string{"bitbucket:mrnugget"}
+	if !target.CanDeploy(user) {
+		t.Errorf("Bitbucket user listed as %s can't deploy", "bitbucket:mrnugget")
+	}
+}
+
+func TestOIDCAuthProvider(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"issuer": "%s", "authorization_endpoint": "%s/auth", "token_endpoint": "%s/token", "userinfo_endpoint": "%s/userinfo"}`,
+				ts.URL, ts.URL, ts.URL, ts.URL)
+		case "/userinfo":
+			if r.Header.Get("Authorization") != "Bearer t0k3n" {
+				t.Errorf("wrong Authorization header. got=%s", r.Header.Get("Authorization"))
+			}
+			fmt.Fprintln(w, `{"sub": "00u1", "preferred_username": "mrnugget", "email": "mrnugget@example.com"}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer ts.Close()
+
+	c := &Configuration{
+		Host:          "applikatoni.example.com",
+		OIDCIssuerURL: ts.URL + "/",
+		OIDCClientId:  "id",
+		OIDCTitle:     "Okta",
+	}
+
+	provider, err := NewOIDCAuthProvider(c)
+	if err != nil {
+		t.Fatalf("setting up provider failed: %s", err)
+	}
+
+	if provider.Title() != "Okta" {
+		t.Errorf("wrong title. want=%s, got=%s", "Okta", provider.Title())
+	}
+	if provider.OAuth2Config().Endpoint.TokenURL != ts.URL+"/token" {
+		t.Errorf("wrong token URL. got=%s", provider.OAuth2Config().Endpoint.TokenURL)
+	}
+
+	user, err := provider.FetchUser(&oauth2.Token{AccessToken: "t0k3n"})
+	if err != nil {
+		t.Fatalf("fetching user failed: %s", err)
+	}
+	if user.Name != "mrnugget" {
+		t.Errorf("wrong name. want=%s, got=%s", "mrnugget", user.Name)
+	}
+	if user.ProviderId != "00u1" {
+		t.Errorf("wrong provider id. want=%s, got=%s", "00u1", user.ProviderId)
+	}
+
+	provider.usernameClaim = "email"
+	user, err = provider.FetchUser(&oauth2.Token{AccessToken: "t0k3n"})
+	if err != nil {
+		t.Fatalf("fetching user failed: %s", err)
+	}
+	if user.Name != "mrnugget@example.com" {
+		t.Errorf("wrong name. want=%s, got=%s", "mrnugget@example.com", user.Name)
+	}
+	if user.QualifiedName() != "oidc:mrnugget@example.com" {
+		t.Errorf("wrong qualified name. want=%s, got=%s", "oidc:mrnugget@example.com", user.QualifiedName())
+	}
+
+	provider.usernameClaim = "nickname"
+	_, err = provider.FetchUser(&oauth2.Token{AccessToken: "t0k3n"})
+	if err == nil {
+		t.Errorf("expected error for missing username claim")
+	}
+}
+
+func TestRefreshUserToken(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "r3fresh" {
+			t.Errorf("wrong refresh request. form=%v", r.Form)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, `{"access_token": "n3w", "token_type": "bearer", "expires_in": 7200}`)
+	}))
+	defer ts.Close()
+
+	authProviders = []AuthProvider{NewGitLabAuthProvider(ts.URL, "id", "secret", "")}
+	defer func() { authProviders = nil }()
+
+	expiry := time.Now().Add(-time.Minute)
+	user := &models.User{
+		Name:         "mrnugget",
+		Provider:     GITLAB_PROVIDER,
+		ProviderId:   "42",
+		AccessToken:  "0ld",
+		RefreshToken: "r3fresh",
+		TokenExpiry:  &expiry,
+	}
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	if !tokenExpired(user) {
+		t.Fatalf("expected token to be expired")
+	}
+
+	err = refreshUserToken(db, user)
+	if err != nil {
+		t.Fatalf("refreshing token failed: %s", err)
+	}
+
+	saved, err := getUser(db, user.Id)
+	checkErr(t, err)
+
+	if saved.AccessToken != "n3w" {
+		t.Errorf("wrong access token. want=%s, got=%s", "n3w", saved.AccessToken)
+	}
+	// The refresh token is kept if no new one is issued
+	if saved.RefreshToken != "r3fresh" {
+		t.Errorf("wrong refresh token. want=%s, got=%s", "r3fresh", saved.RefreshToken)
+	}
+	if tokenExpired(saved) {
+		t.Errorf("refreshed token is expired. expiry=%s", saved.TokenExpiry)
+	}
+
+	user.RefreshToken = ""
+	user.TokenExpiry = &expiry
+	err = refreshUserToken(db, user)
+	if err != ErrLoginRequired {
+		t.Errorf("wrong error without refresh token. want=%s, got=%v", ErrLoginRequired, err)
+	}
+}
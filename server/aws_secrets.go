@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// References to secrets in AWS look like
+// secretsmanager:applikatoni/production#ssh_key, the ID of the secret and
+// optionally the key of the value if the secret is JSON, or
+// ssm:/applikatoni/production/slack_url, the name of a parameter in the SSM
+// Parameter Store. SecureString parameters are decrypted. Values encrypted
+// with KMS are stored in the configuration as kms: and the base64 encoded
+// ciphertext.
+const (
+	secretsManagerSecretPrefix = "secretsmanager:"
+	ssmSecretPrefix            = "ssm:"
+	kmsSecretPrefix            = "kms:"
+)
+
+// AWSSecretsClient reads secrets from AWS Secrets Manager and the SSM
+// Parameter Store. The credentials are the usual ones of the AWS SDK, e.g. the
+// environment variables or the instance role.
+type AWSSecretsClient struct {
+	getSecretValue func(id string) (string, error)
+	getParameter   func(name string) (string, error)
+	decrypt        func(ciphertext []byte) ([]byte, error)
+	// The secrets read so far, by ID
+	secrets map[string]string
+}
+
+// NewAWSSecretsClient returns a client for the region configured with
+// aws_region, or the region of the environment of the AWS SDK.
+func NewAWSSecretsClient(c *Configuration) (*AWSSecretsClient, error) {
+	cfg := aws.Config{}
+	if c.AWSRegion != "" {
+		cfg.Region = aws.String(c.AWSRegion)
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	secretsManager := secretsmanager.New(sess)
+	parameterStore := ssm.New(sess)
+	keyManagement := kms.New(sess)
+
+	return &AWSSecretsClient{
+		getSecretValue: func(id string) (string, error) {
+			out, err := secretsManager.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+			if err != nil {
+				return "", err
+			}
+			return aws.StringValue(out.SecretString), nil
+		},
+		getParameter: func(name string) (string, error) {
+			out, err := parameterStore.GetParameter(&ssm.GetParameterInput{Name: aws.String(name), WithDecryption: aws.Bool(true)})
+			if err != nil {
+				return "", err
+			}
+			return aws.StringValue(out.Parameter.Value), nil
+		},
+		decrypt: func(ciphertext []byte) ([]byte, error) {
+			out, err := keyManagement.Decrypt(&kms.DecryptInput{CiphertextBlob: ciphertext})
+			if err != nil {
+				return nil, err
+			}
+			return out.Plaintext, nil
+		},
+		secrets: make(map[string]string),
+	}, nil
+}
+
+// SecretsManager returns the resolver of secretsmanager: references.
+func (ac *AWSSecretsClient) SecretsManager() SecretResolver {
+	return secretResolverFunc(ac.resolveSecret)
+}
+
+// ParameterStore returns the resolver of ssm: references.
+func (ac *AWSSecretsClient) ParameterStore() SecretResolver {
+	return secretResolverFunc(ac.getParameter)
+}
+
+// KMS returns the resolver of kms: references.
+func (ac *AWSSecretsClient) KMS() SecretResolver {
+	return secretResolverFunc(ac.decryptSecret)
+}
+
+func (ac *AWSSecretsClient) decryptSecret(ref string) (string, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(ref)
+	if err != nil {
+		return "", fmt.Errorf("ciphertext isn't base64 encoded: %s", err)
+	}
+
+	plaintext, err := ac.decrypt(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+func (ac *AWSSecretsClient) resolveSecret(ref string) (string, error) {
+	id, key := ref, ""
+	if i := strings.LastIndex(ref, "#"); i != -1 {
+		id, key = ref[:i], ref[i+1:]
+	}
+
+	secret, ok := ac.secrets[id]
+	if !ok {
+		var err error
+		secret, err = ac.getSecretValue(id)
+		if err != nil {
+			return "", err
+		}
+		ac.secrets[id] = secret
+	}
+
+	if key == "" {
+		return secret, nil
+	}
+
+	values := map[string]interface{}{}
+	err := json.Unmarshal([]byte(secret), &values)
+	if err != nil {
+		return "", fmt.Errorf("secret %s is no JSON object: %s", id, err)
+	}
+	value, ok := values[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", id, key)
+	}
+	return value, nil
+}
+
+// secretResolverFunc turns a function into a SecretResolver.
+type secretResolverFunc func(ref string) (string, error)
+
+func (f secretResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
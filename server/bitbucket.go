@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/oauth2"
+)
+
+// ErrBitbucketUnauthorized is returned if Bitbucket rejects the access token.
+var ErrBitbucketUnauthorized = errors.New("Bitbucket rejected the access token")
+
+type bitbucketUser struct {
+	Nickname string `json:"nickname"`
+	Links    struct {
+		Avatar struct {
+			Href string `json:"href"`
+		} `json:"avatar"`
+	} `json:"links"`
+}
+
+type bitbucketCommit struct {
+	Hash    string    `json:"hash"`
+	Message string    `json:"message"`
+	Date    time.Time `json:"date"`
+	Author  struct {
+		Raw  string         `json:"raw"`
+		User *bitbucketUser `json:"user"`
+	} `json:"author"`
+	Links struct {
+		Html struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+type bitbucketPullRequest struct {
+	Id        int64         `json:"id"`
+	Title     string        `json:"title"`
+	Author    bitbucketUser `json:"author"`
+	CreatedOn time.Time     `json:"created_on"`
+	UpdatedOn time.Time     `json:"updated_on"`
+	Source    struct {
+		Branch struct {
+			Name string `json:"name"`
+		} `json:"branch"`
+		Commit struct {
+			Hash string `json:"hash"`
+		} `json:"commit"`
+	} `json:"source"`
+	Links struct {
+		Html struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+type bitbucketTag struct {
+	Name   string          `json:"name"`
+	Target bitbucketCommit `json:"target"`
+}
+
+// BitbucketClient accesses the repositories on Bitbucket Cloud. Repositories
+// are identified by "github_owner/github_repo", the workspace and the slug.
+type BitbucketClient struct {
+	*http.Client
+	apiURL string
+}
+
+func NewBitbucketClient(apiURL, accessToken string) *BitbucketClient {
+	token := &oauth2.Token{AccessToken: accessToken}
+	client := oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(token))
+
+	return &BitbucketClient{Client: client, apiURL: apiURL}
+}
+
+func (bc *BitbucketClient) repositoryURL(a *models.Application) string {
+	return fmt.Sprintf("%s/repositories/%s/%s", bc.apiURL, a.GitHubOwner, a.GitHubRepo)
+}
+
+func (bc *BitbucketClient) GetPullRequests(a *models.Application) ([]PullRequest, error) {
+	page := struct {
+		Values []bitbucketPullRequest `json:"values"`
+	}{}
+
+	err := bc.GetDecode(bc.repositoryURL(a)+"/pullrequests?state=OPEN&pagelen=50", &page)
+	if err != nil {
+		return nil, err
+	}
+
+	pulls := []PullRequest{}
+	for _, pr := range page.Values {
+		pull, err := bc.toPullRequest(a, pr)
+		if err != nil {
+			return nil, err
+		}
+		pulls = append(pulls, *pull)
+	}
+
+	err = setPullRequestsTravisImages(a, pulls)
+	if err != nil {
+		return nil, err
+	}
+
+	return pulls, nil
+}
+
+func (bc *BitbucketClient) GetPullRequest(a *models.Application, number int) (*PullRequest, error) {
+	pr := bitbucketPullRequest{}
+
+	err := bc.GetDecode(fmt.Sprintf("%s/pullrequests/%d", bc.repositoryURL(a), number), &pr)
+	if err != nil {
+		return nil, err
+	}
+
+	return bc.toPullRequest(a, pr)
+}
+
+// CommentOnPullRequest adds a comment to the pull request. This requires the
+// "pullrequest:write" scope.
+func (bc *BitbucketClient) CommentOnPullRequest(a *models.Application, number int, body string) error {
+	comment := struct {
+		Content struct {
+			Raw string `json:"raw"`
+		} `json:"content"`
+	}{}
+	comment.Content.Raw = body
+
+	return bc.PostJSON(fmt.Sprintf("%s/pullrequests/%d/comments", bc.repositoryURL(a), number), comment)
+}
+
+// GetTags returns the tags pointing to the most recent commits.
+func (bc *BitbucketClient) GetTags(a *models.Application) ([]Tag, error) {
+	page := struct {
+		Values []bitbucketTag `json:"values"`
+	}{}
+
+	err := bc.GetDecode(bc.repositoryURL(a)+"/refs/tags?sort=-target.date&pagelen=30", &page)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []Tag{}
+	for _, t := range page.Values {
+		tags = append(tags, t.toTag())
+	}
+
+	return tags, nil
+}
+
+func (bc *BitbucketClient) GetTag(a *models.Application, name string) (*Tag, error) {
+	bitbucketTag := bitbucketTag{}
+
+	endpoint := fmt.Sprintf("%s/refs/tags/%s", bc.repositoryURL(a), url.PathEscape(name))
+	err := bc.GetDecode(endpoint, &bitbucketTag)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := bitbucketTag.toTag()
+	return &tag, nil
+}
+
+func (bc *BitbucketClient) GetBranches(a *models.Application) ([]Branch, error) {
+	branches := []Branch{}
+
+	for _, branchName := range a.GitHubBranches {
+		branch, err := bc.GetBranch(a, branchName)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, *branch)
+	}
+
+	err := setBranchesTravisImages(a, branches)
+	if err != nil {
+		return nil, err
+	}
+
+	return branches, nil
+}
+
+func (bc *BitbucketClient) GetBranch(a *models.Application, name string) (*Branch, error) {
+	bitbucketBranch := struct {
+		Name   string          `json:"name"`
+		Target bitbucketCommit `json:"target"`
+	}{}
+
+	endpoint := fmt.Sprintf("%s/refs/branches/%s", bc.repositoryURL(a), url.PathEscape(name))
+	err := bc.GetDecode(endpoint, &bitbucketBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Branch{
+		Name:          bitbucketBranch.Name,
+		CurrentCommit: bitbucketBranch.Target.toCommit(),
+	}, nil
+}
+
+func (bc *BitbucketClient) Compare(a *models.Application, oldSha, newSha string) (*Diff, error) {
+	page := struct {
+		Values []bitbucketCommit `json:"values"`
+	}{}
+
+	query := url.Values{"exclude": {oldSha}, "pagelen": {"100"}}
+	endpoint := fmt.Sprintf("%s/commits/%s?%s", bc.repositoryURL(a), newSha, query.Encode())
+	err := bc.GetDecode(endpoint, &page)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bitbucket only returns the commits newSha is ahead of oldSha
+	diff := &Diff{
+		CompareURL: fmt.Sprintf("%s/branches/compare/%s%%0D%s", repositoryWebURL(a), newSha, oldSha),
+		Status:     "identical",
+		AheadBy:    len(page.Values),
+		Commits:    []Commit{},
+	}
+	if diff.AheadBy > 0 {
+		diff.Status = "ahead"
+	}
+
+	// Bitbucket lists the newest commit first, GitHub the oldest
+	for i := len(page.Values) - 1; i >= 0; i-- {
+		diff.Commits = append(diff.Commits, page.Values[i].toCommit())
+	}
+
+	return diff, nil
+}
+
+func (bc *BitbucketClient) getCommit(a *models.Application, sha string) (*bitbucketCommit, error) {
+	commit := &bitbucketCommit{}
+
+	err := bc.GetDecode(fmt.Sprintf("%s/commit/%s", bc.repositoryURL(a), sha), commit)
+	if err != nil {
+		return nil, err
+	}
+
+	return commit, nil
+}
+
+func (bc *BitbucketClient) GetDecode(url string, v interface{}) error {
+	res, err := bc.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrBitbucketUnauthorized
+	}
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("Bitbucket responded with %d instead of 200", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (bc *BitbucketClient) PostJSON(url string, v interface{}) error {
+	jsonPayload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	res, err := bc.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrBitbucketUnauthorized
+	}
+
+	if res.StatusCode != 201 {
+		return fmt.Errorf("Bitbucket responded with %d instead of 201", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (bc *BitbucketClient) toPullRequest(a *models.Application, pr bitbucketPullRequest) (*PullRequest, error) {
+	// Pull requests only contain abbreviated commit hashes
+	head, err := bc.getCommit(a, pr.Source.Commit.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	pull := &PullRequest{
+		Id:        pr.Id,
+		Number:    int(pr.Id),
+		Url:       pr.Links.Html.Href,
+		Title:     pr.Title,
+		User:      pr.Author.toUser(),
+		CreatedAt: pr.CreatedOn,
+		UpdatedAt: pr.UpdatedOn,
+	}
+	pull.Head.Branch = pr.Source.Branch.Name
+	pull.Head.CommitSha = head.Hash
+
+	return pull, nil
+}
+
+func (t bitbucketTag) toTag() Tag {
+	tag := Tag{Name: t.Name}
+	tag.Commit.Sha = t.Target.Hash
+	return tag
+}
+
+func (u bitbucketUser) toUser() *models.User {
+	return &models.User{Name: u.Nickname, AvatarUrl: u.Links.Avatar.Href}
+}
+
+func (c bitbucketCommit) toCommit() Commit {
+	// Authors without Bitbucket account are only known by their raw
+	// "Name <email>"
+	author := &models.User{Name: c.Author.Raw}
+	if c.Author.User != nil {
+		author = c.Author.User.toUser()
+	}
+
+	commit := Commit{
+		Author:  author,
+		Sha:     c.Hash,
+		HtmlURL: c.Links.Html.Href,
+	}
+	commit.Commit.Message = strings.TrimRight(c.Message, "\n")
+	commit.Commit.Committer.ComittedAt = c.Date
+
+	return commit
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestBitbucketClient(t *testing.T) {
+	fullSha := "f00b4rf00b4rf00b4rf00b4rf00b4rf00b4rf00b"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			t.Errorf("wrong Authorization header. got=%s", r.Header.Get("Authorization"))
+		}
+
+		switch r.URL.EscapedPath() {
+		case "/repositories/shipping-co/web-app/pullrequests":
+			fmt.Fprintln(w, `{"values": [{"id": 7, "title": "Add pizza", "author": {"nickname": "mrnugget"}, "source": {"branch": {"name": "pizza"}, "commit": {"hash": "f00b4rf00b4r"}}, "links": {"html": {"href": "http://example.com/pr/7"}}}]}`)
+		case "/repositories/shipping-co/web-app/commit/f00b4rf00b4r":
+			fmt.Fprintf(w, `{"hash": "%s"}`, fullSha)
+		case "/repositories/shipping-co/web-app/refs/branches/feature%2Fpizza":
+			fmt.Fprintf(w, `{"name": "feature/pizza", "target": {"hash": "%s", "message": "Add pizza\n", "author": {"raw": "Pizza Bot <bot@example.com>"}}}`, fullSha)
+		case "/repositories/shipping-co/web-app/refs/tags":
+			fmt.Fprintf(w, `{"values": [{"name": "v1.0.0", "target": {"hash": "%s"}}]}`, fullSha)
+		case "/repositories/shipping-co/web-app/commits/" + fullSha:
+			if r.URL.Query().Get("exclude") != "0ld" {
+				t.Errorf("wrong exclude. got=%s", r.URL.Query().Get("exclude"))
+			}
+			fmt.Fprintln(w, `{"values": [{"hash": "n3w", "author": {"user": {"nickname": "mrnugget"}}}, {"hash": "0lder"}]}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.EscapedPath())
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	a := &models.Application{
+		SCM:            models.SCM_BITBUCKET,
+		GitHubOwner:    "shipping-co",
+		GitHubRepo:     "web-app",
+		GitHubBranches: []string{"feature/pizza"},
+	}
+	client := NewBitbucketClient(ts.URL, "t0k3n")
+
+	pulls, err := client.GetPullRequests(a)
+	if err != nil {
+		t.Fatalf("loading pull requests failed: %s", err)
+	}
+	if len(pulls) != 1 || pulls[0].Head.CommitSha != fullSha || pulls[0].Head.Branch != "pizza" {
+		t.Errorf("wrong pull requests. got=%+v", pulls)
+	}
+
+	branches, err := client.GetBranches(a)
+	if err != nil {
+		t.Fatalf("loading branches failed: %s", err)
+	}
+	if len(branches) != 1 || branches[0].CurrentCommit.Sha != fullSha {
+		t.Errorf("wrong branches. got=%+v", branches)
+	}
+	if branches[0].CurrentCommit.Author.Name != "Pizza Bot <bot@example.com>" {
+		t.Errorf("wrong author. got=%s", branches[0].CurrentCommit.Author.Name)
+	}
+
+	tags, err := client.GetTags(a)
+	if err != nil {
+		t.Fatalf("loading tags failed: %s", err)
+	}
+	if len(tags) != 1 || tags[0].Name != "v1.0.0" || tags[0].Commit.Sha != fullSha {
+		t.Errorf("wrong tags. got=%+v", tags)
+	}
+
+	diff, err := client.Compare(a, "0ld", fullSha)
+	if err != nil {
+		t.Fatalf("comparing failed: %s", err)
+	}
+	if diff.AheadBy != 2 || len(diff.Commits) != 2 {
+		t.Fatalf("wrong diff. got=%+v", diff)
+	}
+	if diff.Commits[0].Sha != "0lder" || diff.Commits[1].Author.Name != "mrnugget" {
+		t.Errorf("wrong order of commits. got=%+v", diff.Commits)
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"log"
 	"net/http"
 	"net/url"
 )
@@ -10,34 +9,30 @@ const (
 	bugsnagNotifyEndpoint = "https://notify.bugsnag.com/deploy"
 )
 
-func NotifyBugsnag(ev *DeploymentEvent) {
-	if ev.Target.BugsnagApiKey != "" {
-		SendBugsnagRequest(bugsnagNotifyEndpoint, ev)
+func NotifyBugsnag(ev *DeploymentEvent) error {
+	if ev.Target.BugsnagApiKey == "" {
+		return nil
 	}
+	return SendBugsnagRequest(bugsnagNotifyEndpoint, ev)
 }
 
-func SendBugsnagRequest(endpoint string, ev *DeploymentEvent) {
+func SendBugsnagRequest(endpoint string, ev *DeploymentEvent) error {
 	params := url.Values{
 		"apiKey":       {ev.Target.BugsnagApiKey},
 		"releaseStage": {ev.Deployment.TargetName},
-		"repository":   {ev.Application.RepositoryURL()},
+		"repository":   {repositoryURL(ev.Application)},
 		"branch":       {ev.Deployment.Branch},
 		"revision":     {ev.Deployment.CommitSha},
 	}
 
-	resp, err := http.PostForm(endpoint, params)
-	if err != nil {
-		log.Printf("Notifying Bugsnag failed (%s on %s, %s): err=%s\n",
-			ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha, err)
-		return
-	}
-	if resp.StatusCode != 200 {
-		log.Printf("Notifying Bugsnag failed (%s on %s, %s): status=%d\n",
-			ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha,
-			resp.StatusCode)
-		return
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	d := newDelivery("bugsnag", ev.Deployment.Id, "POST", endpoint, header, []byte(params.Encode()), 200)
+	if err := deliver(d); err != nil {
+		deploymentLogger(ev.Deployment).Error("notifying Bugsnag failed", "err", err)
+		metrics.NotifierFailed("bugsnag")
+		return err
 	}
 
-	log.Printf("Successfully notified Bugsnag about deployment of %s on %s, %s!\n",
-		ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha)
+	deploymentLogger(ev.Deployment).Info("notified Bugsnag")
+	return nil
 }
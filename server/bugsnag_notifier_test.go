@@ -9,6 +9,8 @@ import (
 )
 
 func TestNotifyBugsnag(t *testing.T) {
+	setConfig(&Configuration{})
+
 	target := &models.Target{Name: "staging", BugsnagApiKey: "APIKEY"}
 
 	application := &models.Application{
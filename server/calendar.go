@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const calendarMonthLayout = "2006-01"
+
+// calendarDay counts the deployments started on one day. Other are the
+// deployments that haven't finished yet.
+type calendarDay struct {
+	Date       time.Time
+	InMonth    bool
+	Successful int
+	Failed     int
+	Other      int
+}
+
+func (d *calendarDay) Total() int {
+	return d.Successful + d.Failed + d.Other
+}
+
+// Outcome is used as CSS class to color the day: "successful" or "failed" if
+// all finished deployments of the day had that outcome, "mixed" otherwise.
+func (d *calendarDay) Outcome() string {
+	switch {
+	case d.Successful > 0 && d.Failed > 0:
+		return "mixed"
+	case d.Failed > 0:
+		return "failed"
+	case d.Successful > 0:
+		return "successful"
+	}
+	return ""
+}
+
+// calendar is a month of days, in weeks from Monday to Sunday.
+type calendar struct {
+	Month time.Time
+	Weeks [][]*calendarDay
+	// The filters of the deployments, kept by the links
+	Filters url.Values
+}
+
+func (c *calendar) PreviousMonthQuery() string {
+	return c.query("month", c.Month.AddDate(0, -1, 0).Format(calendarMonthLayout))
+}
+
+func (c *calendar) NextMonthQuery() string {
+	return c.query("month", c.Month.AddDate(0, 1, 0).Format(calendarMonthLayout))
+}
+
+// DayQuery is the query of the deployments list of the day.
+func (c *calendar) DayQuery(d *calendarDay) string {
+	date := d.Date.Format(deploymentFilterDateLayout)
+	return c.query("from", date, "to", date)
+}
+
+func (c *calendar) query(pairs ...string) string {
+	q := url.Values{}
+	for k, v := range c.Filters {
+		q[k] = v
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		q.Set(pairs[i], pairs[i+1])
+	}
+	return q.Encode()
+}
+
+// newCalendar builds the calendar of the month and counts the deployments on
+// their days. month has to be the first day of the month.
+func newCalendar(month time.Time, filters url.Values, deployments []*models.Deployment) *calendar {
+	c := &calendar{Month: month, Filters: filters}
+
+	// Go back to the Monday of the first week
+	offset := (int(month.Weekday()) + 6) % 7
+	start := month.AddDate(0, 0, -offset)
+	end := month.AddDate(0, 1, 0)
+
+	days := map[string]*calendarDay{}
+	for day := start; day.Before(end) || day.Weekday() != time.Monday; day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Monday {
+			c.Weeks = append(c.Weeks, []*calendarDay{})
+		}
+		d := &calendarDay{Date: day, InMonth: day.Month() == month.Month()}
+		c.Weeks[len(c.Weeks)-1] = append(c.Weeks[len(c.Weeks)-1], d)
+		days[day.Format(deploymentFilterDateLayout)] = d
+	}
+
+	for _, deployment := range deployments {
+		d, ok := days[deployment.CreatedAt.In(month.Location()).Format(deploymentFilterDateLayout)]
+		if !ok {
+			continue
+		}
+		switch deployment.State {
+		case models.DEPLOYMENT_SUCCESSFUL:
+			d.Successful++
+		case models.DEPLOYMENT_FAILED:
+			d.Failed++
+		default:
+			d.Other++
+		}
+	}
+
+	return c
+}
+
+// calendarHandler shows the deployments of a month per day, colored by their
+// outcome. The days link to the deployments list of that day. It accepts the
+// filters of the deployments list, except from, to and sort.
+func calendarHandler(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	// The days start at midnight in the time zone of the user
+	loc := currentUser.Preferences.Location()
+	now := time.Now().In(loc)
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	if m := r.URL.Query().Get("month"); m != "" {
+		var err error
+		month, err = time.ParseInLocation(calendarMonthLayout, m, loc)
+		if err != nil {
+			http.Error(w, "month must be like 2006-01", 422)
+			return
+		}
+	}
+
+	query := url.Values{}
+	for _, param := range []string{"target", "state", "branch", "user"} {
+		if v := r.URL.Query().Get(param); v != "" {
+			query.Set(param, v)
+		}
+	}
+
+	filter, err := parseDeploymentFilter(application, query, loc)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	filter.From = month
+	filter.To = month.AddDate(0, 1, 0)
+	target, _ := getTarget(application, filter.TargetName)
+
+	deployments, err := getFilteredApplicationDeployments(db, application, filter)
+	if err != nil {
+		requestLogger(r).Error("error loading deployments", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	feedURL := ""
+	token, err := getCalendarToken(db, currentUser.Id)
+	if err != nil {
+		requestLogger(r).Error("error loading the calendar token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if token != "" {
+		feedURL = config.URL(calendarFeedPath(application, token))
+	}
+
+	renderTemplate(w, r, "calendar.tmpl", map[string]interface{}{
+		"Applications":     config.Applications,
+		"Application":      application,
+		"Calendar":         newCalendar(month, query, deployments),
+		"FeedURL":          feedURL,
+		"DeploymentStates": models.DeploymentStates,
+		"Query":            query,
+		"currentUser":      currentUser,
+		"selectedTarget":   target,
+	})
+}
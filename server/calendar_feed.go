@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// How many days back the calendar feed lists deployments
+const calendarFeedDays = 90
+
+// The UTC date-times and dates of iCalendar, RFC 5545
+const (
+	icsTimeLayout = "20060102T150405Z"
+	icsDateLayout = "20060102"
+	// Lines longer than this many bytes are folded
+	icsLineLength = 75
+)
+
+// calendarEvent is an event of the iCalendar feed.
+type calendarEvent struct {
+	UID   string
+	Start time.Time
+	End   time.Time
+	// All-day events span the days from Start to End, including End
+	AllDay      bool
+	Summary     string
+	Description string
+	URL         string
+}
+
+func calendarFeedPath(a *models.Application, token string) string {
+	return "/calendar/" + token + "/" + a.Name + ".ics"
+}
+
+// deploymentCalendarEvent shows the deployment from its start to its last
+// change. Deployments that are still queued or running last until now.
+func deploymentCalendarEvent(a *models.Application, d *models.Deployment, now time.Time) *calendarEvent {
+	config := getConfig()
+	end := d.UpdatedAt
+	if d.State != models.DEPLOYMENT_SUCCESSFUL && d.State != models.DEPLOYMENT_FAILED {
+		end = now
+	}
+	if !end.After(d.CreatedAt) {
+		end = d.CreatedAt.Add(time.Minute)
+	}
+
+	ref := d.Branch
+	if d.Tag != "" {
+		ref = d.Tag
+	}
+	description := d.Comment
+	if d.User != nil {
+		description += "\n\nDeployed by " + d.User.DisplayName()
+	}
+
+	return &calendarEvent{
+		UID:         fmt.Sprintf("deployment-%d@%s", d.Id, config.Host),
+		Start:       d.CreatedAt,
+		End:         end,
+		Summary:     fmt.Sprintf("%s %s to %s (%s)", a.Name, ref, d.TargetName, d.State),
+		Description: description,
+		URL:         config.URL(deploymentUrl(a, d)),
+	}
+}
+
+// freezeCalendarEvent shows the freeze as all-day event from the day it was
+// created until today, as it lasts until it's lifted.
+func freezeCalendarEvent(a *models.Application, f *models.DeployFreeze, now time.Time) *calendarEvent {
+	config := getConfig()
+	targets := f.TargetName
+	if targets == "" {
+		targets = "all targets"
+	}
+	by := fmt.Sprintf("user #%d", f.UserId)
+	if f.User != nil {
+		by = f.User.Name
+	}
+
+	return &calendarEvent{
+		UID:         fmt.Sprintf("freeze-%d@%s", f.Id, config.Host),
+		Start:       f.CreatedAt,
+		End:         now,
+		AllDay:      true,
+		Summary:     fmt.Sprintf("%s deployments to %s frozen", a.Name, targets),
+		Description: fmt.Sprintf("%s\n\nFrozen by %s until an admin lifts the freeze", f.Reason, by),
+		URL:         config.URL("/" + a.Name),
+	}
+}
+
+// writeCalendar writes the events as iCalendar.
+func writeCalendar(w io.Writer, name string, events []*calendarEvent, now time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Applikatoni//Deployments//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:" + icsEscape(name),
+	}
+
+	for _, e := range events {
+		lines = append(lines, "BEGIN:VEVENT", "UID:"+e.UID, "DTSTAMP:"+now.UTC().Format(icsTimeLayout))
+		if e.AllDay {
+			// The end date of all-day events is exclusive
+			lines = append(lines,
+				"DTSTART;VALUE=DATE:"+e.Start.UTC().Format(icsDateLayout),
+				"DTEND;VALUE=DATE:"+e.End.UTC().AddDate(0, 0, 1).Format(icsDateLayout))
+		} else {
+			lines = append(lines,
+				"DTSTART:"+e.Start.UTC().Format(icsTimeLayout),
+				"DTEND:"+e.End.UTC().Format(icsTimeLayout))
+		}
+		lines = append(lines,
+			"SUMMARY:"+icsEscape(e.Summary),
+			"DESCRIPTION:"+icsEscape(e.Description),
+			"URL:"+e.URL,
+			"END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	for _, line := range lines {
+		if _, err := io.WriteString(w, icsFold(line)+"\r\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// icsEscape escapes the special characters of iCalendar texts.
+func icsEscape(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// icsFold splits lines longer than 75 bytes into continuation lines, which
+// start with a space, without splitting UTF-8 characters.
+func icsFold(line string) string {
+	var b strings.Builder
+	limit := icsLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		// The space counts towards the length of continuation lines
+		limit = icsLineLength - 1
+	}
+	b.WriteString(line)
+	return b.String()
+}
+
+// calendarFeedHandler serves the deployments of the last 90 days, including
+// the queued and running ones, and the deploy freezes of the application as
+// iCalendar feed. Calendar apps can't log in, so the feed is authenticated
+// with the calendar token of the user in its URL.
+func calendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	user, err := getUserByCalendarToken(db, vars["token"])
+	if err != nil {
+		requestLogger(r).Error("error loading the user of the calendar token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if user == nil || user.IsDeactivated() || !isAllowedUser(user) {
+		http.NotFound(w, r)
+		return
+	}
+	application, err := findApplication(vars["application"])
+	if err != nil || !application.CanRead(user) {
+		http.NotFound(w, r)
+		return
+	}
+
+	now := time.Now()
+	deployments, err := getFilteredApplicationDeployments(db, application, &deploymentFilter{From: now.AddDate(0, 0, -calendarFeedDays)})
+	if err == nil {
+		err = loadDeploymentsUsers(db, deployments)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading deployments", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	freezes, err := getDeployFreezes(db, application)
+	if err == nil {
+		err = loadDeployFreezesUsers(db, freezes)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy freezes", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	events := []*calendarEvent{}
+	for _, f := range freezes {
+		events = append(events, freezeCalendarEvent(application, f, now))
+	}
+	for _, d := range deployments {
+		events = append(events, deploymentCalendarEvent(application, d, now))
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	if err := writeCalendar(w, application.Name+" deployments", events, now); err != nil {
+		requestLogger(r).Warn("writing the calendar feed failed", "err", err)
+	}
+}
+
+// calendarTokenHandler creates a new calendar token of the user. The feed
+// links with the previous token stop working.
+func calendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	if _, err := saveCalendarToken(db, currentUser.Id); err != nil {
+		requestLogger(r).Error("error saving the calendar token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addFlash(w, r, "A new calendar feed link has been created.")
+	http.Redirect(w, r, "/"+application.Name+"/calendar", http.StatusSeeOther)
+}
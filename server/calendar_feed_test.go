@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func TestIcsEscape(t *testing.T) {
+	got := icsEscape("Fix login; sessions, cookies\\tokens\nand more")
+	want := `Fix login\; sessions\, cookies\\tokens\nand more`
+	if got != want {
+		t.Errorf("wrong escaping. want=%s, got=%s", want, got)
+	}
+}
+
+func TestIcsFold(t *testing.T) {
+	if got := icsFold("SUMMARY:short"); got != "SUMMARY:short" {
+		t.Errorf("short line folded. got=%q", got)
+	}
+
+	line := "DESCRIPTION:" + strings.Repeat("ä", 60)
+	folded := icsFold(line)
+	parts := strings.Split(folded, "\r\n")
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], " ") {
+		t.Fatalf("wrong folding. got=%q", folded)
+	}
+	if len(parts[0]) > icsLineLength || len(parts[1]) > icsLineLength {
+		t.Errorf("folded lines too long. got=%q", folded)
+	}
+	if parts[0]+parts[1][1:] != line {
+		t.Errorf("UTF-8 characters split. got=%q", folded)
+	}
+}
+
+func TestWriteCalendar(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	events := []*calendarEvent{
+		{
+			UID:         "deployment-1@applikatoni.example.com",
+			Start:       now.Add(-time.Hour),
+			End:         now.Add(-50 * time.Minute),
+			Summary:     "web master to production (successful)",
+			Description: "Deploying a hotfix",
+			URL:         "https://applikatoni.example.com/web/deployments/1",
+		},
+		{
+			UID:     "freeze-1@applikatoni.example.com",
+			Start:   now.AddDate(0, 0, -2),
+			End:     now,
+			AllDay:  true,
+			Summary: "web deployments to production frozen",
+		},
+	}
+
+	var buf bytes.Buffer
+	checkErr(t, writeCalendar(&buf, "web deployments", events, now))
+	ics := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"X-WR-CALNAME:web deployments\r\n",
+		"DTSTAMP:20261016T120000Z\r\n",
+		"DTSTART:20261016T110000Z\r\nDTEND:20261016T111000Z\r\n",
+		"DTSTART;VALUE=DATE:20261014\r\nDTEND;VALUE=DATE:20261017\r\n",
+		"SUMMARY:web deployments to production frozen\r\n",
+		"URL:https://applikatoni.example.com/web/deployments/1\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("calendar without %q. got=%s", want, ics)
+		}
+	}
+	if strings.Count(ics, "BEGIN:VEVENT") != 2 {
+		t.Errorf("wrong number of events. got=%s", ics)
+	}
+}
+
+func TestCalendarFeedHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{Host: "applikatoni.example.com", Applications: []*models.Application{
+		{Name: "flincOnRails", ReadUsernames: []string{"alice"}},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	alice := buildUser(1, "alice")
+	bob := buildUser(2, "bob")
+	for _, u := range []*models.User{alice, bob} {
+		checkErr(t, createUser(db, u))
+	}
+
+	deployment := buildDeployment(alice.Id)
+	checkErr(t, createDeployment(db, deployment))
+	freeze := &models.DeployFreeze{ApplicationName: "flincOnRails", TargetName: "production", UserId: alice.Id, Reason: "Black Friday"}
+	checkErr(t, createDeployFreeze(db, freeze))
+
+	aliceToken, err := saveCalendarToken(db, alice.Id)
+	checkErr(t, err)
+	bobToken, err := saveCalendarToken(db, bob.Id)
+	checkErr(t, err)
+
+	// A new token replaces the old one
+	oldToken := aliceToken
+	aliceToken, err = saveCalendarToken(db, alice.Id)
+	checkErr(t, err)
+	if token, err := getCalendarToken(db, alice.Id); err != nil || token != aliceToken {
+		t.Errorf("wrong calendar token. want=%s, got=%s (err=%v)", aliceToken, token, err)
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/calendar/{token}/{application}.ics", calendarFeedHandler)
+
+	tests := []struct {
+		token          string
+		application    string
+		expectedStatus int
+	}{
+		{aliceToken, "flincOnRails", 200},
+		{oldToken, "flincOnRails", 404},
+		{"unknown", "flincOnRails", 404},
+		{aliceToken, "unknown", 404},
+		// bob can't read the application
+		{bobToken, "flincOnRails", 404},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/calendar/"+tt.token+"/"+tt.application+".ics", nil)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s of %s. want=%d, got=%d", tt.application, tt.token, tt.expectedStatus, rec.Code)
+		}
+	}
+
+	req := httptest.NewRequest("GET", calendarFeedPath(getConfig().Applications[0], aliceToken), nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/calendar") {
+		t.Errorf("wrong content type. got=%s", ct)
+	}
+	for _, want := range []string{
+		"SUMMARY:flincOnRails master to production (new)",
+		"SUMMARY:flincOnRails deployments to production frozen",
+		"DESCRIPTION:Black Friday\\n\\nFrozen by alice until an admin lifts the freeze",
+	} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("feed without %q. got=%s", want, rec.Body.String())
+		}
+	}
+}
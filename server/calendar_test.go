@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestNewCalendar(t *testing.T) {
+	// October 2026 starts on a Thursday and ends on a Saturday
+	month := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	deployments := []*models.Deployment{
+		{State: models.DEPLOYMENT_SUCCESSFUL, CreatedAt: time.Date(2026, time.October, 6, 10, 0, 0, 0, time.UTC)},
+		{State: models.DEPLOYMENT_FAILED, CreatedAt: time.Date(2026, time.October, 6, 23, 59, 0, 0, time.UTC)},
+		{State: models.DEPLOYMENT_SUCCESSFUL, CreatedAt: time.Date(2026, time.October, 16, 9, 0, 0, 0, time.UTC)},
+		{State: models.DEPLOYMENT_ACTIVE, CreatedAt: time.Date(2026, time.October, 16, 9, 30, 0, 0, time.UTC)},
+	}
+
+	c := newCalendar(month, url.Values{"target": {"production"}}, deployments)
+
+	if len(c.Weeks) != 5 {
+		t.Fatalf("wrong number of weeks. want=%d, got=%d", 5, len(c.Weeks))
+	}
+	for _, week := range c.Weeks {
+		if len(week) != 7 || week[0].Date.Weekday() != time.Monday {
+			t.Errorf("week doesn't start on Monday or has %d days", len(week))
+		}
+	}
+
+	first := c.Weeks[0][0]
+	if first.InMonth || first.Date.Day() != 28 {
+		t.Errorf("wrong first day. got=%s, in month=%t", first.Date, first.InMonth)
+	}
+
+	tests := []struct {
+		day                       *calendarDay
+		successful, failed, other int
+		outcome                   string
+	}{
+		{c.Weeks[1][1], 1, 1, 0, "mixed"},
+		{c.Weeks[2][4], 1, 0, 1, "successful"},
+		{c.Weeks[2][5], 0, 0, 0, ""},
+	}
+
+	for _, tt := range tests {
+		d := tt.day
+		if d.Successful != tt.successful || d.Failed != tt.failed || d.Other != tt.other {
+			t.Errorf("wrong counts for %s. want=%d/%d/%d, got=%d/%d/%d", d.Date, tt.successful, tt.failed, tt.other, d.Successful, d.Failed, d.Other)
+		}
+		if d.Outcome() != tt.outcome {
+			t.Errorf("wrong outcome for %s. want=%q, got=%q", d.Date, tt.outcome, d.Outcome())
+		}
+	}
+
+	expected := "from=2026-10-06&target=production&to=2026-10-06"
+	if got := c.DayQuery(c.Weeks[1][1]); got != expected {
+		t.Errorf("wrong day query. want=%s, got=%s", expected, got)
+	}
+	if got := c.NextMonthQuery(); got != "month=2026-11&target=production" {
+		t.Errorf("wrong next month query. got=%s", got)
+	}
+}
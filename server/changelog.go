@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// buildChangelog compares the commit with the one of the last successful
+// deployment to the target. It returns nil if nothing was deployed to the
+// target before.
+func buildChangelog(a *models.Application, t *models.Target, u *models.User, sha string) ([]*models.ChangelogEntry, error) {
+	last, err := getLastTargetDeployment(db, a, t.Name)
+	if err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, nil
+	}
+
+	client, err := NewSCMClient(a, u)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := client.Compare(a, last.CommitSha, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	return changelogEntries(diff), nil
+}
+
+func changelogEntries(diff *Diff) []*models.ChangelogEntry {
+	changelog := []*models.ChangelogEntry{}
+
+	for _, c := range diff.Commits {
+		e := &models.ChangelogEntry{
+			CommitSha: c.Sha,
+			Message:   strings.TrimSpace(c.Commit.Message),
+		}
+		if c.Author != nil {
+			e.Author = c.Author.Name
+		}
+		changelog = append(changelog, e)
+	}
+
+	return changelog
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"text/template"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// checkConfigCommand runs `applikatoni check-config`, which checks the
+// configuration without starting the server. It returns the exit code.
+func checkConfigCommand(out io.Writer, args []string) int {
+	flags := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	flags.SetOutput(out)
+	path := flags.String("conf", *configurationFilePath, "path to configuration file")
+	offline := flags.Bool("offline", false, "don't check the credentials with the services they belong to")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	c, err := readConfiguration(*path)
+	if err != nil {
+		fmt.Fprintf(out, "%s: %s\n", *path, err)
+		return 1
+	}
+
+	for _, w := range c.warnings {
+		fmt.Fprintf(out, "%s: warning: %s\n", *path, w)
+	}
+
+	problems := checkConfiguration(c)
+	if !*offline {
+		problems = append(problems, pingConfiguredServices(c, out)...)
+	}
+
+	for _, p := range problems {
+		fmt.Fprintf(out, "%s: %s\n", *path, p)
+	}
+	if len(problems) > 0 {
+		fmt.Fprintf(out, "%d problems found\n", len(problems))
+		return 1
+	}
+
+	fmt.Fprintf(out, "%s: OK\n", *path)
+	return 0
+}
+
+// checkConfiguration returns the problems of the configuration that
+// readConfiguration doesn't reject, because the server could start with
+// them, but deployments or logins would fail.
+func checkConfiguration(c *Configuration) []error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if c.Host == "" {
+		problem("host is missing")
+	}
+	if len(c.SessionSecretList()) == 0 {
+		problem("session_secret is missing")
+	}
+	if c.GitHubClientId == "" && c.GitLabClientId == "" && c.BitbucketClientId == "" &&
+		c.GiteaClientId == "" && c.OIDCIssuerURL == "" && c.SAMLIDPMetadataURL == "" {
+		problem("no login provider configured. Set github_client_id, gitlab_client_id, bitbucket_client_id, gitea_client_id, oidc_issuer_url or saml_idp_metadata_url")
+	}
+
+	if len(c.Applications) == 0 {
+		problem("no applications configured")
+	}
+	for i, a := range c.Applications {
+		if a.Name == "" {
+			problem("application %d has no name", i+1)
+		}
+		if a.GitHubOwner == "" || a.GitHubRepo == "" {
+			problem("application %s: github_owner and github_repo are required", a.Name)
+		}
+		if len(a.Targets) == 0 {
+			problem("application %s has no targets", a.Name)
+		}
+		for _, t := range a.Targets {
+			for _, err := range checkTarget(t) {
+				problem("target %s of %s: %s", t.Name, a.Name, err)
+			}
+		}
+	}
+
+	return problems
+}
+
+func checkTarget(t *models.Target) []error {
+	var problems []error
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Errorf(format, args...))
+	}
+
+	if t.Name == "" {
+		problem("name is missing")
+	}
+	if t.DeploymentUser == "" {
+		problem("deployment_user is missing")
+	}
+
+	if t.DeploymentSshKey == "" {
+		problem("deployment_ssh_key is missing")
+	} else if err := checkSSHKey(t.DeploymentSshKey, t.SshKeyPassphrase); err != nil {
+		problem("can't read deployment_ssh_key: %s", err)
+	}
+
+	if len(t.Hosts) == 0 {
+		problem("no hosts configured")
+	}
+	funcs := models.TemplateFuncs(time.Now(), nil)
+	roles := make(map[string]bool)
+	for _, r := range t.Roles {
+		roles[r.Name] = true
+		for stage, script := range r.ScriptTemplates {
+			if _, err := template.New(string(stage)).Funcs(funcs).Parse(script); err != nil {
+				problem("invalid script template of role %s: %s", r.Name, err)
+			}
+		}
+	}
+	for _, h := range t.Hosts {
+		for _, role := range h.Roles {
+			if !roles[role] {
+				problem("host %s has the unknown role %s", h.Name, role)
+			}
+		}
+		if h.DeploymentSshKey != "" {
+			if err := checkSSHKey(h.DeploymentSshKey, h.SshKeyPassphrase); err != nil {
+				problem("can't read deployment_ssh_key of host %s: %s", h.Name, err)
+			}
+		}
+	}
+
+	available := make(map[models.DeploymentStage]bool)
+	for _, s := range t.AvailableStages {
+		available[s] = true
+	}
+	for _, s := range t.DefaultStages {
+		if !available[s] {
+			problem("default stage %s isn't one of the available_stages", s)
+		}
+	}
+
+	urls := append([]string{t.SlackUrl, t.FlowdockEndpoint}, t.Webhooks...)
+	for _, raw := range urls {
+		if raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+			problem("invalid notification URL %q", raw)
+		}
+	}
+
+	return problems
+}
+
+// checkSSHKey returns an error if the key can't be parsed. Encrypted keys
+// without a passphrase are fine, the passphrase is asked for on startup.
+func checkSSHKey(sshKey, passphrase string) error {
+	key := []byte(sshKey)
+	if passphrase == "" && deploy.IsEncryptedSSHKey(key) {
+		return nil
+	}
+	return deploy.ValidateSSHKey(key, passphrase)
+}
+
+// pingConfiguredServices checks the credentials of the code hosting services
+// and the daily digest with requests that don't change anything. Chat
+// notifiers and webhooks can't be checked without posting.
+func pingConfiguredServices(c *Configuration, out io.Writer) []error {
+	var problems []error
+
+	// NewSCMClient reads the base URLs from the global configuration
+	old := getConfig()
+	setConfig(c)
+	defer func() { setConfig(old) }()
+
+	for _, a := range c.Applications {
+		if a.SCMAccessToken == "" {
+			fmt.Fprintf(out, "application %s: no scm_access_token, skipping the repository check\n", a.Name)
+			continue
+		}
+
+		client, err := NewSCMClient(a, nil)
+		if err == nil {
+			_, err = client.GetBranches(a)
+		}
+		if err != nil {
+			problems = append(problems, fmt.Errorf("application %s: loading the branches of %s/%s failed: %s", a.Name, a.GitHubOwner, a.GitHubRepo, err))
+		}
+	}
+
+	if sender, ok := c.DailyDigestSender().(interface{ Ping() error }); ok {
+		if err := sender.Ping(); err != nil {
+			problems = append(problems, fmt.Errorf("checking the daily digest credentials failed: %s", err))
+		}
+	}
+
+	return problems
+}
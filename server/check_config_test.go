@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func checkConfigTestConfiguration(t *testing.T) *Configuration {
+	return &Configuration{
+		Host:           "applikatoni.shipping-company.com",
+		SessionSecret:  "s3ss10n",
+		GitHubClientId: "cl13nt",
+		Applications: []*models.Application{
+			{
+				Name:        "web",
+				GitHubOwner: "shipping-company",
+				GitHubRepo:  "web",
+				Targets: []*models.Target{
+					{
+						Name:             "production",
+						DeploymentUser:   "deploy",
+						DeploymentSshKey: encryptedTestKey(t, "s3cret"),
+						SshKeyPassphrase: "s3cret",
+						Hosts:            []*models.Host{{Name: "1.web", Roles: []string{"web"}}},
+						Roles: []*models.Role{
+							{Name: "web", ScriptTemplates: map[models.DeploymentStage]string{
+								"CODE_DEPLOYMENT": "git checkout {{.CommitSha}}",
+							}},
+						},
+						AvailableStages: []models.DeploymentStage{"CODE_DEPLOYMENT"},
+						DefaultStages:   []models.DeploymentStage{"CODE_DEPLOYMENT"},
+						SlackUrl:        "https://hooks.slack.com/services/x",
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestCheckConfiguration(t *testing.T) {
+	c := checkConfigTestConfiguration(t)
+	if problems := checkConfiguration(c); len(problems) != 0 {
+		t.Fatalf("valid configuration has problems: %v", problems)
+	}
+
+	c.SessionSecret = ""
+	target := c.Applications[0].Targets[0]
+	target.SshKeyPassphrase = "wr0ng"
+	target.Hosts = append(target.Hosts, &models.Host{Name: "1.worker", Roles: []string{"worker"}})
+	target.Roles[0].ScriptTemplates["POST_DEPLOYMENT"] = "restart {{.Service"
+	target.DefaultStages = append(target.DefaultStages, "POST_DEPLOYMENT")
+	target.SlackUrl = "hooks.slack.com"
+
+	expected := []string{
+		"session_secret is missing",
+		"target production of web: can't read deployment_ssh_key",
+		"target production of web: invalid script template of role web",
+		"target production of web: host 1.worker has the unknown role worker",
+		"target production of web: default stage POST_DEPLOYMENT isn't one of the available_stages",
+		`target production of web: invalid notification URL "hooks.slack.com"`,
+	}
+
+	problems := checkConfiguration(c)
+	if len(problems) != len(expected) {
+		t.Fatalf("wrong number of problems. want=%d, got=%d (%v)", len(expected), len(problems), problems)
+	}
+	for i, e := range expected {
+		if !strings.HasPrefix(problems[i].Error(), e) {
+			t.Errorf("wrong problem. want=%q, got=%q", e, problems[i])
+		}
+	}
+}
+
+func TestCheckConfigCommand(t *testing.T) {
+	pinged := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/events" && r.Method == "GET" {
+			pinged = true
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	c := checkConfigTestConfiguration(t)
+	c.MailgunBaseURL = ts.URL
+	c.MailgunAPIKey = "k3y"
+	path := writeTestConfiguration(t, c)
+	defer os.Remove(path)
+
+	var out bytes.Buffer
+	code := checkConfigCommand(&out, []string{"-conf", path})
+	if code != 0 {
+		t.Errorf("wrong exit code. want=%d, got=%d (%s)", 0, code, out.String())
+	}
+	if !pinged {
+		t.Errorf("mailgun credentials weren't checked")
+	}
+
+	out.Reset()
+	code = checkConfigCommand(&out, []string{"-conf", path, "-offline"})
+	if code != 0 {
+		t.Errorf("wrong exit code offline. want=%d, got=%d (%s)", 0, code, out.String())
+	}
+
+	c.Host = ""
+	c.MailgunBaseURL = ts.URL + "/unknown"
+	path = writeTestConfiguration(t, c)
+	defer os.Remove(path)
+
+	out.Reset()
+	code = checkConfigCommand(&out, []string{"-conf", path})
+	if code != 1 {
+		t.Errorf("wrong exit code. want=%d, got=%d", 1, code)
+	}
+	for _, expected := range []string{"host is missing", "daily digest credentials", "2 problems found"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("output doesn't contain %q. got=%s", expected, out.String())
+		}
+	}
+
+	code = checkConfigCommand(&out, []string{"-conf", path + ".missing"})
+	if code != 1 {
+		t.Errorf("wrong exit code for missing file. want=%d, got=%d", 1, code)
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// checkCIStatus returns an error if CI didn't pass for the commit on a target
+// that requires passing CI. Targets that allow overrides accept the commit
+// anyway if the deployer gave a reason, in which case overridden is true.
+func checkCIStatus(a *models.Application, t *models.Target, u *models.User, sha, reason string) (overridden bool, err error) {
+	if !t.RequirePassingCI {
+		return false, nil
+	}
+
+	token, err := scmAccessToken(a, u, GITHUB_PROVIDER)
+	if err != nil {
+		return false, err
+	}
+
+	status, err := newGitHubTokenClient(token).GetCIStatus(a, sha)
+	if err != nil {
+		return false, fmt.Errorf("could not load the CI status: %s", err)
+	}
+
+	if status.State == "success" {
+		return false, nil
+	}
+
+	if t.AllowCIOverride && reason != "" {
+		return true, nil
+	}
+
+	msg := ciStatusMessage(status, sha)
+	if t.AllowCIOverride {
+		msg += ". Give a CI override reason to deploy it anyway"
+	}
+	return false, errors.New(msg)
+}
+
+func ciStatusMessage(status *CIStatus, sha string) string {
+	switch status.State {
+	case "failure":
+		return fmt.Sprintf("CI failed for %s: %s", sha, strings.Join(status.Failed, ", "))
+	case "pending":
+		return fmt.Sprintf("CI is still running for %s", sha)
+	default:
+		return fmt.Sprintf("CI reported no status for %s", sha)
+	}
+}
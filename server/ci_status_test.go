@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestCheckCIStatus(t *testing.T) {
+	statuses := map[string]string{
+		"f41l": `{"statuses": [{"state": "success", "context": "ci/lint"}, {"state": "failure", "context": "ci/test"}]}`,
+		"p3nd": `{"statuses": [{"state": "pending", "context": "ci/test"}]}`,
+		"s0cc": `{"statuses": [{"state": "success", "context": "ci/test"}]}`,
+		"n0ne": `{"statuses": []}`,
+	}
+	checkRuns := map[string]string{
+		"f41l": `{"check_runs": [{"name": "build", "status": "completed", "conclusion": "timed_out"}]}`,
+		"p3nd": `{"check_runs": []}`,
+		"s0cc": `{"check_runs": [{"name": "build", "status": "completed", "conclusion": "success"}]}`,
+		"n0ne": `{"check_runs": []}`,
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sha, endpoint string
+		fmt.Sscanf(r.URL.Path, "/repos/shipping-co/web-app/commits/%4s/%s", &sha, &endpoint)
+
+		switch endpoint {
+		case "status":
+			fmt.Fprintln(w, statuses[sha])
+		case "check-runs":
+			fmt.Fprintln(w, checkRuns[sha])
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	setConfig(&Configuration{GitHubAPIURL: ts.URL})
+	defer func() { setConfig(&Configuration{}) }()
+
+	a := &models.Application{GitHubOwner: "shipping-co", GitHubRepo: "web-app"}
+	u := &models.User{Provider: GITHUB_PROVIDER, AccessToken: "t0k3n"}
+	gated := &models.Target{RequirePassingCI: true}
+	overridable := &models.Target{RequirePassingCI: true, AllowCIOverride: true}
+
+	tests := []struct {
+		target             *models.Target
+		sha                string
+		reason             string
+		expectedErr        string
+		expectedOverridden bool
+	}{
+		{&models.Target{}, "f41l", "", "", false},
+		{gated, "s0cc", "", "", false},
+		{gated, "f41l", "", "CI failed for f41l: ci/test, build", false},
+		{gated, "f41l", "hotfix", "CI failed for f41l: ci/test, build", false},
+		{gated, "p3nd", "", "CI is still running for p3nd", false},
+		{gated, "n0ne", "", "CI reported no status for n0ne", false},
+		{overridable, "f41l", "", "CI failed for f41l: ci/test, build. Give a CI override reason to deploy it anyway", false},
+		{overridable, "f41l", "hotfix", "", true},
+		{overridable, "s0cc", "hotfix", "", false},
+	}
+
+	for _, tt := range tests {
+		overridden, err := checkCIStatus(a, tt.target, u, tt.sha, tt.reason)
+
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.expectedErr {
+			t.Errorf("wrong error for %s. want=%q, got=%q", tt.sha, tt.expectedErr, got)
+		}
+		if overridden != tt.expectedOverridden {
+			t.Errorf("wrong overridden for %s. want=%t, got=%t", tt.sha, tt.expectedOverridden, overridden)
+		}
+	}
+}
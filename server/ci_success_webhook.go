@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+type gitLabPipelineEvent struct {
+	ObjectAttributes struct {
+		Name   string `json:"name"`
+		Ref    string `json:"ref"`
+		Tag    bool   `json:"tag"`
+		Sha    string `json:"sha"`
+		Status string `json:"status"`
+	} `json:"object_attributes"`
+	User struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Commit struct {
+		Message string `json:"message"`
+	} `json:"commit"`
+}
+
+// ciStatusEvent is the payload other CI systems send when a pipeline
+// finished.
+type ciStatusEvent struct {
+	Pipeline   string `json:"pipeline"`
+	Status     string `json:"status"`
+	Branch     string `json:"branch"`
+	CommitSha  string `json:"commit_sha"`
+	Message    string `json:"message"`
+	OnBehalfOf string `json:"on_behalf_of"`
+}
+
+// ciSuccessApplication returns the application of the request if it deploys
+// commits when CI succeeds on them and writes a 404 otherwise.
+func ciSuccessApplication(w http.ResponseWriter, r *http.Request) (*models.Application, bool) {
+	application, err := findApplication(mux.Vars(r)["application"])
+	if err != nil || application.AutoDeploy == nil || !application.AutoDeploy.Enabled || !application.AutoDeploy.OnCISuccess {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	return application, true
+}
+
+// gitLabWebhookHandler receives the pipeline events of a GitLab project and
+// deploys the commit a pipeline succeeded on to the target configured for
+// the branch in the auto_deploy settings of the application. GitLab sends the
+// webhook_secret in the X-Gitlab-Token header instead of signing the body.
+func gitLabWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := ciSuccessApplication(w, r)
+	if !ok {
+		return
+	}
+
+	token := r.Header.Get("X-Gitlab-Token")
+	if !hmac.Equal([]byte(token), []byte(application.AutoDeploy.WebhookSecret)) {
+		requestLogger(r).Warn("GitLab webhook with invalid token", "application", application.Name, "remote_addr", r.RemoteAddr)
+		http.Error(w, "invalid token", http.StatusForbidden)
+		return
+	}
+
+	if r.Header.Get("X-Gitlab-Event") != "Pipeline Hook" {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "ignoring event")
+		return
+	}
+
+	event := &gitLabPipelineEvent{}
+	if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pipeline := event.ObjectAttributes
+	if pipeline.Status != "success" || pipeline.Tag {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying %s, pipeline %s\n", pipeline.Ref, pipeline.Status)
+		return
+	}
+
+	deployOnCISuccess(w, r, application, pipeline.Name, &autoDeployCommit{
+		Branch:     pipeline.Ref,
+		CommitSha:  pipeline.Sha,
+		Message:    event.Commit.Message,
+		OnBehalfOf: event.User.Username,
+		Source:     "GitLab webhook",
+	})
+}
+
+// ciStatusWebhookHandler receives the results of pipelines of any CI system
+// and deploys the commit a pipeline succeeded on. The body has to be signed
+// with the webhook_secret in the X-Applikatoni-Signature header, like the
+// requests of the ci_trigger.
+func ciStatusWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	application, ok := ciSuccessApplication(w, r)
+	if !ok {
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isValidWebhookSignature(application.AutoDeploy.WebhookSecret, r.Header.Get("X-Applikatoni-Signature"), body) {
+		requestLogger(r).Warn("CI status webhook with invalid signature", "application", application.Name, "remote_addr", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	event := &ciStatusEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if event.Status != "success" {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying %s, pipeline %s\n", event.Branch, event.Status)
+		return
+	}
+
+	deployOnCISuccess(w, r, application, event.Pipeline, &autoDeployCommit{
+		Branch:     event.Branch,
+		CommitSha:  event.CommitSha,
+		Message:    event.Message,
+		OnBehalfOf: event.OnBehalfOf,
+		Source:     "CI webhook",
+	})
+}
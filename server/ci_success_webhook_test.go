@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func ciSuccessTestConfig() *Configuration {
+	return &Configuration{Applications: []*models.Application{
+		{
+			Name:    "flincOnRails",
+			SCM:     models.SCM_GITLAB,
+			Targets: []*models.Target{{Name: "production"}},
+			AutoDeploy: &models.AutoDeploy{
+				Enabled:        true,
+				WebhookSecret:  "s3cr3t",
+				ServiceAccount: "ci",
+				Branches:       map[string]string{"master": "production"},
+				OnCISuccess:    true,
+				Pipelines:      []string{"build"},
+			},
+		},
+		{
+			Name: "web",
+			AutoDeploy: &models.AutoDeploy{
+				Enabled:       true,
+				WebhookSecret: "s3cr3t",
+				Branches:      map[string]string{"master": "production"},
+			},
+		},
+	}}
+}
+
+func TestGitLabWebhookHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(ciSuccessTestConfig())
+	defer func() { setConfig(&Configuration{}) }()
+
+	deployed := buildDeployment(1)
+	checkErr(t, createDeployment(db, deployed))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{application}/webhooks/gitlab", gitLabWebhookHandler)
+
+	pipeline := func(name, ref, status string) string {
+		return `{"object_attributes": {"name": "` + name + `", "ref": "` + ref + `", "sha": "f133742", "status": "` + status + `"}}`
+	}
+
+	tests := []struct {
+		application    string
+		event          string
+		token          string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"web", "Pipeline Hook", "s3cr3t", pipeline("build", "master", "success"), 404, ""},
+		{"flincOnRails", "Pipeline Hook", "wrong", pipeline("build", "master", "success"), 403, "invalid token"},
+		{"flincOnRails", "Push Hook", "s3cr3t", `{}`, 202, "ignoring event"},
+		{"flincOnRails", "Pipeline Hook", "s3cr3t", pipeline("build", "master", "failed"), 202, "pipeline failed"},
+		{"flincOnRails", "Pipeline Hook", "s3cr3t", pipeline("build", "feature", "success"), 202, "not deploying feature"},
+		{"flincOnRails", "Pipeline Hook", "s3cr3t", pipeline("lint", "master", "success"), 202, `pipeline "lint" is not in [build]`},
+		{"flincOnRails", "Pipeline Hook", "s3cr3t", pipeline("build", "master", "success"), 202, "f133742 has already been deployed to production"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/"+tt.application+"/webhooks/gitlab", strings.NewReader(tt.body))
+		req.Header.Set("X-Gitlab-Event", tt.event)
+		req.Header.Set("X-Gitlab-Token", tt.token)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s. want=%d, got=%d", tt.application, tt.body, tt.expectedStatus, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tt.expectedBody) {
+			t.Errorf("wrong response for %s %s. want=%s, got=%s", tt.application, tt.body, tt.expectedBody, rec.Body.String())
+		}
+	}
+}
+
+func TestCIStatusWebhookHandler(t *testing.T) {
+	setConfig(ciSuccessTestConfig())
+	defer func() { setConfig(&Configuration{}) }()
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{application}/webhooks/ci_status", ciStatusWebhookHandler)
+
+	failed := `{"pipeline": "build", "status": "failure", "branch": "master", "commit_sha": "f133742"}`
+	lint := `{"pipeline": "lint", "status": "success", "branch": "master", "commit_sha": "f133742"}`
+
+	tests := []struct {
+		application    string
+		body           string
+		signature      string
+		expectedStatus int
+	}{
+		{"web", failed, signWebhookBody("s3cr3t", failed), 404},
+		{"flincOnRails", failed, signWebhookBody("wrong", failed), 403},
+		{"flincOnRails", `{`, signWebhookBody("s3cr3t", `{`), 400},
+		{"flincOnRails", failed, signWebhookBody("s3cr3t", failed), 202},
+		{"flincOnRails", lint, signWebhookBody("s3cr3t", lint), 202},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/"+tt.application+"/webhooks/ci_status", strings.NewReader(tt.body))
+		req.Header.Set("X-Applikatoni-Signature", tt.signature)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s. want=%d, got=%d", tt.application, tt.body, tt.expectedStatus, rec.Code)
+		}
+	}
+}
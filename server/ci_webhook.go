@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// ciTriggerMaxAge is how old the timestamp of a CI trigger may be, so
+// captured requests can't be replayed later.
+const ciTriggerMaxAge = 5 * time.Minute
+
+// ciTriggerRequest is the payload CI pipelines send to trigger a deployment.
+// Either the commit_sha or the branch is required. A branch without a commit
+// sha deploys the current head of the branch.
+type ciTriggerRequest struct {
+	Target    string   `json:"target"`
+	CommitSha string   `json:"commit_sha"`
+	Branch    string   `json:"branch"`
+	Comment   string   `json:"comment"`
+	Stages    []string `json:"stages"`
+	// The person who triggered the pipeline, shown on the deployment page
+	OnBehalfOf string `json:"on_behalf_of"`
+}
+
+type ciTriggerResponse struct {
+	Id int `json:"id"`
+	// The deployment page and the JSON API URL to poll its state
+	Url     string `json:"url"`
+	PollUrl string `json:"poll_url"`
+}
+
+// ciTriggerHandler starts a deployment requested by a CI pipeline. The
+// X-Applikatoni-Timestamp and the body have to be signed with the ci_trigger
+// secret of the application in the X-Applikatoni-Signature header.
+func ciTriggerHandler(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
+	application, err := findApplication(mux.Vars(r)["application"])
+	if err != nil || application.CITrigger == nil {
+		renderApiError(w, http.StatusNotFound, "application not found")
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		renderApiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	timestamp, signature := r.Header.Get("X-Applikatoni-Timestamp"), r.Header.Get("X-Applikatoni-Signature")
+	if !isValidCITriggerSignature(application.CITrigger.Secret, timestamp, signature, body, time.Now()) {
+		requestLogger(r).Warn("CI trigger with invalid signature", "application", application.Name, "remote_addr", r.RemoteAddr)
+		renderApiErrorDetails(w, http.StatusForbidden, apiErrInvalidSignature, "invalid signature", nil)
+		return
+	}
+
+	req := &ciTriggerRequest{}
+	if err := json.Unmarshal(body, req); err != nil {
+		renderApiErrorDetails(w, http.StatusBadRequest, apiErrInvalidRequest, "invalid JSON", map[string]string{"error": err.Error()})
+		return
+	}
+
+	deployment, rerr := ciTriggerDeploy(application, req, r)
+	if rerr != nil {
+		requestLogger(r).Warn("CI trigger failed", "application", application.Name, "target", req.Target, "err", rerr.Message)
+		renderApiError(w, rerr.Status, rerr.Message)
+		return
+	}
+
+	pollUrl := config.URL(apiDeploymentUrl(application, deployment))
+	w.Header().Set("Location", pollUrl)
+	renderApiData(w, http.StatusCreated, &ciTriggerResponse{
+		Id:      deployment.Id,
+		Url:     config.URL(deploymentUrl(application, deployment)),
+		PollUrl: pollUrl,
+	})
+}
+
+// isValidCITriggerSignature checks the HMAC-SHA256 of the timestamp and the
+// body joined by a dot, like "1531420618.{...}". Timestamps that are more than
+// ciTriggerMaxAge off are rejected, like the ones of Slack.
+func isValidCITriggerSignature(secret, timestamp, signature string, body []byte, now time.Time) bool {
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > ciTriggerMaxAge || age < -ciTriggerMaxAge {
+		return false
+	}
+
+	signed := append([]byte(timestamp+"."), body...)
+	return isValidWebhookSignature(secret, signature, signed)
+}
+
+func ciTriggerDeploy(a *models.Application, req *ciTriggerRequest, r *http.Request) (*models.Deployment, *requestError) {
+	target, err := findTarget(a, req.Target)
+	if err != nil {
+		return nil, &requestError{http.StatusNotFound, "target not found"}
+	}
+
+	user, err := getUserByProvider(db, SERVICE_ACCOUNT_PROVIDER, a.CITrigger.ServiceAccount)
+	if err != nil {
+		requestLogger(r).Error("loading service account failed", "service_account", a.CITrigger.ServiceAccount, "err", err)
+		return nil, &requestError{http.StatusInternalServerError, "could not load the service account"}
+	}
+	if !loadServiceAccount(user) || !a.CanDeploy(target, user) {
+		msg := fmt.Sprintf("service account %s can't deploy to %s", a.CITrigger.ServiceAccount, target.Name)
+		return nil, &requestError{http.StatusForbidden, msg}
+	}
+	if reqErr := checkDeployFreeze(a, target, user, false); reqErr != nil {
+		return nil, reqErr
+	}
+	if reqErr := checkDeployWindow(target, user, "", time.Now()); reqErr != nil {
+		return nil, reqErr
+	}
+
+	if req.Comment == "" {
+		return nil, &requestError{422, "comment is empty"}
+	}
+
+	commitSha := req.CommitSha
+	if commitSha == "" && req.Branch != "" {
+		commitSha, err = getBranchHead(a, user, req.Branch)
+		if err != nil {
+			return nil, &requestError{422, fmt.Sprintf("could not load branch %s: %s", req.Branch, err)}
+		}
+	}
+	if !isValidCommitSha(commitSha) {
+		return nil, &requestError{422, "invalid commit sha"}
+	}
+
+	if !target.IsDeployableBranch(req.Branch) {
+		msg := "branch %q can't be deployed to %s. Deployable branches: %v"
+		return nil, &requestError{422, fmt.Sprintf(msg, req.Branch, target.Name, target.DeployableBranches)}
+	}
+	if req.CommitSha != "" {
+		if err := checkDeployableCommit(a, target, user, req.Branch, commitSha); err != nil {
+			return nil, &requestError{422, err.Error()}
+		}
+	}
+	if _, err := checkCIStatus(a, target, user, commitSha, ""); err != nil {
+		return nil, &requestError{422, err.Error()}
+	}
+
+	stages := target.DefaultStages
+	if len(req.Stages) > 0 {
+		stages = []models.DeploymentStage{}
+		for _, s := range req.Stages {
+			stages = append(stages, models.DeploymentStage(s))
+		}
+	}
+	if !target.AreValidStages(stages) {
+		msg := "stages have wrong order or contain invalid stages. Available stages: %v"
+		return nil, &requestError{422, fmt.Sprintf(msg, target.AvailableStages)}
+	}
+
+	deployment := &models.Deployment{
+		UserId:          user.Id,
+		CommitSha:       commitSha,
+		Branch:          req.Branch,
+		Comment:         req.Comment,
+		ApplicationName: a.Name,
+		TargetName:      target.Name,
+		RequestId:       r.Header.Get(requestIdHeader),
+		Initiator: &models.DeploymentInitiator{
+			TokenName:  "CI trigger (" + user.Name + ")",
+			SourceIP:   remoteIP(r),
+			OnBehalfOf: req.OnBehalfOf,
+		},
+	}
+
+	deployment.Changelog, err = buildChangelog(a, target, user, commitSha)
+	if err != nil {
+		requestLogger(r).Warn("could not build changelog", "commit_sha", commitSha, "target", target.Name, "err", err)
+	}
+
+	err = startDeployment(a, target, deployment, stages)
+	if err != nil {
+		return nil, &requestError{startDeploymentErrorStatus(err), err.Error()}
+	}
+	recordAuditEvent(r, user, models.AUDIT_DEPLOYMENT_CREATE, deploymentAuditSubject(deployment))
+
+	return deployment, nil
+}
+
+// getBranchHead returns the sha of the current commit of the branch.
+func getBranchHead(a *models.Application, u *models.User, name string) (string, error) {
+	client, err := NewSCMClient(a, u)
+	if err != nil {
+		return "", err
+	}
+
+	branches, err := client.GetBranches(a)
+	if err != nil {
+		return "", err
+	}
+	for _, b := range branches {
+		if b.Name == name {
+			return b.CurrentCommit.Sha, nil
+		}
+	}
+	return "", errors.New("branch not found")
+}
+
+func validateCITrigger(c *Configuration, a *models.Application) error {
+	ct := a.CITrigger
+	if ct == nil {
+		return nil
+	}
+
+	if ct.Secret == "" {
+		return errors.New("secret is required")
+	}
+
+	for _, s := range c.ServiceAccounts {
+		if s.Name == ct.ServiceAccount {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown service_account %q", ct.ServiceAccount)
+}
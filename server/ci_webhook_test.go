@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func signCITrigger(secret, timestamp, body string) string {
+	return signWebhookBody(secret, timestamp+"."+body)
+}
+
+// newCITriggerRequest returns a request signed now with the secret.
+func newCITriggerRequest(application, secret, body string) *http.Request {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req := httptest.NewRequest("POST", "/"+application+"/webhooks/ci", strings.NewReader(body))
+	req.Header.Set("X-Applikatoni-Timestamp", timestamp)
+	req.Header.Set("X-Applikatoni-Signature", signCITrigger(secret, timestamp, body))
+	return req
+}
+
+func TestIsValidCITriggerSignature(t *testing.T) {
+	body := `{"target": "staging", "branch": "master", "comment": "Build 42"}`
+	now := time.Unix(1531420618, 0)
+	timestamp := "1531420618"
+
+	tests := []struct {
+		secret    string
+		timestamp string
+		signature string
+		expected  bool
+	}{
+		{"s3cr3t", timestamp, signCITrigger("s3cr3t", timestamp, body), true},
+		{"s3cr3t", timestamp, signCITrigger("wrong", timestamp, body), false},
+		{"", timestamp, signCITrigger("", timestamp, body), false},
+		// Signatures of the body alone, as before
+		{"s3cr3t", timestamp, signWebhookBody("s3cr3t", body), false},
+		{"s3cr3t", "", signWebhookBody("s3cr3t", body), false},
+		// Signed with another timestamp than the one sent
+		{"s3cr3t", "1531420619", signCITrigger("s3cr3t", timestamp, body), false},
+		// Replayed after more than 5 minutes
+		{"s3cr3t", "1531420000", signCITrigger("s3cr3t", "1531420000", body), false},
+		{"s3cr3t", "1531421000", signCITrigger("s3cr3t", "1531421000", body), false},
+		{"s3cr3t", "yesterday", signCITrigger("s3cr3t", "yesterday", body), false},
+	}
+
+	for i, tt := range tests {
+		if got := isValidCITriggerSignature(tt.secret, tt.timestamp, tt.signature, []byte(body), now); got != tt.expected {
+			t.Errorf("%d: wrong result. want=%t, got=%t", i, tt.expected, got)
+		}
+	}
+}
+
+func TestCITriggerHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{
+		ServiceAccounts: []*models.ServiceAccount{
+			{Name: "ci", ApiToken: "c1t0k3n", Targets: map[string][]string{"web": {"staging"}}},
+		},
+		Applications: []*models.Application{
+			{
+				Name: "web",
+				Targets: []*models.Target{
+					{Name: "staging", AvailableStages: []models.DeploymentStage{"CHECK_CONNECTION"}},
+					{Name: "production"},
+				},
+				CITrigger: &models.CITrigger{Secret: "s3cr3t", ServiceAccount: "ci"},
+			},
+			{Name: "api"},
+		},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	checkErr(t, syncServiceAccounts(db, getConfig().ServiceAccounts))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{application}/webhooks/ci", ciTriggerHandler)
+
+	sha := "0123456789abcdef0123456789abcdef01234567"
+
+	tests := []struct {
+		application     string
+		body            string
+		secret          string
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{"unknown", `{}`, "s3cr3t", 404, "application not found"},
+		{"api", `{}`, "s3cr3t", 404, "application not found"},
+		{"web", `{}`, "wrong", 403, "invalid signature"},
+		{"web", `{"target": "qa"}`, "s3cr3t", 404, "target not found"},
+		{"web", `{"target": "production", "commit_sha": "` + sha + `", "comment": "Ship it"}`, "s3cr3t", 403, "service account ci can't deploy to production"},
+		{"web", `{"target": "staging", "commit_sha": "` + sha + `"}`, "s3cr3t", 422, "comment is empty"},
+		{"web", `{"target": "staging", "commit_sha": "nope", "comment": "Ship it"}`, "s3cr3t", 422, "invalid commit sha"},
+		{"web", `{"target": "staging", "commit_sha": "` + sha + `", "comment": "Ship it", "stages": ["MIGRATE_DATABASE"]}`, "s3cr3t", 422, "stages have wrong order or contain invalid stages. Available stages: [CHECK_CONNECTION]"},
+	}
+
+	for _, tt := range tests {
+		req := newCITriggerRequest(tt.application, tt.secret, tt.body)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s. want=%d, got=%d", tt.application, tt.body, tt.expectedStatus, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tt.expectedMessage) {
+			t.Errorf("wrong response for %s %s. want=%s, got=%s", tt.application, tt.body, tt.expectedMessage, rec.Body.String())
+		}
+	}
+}
+
+func TestValidateCITrigger(t *testing.T) {
+	c := &Configuration{ServiceAccounts: []*models.ServiceAccount{{Name: "ci"}}}
+
+	tests := []struct {
+		trigger     *models.CITrigger
+		expectedErr string
+	}{
+		{nil, ""},
+		{&models.CITrigger{Secret: "s3cr3t", ServiceAccount: "ci"}, ""},
+		{&models.CITrigger{ServiceAccount: "ci"}, "secret is required"},
+		{&models.CITrigger{Secret: "s3cr3t", ServiceAccount: "travis"}, `unknown service_account "travis"`},
+	}
+
+	for i, tt := range tests {
+		err := validateCITrigger(c, &models.Application{Name: "web", CITrigger: tt.trigger})
+		if tt.expectedErr == "" && err != nil {
+			t.Errorf("unexpected error for case %d: %s", i, err)
+		}
+		if tt.expectedErr != "" && (err == nil || err.Error() != tt.expectedErr) {
+			t.Errorf("wrong error for case %d. want=%s, got=%v", i, tt.expectedErr, err)
+		}
+	}
+}
+
+func TestCITriggerCommitNotOnBranch(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	head := "0123456789abcdef0123456789abcdef01234567"
+	feature := "89abcdef0123456789abcdef0123456789abcdef"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/repos/shipping-co/web/branches/master":
+			fmt.Fprintf(w, `{"name": "master", "commit": {"id": "%s"}}`, head)
+		case "/api/v1/repos/shipping-co/web/compare/" + head + "..." + feature:
+			fmt.Fprintln(w, `{"total_commits": 1, "commits": []}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	setConfig(&Configuration{
+		GiteaURL: ts.URL,
+		ServiceAccounts: []*models.ServiceAccount{
+			{Name: "ci", ApiToken: "c1t0k3n", Targets: map[string][]string{"web": {"production"}}},
+		},
+		Applications: []*models.Application{
+			{
+				Name:           "web",
+				SCM:            "gitea",
+				SCMAccessToken: "t0k3n",
+				GitHubOwner:    "shipping-co",
+				GitHubRepo:     "web",
+				Targets: []*models.Target{
+					{Name: "production", DeployableBranches: []string{"master"}},
+				},
+				CITrigger: &models.CITrigger{Secret: "s3cr3t", ServiceAccount: "ci"},
+			},
+		},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	checkErr(t, syncServiceAccounts(db, getConfig().ServiceAccounts))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{application}/webhooks/ci", ciTriggerHandler)
+
+	// The commit of a feature branch deployed as master
+	body := `{"target": "production", "branch": "master", "commit_sha": "` + feature + `", "comment": "Ship it"}`
+	req := newCITriggerRequest("web", "s3cr3t", body)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != 422 {
+		t.Errorf("wrong status. want=%d, got=%d", 422, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "is not on branch master") {
+		t.Errorf("wrong response. got=%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCompressed(t *testing.T) {
+	tests := []struct {
+		contentType      string
+		acceptEncoding   string
+		expectedEncoding string
+	}{
+		{"application/json", "gzip, deflate", "gzip"},
+		{"text/html; charset=utf-8", "gzip", "gzip"},
+		{"", "gzip", "gzip"},
+		{"application/json", "", ""},
+		{"text/event-stream", "gzip", ""},
+		{"image/png", "gzip", ""},
+	}
+
+	body := "<html><body>Deployment successful</body></html>"
+
+	for _, tt := range tests {
+		h := compressed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
+			w.Write([]byte(body))
+		}))
+
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != tt.expectedEncoding {
+			t.Errorf("wrong encoding of %q. want=%q, got=%q", tt.contentType, tt.expectedEncoding, got)
+			continue
+		}
+		if rec.Header().Get("Vary") != "Accept-Encoding" {
+			t.Errorf("missing Vary header for %q", tt.contentType)
+		}
+
+		got := rec.Body.Bytes()
+		if tt.expectedEncoding == "gzip" {
+			gz, err := gzip.NewReader(rec.Body)
+			checkErr(t, err)
+			got, err = ioutil.ReadAll(gz)
+			checkErr(t, err)
+		}
+		if string(got) != body {
+			t.Errorf("wrong body for %q. want=%q, got=%q", tt.contentType, body, got)
+		}
+	}
+}
+
+func TestCompressedNotModified(t *testing.T) {
+	h := compressed(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotModified)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != 0 {
+		t.Errorf("304 response compressed. got=%v %q", rec.Header(), rec.Body.String())
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// checkNotModified sets the ETag and, if lastModified isn't zero, the
+// Last-Modified header of a response that only changes with the given
+// version, e.g. the time of the last change of the shown deployments. It
+// answers with 304 and returns true if the client's copy is still current,
+// so polling clients don't cause the response to be loaded again.
+func checkNotModified(w http.ResponseWriter, r *http.Request, lastModified time.Time, version ...interface{}) bool {
+	// Responses differ by path and query, e.g. filters of listings
+	sum := sha1.Sum([]byte(fmt.Sprint(r.URL.RequestURI(), version)))
+	etag := fmt.Sprintf(`W/"%x"`, sum[:10])
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if !lastModified.IsZero() {
+		w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if r.Method != "GET" && r.Method != "HEAD" {
+		return false
+	}
+
+	notModified := false
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		notModified = etagMatches(inm, etag)
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		t, err := http.ParseTime(ims)
+		notModified = err == nil && !lastModified.Truncate(time.Second).After(t)
+	}
+
+	if notModified {
+		w.WriteHeader(http.StatusNotModified)
+	}
+	return notModified
+}
+
+// etagMatches checks the If-None-Match header, which can list several ETags,
+// with the weak comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckNotModified(t *testing.T) {
+	lastModified := time.Date(2015, 1, 26, 10, 0, 0, 500, time.UTC)
+
+	rec := httptest.NewRecorder()
+	checkNotModified(rec, httptest.NewRequest("GET", "/api/v1/applications/web/deployments", nil), lastModified, 42)
+	etag := rec.Header().Get("ETag")
+	if etag == "" || rec.Header().Get("Last-Modified") != "Mon, 26 Jan 2015 10:00:00 GMT" {
+		t.Fatalf("missing headers. got=%v", rec.Header())
+	}
+
+	tests := []struct {
+		path                string
+		version             int
+		ifNoneMatch         string
+		ifModifiedSince     string
+		expectedNotModified bool
+	}{
+		{"/api/v1/applications/web/deployments", 42, etag, "", true},
+		{"/api/v1/applications/web/deployments", 42, `"other", ` + etag, "", true},
+		{"/api/v1/applications/web/deployments", 43, etag, "", false},
+		{"/api/v1/applications/web/deployments?target=production", 42, etag, "", false},
+		{"/api/v1/applications/web/deployments", 42, "", "Mon, 26 Jan 2015 10:00:00 GMT", true},
+		{"/api/v1/applications/web/deployments", 42, "", "Mon, 26 Jan 2015 09:59:59 GMT", false},
+		{"/api/v1/applications/web/deployments", 42, `"other"`, "Mon, 26 Jan 2015 10:00:00 GMT", false},
+		{"/api/v1/applications/web/deployments", 42, "", "", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+		}
+		if tt.ifModifiedSince != "" {
+			req.Header.Set("If-Modified-Since", tt.ifModifiedSince)
+		}
+
+		rec := httptest.NewRecorder()
+		notModified := checkNotModified(rec, req, lastModified, tt.version)
+
+		if notModified != tt.expectedNotModified {
+			t.Errorf("wrong result for %s %d %q %q. want=%t, got=%t", tt.path, tt.version, tt.ifNoneMatch, tt.ifModifiedSince, tt.expectedNotModified, notModified)
+		}
+		if notModified && rec.Code != http.StatusNotModified {
+			t.Errorf("wrong status. want=%d, got=%d", http.StatusNotModified, rec.Code)
+		}
+	}
+}
@@ -1,23 +1,120 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 
+	"github.com/applikatoni/applikatoni/deploy"
 	"github.com/applikatoni/applikatoni/models"
 )
 
+const defaultSessionTTL = 7 * 24 * time.Hour
+
 type Configuration struct {
-	Host               string                `json:"host"`
-	SSLEnabled         bool                  `json:"ssl_enabled"`
-	SessionSecret      string                `json:"session_secret"`
-	Oauth2StateString  string                `json:"oauth2_state_string"`
-	GitHubClientId     string                `json:"github_client_id"`
-	GitHubClientSecret string                `json:"github_client_secret"`
-	MandrillAPIKey     string                `json:"mandrill_api_key"`
-	MailgunBaseURL     string                `json:"mailgun_base_url"`
-	MailgunAPIKey      string                `json:"mailgun_api_key"`
-	Applications       []*models.Application `json:"applications"`
+	SchemaVersion                int                      `json:"schema_version,omitempty"`
+	Host                         string                   `json:"host"`
+	SSLEnabled                   bool                     `json:"ssl_enabled"`
+	TLSCertificateFile           string                   `json:"tls_certificate_file"`
+	TLSKeyFile                   string                   `json:"tls_key_file"`
+	ACMEHosts                    []string                 `json:"acme_hosts"`
+	ACMEEmail                    string                   `json:"acme_email"`
+	ACMECacheDir                 string                   `json:"acme_cache_dir"`
+	HTTPRedirectAddr             string                   `json:"http_redirect_addr"`
+	SessionSecret                string                   `json:"session_secret"`
+	SessionSecrets               []string                 `json:"session_secrets"`
+	SessionTTL                   string                   `json:"session_ttl"`
+	AdminUsernames               []string                 `json:"admin_usernames"`
+	Oauth2StateString            string                   `json:"oauth2_state_string"`
+	GitHubURL                    string                   `json:"github_url"`
+	GitHubAPIURL                 string                   `json:"github_api_url"`
+	GitHubClientId               string                   `json:"github_client_id"`
+	GitHubClientSecret           string                   `json:"github_client_secret"`
+	GitHubOrganizations          []string                 `json:"github_organizations"`
+	ExemptLoginProviders         []string                 `json:"exempt_login_providers"`
+	GitHubMembershipSyncInterval string                   `json:"github_membership_sync_interval"`
+	GitHubAppId                  int64                    `json:"github_app_id"`
+	GitHubAppPrivateKeyFile      string                   `json:"github_app_private_key_file"`
+	GitLabURL                    string                   `json:"gitlab_url"`
+	GitLabClientId               string                   `json:"gitlab_client_id"`
+	GitLabClientSecret           string                   `json:"gitlab_client_secret"`
+	BitbucketClientId            string                   `json:"bitbucket_client_id"`
+	BitbucketClientSecret        string                   `json:"bitbucket_client_secret"`
+	GiteaURL                     string                   `json:"gitea_url"`
+	GiteaClientId                string                   `json:"gitea_client_id"`
+	GiteaClientSecret            string                   `json:"gitea_client_secret"`
+	GiteaTitle                   string                   `json:"gitea_title"`
+	OIDCIssuerURL                string                   `json:"oidc_issuer_url"`
+	OIDCClientId                 string                   `json:"oidc_client_id"`
+	OIDCClientSecret             string                   `json:"oidc_client_secret"`
+	OIDCScopes                   []string                 `json:"oidc_scopes"`
+	OIDCUsernameClaim            string                   `json:"oidc_username_claim"`
+	OIDCTitle                    string                   `json:"oidc_title"`
+	SAMLIDPMetadataURL           string                   `json:"saml_idp_metadata_url"`
+	SAMLCertificateFile          string                   `json:"saml_certificate_file"`
+	SAMLKeyFile                  string                   `json:"saml_key_file"`
+	SAMLUsernameAttribute        string                   `json:"saml_username_attribute"`
+	SAMLAvatarAttribute          string                   `json:"saml_avatar_attribute"`
+	SAMLGroupsAttribute          string                   `json:"saml_groups_attribute"`
+	SAMLTitle                    string                   `json:"saml_title"`
+	MandrillAPIKey               string                   `json:"mandrill_api_key"`
+	MailgunBaseURL               string                   `json:"mailgun_base_url"`
+	MailgunAPIKey                string                   `json:"mailgun_api_key"`
+	WeeklySummaryReceivers       []string                 `json:"weekly_summary_receivers"`
+	SlackBotToken                string                   `json:"slack_bot_token"`
+	SlackSigningSecret           string                   `json:"slack_signing_secret"`
+	SlackUsers                   map[string]string        `json:"slack_users"`
+	TeamsUsers                   map[string]string        `json:"teams_users"`
+	RateLimitPerToken            int                      `json:"rate_limit_per_token"`
+	RateLimitPerIP               int                      `json:"rate_limit_per_ip"`
+	RateLimitWindow              string                   `json:"rate_limit_window"`
+	LoginMaxFailures             int                      `json:"login_max_failures"`
+	LoginLockout                 string                   `json:"login_lockout"`
+	MetricsToken                 string                   `json:"metrics_token"`
+	ShutdownTimeout              string                   `json:"shutdown_timeout"`
+	SCMCacheTTL                  string                   `json:"scm_cache_ttl"`
+	NotifierWorkers              int                      `json:"notifier_workers"`
+	NotifierTimeout              string                   `json:"notifier_timeout"`
+	SentryDSN                    string                   `json:"sentry_dsn"`
+	SentryEnvironment            string                   `json:"sentry_environment"`
+	CORSAllowedOrigins           []string                 `json:"cors_allowed_origins"`
+	CORSAllowedMethods           []string                 `json:"cors_allowed_methods"`
+	IPAllowlists                 map[string][]string      `json:"ip_allowlists"`
+	ContentSecurityPolicy        string                   `json:"content_security_policy"`
+	LogRedactionPatterns         []string                 `json:"log_redaction_patterns"`
+	LogRedactionSecrets          []string                 `json:"log_redaction_secrets"`
+	VaultAddress                 string                   `json:"vault_address"`
+	VaultToken                   string                   `json:"vault_token"`
+	VaultRoleId                  string                   `json:"vault_role_id"`
+	VaultSecretId                string                   `json:"vault_secret_id"`
+	AWSRegion                    string                   `json:"aws_region"`
+	ApplicationsDir              string                   `json:"applications_dir"`
+	EncryptionKey                string                   `json:"encryption_key"`
+	Applications                 []*models.Application    `json:"applications"`
+	ServiceAccounts              []*models.ServiceAccount `json:"service_accounts"`
+
+	// fileApplications are the Applications read from the configuration
+	// file and the applications_dir, without the ones managed in the database.
+	fileApplications []*models.Application
+	// warnings about migrating the configuration files to the current
+	// schema_version
+	warnings []string
+	// dir is the directory of the configuration file, which relative paths
+	// like the digest templates are relative to
+	dir string
+	// ipAllowlists are the parsed IPAllowlists
+	ipAllowlists map[string][]*net.IPNet
+	// redactionPatterns are the default patterns and the compiled
+	// LogRedactionPatterns
+	redactionPatterns []*regexp.Regexp
 }
 
 func (c *Configuration) DailyDigestSender() DailyDigestSender {
@@ -30,18 +127,519 @@ func (c *Configuration) DailyDigestSender() DailyDigestSender {
 	}
 }
 
+// URL returns the absolute URL of path on this Applikatoni instance.
+func (c *Configuration) URL(path string) string {
+	scheme := "http"
+	if c.SSLEnabled {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Host, path)
+}
+
+// IsAdmin checks whether the user, by its QualifiedName, is listed in the
+// admin_usernames. Service accounts are never admins.
+func (c *Configuration) IsAdmin(u *models.User) bool {
+	if u.ServiceAccount != nil {
+		return false
+	}
+	for _, name := range c.AdminUsernames {
+		if u.QualifiedName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GitHubBaseURL returns the URL of the GitHub web interface, github.com or a
+// GitHub Enterprise Server instance.
+func (c *Configuration) GitHubBaseURL() string {
+	if c.GitHubURL == "" {
+		return defaultGitHubURL
+	}
+	return strings.TrimRight(c.GitHubURL, "/")
+}
+
+// GitHubAPIBaseURL returns the URL of the GitHub API. GitHub Enterprise Server
+// serves it at /api/v3 if no github_api_url is configured.
+func (c *Configuration) GitHubAPIBaseURL() string {
+	switch {
+	case c.GitHubAPIURL != "":
+		return strings.TrimRight(c.GitHubAPIURL, "/")
+	case c.GitHubURL != "":
+		return c.GitHubBaseURL() + "/api/v3"
+	default:
+		return defaultGitHubAPI
+	}
+}
+
+// GitLabBaseURL returns the URL of the GitLab instance used for login and
+// repositories, gitlab.com by default.
+func (c *Configuration) GitLabBaseURL() string {
+	if c.GitLabURL == "" {
+		return defaultGitLabURL
+	}
+	return strings.TrimRight(c.GitLabURL, "/")
+}
+
+func (c *Configuration) GiteaBaseURL() string {
+	return strings.TrimRight(c.GiteaURL, "/")
+}
+
+// SessionSecretList returns the secrets of the session cookies, the current
+// one first: the session_secrets, or the session_secret if there are none.
+func (c *Configuration) SessionSecretList() []string {
+	if len(c.SessionSecrets) > 0 {
+		return c.SessionSecrets
+	}
+	if c.SessionSecret != "" {
+		return []string{c.SessionSecret}
+	}
+	return nil
+}
+
+// SessionTimeout returns how long users stay logged in.
+func (c *Configuration) SessionTimeout() (time.Duration, error) {
+	if c.SessionTTL == "" {
+		return defaultSessionTTL, nil
+	}
+	return time.ParseDuration(c.SessionTTL)
+}
+
+// MembershipSyncInterval returns how often the GitHub organizations and
+// teams of the users are synced.
+func (c *Configuration) MembershipSyncInterval() (time.Duration, error) {
+	if c.GitHubMembershipSyncInterval == "" {
+		return defaultGitHubMembershipSyncInterval, nil
+	}
+	return time.ParseDuration(c.GitHubMembershipSyncInterval)
+}
+
+// RateLimitWindowDuration returns the window in which rate_limit_per_token
+// and rate_limit_per_ip requests are allowed.
+func (c *Configuration) RateLimitWindowDuration() (time.Duration, error) {
+	if c.RateLimitWindow == "" {
+		return defaultRateLimitWindow, nil
+	}
+	d, err := time.ParseDuration(c.RateLimitWindow)
+	if err == nil && d <= 0 {
+		err = errors.New("must be positive")
+	}
+	return d, err
+}
+
+// LoginLockoutDuration returns how long an IP is locked out after
+// login_max_failures failed logins or API token checks within that time.
+func (c *Configuration) LoginLockoutDuration() (time.Duration, error) {
+	if c.LoginLockout == "" {
+		return defaultLoginLockoutDuration, nil
+	}
+	d, err := time.ParseDuration(c.LoginLockout)
+	if err == nil && d <= 0 {
+		err = errors.New("must be positive")
+	}
+	return d, err
+}
+
+// ShutdownTimeoutDuration returns how long the server waits for running
+// deployments before exiting on SIGTERM.
+func (c *Configuration) ShutdownTimeoutDuration() (time.Duration, error) {
+	if c.ShutdownTimeout == "" {
+		return defaultShutdownTimeout, nil
+	}
+	d, err := time.ParseDuration(c.ShutdownTimeout)
+	if err == nil && d < 0 {
+		err = errors.New("must not be negative")
+	}
+	return d, err
+}
+
+// SCMCacheTTLDuration returns how long the branches, tags and comparisons
+// shown in the deploy form are cached. 0 turns the cache off.
+func (c *Configuration) SCMCacheTTLDuration() (time.Duration, error) {
+	if c.SCMCacheTTL == "" {
+		return defaultSCMCacheTTL, nil
+	}
+	d, err := time.ParseDuration(c.SCMCacheTTL)
+	if err == nil && d < 0 {
+		err = errors.New("must not be negative")
+	}
+	return d, err
+}
+
+// NotifierWorkerCount returns how many notifiers and other listeners of the
+// deployment states are called at the same time.
+func (c *Configuration) NotifierWorkerCount() int {
+	if c.NotifierWorkers == 0 {
+		return defaultNotifierWorkers
+	}
+	return c.NotifierWorkers
+}
+
+// NotifierTimeoutDuration returns how long a notifier may take before the
+// next one is called in its place.
+func (c *Configuration) NotifierTimeoutDuration() (time.Duration, error) {
+	if c.NotifierTimeout == "" {
+		return defaultNotifierTimeout, nil
+	}
+	d, err := time.ParseDuration(c.NotifierTimeout)
+	if err == nil && d <= 0 {
+		err = errors.New("must be positive")
+	}
+	return d, err
+}
+
+// IsAllowedOrigin checks whether browsers on the origin may call the API.
+// "*" allows every origin.
+func (c *Configuration) IsAllowedOrigin(origin string) bool {
+	for _, o := range c.CORSAllowedOrigins {
+		if o == "*" || strings.EqualFold(strings.TrimRight(o, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMethods returns the methods browsers on the cors_allowed_origins may
+// use, GET and POST by default.
+func (c *Configuration) CORSMethods() []string {
+	if len(c.CORSAllowedMethods) == 0 {
+		return defaultCORSAllowedMethods
+	}
+	return c.CORSAllowedMethods
+}
+
 func readConfiguration(path string) (*Configuration, error) {
-	var config Configuration
+	config := Configuration{dir: filepath.Dir(path)}
 
 	configFile, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.Unmarshal(configFile, &config)
+	config.warnings, err = parseConfiguration(configFile, configurationFormat(path), &config)
 	if err != nil {
 		return nil, err
 	}
 
+	if config.ApplicationsDir != "" {
+		dir := config.ApplicationsDir
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(path), dir)
+		}
+		applications, warnings, err := readApplicationsDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid applications_dir: %s", err)
+		}
+		config.Applications = append(config.Applications, applications...)
+		config.warnings = append(config.warnings, warnings...)
+	}
+
+	names := make(map[string]bool)
+	for _, a := range config.Applications {
+		if names[a.Name] {
+			return nil, fmt.Errorf("application %s is configured more than once", a.Name)
+		}
+		names[a.Name] = true
+	}
+
+	err = resolveSecrets(&config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.SessionSecret != "" && len(config.SessionSecrets) > 0 {
+		return nil, errors.New("set either session_secret or session_secrets")
+	}
+	for _, s := range config.SessionSecrets {
+		if s == "" {
+			return nil, errors.New("invalid session_secrets: empty secret")
+		}
+	}
+
+	if _, err := config.SessionTimeout(); err != nil {
+		return nil, fmt.Errorf("invalid session_ttl: %s", err)
+	}
+
+	if _, err := config.MembershipSyncInterval(); err != nil {
+		return nil, fmt.Errorf("invalid github_membership_sync_interval: %s", err)
+	}
+
+	if _, err := config.RateLimitWindowDuration(); err != nil {
+		return nil, fmt.Errorf("invalid rate_limit_window: %s", err)
+	}
+
+	if _, err := config.LoginLockoutDuration(); err != nil {
+		return nil, fmt.Errorf("invalid login_lockout: %s", err)
+	}
+
+	if _, err := config.ShutdownTimeoutDuration(); err != nil {
+		return nil, fmt.Errorf("invalid shutdown_timeout: %s", err)
+	}
+
+	if _, err := config.SCMCacheTTLDuration(); err != nil {
+		return nil, fmt.Errorf("invalid scm_cache_ttl: %s", err)
+	}
+
+	if config.NotifierWorkers < 0 {
+		return nil, errors.New("invalid notifier_workers: must not be negative")
+	}
+
+	if _, err := config.NotifierTimeoutDuration(); err != nil {
+		return nil, fmt.Errorf("invalid notifier_timeout: %s", err)
+	}
+
+	if config.SentryDSN != "" {
+		if _, err := NewSentryClient(config.SentryDSN, "", ""); err != nil {
+			return nil, fmt.Errorf("invalid sentry_dsn: %s", err)
+		}
+	}
+
+	if err := validateCORS(&config); err != nil {
+		return nil, err
+	}
+
+	if err := validateTLS(&config); err != nil {
+		return nil, err
+	}
+
+	config.ipAllowlists, err = parseIPAllowlists(config.IPAllowlists)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ip_allowlists: %s", err)
+	}
+
+	config.redactionPatterns, err = compileRedactionPatterns(config.LogRedactionPatterns)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log_redaction_patterns: %s", err)
+	}
+
+	if err := validateServiceAccounts(&config); err != nil {
+		return nil, fmt.Errorf("invalid service_accounts: %s", err)
+	}
+
+	if len(config.SlackUsers) > 0 && config.SlackSigningSecret == "" {
+		return nil, errors.New("slack_signing_secret is required with slack_users")
+	}
+
+	if config.GitHubAppId != 0 && config.GitHubAppPrivateKeyFile == "" {
+		return nil, errors.New("github_app_private_key_file is required with github_app_id")
+	}
+
+	if config.GiteaClientId != "" && config.GiteaURL == "" {
+		return nil, errors.New("gitea_url is required with gitea_client_id")
+	}
+
+	for _, p := range config.ExemptLoginProviders {
+		switch p {
+		case GITLAB_PROVIDER, BITBUCKET_PROVIDER, GITEA_PROVIDER, OIDC_PROVIDER, SAML_PROVIDER:
+		default:
+			return nil, fmt.Errorf("unknown login provider %q in exempt_login_providers", p)
+		}
+	}
+
+	for _, a := range config.Applications {
+		if err := validateApplication(&config, a); err != nil {
+			return nil, err
+		}
+	}
+	config.fileApplications = config.Applications
+
 	return &config, nil
 }
+
+// validateApplication checks the settings of an application and its targets
+// that can't be checked by parsing them.
+func validateApplication(c *Configuration, a *models.Application) error {
+	if !isValidSCM(a.SCMName()) {
+		return fmt.Errorf("invalid scm %q for %s", a.SCM, a.Name)
+	}
+	if a.SCMName() == models.SCM_GITEA && c.GiteaURL == "" {
+		return fmt.Errorf("gitea_url is required for the scm of %s", a.Name)
+	}
+
+	if err := validateAutoDeploy(c, a); err != nil {
+		return fmt.Errorf("invalid auto_deploy for %s: %s", a.Name, err)
+	}
+	if err := validateCITrigger(c, a); err != nil {
+		return fmt.Errorf("invalid ci_trigger for %s: %s", a.Name, err)
+	}
+	if a.IssueTracker != nil {
+		if err := a.IssueTracker.Validate(); err != nil {
+			return fmt.Errorf("invalid issue_tracker for %s: %s", a.Name, err)
+		}
+	}
+	if err := validateJiraRelease(a); err != nil {
+		return fmt.Errorf("invalid jira_release for %s: %s", a.Name, err)
+	}
+	if err := validateDigestTemplates(c.dir, a); err != nil {
+		return fmt.Errorf("invalid digest template for %s: %s", a.Name, err)
+	}
+
+	for _, t := range a.Targets {
+		if _, err := t.Timeout(); err != nil {
+			return fmt.Errorf("invalid deployment_timeout for target %s of %s: %s", t.Name, a.Name, err)
+		}
+		if _, err := t.ApprovalTimeout(); err != nil {
+			return fmt.Errorf("invalid pause_timeout for target %s of %s: %s", t.Name, a.Name, err)
+		}
+		if _, err := t.RetryDelay(); err != nil {
+			return fmt.Errorf("invalid auto_retry_delay for target %s of %s: %s", t.Name, a.Name, err)
+		}
+		if err := validateDeployableBranches(t); err != nil {
+			return fmt.Errorf("invalid deployable_branches for target %s of %s: %s", t.Name, a.Name, err)
+		}
+		for _, name := range t.OverridableVariables {
+			if !overridableVariablePattern.MatchString(name) {
+				return fmt.Errorf("invalid overridable_variables for target %s of %s: %q is no name like MIGRATION_TIMEOUT", t.Name, a.Name, name)
+			}
+		}
+		for _, w := range t.DeployWindows {
+			if err := w.Validate(); err != nil {
+				return fmt.Errorf("invalid deploy_windows for target %s of %s: %s", t.Name, a.Name, err)
+			}
+		}
+		if _, err := a.DigestSchedule(t); err != nil {
+			return fmt.Errorf("invalid digest schedule for target %s of %s: %s", t.Name, a.Name, err)
+		}
+		if t.ApprovalSlackUrl != "" && c.SlackSigningSecret == "" {
+			return fmt.Errorf("slack_signing_secret is required for the approval_slack_url of target %s of %s", t.Name, a.Name)
+		}
+		if t.ApprovalTeamsUrl != "" && len(c.TeamsUsers) == 0 {
+			return fmt.Errorf("teams_users is required for the approval_teams_url of target %s of %s", t.Name, a.Name)
+		}
+		if t.Statuspage != nil {
+			if err := t.Statuspage.Validate(); err != nil {
+				return fmt.Errorf("invalid statuspage for target %s of %s: %s", t.Name, a.Name, err)
+			}
+		}
+		if t.DigestSlackChannel != "" && c.SlackBotToken == "" {
+			return fmt.Errorf("slack_bot_token is required for the digest_slack_channel of target %s of %s", t.Name, a.Name)
+		}
+		if t.RequirePassingCI && a.SCMName() != models.SCM_GITHUB {
+			return fmt.Errorf("require_passing_ci for target %s of %s is only supported on GitHub", t.Name, a.Name)
+		}
+		if err := validateBlueGreen(t); err != nil {
+			return fmt.Errorf("invalid blue_green configuration for target %s of %s: %s", t.Name, a.Name, err)
+		}
+		for _, h := range t.Hosts {
+			if h.Port < 0 || h.Port > 65535 {
+				return fmt.Errorf("invalid port %d of host %s of target %s of %s", h.Port, h.Name, t.Name, a.Name)
+			}
+		}
+		if t.KnownHostsFile != "" {
+			if _, err := os.Stat(t.KnownHostsFile); err != nil {
+				return fmt.Errorf("invalid known_hosts_file for target %s of %s: %s", t.Name, a.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateCORS(c *Configuration) error {
+	for _, origin := range c.CORSAllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || strings.TrimRight(u.Path, "/") != "" {
+			return fmt.Errorf("invalid cors_allowed_origins: %q is no origin like https://dashboard.example.com", origin)
+		}
+	}
+
+	for i, method := range c.CORSAllowedMethods {
+		c.CORSAllowedMethods[i] = strings.ToUpper(method)
+	}
+
+	return nil
+}
+
+// overridableVariablePattern matches names like MIGRATION_TIMEOUT. The options
+// set by Applikatoni, like CommitSha, can't be overridden.
+var overridableVariablePattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+func validateDeployableBranches(t *models.Target) error {
+	for _, pattern := range t.DeployableBranches {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("%q: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+func validateBlueGreen(t *models.Target) error {
+	if !t.IsBlueGreen() {
+		return nil
+	}
+
+	if !t.AreValidStages([]models.DeploymentStage{t.BlueGreen.SwitchStage}) {
+		return fmt.Errorf("switch_stage %q is not an available stage", t.BlueGreen.SwitchStage)
+	}
+
+	groupSizes := make(map[string]int)
+	for _, h := range t.Hosts {
+		if h.Group != "" && !models.IsValidGroup(h.Group) {
+			return fmt.Errorf("host %s has invalid group %q", h.Name, h.Group)
+		}
+		groupSizes[h.Group]++
+	}
+
+	for _, group := range []string{models.BLUE_GROUP, models.GREEN_GROUP} {
+		if groupSizes[group] == 0 {
+			return fmt.Errorf("no hosts in group %s", group)
+		}
+	}
+
+	return nil
+}
+
+// readSshKeyPassphrases asks for the passphrase of every encrypted deployment
+// SSH key of a target or host without a configured
+// deployment_ssh_key_passphrase, so the passphrase doesn't have to be stored
+// on disk. Keys used several times are only asked for once.
+func readSshKeyPassphrases(config *Configuration, prompt func(string) (string, error)) error {
+	passphrases := make(map[string]string)
+
+	read := func(sshKey string, passphrase *string, owner string) error {
+		key := []byte(sshKey)
+		if *passphrase == "" && deploy.IsEncryptedSSHKey(key) {
+			known, ok := passphrases[sshKey]
+			if !ok {
+				var err error
+				known, err = prompt(fmt.Sprintf("Passphrase for the SSH key of %s: ", owner))
+				if err != nil {
+					return fmt.Errorf("reading passphrase for %s failed: %s", owner, err)
+				}
+			}
+			*passphrase = known
+		}
+
+		if *passphrase != "" {
+			if err := deploy.ValidateSSHKey(key, *passphrase); err != nil {
+				return fmt.Errorf("invalid SSH key passphrase for %s: %s", owner, err)
+			}
+			passphrases[sshKey] = *passphrase
+		}
+		return nil
+	}
+
+	for _, a := range config.Applications {
+		for _, t := range a.Targets {
+			err := read(t.DeploymentSshKey, &t.SshKeyPassphrase, fmt.Sprintf("target %s of %s", t.Name, a.Name))
+			if err != nil {
+				return err
+			}
+
+			for _, h := range t.Hosts {
+				if h.DeploymentSshKey == "" {
+					continue
+				}
+				err := read(h.DeploymentSshKey, &h.SshKeyPassphrase, fmt.Sprintf("host %s of target %s of %s", h.Name, t.Name, a.Name))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
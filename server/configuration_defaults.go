@@ -0,0 +1,128 @@
+package main
+
+import "fmt"
+
+// applyTargetDefaults merges the target_defaults of the applications into
+// their targets. values is the decoded configuration, or a decoded
+// application if isApplication is true. It reports whether any application
+// had target_defaults.
+//
+// A setting of a target replaces the default, except for objects, which are
+// merged key by key, and roles, which are merged with the default role of the
+// same name. Setting a value to null removes the default.
+func applyTargetDefaults(values interface{}, isApplication bool) (bool, error) {
+	root, ok := values.(map[string]interface{})
+	if !ok {
+		return false, nil
+	}
+
+	applications := []interface{}{root}
+	if !isApplication {
+		applications, _ = root["applications"].([]interface{})
+	}
+
+	inherited := false
+	for i, value := range applications {
+		a, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		raw, ok := a["target_defaults"]
+		if !ok {
+			continue
+		}
+		delete(a, "target_defaults")
+		inherited = true
+
+		defaults, ok := raw.(map[string]interface{})
+		if !ok {
+			return inherited, fmt.Errorf("target_defaults of application %s is no object", applicationLabel(a, i))
+		}
+
+		targets, _ := a["targets"].([]interface{})
+		for j, t := range targets {
+			target, ok := t.(map[string]interface{})
+			if !ok {
+				return inherited, fmt.Errorf("target %d of application %s is no object", j+1, applicationLabel(a, i))
+			}
+			targets[j] = mergeTargetSettings(defaults, target)
+		}
+	}
+
+	return inherited, nil
+}
+
+// mergeTargetSettings returns the settings of the target on top of the
+// defaults, merging the roles by name.
+func mergeTargetSettings(defaults, target map[string]interface{}) map[string]interface{} {
+	merged := mergeSettings(defaults, target)
+
+	defaultRoles, hasDefaultRoles := defaults["roles"].([]interface{})
+	targetRoles, hasTargetRoles := target["roles"].([]interface{})
+	if hasDefaultRoles && hasTargetRoles {
+		merged["roles"] = mergeRoles(defaultRoles, targetRoles)
+	}
+
+	return merged
+}
+
+// mergeSettings returns the defaults with the overrides on top. Objects
+// present in both are merged recursively, other values are replaced. Neither
+// map is changed.
+func mergeSettings(defaults, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(defaults)+len(overrides))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+
+	for key, value := range overrides {
+		defaultObject, isDefaultObject := merged[key].(map[string]interface{})
+		object, isObject := value.(map[string]interface{})
+		if isDefaultObject && isObject {
+			merged[key] = mergeSettings(defaultObject, object)
+			continue
+		}
+		merged[key] = value
+	}
+
+	return merged
+}
+
+// mergeRoles merges the roles of a target into the default roles. A role with
+// the name of a default role changes it, other roles are added.
+func mergeRoles(defaults, overrides []interface{}) []interface{} {
+	merged := make([]interface{}, len(defaults))
+	copy(merged, defaults)
+
+	for _, value := range overrides {
+		role, ok := value.(map[string]interface{})
+		name, _ := role["name"].(string)
+		if !ok || name == "" {
+			merged = append(merged, value)
+			continue
+		}
+
+		replaced := false
+		for i, d := range merged {
+			defaultRole, _ := d.(map[string]interface{})
+			if defaultName, _ := defaultRole["name"].(string); defaultName == name {
+				merged[i] = mergeSettings(defaultRole, role)
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, role)
+		}
+	}
+
+	return merged
+}
+
+// applicationLabel names an application in errors before it's parsed.
+func applicationLabel(a map[string]interface{}, i int) string {
+	if name, ok := a["name"].(string); ok && name != "" {
+		return name
+	}
+	return fmt.Sprintf("%d", i+1)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestParseConfigurationTargetDefaults(t *testing.T) {
+	content := `
+applications:
+  - name: web
+    target_defaults:
+      deployment_user: deploy
+      deployment_timeout: 10m
+      available_stages: [CHECK_CONNECTION, CODE_DEPLOYMENT]
+      slack_url: https://hooks.slack.com/services/ops
+      blue_green:
+        switch_stage: CODE_DEPLOYMENT
+      roles:
+        - name: web
+          script_templates:
+            CHECK_CONNECTION: uptime
+            CODE_DEPLOYMENT: cd /app && git pull
+        - name: worker
+          script_templates:
+            CODE_DEPLOYMENT: restart worker
+    targets:
+      - name: production
+        deployment_timeout: 30m
+        roles:
+          - name: web
+            script_templates:
+              CODE_DEPLOYMENT: cd /srv/app && git pull
+          - name: cron
+            script_templates:
+              CODE_DEPLOYMENT: crontab /app/crontab
+      - name: staging
+        slack_url: null
+        available_stages: [CODE_DEPLOYMENT]
+        blue_green:
+          switch_stage: CHECK_CONNECTION
+`
+
+	var c Configuration
+	_, err := parseConfiguration([]byte(content), configFormatYAML, &c)
+	checkErr(t, err)
+
+	targets := c.Applications[0].Targets
+	production, staging := targets[0], targets[1]
+
+	if production.DeploymentUser != "deploy" || staging.DeploymentUser != "deploy" {
+		t.Errorf("deployment_user not inherited. got=%q, %q", production.DeploymentUser, staging.DeploymentUser)
+	}
+	if production.DeploymentTimeout != "30m" || staging.DeploymentTimeout != "10m" {
+		t.Errorf("wrong deployment_timeout. got=%q, %q", production.DeploymentTimeout, staging.DeploymentTimeout)
+	}
+	if production.SlackUrl == "" || staging.SlackUrl != "" {
+		t.Errorf("wrong slack_url. got=%q, %q", production.SlackUrl, staging.SlackUrl)
+	}
+	expectedStages := []models.DeploymentStage{"CODE_DEPLOYMENT"}
+	if !reflect.DeepEqual(staging.AvailableStages, expectedStages) {
+		t.Errorf("available_stages not replaced. want=%v, got=%v", expectedStages, staging.AvailableStages)
+	}
+	if staging.BlueGreen.SwitchStage != "CHECK_CONNECTION" || production.BlueGreen.SwitchStage != "CODE_DEPLOYMENT" {
+		t.Errorf("wrong switch_stage. got=%q, %q", production.BlueGreen.SwitchStage, staging.BlueGreen.SwitchStage)
+	}
+
+	expectedRoles := map[string]map[models.DeploymentStage]string{
+		"web": {
+			"CHECK_CONNECTION": "uptime",
+			"CODE_DEPLOYMENT":  "cd /srv/app && git pull",
+		},
+		"worker": {"CODE_DEPLOYMENT": "restart worker"},
+		"cron":   {"CODE_DEPLOYMENT": "crontab /app/crontab"},
+	}
+	if len(production.Roles) != len(expectedRoles) {
+		t.Fatalf("wrong number of roles. want=%d, got=%d", len(expectedRoles), len(production.Roles))
+	}
+	for _, r := range production.Roles {
+		if !reflect.DeepEqual(r.ScriptTemplates, expectedRoles[r.Name]) {
+			t.Errorf("wrong script_templates of role %s. want=%v, got=%v", r.Name, expectedRoles[r.Name], r.ScriptTemplates)
+		}
+	}
+	if len(staging.Roles) != 2 || staging.Roles[0].ScriptTemplates["CODE_DEPLOYMENT"] != "cd /app && git pull" {
+		t.Errorf("roles not inherited. got=%+v", staging.Roles)
+	}
+}
+
+func TestParseApplicationTargetDefaults(t *testing.T) {
+	content := `{
+  "name": "web",
+  "target_defaults": {"deployment_user": "deploy"},
+  "targets": [{"name": "production"}]
+}`
+
+	var a models.Application
+	_, err := parseConfiguration([]byte(content), configFormatJSON, &a)
+	checkErr(t, err)
+
+	if a.Targets[0].DeploymentUser != "deploy" {
+		t.Errorf("deployment_user not inherited. got=%q", a.Targets[0].DeploymentUser)
+	}
+}
+
+func TestParseConfigurationTargetDefaultsErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{
+			`{"applications": [{"name": "web", "target_defaults": ["deploy"], "targets": []}]}`,
+			"target_defaults of application web is no object",
+		},
+		{
+			`{"applications": [{"name": "web", "target_defaults": {}, "targets": ["production"]}]}`,
+			"target 1 of application web is no object",
+		},
+		{
+			`{"applications": [{"name": "web", "target_defaults": {"hosts": "1.web"}, "targets": [{"name": "production"}]}]}`,
+			"hosts: expected []*models.Host, got string",
+		},
+	}
+
+	for _, tt := range tests {
+		var c Configuration
+		_, err := parseConfiguration([]byte(tt.content), configFormatJSON, &c)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("wrong error. want=%q, got=%v", tt.err, err)
+		}
+	}
+}
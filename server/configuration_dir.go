@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// applicationFileExtensions are the extensions of the files read from the
+// applications_dir. Other files, e.g. a README, are skipped.
+var applicationFileExtensions = []string{".json", ".yml", ".yaml", ".toml"}
+
+// readApplicationsDir reads the applications from the files in dir, one
+// application per file, in the order of their names. Teams can own the file
+// of their application this way. The warnings about migrating the files to
+// the current schema_version name the file.
+func readApplicationsDir(dir string) ([]*models.Application, []string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	applications := []*models.Application{}
+	warnings := []string{}
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") || !isApplicationFile(f.Name()) {
+			continue
+		}
+
+		path := filepath.Join(dir, f.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var a models.Application
+		fileWarnings, err := parseConfiguration(content, configurationFormat(path), &a)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if a.Name == "" {
+			return nil, nil, fmt.Errorf("%s: the application has no name", path)
+		}
+		for _, w := range fileWarnings {
+			warnings = append(warnings, fmt.Sprintf("%s: %s", path, w))
+		}
+
+		applications = append(applications, &a)
+	}
+
+	return applications, warnings, nil
+}
+
+func isApplicationFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, e := range applicationFileExtensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
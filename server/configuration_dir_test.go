@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, dir, name, content string) {
+	checkErr(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+}
+
+func TestReadApplicationsDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "applikatoni-conf")
+	checkErr(t, err)
+	defer os.RemoveAll(dir)
+
+	checkErr(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0700))
+	writeTestFile(t, dir, "configuration.json", `{
+  "session_secret": "s3ss10n",
+  "applications_dir": "conf.d",
+  "applications": [{"name": "web", "targets": [{"name": "production"}]}]
+}`)
+	writeTestFile(t, dir, "conf.d/20-worker.yml", "name: worker\ntargets:\n  - name: staging\n")
+	writeTestFile(t, dir, "conf.d/10-api.json", `{"name": "api", "targets": [{"name": "production"}]}`)
+	writeTestFile(t, dir, "conf.d/README.md", "# Our applications")
+
+	c, err := readConfiguration(filepath.Join(dir, "configuration.json"))
+	checkErr(t, err)
+
+	expected := []string{"web", "api", "worker"}
+	if len(c.Applications) != len(expected) {
+		t.Fatalf("wrong number of applications. want=%d, got=%d", len(expected), len(c.Applications))
+	}
+	for i, name := range expected {
+		if c.Applications[i].Name != name {
+			t.Errorf("wrong application. want=%s, got=%s", name, c.Applications[i].Name)
+		}
+	}
+	if c.Applications[2].Targets[0].Name != "staging" {
+		t.Errorf("wrong target. want=%s, got=%s", "staging", c.Applications[2].Targets[0].Name)
+	}
+
+	tests := []struct {
+		file     string
+		content  string
+		expected string
+	}{
+		{"conf.d/30-web.json", `{"name": "web"}`, "application web is configured more than once"},
+		{"conf.d/30-broken.toml", "name = ", "30-broken.toml: invalid TOML"},
+		{"conf.d/30-nameless.json", `{"targets": []}`, "30-nameless.json: the application has no name"},
+	}
+
+	for _, tt := range tests {
+		writeTestFile(t, dir, tt.file, tt.content)
+
+		_, err := readConfiguration(filepath.Join(dir, "configuration.json"))
+		if err == nil || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.file, tt.expected, err)
+		}
+
+		checkErr(t, os.Remove(filepath.Join(dir, tt.file)))
+	}
+}
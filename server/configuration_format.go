@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/applikatoni/applikatoni/models"
+	"gopkg.in/yaml.v3"
+)
+
+// The formats of the configuration file, picked by its extension. JSON is the
+// default.
+const (
+	configFormatJSON = "JSON"
+	configFormatYAML = "YAML"
+	configFormatTOML = "TOML"
+)
+
+func configurationFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return configFormatYAML
+	case ".toml":
+		return configFormatTOML
+	default:
+		return configFormatJSON
+	}
+}
+
+// parseConfiguration fills v, the configuration or an application, from the
+// file content in the given format. YAML and TOML are converted to JSON first,
+// so all formats use the same keys. The target_defaults of the applications
+// are merged into their targets and older layouts are migrated to the current
+// schema_version before v is filled. It returns warnings about the
+// migrations. The errors name the line or the key that's wrong.
+func parseConfiguration(content []byte, format string, v interface{}) ([]string, error) {
+	var values interface{}
+	var err error
+
+	switch format {
+	case configFormatYAML:
+		err = yaml.Unmarshal(content, &values)
+	case configFormatTOML:
+		tables := map[string]interface{}{}
+		_, err = toml.Decode(string(content), &tables)
+		values = tables
+	default:
+		err = json.Unmarshal(content, &values)
+		if err != nil {
+			return nil, describeParseError(content, err, true)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", format, err)
+	}
+
+	values = jsonCompatible(values)
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+
+	_, isApplication := v.(*models.Application)
+	inherited, err := applyTargetDefaults(values, isApplication)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings, migrated, err := migrateConfiguration(values, isApplication)
+	if err != nil {
+		return nil, err
+	}
+
+	// The lines of errors are only known if the JSON is used as it is
+	data := content
+	lines := format == configFormatJSON && !inherited && !migrated
+	if !lines {
+		data, err = json.Marshal(values)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", format, err)
+		}
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return nil, describeParseError(data, err, lines)
+	}
+	return warnings, nil
+}
+
+// describeParseError adds the line, if lines is true, or the key to an error
+// of unmarshalling the JSON data.
+func describeParseError(data []byte, err error, lines bool) error {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		return fmt.Errorf("invalid JSON in line %d: %s", lineOfOffset(data, syntaxErr.Offset), err)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if lines {
+			return fmt.Errorf("invalid value for %s in line %d: expected %s, got %s", typeErr.Field, lineOfOffset(data, typeErr.Offset), typeErr.Type, typeErr.Value)
+		}
+		return fmt.Errorf("invalid value for %s: expected %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+
+	return err
+}
+
+// jsonCompatible turns the maps with non-string keys YAML can produce into
+// maps with string keys, so the values can be marshalled to JSON.
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = jsonCompatible(value)
+		}
+		return m
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = jsonCompatible(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = jsonCompatible(value)
+		}
+	}
+	return v
+}
+
+// lineOfOffset returns the line of the byte offset in data, starting at 1.
+func lineOfOffset(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
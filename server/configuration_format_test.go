@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigurationFormat(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"configuration.json", configFormatJSON},
+		{"configuration.yml", configFormatYAML},
+		{"/etc/applikatoni/configuration.YAML", configFormatYAML},
+		{"configuration.toml", configFormatTOML},
+		{"configuration", configFormatJSON},
+	}
+
+	for _, tt := range tests {
+		format := configurationFormat(tt.path)
+		if format != tt.expected {
+			t.Errorf("wrong format for %s. want=%s, got=%s", tt.path, tt.expected, format)
+		}
+	}
+}
+
+func TestParseConfiguration(t *testing.T) {
+	tests := []struct {
+		format  string
+		content string
+	}{
+		{configFormatJSON, `{
+  "host": "applikatoni.shipping-company.com",
+  "ssl_enabled": true,
+  "applications": [
+    {
+      "name": "web",
+      "targets": [{"name": "production", "hosts": [{"name": "1.web", "roles": ["web"]}]}]
+    }
+  ]
+}`},
+		{configFormatYAML, `
+# Deployed by the ops team
+host: applikatoni.shipping-company.com
+ssl_enabled: true
+applications:
+  - name: web
+    targets:
+      - name: production
+        hosts:
+          - name: 1.web
+            roles: [web]
+`},
+		{configFormatTOML, `
+# Deployed by the ops team
+host = "applikatoni.shipping-company.com"
+ssl_enabled = true
+
+[[applications]]
+name = "web"
+
+  [[applications.targets]]
+  name = "production"
+
+    [[applications.targets.hosts]]
+    name = "1.web"
+    roles = ["web"]
+`},
+	}
+
+	for _, tt := range tests {
+		var c Configuration
+		_, err := parseConfiguration([]byte(tt.content), tt.format, &c)
+		if err != nil {
+			t.Fatalf("parsing %s failed: %s", tt.format, err)
+		}
+
+		if c.Host != "applikatoni.shipping-company.com" || !c.SSLEnabled {
+			t.Errorf("wrong settings in %s. got=%q, %v", tt.format, c.Host, c.SSLEnabled)
+		}
+		if len(c.Applications) != 1 || len(c.Applications[0].Targets) != 1 {
+			t.Fatalf("wrong applications in %s. got=%+v", tt.format, c.Applications)
+		}
+		target := c.Applications[0].Targets[0]
+		if target.Name != "production" || len(target.Hosts) != 1 || target.Hosts[0].Name != "1.web" {
+			t.Errorf("wrong target in %s. got=%+v", tt.format, target)
+		}
+	}
+}
+
+func TestParseConfigurationErrors(t *testing.T) {
+	tests := []struct {
+		format   string
+		content  string
+		expected string
+	}{
+		{configFormatJSON, "{\n  \"host\": \"x\"\n  \"ssl_enabled\": true\n}", "line 3"},
+		{configFormatJSON, "{\n  \"host\": \"x\",\n  \"ssl_enabled\": \"yes\"\n}", "ssl_enabled in line 3"},
+		{configFormatYAML, "host: x\nssl_enabled: yes please\napplications: [\n", "invalid YAML"},
+		{configFormatYAML, "host: x\nssl_enabled: yes please\n", "ssl_enabled"},
+		{configFormatTOML, "host = \"x\"\nssl_enabled = \n", "invalid TOML"},
+		{configFormatTOML, "host = \"x\"\nssl_enabled = \"yes\"\n", "ssl_enabled"},
+	}
+
+	for _, tt := range tests {
+		var c Configuration
+		_, err := parseConfiguration([]byte(tt.content), tt.format, &c)
+		if err == nil {
+			t.Errorf("parsing %q didn't fail", tt.content)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("wrong error for %q. want=%q, got=%q", tt.content, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// configReloadMutex keeps reloads from overlapping.
+var configReloadMutex sync.Mutex
+
+// getConfig returns the current configuration. Reloads replace it instead of
+// changing it, so callers that need several settings should keep the result
+// instead of calling getConfig again.
+func getConfig() *Configuration {
+	return currentConfig.Load()
+}
+
+// setConfig replaces the current configuration, e.g. on reloads.
+func setConfig(c *Configuration) {
+	currentConfig.Store(c)
+}
+
+var errReloadPassphrase = errors.New("can't ask for passphrases while reloading, set deployment_ssh_key_passphrase or restart")
+
+// reloadConfiguration reads the configuration file again and switches to its
+// applications, followed by the ones managed in the database, service
+// accounts and admins. The other settings, e.g. the
+// login providers and the session secret, only change on restart. If the new
+// configuration is invalid, the old one is kept.
+//
+// Running and queued deployments keep the targets they were started with, so
+// they aren't affected by the reload.
+func reloadConfiguration(path string) error {
+	configReloadMutex.Lock()
+	defer configReloadMutex.Unlock()
+
+	loaded, err := readConfiguration(path)
+	if err != nil {
+		return err
+	}
+	logConfigurationWarnings(loaded)
+
+	keepSshKeyPassphrases(getConfig(), loaded)
+	err = readSshKeyPassphrases(loaded, func(string) (string, error) {
+		return "", errReloadPassphrase
+	})
+	if err != nil {
+		return err
+	}
+
+	var managed []*models.Application
+	if db != nil {
+		err = syncServiceAccounts(db, loaded.ServiceAccounts)
+		if err != nil {
+			return err
+		}
+		managed, err = loadManagedApplications(db, loaded)
+		if err != nil {
+			return err
+		}
+	}
+
+	reloaded := *getConfig()
+	reloaded.fileApplications = loaded.fileApplications
+	applyManagedApplications(&reloaded, managed)
+	reloaded.ServiceAccounts = loaded.ServiceAccounts
+	reloaded.AdminUsernames = loaded.AdminUsernames
+	setConfig(&reloaded)
+
+	return nil
+}
+
+// keepSshKeyPassphrases copies the passphrases entered on startup to the
+// targets and hosts of the reloaded configuration using the same SSH keys.
+func keepSshKeyPassphrases(old, loaded *Configuration) {
+	passphrases := make(map[string]string)
+	for _, a := range old.Applications {
+		for _, t := range a.Targets {
+			if t.SshKeyPassphrase != "" {
+				passphrases[t.DeploymentSshKey] = t.SshKeyPassphrase
+			}
+			for _, h := range t.Hosts {
+				if h.SshKeyPassphrase != "" {
+					passphrases[h.DeploymentSshKey] = h.SshKeyPassphrase
+				}
+			}
+		}
+	}
+
+	for _, a := range loaded.Applications {
+		for _, t := range a.Targets {
+			if t.SshKeyPassphrase == "" {
+				t.SshKeyPassphrase = passphrases[t.DeploymentSshKey]
+			}
+			for _, h := range t.Hosts {
+				if h.DeploymentSshKey != "" && h.SshKeyPassphrase == "" {
+					h.SshKeyPassphrase = passphrases[h.DeploymentSshKey]
+				}
+			}
+		}
+	}
+}
+
+// reloadConfigurationOnSignal reloads the configuration whenever the process
+// receives SIGHUP.
+func reloadConfigurationOnSignal(path string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		err := reloadConfiguration(path)
+		if err != nil {
+			slog.Error("reloading the configuration failed, keeping the old one", "err", err)
+			continue
+		}
+		slog.Info("reloaded the configuration", "path", path)
+	}
+}
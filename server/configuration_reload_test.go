@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func writeTestConfiguration(t *testing.T, c *Configuration) string {
+	f, err := ioutil.TempFile("", "applikatoni-configuration")
+	checkErr(t, err)
+	defer f.Close()
+
+	checkErr(t, json.NewEncoder(f).Encode(c))
+	return f.Name()
+}
+
+func TestReloadConfiguration(t *testing.T) {
+	key := encryptedTestKey(t, "s3cret")
+
+	staging := &models.Target{Name: "staging", DeploymentSshKey: key, SshKeyPassphrase: "s3cret"}
+	setConfig(&Configuration{
+		SessionSecret:  "s3ss10n",
+		AdminUsernames: []string{"fabrik42"},
+		Applications: []*models.Application{
+			{Name: "flincOnRails", Targets: []*models.Target{staging}},
+		},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	path := writeTestConfiguration(t, &Configuration{
+		SessionSecret:  "changed",
+		AdminUsernames: []string{"mrnugget"},
+		Applications: []*models.Application{
+			{Name: "flincOnRails", Targets: []*models.Target{
+				{Name: "staging", DeploymentSshKey: key},
+				{Name: "production", DeploymentSshKey: key},
+			}},
+		},
+	})
+	defer os.Remove(path)
+
+	err := reloadConfiguration(path)
+	checkErr(t, err)
+
+	if getConfig().SessionSecret != "s3ss10n" {
+		t.Errorf("session secret reloaded. want=%s, got=%s", "s3ss10n", getConfig().SessionSecret)
+	}
+	if len(getConfig().AdminUsernames) != 1 || getConfig().AdminUsernames[0] != "mrnugget" {
+		t.Errorf("wrong admins. got=%v", getConfig().AdminUsernames)
+	}
+
+	targets := getConfig().Applications[0].Targets
+	if len(targets) != 2 {
+		t.Fatalf("wrong number of targets. want=%d, got=%d", 2, len(targets))
+	}
+	for _, target := range targets {
+		if target.SshKeyPassphrase != "s3cret" {
+			t.Errorf("passphrase not kept for %s. got=%q", target.Name, target.SshKeyPassphrase)
+		}
+	}
+	if staging.Name != "staging" || len(staging.Hosts) != 0 {
+		t.Errorf("old target changed. got=%+v", staging)
+	}
+}
+
+func TestReloadInvalidConfiguration(t *testing.T) {
+	old := &Configuration{Applications: []*models.Application{{Name: "flincOnRails"}}}
+	setConfig(old)
+	defer func() { setConfig(&Configuration{}) }()
+
+	for _, c := range []*Configuration{
+		{Applications: []*models.Application{{Name: "flincOnRails", SCM: "svn"}}},
+		{Applications: []*models.Application{
+			{Name: "flincOnRails", Targets: []*models.Target{{Name: "staging", DeploymentSshKey: encryptedTestKey(t, "s3cret")}}},
+		}},
+	} {
+		path := writeTestConfiguration(t, c)
+		defer os.Remove(path)
+
+		err := reloadConfiguration(path)
+		if err == nil {
+			t.Errorf("no error reloading %+v", c.Applications[0])
+		}
+		if getConfig() != old {
+			t.Errorf("configuration replaced by invalid one")
+		}
+	}
+}
+
+func TestReloadConfigurationWhileReading(t *testing.T) {
+	setConfig(&Configuration{Applications: []*models.Application{{Name: "flincOnRails"}}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	path := writeTestConfiguration(t, &Configuration{
+		Applications: []*models.Application{{Name: "flincOnRails"}, {Name: "shop"}},
+	})
+	defer os.Remove(path)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if len(getConfig().Applications) == 0 {
+				t.Errorf("no applications while reloading")
+			}
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		checkErr(t, reloadConfiguration(path))
+	}
+	<-done
+
+	if len(getConfig().Applications) != 2 {
+		t.Errorf("wrong number of applications. want=%d, got=%d", 2, len(getConfig().Applications))
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+)
+
+// currentSchemaVersion is the schema_version of the configuration layout read
+// by this version of Applikatoni. Configurations without a schema_version were
+// written before the layout was versioned and are read as version 1.
+//
+// Changing the meaning of a key requires a new version and a migration from
+// the previous one, so older configurations aren't silently misread.
+const currentSchemaVersion = 2
+
+// configMigration changes the decoded configuration, or a decoded application
+// if isApplication is true, from one schema_version to the next. It returns a
+// warning for every change, so they can be made in the file.
+type configMigration func(values map[string]interface{}, isApplication bool) []string
+
+// configMigrations[i] migrates from version i+1 to version i+2.
+var configMigrations = []configMigration{
+	migrateHostPorts,
+}
+
+// migrateConfiguration migrates the decoded configuration, or a decoded
+// application if isApplication is true, from its schema_version to the
+// current one. It reports whether anything changed and fails for versions
+// newer than the current one, which this Applikatoni can't read.
+func migrateConfiguration(values interface{}, isApplication bool) ([]string, bool, error) {
+	root, ok := values.(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+
+	var warnings []string
+	version := 1
+	if raw, ok := root["schema_version"]; ok {
+		v, ok := schemaVersion(raw)
+		if !ok || v < 1 {
+			return nil, false, fmt.Errorf("invalid schema_version %v", raw)
+		}
+		version = v
+	} else if !isApplication {
+		warnings = append(warnings, fmt.Sprintf("schema_version is missing, reading the configuration as version 1. Set it to %d after making the changes below", currentSchemaVersion))
+	}
+
+	if version > currentSchemaVersion {
+		return nil, false, fmt.Errorf("schema_version %d is newer than %d, the newest one this version of Applikatoni can read. Update Applikatoni", version, currentSchemaVersion)
+	}
+
+	migrated := false
+	for v := version; v < currentSchemaVersion; v++ {
+		changes := configMigrations[v-1](root, isApplication)
+		for _, change := range changes {
+			warnings = append(warnings, fmt.Sprintf("schema_version %d to %d: %s", v, v+1, change))
+		}
+		migrated = migrated || len(changes) > 0
+	}
+
+	return warnings, migrated, nil
+}
+
+// schemaVersion returns the version decoded from JSON, YAML or TOML as int.
+func schemaVersion(raw interface{}) (int, bool) {
+	switch v := raw.(type) {
+	case float64:
+		return int(v), v == float64(int(v))
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case uint64:
+		return int(v), true
+	}
+	return 0, false
+}
+
+// migrateHostPorts moves the port out of the names of the hosts into their
+// port. Since version 2 the name of a host is only the host name and the port
+// defaults to 22, in version 1 the name had to include the port.
+func migrateHostPorts(values map[string]interface{}, isApplication bool) []string {
+	var changes []string
+
+	applications := []interface{}{values}
+	if !isApplication {
+		applications, _ = values["applications"].([]interface{})
+	}
+
+	for _, a := range applications {
+		application, _ := a.(map[string]interface{})
+		targets, _ := application["targets"].([]interface{})
+		for _, t := range targets {
+			target, _ := t.(map[string]interface{})
+			hosts, _ := target["hosts"].([]interface{})
+			for _, h := range hosts {
+				host, _ := h.(map[string]interface{})
+				name, _ := host["name"].(string)
+				if _, ok := host["port"]; ok || name == "" {
+					continue
+				}
+
+				hostname, port, err := net.SplitHostPort(name)
+				if err != nil {
+					continue
+				}
+				portNumber, err := strconv.Atoi(port)
+				if err != nil {
+					continue
+				}
+
+				host["name"] = hostname
+				host["port"] = portNumber
+				changes = append(changes, fmt.Sprintf(`host %s of target %v of %v is now named %q with "port": %d`, name, target["name"], application["name"], hostname, portNumber))
+			}
+		}
+	}
+
+	return changes
+}
+
+// logConfigurationWarnings logs the changes made to read an older
+// configuration, so they can be made in the file before they're removed.
+func logConfigurationWarnings(c *Configuration) {
+	for _, w := range c.warnings {
+		slog.Warn("configuration warning", "warning", w)
+	}
+}
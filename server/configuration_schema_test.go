@@ -0,0 +1,107 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestParseConfigurationSchemaVersion1(t *testing.T) {
+	content := `{
+  "applications": [{
+    "name": "web",
+    "targets": [{
+      "name": "production",
+      "hosts": [
+        {"name": "1.web.applikatoni.com:22"},
+        {"name": "2.web.applikatoni.com:2222", "port": 22}
+      ]
+    }]
+  }]
+}`
+
+	var c Configuration
+	warnings, err := parseConfiguration([]byte(content), configFormatJSON, &c)
+	checkErr(t, err)
+
+	hosts := c.Applications[0].Targets[0].Hosts
+	if hosts[0].Name != "1.web.applikatoni.com" || hosts[0].Port != 22 {
+		t.Errorf("host port not migrated. got=%+v", hosts[0])
+	}
+	if hosts[1].Name != "2.web.applikatoni.com:2222" || hosts[1].Port != 22 {
+		t.Errorf("host with port changed. got=%+v", hosts[1])
+	}
+
+	if len(warnings) != 2 {
+		t.Fatalf("wrong number of warnings. want=%d, got=%d (%v)", 2, len(warnings), warnings)
+	}
+	if !strings.Contains(warnings[0], "schema_version is missing") {
+		t.Errorf("wrong warning. got=%q", warnings[0])
+	}
+	if !strings.HasPrefix(warnings[1], "schema_version 1 to 2: host 1.web.applikatoni.com:22") {
+		t.Errorf("wrong warning. got=%q", warnings[1])
+	}
+}
+
+func TestParseConfigurationSchemaVersionCurrent(t *testing.T) {
+	content := `
+schema_version: 2
+applications:
+  - name: web
+    targets:
+      - name: production
+        hosts:
+          - name: "1.web.applikatoni.com:22"
+`
+
+	var c Configuration
+	warnings, err := parseConfiguration([]byte(content), configFormatYAML, &c)
+	checkErr(t, err)
+
+	if len(warnings) != 0 {
+		t.Errorf("wrong warnings. want none, got=%v", warnings)
+	}
+	if c.SchemaVersion != 2 {
+		t.Errorf("wrong schema_version. want=%d, got=%d", 2, c.SchemaVersion)
+	}
+	host := c.Applications[0].Targets[0].Hosts[0]
+	if host.Name != "1.web.applikatoni.com:22" || host.Port != 0 {
+		t.Errorf("host changed. got=%+v", host)
+	}
+}
+
+func TestParseApplicationSchemaVersion(t *testing.T) {
+	content := `{"name": "web", "targets": [{"name": "production", "hosts": [{"name": "web:2222"}]}]}`
+
+	var a models.Application
+	warnings, err := parseConfiguration([]byte(content), configFormatJSON, &a)
+	checkErr(t, err)
+
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `now named "web" with "port": 2222`) {
+		t.Errorf("wrong warnings. got=%v", warnings)
+	}
+	if h := a.Targets[0].Hosts[0]; h.Address() != "web:2222" {
+		t.Errorf("wrong address. want=%s, got=%s", "web:2222", h.Address())
+	}
+}
+
+func TestParseConfigurationSchemaVersionErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		err     string
+	}{
+		{`{"schema_version": 3}`, "schema_version 3 is newer than 2"},
+		{`{"schema_version": 0}`, "invalid schema_version 0"},
+		{`{"schema_version": 1.5}`, "invalid schema_version 1.5"},
+		{`{"schema_version": "2"}`, "invalid schema_version 2"},
+	}
+
+	for _, tt := range tests {
+		var c Configuration
+		_, err := parseConfiguration([]byte(tt.content), configFormatJSON, &c)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.content, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func encryptedTestKey(t *testing.T, passphrase string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte(passphrase), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(block))
+}
+
+func TestReadSshKeyPassphrases(t *testing.T) {
+	key := encryptedTestKey(t, "s3cret")
+
+	staging := &models.Target{Name: "staging", DeploymentSshKey: key}
+	production := &models.Target{Name: "production", DeploymentSshKey: key}
+	config := &Configuration{
+		Applications: []*models.Application{
+			{Name: "flincs", Targets: []*models.Target{staging, production}},
+		},
+	}
+
+	prompts := 0
+	prompt := func(msg string) (string, error) {
+		prompts++
+		return "s3cret", nil
+	}
+
+	err := readSshKeyPassphrases(config, prompt)
+	if err != nil {
+		t.Fatalf("reading passphrases failed. err=%s", err)
+	}
+
+	if prompts != 1 {
+		t.Errorf("wrong number of prompts for shared key. want=%d, got=%d", 1, prompts)
+	}
+	for _, target := range []*models.Target{staging, production} {
+		if target.SshKeyPassphrase != "s3cret" {
+			t.Errorf("passphrase not set for %s. want=%q, got=%q", target.Name, "s3cret", target.SshKeyPassphrase)
+		}
+	}
+}
+
+func TestReadSshKeyPassphrasesHosts(t *testing.T) {
+	legacy := &models.Host{Name: "legacy", DeploymentSshKey: encryptedTestKey(t, "l3gacy")}
+	web := &models.Host{Name: "web"}
+	target := &models.Target{Name: "staging", DeploymentSshKey: encryptedTestKey(t, "s3cret"), Hosts: []*models.Host{legacy, web}}
+	config := &Configuration{
+		Applications: []*models.Application{
+			{Name: "flincs", Targets: []*models.Target{target}},
+		},
+	}
+
+	prompts := []string{}
+	prompt := func(msg string) (string, error) {
+		prompts = append(prompts, msg)
+		if strings.Contains(msg, "host legacy") {
+			return "l3gacy", nil
+		}
+		return "s3cret", nil
+	}
+
+	err := readSshKeyPassphrases(config, prompt)
+	if err != nil {
+		t.Fatalf("reading passphrases failed. err=%s", err)
+	}
+
+	if len(prompts) != 2 {
+		t.Errorf("wrong number of prompts. want=%d, got=%d", 2, len(prompts))
+	}
+	if target.SshKeyPassphrase != "s3cret" || legacy.SshKeyPassphrase != "l3gacy" {
+		t.Errorf("wrong passphrases. got=%q, %q", target.SshKeyPassphrase, legacy.SshKeyPassphrase)
+	}
+	if web.SshKeyPassphrase != "" {
+		t.Errorf("passphrase set for host without its own key. got=%q", web.SshKeyPassphrase)
+	}
+}
+
+func TestReadSshKeyPassphrasesWrongPassphrase(t *testing.T) {
+	target := &models.Target{Name: "staging", DeploymentSshKey: encryptedTestKey(t, "s3cret")}
+	config := &Configuration{
+		Applications: []*models.Application{
+			{Name: "flincs", Targets: []*models.Target{target}},
+		},
+	}
+
+	err := readSshKeyPassphrases(config, func(string) (string, error) { return "wrong", nil })
+	if err == nil {
+		t.Errorf("expected error for wrong passphrase")
+	}
+}
+
+func TestConfigurationIsAdmin(t *testing.T) {
+	c := &Configuration{AdminUsernames: []string{"mrnugget", "gitlab:fabrik42"}}
+
+	tests := []struct {
+		user     *models.User
+		expected bool
+	}{
+		{&models.User{Name: "mrnugget", Provider: GITHUB_PROVIDER}, true},
+		{&models.User{Name: "mrnugget", Provider: GITLAB_PROVIDER}, false},
+		{&models.User{Name: "fabrik42", Provider: GITLAB_PROVIDER}, true},
+		{&models.User{Name: "fabrik42", Provider: GITHUB_PROVIDER}, false},
+		{&models.User{Name: "mrnugget", Provider: SERVICE_ACCOUNT_PROVIDER, ServiceAccount: &models.ServiceAccount{Name: "mrnugget"}}, false},
+	}
+
+	for _, tt := range tests {
+		if got := c.IsAdmin(tt.user); got != tt.expected {
+			t.Errorf("wrong result for %s of %s. want=%t, got=%t", tt.user.Name, tt.user.Provider, tt.expected, got)
+		}
+	}
+}
+
+func TestGitHubAPIBaseURL(t *testing.T) {
+	tests := []struct {
+		config      *Configuration
+		expectedWeb string
+		expectedAPI string
+	}{
+		{
+			&Configuration{},
+			"https://github.com",
+			"https://api.github.com",
+		},
+		{
+			&Configuration{GitHubURL: "https://github.example.com/"},
+			"https://github.example.com",
+			"https://github.example.com/api/v3",
+		},
+		{
+			&Configuration{GitHubURL: "https://github.example.com", GitHubAPIURL: "https://api.github.example.com/"},
+			"https://github.example.com",
+			"https://api.github.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.config.GitHubBaseURL(); got != tt.expectedWeb {
+			t.Errorf("wrong base URL. want=%s, got=%s", tt.expectedWeb, got)
+		}
+		if got := tt.config.GitHubAPIBaseURL(); got != tt.expectedAPI {
+			t.Errorf("wrong API URL. want=%s, got=%s", tt.expectedAPI, got)
+		}
+	}
+}
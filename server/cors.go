@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+var defaultCORSAllowedMethods = []string{"GET", "POST"}
+
+// How long browsers may cache the answer to a preflight request, in seconds.
+const corsMaxAge = "600"
+
+// allowCORS lets dashboards on the cors_allowed_origins call the JSON API and
+// GraphQL from the browser. The API authenticates with the X-Api-Token header
+// instead of cookies, so credentials are never allowed. Preflight requests
+// are answered here, since the API routes only match their own methods.
+func allowCORS(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !strings.HasPrefix(r.URL.Path, "/api/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		if !getConfig().IsAllowedOrigin(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+
+		preflight := r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != ""
+		if !preflight {
+			w.Header().Set("Access-Control-Expose-Headers", "Location, RateLimit-Limit, RateLimit-Remaining, RateLimit-Reset, Retry-After")
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(getConfig().CORSMethods(), ", "))
+		w.Header().Set("Access-Control-Allow-Headers", "X-Api-Token, Content-Type")
+		w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
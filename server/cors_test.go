@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAllowCORS(t *testing.T) {
+	setConfig(&Configuration{CORSAllowedOrigins: []string{"https://dashboard.example.com/"}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	h := allowCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		method          string
+		path            string
+		origin          string
+		requestMethod   string
+		expectedStatus  int
+		expectedOrigin  string
+		expectedMethods string
+	}{
+		{"GET", "/api/v1/applications", "https://dashboard.example.com", "", 200, "https://dashboard.example.com", ""},
+		{"OPTIONS", "/api/v1/applications", "https://dashboard.example.com", "POST", 204, "https://dashboard.example.com", "GET, POST"},
+		{"OPTIONS", "/api/graphql", "https://evil.example.com", "POST", 200, "", ""},
+		{"GET", "/web/deployments", "https://dashboard.example.com", "", 200, "", ""},
+		{"GET", "/api/v1/applications", "", "", 200, "", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		if tt.requestMethod != "" {
+			req.Header.Set("Access-Control-Request-Method", tt.requestMethod)
+		}
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s from %q. want=%d, got=%d", tt.method, tt.path, tt.origin, tt.expectedStatus, rec.Code)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.expectedOrigin {
+			t.Errorf("wrong allowed origin for %s %s from %q. want=%q, got=%q", tt.method, tt.path, tt.origin, tt.expectedOrigin, got)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Methods"); got != tt.expectedMethods {
+			t.Errorf("wrong allowed methods for %s %s from %q. want=%q, got=%q", tt.method, tt.path, tt.origin, tt.expectedMethods, got)
+		}
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	tests := []struct {
+		origins []string
+		valid   bool
+	}{
+		{[]string{"*"}, true},
+		{[]string{"https://dashboard.example.com", "http://localhost:3000/"}, true},
+		{[]string{"dashboard.example.com"}, false},
+		{[]string{"https://dashboard.example.com/deployments"}, false},
+	}
+
+	for _, tt := range tests {
+		c := &Configuration{CORSAllowedOrigins: tt.origins, CORSAllowedMethods: []string{"get", "delete"}}
+		err := validateCORS(c)
+		if (err == nil) != tt.valid {
+			t.Errorf("wrong validation of %v. want valid=%t, got err=%v", tt.origins, tt.valid, err)
+		}
+		if tt.valid && c.CORSMethods()[1] != "DELETE" {
+			t.Errorf("methods not normalized. got=%v", c.CORSMethods())
+		}
+	}
+}
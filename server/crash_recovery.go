@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// newHostStageTracker saves the progress of the stages on every host from the
+// log entries, so it's known how far every host got if the server crashes in
+// the middle of a deployment.
+func newHostStageTracker(db *sql.DB) deploy.Listener {
+	fn := func(logs <-chan deploy.LogEntry) {
+		// The stage every deployment is running
+		stages := map[int]models.DeploymentStage{}
+
+		for entry := range logs {
+			err := trackHostStage(db, stages, entry)
+			if err != nil {
+				slog.Error("error saving host stage", "deployment.id", entry.DeploymentId, "err", err)
+			}
+		}
+	}
+
+	return fn
+}
+
+func trackHostStage(db *sql.DB, stages map[int]models.DeploymentStage, e deploy.LogEntry) error {
+	stage, inStage := stages[e.DeploymentId]
+
+	switch e.EntryType {
+	case deploy.STAGE_START:
+		stages[e.DeploymentId] = models.DeploymentStage(e.Message)
+	case deploy.STAGE_SUCCESS:
+		delete(stages, e.DeploymentId)
+		return finishHostStages(db, e.DeploymentId, models.DeploymentStage(e.Message), models.HOST_STAGE_SUCCESSFUL)
+	case deploy.STAGE_FAIL:
+		// Like in the progress matrix, the hosts still running the stage
+		// failed with it
+		delete(stages, e.DeploymentId)
+		return finishHostStages(db, e.DeploymentId, models.DeploymentStage(e.Message), models.HOST_STAGE_FAILED)
+	case deploy.DEPLOYMENT_SUCCESS, deploy.DEPLOYMENT_FAIL:
+		delete(stages, e.DeploymentId)
+	case deploy.COMMAND_START:
+		// Commands of the deployment hooks run outside of the stages
+		if inStage {
+			return startHostStage(db, e.DeploymentId, e.Origin, stage, e.Message)
+		}
+	case deploy.COMMAND_SUCCESS:
+		if inStage {
+			return finishHostStageCommand(db, e.DeploymentId, e.Origin, stage)
+		}
+	case deploy.COMMAND_FAIL:
+		if inStage {
+			return failHostStage(db, e.DeploymentId, e.Origin, stage)
+		}
+	}
+
+	return nil
+}
+
+// recoverUnfinishedDeployments fails the deployments left unfinished by a
+// crash or a restart of the server, so other deployments can be started. The
+// log of the active ones reports how far every host got before they're
+// failed.
+func recoverUnfinishedDeployments(db *sql.DB) error {
+	deployments, err := getAllActiveDeployments(db)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range deployments {
+		err := reportInterruptedDeployment(db, d)
+		if err != nil {
+			return err
+		}
+	}
+
+	return failUnfinishedDeployments(db)
+}
+
+// reportInterruptedDeployment adds the results of the interrupted stage on
+// every host and the failure of the deployment to its log, like a failed
+// stage does, and marks the host stages as interrupted.
+func reportInterruptedDeployment(db *sql.DB, d *models.Deployment) error {
+	entries, err := getDeploymentStageEntries(db, d)
+	if err != nil {
+		return err
+	}
+	completed, running := stageProgress(entries)
+
+	hostStages, err := getHostStages(db, d.Id)
+	if err != nil {
+		return err
+	}
+
+	report := []*deploy.LogEntry{}
+	now := time.Now()
+	logEntry := func(entryType deploy.LogEntryType, msg string) {
+		report = append(report, &deploy.LogEntry{
+			DeploymentId: d.Id,
+			Origin:       "applikatoni",
+			EntryType:    entryType,
+			Message:      msg,
+			Timestamp:    now,
+		})
+	}
+
+	reason := "Interrupted by a restart of Applikatoni"
+	if running != "" {
+		for _, hs := range hostStages {
+			if hs.Stage == running && hs.State == models.HOST_STAGE_RUNNING {
+				logEntry(deploy.STAGE_RESULT, fmtInterruptedHostStage(hs))
+			}
+		}
+		logEntry(deploy.STAGE_FAIL, string(running))
+		reason += fmt.Sprintf(" in stage %s", running)
+	}
+	logEntry(deploy.DEPLOYMENT_FAIL, fmt.Sprintf("deployment_id=%d, err=%s", d.Id, reason))
+
+	for _, e := range report {
+		err := createLogEntry(db, e)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = interruptHostStages(db, d.Id)
+	if err != nil {
+		return err
+	}
+
+	deploymentLogger(d).Warn("deployment interrupted by a restart", "stage", running, "completed_stages", len(completed))
+	return nil
+}
+
+func fmtInterruptedHostStage(hs *models.HostStage) string {
+	if hs.LastCommandDone {
+		return fmt.Sprintf("%s - execution of stage %s interrupted after %q succeeded", hs.Host, hs.Stage, hs.LastCommand)
+	}
+	return fmt.Sprintf("%s - execution of stage %s interrupted while running %q", hs.Host, hs.Stage, hs.LastCommand)
+}
+
+// stageProgress returns the stages that succeeded and the stage that was
+// started last but didn't finish, if any, from the stage log entries of a
+// deployment.
+func stageProgress(entries []*deploy.LogEntry) ([]models.DeploymentStage, models.DeploymentStage) {
+	completed := []models.DeploymentStage{}
+	var running models.DeploymentStage
+
+	for _, e := range entries {
+		switch e.EntryType {
+		case deploy.STAGE_START:
+			running = models.DeploymentStage(e.Message)
+		case deploy.STAGE_SUCCESS:
+			completed = append(completed, models.DeploymentStage(e.Message))
+			running = ""
+		case deploy.STAGE_FAIL:
+			running = ""
+		}
+	}
+
+	return completed, running
+}
+
+// resumeStage returns the first stage of the failed deployment that didn't
+// succeed, which it can be resumed from. It's empty if no stage succeeded,
+// the deployment can only be redeployed then.
+func resumeStage(d *models.Deployment, completed []models.DeploymentStage) models.DeploymentStage {
+	if len(completed) == 0 {
+		return ""
+	}
+
+	done := map[models.DeploymentStage]bool{}
+	for _, s := range completed {
+		done[s] = true
+	}
+	for _, s := range d.Stages {
+		if !done[s] {
+			return s
+		}
+	}
+	return ""
+}
+
+// stagesFrom returns the stages starting with from, all of them if from isn't
+// one of them.
+func stagesFrom(stages []models.DeploymentStage, from models.DeploymentStage) []models.DeploymentStage {
+	for i, s := range stages {
+		if s == from {
+			return stages[i:]
+		}
+	}
+	return stages
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestHostStageTracker(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	entry := func(origin string, entryType deploy.LogEntryType, msg string) deploy.LogEntry {
+		return deploy.LogEntry{DeploymentId: 1, Origin: origin, EntryType: entryType, Message: msg, Timestamp: time.Now()}
+	}
+
+	logs := make(chan deploy.LogEntry)
+	done := make(chan struct{})
+	go func() {
+		newHostStageTracker(db)(logs)
+		close(done)
+	}()
+
+	for _, e := range []deploy.LogEntry{
+		// Commands of the hooks are ignored
+		entry("localhost", deploy.COMMAND_START, "./notify.sh"),
+		entry("applikatoni", deploy.STAGE_START, "CHECKOUT"),
+		entry("web-1", deploy.COMMAND_START, "git fetch"),
+		entry("web-2", deploy.COMMAND_START, "git fetch"),
+		entry("web-1", deploy.COMMAND_SUCCESS, `"git fetch"`),
+		entry("web-2", deploy.COMMAND_SUCCESS, `"git fetch"`),
+		entry("applikatoni", deploy.STAGE_SUCCESS, "CHECKOUT"),
+		entry("applikatoni", deploy.STAGE_START, "MIGRATE"),
+		entry("web-1", deploy.COMMAND_START, "rake db:migrate"),
+		entry("web-2", deploy.COMMAND_START, "rake db:migrate"),
+		entry("web-2", deploy.COMMAND_SUCCESS, `"rake db:migrate"`),
+		entry("web-2", deploy.COMMAND_START, "rake cache:clear"),
+		entry("web-2", deploy.COMMAND_FAIL, `cmd="rake cache:clear", error="exit status 1"`),
+	} {
+		logs <- e
+	}
+	close(logs)
+	<-done
+
+	hostStages, err := getHostStages(db, 1)
+	checkErr(t, err)
+
+	expected := []models.HostStage{
+		{Host: "web-1", Stage: "CHECKOUT", State: models.HOST_STAGE_SUCCESSFUL, LastCommand: "git fetch", LastCommandDone: true},
+		{Host: "web-2", Stage: "CHECKOUT", State: models.HOST_STAGE_SUCCESSFUL, LastCommand: "git fetch", LastCommandDone: true},
+		{Host: "web-1", Stage: "MIGRATE", State: models.HOST_STAGE_RUNNING, LastCommand: "rake db:migrate", LastCommandDone: false},
+		{Host: "web-2", Stage: "MIGRATE", State: models.HOST_STAGE_FAILED, LastCommand: "rake cache:clear", LastCommandDone: false},
+	}
+	if len(hostStages) != len(expected) {
+		t.Fatalf("wrong number of host stages. want=%d, got=%d", len(expected), len(hostStages))
+	}
+	for i, hs := range hostStages {
+		e := expected[i]
+		if hs.Host != e.Host || hs.Stage != e.Stage || hs.State != e.State || hs.LastCommand != e.LastCommand || hs.LastCommandDone != e.LastCommandDone {
+			t.Errorf("wrong host stage %d. want=%+v, got=%+v", i, e, hs)
+		}
+	}
+}
+
+func TestRecoverUnfinishedDeployments(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+
+	interrupted := buildDeployment(user.Id)
+	interrupted.Stages = []models.DeploymentStage{"CHECKOUT", "MIGRATE", "KICK_OFF"}
+	checkErr(t, createDeployment(db, interrupted))
+	checkErr(t, updateDeploymentState(db, interrupted, models.DEPLOYMENT_ACTIVE))
+
+	// Queued behind the interrupted deployment
+	queued := buildDeployment(user.Id)
+	checkErr(t, createOrQueueDeployment(db, queued))
+	if queued.State != models.DEPLOYMENT_QUEUED {
+		t.Fatalf("deployment not queued. got=%s", queued.State)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	for i, e := range []*deploy.LogEntry{
+		{EntryType: deploy.STAGE_START, Message: "CHECKOUT"},
+		{EntryType: deploy.STAGE_SUCCESS, Message: "CHECKOUT"},
+		{EntryType: deploy.STAGE_START, Message: "MIGRATE"},
+	} {
+		e.DeploymentId = interrupted.Id
+		e.Origin = "applikatoni"
+		e.Timestamp = start.Add(time.Duration(i) * time.Second)
+		checkErr(t, createLogEntry(db, e))
+	}
+	checkErr(t, startHostStage(db, interrupted.Id, "web-1", "MIGRATE", "rake db:migrate"))
+	checkErr(t, startHostStage(db, interrupted.Id, "web-2", "MIGRATE", "rake db:migrate"))
+	checkErr(t, finishHostStageCommand(db, interrupted.Id, "web-2", "MIGRATE"))
+
+	checkErr(t, recoverUnfinishedDeployments(db))
+
+	for _, d := range []*models.Deployment{interrupted, queued} {
+		saved, err := getDeployment(db, d.Id)
+		checkErr(t, err)
+		if saved.State != models.DEPLOYMENT_FAILED {
+			t.Errorf("wrong state of deployment %d. want=%s, got=%s", d.Id, models.DEPLOYMENT_FAILED, saved.State)
+		}
+	}
+
+	entries, err := getDeploymentLogEntries(db, interrupted)
+	checkErr(t, err)
+
+	messages := []string{}
+	for _, e := range entries[3:] {
+		messages = append(messages, string(e.EntryType)+": "+e.Message)
+	}
+	expected := []string{
+		`STAGE_RESULT: web-1 - execution of stage MIGRATE interrupted while running "rake db:migrate"`,
+		`STAGE_RESULT: web-2 - execution of stage MIGRATE interrupted after "rake db:migrate" succeeded`,
+		`STAGE_FAIL: MIGRATE`,
+		`DEPLOYMENT_FAIL: deployment_id=` + strconv.Itoa(interrupted.Id) + `, err=Interrupted by a restart of Applikatoni in stage MIGRATE`,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("wrong log entries.\nwant=%s\ngot=%s", strings.Join(expected, "\n"), strings.Join(messages, "\n"))
+	}
+
+	hostStages, err := getHostStages(db, interrupted.Id)
+	checkErr(t, err)
+	for _, hs := range hostStages {
+		if hs.State != models.HOST_STAGE_INTERRUPTED {
+			t.Errorf("host stage of %s not interrupted. got=%s", hs.Host, hs.State)
+		}
+	}
+
+	completed, running := stageProgress(entries)
+	if resume := resumeStage(interrupted, completed); resume != "MIGRATE" || running != "" {
+		t.Errorf("wrong stage to resume from. want=%s, got=%s", "MIGRATE", resume)
+	}
+}
+
+func TestResumeStage(t *testing.T) {
+	d := &models.Deployment{Stages: []models.DeploymentStage{"CHECKOUT", "MIGRATE", "KICK_OFF"}}
+
+	tests := []struct {
+		completed []models.DeploymentStage
+		expected  models.DeploymentStage
+	}{
+		{[]models.DeploymentStage{}, ""},
+		{[]models.DeploymentStage{"CHECKOUT"}, "MIGRATE"},
+		{[]models.DeploymentStage{"CHECKOUT", "MIGRATE"}, "KICK_OFF"},
+		{[]models.DeploymentStage{"CHECKOUT", "MIGRATE", "KICK_OFF"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := resumeStage(d, tt.completed); got != tt.expected {
+			t.Errorf("wrong stage to resume from after %v. want=%q, got=%q", tt.completed, tt.expected, got)
+		}
+	}
+
+	if got := stagesFrom(d.Stages, "MIGRATE"); !reflect.DeepEqual(got, d.Stages[1:]) {
+		t.Errorf("wrong stages from MIGRATE. got=%v", got)
+	}
+	if got := stagesFrom(d.Stages, "UNKNOWN"); !reflect.DeepEqual(got, d.Stages) {
+		t.Errorf("wrong stages from unknown stage. got=%v", got)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	// The form field and the header the CSRF token is sent in. The header is
+	// set by applikatoni.js on its POST requests.
+	csrfFormField = "csrf_token"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+// csrfToken returns the CSRF token of the session, which the forms send
+// along, and creates it if the session has none yet.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	session, _ := sessionStore.Get(r, sessionName)
+	token, _ := session.Values["csrf_token"].(string)
+	if token == "" {
+		token = uuid.New()
+		session.Values["csrf_token"] = token
+		session.Save(r, w)
+	}
+	return token
+}
+
+// validCSRFToken checks that a request changing something sent the CSRF
+// token of its session, so other sites can't send forms in the name of a
+// logged in user. Reading requests don't need one.
+func validCSRFToken(r *http.Request) bool {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+
+	session, _ := sessionStore.Get(r, sessionName)
+	expected, _ := session.Values["csrf_token"].(string)
+	if expected == "" {
+		return false
+	}
+
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		token = r.PostFormValue(csrfFormField)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestValidCSRFToken(t *testing.T) {
+	sessionStore = sessions.NewCookieStore([]byte("secret"))
+	defer func() { sessionStore = nil }()
+
+	// The token is created with the first rendered page
+	rec := httptest.NewRecorder()
+	token := csrfToken(rec, httptest.NewRequest("GET", "/", nil))
+	cookies := rec.Result().Cookies()
+	if token == "" || len(cookies) != 1 {
+		t.Fatalf("no token saved in session. got=%q, cookies=%v", token, cookies)
+	}
+
+	newRequest := func(method string, form url.Values, header string, withSession bool) *http.Request {
+		req := httptest.NewRequest(method, "/web/deployments", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if header != "" {
+			req.Header.Set("X-CSRF-Token", header)
+		}
+		if withSession {
+			req.AddCookie(cookies[0])
+		}
+		return req
+	}
+
+	tests := []struct {
+		req   *http.Request
+		valid bool
+	}{
+		{newRequest("GET", nil, "", true), true},
+		{newRequest("POST", url.Values{"csrf_token": {token}}, "", true), true},
+		{newRequest("POST", nil, token, true), true},
+		{newRequest("POST", nil, "", true), false},
+		{newRequest("POST", url.Values{"csrf_token": {"guessed"}}, "", true), false},
+		// A token of another session
+		{newRequest("POST", url.Values{"csrf_token": {token}}, "", false), false},
+	}
+
+	for i, tt := range tests {
+		if got := validCSRFToken(tt.req); got != tt.valid {
+			t.Errorf("%d: wrong validation of %s request. want=%v, got=%v", i, tt.req.Method, tt.valid, got)
+		}
+	}
+
+	// The token stays the same for the session
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookies[0])
+	if again := csrfToken(httptest.NewRecorder(), req); again != token {
+		t.Errorf("token changed. want=%q, got=%q", token, again)
+	}
+}
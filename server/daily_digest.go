@@ -4,10 +4,7 @@ import (
 	"bytes"
 	"database/sql"
 	"fmt"
-	htmltemplate "html/template"
-	"log"
-	"path/filepath"
-	"text/template"
+	"log/slog"
 	"time"
 
 	"github.com/applikatoni/applikatoni/models"
@@ -15,32 +12,37 @@ import (
 
 const (
 	digestSleepTime            = 1 * time.Minute
-	digestHourOfDay            = 22
-	digestInterval             = 24 * time.Hour
 	digestTimezone             = "Europe/Berlin"
-	digestSubjectFmt           = " 🍕 Applikatoni Daily Digest - %s"
+	digestSubjectFmt           = " 🍕 Applikatoni %s Digest - %s"
 	digestFromName             = "Applikatoni"
 	digestFromEmail            = "no-reply@applikatoni.com"
-	digestHtmlTemplateDir      = "./assets/templates/"
 	digestHtmlTemplateFilename = "daily_digest.tmpl"
 	digestTextTemplate         = `Hello there!
 
-Check out what the team behind {{.Application.Name}} deployed in the last 24 hours:
+Check out what the team behind {{.Application.Name}} deployed in the {{.Schedule.Period}}:
 
 {{ range .Deployments }}
-{{.CreatedAt.Format "02.01.2006 15:04 (MST)"}} -- {{.User.Name}} deployed to {{.TargetName}} with the following message:
+{{.CreatedAt.Format "02.01.2006 15:04 (MST)"}} -- {{.User.DisplayName}} {{if eq .State "failed"}}failed to deploy{{else}}deployed{{end}} to {{.TargetName}} with the following message:
     {{.Comment}}
 {{ end}}
-
+{{ with .Stats }}
+In numbers: {{.Deployments}} deployments ({{.DeploymentsChange}} compared to the period before), {{.Current.Failed}} failed ({{printf "%.1f" .Current.ChangeFailureRate}}%)
+Average duration: {{fmtDuration .Current.AverageDuration}}{{with .DurationChange}} ({{.}} compared to the period before){{end}}
+{{ end }}
 Always at your service:
-your Applikatoni Daily Digest Team
+your Applikatoni {{.Schedule.Title}} Digest Team
 
 Applikatoni - Deployments Al Forno
+{{- with .UnsubscribeURL }}
+
+Unsubscribe from these digests: {{.}}
+{{- end }}
 `
 )
 
 var (
-	nextDailyDigest time.Time
+	// When the next digest of every target is sent, by digestKey
+	nextDigests = map[string]time.Time{}
 )
 
 type DailyDigest struct {
@@ -56,157 +58,278 @@ type DailyDigestSender interface {
 	SendDigest(*DailyDigest) error
 }
 
+// SendDailyDigests sends the digest of every target on its schedule, daily or
+// weekly, by email if a sender is configured and to Slack.
 func SendDailyDigests(db *sql.DB, sender DailyDigestSender) {
-	nextDailyDigest = calcInitialDailyDigest(digestHourOfDay)
+	slack := NewSlackDigestClient(slackAPIBaseURL, getConfig().SlackBotToken)
 
 	for {
-		now := time.Now()
+		sendDueDigests(db, sender, slack, time.Now())
+		time.Sleep(digestSleepTime)
+	}
+}
 
-		if now.After(nextDailyDigest) {
-			log.Println("Sending daily digests...")
+// sendDueDigests sends the digests of the targets whose time has come and
+// schedules the next ones. Targets seen for the first time, e.g. after their
+// schedule changed, are only scheduled.
+func sendDueDigests(db *sql.DB, sender DailyDigestSender, slack *SlackDigestClient, now time.Time) {
+	for _, a := range getConfig().Applications {
+		for _, t := range a.Targets {
+			schedule, err := a.DigestSchedule(t)
+			if err != nil || schedule.Frequency == models.DIGEST_DISABLED {
+				continue
+			}
 
-			for _, app := range config.Applications {
-				err := sendApplicationDigest(db, sender, app)
-				if err != nil {
-					log.Printf("Sending digest for application %s failed: %s", app.Name, err)
-				}
+			key := digestKey(a, t, schedule)
+			next, ok := nextDigests[key]
+			if ok && now.Before(next) {
+				continue
+			}
+			nextDigests[key] = schedule.Next(now)
+			if !ok {
+				continue
 			}
 
-			nextDailyDigest = nextDailyDigest.Add(digestInterval)
+			err = sendTargetDigest(db, sender, slack, a, t, schedule, schedule.Since(next), now)
+			if err != nil {
+				slog.Error("sending digest failed", "application", a.Name, "target", t.Name, "err", err)
+			}
 		}
+	}
+}
 
-		time.Sleep(digestSleepTime)
+func digestKey(a *models.Application, t *models.Target, s models.DigestSchedule) string {
+	return fmt.Sprintf("%s/%s/%s", a.Name, t.Name, s)
+}
+
+// digestContent is what the digest of a target reports.
+type digestContent struct {
+	Application *models.Application
+	Target      *models.Target
+	Schedule    models.DigestSchedule
+	// The successful and failed deployments in the period of the digest,
+	// oldest first
+	Deployments []*models.Deployment
+	Stats       *digestStats
+}
+
+// digestStats are the numbers of the deployments in the period of a digest,
+// compared to the period before.
+type digestStats struct {
+	Current  *deploymentMetrics
+	Previous *deploymentMetrics
+}
+
+func newDigestStats(t *models.Target, deployments, previous []*models.Deployment) *digestStats {
+	a := &models.Application{Targets: []*models.Target{t}}
+	_, current := computeDeploymentMetrics(a, 0, deployments)
+	_, before := computeDeploymentMetrics(a, 0, previous)
+	return &digestStats{Current: current, Previous: before}
+}
+
+// Deployments is the number of finished deployments in the period.
+func (s *digestStats) Deployments() int {
+	return s.Current.Successful + s.Current.Failed
+}
+
+// DeploymentsChange is the difference to the number of deployments in the
+// period before, e.g. "+3" or "-1".
+func (s *digestStats) DeploymentsChange() string {
+	return fmt.Sprintf("%+d", s.Deployments()-(s.Previous.Successful+s.Previous.Failed))
+}
+
+// DurationChange is the difference to the average duration of the period
+// before, e.g. "+12s". It's empty if one of the periods has no timed
+// deployments.
+func (s *digestStats) DurationChange() string {
+	if s.Current.Timed == 0 || s.Previous.Timed == 0 {
+		return ""
+	}
+
+	change := s.Current.AverageDuration() - s.Previous.AverageDuration()
+	if change < 0 {
+		return "-" + fmtDuration(-change)
 	}
+	return "+" + fmtDuration(change)
 }
 
-func sendApplicationDigest(db *sql.DB, sender DailyDigestSender, a *models.Application) error {
-	targetName := a.DailyDigestTarget
+// sendTargetDigest sends the digest of the deployments to the target between
+// since and until by email to the daily_digest_receivers and the subscribed
+// users, if there is a sender, and to the Slack destinations of the target.
+func sendTargetDigest(db *sql.DB, sender DailyDigestSender, slack *SlackDigestClient, a *models.Application, t *models.Target, schedule models.DigestSchedule, since, until time.Time) error {
+	config := getConfig()
 	receivers := a.DailyDigestReceivers
-	since := time.Now().Add(-1 * digestInterval)
-
-	if len(receivers) == 0 || targetName == "" {
+	var subscriptions []*models.DigestSubscription
+	if sender == nil {
+		receivers = nil
+	} else {
+		var err error
+		subscriptions, err = getDigestSubscribers(db, a)
+		if err != nil {
+			return err
+		}
+	}
+	if len(receivers) == 0 && len(subscriptions) == 0 && !t.HasSlackDigest() {
 		return nil
 	}
 
-	deployments, err := getDailyDigestDeployments(db, a, targetName, since)
+	deployments, err := getDailyDigestDeployments(db, a, t.Name, since, until)
 	if err != nil {
 		return err
 	}
 
 	if len(deployments) == 0 {
-		log.Printf("Skipping daily digest for %s -- no deployments\n", a.Name)
+		slog.Info("skipping digest, no deployments", "application", a.Name, "target", t.Name)
 		return nil
 	}
 
-	log.Printf("Sending daily digest for %s\n", a.Name)
+	slog.Info("sending digest", "application", a.Name, "target", t.Name, "schedule", schedule.String())
 
-	err = localizeTimestamps(deployments)
+	previous, err := getDailyDigestDeployments(db, a, t.Name, schedule.Since(since), since)
 	if err != nil {
 		return err
 	}
 
-	err = loadDeploymentsUsers(db, deployments)
+	err = localizeTimestamps(deployments, schedule)
 	if err != nil {
 		return err
 	}
 
-	digest, err := NewDigest(receivers, a, deployments)
+	err = loadDeploymentsUsers(db, deployments)
 	if err != nil {
-		log.Printf("generating digest for %s failed: %s\n", a.Name, err)
 		return err
 	}
 
-	err = sender.SendDigest(digest)
+	content := &digestContent{
+		Application: a,
+		Target:      t,
+		Schedule:    schedule,
+		Deployments: deployments,
+		Stats:       newDigestStats(t, deployments, previous),
+	}
+
+	if t.HasSlackDigest() {
+		err = sendSlackDigest(slack, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(receivers) == 0 && len(subscriptions) == 0 {
+		return nil
+	}
+
+	tmpls, err := loadDigestTemplates(config.dir, a)
 	if err != nil {
-		log.Printf("sending digest for %s to %s failed: %s\n", a.Name, receivers, err)
+		slog.Error("loading digest templates failed", "application", a.Name, "err", err)
 		return err
 	}
 
-	log.Printf("successfully sent daily digest for %s to %s\n", a.Name, receivers)
+	if len(receivers) > 0 {
+		err = sendDigestEmail(sender, tmpls, receivers, "", content)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Every subscriber gets an own email with the link to unsubscribe
+	for _, s := range subscriptions {
+		unsubscribeURL := config.URL(digestUnsubscribePath(s))
+		err = sendDigestEmail(sender, tmpls, []string{s.Email}, unsubscribeURL, content)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func NewDigest(receivers []string, a *models.Application, deployments []*models.Deployment) (*DailyDigest, error) {
-	textBody, err := generateDigestTextBody(a, deployments)
+func sendDigestEmail(sender DailyDigestSender, tmpls *digestTemplates, receivers []string, unsubscribeURL string, c *digestContent) error {
+	digest, err := NewDigest(tmpls, receivers, unsubscribeURL, c)
 	if err != nil {
-		return nil, err
+		slog.Error("generating digest failed", "application", c.Application.Name, "err", err)
+		return err
 	}
 
-	htmlBody, err := generateDigestHtmlBody(a, deployments)
+	err = sender.SendDigest(digest)
 	if err != nil {
-		return nil, err
-	}
-
-	digest := &DailyDigest{
-		FromName:  digestFromName,
-		FromEmail: digestFromEmail,
-		Receivers: receivers,
-		TextBody:  textBody,
-		HtmlBody:  htmlBody,
-		Subject:   fmt.Sprintf(digestSubjectFmt, a.Name),
+		slog.Error("sending digest failed", "application", c.Application.Name, "receivers", receivers, "err", err)
+		return err
 	}
-	return digest, nil
-}
 
-func calcInitialDailyDigest(hourOfDay int) time.Time {
-	year, month, day := time.Now().Date()
-	return time.Date(year, month, day, hourOfDay, 0, 0, 0, time.Local)
+	slog.Info("sent digest", "application", c.Application.Name, "target", c.Target.Name, "receivers", receivers)
+	return nil
 }
 
-func localizeTimestamps(deployments []*models.Deployment) error {
-	timezone, err := time.LoadLocation(digestTimezone)
+func sendSlackDigest(slack *SlackDigestClient, c *digestContent) error {
+	text, err := generateSlackDigest(c)
 	if err != nil {
+		slog.Error("generating Slack digest failed", "application", c.Application.Name, "err", err)
 		return err
 	}
 
-	for _, d := range deployments {
-		d.CreatedAt = d.CreatedAt.In(timezone)
+	err = slack.SendDigest(c.Target, text)
+	if err != nil {
+		slog.Error("sending Slack digest failed", "application", c.Application.Name, "target", c.Target.Name, "err", err)
+		return err
 	}
 
+	slog.Info("sent Slack digest", "application", c.Application.Name, "target", c.Target.Name)
 	return nil
 }
 
-func generateDigestTextBody(a *models.Application, deployments []*models.Deployment) (bytes.Buffer, error) {
-	var digestTextBody bytes.Buffer
+// NewDigest renders the digest email to the receivers. unsubscribeURL is the
+// link to unsubscribe from the digests, if the receiver subscribed.
+func NewDigest(tmpls *digestTemplates, receivers []string, unsubscribeURL string, c *digestContent) (*DailyDigest, error) {
+	vars := digestVars(c)
+	vars["UnsubscribeURL"] = unsubscribeURL
 
-	tmpl, err := template.New("digestTextBody").Parse(digestTextTemplate)
-	if err != nil {
-		return digestTextBody, err
+	var textBody bytes.Buffer
+	if err := tmpls.executeText(&textBody, vars); err != nil {
+		return nil, err
 	}
 
-	vars := map[string]interface{}{
-		"Application": a,
-		"Deployments": deployments,
+	var htmlBody bytes.Buffer
+	if err := tmpls.executeHtml(&htmlBody, vars); err != nil {
+		return nil, err
 	}
 
-	if err = tmpl.Execute(&digestTextBody, vars); err != nil {
-		return digestTextBody, err
+	digest := &DailyDigest{
+		FromName:  digestFromName,
+		FromEmail: digestFromEmail,
+		Receivers: receivers,
+		TextBody:  textBody,
+		HtmlBody:  htmlBody,
+		Subject:   fmt.Sprintf(digestSubjectFmt, c.Schedule.Title(), c.Application.Name),
 	}
-
-	return digestTextBody, nil
+	return digest, nil
 }
 
-func generateDigestHtmlBody(a *models.Application, deployments []*models.Deployment) (bytes.Buffer, error) {
-	var digestHtmlBody bytes.Buffer
-	path := filepath.Join(digestHtmlTemplateDir, digestHtmlTemplateFilename)
-
-	tmpl := htmltemplate.New("")
-	tmpl.Funcs(htmltemplate.FuncMap{"newlineToBreak": newlineToBreak})
-
-	tmpl, err := tmpl.ParseFiles(path)
-	if err != nil {
-		return digestHtmlBody, err
+// digestVars are the variables the digest templates are rendered with.
+func digestVars(c *digestContent) map[string]interface{} {
+	return map[string]interface{}{
+		"Application": c.Application,
+		"Target":      c.Target,
+		"Schedule":    c.Schedule,
+		"Deployments": c.Deployments,
+		"Stats":       c.Stats,
 	}
+}
 
-	vars := map[string]interface{}{
-		"Application": a,
-		"Deployments": deployments,
+// localizeTimestamps shows the deployments in the time zone of the digest.
+func localizeTimestamps(deployments []*models.Deployment, schedule models.DigestSchedule) error {
+	timezone := schedule.Location
+	if timezone == nil {
+		var err error
+		timezone, err = time.LoadLocation(digestTimezone)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = tmpl.ExecuteTemplate(&digestHtmlBody, digestHtmlTemplateFilename, vars)
-	if err != nil {
-		return digestHtmlBody, err
+	for _, d := range deployments {
+		d.CreatedAt = d.CreatedAt.In(timezone)
 	}
 
-	return digestHtmlBody, nil
+	return nil
 }
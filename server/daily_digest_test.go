@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+type testDigestSender struct {
+	digests []*DailyDigest
+}
+
+func (s *testDigestSender) SendDigest(d *DailyDigest) error {
+	s.digests = append(s.digests, d)
+	return nil
+}
+
+func TestSendDueDigests(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+	templatesFS = os.DirFS("assets/templates")
+	defer func() { templatesFS = nil }()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+
+	var slackMessages []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg slackMsg
+		checkErr(t, json.NewDecoder(r.Body).Decode(&msg))
+		slackMessages = append(slackMessages, msg.Text)
+	}))
+	defer ts.Close()
+
+	setConfig(&Configuration{Applications: []*models.Application{
+		{
+			Name:                 "web",
+			DailyDigestReceivers: []string{"team@shipping-company.com"},
+			DailyDigestTarget:    "production",
+			Targets: []*models.Target{
+				{Name: "production"},
+				{Name: "staging", DigestSchedule: "weekly", DigestWeekday: "fri", DigestSlackUrl: ts.URL + "/webhook"},
+				{Name: "test", DigestSchedule: "disabled"},
+			},
+		},
+	}})
+	defer func() {
+		setConfig(&Configuration{})
+		nextDigests = map[string]time.Time{}
+	}()
+
+	// Wednesday and Friday
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local)
+	friday := time.Date(2026, 10, 16, 22, 1, 0, 0, time.Local)
+	for _, target := range []string{"production", "staging", "test"} {
+		// Only the weekly digest covers the deployment on Monday
+		for _, createdAt := range []time.Time{friday.Add(-2 * time.Hour), friday.AddDate(0, 0, -4)} {
+			_, err := db.Exec(`INSERT INTO deployments
+			(user_id, application_name, target_name, commit_sha, branch, comment, state, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?);`, user.Id, "web", target, "f00b4r", "master", "foo",
+				string(models.DEPLOYMENT_SUCCESSFUL), createdAt)
+			checkErr(t, err)
+		}
+	}
+	_, err := db.Exec(`INSERT INTO deployments
+	(user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, updated_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`, user.Id, "web", "production", "f00b4r", "master", "broken",
+		string(models.DEPLOYMENT_FAILED), friday.Add(-time.Hour), friday.Add(-time.Hour+90*time.Second))
+	checkErr(t, err)
+
+	sender := &testDigestSender{}
+	slack := NewSlackDigestClient(ts.URL, "")
+	// Only schedules the digests
+	sendDueDigests(db, sender, slack, now)
+	if len(sender.digests) != 0 {
+		t.Fatalf("digests sent before they're due. got=%d", len(sender.digests))
+	}
+	if next := nextDigests["web/staging/weekly on fri at 22:00 Local"]; !next.Equal(time.Date(2026, 10, 16, 22, 0, 0, 0, time.Local)) {
+		t.Errorf("wrong next weekly digest. got=%s", next)
+	}
+	if _, ok := nextDigests["web/test/disabled"]; ok {
+		t.Errorf("disabled digest scheduled")
+	}
+
+	sendDueDigests(db, sender, slack, friday)
+	if len(sender.digests) != 2 {
+		t.Fatalf("wrong number of digests sent. want=2, got=%d", len(sender.digests))
+	}
+
+	daily, weekly := sender.digests[0], sender.digests[1]
+	if !strings.Contains(daily.Subject, "Daily Digest - web") || !strings.Contains(weekly.Subject, "Weekly Digest - web") {
+		t.Fatalf("wrong subjects. got=%q, %q", daily.Subject, weekly.Subject)
+	}
+	if n := strings.Count(daily.TextBody.String(), " deployed to production"); n != 1 {
+		t.Errorf("wrong number of deployments in daily digest. want=1, got=%d", n)
+	}
+	expected := []string{
+		"failed to deploy to production",
+		"2 deployments (+1 compared to the period before), 1 failed (50.0%)",
+		"Average duration: 1m30s",
+	}
+	for _, s := range expected {
+		if !strings.Contains(daily.TextBody.String(), s) {
+			t.Errorf("daily digest doesn't contain %q. got=%s", s, daily.TextBody.String())
+		}
+	}
+	if !strings.Contains(daily.HtmlBody.String(), "failed to deploy") {
+		t.Errorf("failed deployment missing in html body. got=%s", daily.HtmlBody.String())
+	}
+	if !strings.Contains(weekly.TextBody.String(), "in the last 7 days") {
+		t.Errorf("wrong text body. got=%s", weekly.TextBody.String())
+	}
+	if n := strings.Count(weekly.TextBody.String(), "deployed to staging"); n != 2 {
+		t.Errorf("wrong number of deployments in weekly digest. want=2, got=%d", n)
+	}
+	if len(slackMessages) != 1 || strings.Count(slackMessages[0], "mrnugget: foo") != 2 {
+		t.Errorf("wrong Slack digest. got=%q", slackMessages)
+	}
+	if next := nextDigests["web/staging/weekly on fri at 22:00 Local"]; !next.Equal(time.Date(2026, 10, 23, 22, 0, 0, 0, time.Local)) {
+		t.Errorf("wrong next weekly digest after sending. got=%s", next)
+	}
+}
+
+func TestSlackDigestClientPostMessage(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat.postMessage" {
+			t.Errorf("wrong path. got=%s", r.URL.Path)
+		}
+		if auth := r.Header.Get("Authorization"); auth != "Bearer xoxb-token" {
+			t.Errorf("wrong authorization. got=%q", auth)
+		}
+
+		var body map[string]string
+		checkErr(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["channel"] == "#archived" {
+			w.Write([]byte(`{"ok": false, "error": "is_archived"}`))
+			return
+		}
+		if body["text"] != "digest" {
+			t.Errorf("wrong text. got=%q", body["text"])
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer ts.Close()
+
+	slack := NewSlackDigestClient(ts.URL, "xoxb-token")
+
+	checkErr(t, slack.SendDigest(&models.Target{DigestSlackChannel: "#deployments"}, "digest"))
+
+	err := slack.SendDigest(&models.Target{DigestSlackChannel: "#archived"}, "digest")
+	if err == nil || !strings.Contains(err.Error(), "is_archived") {
+		t.Errorf("expected error of archived channel, got=%v", err)
+	}
+
+	err = NewSlackDigestClient(ts.URL, "").SendDigest(&models.Target{DigestSlackChannel: "#deployments"}, "digest")
+	if err == nil {
+		t.Errorf("expected error without bot token, got none")
+	}
+}
+
+func TestValidateDigestTemplates(t *testing.T) {
+	dir := t.TempDir()
+	templates := map[string]string{
+		"digest.txt":      "{{.Application.Name}}: {{len .Deployments}} deployments",
+		"digest.html":     "<h1>{{.Application.Name}}</h1>{{range .Deployments}}{{newlineToBreak .Comment}}{{end}}",
+		"unknown.txt":     "{{.Application.Owner}}",
+		"unparsable.html": "{{range .Deployments}}",
+	}
+	for name, content := range templates {
+		checkErr(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+
+	tests := []struct {
+		text  string
+		html  string
+		valid bool
+	}{
+		{"", "", true},
+		{"digest.txt", "", true},
+		{"digest.txt", "digest.html", true},
+		{"", filepath.Join(dir, "digest.html"), true},
+		{"unknown.txt", "", false},
+		{"", "unparsable.html", false},
+		{"missing.txt", "", false},
+	}
+
+	for _, tt := range tests {
+		a := &models.Application{Name: "web", DigestTextTemplate: tt.text, DigestHtmlTemplate: tt.html}
+		err := validateDigestTemplates(dir, a)
+		if (err == nil) != tt.valid {
+			t.Errorf("wrong validation of %q and %q. want valid=%v, got err=%v", tt.text, tt.html, tt.valid, err)
+		}
+	}
+}
+
+func TestNewDigestWithCustomTemplates(t *testing.T) {
+	dir := t.TempDir()
+	checkErr(t, os.WriteFile(filepath.Join(dir, "digest.txt"), []byte("{{.Schedule.Title}} deploys of {{.Application.Name}}"), 0644))
+	checkErr(t, os.WriteFile(filepath.Join(dir, "digest.html"), []byte("<p>{{range .Deployments}}{{.Comment}}{{end}}</p>"), 0644))
+
+	a := &models.Application{Name: "web", DigestTextTemplate: "digest.txt", DigestHtmlTemplate: "digest.html"}
+	tmpls, err := loadDigestTemplates(dir, a)
+	checkErr(t, err)
+
+	deployments := []*models.Deployment{{Comment: "<script>", User: buildUser(1, "mrnugget")}}
+	content := &digestContent{Application: a, Target: &models.Target{Name: "production"}, Schedule: models.DigestSchedule{Frequency: models.DIGEST_WEEKLY}, Deployments: deployments}
+	digest, err := NewDigest(tmpls, []string{"team@shipping-company.com"}, "", content)
+	checkErr(t, err)
+
+	if digest.TextBody.String() != "Weekly deploys of web" {
+		t.Errorf("wrong text body. got=%q", digest.TextBody.String())
+	}
+	if digest.HtmlBody.String() != "<p>&lt;script&gt;</p>" {
+		t.Errorf("wrong html body. got=%q", digest.HtmlBody.String())
+	}
+}
+
+func TestLocalizeTimestamps(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	checkErr(t, err)
+	createdAt := time.Date(2026, 10, 15, 2, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		schedule models.DigestSchedule
+		expected string
+	}{
+		{models.DigestSchedule{Frequency: models.DIGEST_DAILY}, "15.10.2026 04:00 (CEST)"},
+		{models.DigestSchedule{Frequency: models.DIGEST_DAILY, Location: newYork}, "14.10.2026 22:00 (EDT)"},
+	}
+
+	for _, tt := range tests {
+		deployments := []*models.Deployment{{CreatedAt: createdAt}}
+		checkErr(t, localizeTimestamps(deployments, tt.schedule))
+
+		got := deployments[0].CreatedAt.Format("02.01.2006 15:04 (MST)")
+		if got != tt.expected {
+			t.Errorf("wrong timestamp for %s. want=%s, got=%s", tt.schedule, tt.expected, got)
+		}
+	}
+}
+
+func TestDigestStats(t *testing.T) {
+	start := time.Date(2026, 10, 15, 12, 0, 0, 0, time.UTC)
+	deployment := func(state models.DeploymentState, duration time.Duration) *models.Deployment {
+		return &models.Deployment{TargetName: "production", State: state, CreatedAt: start, UpdatedAt: start.Add(duration)}
+	}
+
+	target := &models.Target{Name: "production"}
+	current := []*models.Deployment{
+		deployment(models.DEPLOYMENT_SUCCESSFUL, 2*time.Minute),
+		deployment(models.DEPLOYMENT_FAILED, time.Minute),
+	}
+	previous := []*models.Deployment{
+		deployment(models.DEPLOYMENT_SUCCESSFUL, 2*time.Minute),
+		deployment(models.DEPLOYMENT_SUCCESSFUL, 2*time.Minute),
+		deployment(models.DEPLOYMENT_SUCCESSFUL, 2*time.Minute),
+	}
+
+	stats := newDigestStats(target, current, previous)
+	if stats.Deployments() != 2 || stats.DeploymentsChange() != "-1" {
+		t.Errorf("wrong deployments. want=2 (-1), got=%d (%s)", stats.Deployments(), stats.DeploymentsChange())
+	}
+	if stats.Current.ChangeFailureRate() != 50 {
+		t.Errorf("wrong change failure rate. want=50, got=%v", stats.Current.ChangeFailureRate())
+	}
+	if stats.DurationChange() != "-30s" {
+		t.Errorf("wrong duration change. want=-30s, got=%q", stats.DurationChange())
+	}
+
+	stats = newDigestStats(target, current, nil)
+	if stats.DeploymentsChange() != "+2" || stats.DurationChange() != "" {
+		t.Errorf("wrong changes without previous deployments. got=%q, %q", stats.DeploymentsChange(), stats.DurationChange())
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const defaultDashboardDays = 30
+
+// dashboardPeriods are the selectable periods of the dashboard, in days.
+var dashboardPeriods = []int{7, 30, 90}
+
+// deploymentMetrics are the DORA metrics of the deployments to a target, or of
+// all targets, in a period. Only finished deployments count.
+type deploymentMetrics struct {
+	// Empty for the metrics of all targets
+	TargetName string
+	Days       int
+	Successful int
+	Failed     int
+	// The restores after failures and the time from each failed deployment
+	// to the next successful one, summed up
+	Restores      int
+	TimeToRestore time.Duration
+	// The number of deployments with a known duration and their durations,
+	// from creating to finishing them, summed up
+	Timed    int
+	Duration time.Duration
+}
+
+// Frequency is the number of successful deployments per day.
+func (m *deploymentMetrics) Frequency() float64 {
+	if m.Days == 0 {
+		return 0
+	}
+	return float64(m.Successful) / float64(m.Days)
+}
+
+// ChangeFailureRate is the percentage of failed deployments.
+func (m *deploymentMetrics) ChangeFailureRate() float64 {
+	if m.Successful+m.Failed == 0 {
+		return 0
+	}
+	return float64(m.Failed) / float64(m.Successful+m.Failed) * 100
+}
+
+// MeanTimeToRestore is 0 if no failure was followed by a success.
+func (m *deploymentMetrics) MeanTimeToRestore() time.Duration {
+	if m.Restores == 0 {
+		return 0
+	}
+	return m.TimeToRestore / time.Duration(m.Restores)
+}
+
+func (m *deploymentMetrics) AverageDuration() time.Duration {
+	if m.Timed == 0 {
+		return 0
+	}
+	return m.Duration / time.Duration(m.Timed)
+}
+
+func (m *deploymentMetrics) add(o *deploymentMetrics) {
+	m.Successful += o.Successful
+	m.Failed += o.Failed
+	m.Restores += o.Restores
+	m.TimeToRestore += o.TimeToRestore
+	m.Timed += o.Timed
+	m.Duration += o.Duration
+}
+
+// computeDeploymentMetrics returns the metrics of every target of the
+// application and of all of them together. deployments have to be sorted
+// oldest first.
+func computeDeploymentMetrics(a *models.Application, days int, deployments []*models.Deployment) ([]*deploymentMetrics, *deploymentMetrics) {
+	targets := make([]*deploymentMetrics, len(a.Targets))
+	byName := map[string]*deploymentMetrics{}
+	for i, t := range a.Targets {
+		targets[i] = &deploymentMetrics{TargetName: t.Name, Days: days}
+		byName[t.Name] = targets[i]
+	}
+
+	// The end of the first failure of every target since its last success
+	failedAt := map[string]time.Time{}
+
+	for _, d := range deployments {
+		m, ok := byName[d.TargetName]
+		if !ok {
+			continue
+		}
+
+		switch d.State {
+		case models.DEPLOYMENT_SUCCESSFUL:
+			m.Successful++
+			if t, ok := failedAt[d.TargetName]; ok {
+				m.Restores++
+				m.TimeToRestore += d.UpdatedAt.Sub(t)
+				delete(failedAt, d.TargetName)
+			}
+		case models.DEPLOYMENT_FAILED:
+			m.Failed++
+			if _, ok := failedAt[d.TargetName]; !ok {
+				failedAt[d.TargetName] = d.UpdatedAt
+			}
+		default:
+			continue
+		}
+
+		// Deployments finished before updated_at was added don't have a
+		// duration
+		if d.UpdatedAt.After(d.CreatedAt) {
+			m.Timed++
+			m.Duration += d.UpdatedAt.Sub(d.CreatedAt)
+		}
+	}
+
+	total := &deploymentMetrics{Days: days}
+	for _, m := range targets {
+		total.add(m)
+	}
+	return targets, total
+}
+
+// dashboardHandler shows the deployment frequency, change failure rate, mean
+// time to restore and average duration of the application's deployments in
+// the last days, 30 by default.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	days := defaultDashboardDays
+	if d := r.URL.Query().Get("days"); d != "" {
+		var err error
+		days, err = strconv.Atoi(d)
+		if err != nil || days <= 0 {
+			http.Error(w, "days must be a positive number", 422)
+			return
+		}
+	}
+
+	filter := &deploymentFilter{
+		From:      time.Now().AddDate(0, 0, -days),
+		Ascending: true,
+	}
+	deployments, err := getFilteredApplicationDeployments(db, application, filter)
+	if err != nil {
+		requestLogger(r).Error("error loading deployments", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	targets, total := computeDeploymentMetrics(application, days, deployments)
+
+	renderTemplate(w, r, "dashboard.tmpl", map[string]interface{}{
+		"Applications": getConfig().Applications,
+		"Application":  application,
+		"Days":         days,
+		"Periods":      dashboardPeriods,
+		"Targets":      targets,
+		"Total":        total,
+		"currentUser":  currentUser,
+	})
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestComputeDeploymentMetrics(t *testing.T) {
+	application := &models.Application{
+		Name: "web",
+		Targets: []*models.Target{
+			{Name: "production"},
+			{Name: "staging"},
+		},
+	}
+
+	start := time.Date(2026, time.October, 1, 10, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	deployments := []*models.Deployment{
+		{TargetName: "production", State: models.DEPLOYMENT_SUCCESSFUL, CreatedAt: at(0), UpdatedAt: at(10)},
+		{TargetName: "production", State: models.DEPLOYMENT_FAILED, CreatedAt: at(60), UpdatedAt: at(64)},
+		{TargetName: "staging", State: models.DEPLOYMENT_SUCCESSFUL, CreatedAt: at(70), UpdatedAt: at(81)},
+		{TargetName: "production", State: models.DEPLOYMENT_FAILED, CreatedAt: at(80), UpdatedAt: at(86)},
+		{TargetName: "production", State: models.DEPLOYMENT_SUCCESSFUL, CreatedAt: at(100), UpdatedAt: at(124)},
+		// Unfinished, unknown targets and missing durations
+		{TargetName: "production", State: models.DEPLOYMENT_ACTIVE, CreatedAt: at(200), UpdatedAt: at(200)},
+		{TargetName: "removed", State: models.DEPLOYMENT_FAILED, CreatedAt: at(210), UpdatedAt: at(215)},
+		{TargetName: "staging", State: models.DEPLOYMENT_FAILED, CreatedAt: at(220), UpdatedAt: at(220)},
+	}
+
+	targets, total := computeDeploymentMetrics(application, 2, deployments)
+
+	tests := []struct {
+		metrics           *deploymentMetrics
+		successful        int
+		failed            int
+		frequency         float64
+		changeFailureRate float64
+		timeToRestore     time.Duration
+		duration          time.Duration
+	}{
+		{targets[0], 2, 2, 1, 50, 60 * time.Minute, 11 * time.Minute},
+		{targets[1], 1, 1, 0.5, 50, 0, 11 * time.Minute},
+		{total, 3, 3, 1.5, 50, 60 * time.Minute, 11 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		m := tt.metrics
+		if m.Successful != tt.successful || m.Failed != tt.failed {
+			t.Errorf("wrong counts of %q. want=%d/%d, got=%d/%d", m.TargetName, tt.successful, tt.failed, m.Successful, m.Failed)
+		}
+		if m.Frequency() != tt.frequency {
+			t.Errorf("wrong frequency of %q. want=%v, got=%v", m.TargetName, tt.frequency, m.Frequency())
+		}
+		if m.ChangeFailureRate() != tt.changeFailureRate {
+			t.Errorf("wrong change failure rate of %q. want=%v, got=%v", m.TargetName, tt.changeFailureRate, m.ChangeFailureRate())
+		}
+		if m.MeanTimeToRestore() != tt.timeToRestore {
+			t.Errorf("wrong time to restore of %q. want=%v, got=%v", m.TargetName, tt.timeToRestore, m.MeanTimeToRestore())
+		}
+		if m.AverageDuration() != tt.duration {
+			t.Errorf("wrong duration of %q. want=%v, got=%v", m.TargetName, tt.duration, m.AverageDuration())
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -16,27 +18,116 @@ import (
 	"database/sql"
 )
 
+// How many log entries are saved in one transaction at most
+const logEntryBatchSize = 100
+
 const (
-	deploymentStmt                     = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.id = ?`
-	deploymentInsertStmt               = `INSERT INTO deployments (user_id, application_name, target_name, commit_sha, branch, comment, state, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
-	deploymentUpdateStateStmt          = `UPDATE deployments SET state = ? WHERE deployments.id = ?`
-	deploymentFailUnfinishedStmt       = `UPDATE deployments SET state = ? WHERE deployments.state = ? OR deployments.state = ?`
-	lastTargetDeploymentStmt           = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.state = ? AND deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC LIMIT 1`
-	applicationDeploymentsStmt         = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.application_name = ? ORDER BY created_at DESC LIMIT ?`
-	applicationDeploymentsByTargetStmt = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC`
-	logEntryInsertStmt                 = `INSERT INTO log_entries (deployment_id, entry_type, origin, message, timestamp, created_at) VALUES (?, ?, ?, ?, ?, ?);`
-	deploymentLogEntriesStmt           = `SELECT id, deployment_id, entry_type, origin, message, timestamp FROM log_entries WHERE log_entries.deployment_id = ? ORDER BY timestamp ASC`
-	userInsertStmt                     = `INSERT INTO users(id, name, access_token, avatar_url, api_token) VALUES(?, ?, ?, ?, ?);`
-	userUpdateStmt                     = `UPDATE users SET access_token = ?, avatar_url = ? WHERE id = ?;`
-	userStmt                           = `SELECT id, name, access_token, avatar_url, api_token FROM users WHERE id = ?;`
-	userApiTokenStmt                   = `SELECT id, name, access_token, avatar_url, api_token FROM users WHERE api_token = ?;`
+	deploymentStmt                     = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE deployments.id = ?`
+	deploymentInsertStmt               = `INSERT INTO deployments (user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	deploymentUpdateStateStmt          = `UPDATE deployments SET state = ?, updated_at = ? WHERE deployments.id = ?`
+	deploymentUpdateHostGroupStmt      = `UPDATE deployments SET host_group = ?, updated_at = ? WHERE deployments.id = ?`
+	deploymentFailUnfinishedStmt       = `UPDATE deployments SET state = ?, updated_at = ? WHERE deployments.state = ? OR deployments.state = ? OR deployments.state = ?`
+	lastChangedDeploymentStmt          = `SELECT id, updated_at FROM deployments WHERE application_name = ? ORDER BY updated_at DESC, id DESC LIMIT 1`
+	logEntriesVersionStmt              = `SELECT COUNT(*), COALESCE(MAX(id), 0) FROM log_entries WHERE deployment_id = ?`
+	lastTargetDeploymentStmt           = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE deployments.state = ? AND deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC LIMIT 1`
+	previousTargetDeploymentStmt       = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE deployments.state = ? AND deployments.application_name = ? AND deployments.target_name = ? AND deployments.commit_sha != ? AND deployments.created_at < ? ORDER BY created_at DESC LIMIT 1`
+	latestTargetDeploymentStmt         = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE deployments.application_name = ? AND deployments.target_name = ? ORDER BY created_at DESC LIMIT 1`
+	filteredApplicationDeploymentsStmt = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE %s ORDER BY created_at %s, id %s LIMIT ?`
+	logEntryInsertStmt                 = `INSERT INTO log_entries (deployment_id, sequence, entry_type, origin, message, timestamp, exit_code, duration, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	deploymentLogEntriesStmt           = `SELECT id, deployment_id, sequence, entry_type, origin, message, timestamp, exit_code, duration FROM log_entries WHERE log_entries.deployment_id = ? ORDER BY id ASC`
+	userInsertStmt                     = `INSERT INTO users(id, name, access_token, avatar_url, api_token, api_token_hash, provider, provider_id, api_token_created_at, refresh_token, token_expires_at) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	userApiTokenUpdateStmt             = `UPDATE users SET api_token = ?, api_token_hash = ?, api_token_created_at = ?, api_token_last_used_at = NULL WHERE id = ?;`
+	userApiTokenUsageStmt              = `SELECT api_token_created_at, api_token_last_used_at FROM users WHERE id = ?;`
+	userApiTokenUsedStmt               = `UPDATE users SET api_token_last_used_at = ? WHERE id = ?;`
+	userUpdateStmt                     = `UPDATE users SET access_token = ?, avatar_url = ?, refresh_token = ?, token_expires_at = ? WHERE id = ?;`
+	userTokenUpdateStmt                = `UPDATE users SET access_token = ?, refresh_token = ?, token_expires_at = ? WHERE id = ?;`
+	userSecretsUpdateStmt              = `UPDATE users SET access_token = ?, refresh_token = ?, api_token = ?, api_token_hash = ? WHERE id = ?;`
+	userStmt                           = `SELECT id, name, access_token, avatar_url, api_token, provider, provider_id, refresh_token, token_expires_at, deactivated_at FROM users WHERE id = ?;`
+	userApiTokenStmt                   = `SELECT id, name, access_token, avatar_url, api_token, provider, provider_id, refresh_token, token_expires_at, deactivated_at FROM users WHERE api_token_hash = ?;`
+	userGroupsStmt                     = `SELECT group_name FROM user_groups WHERE user_id = ? ORDER BY group_name;`
+	userGroupsDeleteStmt               = `DELETE FROM user_groups WHERE user_id = ?;`
+	userGroupInsertStmt                = `INSERT INTO user_groups (user_id, group_name) VALUES (?, ?);`
+	userPreferencesStmt                = `SELECT theme, time_zone, default_application, deployment_notifications, language FROM user_preferences WHERE user_id = ?;`
+	userPreferencesReplaceStmt         = `INSERT OR REPLACE INTO user_preferences (user_id, theme, time_zone, default_application, deployment_notifications, language) VALUES (?, ?, ?, ?, ?, ?);`
+	userSessionInsertStmt              = `INSERT INTO user_sessions (id, user_id, source_ip, user_agent, created_at, last_seen_at) VALUES (?, ?, ?, ?, ?, ?);`
+	userSessionStmt                    = `SELECT id, user_id, source_ip, user_agent, created_at, last_seen_at FROM user_sessions WHERE id = ? AND last_seen_at > ?;`
+	userSessionsStmt                   = `SELECT id, user_id, source_ip, user_agent, created_at, last_seen_at FROM user_sessions WHERE user_id = ? AND last_seen_at > ? ORDER BY last_seen_at DESC;`
+	userSessionTouchStmt               = `UPDATE user_sessions SET last_seen_at = ?, source_ip = ? WHERE id = ?;`
+	userSessionDeleteStmt              = `DELETE FROM user_sessions WHERE user_id = ? AND id = ?;`
+	userOtherSessionsDeleteStmt        = `DELETE FROM user_sessions WHERE user_id = ? AND id != ?;`
+	userSessionsDeleteStmt             = `DELETE FROM user_sessions WHERE user_id = ?;`
+	expiredUserSessionsDeleteStmt      = `DELETE FROM user_sessions WHERE last_seen_at <= ?;`
+	recentUserDeploymentsStmt          = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE user_id = ? ORDER BY created_at DESC, id DESC LIMIT ?`
+	usersByProviderStmt                = `SELECT id, name, access_token, avatar_url, api_token, provider, provider_id, refresh_token, token_expires_at, deactivated_at FROM users WHERE provider = ? ORDER BY id;`
+	userIdByNameStmt                   = `SELECT id FROM users WHERE name = ? AND provider = ? ORDER BY id LIMIT 1;`
+	allUsersStmt                       = `SELECT id, name, access_token, avatar_url, api_token, provider, provider_id, refresh_token, token_expires_at, deactivated_at FROM users ORDER BY name, id;`
+	userDeactivatedStmt                = `UPDATE users SET deactivated_at = ? WHERE id = ?;`
+	userProviderStmt                   = `SELECT id, name, access_token, avatar_url, api_token, provider, provider_id, refresh_token, token_expires_at, deactivated_at FROM users WHERE provider = ? AND provider_id = ?;`
+	deploymentInitiatorStmt            = `SELECT token_name, source_ip, build_url, build_number, on_behalf_of FROM deployment_initiators WHERE deployment_id = ?;`
+	deploymentInitiatorInsertStmt      = `INSERT INTO deployment_initiators (deployment_id, token_name, source_ip, build_url, build_number, on_behalf_of) VALUES (?, ?, ?, ?, ?, ?);`
+	deploymentChangelogStmt            = `SELECT commit_sha, author, message FROM deployment_changelog_entries WHERE deployment_id = ? ORDER BY position ASC;`
+	deploymentChangelogInsertStmt      = `INSERT INTO deployment_changelog_entries (deployment_id, position, commit_sha, author, message) VALUES (?, ?, ?, ?, ?);`
+	deploymentOverridesStmt            = `SELECT name, value FROM deployment_overrides WHERE deployment_id = ?;`
+	deploymentOverrideInsertStmt       = `INSERT INTO deployment_overrides (deployment_id, name, value) VALUES (?, ?, ?);`
 	activeDeploymentsStmt              = `SELECT state FROM deployments WHERE application_name = ? AND target_name = ? AND state = 'active' LIMIT 1;`
-	dailyDigestDeploymentsStmt         = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at FROM deployments WHERE state = 'successful' AND application_name = ? AND target_name = ? AND created_at > ? ORDER BY created_at ASC;`
+	unfinishedDeploymentsStmt          = `SELECT state FROM deployments WHERE application_name = ? AND target_name = ? AND state IN ('new', 'active', 'queued') LIMIT 1;`
+	dailyDigestDeploymentsStmt         = `SELECT id, user_id, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE state IN ('successful', 'failed') AND application_name = ? AND target_name = ? AND created_at > ? AND created_at <= ? ORDER BY created_at ASC;`
+	weeklySummaryDeploymentsStmt       = `SELECT id, user_id, application_name, target_name, state, created_at FROM deployments WHERE state IN ('successful', 'failed') AND created_at > ? AND created_at <= ? ORDER BY created_at ASC;`
+	allActiveDeploymentsStmt           = `SELECT id, user_id, application_name, target_name, commit_sha, branch, comment, state, created_at, retry_of, host_group, ci_override_reason, pull_request, tag, updated_at, redeploy_of, stages, request_id FROM deployments WHERE state = 'active' ORDER BY created_at ASC, id ASC;`
+	activeStageEntriesStmt             = `SELECT log_entries.deployment_id, log_entries.entry_type, log_entries.message, log_entries.timestamp FROM log_entries JOIN deployments ON deployments.id = log_entries.deployment_id WHERE log_entries.entry_type IN ('STAGE_START', 'STAGE_SUCCESS') AND deployments.state = 'active' ORDER BY log_entries.deployment_id ASC, log_entries.timestamp ASC;`
+	weeklySummaryStageEntriesStmt      = `SELECT log_entries.deployment_id, log_entries.entry_type, log_entries.message, log_entries.timestamp FROM log_entries JOIN deployments ON deployments.id = log_entries.deployment_id WHERE log_entries.entry_type IN ('STAGE_START', 'STAGE_SUCCESS') AND deployments.state IN ('successful', 'failed') AND deployments.created_at > ? AND deployments.created_at <= ? ORDER BY log_entries.deployment_id ASC, log_entries.timestamp ASC;`
+	liveHostGroupStmt                  = `SELECT host_group FROM live_host_groups WHERE application_name = ? AND target_name = ?;`
+	liveHostGroupReplaceStmt           = `INSERT OR REPLACE INTO live_host_groups (application_name, target_name, host_group, deployment_id, updated_at) VALUES (?, ?, ?, ?, ?);`
+	hostStageStartStmt                 = `INSERT OR REPLACE INTO deployment_host_stages (deployment_id, host, stage, state, last_command, last_command_done, updated_at) VALUES (?, ?, ?, 'running', ?, 0, ?);`
+	hostStageCommandDoneStmt           = `UPDATE deployment_host_stages SET last_command_done = 1, updated_at = ? WHERE deployment_id = ? AND host = ? AND stage = ?;`
+	hostStageFailStmt                  = `UPDATE deployment_host_stages SET state = 'failed', updated_at = ? WHERE deployment_id = ? AND host = ? AND stage = ?;`
+	hostStagesFinishStmt               = `UPDATE deployment_host_stages SET state = ?, updated_at = ? WHERE deployment_id = ? AND stage = ? AND state = 'running';`
+	hostStagesInterruptStmt            = `UPDATE deployment_host_stages SET state = 'interrupted', updated_at = ? WHERE deployment_id = ? AND state = 'running';`
+	hostStagesStmt                     = `SELECT deployment_id, host, stage, state, last_command, last_command_done, updated_at FROM deployment_host_stages WHERE deployment_id = ? ORDER BY id ASC;`
+	deploymentStageEntriesStmt         = `SELECT deployment_id, entry_type, message, timestamp FROM log_entries WHERE deployment_id = ? AND entry_type IN ('STAGE_START', 'STAGE_SUCCESS', 'STAGE_FAIL') ORDER BY timestamp ASC, id ASC;`
+	auditEventInsertStmt               = `INSERT INTO audit_events (user_id, action, subject, source_ip, created_at) VALUES (?, ?, ?, ?, ?);`
+	filteredAuditEventsStmt            = `SELECT id, user_id, action, subject, source_ip, created_at FROM audit_events WHERE %s ORDER BY created_at DESC, id DESC LIMIT ?`
+	deployFreezeInsertStmt             = `INSERT OR REPLACE INTO deploy_freezes (application_name, target_name, user_id, reason, created_at) VALUES (?, ?, ?, ?, ?);`
+	deployFreezesStmt                  = `SELECT id, application_name, target_name, user_id, reason, created_at FROM deploy_freezes WHERE application_name = ? ORDER BY target_name;`
+	deployFreezeDeleteStmt             = `DELETE FROM deploy_freezes WHERE application_name = ? AND id = ?;`
+	deployPresetInsertStmt             = `INSERT OR REPLACE INTO deploy_presets (application_name, name, target_name, branch, comment_prefix, stages, overrides, user_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	deployPresetsStmt                  = `SELECT id, application_name, name, target_name, branch, comment_prefix, stages, overrides, user_id, created_at FROM deploy_presets WHERE application_name = ? ORDER BY name;`
+	deployPresetDeleteStmt             = `DELETE FROM deploy_presets WHERE application_name = ? AND id = ?;`
+	managedApplicationsStmt            = `SELECT name, definition, user_id, created_at, updated_at FROM managed_applications ORDER BY name;`
+	managedApplicationSaveStmt         = `INSERT OR REPLACE INTO managed_applications (name, definition, user_id, created_at, updated_at) VALUES (?, ?, ?, COALESCE((SELECT created_at FROM managed_applications WHERE name = ?), ?), ?);`
+	managedApplicationCreatedAtStmt    = `SELECT created_at FROM managed_applications WHERE name = ?;`
+	managedApplicationUpdateStmt       = `UPDATE managed_applications SET definition = ? WHERE name = ?;`
+	managedApplicationDeleteStmt       = `DELETE FROM managed_applications WHERE name = ?;`
+	deliveryInsertStmt                 = `INSERT INTO deliveries (deployment_id, notifier, method, url, header, payload, payload_hash, expected_status, status_code, error, latency, retry_of, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	deliveryStmt                       = `SELECT id, deployment_id, notifier, method, url, header, payload, payload_hash, expected_status, status_code, error, latency, retry_of, created_at FROM deliveries WHERE id = ?;`
+	filteredDeliveriesStmt             = `SELECT id, deployment_id, notifier, method, url, header, payload, payload_hash, expected_status, status_code, error, latency, retry_of, created_at FROM deliveries WHERE %s ORDER BY created_at DESC, id DESC LIMIT ?`
+	deliveryFailedCondition            = `(error != '' OR (expected_status = 0 AND (status_code < 200 OR status_code >= 300)) OR (expected_status != 0 AND status_code != expected_status))`
+	digestSubscriptionSaveStmt         = `INSERT OR REPLACE INTO digest_subscriptions (user_id, application_name, email, token, created_at) VALUES (?, ?, ?, ?, ?);`
+	userDigestSubscriptionsStmt        = `SELECT id, user_id, application_name, email, token, created_at FROM digest_subscriptions WHERE user_id = ? ORDER BY application_name;`
+	applicationDigestSubscriptionsStmt = `SELECT s.id, s.user_id, s.application_name, s.email, s.token, s.created_at FROM digest_subscriptions s JOIN users ON users.id = s.user_id WHERE s.application_name = ? AND users.deactivated_at IS NULL ORDER BY s.id;`
+	digestSubscriptionByTokenStmt      = `SELECT id, user_id, application_name, email, token, created_at FROM digest_subscriptions WHERE token = ?;`
+	digestSubscriptionDeleteStmt       = `DELETE FROM digest_subscriptions WHERE id = ?;`
+	calendarTokenSaveStmt              = `INSERT OR REPLACE INTO calendar_tokens (user_id, token, created_at) VALUES (?, ?, ?);`
+	calendarTokenStmt                  = `SELECT token FROM calendar_tokens WHERE user_id = ?;`
+	calendarTokenUserStmt              = `SELECT user_id FROM calendar_tokens WHERE token = ?;`
+	eventRecordInsertStmt              = `INSERT INTO event_records (type, deployment_id, application_name, target_name, state, commit_sha, branch, tag, comment, user_name, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);`
+	filteredEventRecordsStmt           = `SELECT id, type, deployment_id, application_name, target_name, state, commit_sha, branch, tag, comment, user_name, created_at FROM event_records WHERE %s ORDER BY id %s LIMIT ?`
 )
 
 var ErrDeployInProgress = errors.New("another deployment to target already in progress")
 
 func createDeployment(db *sql.DB, d *models.Deployment) error {
+	return insertDeployment(db, d, false)
+}
+
+// createOrQueueDeployment saves the deployment in state 'queued' if another
+// deployment to the target is in progress or queued, instead of returning
+// ErrDeployInProgress.
+func createOrQueueDeployment(db *sql.DB, d *models.Deployment) error {
+	return insertDeployment(db, d, true)
+}
+
+func insertDeployment(db *sql.DB, d *models.Deployment, queue bool) error {
 	var id int64
 	var state models.DeploymentState = models.DEPLOYMENT_NEW
 	var createdAt time.Time = time.Now()
@@ -45,18 +136,32 @@ func createDeployment(db *sql.DB, d *models.Deployment) error {
 	if err != nil {
 		return err
 	}
-	exists, err := activeDeploymentExists(tx, d.ApplicationName, d.TargetName)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
-	if exists {
-		tx.Rollback()
-		return ErrDeployInProgress
+
+	if queue {
+		exists, err := unfinishedDeploymentExists(tx, d.ApplicationName, d.TargetName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if exists {
+			state = models.DEPLOYMENT_QUEUED
+		}
+	} else {
+		exists, err := activeDeploymentExists(tx, d.ApplicationName, d.TargetName)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if exists {
+			tx.Rollback()
+			return ErrDeployInProgress
+		}
 	}
 
 	result, err := tx.Exec(deploymentInsertStmt, d.UserId, d.ApplicationName,
-		d.TargetName, d.CommitSha, d.Branch, d.Comment, string(state), createdAt)
+		d.TargetName, d.CommitSha, d.Branch, d.Comment, string(state), createdAt,
+		d.RetryOf, d.HostGroup, d.CIOverrideReason, d.PullRequest, d.Tag, createdAt,
+		d.RedeployOf, joinDeploymentStages(d.Stages), d.RequestId)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -68,33 +173,157 @@ func createDeployment(db *sql.DB, d *models.Deployment) error {
 		return err
 	}
 
+	if d.Initiator != nil {
+		i := d.Initiator
+		_, err = tx.Exec(deploymentInitiatorInsertStmt, id, i.TokenName, i.SourceIP,
+			i.BuildURL, i.BuildNumber, i.OnBehalfOf)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for position, e := range d.Changelog {
+		_, err = tx.Exec(deploymentChangelogInsertStmt, id, position, e.CommitSha, e.Author, e.Message)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	for name, value := range d.Overrides {
+		_, err = tx.Exec(deploymentOverrideInsertStmt, id, name, value)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	d.Id = int(id)
 	d.State = state
 	d.CreatedAt = createdAt
+	d.UpdatedAt = createdAt
 
 	return tx.Commit()
 }
 
+// loadDeploymentInitiator loads the initiator of a deployment created with an
+// API token. It stays nil for other deployments.
+func loadDeploymentInitiator(db *sql.DB, d *models.Deployment) error {
+	i := &models.DeploymentInitiator{}
+
+	err := db.QueryRow(deploymentInitiatorStmt, d.Id).Scan(&i.TokenName, &i.SourceIP,
+		&i.BuildURL, &i.BuildNumber, &i.OnBehalfOf)
+	switch {
+	case err == sql.ErrNoRows:
+		d.Initiator = nil
+		return nil
+	case err != nil:
+		return err
+	}
+
+	d.Initiator = i
+	return nil
+}
+
+// loadDeploymentChangelog loads the commits deployed since the previous
+// successful deployment to the target.
+func loadDeploymentChangelog(db *sql.DB, d *models.Deployment) error {
+	rows, err := db.Query(deploymentChangelogStmt, d.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	changelog := []*models.ChangelogEntry{}
+	for rows.Next() {
+		e := &models.ChangelogEntry{}
+		err := rows.Scan(&e.CommitSha, &e.Author, &e.Message)
+		if err != nil {
+			return err
+		}
+		changelog = append(changelog, e)
+	}
+
+	d.Changelog = changelog
+	return rows.Err()
+}
+
+// loadDeploymentOverrides loads the variables overridden for the deployment.
+func loadDeploymentOverrides(db *sql.DB, d *models.Deployment) error {
+	rows, err := db.Query(deploymentOverridesStmt, d.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	overrides := make(map[string]string)
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+		overrides[name] = value
+	}
+
+	d.Overrides = overrides
+	return rows.Err()
+}
+
 func updateDeploymentState(db *sql.DB, d *models.Deployment, state models.DeploymentState) error {
-	_, err := db.Exec(deploymentUpdateStateStmt, string(state), d.Id)
+	updatedAt := time.Now()
+
+	_, err := db.Exec(deploymentUpdateStateStmt, string(state), updatedAt, d.Id)
 	if err != nil {
 		return err
 	}
 
 	d.State = state
+	d.UpdatedAt = updatedAt
 	return nil
 }
 
+// getLastDeploymentChange returns the ID and the time of the last change of
+// the most recently created or updated deployment of the application. They
+// change whenever a listing of the deployments might change.
+func getLastDeploymentChange(db *sql.DB, a *models.Application) (int, time.Time, error) {
+	var id int
+	var updatedAt *time.Time
+
+	err := db.QueryRow(lastChangedDeploymentStmt, a.Name).Scan(&id, &updatedAt)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil || updatedAt == nil {
+		return id, time.Time{}, err
+	}
+
+	return id, *updatedAt, nil
+}
+
+// getLogEntriesVersion returns the number of log entries of the deployment
+// and the ID of the last one, which change with every new entry.
+func getLogEntriesVersion(db *sql.DB, d *models.Deployment) (int, int, error) {
+	var count, lastId int
+	err := db.QueryRow(logEntriesVersionStmt, d.Id).Scan(&count, &lastId)
+	return count, lastId, err
+}
+
 func getRecentApplicationDeployments(db *sql.DB, a *models.Application) ([]*models.Deployment, error) {
 	return getApplicationDeployments(db, a, 10)
 }
 
-func getAllApplicationDeployments(db *sql.DB, a *models.Application) ([]*models.Deployment, error) {
-	return getApplicationDeployments(db, a, -1)
+func getApplicationDeployments(db *sql.DB, a *models.Application, limit int) ([]*models.Deployment, error) {
+	return getFilteredApplicationDeployments(db, a, &deploymentFilter{Limit: limit})
 }
 
-func getApplicationDeployments(db *sql.DB, a *models.Application, limit int) ([]*models.Deployment, error) {
-	rows, err := db.Query(applicationDeploymentsStmt, a.Name, limit)
+func getApplicationDeploymentsByTarget(db *sql.DB, a *models.Application, t *models.Target) ([]*models.Deployment, error) {
+	return getFilteredApplicationDeployments(db, a, &deploymentFilter{TargetName: t.Name})
+}
+
+func getFilteredApplicationDeployments(db *sql.DB, a *models.Application, f *deploymentFilter) ([]*models.Deployment, error) {
+	stmt, args := filteredApplicationDeploymentsQuery(a, f)
+	rows, err := db.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -103,8 +332,66 @@ func getApplicationDeployments(db *sql.DB, a *models.Application, limit int) ([]
 	return readApplicationDeployments(rows)
 }
 
-func getApplicationDeploymentsByTarget(db *sql.DB, a *models.Application, t *models.Target) ([]*models.Deployment, error) {
-	rows, err := db.Query(applicationDeploymentsByTargetStmt, a.Name, t.Name)
+// filteredApplicationDeploymentsQuery returns the statement and the arguments
+// loading the deployments of the application that match the filter.
+func filteredApplicationDeploymentsQuery(a *models.Application, f *deploymentFilter) (string, []interface{}) {
+	conditions := []string{"application_name = ?"}
+	args := []interface{}{a.Name}
+
+	if f.TargetName != "" {
+		conditions = append(conditions, "target_name = ?")
+		args = append(args, f.TargetName)
+	}
+	if f.State != "" {
+		// The unary + keeps SQLite from choosing the index on the state,
+		// which matches the deployments of all applications
+		conditions = append(conditions, "+state = ?")
+		args = append(args, string(f.State))
+	}
+	if f.Branch != "" {
+		conditions = append(conditions, "branch = ?")
+		args = append(args, f.Branch)
+	}
+	if f.UserName != "" {
+		conditions = append(conditions, "user_id IN (SELECT id FROM users WHERE name = ?)")
+		args = append(args, f.UserName)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To)
+	}
+
+	order := "DESC"
+	if f.Ascending {
+		order = "ASC"
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	stmt := fmt.Sprintf(filteredApplicationDeploymentsStmt, strings.Join(conditions, " AND "), order, order)
+	return stmt, args
+}
+
+// searchApplicationDeployments finds deployments whose commit SHA starts with
+// the query or whose branch, tag, comment or deployer contains it, ignoring
+// the case. Newest first.
+func searchApplicationDeployments(db *sql.DB, a *models.Application, query string, limit int) ([]*models.Deployment, error) {
+	escaped := likeEscaper.Replace(query)
+	prefix, contains := escaped+"%", "%"+escaped+"%"
+
+	conditions := `application_name = ? AND (commit_sha LIKE ? ESCAPE '\' OR branch LIKE ? ESCAPE '\' OR tag LIKE ? ESCAPE '\' OR comment LIKE ? ESCAPE '\' OR user_id IN (SELECT id FROM users WHERE name LIKE ? ESCAPE '\'))`
+	args := []interface{}{a.Name, prefix, contains, contains, contains, contains, limit}
+
+	stmt := fmt.Sprintf(filteredApplicationDeploymentsStmt, conditions, "DESC", "DESC")
+	rows, err := db.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -113,19 +400,25 @@ func getApplicationDeploymentsByTarget(db *sql.DB, a *models.Application, t *mod
 	return readApplicationDeployments(rows)
 }
 
+// likeEscaper escapes the wildcards of LIKE patterns, with \ as ESCAPE.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func readApplicationDeployments(rows *sql.Rows) ([]*models.Deployment, error) {
 	deployments := []*models.Deployment{}
 
 	for rows.Next() {
-		var state string
+		var state, stages string
+		var updatedAt *time.Time
 		d := &models.Deployment{}
 
-		err := rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt)
+		err := rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt, &d.RetryOf, &d.HostGroup, &d.CIOverrideReason, &d.PullRequest, &d.Tag, &updatedAt, &d.RedeployOf, &stages, &d.RequestId)
 		if err != nil {
 			return deployments, err
 		}
 
 		d.State = models.DeploymentState(state)
+		setUpdatedAt(d, updatedAt)
+		d.Stages = splitDeploymentStages(stages)
 
 		deployments = append(deployments, d)
 	}
@@ -146,25 +439,94 @@ func getLastTargetDeployment(db *sql.DB, a *models.Application, targetName strin
 		string(models.DEPLOYMENT_SUCCESSFUL), a.Name, targetName)
 }
 
-func getDailyDigestDeployments(db *sql.DB, a *models.Application, targetName string, since time.Time) ([]*models.Deployment, error) {
+// getPreviousTargetDeployment returns the last successful deployment to the
+// target of d before d with another commit, the one a rollback redeploys.
+func getPreviousTargetDeployment(db *sql.DB, a *models.Application, d *models.Deployment) (*models.Deployment, error) {
+	return queryDeploymentRow(db, previousTargetDeploymentStmt,
+		string(models.DEPLOYMENT_SUCCESSFUL), a.Name, d.TargetName, d.CommitSha, d.CreatedAt)
+}
+
+// getLatestTargetDeployment returns the last deployment to the target,
+// regardless of its state.
+// getRecentUserDeployments returns the last deployments of the user to all
+// applications, newest first.
+func getRecentUserDeployments(db *sql.DB, userId int, limit int) ([]*models.Deployment, error) {
+	deployments := []*models.Deployment{}
+
+	rows, err := db.Query(recentUserDeploymentsStmt, userId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := &models.Deployment{}
+		var state, stages string
+		var updatedAt *time.Time
+
+		err = rows.Scan(&d.Id, &d.UserId, &d.ApplicationName, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt, &d.RetryOf, &d.HostGroup, &d.CIOverrideReason, &d.PullRequest, &d.Tag, &updatedAt, &d.RedeployOf, &stages, &d.RequestId)
+		if err != nil {
+			return nil, err
+		}
+		d.State = models.DeploymentState(state)
+		setUpdatedAt(d, updatedAt)
+		d.Stages = splitDeploymentStages(stages)
+
+		deployments = append(deployments, d)
+	}
+
+	return deployments, rows.Err()
+}
+
+func getLatestTargetDeployment(db *sql.DB, a *models.Application, targetName string) (*models.Deployment, error) {
+	return queryDeploymentRow(db, latestTargetDeploymentStmt, a.Name, targetName)
+}
+
+// countDeploymentRetries returns how often the original deployment has been
+// retried automatically until the given deployment.
+func countDeploymentRetries(db *sql.DB, d *models.Deployment) (int, error) {
+	retries := 0
+
+	for d.RetryOf != 0 {
+		retries++
+
+		previous, err := getDeployment(db, d.RetryOf)
+		if err != nil {
+			return retries, err
+		}
+		if previous == nil {
+			break
+		}
+		d = previous
+	}
+
+	return retries, nil
+}
+
+// getDailyDigestDeployments returns the successful and failed deployments of
+// the target created after since and until at the latest, oldest first.
+func getDailyDigestDeployments(db *sql.DB, a *models.Application, targetName string, since, until time.Time) ([]*models.Deployment, error) {
 	deployments := []*models.Deployment{}
 
-	rows, err := db.Query(dailyDigestDeploymentsStmt, a.Name, targetName, since)
+	rows, err := db.Query(dailyDigestDeploymentsStmt, a.Name, targetName, since, until)
 	if err != nil {
 		return deployments, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var state string
+		var state, stages string
+		var updatedAt *time.Time
 		d := &models.Deployment{}
 
-		err = rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt)
+		err = rows.Scan(&d.Id, &d.UserId, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt, &d.RetryOf, &d.HostGroup, &d.CIOverrideReason, &d.PullRequest, &d.Tag, &updatedAt, &d.RedeployOf, &stages, &d.RequestId)
 		if err != nil {
 			return deployments, err
 		}
 
 		d.State = models.DeploymentState(state)
+		setUpdatedAt(d, updatedAt)
+		d.Stages = splitDeploymentStages(stages)
 
 		deployments = append(deployments, d)
 	}
@@ -176,35 +538,41 @@ func getDailyDigestDeployments(db *sql.DB, a *models.Application, targetName str
 	return deployments, nil
 }
 
-func failUnfinishedDeployments(db *sql.DB) error {
-	_, err := db.Exec(deploymentFailUnfinishedStmt,
-		string(models.DEPLOYMENT_FAILED), string(models.DEPLOYMENT_NEW),
-		string(models.DEPLOYMENT_ACTIVE))
-	return err
-}
+// getWeeklySummaryDeployments returns the finished deployments of all
+// applications created after from and up to to. Only the fields needed for
+// the weekly summary are loaded.
+func getWeeklySummaryDeployments(db *sql.DB, from, to time.Time) ([]*models.Deployment, error) {
+	deployments := []*models.Deployment{}
 
-func createLogEntry(db *sql.DB, entry *deploy.LogEntry) error {
-	result, err := db.Exec(logEntryInsertStmt, entry.DeploymentId,
-		string(entry.EntryType), entry.Origin, entry.Message,
-		entry.Timestamp, time.Now())
+	rows, err := db.Query(weeklySummaryDeploymentsStmt, from, to)
 	if err != nil {
-		return err
+		return deployments, err
 	}
+	defer rows.Close()
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
-	}
+	for rows.Next() {
+		var state string
+		d := &models.Deployment{}
 
-	entry.Id = int(id)
+		err = rows.Scan(&d.Id, &d.UserId, &d.ApplicationName, &d.TargetName, &state, &d.CreatedAt)
+		if err != nil {
+			return deployments, err
+		}
+		d.State = models.DeploymentState(state)
 
-	return err
+		deployments = append(deployments, d)
+	}
+
+	return deployments, rows.Err()
 }
 
-func getDeploymentLogEntries(db *sql.DB, d *models.Deployment) ([]*deploy.LogEntry, error) {
+// getWeeklySummaryStageEntries returns the STAGE_START and STAGE_SUCCESS log
+// entries of the deployments returned by getWeeklySummaryDeployments, ordered
+// by deployment and time.
+func getWeeklySummaryStageEntries(db *sql.DB, from, to time.Time) ([]*deploy.LogEntry, error) {
 	entries := []*deploy.LogEntry{}
 
-	rows, err := db.Query(deploymentLogEntriesStmt, d.Id)
+	rows, err := db.Query(weeklySummaryStageEntriesStmt, from, to)
 	if err != nil {
 		return entries, err
 	}
@@ -214,182 +582,1570 @@ func getDeploymentLogEntries(db *sql.DB, d *models.Deployment) ([]*deploy.LogEnt
 		var entryType string
 		e := &deploy.LogEntry{}
 
-		err = rows.Scan(&e.Id, &e.DeploymentId, &entryType, &e.Origin, &e.Message, &e.Timestamp)
+		err = rows.Scan(&e.DeploymentId, &entryType, &e.Message, &e.Timestamp)
 		if err != nil {
 			return entries, err
 		}
-
 		e.EntryType = deploy.LogEntryType(entryType)
 
 		entries = append(entries, e)
 	}
 
-	if err := rows.Err(); err != nil {
-		return entries, err
+	return entries, rows.Err()
+}
+
+// getAllActiveDeployments returns the active deployments of all
+// applications, the longest running first.
+func getAllActiveDeployments(db *sql.DB) ([]*models.Deployment, error) {
+	deployments := []*models.Deployment{}
+
+	rows, err := db.Query(allActiveDeploymentsStmt)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	return entries, nil
-}
+	for rows.Next() {
+		d := &models.Deployment{}
+		var state, stages string
+		var updatedAt *time.Time
 
-func newLogEntrySaver(db *sql.DB) deploy.Listener {
-	fn := func(logs <-chan deploy.LogEntry) {
-		for entry := range logs {
-			err := createLogEntry(db, &entry)
-			if err != nil {
-				log.Printf("error saving log entry: %s", err)
-			}
+		err = rows.Scan(&d.Id, &d.UserId, &d.ApplicationName, &d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt, &d.RetryOf, &d.HostGroup, &d.CIOverrideReason, &d.PullRequest, &d.Tag, &updatedAt, &d.RedeployOf, &stages, &d.RequestId)
+		if err != nil {
+			return nil, err
 		}
+		d.State = models.DeploymentState(state)
+		setUpdatedAt(d, updatedAt)
+		d.Stages = splitDeploymentStages(stages)
+
+		deployments = append(deployments, d)
 	}
 
-	return fn
+	return deployments, rows.Err()
 }
 
-func createUser(db *sql.DB, u *models.User) error {
-	u.ApiToken = uuid.New()
-	_, err := db.Exec(userInsertStmt, u.Id, u.Name, u.AccessToken, u.AvatarUrl, u.ApiToken)
-	return err
-}
+// getActiveStageEntries returns the STAGE_START and STAGE_SUCCESS log entries
+// of the active deployments, ordered by deployment and time.
+func getActiveStageEntries(db *sql.DB) ([]*deploy.LogEntry, error) {
+	entries := []*deploy.LogEntry{}
 
-func updateUser(db *sql.DB, u *models.User) error {
-	_, err := db.Exec(userUpdateStmt, u.AccessToken, u.AvatarUrl, u.Id)
-	return err
+	rows, err := db.Query(activeStageEntriesStmt)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryType string
+		e := &deploy.LogEntry{}
+
+		err = rows.Scan(&e.DeploymentId, &entryType, &e.Message, &e.Timestamp)
+		if err != nil {
+			return entries, err
+		}
+		e.EntryType = deploy.LogEntryType(entryType)
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
 }
 
-func getUser(db *sql.DB, id int) (*models.User, error) {
-	u := &models.User{}
+// getLiveHostGroup returns the blue-green host group currently receiving the
+// traffic of the target or an empty string if no group is live yet.
+func getLiveHostGroup(db *sql.DB, applicationName, targetName string) (string, error) {
+	var group string
 
-	err := db.QueryRow(userStmt, id).Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.ApiToken)
-	if err != nil {
-		return nil, err
+	err := db.QueryRow(liveHostGroupStmt, applicationName, targetName).Scan(&group)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
 
-	return u, nil
+	return group, err
 }
 
-func getUserByApiToken(db *sql.DB, token string) (*models.User, error) {
-	u := &models.User{}
+func updateDeploymentHostGroup(db *sql.DB, d *models.Deployment, group string) error {
+	updatedAt := time.Now()
 
-	err := db.QueryRow(userApiTokenStmt, token).Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.ApiToken)
+	_, err := db.Exec(deploymentUpdateHostGroupStmt, group, updatedAt, d.Id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return u, nil
+	d.HostGroup = group
+	d.UpdatedAt = updatedAt
+	return nil
 }
 
-func getUsers(db *sql.DB, ids []int) ([]*models.User, error) {
-	users := []*models.User{}
+func setLiveHostGroup(db *sql.DB, d *models.Deployment) error {
+	_, err := db.Exec(liveHostGroupReplaceStmt, d.ApplicationName, d.TargetName,
+		d.HostGroup, d.Id, time.Now())
+	return err
+}
 
-	if len(ids) == 0 {
-		return users, nil
-	}
+func failUnfinishedDeployments(db *sql.DB) error {
+	_, err := db.Exec(deploymentFailUnfinishedStmt,
+		string(models.DEPLOYMENT_FAILED), time.Now(), string(models.DEPLOYMENT_NEW),
+		string(models.DEPLOYMENT_ACTIVE), string(models.DEPLOYMENT_QUEUED))
+	return err
+}
 
-	stmt := selectUsersStmt(ids)
+// startHostStage saves that the host started the command of the stage.
+func startHostStage(db *sql.DB, deploymentId int, host string, stage models.DeploymentStage, command string) error {
+	_, err := db.Exec(hostStageStartStmt, deploymentId, host, string(stage), command, time.Now())
+	return err
+}
 
-	args := []interface{}{}
-	for _, id := range ids {
-		args = append(args, id)
-	}
+// finishHostStageCommand saves that the last command the host started in the
+// stage succeeded.
+func finishHostStageCommand(db *sql.DB, deploymentId int, host string, stage models.DeploymentStage) error {
+	_, err := db.Exec(hostStageCommandDoneStmt, time.Now(), deploymentId, host, string(stage))
+	return err
+}
 
-	rows, err := db.Query(stmt, args...)
+// failHostStage saves that a command of the stage failed on the host.
+func failHostStage(db *sql.DB, deploymentId int, host string, stage models.DeploymentStage) error {
+	_, err := db.Exec(hostStageFailStmt, time.Now(), deploymentId, host, string(stage))
+	return err
+}
+
+// finishHostStages sets the state of the hosts still running the stage.
+func finishHostStages(db *sql.DB, deploymentId int, stage models.DeploymentStage, state models.HostStageState) error {
+	_, err := db.Exec(hostStagesFinishStmt, string(state), time.Now(), deploymentId, string(stage))
+	return err
+}
+
+// interruptHostStages marks the stages still running on the hosts of the
+// deployment as interrupted.
+func interruptHostStages(db *sql.DB, deploymentId int) error {
+	_, err := db.Exec(hostStagesInterruptStmt, time.Now(), deploymentId)
+	return err
+}
+
+// getHostStages returns the progress of the stages on the hosts of the
+// deployment, in the order they were started.
+func getHostStages(db *sql.DB, deploymentId int) ([]*models.HostStage, error) {
+	hostStages := []*models.HostStage{}
+
+	rows, err := db.Query(hostStagesStmt, deploymentId)
 	if err != nil {
-		return users, err
+		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		u := &models.User{}
+		var stage, state string
+		hs := &models.HostStage{}
 
-		err = rows.Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl)
+		err = rows.Scan(&hs.DeploymentId, &hs.Host, &stage, &state, &hs.LastCommand, &hs.LastCommandDone, &hs.UpdatedAt)
 		if err != nil {
-			return users, err
+			return nil, err
 		}
+		hs.Stage = models.DeploymentStage(stage)
+		hs.State = models.HostStageState(state)
 
-		users = append(users, u)
+		hostStages = append(hostStages, hs)
 	}
 
-	if err := rows.Err(); err != nil {
-		return users, err
-	}
+	return hostStages, rows.Err()
+}
+
+// getDeploymentStageEntries returns the STAGE_START, STAGE_SUCCESS and
+// STAGE_FAIL log entries of the deployment in the order they were written.
+func getDeploymentStageEntries(db *sql.DB, d *models.Deployment) ([]*deploy.LogEntry, error) {
+	entries := []*deploy.LogEntry{}
+
+	rows, err := db.Query(deploymentStageEntriesStmt, d.Id)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryType string
+		e := &deploy.LogEntry{}
+
+		err = rows.Scan(&e.DeploymentId, &entryType, &e.Message, &e.Timestamp)
+		if err != nil {
+			return entries, err
+		}
+		e.EntryType = deploy.LogEntryType(entryType)
+
+		entries = append(entries, e)
+	}
+
+	return entries, rows.Err()
+}
+
+func createLogEntry(db *sql.DB, entry *deploy.LogEntry) error {
+	result, err := db.Exec(logEntryInsertStmt, entry.DeploymentId, entry.Sequence,
+		string(entry.EntryType), entry.Origin, entry.Message,
+		entry.Timestamp, entry.ExitCode, int64(entry.Duration), time.Now())
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	entry.Id = int(id)
+
+	return err
+}
+
+func getDeploymentLogEntries(db *sql.DB, d *models.Deployment) ([]*deploy.LogEntry, error) {
+	entries := []*deploy.LogEntry{}
+
+	err := eachDeploymentLogEntry(db, d, func(e *deploy.LogEntry) error {
+		entries = append(entries, e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// eachDeploymentLogEntry calls fn with the log entries of the deployment one
+// by one, so long logs can be streamed without loading them completely. It
+// stops at the first error returned by fn. The entries are returned in the
+// order they were saved, which is the order of their sequences. The entries
+// saved before they had a sequence are numbered in order.
+func eachDeploymentLogEntry(db *sql.DB, d *models.Deployment, fn func(*deploy.LogEntry) error) error {
+	rows, err := db.Query(deploymentLogEntriesStmt, d.Id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	sequence := 0
+	for rows.Next() {
+		var entryType string
+		var duration int64
+		e := &deploy.LogEntry{}
+
+		err = rows.Scan(&e.Id, &e.DeploymentId, &e.Sequence, &entryType, &e.Origin, &e.Message, &e.Timestamp, &e.ExitCode, &duration)
+		if err != nil {
+			return err
+		}
+
+		if e.Sequence == 0 {
+			e.Sequence = sequence + 1
+		}
+		sequence = e.Sequence
+
+		e.EntryType = deploy.LogEntryType(entryType)
+		e.Duration = time.Duration(duration)
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// getDeploymentLogEntriesPage returns up to limit entries of the deployment
+// matching the filter, oldest first: the last ones before the sequence before,
+// or the last ones of the log if before is 0. Only one page is kept in memory
+// while the log is read.
+func getDeploymentLogEntriesPage(db *sql.DB, d *models.Deployment, filter *logEntryFilter, before, limit int) ([]*deploy.LogEntry, error) {
+	page := []*deploy.LogEntry{}
+
+	err := eachDeploymentLogEntry(db, d, func(e *deploy.LogEntry) error {
+		if before > 0 && e.Sequence >= before {
+			return errStopLogEntries
+		}
+		if !filter.Matches(e) {
+			return nil
+		}
+		page = append(page, e)
+		if len(page) > limit {
+			page = page[1:]
+		}
+		return nil
+	})
+	if err == errStopLogEntries {
+		err = nil
+	}
+
+	return page, err
+}
+
+// errStopLogEntries stops reading the log once the page is complete.
+var errStopLogEntries = errors.New("page complete")
+
+// newLogEntrySaver returns the listener saving the log entries of all
+// deployments. The entries that piled up in its buffer while it was saving
+// are saved together in one transaction, so it keeps up with chatty
+// deployments.
+func newLogEntrySaver(db *sql.DB) deploy.Listener {
+	fn := func(logs <-chan deploy.LogEntry) {
+		batch := make([]*deploy.LogEntry, 0, logEntryBatchSize)
+		for entry := range logs {
+			entry := entry
+			batch = append(batch, &entry)
+			batch = appendBufferedLogEntries(batch, logs)
+
+			if err := createLogEntries(db, batch); err != nil {
+				slog.Error("error saving log entries", "deployment.id", entry.DeploymentId, "entries", len(batch), "err", err)
+			}
+			batch = batch[:0]
+		}
+	}
+
+	return fn
+}
+
+// appendBufferedLogEntries appends the entries that can be received without
+// waiting, up to logEntryBatchSize entries in total.
+func appendBufferedLogEntries(batch []*deploy.LogEntry, logs <-chan deploy.LogEntry) []*deploy.LogEntry {
+	for len(batch) < logEntryBatchSize {
+		select {
+		case entry, ok := <-logs:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, &entry)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// createLogEntries saves the entries in one transaction.
+func createLogEntries(db *sql.DB, entries []*deploy.LogEntry) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(logEntryInsertStmt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	createdAt := time.Now()
+	for _, entry := range entries {
+		result, err := stmt.Exec(entry.DeploymentId, entry.Sequence, string(entry.EntryType), entry.Origin, entry.Message,
+			entry.Timestamp, entry.ExitCode, int64(entry.Duration), createdAt)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		id, err := result.LastInsertId()
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		entry.Id = int(id)
+	}
+
+	return tx.Commit()
+}
+
+func createUser(db *sql.DB, u *models.User) error {
+	u.ApiToken = uuid.New()
+	createdAt := time.Now()
+	u.ApiTokenCreatedAt = &createdAt
+
+	// Let the database assign an id to users that don't have one yet
+	var id interface{}
+	if u.Id != 0 {
+		id = u.Id
+	}
+
+	accessToken, refreshToken, err := encryptUserOAuthTokens(u)
+	if err != nil {
+		return err
+	}
+	apiToken, err := encryptField(u.ApiToken)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(userInsertStmt, id, u.Name, accessToken, u.AvatarUrl,
+		apiToken, hashApiToken(u.ApiToken), u.Provider, u.ProviderId, createdAt, refreshToken, u.TokenExpiry)
+	if err != nil {
+		return err
+	}
+
+	userId, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	u.Id = int(userId)
+
+	return nil
+}
+
+func updateUser(db *sql.DB, u *models.User) error {
+	accessToken, refreshToken, err := encryptUserOAuthTokens(u)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(userUpdateStmt, accessToken, u.AvatarUrl, refreshToken, u.TokenExpiry, u.Id)
+	return err
+}
+
+// updateUserToken saves a refreshed access token of the user.
+func updateUserToken(db *sql.DB, u *models.User) error {
+	accessToken, refreshToken, err := encryptUserOAuthTokens(u)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(userTokenUpdateStmt, accessToken, refreshToken, u.TokenExpiry, u.Id)
+	return err
+}
+
+func encryptUserOAuthTokens(u *models.User) (string, string, error) {
+	accessToken, err := encryptField(u.AccessToken)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := encryptField(u.RefreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+func getUser(db *sql.DB, id int) (*models.User, error) {
+	u := &models.User{}
+
+	err := db.QueryRow(userStmt, id).Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.ApiToken, &u.Provider, &u.ProviderId, &u.RefreshToken, &u.TokenExpiry, &u.DeactivatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decryptUserTokens(u); err != nil {
+		return nil, err
+	}
+
+	u.Groups, err = getUserGroups(db, u.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Preferences, err = getUserPreferences(db, u.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func getUserByApiToken(db *sql.DB, token string) (*models.User, error) {
+	// Revoked tokens are saved as empty strings
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+
+	u := &models.User{}
+
+	err := db.QueryRow(userApiTokenStmt, hashApiToken(token)).Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.ApiToken, &u.Provider, &u.ProviderId, &u.RefreshToken, &u.TokenExpiry, &u.DeactivatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decryptUserTokens(u); err != nil {
+		return nil, err
+	}
+
+	u.Groups, err = getUserGroups(db, u.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Preferences, err = getUserPreferences(db, u.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// regenerateApiToken replaces the API token of the user with a new one.
+func regenerateApiToken(db *sql.DB, u *models.User) error {
+	return setApiToken(db, u, uuid.New())
+}
+
+func setApiToken(db *sql.DB, u *models.User, token string) error {
+	createdAt := time.Now()
+
+	encrypted, err := encryptField(token)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(userApiTokenUpdateStmt, encrypted, hashApiToken(token), createdAt, u.Id)
+	if err != nil {
+		return err
+	}
+
+	u.ApiToken = token
+	u.ApiTokenCreatedAt = &createdAt
+	u.ApiTokenLastUsedAt = nil
+	return nil
+}
+
+// revokeApiToken removes the API token of the user, so it can't be used
+// until a new one is generated.
+func revokeApiToken(db *sql.DB, u *models.User) error {
+	_, err := db.Exec(userApiTokenUpdateStmt, "", "", nil, u.Id)
+	if err != nil {
+		return err
+	}
+
+	u.ApiToken = ""
+	u.ApiTokenCreatedAt = nil
+	u.ApiTokenLastUsedAt = nil
+	return nil
+}
+
+func loadApiTokenUsage(db *sql.DB, u *models.User) error {
+	return db.QueryRow(userApiTokenUsageStmt, u.Id).Scan(&u.ApiTokenCreatedAt, &u.ApiTokenLastUsedAt)
+}
+
+func touchApiToken(db *sql.DB, u *models.User) error {
+	_, err := db.Exec(userApiTokenUsedStmt, time.Now(), u.Id)
+	return err
+}
+
+func getUserByProvider(db *sql.DB, provider, providerId string) (*models.User, error) {
+	u := &models.User{}
+
+	err := db.QueryRow(userProviderStmt, provider, providerId).Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.ApiToken, &u.Provider, &u.ProviderId, &u.RefreshToken, &u.TokenExpiry, &u.DeactivatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decryptUserTokens(u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// getUserByName loads the user with the QualifiedName, who logged in with a
+// login provider. Service accounts aren't returned.
+func getUserByName(db *sql.DB, qualifiedName string) (*models.User, error) {
+	provider, name := models.SplitQualifiedName(qualifiedName)
+	if provider == SERVICE_ACCOUNT_PROVIDER {
+		return nil, sql.ErrNoRows
+	}
+
+	var id int
+	err := db.QueryRow(userIdByNameStmt, name, provider).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return getUser(db, id)
+}
+
+func getUsersByProvider(db *sql.DB, provider string) ([]*models.User, error) {
+	return queryUsers(db, usersByProviderStmt, provider)
+}
+
+func getAllUsers(db *sql.DB) ([]*models.User, error) {
+	return queryUsers(db, allUsersStmt)
+}
+
+func queryUsers(db *sql.DB, stmt string, args ...interface{}) ([]*models.User, error) {
+	users := []*models.User{}
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u := &models.User{}
+		err = rows.Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.ApiToken, &u.Provider, &u.ProviderId, &u.RefreshToken, &u.TokenExpiry, &u.DeactivatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if err := decryptUserTokens(u); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+
+	return users, rows.Err()
+}
+
+// deactivateUser marks the user as deactivated. Deactivated users can't log
+// in or use their API token, but their deployments are kept.
+func deactivateUser(db *sql.DB, u *models.User) error {
+	deactivatedAt := time.Now()
+
+	_, err := db.Exec(userDeactivatedStmt, deactivatedAt, u.Id)
+	if err != nil {
+		return err
+	}
+
+	u.DeactivatedAt = &deactivatedAt
+	return nil
+}
+
+func reactivateUser(db *sql.DB, u *models.User) error {
+	_, err := db.Exec(userDeactivatedStmt, nil, u.Id)
+	if err != nil {
+		return err
+	}
+
+	u.DeactivatedAt = nil
+	return nil
+}
+
+func getUserGroups(db *sql.DB, userId int) ([]string, error) {
+	groups := []string{}
+
+	rows, err := db.Query(userGroupsStmt, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var group string
+		if err := rows.Scan(&group); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
+
+// setUserGroups replaces the saved groups of the user with u.Groups
+func setUserGroups(db *sql.DB, u *models.User) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(userGroupsDeleteStmt, u.Id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, group := range u.Groups {
+		_, err = tx.Exec(userGroupInsertStmt, u.Id, group)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// getUserPreferences returns the saved preferences of the user, the defaults
+// if the user never saved them.
+func getUserPreferences(db *sql.DB, userId int) (models.UserPreferences, error) {
+	p := models.UserPreferences{}
+
+	err := db.QueryRow(userPreferencesStmt, userId).Scan(&p.Theme, &p.TimeZone, &p.DefaultApplication, &p.DeploymentNotifications, &p.Language)
+	if err == sql.ErrNoRows {
+		return p, nil
+	}
+	return p, err
+}
+
+// setUserPreferences saves u.Preferences
+func setUserPreferences(db *sql.DB, u *models.User) error {
+	p := u.Preferences
+	_, err := db.Exec(userPreferencesReplaceStmt, u.Id, p.Theme, p.TimeZone, p.DefaultApplication, p.DeploymentNotifications, p.Language)
+	return err
+}
+
+func createUserSession(db *sql.DB, s *models.UserSession) error {
+	now := time.Now()
+	s.Id = uuid.New()
+	s.CreatedAt = now
+	s.LastSeenAt = now
+
+	_, err := db.Exec(userSessionInsertStmt, s.Id, s.UserId, s.SourceIP, s.UserAgent, s.CreatedAt, s.LastSeenAt)
+	return err
+}
+
+// getUserSession returns the session if it hasn't expired, nil otherwise.
+func getUserSession(db *sql.DB, id string, ttl time.Duration) (*models.UserSession, error) {
+	s := &models.UserSession{}
+
+	err := db.QueryRow(userSessionStmt, id, time.Now().Add(-ttl)).Scan(&s.Id, &s.UserId, &s.SourceIP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// getUserSessions returns the sessions of the user that haven't expired, the
+// last used first.
+func getUserSessions(db *sql.DB, userId int, ttl time.Duration) ([]*models.UserSession, error) {
+	sessions := []*models.UserSession{}
+
+	rows, err := db.Query(userSessionsStmt, userId, time.Now().Add(-ttl))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &models.UserSession{}
+		err = rows.Scan(&s.Id, &s.UserId, &s.SourceIP, &s.UserAgent, &s.CreatedAt, &s.LastSeenAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+
+	return sessions, rows.Err()
+}
+
+func touchUserSession(db *sql.DB, s *models.UserSession, sourceIP string) error {
+	s.LastSeenAt = time.Now()
+	s.SourceIP = sourceIP
+
+	_, err := db.Exec(userSessionTouchStmt, s.LastSeenAt, s.SourceIP, s.Id)
+	return err
+}
+
+// deleteUserSession logs the session of the user out. Sessions of other
+// users are left alone.
+func deleteUserSession(db *sql.DB, userId int, id string) error {
+	_, err := db.Exec(userSessionDeleteStmt, userId, id)
+	return err
+}
+
+// deleteOtherUserSessions logs the user out everywhere except in the session
+// with the id.
+func deleteOtherUserSessions(db *sql.DB, userId int, id string) error {
+	_, err := db.Exec(userOtherSessionsDeleteStmt, userId, id)
+	return err
+}
+
+// deleteUserSessions logs the user out everywhere, e.g. when a laptop has
+// been lost.
+func deleteUserSessions(db *sql.DB, userId int) error {
+	_, err := db.Exec(userSessionsDeleteStmt, userId)
+	return err
+}
+
+func deleteExpiredUserSessions(db *sql.DB, ttl time.Duration) error {
+	_, err := db.Exec(expiredUserSessionsDeleteStmt, time.Now().Add(-ttl))
+	return err
+}
+
+func getUsers(db *sql.DB, ids []int) ([]*models.User, error) {
+	users := []*models.User{}
+
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	stmt := selectUsersStmt(ids)
+
+	args := []interface{}{}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		u := &models.User{}
+
+		err = rows.Scan(&u.Id, &u.Name, &u.AccessToken, &u.AvatarUrl, &u.Provider, &u.ProviderId, &u.DeactivatedAt)
+		if err != nil {
+			return users, err
+		}
+		if err := decryptUserTokens(u); err != nil {
+			return users, err
+		}
+
+		users = append(users, u)
+	}
+
+	if err := rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+func createOrUpdateUser(db *sql.DB, u *models.User) error {
+	saved, err := getUserByProvider(db, u.Provider, u.ProviderId)
+	if saved != nil && err == nil {
+		u.Id = saved.Id
+		u.ApiToken = saved.ApiToken
+		u.DeactivatedAt = saved.DeactivatedAt
+		err = updateUser(db, u)
+		return err
+	}
+	err = createUser(db, u)
+	return err
+}
+
+func loadDeploymentsUsers(db *sql.DB, deployments []*models.Deployment) error {
+	// Set up map to have unique id->pointer mappings
+	uniqueUserIds := map[int]*models.User{}
+	for _, d := range deployments {
+		uniqueUserIds[d.UserId] = nil
+	}
+
+	// Collect the now unique (keys in map are unique)
+	userIds := []int{}
+	for k := range uniqueUserIds {
+		userIds = append(userIds, k)
+	}
+
+	users, err := getUsers(db, userIds)
+	if err != nil {
+		return err
+	}
+
+	// Set up the pointers to the users
+	for _, u := range users {
+		uniqueUserIds[u.Id] = u
+	}
+
+	// Go over deployments again, set pointers to correct user
+	for _, d := range deployments {
+		d.User = uniqueUserIds[d.UserId]
+	}
+
+	return nil
+}
+
+func selectUsersStmt(ids []int) string {
+	tmpl := "SELECT id, name, access_token, avatar_url, provider, provider_id, deactivated_at FROM users WHERE id IN (?"
+	stmt := tmpl + strings.Repeat(",?", len(ids)-1) + ");"
+	return stmt
+}
+
+func activeDeploymentExists(tx *sql.Tx, applicationName, targetName string) (bool, error) {
+	var state string
+	err := tx.QueryRow(activeDeploymentsStmt, applicationName, targetName).Scan(&state)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+func unfinishedDeploymentExists(tx *sql.Tx, applicationName, targetName string) (bool, error) {
+	var state string
+	err := tx.QueryRow(unfinishedDeploymentsStmt, applicationName, targetName).Scan(&state)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+func queryDeploymentRow(db *sql.DB, query string, args ...interface{}) (*models.Deployment, error) {
+	d := &models.Deployment{}
+	var state, stages string
+	var updatedAt *time.Time
+
+	err := db.QueryRow(query, args...).Scan(&d.Id, &d.UserId, &d.ApplicationName,
+		&d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt,
+		&d.RetryOf, &d.HostGroup, &d.CIOverrideReason, &d.PullRequest, &d.Tag, &updatedAt, &d.RedeployOf, &stages, &d.RequestId)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.State = models.DeploymentState(state)
+	setUpdatedAt(d, updatedAt)
+	d.Stages = splitDeploymentStages(stages)
+
+	return d, nil
+}
+
+// The stages of a deployment are saved comma separated. Deployments created
+// before the stages were saved have none.
+func joinDeploymentStages(stages []models.DeploymentStage) string {
+	names := make([]string, len(stages))
+	for i, s := range stages {
+		names[i] = string(s)
+	}
+	return strings.Join(names, ",")
+}
+
+func splitDeploymentStages(s string) []models.DeploymentStage {
+	if s == "" {
+		return nil
+	}
+
+	stages := []models.DeploymentStage{}
+	for _, name := range strings.Split(s, ",") {
+		stages = append(stages, models.DeploymentStage(name))
+	}
+	return stages
+}
+
+// splitOverrides reads the overrides saved as lines by FormatOverrides.
+func splitOverrides(s string) map[string]string {
+	if s == "" {
+		return nil
+	}
+
+	overrides := make(map[string]string)
+	for _, line := range strings.Split(s, "\n") {
+		name, value, _ := strings.Cut(line, "=")
+		overrides[name] = value
+	}
+	return overrides
+}
+
+// setUpdatedAt falls back to the creation time for deployments that haven't
+// been updated since updated_at was added.
+func setUpdatedAt(d *models.Deployment, updatedAt *time.Time) {
+	d.UpdatedAt = d.CreatedAt
+	if updatedAt != nil {
+		d.UpdatedAt = *updatedAt
+	}
+}
+
+func createAuditEvent(db *sql.DB, e *models.AuditEvent) error {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+
+	result, err := db.Exec(auditEventInsertStmt, e.UserId, string(e.Action), e.Subject, e.SourceIP, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.Id = int(id)
+
+	return nil
+}
+
+// getFilteredAuditEvents returns the matching audit events, newest first.
+func getFilteredAuditEvents(db *sql.DB, f *auditFilter) ([]*models.AuditEvent, error) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+
+	if f.UserName != "" {
+		conditions = append(conditions, "user_id IN (SELECT id FROM users WHERE name = ?)")
+		args = append(args, f.UserName)
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, string(f.Action))
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.From)
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, f.To)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	stmt := fmt.Sprintf(filteredAuditEventsStmt, strings.Join(conditions, " AND "))
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*models.AuditEvent{}
+	for rows.Next() {
+		var action string
+		e := &models.AuditEvent{}
+
+		err := rows.Scan(&e.Id, &e.UserId, &action, &e.Subject, &e.SourceIP, &e.CreatedAt)
+		if err != nil {
+			return events, err
+		}
+		e.Action = models.AuditAction(action)
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func loadAuditEventsUsers(db *sql.DB, events []*models.AuditEvent) error {
+	usersById := map[int]*models.User{}
+	userIds := []int{}
+	for _, e := range events {
+		if _, ok := usersById[e.UserId]; !ok {
+			usersById[e.UserId] = nil
+			userIds = append(userIds, e.UserId)
+		}
+	}
+
+	users, err := getUsers(db, userIds)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		usersById[u.Id] = u
+	}
+	for _, e := range events {
+		e.User = usersById[e.UserId]
+	}
+
+	return nil
+}
+
+// createDeployFreeze saves the freeze, replacing an existing freeze of the
+// same target.
+func createDeployFreeze(db *sql.DB, f *models.DeployFreeze) error {
+	if f.CreatedAt.IsZero() {
+		f.CreatedAt = time.Now()
+	}
+
+	result, err := db.Exec(deployFreezeInsertStmt, f.ApplicationName, f.TargetName, f.UserId, f.Reason, f.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	f.Id = int(id)
+
+	return nil
+}
+
+// getDeployFreezes returns the freezes of the application, the one of all
+// targets first.
+func getDeployFreezes(db *sql.DB, a *models.Application) ([]*models.DeployFreeze, error) {
+	freezes := []*models.DeployFreeze{}
+
+	rows, err := db.Query(deployFreezesStmt, a.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		f := &models.DeployFreeze{}
+		err = rows.Scan(&f.Id, &f.ApplicationName, &f.TargetName, &f.UserId, &f.Reason, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		freezes = append(freezes, f)
+	}
+
+	return freezes, rows.Err()
+}
+
+// deleteDeployFreeze lifts the freeze. It returns sql.ErrNoRows if the
+// application has no freeze with the id.
+func deleteDeployFreeze(db *sql.DB, a *models.Application, id int) error {
+	result, err := db.Exec(deployFreezeDeleteStmt, a.Name, id)
+	if err != nil {
+		return err
+	}
 
-	return users, nil
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
 }
 
-func createOrUpdateUser(db *sql.DB, u *models.User) error {
-	saved, err := getUser(db, u.Id)
-	if saved != nil && err == nil {
-		err = updateUser(db, u)
+func loadDeployFreezesUsers(db *sql.DB, freezes []*models.DeployFreeze) error {
+	for _, f := range freezes {
+		u, err := getUser(db, f.UserId)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		f.User = u
+	}
+
+	return nil
+}
+
+// createDeployPreset saves the preset, replacing an existing preset of the
+// application with the same name.
+func createDeployPreset(db *sql.DB, p *models.DeployPreset) error {
+	if p.CreatedAt.IsZero() {
+		p.CreatedAt = time.Now()
+	}
+
+	result, err := db.Exec(deployPresetInsertStmt, p.ApplicationName, p.Name, p.TargetName, p.Branch, p.CommentPrefix, joinDeploymentStages(p.Stages), p.FormatOverrides(), p.UserId, p.CreatedAt)
+	if err != nil {
 		return err
 	}
-	err = createUser(db, u)
-	return err
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	p.Id = int(id)
+
+	return nil
 }
 
-func loadDeploymentsUsers(db *sql.DB, deployments []*models.Deployment) error {
-	// Set up map to have unique id->pointer mappings
-	uniqueUserIds := map[int]*models.User{}
-	for _, d := range deployments {
-		uniqueUserIds[d.UserId] = nil
+// getDeployPresets returns the presets of the application ordered by name.
+func getDeployPresets(db *sql.DB, a *models.Application) ([]*models.DeployPreset, error) {
+	presets := []*models.DeployPreset{}
+
+	rows, err := db.Query(deployPresetsStmt, a.Name)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	// Collect the now unique (keys in map are unique)
-	userIds := []int{}
-	for k := range uniqueUserIds {
-		userIds = append(userIds, k)
+	for rows.Next() {
+		p := &models.DeployPreset{}
+		var stages, overrides string
+		err = rows.Scan(&p.Id, &p.ApplicationName, &p.Name, &p.TargetName, &p.Branch, &p.CommentPrefix, &stages, &overrides, &p.UserId, &p.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		p.Stages = splitDeploymentStages(stages)
+		p.Overrides = splitOverrides(overrides)
+		presets = append(presets, p)
 	}
 
-	users, err := getUsers(db, userIds)
+	return presets, rows.Err()
+}
+
+// deleteDeployPreset deletes the preset. It returns sql.ErrNoRows if the
+// application has no preset with the id.
+func deleteDeployPreset(db *sql.DB, a *models.Application, id int) error {
+	result, err := db.Exec(deployPresetDeleteStmt, a.Name, id)
 	if err != nil {
 		return err
 	}
 
-	// Set up the pointers to the users
-	for _, u := range users {
-		uniqueUserIds[u.Id] = u
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
 	}
 
-	// Go over deployments again, set pointers to correct user
-	for _, d := range deployments {
-		d.User = uniqueUserIds[d.UserId]
+	return nil
+}
+
+// getManagedApplications returns the applications added by admins, ordered
+// by name, with their definitions decrypted.
+func getManagedApplications(db *sql.DB) ([]*models.ManagedApplication, error) {
+	applications := []*models.ManagedApplication{}
+
+	rows, err := db.Query(managedApplicationsStmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a := &models.ManagedApplication{}
+		err = rows.Scan(&a.Name, &a.Definition, &a.UserId, &a.CreatedAt, &a.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		a.Definition, err = decryptField(a.Definition)
+		if err != nil {
+			return nil, fmt.Errorf("reading the definition of application %s failed: %s", a.Name, err)
+		}
+		applications = append(applications, a)
+	}
+
+	return applications, rows.Err()
+}
+
+// saveManagedApplication adds the application or replaces its definition.
+// The definition contains the SSH keys of the targets, so it's encrypted if
+// an encryption_key is configured.
+func saveManagedApplication(db *sql.DB, a *models.ManagedApplication) error {
+	definition, err := encryptField(a.Definition)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	_, err = db.Exec(managedApplicationSaveStmt, a.Name, definition, a.UserId, a.Name, now, now)
+	if err != nil {
+		return err
+	}
+
+	a.UpdatedAt = now
+	return db.QueryRow(managedApplicationCreatedAtStmt, a.Name).Scan(&a.CreatedAt)
+}
+
+// deleteManagedApplication removes the application. It returns
+// sql.ErrNoRows if there is no application with the name.
+func deleteManagedApplication(db *sql.DB, name string) error {
+	result, err := db.Exec(managedApplicationDeleteStmt, name)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
 	}
 
 	return nil
 }
 
-func selectUsersStmt(ids []int) string {
-	tmpl := "SELECT id, name, access_token, avatar_url FROM users WHERE id IN (?"
-	stmt := tmpl + strings.Repeat(",?", len(ids)-1) + ");"
-	return stmt
+// createDelivery saves the attempt to deliver a notification. The URL, the
+// headers and the payload contain secrets, e.g. API keys, so they're
+// encrypted if an encryption_key is configured.
+func createDelivery(db *sql.DB, d *models.Delivery) error {
+	if d.CreatedAt.IsZero() {
+		d.CreatedAt = time.Now()
+	}
+
+	header, err := json.Marshal(d.Header)
+	if err != nil {
+		return err
+	}
+
+	var encrypted [3]string
+	for i, value := range []string{d.URL, string(header), string(d.Payload)} {
+		encrypted[i], err = encryptField(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	result, err := db.Exec(deliveryInsertStmt, d.DeploymentId, d.Notifier, d.Method,
+		encrypted[0], encrypted[1], encrypted[2], d.PayloadHash, d.ExpectedStatus,
+		d.StatusCode, d.Error, int64(d.Latency), d.RetryOf, d.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	d.Id = int(id)
+
+	return nil
 }
 
-func activeDeploymentExists(tx *sql.Tx, applicationName, targetName string) (bool, error) {
-	var state string
-	err := tx.QueryRow(activeDeploymentsStmt, applicationName, targetName).Scan(&state)
-	switch {
-	case err == sql.ErrNoRows:
-		return false, nil
-	case err != nil:
-		return false, err
-	default:
-		return true, nil
+func getDelivery(db *sql.DB, id int) (*models.Delivery, error) {
+	return scanDelivery(db.QueryRow(deliveryStmt, id))
+}
+
+// getFilteredDeliveries returns the matching deliveries, newest first.
+func getFilteredDeliveries(db *sql.DB, f *deliveryFilter) ([]*models.Delivery, error) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+
+	if f.Notifier != "" {
+		conditions = append(conditions, "notifier = ?")
+		args = append(args, f.Notifier)
+	}
+	if f.Failed {
+		conditions = append(conditions, deliveryFailedCondition)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	stmt := fmt.Sprintf(filteredDeliveriesStmt, strings.Join(conditions, " AND "))
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []*models.Delivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
 	}
+
+	return deliveries, rows.Err()
 }
 
-func queryDeploymentRow(db *sql.DB, query string, args ...interface{}) (*models.Deployment, error) {
-	d := &models.Deployment{}
-	var state string
+func scanDelivery(row interface{ Scan(...interface{}) error }) (*models.Delivery, error) {
+	var (
+		d       = &models.Delivery{}
+		header  string
+		payload string
+		latency int64
+	)
+
+	err := row.Scan(&d.Id, &d.DeploymentId, &d.Notifier, &d.Method, &d.URL, &header,
+		&payload, &d.PayloadHash, &d.ExpectedStatus, &d.StatusCode, &d.Error, &latency,
+		&d.RetryOf, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	d.Latency = time.Duration(latency)
 
-	err := db.QueryRow(query, args...).Scan(&d.Id, &d.UserId, &d.ApplicationName,
-		&d.TargetName, &d.CommitSha, &d.Branch, &d.Comment, &state, &d.CreatedAt)
+	d.URL, err = decryptField(d.URL)
+	if err != nil {
+		return nil, fmt.Errorf("reading delivery %d failed: %s", d.Id, err)
+	}
+	header, err = decryptField(header)
+	if err != nil {
+		return nil, fmt.Errorf("reading delivery %d failed: %s", d.Id, err)
+	}
+	payload, err = decryptField(payload)
+	if err != nil {
+		return nil, fmt.Errorf("reading delivery %d failed: %s", d.Id, err)
+	}
+	d.Payload = []byte(payload)
+
+	if err := json.Unmarshal([]byte(header), &d.Header); err != nil {
+		return nil, fmt.Errorf("reading delivery %d failed: %s", d.Id, err)
+	}
+
+	return d, nil
+}
+
+// saveDigestSubscription subscribes the user to the digests of the
+// application, or changes the email address of the subscription.
+func saveDigestSubscription(db *sql.DB, s *models.DigestSubscription) error {
+	s.Token = uuid.New()
+	s.CreatedAt = time.Now()
+
+	res, err := db.Exec(digestSubscriptionSaveStmt, s.UserId, s.ApplicationName, s.Email, s.Token, s.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	s.Id = int(id)
+
+	return nil
+}
+
+// getUserDigestSubscriptions returns the subscriptions of the user, by
+// application name.
+func getUserDigestSubscriptions(db *sql.DB, userId int) (map[string]*models.DigestSubscription, error) {
+	subscriptions, err := queryDigestSubscriptions(db, userDigestSubscriptionsStmt, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	byApplication := map[string]*models.DigestSubscription{}
+	for _, s := range subscriptions {
+		byApplication[s.ApplicationName] = s
+	}
+	return byApplication, nil
+}
+
+// getApplicationDigestSubscriptions returns the subscriptions of the users
+// that aren't deactivated to the digests of the application.
+func getApplicationDigestSubscriptions(db *sql.DB, a *models.Application) ([]*models.DigestSubscription, error) {
+	return queryDigestSubscriptions(db, applicationDigestSubscriptionsStmt, a.Name)
+}
+
+// getDigestSubscriptionByToken returns the subscription of the unsubscribe
+// link, or nil if it doesn't exist (anymore).
+func getDigestSubscriptionByToken(db *sql.DB, token string) (*models.DigestSubscription, error) {
+	subscriptions, err := queryDigestSubscriptions(db, digestSubscriptionByTokenStmt, token)
+	if err != nil || len(subscriptions) == 0 {
+		return nil, err
+	}
+	return subscriptions[0], nil
+}
+
+func deleteDigestSubscription(db *sql.DB, s *models.DigestSubscription) error {
+	_, err := db.Exec(digestSubscriptionDeleteStmt, s.Id)
+	return err
+}
+
+func queryDigestSubscriptions(db *sql.DB, stmt string, args ...interface{}) ([]*models.DigestSubscription, error) {
+	subscriptions := []*models.DigestSubscription{}
+
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s := &models.DigestSubscription{}
+		err = rows.Scan(&s.Id, &s.UserId, &s.ApplicationName, &s.Email, &s.Token, &s.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+
+	return subscriptions, rows.Err()
+}
+
+// saveCalendarToken creates the token of the calendar feed links of the user,
+// replacing the previous one.
+func saveCalendarToken(db *sql.DB, userId int) (string, error) {
+	token := uuid.New()
+	_, err := db.Exec(calendarTokenSaveStmt, userId, token, time.Now())
+	return token, err
+}
+
+// getCalendarToken returns the token of the calendar feed links of the user,
+// or an empty string if the user has none.
+func getCalendarToken(db *sql.DB, userId int) (string, error) {
+	var token string
+	err := db.QueryRow(calendarTokenStmt, userId).Scan(&token)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return token, err
+}
+
+// getUserByCalendarToken returns the user of the calendar feed token, or nil
+// if the token doesn't exist (anymore).
+func getUserByCalendarToken(db *sql.DB, token string) (*models.User, error) {
+	var userId int
+	err := db.QueryRow(calendarTokenUserStmt, token).Scan(&userId)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	d.State = models.DeploymentState(state)
+	return getUser(db, userId)
+}
 
-	return d, nil
+func createEventRecord(db *sql.DB, e *models.EventRecord) error {
+	result, err := db.Exec(eventRecordInsertStmt, string(e.Type), e.DeploymentId, e.ApplicationName, e.TargetName, string(e.State), e.CommitSha, e.Branch, e.Tag, e.Comment, e.UserName, e.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.Id = int(id)
+
+	return nil
+}
+
+// getFilteredEventRecords returns the matching events of the applications,
+// newest first, or oldest first if only the events after an event are
+// requested.
+func getFilteredEventRecords(db *sql.DB, f *eventFilter) ([]*models.EventRecord, error) {
+	events := []*models.EventRecord{}
+	if len(f.ApplicationNames) == 0 {
+		return events, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(f.ApplicationNames)), ", ")
+	conditions := []string{"application_name IN (" + placeholders + ")"}
+	args := []interface{}{}
+	for _, name := range f.ApplicationNames {
+		args = append(args, name)
+	}
+
+	if f.TargetName != "" {
+		conditions = append(conditions, "target_name = ?")
+		args = append(args, f.TargetName)
+	}
+	if f.Type != "" {
+		conditions = append(conditions, "type = ?")
+		args = append(args, string(f.Type))
+	}
+	order := "DESC"
+	if f.After > 0 {
+		conditions = append(conditions, "id > ?")
+		args = append(args, f.After)
+		order = "ASC"
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	args = append(args, limit)
+
+	stmt := fmt.Sprintf(filteredEventRecordsStmt, strings.Join(conditions, " AND "), order)
+	rows, err := db.Query(stmt, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventType, state string
+		e := &models.EventRecord{}
+
+		err := rows.Scan(&e.Id, &eventType, &e.DeploymentId, &e.ApplicationName, &e.TargetName, &state, &e.CommitSha, &e.Branch, &e.Tag, &e.Comment, &e.UserName, &e.CreatedAt)
+		if err != nil {
+			return events, err
+		}
+		e.Type = models.EventType(eventType)
+		e.State = models.DeploymentState(state)
+
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+func loadManagedApplicationsUsers(db *sql.DB, applications []*models.ManagedApplication) error {
+	for _, a := range applications {
+		u, err := getUser(db, a.UserId)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		a.User = u
+	}
+
+	return nil
 }
 
 func isMigrated(db *sql.DB) (bool, error) {
@@ -2,6 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -17,6 +21,22 @@ var cleanStmts []string = []string{
 	"DELETE FROM deployments;",
 	"DELETE FROM log_entries;",
 	"DELETE FROM users;",
+	"DELETE FROM live_host_groups;",
+	"DELETE FROM user_groups;",
+	"DELETE FROM deployment_initiators;",
+	"DELETE FROM deployment_changelog_entries;",
+	"DELETE FROM audit_events;",
+	"DELETE FROM user_preferences;",
+	"DELETE FROM deploy_freezes;",
+	"DELETE FROM deploy_presets;",
+	"DELETE FROM user_sessions;",
+	"DELETE FROM managed_applications;",
+	"DELETE FROM deliveries;",
+	"DELETE FROM deployment_host_stages;",
+	"DELETE FROM digest_subscriptions;",
+	"DELETE FROM calendar_tokens;",
+	"DELETE FROM event_records;",
+	"DELETE FROM deployment_overrides;",
 }
 
 func newTestDb(t *testing.T) *sql.DB {
@@ -53,6 +73,8 @@ func buildUser(id int, name string) *models.User {
 		Id:          id,
 		AccessToken: "f00bardummytoken",
 		AvatarUrl:   "http://www.github.com/avatars/avatar.png",
+		Provider:    "github",
+		ProviderId:  strconv.Itoa(id),
 	}
 }
 
@@ -105,6 +127,128 @@ func TestCreateDeployment(t *testing.T) {
 	}
 }
 
+func TestCreateDeploymentWithInitiator(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	deployment := buildDeployment(9999)
+	deployment.Initiator = &models.DeploymentInitiator{
+		TokenName:   "ci",
+		SourceIP:    "10.0.0.1",
+		BuildURL:    "https://ci.example.com/builds/123",
+		BuildNumber: "123",
+		OnBehalfOf:  "alice",
+	}
+
+	err := createDeployment(db, deployment)
+	checkErr(t, err)
+
+	saved, err := getDeployment(db, deployment.Id)
+	checkErr(t, err)
+
+	err = loadDeploymentInitiator(db, saved)
+	checkErr(t, err)
+
+	if saved.Initiator == nil {
+		t.Fatalf("initiator not loaded")
+	}
+	if *saved.Initiator != *deployment.Initiator {
+		t.Errorf("wrong initiator. want=%+v, got=%+v", deployment.Initiator, saved.Initiator)
+	}
+
+	other := buildDeployment(9999)
+	err = createDeployment(db, other)
+	checkErr(t, err)
+
+	err = loadDeploymentInitiator(db, other)
+	checkErr(t, err)
+	if other.Initiator != nil {
+		t.Errorf("expected no initiator for deployment created in the UI. got=%+v", other.Initiator)
+	}
+}
+
+func TestCreateRedeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	original := buildDeployment(9999)
+	err := createDeployment(db, original)
+	checkErr(t, err)
+
+	redeploy := buildDeployment(9999)
+	redeploy.RedeployOf = original.Id
+	redeploy.Stages = []models.DeploymentStage{"CHECK_CONNECTION", "DEPLOY"}
+	err = createDeployment(db, redeploy)
+	checkErr(t, err)
+
+	tests := []struct {
+		id         int
+		redeployOf int
+		stages     string
+	}{
+		{original.Id, 0, "[]"},
+		{redeploy.Id, original.Id, "[CHECK_CONNECTION DEPLOY]"},
+	}
+
+	for _, tt := range tests {
+		saved, err := getDeployment(db, tt.id)
+		checkErr(t, err)
+
+		if saved.RedeployOf != tt.redeployOf {
+			t.Errorf("wrong RedeployOf of deployment %d. want=%d, got=%d", tt.id, tt.redeployOf, saved.RedeployOf)
+		}
+		if fmt.Sprint(saved.Stages) != tt.stages {
+			t.Errorf("wrong stages of deployment %d. want=%s, got=%v", tt.id, tt.stages, saved.Stages)
+		}
+	}
+}
+
+func TestCreateDeploymentWithChangelog(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	deployment := buildDeployment(9999)
+	deployment.Changelog = []*models.ChangelogEntry{
+		{CommitSha: "0ld", Author: "alice", Message: "Add pizza"},
+		{CommitSha: "n3w", Author: "bob", Message: "Add more pizza\n\nWith cheese"},
+	}
+
+	err := createDeployment(db, deployment)
+	checkErr(t, err)
+
+	saved, err := getDeployment(db, deployment.Id)
+	checkErr(t, err)
+
+	err = loadDeploymentChangelog(db, saved)
+	checkErr(t, err)
+
+	if len(saved.Changelog) != 2 {
+		t.Fatalf("wrong number of changelog entries. want=%d, got=%d", 2, len(saved.Changelog))
+	}
+	for i, e := range deployment.Changelog {
+		if *saved.Changelog[i] != *e {
+			t.Errorf("wrong changelog entry. want=%+v, got=%+v", e, saved.Changelog[i])
+		}
+	}
+}
+
+func TestCreateDeploymentWithOverrides(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	deployment := buildDeployment(9999)
+	deployment.Overrides = map[string]string{"MIGRATION_TIMEOUT": "600", "WORKERS": "4"}
+	checkErr(t, createDeployment(db, deployment))
+
+	saved, err := getDeployment(db, deployment.Id)
+	checkErr(t, err)
+	checkErr(t, loadDeploymentOverrides(db, saved))
+
+	if !reflect.DeepEqual(saved.Overrides, deployment.Overrides) {
+		t.Errorf("wrong overrides. want=%v, got=%v", deployment.Overrides, saved.Overrides)
+	}
+}
+
 func TestUpdateDeploymentState(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -130,6 +274,45 @@ func TestUpdateDeploymentState(t *testing.T) {
 	}
 }
 
+func TestGetLastDeploymentChange(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	a := &models.Application{Name: "flincOnRails"}
+
+	id, changedAt, err := getLastDeploymentChange(db, a)
+	checkErr(t, err)
+	if id != 0 || !changedAt.IsZero() {
+		t.Errorf("wrong last change without deployments. got=%d %s", id, changedAt)
+	}
+
+	first := buildDeployment(9999)
+	checkErr(t, createDeployment(db, first))
+	second := buildDeployment(9999)
+	second.TargetName = "staging"
+	checkErr(t, createDeployment(db, second))
+
+	id, _, err = getLastDeploymentChange(db, a)
+	checkErr(t, err)
+	if id != second.Id {
+		t.Errorf("wrong last changed deployment. want=%d, got=%d", second.Id, id)
+	}
+
+	checkErr(t, updateDeploymentState(db, first, models.DEPLOYMENT_SUCCESSFUL))
+
+	id, changedAt, err = getLastDeploymentChange(db, a)
+	checkErr(t, err)
+	if id != first.Id || !changedAt.Equal(first.UpdatedAt) {
+		t.Errorf("wrong last change after update. want=%d %s, got=%d %s", first.Id, first.UpdatedAt, id, changedAt)
+	}
+
+	saved, err := getDeployment(db, first.Id)
+	checkErr(t, err)
+	if !saved.UpdatedAt.Equal(first.UpdatedAt) {
+		t.Errorf("updated_at not saved. want=%s, got=%s", first.UpdatedAt, saved.UpdatedAt)
+	}
+}
+
 func TestGetApplicationDeployments(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -212,6 +395,99 @@ func TestGetApplicationDeploymentsByTarget(t *testing.T) {
 	}
 }
 
+func TestGetFilteredApplicationDeployments(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	checkErr(t, createUser(db, buildUser(9999, "mrnugget")))
+	checkErr(t, createUser(db, buildUser(1111, "fabrik42")))
+
+	first := buildDeployment(9999)
+	checkErr(t, createDeployment(db, first))
+
+	second := buildDeployment(1111)
+	second.Branch = "hotfix"
+	checkErr(t, createDeployment(db, second))
+	checkErr(t, updateDeploymentState(db, second, models.DEPLOYMENT_SUCCESSFUL))
+
+	third := buildDeployment(9999)
+	third.TargetName = "staging"
+	checkErr(t, createDeployment(db, third))
+
+	application := &models.Application{Name: "flincOnRails"}
+	now := time.Now()
+
+	tests := []struct {
+		filter   *deploymentFilter
+		expected []int
+	}{
+		{&deploymentFilter{}, []int{third.Id, second.Id, first.Id}},
+		{&deploymentFilter{Ascending: true}, []int{first.Id, second.Id, third.Id}},
+		{&deploymentFilter{Limit: 2}, []int{third.Id, second.Id}},
+		{&deploymentFilter{TargetName: "production"}, []int{second.Id, first.Id}},
+		{&deploymentFilter{State: models.DEPLOYMENT_SUCCESSFUL}, []int{second.Id}},
+		{&deploymentFilter{Branch: "master"}, []int{third.Id, first.Id}},
+		{&deploymentFilter{UserName: "mrnugget", TargetName: "production"}, []int{first.Id}},
+		{&deploymentFilter{UserName: "unknown"}, []int{}},
+		{&deploymentFilter{From: now.Add(-time.Hour), To: now.Add(time.Hour)}, []int{third.Id, second.Id, first.Id}},
+		{&deploymentFilter{From: now.Add(time.Hour)}, []int{}},
+		{&deploymentFilter{To: now.Add(-time.Hour)}, []int{}},
+	}
+
+	for i, tt := range tests {
+		deployments, err := getFilteredApplicationDeployments(db, application, tt.filter)
+		checkErr(t, err)
+
+		ids := []int{}
+		for _, d := range deployments {
+			ids = append(ids, d.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.expected) {
+			t.Errorf("%d: wrong deployments. want=%v, got=%v", i, tt.expected, ids)
+		}
+	}
+}
+
+func TestDeploymentQueriesUseIndexes(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	application := &models.Application{Name: "web"}
+	filtered, filteredArgs := filteredApplicationDeploymentsQuery(application, &deploymentFilter{State: models.DEPLOYMENT_SUCCESSFUL, Limit: 10})
+	byTarget, byTargetArgs := filteredApplicationDeploymentsQuery(application, &deploymentFilter{TargetName: "production", Limit: 10})
+
+	tests := []struct {
+		query         string
+		args          []interface{}
+		expectedIndex string
+	}{
+		{filtered, filteredArgs, "deployments_application_name_target_name_created_at"},
+		{byTarget, byTargetArgs, "deployments_application_name_target_name_created_at"},
+		{lastTargetDeploymentStmt, []interface{}{"successful", "web", "production"}, "deployments_application_name_target_name_created_at"},
+		{allActiveDeploymentsStmt, nil, "deployments_state"},
+		{deploymentLogEntriesStmt, []interface{}{1}, "log_entries_deployment_id"},
+	}
+
+	for _, tt := range tests {
+		rows, err := db.Query("EXPLAIN QUERY PLAN "+tt.query, tt.args...)
+		checkErr(t, err)
+
+		var plan []string
+		for rows.Next() {
+			var id, parent, notUsed int
+			var detail string
+			checkErr(t, rows.Scan(&id, &parent, &notUsed, &detail))
+			plan = append(plan, detail)
+		}
+		checkErr(t, rows.Err())
+		rows.Close()
+
+		if !strings.Contains(strings.Join(plan, "\n"), "INDEX "+tt.expectedIndex) {
+			t.Errorf("query doesn't use %s: %s\nplan=%v", tt.expectedIndex, tt.query, plan)
+		}
+	}
+}
+
 func TestGetDeployment(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -337,6 +613,164 @@ func TestGetLastTargetDeployment(t *testing.T) {
 	}
 }
 
+func TestGetLatestTargetDeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	app := &models.Application{Name: "flincOnRails"}
+
+	latest, err := getLatestTargetDeployment(db, app, "production")
+	checkErr(t, err)
+	if latest != nil {
+		t.Errorf("got a deployment. expected none")
+	}
+
+	first := buildDeployment(9999)
+	err = createDeployment(db, first)
+	checkErr(t, err)
+	err = updateDeploymentState(db, first, models.DEPLOYMENT_FAILED)
+	checkErr(t, err)
+
+	second := buildDeployment(9999)
+	err = createDeployment(db, second)
+	checkErr(t, err)
+	err = updateDeploymentState(db, second, models.DEPLOYMENT_FAILED)
+	checkErr(t, err)
+
+	latest, err = getLatestTargetDeployment(db, app, "production")
+	checkErr(t, err)
+	if latest == nil || latest.Id != second.Id {
+		t.Errorf("wrong latest deployment. want=%d, got=%+v", second.Id, latest)
+	}
+}
+
+func TestGetPreviousTargetDeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	stmt := `INSERT INTO
+	deployments (user_id, application_name, target_name, commit_sha, branch, comment, state, created_at)
+	VALUES
+	(?, ?, ?, ?, ?, ?, ?, ?);`
+
+	app := &models.Application{Name: "app"}
+	deployments := []struct {
+		targetName string
+		commitSha  string
+		createdAt  time.Time
+		state      models.DeploymentState
+		comment    string
+	}{
+		{"production", "aaa", time.Now().Add(-4 * time.Hour), models.DEPLOYMENT_SUCCESSFUL, "older"},
+		{"production", "bbb", time.Now().Add(-3 * time.Hour), models.DEPLOYMENT_SUCCESSFUL, "previous"},
+		{"staging", "ccc", time.Now().Add(-150 * time.Minute), models.DEPLOYMENT_SUCCESSFUL, "other target"},
+		{"production", "ccc", time.Now().Add(-2 * time.Hour), models.DEPLOYMENT_FAILED, "failed"},
+		{"production", "ddd", time.Now().Add(-90 * time.Minute), models.DEPLOYMENT_SUCCESSFUL, "same commit"},
+		{"production", "ddd", time.Now().Add(-1 * time.Hour), models.DEPLOYMENT_SUCCESSFUL, "current"},
+	}
+
+	for _, d := range deployments {
+		_, err := db.Exec(stmt, 9999, app.Name, d.targetName,
+			d.commitSha, "master", d.comment, string(d.state), d.createdAt)
+		checkErr(t, err)
+	}
+
+	current, err := getLastTargetDeployment(db, app, "production")
+	checkErr(t, err)
+
+	previous, err := getPreviousTargetDeployment(db, app, current)
+	checkErr(t, err)
+	if previous == nil || previous.Comment != "previous" {
+		t.Fatalf("wrong previous deployment. want=%s, got=%+v", "previous", previous)
+	}
+
+	previous, err = getPreviousTargetDeployment(db, app, &models.Deployment{TargetName: "production", CommitSha: "aaa", CreatedAt: time.Now().Add(-4 * time.Hour)})
+	checkErr(t, err)
+	if previous != nil {
+		t.Errorf("got a deployment before the first one. got=%+v", previous)
+	}
+}
+
+func TestCountDeploymentRetries(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	original := buildDeployment(9999)
+	err := createDeployment(db, original)
+	checkErr(t, err)
+	err = updateDeploymentState(db, original, models.DEPLOYMENT_FAILED)
+	checkErr(t, err)
+
+	retries, err := countDeploymentRetries(db, original)
+	checkErr(t, err)
+	if retries != 0 {
+		t.Errorf("wrong number of retries. want=%d, got=%d", 0, retries)
+	}
+
+	previous := original
+	for i := 1; i <= 2; i++ {
+		retry := buildDeployment(9999)
+		retry.RetryOf = previous.Id
+		err = createDeployment(db, retry)
+		checkErr(t, err)
+		err = updateDeploymentState(db, retry, models.DEPLOYMENT_FAILED)
+		checkErr(t, err)
+
+		loaded, err := getDeployment(db, retry.Id)
+		checkErr(t, err)
+		if loaded.RetryOf != previous.Id {
+			t.Errorf("wrong RetryOf saved. want=%d, got=%d", previous.Id, loaded.RetryOf)
+		}
+
+		retries, err = countDeploymentRetries(db, loaded)
+		checkErr(t, err)
+		if retries != i {
+			t.Errorf("wrong number of retries. want=%d, got=%d", i, retries)
+		}
+
+		previous = retry
+	}
+}
+
+func TestLiveHostGroup(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	group, err := getLiveHostGroup(db, "flincOnRails", "production")
+	checkErr(t, err)
+	if group != "" {
+		t.Errorf("wrong live group. want=%q, got=%q", "", group)
+	}
+
+	for _, g := range []string{models.BLUE_GROUP, models.GREEN_GROUP} {
+		deployment := buildDeployment(9999)
+		deployment.HostGroup = g
+		err = createDeployment(db, deployment)
+		checkErr(t, err)
+
+		err = setLiveHostGroup(db, deployment)
+		checkErr(t, err)
+
+		group, err = getLiveHostGroup(db, "flincOnRails", "production")
+		checkErr(t, err)
+		if group != g {
+			t.Errorf("wrong live group. want=%q, got=%q", g, group)
+		}
+
+		loaded, err := getDeployment(db, deployment.Id)
+		checkErr(t, err)
+		if loaded.HostGroup != g {
+			t.Errorf("wrong host group saved. want=%q, got=%q", g, loaded.HostGroup)
+		}
+	}
+
+	group, err = getLiveHostGroup(db, "flincOnRails", "staging")
+	checkErr(t, err)
+	if group != "" {
+		t.Errorf("wrong live group for other target. want=%q, got=%q", "", group)
+	}
+}
+
 func TestCreateLogEntry(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -365,6 +799,87 @@ func TestCreateLogEntry(t *testing.T) {
 	}
 }
 
+func TestLogEntrySaver(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	// More entries than fit into one batch are buffered
+	logs := make(chan deploy.LogEntry, logEntryBatchSize+10)
+	for i := 0; i < logEntryBatchSize+10; i++ {
+		logs <- deploy.LogEntry{
+			DeploymentId: 99,
+			Origin:       "production.server.com",
+			EntryType:    deploy.COMMAND_STDOUT_OUTPUT,
+			Message:      fmt.Sprintf("line %d", i),
+			Timestamp:    time.Now(),
+		}
+	}
+	close(logs)
+
+	newLogEntrySaver(db)(logs)
+
+	entries, err := getDeploymentLogEntries(db, &models.Deployment{Id: 99})
+	checkErr(t, err)
+
+	if len(entries) != logEntryBatchSize+10 {
+		t.Fatalf("wrong count of log_entries. want=%d, got=%d", logEntryBatchSize+10, len(entries))
+	}
+	if entries[0].Message != "line 0" || entries[len(entries)-1].Message != fmt.Sprintf("line %d", logEntryBatchSize+9) {
+		t.Errorf("wrong order of entries. got first=%s, last=%s", entries[0].Message, entries[len(entries)-1].Message)
+	}
+}
+
+func TestGetDeploymentLogEntriesPage(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	deployment := &models.Deployment{Id: 99}
+	start := time.Now()
+	for i := 1; i <= 10; i++ {
+		entryType := deploy.COMMAND_STDOUT_OUTPUT
+		if i%5 == 0 {
+			entryType = deploy.COMMAND_SUCCESS
+		}
+		entry := &deploy.LogEntry{
+			DeploymentId: deployment.Id,
+			Sequence:     i,
+			Origin:       "production.server.com",
+			EntryType:    entryType,
+			Message:      fmt.Sprintf("line %d", i),
+			Timestamp:    start.Add(time.Duration(i) * time.Second),
+		}
+		checkErr(t, createLogEntry(db, entry))
+	}
+
+	tests := []struct {
+		filter            *logEntryFilter
+		before            int
+		limit             int
+		expectedSequences []int
+	}{
+		{&logEntryFilter{}, 0, 3, []int{8, 9, 10}},
+		{&logEntryFilter{}, 8, 3, []int{5, 6, 7}},
+		{&logEntryFilter{}, 3, 5, []int{1, 2}},
+		{&logEntryFilter{}, 1, 5, []int{}},
+		{&logEntryFilter{Types: []deploy.LogEntryType{deploy.COMMAND_SUCCESS}}, 0, 3, []int{5, 10}},
+	}
+
+	for _, tt := range tests {
+		entries, err := getDeploymentLogEntriesPage(db, deployment, tt.filter, tt.before, tt.limit)
+		checkErr(t, err)
+
+		if len(entries) != len(tt.expectedSequences) {
+			t.Errorf("wrong number of entries before %d. want=%d, got=%d", tt.before, len(tt.expectedSequences), len(entries))
+			continue
+		}
+		for i, e := range entries {
+			if e.Sequence != tt.expectedSequences[i] {
+				t.Errorf("wrong entry before %d. want=%d, got=%d", tt.before, tt.expectedSequences[i], e.Sequence)
+			}
+		}
+	}
+}
+
 func TestGetDeploymentLogEntries(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -382,10 +897,12 @@ func TestGetDeploymentLogEntries(t *testing.T) {
 
 	secondEntry := deploy.LogEntry{
 		DeploymentId: deployment.Id,
+		Sequence:     5,
 		Origin:       "production.server.com",
 		EntryType:    deploy.COMMAND_SUCCESS,
 		Message:      "bundle exec rake db:migrate",
 		Timestamp:    time.Now(),
+		Duration:     12 * time.Second,
 	}
 	err = createLogEntry(db, &secondEntry)
 	checkErr(t, err)
@@ -413,6 +930,15 @@ func TestGetDeploymentLogEntries(t *testing.T) {
 	if entries[1].Id != secondEntry.Id {
 		t.Error("wrong order of entries.")
 	}
+
+	if entries[1].Duration != secondEntry.Duration {
+		t.Errorf("wrong duration saved. want=%s, got=%s", secondEntry.Duration, entries[1].Duration)
+	}
+
+	// The first entry was saved without a sequence
+	if entries[0].Sequence != 1 || entries[1].Sequence != 5 {
+		t.Errorf("wrong sequences. want=1, 5, got=%d, %d", entries[0].Sequence, entries[1].Sequence)
+	}
 }
 
 func TestNewLogEntrySaver(t *testing.T) {
@@ -484,6 +1010,58 @@ func TestCreateUserApiToken(t *testing.T) {
 	}
 }
 
+func TestRegenerateAndRevokeApiToken(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(12345, "mrnugget")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	oldToken := user.ApiToken
+
+	err = touchApiToken(db, user)
+	checkErr(t, err)
+	err = loadApiTokenUsage(db, user)
+	checkErr(t, err)
+	if user.ApiTokenCreatedAt == nil || user.ApiTokenLastUsedAt == nil {
+		t.Fatalf("expected token usage to be saved. got=%v, %v", user.ApiTokenCreatedAt, user.ApiTokenLastUsedAt)
+	}
+
+	err = regenerateApiToken(db, user)
+	checkErr(t, err)
+	if user.ApiToken == oldToken || user.ApiToken == "" {
+		t.Errorf("token not regenerated. old=%s, new=%s", oldToken, user.ApiToken)
+	}
+
+	_, err = getUserByApiToken(db, oldToken)
+	if err != sql.ErrNoRows {
+		t.Errorf("old token still valid. err=%v", err)
+	}
+
+	saved, err := getUserByApiToken(db, user.ApiToken)
+	checkErr(t, err)
+	err = loadApiTokenUsage(db, saved)
+	checkErr(t, err)
+	if saved.ApiTokenLastUsedAt != nil {
+		t.Errorf("regenerated token has last usage %v", saved.ApiTokenLastUsedAt)
+	}
+
+	err = revokeApiToken(db, user)
+	checkErr(t, err)
+
+	_, err = getUserByApiToken(db, "")
+	if err != sql.ErrNoRows {
+		t.Errorf("revoked token valid. err=%v", err)
+	}
+
+	saved, err = getUser(db, user.Id)
+	checkErr(t, err)
+	if saved.ApiToken != "" {
+		t.Errorf("token not revoked. got=%s", saved.ApiToken)
+	}
+}
+
 func TestGetUser(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -566,6 +1144,178 @@ func TestUpdateOnCreateOrUpdateUser(t *testing.T) {
 	}
 }
 
+func TestCreateOrUpdateUserWithProviders(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	gitHubUser := &models.User{Name: "mrnugget", Provider: "github", ProviderId: "12345"}
+	err := createOrUpdateUser(db, gitHubUser)
+	checkErr(t, err)
+
+	// Same id, different provider
+	gitLabUser := &models.User{Name: "mrnugget", Provider: "gitlab", ProviderId: "12345"}
+	err = createOrUpdateUser(db, gitLabUser)
+	checkErr(t, err)
+
+	if gitLabUser.Id == 0 || gitLabUser.Id == gitHubUser.Id {
+		t.Errorf("wrong id of gitlab user. got=%d, github user=%d", gitLabUser.Id, gitHubUser.Id)
+	}
+
+	returning := &models.User{Name: "mrnugget", Provider: "gitlab", ProviderId: "12345", AccessToken: "newtoken"}
+	err = createOrUpdateUser(db, returning)
+	checkErr(t, err)
+
+	if returning.Id != gitLabUser.Id {
+		t.Errorf("returning user has wrong id. want=%d, got=%d", gitLabUser.Id, returning.Id)
+	}
+
+	saved, err := getUser(db, gitLabUser.Id)
+	checkErr(t, err)
+	if saved.Provider != "gitlab" {
+		t.Errorf("wrong provider. want=%s, got=%s", "gitlab", saved.Provider)
+	}
+	if saved.AccessToken != "newtoken" {
+		t.Errorf("access token not updated. want=%s, got=%s", "newtoken", saved.AccessToken)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(1) FROM users").Scan(&count)
+	checkErr(t, err)
+	if count != 2 {
+		t.Errorf("wrong count of users. want=%d, got=%d", 2, count)
+	}
+}
+
+func TestSetUserGroups(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(12345, "mrnugget")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	user.Groups = []string{"ops", "developers"}
+	err = setUserGroups(db, user)
+	checkErr(t, err)
+
+	user.Groups = []string{"ops", "admins"}
+	err = setUserGroups(db, user)
+	checkErr(t, err)
+
+	saved, err := getUser(db, user.Id)
+	checkErr(t, err)
+
+	expected := []string{"admins", "ops"}
+	if len(saved.Groups) != len(expected) {
+		t.Fatalf("wrong number of groups. want=%v, got=%v", expected, saved.Groups)
+	}
+	for i, group := range expected {
+		if saved.Groups[i] != group {
+			t.Errorf("wrong group. want=%s, got=%s", group, saved.Groups[i])
+		}
+	}
+}
+
+func TestSetUserPreferences(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(12345, "mrnugget")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	saved, err := getUser(db, user.Id)
+	checkErr(t, err)
+	if saved.Preferences != (models.UserPreferences{}) {
+		t.Errorf("wrong default preferences. want=%+v, got=%+v", models.UserPreferences{}, saved.Preferences)
+	}
+
+	for _, p := range []models.UserPreferences{
+		{Theme: models.THEME_DARK, TimeZone: "Europe/Berlin", DefaultApplication: "flincOnRails", DeploymentNotifications: models.NOTIFY_DEPLOYMENTS_FAILED, Language: "de"},
+		{TimeZone: "UTC"},
+	} {
+		user.Preferences = p
+		err = setUserPreferences(db, user)
+		checkErr(t, err)
+
+		saved, err = getUser(db, user.Id)
+		checkErr(t, err)
+		if saved.Preferences != p {
+			t.Errorf("wrong preferences. want=%+v, got=%+v", p, saved.Preferences)
+		}
+	}
+}
+
+func TestDeactivateAndReactivateUser(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(12345, "mrnugget")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	err = deactivateUser(db, user)
+	checkErr(t, err)
+
+	saved, err := getUser(db, user.Id)
+	checkErr(t, err)
+	if !saved.IsDeactivated() {
+		t.Fatalf("expected user to be deactivated")
+	}
+	if saved.DisplayName() != "mrnugget (deactivated)" {
+		t.Errorf("wrong display name. want=%s, got=%s", "mrnugget (deactivated)", saved.DisplayName())
+	}
+
+	// Logging in again doesn't reactivate the user
+	loggedIn := buildUser(12345, "mrnugget")
+	err = createOrUpdateUser(db, loggedIn)
+	checkErr(t, err)
+	if !loggedIn.IsDeactivated() {
+		t.Errorf("expected user to stay deactivated after logging in")
+	}
+
+	users, err := getUsers(db, []int{user.Id})
+	checkErr(t, err)
+	if !users[0].IsDeactivated() {
+		t.Errorf("expected deployment user to be deactivated")
+	}
+
+	err = reactivateUser(db, user)
+	checkErr(t, err)
+
+	saved, err = getUser(db, user.Id)
+	checkErr(t, err)
+	if saved.IsDeactivated() {
+		t.Errorf("expected user to be reactivated")
+	}
+}
+
+func TestGetUsersByProvider(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	gitHubUser := buildUser(12345, "mrnugget")
+	err := createUser(db, gitHubUser)
+	checkErr(t, err)
+
+	gitLabUser := &models.User{Name: "fabrik42", Provider: "gitlab", ProviderId: "1"}
+	err = createUser(db, gitLabUser)
+	checkErr(t, err)
+
+	users, err := getUsersByProvider(db, "github")
+	checkErr(t, err)
+
+	if len(users) != 1 {
+		t.Fatalf("wrong number of users. want=%d, got=%d", 1, len(users))
+	}
+	if users[0].Id != gitHubUser.Id {
+		t.Errorf("wrong user. want=%d, got=%d", gitHubUser.Id, users[0].Id)
+	}
+	if users[0].AccessToken != gitHubUser.AccessToken {
+		t.Errorf("wrong access token. want=%s, got=%s", gitHubUser.AccessToken, users[0].AccessToken)
+	}
+}
+
 func TestLoadDeploymentsUsers(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
@@ -634,13 +1384,53 @@ func TestCreateDeploymentWithActiveDeployments(t *testing.T) {
 	}
 }
 
+func TestCreateOrQueueDeployment(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	first := buildDeployment(9999)
+	err := createOrQueueDeployment(db, first)
+	checkErr(t, err)
+	if first.State != models.DEPLOYMENT_NEW {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_NEW, first.State)
+	}
+
+	err = updateDeploymentState(db, first, models.DEPLOYMENT_ACTIVE)
+	checkErr(t, err)
+
+	second := buildDeployment(9999)
+	err = createOrQueueDeployment(db, second)
+	checkErr(t, err)
+	if second.State != models.DEPLOYMENT_QUEUED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_QUEUED, second.State)
+	}
+
+	// Deployments are queued behind other queued deployments
+	err = updateDeploymentState(db, first, models.DEPLOYMENT_SUCCESSFUL)
+	checkErr(t, err)
+
+	third := buildDeployment(9999)
+	err = createOrQueueDeployment(db, third)
+	checkErr(t, err)
+	if third.State != models.DEPLOYMENT_QUEUED {
+		t.Errorf("wrong state. want=%s, got=%s", models.DEPLOYMENT_QUEUED, third.State)
+	}
+
+	loaded, err := getDeployment(db, third.Id)
+	checkErr(t, err)
+	if loaded.State != models.DEPLOYMENT_QUEUED {
+		t.Errorf("wrong state saved. want=%s, got=%s", models.DEPLOYMENT_QUEUED, loaded.State)
+	}
+}
+
 func TestGetDailyDigestDeployments(t *testing.T) {
 	db := newTestDb(t)
 	defer cleanCloseTestDb(db, t)
 
 	a := &models.Application{Name: "awesomeDB"}
 	targetName := "production"
-	since := time.Now().Add(-24 * time.Hour)
+	until := time.Now().Add(-time.Hour)
+	since := until.Add(-24 * time.Hour)
 
 	stmt := `INSERT INTO
 	deployments
@@ -656,10 +1446,12 @@ func TestGetDailyDigestDeployments(t *testing.T) {
 	}{
 		// should be included
 		{"awesomeDB", "production", time.Now().Add(-12 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
+		{"awesomeDB", "production", time.Now().Add(-11 * time.Hour), models.DEPLOYMENT_FAILED},
 		// these should not be included
 		{"awesomeDB", "production", time.Now().Add(-45 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
+		{"awesomeDB", "production", time.Now().Add(-30 * time.Minute), models.DEPLOYMENT_SUCCESSFUL},
 		{"awesomeDB", "staging", time.Now().Add(-10 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
-		{"awesomeDB", "production", time.Now().Add(-12 * time.Hour), models.DEPLOYMENT_FAILED},
+		{"awesomeDB", "production", time.Now().Add(-12 * time.Hour), models.DEPLOYMENT_ACTIVE},
 	}
 
 	for _, ed := range existingDeployments {
@@ -668,11 +1460,14 @@ func TestGetDailyDigestDeployments(t *testing.T) {
 		checkErr(t, err)
 	}
 
-	deployments, err := getDailyDigestDeployments(db, a, targetName, since)
+	deployments, err := getDailyDigestDeployments(db, a, targetName, since, until)
 	checkErr(t, err)
 
-	if len(deployments) != 1 {
-		t.Errorf("getDailyDigestDeployments wrong number of deployments: %d", len(deployments))
+	if len(deployments) != 2 {
+		t.Fatalf("getDailyDigestDeployments wrong number of deployments: %d", len(deployments))
+	}
+	if deployments[1].State != models.DEPLOYMENT_FAILED {
+		t.Errorf("getDailyDigestDeployments wrong order or state. got=%s", deployments[1].State)
 	}
 }
 
@@ -715,3 +1510,301 @@ func TestFailUnfinishedDeployments(t *testing.T) {
 		t.Errorf("wrong count of successful deployments. want=%d, got=%d", 1, count)
 	}
 }
+
+func TestGetFilteredAuditEvents(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	mrnugget := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, mrnugget))
+	fabrik42 := buildUser(2, "fabrik42")
+	checkErr(t, createUser(db, fabrik42))
+
+	day := time.Date(2026, time.October, 16, 12, 0, 0, 0, time.Local)
+	events := []*models.AuditEvent{
+		{UserId: mrnugget.Id, Action: models.AUDIT_LOGIN, Subject: "github", CreatedAt: day.AddDate(0, 0, -2)},
+		{UserId: mrnugget.Id, Action: models.AUDIT_DEPLOYMENT_CREATE, Subject: "web/production #1", CreatedAt: day},
+		{UserId: fabrik42.Id, Action: models.AUDIT_DEPLOYMENT_CANCEL, Subject: "web/production #1", CreatedAt: day.Add(time.Minute)},
+	}
+	for _, e := range events {
+		checkErr(t, createAuditEvent(db, e))
+	}
+
+	tests := []struct {
+		filter   *auditFilter
+		expected []int
+	}{
+		{&auditFilter{}, []int{events[2].Id, events[1].Id, events[0].Id}},
+		{&auditFilter{UserName: "mrnugget"}, []int{events[1].Id, events[0].Id}},
+		{&auditFilter{Action: models.AUDIT_DEPLOYMENT_CANCEL}, []int{events[2].Id}},
+		{&auditFilter{From: day.Add(-time.Hour), To: day.Add(time.Hour)}, []int{events[2].Id, events[1].Id}},
+		{&auditFilter{Limit: 1}, []int{events[2].Id}},
+	}
+
+	for i, tt := range tests {
+		got, err := getFilteredAuditEvents(db, tt.filter)
+		checkErr(t, err)
+
+		ids := []int{}
+		for _, e := range got {
+			ids = append(ids, e.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong events for filter %d. want=%v, got=%v", i, tt.expected, ids)
+		}
+	}
+
+	got, err := getFilteredAuditEvents(db, &auditFilter{UserName: "fabrik42"})
+	checkErr(t, err)
+	checkErr(t, loadAuditEventsUsers(db, got))
+	if got[0].User == nil || got[0].User.Name != "fabrik42" || got[0].Subject != "web/production #1" {
+		t.Errorf("wrong event. got=%+v", got[0])
+	}
+}
+
+func TestSearchApplicationDeployments(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+	other := buildUser(2, "fabrik42")
+	checkErr(t, createUser(db, other))
+
+	first := buildDeployment(user.Id)
+	first.CommitSha = "f00b4r" + first.CommitSha[6:]
+	first.Branch = "feature/search"
+	first.Comment = "Add the 100% search"
+	checkErr(t, createDeployment(db, first))
+
+	second := buildDeployment(other.Id)
+	second.Branch = "master"
+	second.Comment = "Fix the login"
+	checkErr(t, createDeployment(db, second))
+
+	application := &models.Application{Name: first.ApplicationName}
+
+	tests := []struct {
+		query    string
+		expected []int
+	}{
+		{"F00B4", []int{first.Id}},
+		{"b4r", []int{}},
+		{"SEARCH", []int{first.Id}},
+		{"100%", []int{first.Id}},
+		{"0%s", []int{}},
+		{"fabrik", []int{second.Id}},
+		{"the", []int{second.Id, first.Id}},
+	}
+
+	for _, tt := range tests {
+		deployments, err := searchApplicationDeployments(db, application, tt.query, 10)
+		checkErr(t, err)
+
+		ids := []int{}
+		for _, d := range deployments {
+			ids = append(ids, d.Id)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(tt.expected) {
+			t.Errorf("wrong deployments for %q. want=%v, got=%v", tt.query, tt.expected, ids)
+		}
+	}
+}
+
+func TestDeployFreezes(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	a := &models.Application{Name: "flincOnRails"}
+	other := &models.Application{Name: "other"}
+
+	all := &models.DeployFreeze{ApplicationName: a.Name, UserId: 1, Reason: "Black Friday"}
+	checkErr(t, createDeployFreeze(db, all))
+	production := &models.DeployFreeze{ApplicationName: a.Name, TargetName: "production", UserId: 1, Reason: "incident"}
+	checkErr(t, createDeployFreeze(db, production))
+	// Replaces the freeze of the same target
+	replaced := &models.DeployFreeze{ApplicationName: a.Name, TargetName: "production", UserId: 2, Reason: "migration"}
+	checkErr(t, createDeployFreeze(db, replaced))
+	checkErr(t, createDeployFreeze(db, &models.DeployFreeze{ApplicationName: other.Name, UserId: 1, Reason: "other"}))
+
+	freezes, err := getDeployFreezes(db, a)
+	checkErr(t, err)
+	if len(freezes) != 2 {
+		t.Fatalf("wrong number of freezes. want=%d, got=%d", 2, len(freezes))
+	}
+	if freezes[0].Id != all.Id || freezes[0].Reason != "Black Friday" {
+		t.Errorf("wrong first freeze. want=%+v, got=%+v", all, freezes[0])
+	}
+	if freezes[1].Id != replaced.Id || freezes[1].Reason != "migration" || freezes[1].UserId != 2 {
+		t.Errorf("wrong second freeze. want=%+v, got=%+v", replaced, freezes[1])
+	}
+
+	err = deleteDeployFreeze(db, other, all.Id)
+	if err != sql.ErrNoRows {
+		t.Errorf("freeze of another application deleted. err=%v", err)
+	}
+	checkErr(t, deleteDeployFreeze(db, a, all.Id))
+
+	freezes, err = getDeployFreezes(db, a)
+	checkErr(t, err)
+	if len(freezes) != 1 || freezes[0].Id != replaced.Id {
+		t.Errorf("wrong freezes after deleting. got=%v", freezes)
+	}
+}
+
+func TestDeployPresets(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	a := &models.Application{Name: "flincOnRails"}
+	other := &models.Application{Name: "other"}
+
+	release := &models.DeployPreset{
+		ApplicationName: a.Name,
+		Name:            "release",
+		TargetName:      "production",
+		Branch:          "master",
+		CommentPrefix:   "[release]",
+		Stages:          []models.DeploymentStage{"CHECK_CONNECTION", "PRE_DEPLOYMENT"},
+		Overrides:       map[string]string{"WORKERS": "4"},
+		UserId:          1,
+	}
+	checkErr(t, createDeployPreset(db, release))
+	hotfix := &models.DeployPreset{ApplicationName: a.Name, Name: "hotfix", TargetName: "production", UserId: 1}
+	checkErr(t, createDeployPreset(db, hotfix))
+	// Replaces the preset with the same name
+	replaced := &models.DeployPreset{ApplicationName: a.Name, Name: "hotfix", TargetName: "staging", UserId: 2}
+	checkErr(t, createDeployPreset(db, replaced))
+	checkErr(t, createDeployPreset(db, &models.DeployPreset{ApplicationName: other.Name, Name: "release", TargetName: "production", UserId: 1}))
+
+	presets, err := getDeployPresets(db, a)
+	checkErr(t, err)
+	if len(presets) != 2 {
+		t.Fatalf("wrong number of presets. want=%d, got=%d", 2, len(presets))
+	}
+	if presets[0].Id != replaced.Id || presets[0].TargetName != "staging" || presets[0].Stages != nil || presets[0].Overrides != nil {
+		t.Errorf("wrong first preset. want=%+v, got=%+v", replaced, presets[0])
+	}
+	p := presets[1]
+	if p.Id != release.Id || p.Branch != "master" || p.CommentPrefix != "[release]" {
+		t.Errorf("wrong second preset. want=%+v, got=%+v", release, p)
+	}
+	if !reflect.DeepEqual(p.Stages, release.Stages) {
+		t.Errorf("wrong stages. want=%v, got=%v", release.Stages, p.Stages)
+	}
+	if !reflect.DeepEqual(p.Overrides, release.Overrides) {
+		t.Errorf("wrong overrides. want=%v, got=%v", release.Overrides, p.Overrides)
+	}
+
+	err = deleteDeployPreset(db, other, release.Id)
+	if err != sql.ErrNoRows {
+		t.Errorf("preset of another application deleted. err=%v", err)
+	}
+	checkErr(t, deleteDeployPreset(db, a, release.Id))
+
+	presets, err = getDeployPresets(db, a)
+	checkErr(t, err)
+	if len(presets) != 1 || presets[0].Id != replaced.Id {
+		t.Errorf("wrong presets after deleting. got=%v", presets)
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	ttl := time.Hour
+
+	first := &models.UserSession{UserId: 1, SourceIP: "10.0.0.1", UserAgent: "Firefox"}
+	checkErr(t, createUserSession(db, first))
+	second := &models.UserSession{UserId: 1, SourceIP: "10.0.0.2", UserAgent: "Safari"}
+	checkErr(t, createUserSession(db, second))
+	other := &models.UserSession{UserId: 2}
+	checkErr(t, createUserSession(db, other))
+
+	saved, err := getUserSession(db, first.Id, ttl)
+	checkErr(t, err)
+	if saved == nil || saved.UserId != 1 || saved.UserAgent != "Firefox" {
+		t.Fatalf("wrong session. want=%+v, got=%+v", first, saved)
+	}
+
+	// Expired sessions aren't returned
+	saved, err = getUserSession(db, first.Id, 0)
+	checkErr(t, err)
+	if saved != nil {
+		t.Errorf("expired session returned")
+	}
+
+	checkErr(t, touchUserSession(db, second, "10.0.0.3"))
+	sessions, err := getUserSessions(db, 1, ttl)
+	checkErr(t, err)
+	if len(sessions) != 2 || sessions[0].Id != second.Id || sessions[0].SourceIP != "10.0.0.3" {
+		t.Errorf("wrong sessions. got=%+v", sessions)
+	}
+
+	// Sessions of other users can't be deleted
+	checkErr(t, deleteUserSession(db, 2, first.Id))
+	checkErr(t, deleteOtherUserSessions(db, 1, second.Id))
+
+	sessions, err = getUserSessions(db, 1, ttl)
+	checkErr(t, err)
+	if len(sessions) != 1 || sessions[0].Id != second.Id {
+		t.Errorf("wrong sessions after deleting the others. got=%+v", sessions)
+	}
+
+	checkErr(t, deleteUserSession(db, 1, second.Id))
+	checkErr(t, deleteExpiredUserSessions(db, 0))
+
+	// Logging out everywhere leaves the sessions of other users alone
+	third := &models.UserSession{UserId: 3}
+	checkErr(t, createUserSession(db, third))
+	fourth := &models.UserSession{UserId: 4}
+	checkErr(t, createUserSession(db, fourth))
+	checkErr(t, deleteUserSessions(db, 3))
+
+	saved, err = getUserSession(db, fourth.Id, ttl)
+	checkErr(t, err)
+	if saved == nil {
+		t.Errorf("session of other user deleted")
+	}
+	saved, err = getUserSession(db, third.Id, ttl)
+	checkErr(t, err)
+	if saved != nil {
+		t.Errorf("session %s not deleted", third.Id)
+	}
+
+	for _, s := range []*models.UserSession{second, other} {
+		saved, err = getUserSession(db, s.Id, ttl)
+		checkErr(t, err)
+		if saved != nil {
+			t.Errorf("session %s not deleted", s.Id)
+		}
+	}
+}
+
+func TestGetRecentUserDeployments(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	first := buildDeployment(1)
+	checkErr(t, createDeployment(db, first))
+	second := buildDeployment(1)
+	second.ApplicationName = "other"
+	checkErr(t, createDeployment(db, second))
+	checkErr(t, createDeployment(db, buildDeployment(2)))
+
+	deployments, err := getRecentUserDeployments(db, 1, 10)
+	checkErr(t, err)
+	if len(deployments) != 2 {
+		t.Fatalf("wrong number of deployments. want=%d, got=%d", 2, len(deployments))
+	}
+	if deployments[0].Id != second.Id || deployments[0].ApplicationName != "other" {
+		t.Errorf("wrong first deployment. want=%d, got=%d", second.Id, deployments[0].Id)
+	}
+
+	deployments, err = getRecentUserDeployments(db, 1, 1)
+	checkErr(t, err)
+	if len(deployments) != 1 {
+		t.Errorf("limit ignored. got=%d deployments", len(deployments))
+	}
+}
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE log_entries ADD COLUMN exit_code INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE log_entries ADD COLUMN duration INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+SELECT 1;
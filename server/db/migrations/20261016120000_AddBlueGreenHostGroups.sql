@@ -0,0 +1,17 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE deployments ADD COLUMN host_group TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE live_host_groups (
+  application_name TEXT NOT NULL,
+  target_name TEXT NOT NULL,
+  host_group TEXT NOT NULL,
+  deployment_id INTEGER NOT NULL,
+  updated_at DATETIME,
+  PRIMARY KEY (application_name, target_name)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE live_host_groups;
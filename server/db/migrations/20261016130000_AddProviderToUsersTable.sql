@@ -0,0 +1,11 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users ADD COLUMN provider TEXT NOT NULL DEFAULT 'github';
+ALTER TABLE users ADD COLUMN provider_id TEXT NOT NULL DEFAULT '';
+UPDATE users SET provider_id = id;
+CREATE UNIQUE INDEX users_provider_provider_id ON users(provider, provider_id);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX users_provider_provider_id;
@@ -0,0 +1,12 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE user_groups (
+  user_id INTEGER NOT NULL,
+  group_name TEXT NOT NULL,
+  PRIMARY KEY (user_id, group_name)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE user_groups;
@@ -0,0 +1,9 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users ADD COLUMN refresh_token TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN token_expires_at DATETIME;
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+SELECT 1;
@@ -0,0 +1,15 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE deployment_initiators (
+  deployment_id INTEGER PRIMARY KEY,
+  token_name TEXT NOT NULL,
+  source_ip TEXT NOT NULL,
+  build_url TEXT NOT NULL DEFAULT '',
+  build_number TEXT NOT NULL DEFAULT '',
+  on_behalf_of TEXT NOT NULL DEFAULT ''
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE deployment_initiators;
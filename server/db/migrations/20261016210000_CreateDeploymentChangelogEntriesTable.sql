@@ -0,0 +1,15 @@
+
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE deployment_changelog_entries (
+  deployment_id INTEGER NOT NULL,
+  position INTEGER NOT NULL,
+  commit_sha TEXT NOT NULL,
+  author TEXT NOT NULL DEFAULT '',
+  message TEXT NOT NULL DEFAULT '',
+  PRIMARY KEY (deployment_id, position)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE deployment_changelog_entries;
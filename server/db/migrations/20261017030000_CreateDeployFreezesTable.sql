@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE deploy_freezes (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  application_name TEXT NOT NULL,
+  target_name TEXT NOT NULL DEFAULT '',
+  user_id INTEGER NOT NULL,
+  reason TEXT NOT NULL,
+  created_at DATETIME NOT NULL,
+  UNIQUE (application_name, target_name)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE deploy_freezes;
@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE user_sessions (
+  id TEXT PRIMARY KEY NOT NULL,
+  user_id INTEGER NOT NULL,
+  source_ip TEXT NOT NULL DEFAULT '',
+  user_agent TEXT NOT NULL DEFAULT '',
+  created_at DATETIME NOT NULL,
+  last_seen_at DATETIME NOT NULL
+);
+CREATE INDEX user_sessions_user_id ON user_sessions (user_id);
+ALTER TABLE user_preferences ADD COLUMN deployment_notifications TEXT NOT NULL DEFAULT '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE user_sessions;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+ALTER TABLE users ADD COLUMN api_token_hash TEXT NOT NULL DEFAULT '';
+CREATE INDEX users_api_token_hash ON users(api_token_hash);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX users_api_token_hash;
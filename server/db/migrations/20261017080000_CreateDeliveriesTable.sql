@@ -0,0 +1,23 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE deliveries (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  deployment_id INTEGER NOT NULL,
+  notifier TEXT NOT NULL,
+  method TEXT NOT NULL,
+  url TEXT NOT NULL,
+  header TEXT NOT NULL,
+  payload TEXT NOT NULL,
+  payload_hash TEXT NOT NULL,
+  expected_status INTEGER NOT NULL,
+  status_code INTEGER NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  latency INTEGER NOT NULL,
+  retry_of INTEGER NOT NULL DEFAULT 0,
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX deliveries_created_at ON deliveries (created_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE deliveries;
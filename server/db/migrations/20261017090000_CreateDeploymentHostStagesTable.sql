@@ -0,0 +1,17 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE deployment_host_stages (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  deployment_id INTEGER NOT NULL,
+  host TEXT NOT NULL,
+  stage TEXT NOT NULL,
+  state TEXT NOT NULL,
+  last_command TEXT NOT NULL DEFAULT '',
+  last_command_done INTEGER NOT NULL DEFAULT 0,
+  updated_at DATETIME NOT NULL,
+  UNIQUE (deployment_id, host, stage)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE deployment_host_stages;
@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE event_records (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  type TEXT NOT NULL,
+  deployment_id INTEGER NOT NULL,
+  application_name TEXT NOT NULL,
+  target_name TEXT NOT NULL,
+  state TEXT NOT NULL,
+  commit_sha TEXT NOT NULL,
+  branch TEXT NOT NULL,
+  tag TEXT NOT NULL,
+  comment TEXT NOT NULL,
+  user_name TEXT NOT NULL,
+  created_at DATETIME NOT NULL
+);
+CREATE INDEX event_records_application_name ON event_records (application_name);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE event_records;
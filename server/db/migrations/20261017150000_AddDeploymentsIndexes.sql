@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE INDEX deployments_application_name_target_name_created_at ON deployments (application_name, target_name, created_at);
+CREATE INDEX deployments_state ON deployments (state);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP INDEX deployments_state;
+DROP INDEX deployments_application_name_target_name_created_at;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+CREATE TABLE deploy_presets (
+  id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+  application_name TEXT NOT NULL,
+  name TEXT NOT NULL,
+  target_name TEXT NOT NULL,
+  branch TEXT NOT NULL DEFAULT '',
+  comment_prefix TEXT NOT NULL DEFAULT '',
+  stages TEXT NOT NULL DEFAULT '',
+  overrides TEXT NOT NULL DEFAULT '',
+  user_id INTEGER NOT NULL,
+  created_at DATETIME NOT NULL,
+  UNIQUE (application_name, name)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+DROP TABLE deploy_presets;
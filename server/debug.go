@@ -0,0 +1,52 @@
+package main
+
+import (
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+)
+
+func init() {
+	expvar.Publish("goroutines", expvar.Func(func() interface{} {
+		return runtime.NumGoroutine()
+	}))
+	expvar.Publish("active_deployments", expvar.Func(func() interface{} {
+		if killRegistry == nil {
+			return 0
+		}
+		return killRegistry.Len()
+	}))
+}
+
+// debugHandler serves the profiles of net/http/pprof at /debug/pprof/ and the
+// variables of expvar, e.g. the memory stats, at /debug/vars. It doesn't check
+// who is asking, so it's either wrapped in admins or served on -debug-addr.
+func debugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	return mux
+}
+
+// checkDebugAddr makes sure the debug listener can only be reached from the
+// host itself, since it doesn't require a login.
+func checkDebugAddr(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid -debug-addr %q: %s", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("-debug-addr %q is not a loopback address, use e.g. localhost:6060", addr)
+}
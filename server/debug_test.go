@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/context"
+)
+
+func TestCheckDebugAddr(t *testing.T) {
+	tests := []struct {
+		addr string
+		err  string
+	}{
+		{"localhost:6060", ""},
+		{"127.0.0.1:6060", ""},
+		{"[::1]:6060", ""},
+		{":6060", "not a loopback address"},
+		{"0.0.0.0:6060", "not a loopback address"},
+		{"applikatoni.shipping-company.com:6060", "not a loopback address"},
+		{"localhost", "invalid -debug-addr"},
+	}
+
+	for _, tt := range tests {
+		err := checkDebugAddr(tt.addr)
+		if tt.err == "" && err != nil {
+			t.Errorf("unexpected error for %s: %s", tt.addr, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.addr, tt.err, err)
+		}
+	}
+}
+
+func TestDebugHandlerAdmins(t *testing.T) {
+	setConfig(&Configuration{AdminUsernames: []string{"fabrik42"}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	h := admins(debugHandler().ServeHTTP)
+
+	tests := []struct {
+		user           string
+		path           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"mrnugget", "/debug/vars", 403, "only admins can do this"},
+		{"fabrik42", "/debug/vars", 200, `"goroutines":`},
+		{"fabrik42", "/debug/pprof/", 200, "heap"},
+		{"fabrik42", "/debug/pprof/goroutine?debug=1", 200, "goroutine profile:"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		context.Set(req, CurrentUser, &models.User{Name: tt.user})
+		rec := httptest.NewRecorder()
+
+		h(rec, req)
+		context.Clear(req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s. want=%d, got=%d", tt.user, tt.path, tt.expectedStatus, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tt.expectedBody) {
+			t.Errorf("wrong body for %s %s. want=%q in %s", tt.user, tt.path, tt.expectedBody, rec.Body.String())
+		}
+	}
+}
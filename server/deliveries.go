@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+const defaultDeliveriesLimit = 200
+
+// The notifiers whose deliveries are recorded. The GitHub notifiers talk to
+// the API through the client of the user and aren't recorded.
+var deliveryNotifiers = []string{"bugsnag", "flowdock", "new_relic", "slack", "webhook"}
+
+// deliveryFilter selects the deliveries shown on the admin page.
+type deliveryFilter struct {
+	Notifier string
+	// Only the deliveries that didn't get the expected answer
+	Failed bool
+	Limit  int
+}
+
+func parseDeliveryFilter(q url.Values) (*deliveryFilter, error) {
+	f := &deliveryFilter{
+		Notifier: q.Get("notifier"),
+		Failed:   q.Get("failed") == "true",
+		Limit:    defaultDeliveriesLimit,
+	}
+
+	if f.Notifier != "" && !isDeliveryNotifier(f.Notifier) {
+		return nil, fmt.Errorf("unknown notifier %q", f.Notifier)
+	}
+
+	return f, nil
+}
+
+func isDeliveryNotifier(notifier string) bool {
+	for _, n := range deliveryNotifiers {
+		if n == notifier {
+			return true
+		}
+	}
+	return false
+}
+
+// newDelivery returns the delivery of a notification of the deployment,
+// ready to be sent with deliver.
+func newDelivery(notifier string, deploymentId int, method, url string, header http.Header, payload []byte, expectedStatus int) *models.Delivery {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &models.Delivery{
+		DeploymentId:   deploymentId,
+		Notifier:       notifier,
+		Method:         method,
+		URL:            url,
+		Header:         header,
+		Payload:        payload,
+		ExpectedStatus: expectedStatus,
+	}
+}
+
+// deliver sends the notification and records the attempt with the answer of
+// the service, so it can be inspected and retried by admins. It returns an
+// error if the service couldn't be reached or didn't answer with the
+// expected status.
+func deliver(d *models.Delivery) error {
+	sum := sha256.Sum256(d.Payload)
+	d.PayloadHash = hex.EncodeToString(sum[:])
+
+	err := sendDelivery(d)
+	if err != nil {
+		d.Error = err.Error()
+	}
+	recordDelivery(d)
+
+	if err != nil {
+		return err
+	}
+	if !d.Succeeded() {
+		return fmt.Errorf("unexpected status %d", d.StatusCode)
+	}
+	return nil
+}
+
+func sendDelivery(d *models.Delivery) error {
+	req, err := http.NewRequest(d.Method, d.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header = http.Header(d.Header).Clone()
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	d.Latency = time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		// The error would contain the URL, which is only stored encrypted
+		if urlErr, ok := err.(*url.Error); ok {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	d.StatusCode = resp.StatusCode
+	return nil
+}
+
+// recordDelivery saves the delivery. Failing to save it is only logged, the
+// notification has already been sent.
+func recordDelivery(d *models.Delivery) {
+	if db == nil {
+		return
+	}
+
+	if err := createDelivery(db, d); err != nil {
+		slog.Error("could not record delivery", "notifier", d.Notifier, "deployment.id", d.DeploymentId, "err", err)
+	}
+}
+
+// retryDelivery sends the notification of the delivery again and returns
+// the new delivery.
+func retryDelivery(failed *models.Delivery) (*models.Delivery, error) {
+	d := newDelivery(failed.Notifier, failed.DeploymentId, failed.Method, failed.URL,
+		failed.Header, failed.Payload, failed.ExpectedStatus)
+	d.RetryOf = failed.Id
+
+	return d, deliver(d)
+}
+
+func adminDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseDeliveryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	deliveries, err := getFilteredDeliveries(db, filter)
+	if err != nil {
+		requestLogger(r).Error("error loading deliveries", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "admin_deliveries.tmpl", map[string]interface{}{
+		"Applications": getConfig().Applications,
+		"Deliveries":   deliveries,
+		"Notifiers":    deliveryNotifiers,
+		"Limit":        filter.Limit,
+		"Query":        r.URL.Query(),
+		"currentUser":  getCurrentUser(r),
+	})
+}
+
+func retryDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	deliveryId, err := strconv.Atoi(mux.Vars(r)["deliveryId"])
+	if err != nil {
+		http.Error(w, "delivery not found", http.StatusNotFound)
+		return
+	}
+
+	failed, err := getDelivery(db, deliveryId)
+	if err != nil {
+		http.Error(w, "delivery not found", http.StatusNotFound)
+		return
+	}
+	if failed.Succeeded() {
+		http.Error(w, "the delivery succeeded, only failed deliveries can be retried", 422)
+		return
+	}
+
+	d, err := retryDelivery(failed)
+	if err != nil {
+		requestLogger(r).Warn("retrying delivery failed", "delivery_id", failed.Id, "notifier", failed.Notifier, "err", err)
+		addFlash(w, r, "Retrying delivery %d failed: %s", failed.Id, err)
+	} else {
+		addFlash(w, r, "Delivery %d has been retried.", failed.Id)
+	}
+	recordAuditEvent(r, getCurrentUser(r), models.AUDIT_DELIVERY_RETRY, fmt.Sprintf("%s #%d", d.Notifier, failed.Id))
+
+	http.Redirect(w, r, "/admin/deliveries", http.StatusSeeOther)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestDeliver(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	var err error
+	fieldCipher, err = newFieldCipher(testEncryptionKey)
+	checkErr(t, err)
+	defer func() { fieldCipher = nil }()
+
+	status := 200
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			t.Errorf("wrong api key header. got=%q", r.Header.Get("X-Api-Key"))
+		}
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	header := http.Header{"X-Api-Key": {"secret"}}
+	d := newDelivery("new_relic", 12, "POST", ts.URL+"/hook", header, []byte("revision=abc"), 200)
+	checkErr(t, deliver(d))
+	if body != "revision=abc" {
+		t.Errorf("wrong body. want=%q, got=%q", "revision=abc", body)
+	}
+
+	status = 500
+	failed := newDelivery("new_relic", 12, "POST", ts.URL+"/hook", header, []byte("revision=def"), 200)
+	if err := deliver(failed); err == nil {
+		t.Errorf("no error for status 500")
+	}
+
+	for _, expected := range []*models.Delivery{d, failed} {
+		saved, err := getDelivery(db, expected.Id)
+		checkErr(t, err)
+
+		if saved.URL != expected.URL {
+			t.Errorf("wrong url. want=%q, got=%q", expected.URL, saved.URL)
+		}
+		if http.Header(saved.Header).Get("X-Api-Key") != "secret" {
+			t.Errorf("wrong header. got=%v", saved.Header)
+		}
+		if string(saved.Payload) != string(expected.Payload) {
+			t.Errorf("wrong payload. want=%q, got=%q", expected.Payload, saved.Payload)
+		}
+		if saved.PayloadHash == "" || saved.PayloadHash != expected.PayloadHash {
+			t.Errorf("wrong payload hash. want=%q, got=%q", expected.PayloadHash, saved.PayloadHash)
+		}
+		if saved.StatusCode != expected.StatusCode || saved.DeploymentId != 12 {
+			t.Errorf("wrong delivery. want=%+v, got=%+v", expected, saved)
+		}
+	}
+
+	if !d.Succeeded() || failed.Succeeded() {
+		t.Errorf("wrong success. first=%t, second=%t", d.Succeeded(), failed.Succeeded())
+	}
+
+	// The secrets aren't stored in plain text
+	var rawURL, rawHeader string
+	err = db.QueryRow("SELECT url, header FROM deliveries WHERE id = ?", d.Id).Scan(&rawURL, &rawHeader)
+	checkErr(t, err)
+	if strings.Contains(rawURL, ts.URL) || strings.Contains(rawHeader, "secret") {
+		t.Errorf("delivery not encrypted. url=%q, header=%q", rawURL, rawHeader)
+	}
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	ts := httptest.NewServer(http.NotFoundHandler())
+	ts.Close()
+
+	d := newDelivery("slack", 1, "POST", ts.URL, nil, []byte("{}"), 200)
+	if err := deliver(d); err == nil {
+		t.Fatalf("no error for unreachable service")
+	}
+
+	saved, err := getDelivery(db, d.Id)
+	checkErr(t, err)
+	if saved.Error == "" || saved.StatusCode != 0 || saved.Succeeded() {
+		t.Errorf("wrong delivery. got=%+v", saved)
+	}
+}
+
+func TestRetryDelivery(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	status := 503
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	failed := newDelivery("flowdock", 3, "POST", ts.URL, nil, []byte("event=message"), 201)
+	if err := deliver(failed); err == nil {
+		t.Fatalf("no error for status 503")
+	}
+
+	status = 201
+	retry, err := retryDelivery(failed)
+	checkErr(t, err)
+
+	if retry.Id == failed.Id || retry.RetryOf != failed.Id {
+		t.Errorf("wrong retry. want retry of %d, got=%+v", failed.Id, retry)
+	}
+	if retry.PayloadHash != failed.PayloadHash || !retry.Succeeded() {
+		t.Errorf("wrong retry. got=%+v", retry)
+	}
+}
+
+func TestGetFilteredDeliveries(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	deliveries := []*models.Delivery{
+		{Notifier: "slack", Method: "POST", ExpectedStatus: 200, StatusCode: 200},
+		{Notifier: "slack", Method: "POST", ExpectedStatus: 200, StatusCode: 404},
+		{Notifier: "webhook", Method: "POST", ExpectedStatus: 0, StatusCode: 204},
+		{Notifier: "webhook", Method: "POST", ExpectedStatus: 0, Error: "connection refused"},
+	}
+	for _, d := range deliveries {
+		checkErr(t, createDelivery(db, d))
+	}
+
+	tests := []struct {
+		query    string
+		expected []*models.Delivery
+	}{
+		{"", []*models.Delivery{deliveries[3], deliveries[2], deliveries[1], deliveries[0]}},
+		{"notifier=slack", []*models.Delivery{deliveries[1], deliveries[0]}},
+		{"failed=true", []*models.Delivery{deliveries[3], deliveries[1]}},
+		{"notifier=webhook&failed=true", []*models.Delivery{deliveries[3]}},
+	}
+
+	for _, tt := range tests {
+		q, _ := url.ParseQuery(tt.query)
+		f, err := parseDeliveryFilter(q)
+		checkErr(t, err)
+
+		got, err := getFilteredDeliveries(db, f)
+		checkErr(t, err)
+
+		if len(got) != len(tt.expected) {
+			t.Errorf("wrong number of deliveries for %q. want=%d, got=%d", tt.query, len(tt.expected), len(got))
+			continue
+		}
+		for i := range got {
+			if got[i].Id != tt.expected[i].Id {
+				t.Errorf("wrong delivery %d for %q. want=%d, got=%d", i, tt.query, tt.expected[i].Id, got[i].Id)
+			}
+		}
+	}
+
+	if _, err := parseDeliveryFilter(url.Values{"notifier": {"github"}}); err == nil {
+		t.Errorf("no error for unknown notifier")
+	}
+}
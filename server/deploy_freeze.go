@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// findDeployFreeze returns the freeze stopping deployments to the target, nil
+// if there is none. A freeze of the target is preferred to one of all targets.
+func findDeployFreeze(freezes []*models.DeployFreeze, targetName string) *models.DeployFreeze {
+	var found *models.DeployFreeze
+	for _, f := range freezes {
+		if f.TargetName == targetName {
+			return f
+		}
+		if f.AppliesTo(targetName) {
+			found = f
+		}
+	}
+	return found
+}
+
+// getTargetDeployFreeze loads the freeze of the target and who froze it.
+func getTargetDeployFreeze(a *models.Application, targetName string) (*models.DeployFreeze, error) {
+	freezes, err := getDeployFreezes(db, a)
+	if err != nil {
+		return nil, err
+	}
+
+	freeze := findDeployFreeze(freezes, targetName)
+	if freeze == nil {
+		return nil, nil
+	}
+
+	err = loadDeployFreezesUsers(db, []*models.DeployFreeze{freeze})
+	if err != nil {
+		return nil, err
+	}
+	return freeze, nil
+}
+
+// checkDeployFreeze answers deployments to frozen targets with 403. Admins can
+// deploy anyway if they override the freeze, automatic deployments can't.
+func checkDeployFreeze(a *models.Application, t *models.Target, u *models.User, override bool) *requestError {
+	freeze, err := getTargetDeployFreeze(a, t.Name)
+	if err != nil {
+		slog.Error("loading the deploy freezes failed", "application", a.Name, "err", err)
+		return &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	if freeze == nil {
+		return nil
+	}
+
+	if override && getConfig().IsAdmin(u) {
+		slog.Info("deploy freeze overridden", "user", u.Name, "application", a.Name, "target", t.Name)
+		return nil
+	}
+
+	return &requestError{http.StatusForbidden, deployFreezeMessage(freeze, t.Name)}
+}
+
+func deployFreezeMessage(f *models.DeployFreeze, targetName string) string {
+	by := fmt.Sprintf("user #%d", f.UserId)
+	if f.User != nil {
+		by = f.User.Name
+	}
+	return fmt.Sprintf("deployments to %s are frozen by %s: %s", targetName, by, f.Reason)
+}
+
+func deployFreezeAuditSubject(f *models.DeployFreeze) string {
+	if f.TargetName == "" {
+		return f.ApplicationName
+	}
+	return f.ApplicationName + "/" + f.TargetName
+}
+
+// deployFreezeFlashTarget names the frozen target in flash messages.
+func deployFreezeFlashTarget(r *http.Request, f *models.DeployFreeze) string {
+	if f.TargetName == "" {
+		return translate(requestLanguage(r), "all targets")
+	}
+	return f.TargetName
+}
+
+// createDeployFreezeFromRequest validates and saves a freeze of the target,
+// or of all targets of the application if no target is given. Only admins can
+// freeze and unfreeze.
+func createDeployFreezeFromRequest(r *http.Request, a *models.Application, u *models.User, targetName, reason string) (*models.DeployFreeze, *requestError) {
+	if !getConfig().IsAdmin(u) {
+		return nil, &requestError{http.StatusForbidden, "only admins can freeze deployments"}
+	}
+
+	if targetName != "" {
+		if _, err := findTarget(a, targetName); err != nil {
+			return nil, &requestError{http.StatusNotFound, "target not found"}
+		}
+	}
+
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, &requestError{422, "reason is empty"}
+	}
+
+	freezes, err := getDeployFreezes(db, a)
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy freezes", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	for _, f := range freezes {
+		if f.TargetName == targetName {
+			return nil, &requestError{http.StatusConflict, "deployments are already frozen"}
+		}
+	}
+
+	freeze := &models.DeployFreeze{
+		ApplicationName: a.Name,
+		TargetName:      targetName,
+		UserId:          u.Id,
+		User:            u,
+		Reason:          reason,
+	}
+	err = createDeployFreeze(db, freeze)
+	if err != nil {
+		requestLogger(r).Error("error saving the deploy freeze", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	recordAuditEvent(r, u, models.AUDIT_DEPLOY_FREEZE, deployFreezeAuditSubject(freeze))
+
+	return freeze, nil
+}
+
+// deleteDeployFreezeFromRequest lifts the freeze with the id and returns it.
+func deleteDeployFreezeFromRequest(r *http.Request, a *models.Application, u *models.User, id int) (*models.DeployFreeze, *requestError) {
+	if !getConfig().IsAdmin(u) {
+		return nil, &requestError{http.StatusForbidden, "only admins can unfreeze deployments"}
+	}
+
+	freezes, err := getDeployFreezes(db, a)
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy freezes", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+
+	var freeze *models.DeployFreeze
+	for _, f := range freezes {
+		if f.Id == id {
+			freeze = f
+		}
+	}
+	if freeze == nil {
+		return nil, &requestError{http.StatusNotFound, "freeze not found"}
+	}
+
+	err = deleteDeployFreeze(db, a, id)
+	if err == sql.ErrNoRows {
+		return nil, &requestError{http.StatusNotFound, "freeze not found"}
+	}
+	if err != nil {
+		requestLogger(r).Error("error deleting the deploy freeze", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	recordAuditEvent(r, u, models.AUDIT_DEPLOY_UNFREEZE, deployFreezeAuditSubject(freeze))
+
+	return freeze, nil
+}
+
+func freezeHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	freeze, reqErr := createDeployFreezeFromRequest(r, application, getCurrentUser(r), r.FormValue("target"), r.FormValue("reason"))
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	addFlash(w, r, "Deployments to %s have been frozen.", deployFreezeFlashTarget(r, freeze))
+	http.Redirect(w, r, "/"+application.Name, http.StatusSeeOther)
+}
+
+func unfreezeHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	id, err := strconv.Atoi(mux.Vars(r)["freezeId"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	freeze, reqErr := deleteDeployFreezeFromRequest(r, application, getCurrentUser(r), id)
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	addFlash(w, r, "Deployments to %s are no longer frozen.", deployFreezeFlashTarget(r, freeze))
+	http.Redirect(w, r, "/"+application.Name, http.StatusSeeOther)
+}
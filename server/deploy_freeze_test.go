@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestFindDeployFreeze(t *testing.T) {
+	all := &models.DeployFreeze{Reason: "all"}
+	production := &models.DeployFreeze{TargetName: "production", Reason: "production"}
+
+	tests := []struct {
+		freezes  []*models.DeployFreeze
+		target   string
+		expected *models.DeployFreeze
+	}{
+		{nil, "production", nil},
+		{[]*models.DeployFreeze{production}, "staging", nil},
+		{[]*models.DeployFreeze{production}, "production", production},
+		{[]*models.DeployFreeze{all}, "staging", all},
+		{[]*models.DeployFreeze{all, production}, "production", production},
+		{[]*models.DeployFreeze{all, production}, "staging", all},
+	}
+
+	for _, tt := range tests {
+		if got := findDeployFreeze(tt.freezes, tt.target); got != tt.expected {
+			t.Errorf("wrong freeze of %s. want=%v, got=%v", tt.target, tt.expected, got)
+		}
+	}
+}
+
+func TestCheckDeployFreeze(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{AdminUsernames: []string{"fabrik42"}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	a := &models.Application{Name: "flincOnRails"}
+	production := &models.Target{Name: "production"}
+	staging := &models.Target{Name: "staging"}
+
+	admin := buildUser(1, "fabrik42")
+	checkErr(t, createUser(db, admin))
+	deployer := buildUser(2, "mrnugget")
+	checkErr(t, createUser(db, deployer))
+
+	freeze := &models.DeployFreeze{ApplicationName: a.Name, TargetName: "production", UserId: admin.Id, Reason: "incident"}
+	checkErr(t, createDeployFreeze(db, freeze))
+
+	tests := []struct {
+		target   *models.Target
+		user     *models.User
+		override bool
+		status   int
+	}{
+		{staging, deployer, false, 0},
+		{production, deployer, false, http.StatusForbidden},
+		{production, deployer, true, http.StatusForbidden},
+		{production, admin, false, http.StatusForbidden},
+		{production, admin, true, 0},
+	}
+
+	for _, tt := range tests {
+		reqErr := checkDeployFreeze(a, tt.target, tt.user, tt.override)
+		status := 0
+		if reqErr != nil {
+			status = reqErr.Status
+		}
+		if status != tt.status {
+			t.Errorf("wrong status of %s deploying to %s (override=%t). want=%d, got=%d", tt.user.Name, tt.target.Name, tt.override, tt.status, status)
+		}
+	}
+
+	reqErr := checkDeployFreeze(a, production, deployer, false)
+	expected := "deployments to production are frozen by fabrik42: incident"
+	if reqErr == nil || reqErr.Message != expected {
+		t.Errorf("wrong message. want=%q, got=%v", expected, reqErr)
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func findDeployPreset(presets []*models.DeployPreset, name string) *models.DeployPreset {
+	for _, p := range presets {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func deployPresetAuditSubject(p *models.DeployPreset) string {
+	return p.ApplicationName + "/" + p.Name
+}
+
+// applyDeployPreset fills the fields of the deploy form that are left empty
+// with the values of the preset named in the form field preset. The comment
+// is prefixed with the comment prefix of the preset.
+func applyDeployPreset(r *http.Request, a *models.Application) *requestError {
+	name := r.FormValue("preset")
+	if name == "" {
+		return nil
+	}
+
+	presets, err := getDeployPresets(db, a)
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy presets", "err", err)
+		return &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	preset := findDeployPreset(presets, name)
+	if preset == nil {
+		return &requestError{422, fmt.Sprintf("preset %s not found", name)}
+	}
+
+	setDefault := func(field, value string) {
+		if r.FormValue(field) == "" && value != "" {
+			r.Form.Set(field, value)
+		}
+	}
+
+	setDefault("target", preset.TargetName)
+	// The branch of the preset is only deployed if nothing else is
+	if r.FormValue("commitsha") == "" && r.FormValue("tag") == "" && r.FormValue("pull_request") == "" {
+		setDefault("branch", preset.Branch)
+	}
+	setDefault("overrides", preset.FormatOverrides())
+	r.Form.Set("comment", preset.PrefixComment(r.FormValue("comment")))
+
+	if len(r.Form["stages[]"]) == 0 {
+		stages := preset.Stages
+		if len(stages) == 0 {
+			if target, err := findTarget(a, r.FormValue("target")); err == nil {
+				stages = target.AvailableStages
+			}
+		}
+		for _, s := range stages {
+			r.Form.Add("stages[]", string(s))
+		}
+	}
+
+	return nil
+}
+
+// createDeployPresetFromRequest validates and saves the preset, replacing
+// the preset of the application with the same name. Users can only save
+// presets of targets they can deploy to.
+func createDeployPresetFromRequest(r *http.Request, a *models.Application, u *models.User) (*models.DeployPreset, *requestError) {
+	name := strings.TrimSpace(r.FormValue("preset_name"))
+	if name == "" {
+		return nil, &requestError{422, "preset name is empty"}
+	}
+
+	target, err := findTarget(a, r.FormValue("target"))
+	if err != nil {
+		return nil, &requestError{http.StatusNotFound, "target not found"}
+	}
+	if !a.CanDeploy(target, u) {
+		return nil, &requestError{403, "not authorized to deploy to this target"}
+	}
+
+	branch := strings.TrimSpace(r.FormValue("branch"))
+	if branch != "" && !target.IsDeployableBranch(branch) {
+		msg := "branch %q can't be deployed to %s. Deployable branches: %v"
+		return nil, &requestError{422, fmt.Sprintf(msg, branch, target.Name, target.DeployableBranches)}
+	}
+
+	stages := []models.DeploymentStage{}
+	for _, fs := range r.Form["stages[]"] {
+		stages = append(stages, models.DeploymentStage(fs))
+	}
+	if !target.AreValidStages(stages) {
+		msg := "stages have wrong order or contain invalid stages. Available stages: %v"
+		return nil, &requestError{422, fmt.Sprintf(msg, target.AvailableStages)}
+	}
+	// Presets with all stages also deploy the stages added to the target later
+	if len(stages) == 0 || reflect.DeepEqual(stages, target.AvailableStages) {
+		stages = nil
+	}
+
+	overrides, err := parseOverrides(target, r.FormValue("overrides"))
+	if err != nil {
+		return nil, &requestError{422, err.Error()}
+	}
+
+	preset := &models.DeployPreset{
+		ApplicationName: a.Name,
+		Name:            name,
+		TargetName:      target.Name,
+		Branch:          branch,
+		CommentPrefix:   strings.TrimSpace(r.FormValue("comment")),
+		Stages:          stages,
+		Overrides:       overrides,
+		UserId:          u.Id,
+	}
+	err = createDeployPreset(db, preset)
+	if err != nil {
+		requestLogger(r).Error("error saving the deploy preset", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	recordAuditEvent(r, u, models.AUDIT_DEPLOY_PRESET_SAVE, deployPresetAuditSubject(preset))
+
+	return preset, nil
+}
+
+// deleteDeployPresetFromRequest deletes the preset with the id and returns
+// it. Users can only delete presets of targets they can deploy to.
+func deleteDeployPresetFromRequest(r *http.Request, a *models.Application, u *models.User, id int) (*models.DeployPreset, *requestError) {
+	presets, err := getDeployPresets(db, a)
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy presets", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+
+	var preset *models.DeployPreset
+	for _, p := range presets {
+		if p.Id == id {
+			preset = p
+		}
+	}
+	if preset == nil {
+		return nil, &requestError{http.StatusNotFound, "preset not found"}
+	}
+
+	// Presets of removed targets can be deleted by admins
+	target, err := findTarget(a, preset.TargetName)
+	if (err != nil || !a.CanDeploy(target, u)) && !getConfig().IsAdmin(u) {
+		return nil, &requestError{403, "not authorized to deploy to this target"}
+	}
+
+	err = deleteDeployPreset(db, a, id)
+	if err == sql.ErrNoRows {
+		return nil, &requestError{http.StatusNotFound, "preset not found"}
+	}
+	if err != nil {
+		requestLogger(r).Error("error deleting the deploy preset", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	recordAuditEvent(r, u, models.AUDIT_DEPLOY_PRESET_DELETE, deployPresetAuditSubject(preset))
+
+	return preset, nil
+}
+
+func presetHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	preset, reqErr := createDeployPresetFromRequest(r, application, getCurrentUser(r))
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	addFlash(w, r, "Preset %s has been saved.", preset.Name)
+	http.Redirect(w, r, "/"+application.Name, http.StatusSeeOther)
+}
+
+func deletePresetHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	id, err := strconv.Atoi(mux.Vars(r)["presetId"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	preset, reqErr := deleteDeployPresetFromRequest(r, application, getCurrentUser(r), id)
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	addFlash(w, r, "Preset %s has been deleted.", preset.Name)
+	http.Redirect(w, r, "/"+application.Name, http.StatusSeeOther)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestApplyDeployPreset(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	a := &models.Application{
+		Name: "flincOnRails",
+		Targets: []*models.Target{
+			{Name: "production", AvailableStages: []models.DeploymentStage{"CHECK_CONNECTION", "PRE_DEPLOYMENT", "CODE_DEPLOYMENT"}},
+			{Name: "staging", AvailableStages: []models.DeploymentStage{"CHECK_CONNECTION", "CODE_DEPLOYMENT"}},
+		},
+	}
+
+	release := &models.DeployPreset{
+		ApplicationName: a.Name,
+		Name:            "release",
+		TargetName:      "production",
+		Branch:          "master",
+		CommentPrefix:   "[release]",
+		Stages:          []models.DeploymentStage{"CHECK_CONNECTION", "CODE_DEPLOYMENT"},
+		Overrides:       map[string]string{"WORKERS": "4"},
+		UserId:          1,
+	}
+	checkErr(t, createDeployPreset(db, release))
+	checkErr(t, createDeployPreset(db, &models.DeployPreset{ApplicationName: a.Name, Name: "staging", TargetName: "staging", UserId: 1}))
+
+	tests := []struct {
+		form     url.Values
+		expected url.Values
+	}{
+		// Without a preset nothing is changed
+		{
+			url.Values{"comment": {"Fix signup"}},
+			url.Values{"comment": {"Fix signup"}},
+		},
+		{
+			url.Values{"preset": {"release"}, "comment": {"Fix signup"}},
+			url.Values{
+				"preset":    {"release"},
+				"target":    {"production"},
+				"branch":    {"master"},
+				"comment":   {"[release] Fix signup"},
+				"stages[]":  {"CHECK_CONNECTION", "CODE_DEPLOYMENT"},
+				"overrides": {"WORKERS=4"},
+			},
+		},
+		// The values given are kept, the branch isn't set if a commit is
+		{
+			url.Values{
+				"preset":    {"release"},
+				"commitsha": {"d1a2b3c4d5e6f7a8b9c0d1a2b3c4d5e6f7a8b9c0"},
+				"comment":   {"[release] Fix signup"},
+				"stages[]":  {"CODE_DEPLOYMENT"},
+				"overrides": {"WORKERS=8"},
+			},
+			url.Values{
+				"preset":    {"release"},
+				"target":    {"production"},
+				"commitsha": {"d1a2b3c4d5e6f7a8b9c0d1a2b3c4d5e6f7a8b9c0"},
+				"comment":   {"[release] Fix signup"},
+				"stages[]":  {"CODE_DEPLOYMENT"},
+				"overrides": {"WORKERS=8"},
+			},
+		},
+		// Presets without stages deploy all stages of the target
+		{
+			url.Values{"preset": {"staging"}, "comment": {"Try signup"}},
+			url.Values{
+				"preset":   {"staging"},
+				"target":   {"staging"},
+				"comment":  {"Try signup"},
+				"stages[]": {"CHECK_CONNECTION", "CODE_DEPLOYMENT"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/flincOnRails/deployments", nil)
+		r.Form = tt.form
+
+		if reqErr := applyDeployPreset(r, a); reqErr != nil {
+			t.Fatalf("applying the preset failed: %s", reqErr.Message)
+		}
+		if !reflect.DeepEqual(r.Form, tt.expected) {
+			t.Errorf("wrong form values. want=%v, got=%v", tt.expected, r.Form)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/flincOnRails/deployments", nil)
+	r.Form = url.Values{"preset": {"hotfix"}}
+	reqErr := applyDeployPreset(r, a)
+	if reqErr == nil || reqErr.Status != http.StatusUnprocessableEntity {
+		t.Errorf("unknown preset not refused. got=%v", reqErr)
+	}
+}
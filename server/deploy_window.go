@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// checkDeployWindow answers deployments outside the deploy windows of the
+// target with 403. Admins can deploy anyway if they give a reason, automatic
+// deployments can't.
+func checkDeployWindow(t *models.Target, u *models.User, overrideReason string, now time.Time) *requestError {
+	if t.InDeployWindow(now) {
+		return nil
+	}
+
+	if getConfig().IsAdmin(u) {
+		if overrideReason != "" {
+			slog.Info("deploying outside the deploy windows", "user", u.Name, "target", t.Name, "reason", overrideReason)
+			return nil
+		}
+		return &requestError{http.StatusForbidden, deployWindowMessage(t) + ". Give a reason to deploy anyway"}
+	}
+
+	return &requestError{http.StatusForbidden, deployWindowMessage(t)}
+}
+
+func deployWindowMessage(t *models.Target) string {
+	windows := []string{}
+	for _, w := range t.DeployWindows {
+		windows = append(windows, w.String())
+	}
+	return "deployments to " + t.Name + " are only allowed " + strings.Join(windows, " or ")
+}
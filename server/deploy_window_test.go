@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestCheckDeployWindow(t *testing.T) {
+	setConfig(&Configuration{AdminUsernames: []string{"fabrik42"}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	target := &models.Target{
+		Name: "production",
+		DeployWindows: []*models.DeployWindow{
+			{Days: []string{"mon-thu"}, Start: "09:00", End: "17:00", TimeZone: "UTC"},
+		},
+	}
+	admin := buildUser(1, "fabrik42")
+	deployer := buildUser(2, "mrnugget")
+
+	inside := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	outside := time.Date(2026, 10, 23, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		user     *models.User
+		reason   string
+		at       time.Time
+		expected int
+	}{
+		{deployer, "", inside, 0},
+		{deployer, "", outside, http.StatusForbidden},
+		{deployer, "hotfix", outside, http.StatusForbidden},
+		{admin, "", outside, http.StatusForbidden},
+		{admin, "hotfix", outside, 0},
+	}
+
+	for _, tt := range tests {
+		reqErr := checkDeployWindow(target, tt.user, tt.reason, tt.at)
+		status := 0
+		if reqErr != nil {
+			status = reqErr.Status
+			if !strings.Contains(reqErr.Message, "only allowed mon-thu 09:00-17:00 UTC") {
+				t.Errorf("wrong message. got=%q", reqErr.Message)
+			}
+		}
+		if status != tt.expected {
+			t.Errorf("wrong status for %s with reason %q. want=%d, got=%d", tt.user.Name, tt.reason, tt.expected, status)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const deploymentFilterDateLayout = "2006-01-02"
+
+// deploymentFilter narrows down the deployments of an application. Empty
+// fields don't filter.
+type deploymentFilter struct {
+	TargetName string
+	State      models.DeploymentState
+	Branch     string
+	// The name of the user who created the deployments
+	UserName string
+	// Deployments created at or after From and before To
+	From time.Time
+	To   time.Time
+	// Oldest deployments first instead of newest first
+	Ascending bool
+	// No limit if it's 0 or negative
+	Limit int
+}
+
+// parseDeploymentFilter reads the filter from the query parameters target,
+// state, branch, user, from, to and sort. from and to are dates like
+// 2006-01-02 in loc, which include the whole day, or times in RFC 3339.
+func parseDeploymentFilter(a *models.Application, q url.Values, loc *time.Location) (*deploymentFilter, error) {
+	f := &deploymentFilter{
+		TargetName: q.Get("target"),
+		State:      models.DeploymentState(q.Get("state")),
+		Branch:     q.Get("branch"),
+		UserName:   q.Get("user"),
+	}
+
+	if f.TargetName != "" {
+		if _, err := findTarget(a, f.TargetName); err != nil {
+			return nil, err
+		}
+	}
+
+	if f.State != "" && !isDeploymentState(f.State) {
+		return nil, fmt.Errorf("unknown state %q", f.State)
+	}
+
+	var err error
+	f.From, err = parseDeploymentFilterTime("from", q.Get("from"), false, loc)
+	if err != nil {
+		return nil, err
+	}
+	f.To, err = parseDeploymentFilterTime("to", q.Get("to"), true, loc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch q.Get("sort") {
+	case "", "desc":
+	case "asc":
+		f.Ascending = true
+	default:
+		return nil, errors.New("sort must be asc or desc")
+	}
+
+	return f, nil
+}
+
+// parseDeploymentFilterTime parses a date or time. For the end of a range a
+// date means the start of the next day.
+func parseDeploymentFilterTime(name, value string, end bool, loc *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(deploymentFilterDateLayout, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be a date like 2006-01-02 or a time in RFC 3339", name)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+func isDeploymentState(state models.DeploymentState) bool {
+	for _, s := range models.DeploymentStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestParseDeploymentFilter(t *testing.T) {
+	a := &models.Application{Name: "web", Targets: []*models.Target{{Name: "production"}}}
+
+	tests := []struct {
+		query       string
+		expectedErr string
+		check       func(f *deploymentFilter) bool
+	}{
+		{"", "", func(f *deploymentFilter) bool { return *f == deploymentFilter{} }},
+		{"target=production&state=failed&branch=master&user=mrnugget&sort=asc", "", func(f *deploymentFilter) bool {
+			return f.TargetName == "production" && f.State == models.DEPLOYMENT_FAILED &&
+				f.Branch == "master" && f.UserName == "mrnugget" && f.Ascending
+		}},
+		{"from=2015-01-26&to=2015-01-27", "", func(f *deploymentFilter) bool {
+			from := time.Date(2015, 1, 26, 0, 0, 0, 0, time.Local)
+			to := time.Date(2015, 1, 28, 0, 0, 0, 0, time.Local)
+			return f.From.Equal(from) && f.To.Equal(to)
+		}},
+		{"to=2015-01-27T10:00:00Z", "", func(f *deploymentFilter) bool {
+			return f.To.Equal(time.Date(2015, 1, 27, 10, 0, 0, 0, time.UTC))
+		}},
+		{"target=staging", "target not found", nil},
+		{"state=exploded", `unknown state "exploded"`, nil},
+		{"from=yesterday", "from must be a date like 2006-01-02 or a time in RFC 3339", nil},
+		{"sort=random", "sort must be asc or desc", nil},
+	}
+
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		checkErr(t, err)
+
+		f, err := parseDeploymentFilter(a, q, time.Local)
+		if tt.expectedErr != "" {
+			if err == nil || err.Error() != tt.expectedErr {
+				t.Errorf("wrong error for %s. want=%s, got=%v", tt.query, tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", tt.query, err)
+			continue
+		}
+		if !tt.check(f) {
+			t.Errorf("wrong filter for %s. got=%+v", tt.query, f)
+		}
+	}
+}
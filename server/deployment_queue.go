@@ -0,0 +1,91 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+type queuedDeployment struct {
+	application *models.Application
+	target      *models.Target
+	deployment  *models.Deployment
+	stages      []models.DeploymentStage
+}
+
+// DeploymentQueue holds the deployments waiting for the running deployment
+// to their target to finish, in the order they have been created.
+type DeploymentQueue struct {
+	sync.Mutex
+	queues map[string][]*queuedDeployment
+}
+
+func NewDeploymentQueue() *DeploymentQueue {
+	return &DeploymentQueue{
+		queues: make(map[string][]*queuedDeployment),
+	}
+}
+
+func queueKey(applicationName, targetName string) string {
+	return applicationName + "/" + targetName
+}
+
+func (q *DeploymentQueue) Push(qd *queuedDeployment) {
+	key := queueKey(qd.application.Name, qd.target.Name)
+
+	q.Lock()
+	q.queues[key] = append(q.queues[key], qd)
+	q.Unlock()
+}
+
+// Pop removes the next deployment to the target from the queue. Returns nil
+// if no deployment is queued.
+func (q *DeploymentQueue) Pop(applicationName, targetName string) *queuedDeployment {
+	key := queueKey(applicationName, targetName)
+
+	q.Lock()
+	defer q.Unlock()
+
+	queue := q.queues[key]
+	if len(queue) == 0 {
+		return nil
+	}
+
+	q.queues[key] = queue[1:]
+	return queue[0]
+}
+
+// Remove removes the deployment from the queue. Returns false if the
+// deployment is not queued.
+func (q *DeploymentQueue) Remove(deploymentId int) bool {
+	q.Lock()
+	defer q.Unlock()
+
+	for key, queue := range q.queues {
+		for i, qd := range queue {
+			if qd.deployment.Id == deploymentId {
+				q.queues[key] = append(queue[:i:i], queue[i+1:]...)
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Position returns the 1-based position of the deployment in the queue of its
+// target or 0 if it's not queued.
+func (q *DeploymentQueue) Position(deploymentId int) int {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, queue := range q.queues {
+		for i, qd := range queue {
+			if qd.deployment.Id == deploymentId {
+				return i + 1
+			}
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestDeploymentQueue(t *testing.T) {
+	app := &models.Application{Name: "flincOnRails"}
+	production := &models.Target{Name: "production"}
+	staging := &models.Target{Name: "staging"}
+
+	queue := NewDeploymentQueue()
+	for i, target := range []*models.Target{production, staging, production, production} {
+		queue.Push(&queuedDeployment{
+			application: app,
+			target:      target,
+			deployment:  &models.Deployment{Id: i + 1},
+		})
+	}
+
+	positions := map[int]int{1: 1, 2: 1, 3: 2, 4: 3, 5: 0}
+	for id, want := range positions {
+		if got := queue.Position(id); got != want {
+			t.Errorf("wrong position of deployment %d. want=%d, got=%d", id, want, got)
+		}
+	}
+
+	if !queue.Remove(3) {
+		t.Errorf("removing queued deployment failed")
+	}
+	if queue.Remove(3) {
+		t.Errorf("removing deployment twice succeeded")
+	}
+	if got := queue.Position(4); got != 2 {
+		t.Errorf("wrong position after removal. want=%d, got=%d", 2, got)
+	}
+
+	for _, want := range []int{1, 4} {
+		qd := queue.Pop(app.Name, production.Name)
+		if qd == nil || qd.deployment.Id != want {
+			t.Fatalf("wrong deployment popped. want=%d, got=%+v", want, qd)
+		}
+	}
+	if qd := queue.Pop(app.Name, production.Name); qd != nil {
+		t.Errorf("popped deployment from empty queue. got=%+v", qd)
+	}
+
+	qd := queue.Pop(app.Name, staging.Name)
+	if qd == nil || qd.deployment.Id != 2 {
+		t.Errorf("wrong deployment popped. want=%d, got=%+v", 2, qd)
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+var deploymentReportHeader = []string{
+	"id", "target", "user", "commit_sha", "branch", "tag", "pull_request",
+	"comment", "state", "created_at", "finished_at", "duration_seconds",
+	"redeploy_of", "retry_of", "ci_override_reason",
+}
+
+// deploymentReport lists who deployed what, when, with which outcome and how
+// long it took, e.g. for change-management audits. From and To are the range
+// of the filter, nil if it's open.
+type deploymentReport struct {
+	Application string                   `json:"application"`
+	From        *time.Time               `json:"from"`
+	To          *time.Time               `json:"to"`
+	Deployments []*deploymentReportEntry `json:"deployments"`
+}
+
+type deploymentReportEntry struct {
+	Id          int                    `json:"id"`
+	Target      string                 `json:"target"`
+	User        string                 `json:"user"`
+	CommitSha   string                 `json:"commit_sha"`
+	Branch      string                 `json:"branch"`
+	Tag         string                 `json:"tag"`
+	PullRequest int                    `json:"pull_request"`
+	Comment     string                 `json:"comment"`
+	State       models.DeploymentState `json:"state"`
+	CreatedAt   time.Time              `json:"created_at"`
+	// FinishedAt and Duration are nil while the deployment is running and
+	// for deployments that finished before updated_at was added
+	FinishedAt       *time.Time `json:"finished_at"`
+	Duration         *float64   `json:"duration_seconds"`
+	RedeployOf       int        `json:"redeploy_of"`
+	RetryOf          int        `json:"retry_of"`
+	CIOverrideReason string     `json:"ci_override_reason"`
+}
+
+func newDeploymentReportEntry(d *models.Deployment) *deploymentReportEntry {
+	e := &deploymentReportEntry{
+		Id:               d.Id,
+		Target:           d.TargetName,
+		User:             fmt.Sprintf("user #%d", d.UserId),
+		CommitSha:        d.CommitSha,
+		Branch:           d.Branch,
+		Tag:              d.Tag,
+		PullRequest:      d.PullRequest,
+		Comment:          d.Comment,
+		State:            d.State,
+		CreatedAt:        d.CreatedAt.UTC(),
+		RedeployOf:       d.RedeployOf,
+		RetryOf:          d.RetryOf,
+		CIOverrideReason: d.CIOverrideReason,
+	}
+	if d.User != nil {
+		e.User = d.User.Name
+	}
+
+	finished := d.State == models.DEPLOYMENT_SUCCESSFUL || d.State == models.DEPLOYMENT_FAILED
+	if finished && d.UpdatedAt.After(d.CreatedAt) {
+		finishedAt := d.UpdatedAt.UTC()
+		duration := d.UpdatedAt.Sub(d.CreatedAt).Seconds()
+		e.FinishedAt, e.Duration = &finishedAt, &duration
+	}
+	return e
+}
+
+// loadDeploymentReport loads the report of the deployments matching the
+// filter of parseDeploymentFilter, without a limit.
+func loadDeploymentReport(r *http.Request, a *models.Application, q url.Values, loc *time.Location) (*deploymentReport, *requestError) {
+	filter, err := parseDeploymentFilter(a, q, loc)
+	if err != nil {
+		return nil, &requestError{422, err.Error()}
+	}
+
+	deployments, err := getFilteredApplicationDeployments(db, a, filter)
+	if err == nil {
+		err = loadDeploymentsUsers(db, deployments)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading deployments", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, "could not load deployments"}
+	}
+
+	report := &deploymentReport{Application: a.Name, Deployments: []*deploymentReportEntry{}}
+	if !filter.From.IsZero() {
+		report.From = &filter.From
+	}
+	if !filter.To.IsZero() {
+		report.To = &filter.To
+	}
+	for _, d := range deployments {
+		report.Deployments = append(report.Deployments, newDeploymentReportEntry(d))
+	}
+	return report, nil
+}
+
+// writeDeploymentReportCSV writes the report with a header row, the times
+// in RFC 3339 and UTC.
+func writeDeploymentReportCSV(w io.Writer, report *deploymentReport) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(deploymentReportHeader); err != nil {
+		return err
+	}
+
+	for _, e := range report.Deployments {
+		var finishedAt, duration string
+		if e.FinishedAt != nil {
+			finishedAt = e.FinishedAt.Format(time.RFC3339)
+			duration = strconv.FormatFloat(*e.Duration, 'f', 0, 64)
+		}
+
+		err := cw.Write([]string{
+			strconv.Itoa(e.Id), e.Target, e.User, e.CommitSha, e.Branch, e.Tag,
+			strconv.Itoa(e.PullRequest), e.Comment, string(e.State),
+			e.CreatedAt.Format(time.RFC3339), finishedAt, duration,
+			strconv.Itoa(e.RedeployOf), strconv.Itoa(e.RetryOf), e.CIOverrideReason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeDeploymentReport sends the report as a CSV or JSON file download.
+func writeDeploymentReport(w http.ResponseWriter, r *http.Request, report *deploymentReport, format string) {
+	filename := fmt.Sprintf("%s-deployments.%s", report.Application, format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var err error
+	switch format {
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		err = writeDeploymentReportCSV(w, report)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(report)
+	}
+	if err != nil {
+		requestLogger(r).Error("error writing the deployment report", "err", err)
+	}
+}
+
+// deploymentReportHandler exports the deployments of the application as
+// report.csv or report.json, filtered like the deployments page.
+func deploymentReportHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	report, reqErr := loadDeploymentReport(r, application, r.URL.Query(), currentUser.Preferences.Location())
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	writeDeploymentReport(w, r, report, mux.Vars(r)["format"])
+}
+
+func apiDeploymentReportHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	report, reqErr := loadDeploymentReport(r, application, r.URL.Query(), time.Local)
+	if reqErr != nil {
+		renderApiError(w, reqErr.Status, reqErr.Message)
+		return
+	}
+
+	writeDeploymentReport(w, r, report, mux.Vars(r)["format"])
+}
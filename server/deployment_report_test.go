@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestWriteDeploymentReportCSV(t *testing.T) {
+	createdAt := time.Date(2015, 1, 26, 10, 0, 0, 0, time.UTC)
+
+	successful := &models.Deployment{
+		Id:          1,
+		UserId:      1,
+		User:        &models.User{Id: 1, Name: "mrnugget"},
+		TargetName:  "production",
+		CommitSha:   "d1a2b3c4d5e6f7a8b9c0d1a2b3c4d5e6f7a8b9c0",
+		Branch:      "master",
+		PullRequest: 42,
+		Comment:     "Fix signup, \"finally\"",
+		State:       models.DEPLOYMENT_SUCCESSFUL,
+		CreatedAt:   createdAt,
+		UpdatedAt:   createdAt.Add(90 * time.Second),
+	}
+	// Running deployments and deleted users have no finish time and no name
+	active := &models.Deployment{
+		Id:         2,
+		UserId:     7,
+		TargetName: "staging",
+		Tag:        "v1.2.0",
+		State:      models.DEPLOYMENT_ACTIVE,
+		CreatedAt:  createdAt.Add(time.Hour),
+		UpdatedAt:  createdAt.Add(time.Hour + time.Minute),
+		RetryOf:    1,
+	}
+
+	report := &deploymentReport{
+		Application: "flincOnRails",
+		Deployments: []*deploymentReportEntry{
+			newDeploymentReportEntry(successful),
+			newDeploymentReportEntry(active),
+		},
+	}
+
+	var buf bytes.Buffer
+	checkErr(t, writeDeploymentReportCSV(&buf, report))
+
+	expected := "id,target,user,commit_sha,branch,tag,pull_request,comment,state,created_at,finished_at,duration_seconds,redeploy_of,retry_of,ci_override_reason\n" +
+		"1,production,mrnugget,d1a2b3c4d5e6f7a8b9c0d1a2b3c4d5e6f7a8b9c0,master,,42,\"Fix signup, \"\"finally\"\"\",successful,2015-01-26T10:00:00Z,2015-01-26T10:01:30Z,90,0,0,\n" +
+		"2,staging,user #7,,,v1.2.0,0,,active,2015-01-26T11:00:00Z,,,0,1,\n"
+	if buf.String() != expected {
+		t.Errorf("wrong report.\nwant=%q\ngot= %q", expected, buf.String())
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// Held while saving a deployment that might get queued and while taking the
+// next deployment from the queue, so no queued deployment gets lost in between.
+var deploymentQueueMutex sync.Mutex
+
+// startDeployment saves the deployment and runs it in the background. If it
+// fails, it's retried according to the auto_retry_attempts of the target. On
+// targets with queue_deployments it's queued if another deployment is running.
+// While the server is shutting down it returns errShuttingDown.
+func startDeployment(application *models.Application, target *models.Target, deployment *models.Deployment, stages []models.DeploymentStage) error {
+	if deploymentDrain.Draining() {
+		return errShuttingDown
+	}
+
+	deployment.Stages = stages
+
+	if target.IsBlueGreen() {
+		liveGroup, err := getLiveHostGroup(db, application.Name, target.Name)
+		if err != nil {
+			return err
+		}
+		deployment.HostGroup = models.InactiveGroup(liveGroup)
+	}
+
+	var err error
+	if target.QueueDeployments {
+		deploymentQueueMutex.Lock()
+		err = createOrQueueDeployment(db, deployment)
+		if err == nil && deployment.State == models.DEPLOYMENT_QUEUED {
+			deploymentQueue.Push(&queuedDeployment{application, target, deployment, stages})
+		}
+		deploymentQueueMutex.Unlock()
+	} else {
+		err = createDeployment(db, deployment)
+	}
+	if err != nil {
+		return err
+	}
+
+	eventBus.PublishDeploymentState(deployment.State, deployment)
+
+	if deployment.State == models.DEPLOYMENT_QUEUED {
+		return nil
+	}
+
+	return runDeployment(application, target, deployment, stages)
+}
+
+// runDeployment builds the Manager of a saved deployment and runs it in the
+// background.
+func runDeployment(application *models.Application, target *models.Target, deployment *models.Deployment, stages []models.DeploymentStage) error {
+	config := getConfig()
+	if err := deploymentDrain.Start(); err != nil {
+		abortDeployment(deployment)
+		return err
+	}
+
+	killChan := killRegistry.Add(deployment.Id)
+	approvalChan := approvalRegistry.Add(deployment.Id)
+
+	deploymentConfig := models.NewDeploymentConfig(deployment, target, stages)
+	// The secrets looked up by the script templates are masked too
+	redactor := newDeploymentRedactor(config, target)
+	lookupSecret := secretLookup(config)
+	deploymentConfig.LookupSecret = func(ref string) (string, error) {
+		secret, err := lookupSecret(ref)
+		if err == nil {
+			redactor.AddSecrets(secret)
+		}
+		return secret, err
+	}
+	deploymentConfig.Redact = redactor.Redact
+	deploymentConfig.RedactOverlap = redactor.Overlap
+	manager, err := deploy.NewManager(deploymentConfig, logRouter, killChan, approvalChan)
+	if err != nil {
+		abortDeployment(deployment)
+		deploymentDrain.Done()
+		return err
+	}
+	if deploymentConfig.RequireSecondApproval {
+		approvalRegistry.RequireSecondApproval(deployment.Id, deployment.UserId)
+	}
+
+	manager.AnnounceStart()
+
+	err = updateDeploymentState(db, deployment, models.DEPLOYMENT_ACTIVE)
+	if err != nil {
+		abortDeployment(deployment)
+		deploymentDrain.Done()
+		return err
+	}
+	eventBus.PublishDeploymentState(models.DEPLOYMENT_ACTIVE, deployment)
+
+	go func() {
+		defer deploymentDrain.Done()
+
+		newState := models.DEPLOYMENT_SUCCESSFUL
+		if err := manager.Start(); err != nil {
+			newState = models.DEPLOYMENT_FAILED
+		}
+		deployment.ApprovedBy = manager.ApprovedBy()
+
+		// Traffic has been switched even if a later stage failed
+		if target.IsBlueGreen() && manager.StageCompleted(target.BlueGreen.SwitchStage) {
+			if err := setLiveHostGroup(db, deployment); err != nil {
+				deploymentLogger(deployment).Error("could not save live host group", "err", err)
+			}
+		}
+
+		err := updateDeploymentState(db, deployment, newState)
+		if err != nil {
+			deploymentLogger(deployment).Error("could not update deployment state", "err", err)
+		} else {
+			eventBus.PublishDeploymentState(newState, deployment)
+		}
+
+		killRegistry.Remove(deployment.Id)
+		approvalRegistry.Remove(deployment.Id)
+
+		// Killed deployments have been stopped on purpose
+		if newState == models.DEPLOYMENT_FAILED && !manager.Killed() {
+			scheduleRetry(application, target, deployment, stages)
+		}
+
+		runNextQueuedDeployment(application, target)
+	}()
+
+	return nil
+}
+
+// abortDeployment marks a deployment that could not be started as failed, so
+// it doesn't block other deployments to the target.
+func abortDeployment(deployment *models.Deployment) {
+	killRegistry.Remove(deployment.Id)
+	approvalRegistry.Remove(deployment.Id)
+
+	err := updateDeploymentState(db, deployment, models.DEPLOYMENT_FAILED)
+	if err != nil {
+		deploymentLogger(deployment).Error("could not update deployment state", "err", err)
+		return
+	}
+	eventBus.PublishDeploymentState(models.DEPLOYMENT_FAILED, deployment)
+}
+
+var errNotCancelable = errors.New("deployment is neither queued nor running")
+
+// cancelDeployment removes a queued deployment from the queue and marks it as
+// failed, or kills a running deployment. Killed deployments fail once their
+// manager has stopped.
+func cancelDeployment(deployment *models.Deployment) error {
+	if deploymentQueue.Remove(deployment.Id) {
+		err := updateDeploymentState(db, deployment, models.DEPLOYMENT_FAILED)
+		if err != nil {
+			return err
+		}
+		eventBus.PublishDeploymentState(models.DEPLOYMENT_FAILED, deployment)
+		return nil
+	}
+
+	killChan, err := killRegistry.Get(deployment.Id)
+	if err != nil {
+		return errNotCancelable
+	}
+
+	killChan <- struct{}{}
+	return nil
+}
+
+// runNextQueuedDeployment starts the next queued deployment to the target.
+// Queued deployments that fail to start are skipped. While the server is
+// shutting down they stay queued and are marked as failed on the next start.
+func runNextQueuedDeployment(application *models.Application, target *models.Target) {
+	deploymentQueueMutex.Lock()
+	defer deploymentQueueMutex.Unlock()
+
+	if deploymentDrain.Draining() {
+		return
+	}
+
+	for {
+		next := deploymentQueue.Pop(application.Name, target.Name)
+		if next == nil {
+			return
+		}
+
+		err := runQueuedDeployment(next)
+		if err == nil {
+			return
+		}
+		deploymentLogger(next.deployment).Error("queued deployment failed to start", "err", err)
+	}
+}
+
+func runQueuedDeployment(qd *queuedDeployment) error {
+	// The live group might have changed while the deployment was queued
+	if qd.target.IsBlueGreen() {
+		liveGroup, err := getLiveHostGroup(db, qd.application.Name, qd.target.Name)
+		if err != nil {
+			abortDeployment(qd.deployment)
+			return err
+		}
+
+		err = updateDeploymentHostGroup(db, qd.deployment, models.InactiveGroup(liveGroup))
+		if err != nil {
+			abortDeployment(qd.deployment)
+			return err
+		}
+	}
+
+	return runDeployment(qd.application, qd.target, qd.deployment, qd.stages)
+}
+
+func scheduleRetry(application *models.Application, target *models.Target, failed *models.Deployment, stages []models.DeploymentStage) {
+	if target.AutoRetryAttempts <= 0 {
+		return
+	}
+
+	retries, err := countDeploymentRetries(db, failed)
+	if err != nil {
+		deploymentLogger(failed).Error("could not count retries", "err", err)
+		return
+	}
+	if retries >= target.AutoRetryAttempts {
+		deploymentLogger(failed).Warn("deployment failed, giving up", "retries", retries)
+		return
+	}
+
+	// The delay has been validated when reading the configuration
+	delay, _ := target.RetryDelay()
+	time.AfterFunc(delay, func() {
+		retryDeployment(application, target, failed, stages)
+	})
+}
+
+func retryDeployment(application *models.Application, target *models.Target, failed *models.Deployment, stages []models.DeploymentStage) {
+	// Don't retry if somebody deployed to the target in the meantime
+	latest, err := getLatestTargetDeployment(db, application, target.Name)
+	if err != nil {
+		deploymentLogger(failed).Error("could not load latest deployment to the target", "err", err)
+		return
+	}
+	if latest == nil || latest.Id != failed.Id {
+		deploymentLogger(failed).Info("not retrying, there is a newer deployment to the target")
+		return
+	}
+
+	err = loadDeploymentChangelog(db, failed)
+	if err != nil {
+		deploymentLogger(failed).Warn("could not load changelog", "err", err)
+	}
+
+	retry := &models.Deployment{
+		UserId:           failed.UserId,
+		CommitSha:        failed.CommitSha,
+		Branch:           failed.Branch,
+		Comment:          failed.Comment,
+		ApplicationName:  failed.ApplicationName,
+		TargetName:       failed.TargetName,
+		RetryOf:          failed.Id,
+		CIOverrideReason: failed.CIOverrideReason,
+		PullRequest:      failed.PullRequest,
+		Tag:              failed.Tag,
+		RedeployOf:       failed.RedeployOf,
+		Changelog:        failed.Changelog,
+		Overrides:        failed.Overrides,
+	}
+
+	err = startDeployment(application, target, retry, stages)
+	if err != nil {
+		deploymentLogger(failed).Error("automatic retry failed to start", "err", err)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/mail"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// getDigestSubscribers returns the subscriptions to the digests of the
+// application of the users that may still read it.
+func getDigestSubscribers(db *sql.DB, a *models.Application) ([]*models.DigestSubscription, error) {
+	subscriptions, err := getApplicationDigestSubscriptions(db, a)
+	if err != nil {
+		return nil, err
+	}
+
+	subscribers := []*models.DigestSubscription{}
+	for _, s := range subscriptions {
+		u, err := getUser(db, s.UserId)
+		if err != nil {
+			return nil, err
+		}
+		// Access to the application can have been revoked since
+		if !a.CanRead(u) {
+			continue
+		}
+		s.User = u
+		subscribers = append(subscribers, s)
+	}
+	return subscribers, nil
+}
+
+func digestUnsubscribePath(s *models.DigestSubscription) string {
+	return "/digests/unsubscribe/" + s.Token
+}
+
+// digestApplications returns the applications the user can subscribe to the
+// digests of.
+func digestApplications(u *models.User) []*models.Application {
+	applications := []*models.Application{}
+	for _, a := range getConfig().Applications {
+		if a.CanRead(u) && a.HasDigest() {
+			applications = append(applications, a)
+		}
+	}
+	return applications
+}
+
+// subscribeDigestHandler subscribes the user to the digests of the
+// application, sent to the given email address.
+func subscribeDigestHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	a, err := findApplication(mux.Vars(r)["application"])
+	if err != nil || !a.CanRead(currentUser) || !a.HasDigest() {
+		http.NotFound(w, r)
+		return
+	}
+
+	address, err := mail.ParseAddress(r.FormValue("email"))
+	if err != nil {
+		http.Error(w, "invalid email address", 422)
+		return
+	}
+
+	s := &models.DigestSubscription{UserId: currentUser.Id, ApplicationName: a.Name, Email: address.Address}
+	err = saveDigestSubscription(db, s)
+	if err != nil {
+		requestLogger(r).Error("error saving the digest subscription", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addFlash(w, r, "The digests of %s are sent to %s.", a.Name, s.Email)
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// unsubscribeDigestHandler unsubscribes the user from the digests of the
+// application on the profile page.
+func unsubscribeDigestHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	applicationName := mux.Vars(r)["application"]
+
+	subscriptions, err := getUserDigestSubscriptions(db, currentUser.Id)
+	if err != nil {
+		requestLogger(r).Error("error loading the digest subscriptions", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s, ok := subscriptions[applicationName]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	err = deleteDigestSubscription(db, s)
+	if err != nil {
+		requestLogger(r).Error("error deleting the digest subscription", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addFlash(w, r, "You have been unsubscribed from the digests of %s.", applicationName)
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// digestUnsubscribeLinkHandler shows the page of the unsubscribe link in the
+// digest emails, which works without logging in. Unsubscribing needs a click
+// on the page, so mail scanners following the link don't unsubscribe.
+func digestUnsubscribeLinkHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := getDigestSubscriptionByToken(db, mux.Vars(r)["token"])
+	if err != nil {
+		requestLogger(r).Error("error loading the digest subscription", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	unsubscribed := false
+	if s != nil && r.Method == "POST" {
+		err = deleteDigestSubscription(db, s)
+		if err != nil {
+			requestLogger(r).Error("error deleting the digest subscription", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		unsubscribed = true
+	}
+
+	renderTemplate(w, r, "digest_unsubscribe.tmpl", map[string]interface{}{
+		"Subscription": s,
+		"Unsubscribed": unsubscribed,
+		"currentUser":  getCurrentUser(r),
+	})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestDigestSubscriptions(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	alice := buildUser(1, "alice")
+	bob := buildUser(2, "bob")
+	carol := buildUser(3, "carol")
+	for _, u := range []*models.User{alice, bob, carol} {
+		checkErr(t, createUser(db, u))
+	}
+
+	// bob can't read web anymore and carol has been deactivated
+	web := &models.Application{Name: "web", ReadUsernames: []string{"alice", "carol"}}
+
+	subscriptions := []*models.DigestSubscription{
+		{UserId: alice.Id, ApplicationName: "web", Email: "alice@shipping-company.com"},
+		{UserId: bob.Id, ApplicationName: "web", Email: "bob@shipping-company.com"},
+		{UserId: carol.Id, ApplicationName: "web", Email: "carol@shipping-company.com"},
+		{UserId: alice.Id, ApplicationName: "api", Email: "alice@shipping-company.com"},
+	}
+	for _, s := range subscriptions {
+		checkErr(t, saveDigestSubscription(db, s))
+	}
+	checkErr(t, deactivateUser(db, carol))
+
+	// Changing the email address replaces the subscription and its token
+	changed := &models.DigestSubscription{UserId: alice.Id, ApplicationName: "web", Email: "a@shipping-company.com"}
+	checkErr(t, saveDigestSubscription(db, changed))
+
+	subscribers, err := getDigestSubscribers(db, web)
+	checkErr(t, err)
+	if len(subscribers) != 1 || subscribers[0].Email != "a@shipping-company.com" || subscribers[0].User.Name != "alice" {
+		t.Fatalf("wrong subscribers. got=%+v", subscribers)
+	}
+
+	byApplication, err := getUserDigestSubscriptions(db, alice.Id)
+	checkErr(t, err)
+	if len(byApplication) != 2 || byApplication["api"] == nil || byApplication["web"].Token != changed.Token {
+		t.Errorf("wrong subscriptions of user. got=%+v", byApplication)
+	}
+
+	old, err := getDigestSubscriptionByToken(db, subscriptions[0].Token)
+	checkErr(t, err)
+	if old != nil {
+		t.Errorf("token of replaced subscription still valid")
+	}
+
+	s, err := getDigestSubscriptionByToken(db, changed.Token)
+	checkErr(t, err)
+	if s == nil || s.ApplicationName != "web" || s.UserId != alice.Id {
+		t.Fatalf("wrong subscription by token. got=%+v", s)
+	}
+
+	checkErr(t, deleteDigestSubscription(db, s))
+	subscribers, err = getDigestSubscribers(db, web)
+	checkErr(t, err)
+	if len(subscribers) != 0 {
+		t.Errorf("subscription not deleted. got=%+v", subscribers)
+	}
+}
+
+func TestDigestApplications(t *testing.T) {
+	setConfig(&Configuration{Applications: []*models.Application{
+		{Name: "web", ReadUsernames: []string{"mrnugget"}, DailyDigestTarget: "production", Targets: []*models.Target{{Name: "production"}}},
+		{Name: "api", ReadUsernames: []string{"mrnugget"}, Targets: []*models.Target{{Name: "production"}}},
+		{Name: "secret", DailyDigestTarget: "production", Targets: []*models.Target{{Name: "production"}}},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	applications := digestApplications(buildUser(1, "mrnugget"))
+	if len(applications) != 1 || applications[0].Name != "web" {
+		t.Errorf("wrong digest applications. got=%v", applications)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// digestTemplates render the digest emails of an application, with the
+// built-in templates or the digest_html_template and digest_text_template
+// files of the application.
+type digestTemplates struct {
+	// nil if the built-in daily_digest.tmpl is used
+	html *htmltemplate.Template
+	text *template.Template
+}
+
+var digestHtmlFuncs = htmltemplate.FuncMap{
+	"newlineToBreak": newlineToBreak,
+	"fmtDuration":    fmtDuration,
+}
+
+// loadDigestTemplates reads and parses the digest templates of the
+// application. Relative paths are relative to dir, the directory of the
+// configuration file.
+func loadDigestTemplates(dir string, a *models.Application) (*digestTemplates, error) {
+	tmpls := &digestTemplates{}
+
+	textTemplate := digestTextTemplate
+	if a.DigestTextTemplate != "" {
+		content, err := os.ReadFile(digestTemplatePath(dir, a.DigestTextTemplate))
+		if err != nil {
+			return nil, err
+		}
+		textTemplate = string(content)
+	}
+	text, err := template.New("digestTextBody").Funcs(template.FuncMap{"fmtDuration": fmtDuration}).Parse(textTemplate)
+	if err != nil {
+		return nil, err
+	}
+	tmpls.text = text
+
+	if a.DigestHtmlTemplate != "" {
+		content, err := os.ReadFile(digestTemplatePath(dir, a.DigestHtmlTemplate))
+		if err != nil {
+			return nil, err
+		}
+		html := htmltemplate.New("digestHtmlBody").Funcs(digestHtmlFuncs)
+		tmpls.html, err = html.Parse(string(content))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tmpls, nil
+}
+
+func digestTemplatePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+func (t *digestTemplates) executeText(w io.Writer, vars map[string]interface{}) error {
+	return t.text.Execute(w, vars)
+}
+
+func (t *digestTemplates) executeHtml(w io.Writer, vars map[string]interface{}) error {
+	if t.html != nil {
+		return t.html.Execute(w, vars)
+	}
+
+	tmpl := htmltemplate.New("")
+	tmpl.Funcs(digestHtmlFuncs)
+
+	tmpl, err := tmpl.ParseFS(templatesFS, "email_head.tmpl", digestHtmlTemplateFilename)
+	if err != nil {
+		return err
+	}
+	return tmpl.ExecuteTemplate(w, digestHtmlTemplateFilename, vars)
+}
+
+// validateDigestTemplates renders the digest templates of the application
+// with an example deployment, so mistakes like misspelled fields are found
+// when the configuration is loaded instead of when the digest is sent.
+func validateDigestTemplates(dir string, a *models.Application) error {
+	if a.DigestHtmlTemplate == "" && a.DigestTextTemplate == "" {
+		return nil
+	}
+
+	tmpls, err := loadDigestTemplates(dir, a)
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{Name: "mrnugget", AvatarUrl: "https://example.com/avatar.png"}
+	deployment := &models.Deployment{
+		Id:              1,
+		UserId:          1,
+		User:            user,
+		ApplicationName: a.Name,
+		TargetName:      "production",
+		CommitSha:       "f00b4r",
+		Branch:          "master",
+		Comment:         "Example deployment",
+		State:           models.DEPLOYMENT_SUCCESSFUL,
+		CreatedAt:       time.Now(),
+	}
+	deployments := []*models.Deployment{deployment}
+	target := &models.Target{Name: deployment.TargetName}
+	vars := digestVars(&digestContent{
+		Application: a,
+		Target:      target,
+		Schedule:    models.DigestSchedule{Frequency: models.DIGEST_DAILY},
+		Deployments: deployments,
+		Stats:       newDigestStats(target, deployments, nil),
+	})
+	vars["UnsubscribeURL"] = "https://applikatoni.example.com/digests/unsubscribe/token"
+
+	var out bytes.Buffer
+	if err := tmpls.executeText(&out, vars); err != nil {
+		return err
+	}
+	if tmpls.html != nil {
+		return tmpls.executeHtml(&out, vars)
+	}
+	return nil
+}
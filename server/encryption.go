@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// Encrypted values in the database start with encryptedValuePrefix, followed
+// by the base64 encoded nonce and ciphertext of AES-256-GCM.
+const encryptedValuePrefix = "encrypted:v1:"
+
+// minEncryptionKeyLength keeps short passphrases from being used as key.
+const minEncryptionKeyLength = 32
+
+var errNoEncryptionKey = errors.New("the database contains encrypted secrets, but no encryption_key is configured")
+
+// fieldCipher encrypts the access tokens, refresh tokens and API tokens of the
+// users before they are saved in the database. It's nil if no encryption_key
+// is configured, then new secrets are saved as they are.
+var fieldCipher cipher.AEAD
+
+// newFieldCipher returns the cipher for the encryption_key. The AES key is
+// the SHA-256 of the encryption_key, so it can be any random string, e.g. the
+// output of `openssl rand -base64 32` or a data key decrypted with KMS.
+func newFieldCipher(key string) (cipher.AEAD, error) {
+	if len(key) < minEncryptionKeyLength {
+		return nil, fmt.Errorf("the encryption_key needs at least %d characters", minEncryptionKeyLength)
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptField encrypts the value with the fieldCipher. Empty values stay
+// empty, so revoked tokens can still be told apart.
+func encryptField(value string) (string, error) {
+	if fieldCipher == nil || value == "" || strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+
+	nonce := make([]byte, fieldCipher.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := fieldCipher.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decryptField decrypts a value encrypted by encryptField. Values saved before
+// the encryption_key was configured are returned as they are.
+func decryptField(value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedValuePrefix) {
+		return value, nil
+	}
+	if fieldCipher == nil {
+		return "", errNoEncryptionKey
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedValuePrefix))
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < fieldCipher.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	nonce, ciphertext := sealed[:fieldCipher.NonceSize()], sealed[fieldCipher.NonceSize():]
+	plaintext, err := fieldCipher.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("decrypting failed, is the encryption_key correct? %s", err)
+	}
+	return string(plaintext), nil
+}
+
+// hashApiToken returns the hash API tokens are looked up by, since their
+// encrypted value differs every time they are saved. Revoked tokens have no
+// hash.
+func hashApiToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// decryptUserTokens decrypts the tokens of a user loaded from the database.
+func decryptUserTokens(u *models.User) error {
+	for _, token := range []*string{&u.AccessToken, &u.RefreshToken, &u.ApiToken} {
+		decrypted, err := decryptField(*token)
+		if err != nil {
+			return fmt.Errorf("reading the tokens of user %d failed: %s", u.Id, err)
+		}
+		*token = decrypted
+	}
+	return nil
+}
+
+// encryptStoredSecrets encrypts the tokens and application definitions saved
+// before the encryption_key was configured and hashes the API tokens saved
+// before they were looked up by their hash. It runs on startup and fails if the database contains
+// encrypted tokens that can't be decrypted.
+func encryptStoredSecrets(db *sql.DB) error {
+	users, err := getAllUsers(db)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		accessToken, err := encryptField(u.AccessToken)
+		if err != nil {
+			return err
+		}
+		refreshToken, err := encryptField(u.RefreshToken)
+		if err != nil {
+			return err
+		}
+		apiToken, err := encryptField(u.ApiToken)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.Exec(userSecretsUpdateStmt, accessToken, refreshToken, apiToken, hashApiToken(u.ApiToken), u.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	applications, err := getManagedApplications(db)
+	if err != nil {
+		return err
+	}
+	for _, a := range applications {
+		definition, err := encryptField(a.Definition)
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(managedApplicationUpdateStmt, definition, a.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const testEncryptionKey = "7f8b3c9e1a2d4f6081b2c3d4e5f60718"
+
+func TestEncryptField(t *testing.T) {
+	plain, err := encryptField("t0k3n")
+	checkErr(t, err)
+	if plain != "t0k3n" {
+		t.Errorf("value encrypted without key. got=%q", plain)
+	}
+
+	fieldCipher, err = newFieldCipher(testEncryptionKey)
+	checkErr(t, err)
+	defer func() { fieldCipher = nil }()
+
+	encrypted, err := encryptField("t0k3n")
+	checkErr(t, err)
+	if !strings.HasPrefix(encrypted, encryptedValuePrefix) || strings.Contains(encrypted, "t0k3n") {
+		t.Errorf("value not encrypted. got=%q", encrypted)
+	}
+
+	again, err := encryptField("t0k3n")
+	checkErr(t, err)
+	if again == encrypted {
+		t.Errorf("same value encrypted twice with the same nonce")
+	}
+
+	decrypted, err := decryptField(encrypted)
+	checkErr(t, err)
+	if decrypted != "t0k3n" {
+		t.Errorf("wrong decrypted value. want=%q, got=%q", "t0k3n", decrypted)
+	}
+
+	empty, err := encryptField("")
+	checkErr(t, err)
+	if empty != "" {
+		t.Errorf("empty value encrypted. got=%q", empty)
+	}
+
+	plain, err = decryptField("pl41n")
+	checkErr(t, err)
+	if plain != "pl41n" {
+		t.Errorf("wrong value of unencrypted field. want=%q, got=%q", "pl41n", plain)
+	}
+
+	fieldCipher, err = newFieldCipher(strings.Repeat("x", minEncryptionKeyLength))
+	checkErr(t, err)
+	if _, err := decryptField(encrypted); err == nil {
+		t.Errorf("value decrypted with the wrong key")
+	}
+
+	fieldCipher = nil
+	if _, err := decryptField(encrypted); err != errNoEncryptionKey {
+		t.Errorf("wrong error without key. want=%v, got=%v", errNoEncryptionKey, err)
+	}
+
+	if _, err := newFieldCipher("s3cr3t"); err == nil {
+		t.Errorf("short encryption key accepted")
+	}
+}
+
+func TestEncryptStoredSecrets(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	// Saved before the encryption_key was configured
+	plain := buildUser(12345, "mrnugget")
+	plain.RefreshToken = "r3fr3sh"
+	checkErr(t, createUser(db, plain))
+
+	var err error
+	fieldCipher, err = newFieldCipher(testEncryptionKey)
+	checkErr(t, err)
+	defer func() { fieldCipher = nil }()
+
+	encrypted := buildUser(54321, "fabrik42")
+	checkErr(t, createUser(db, encrypted))
+
+	checkErr(t, encryptStoredSecrets(db))
+
+	rows, err := db.Query("SELECT access_token, refresh_token, api_token FROM users")
+	checkErr(t, err)
+	defer rows.Close()
+	for rows.Next() {
+		var accessToken, refreshToken, apiToken string
+		checkErr(t, rows.Scan(&accessToken, &refreshToken, &apiToken))
+		for _, token := range []string{accessToken, apiToken} {
+			if !strings.HasPrefix(token, encryptedValuePrefix) {
+				t.Errorf("token saved unencrypted. got=%q", token)
+			}
+		}
+		if refreshToken != "" && !strings.HasPrefix(refreshToken, encryptedValuePrefix) {
+			t.Errorf("refresh token saved unencrypted. got=%q", refreshToken)
+		}
+	}
+	checkErr(t, rows.Err())
+
+	for _, u := range []*models.User{plain, encrypted} {
+		saved, err := getUserByApiToken(db, u.ApiToken)
+		checkErr(t, err)
+		if saved.Id != u.Id || saved.AccessToken != u.AccessToken || saved.RefreshToken != u.RefreshToken {
+			t.Errorf("wrong user for API token. want=%+v, got=%+v", u, saved)
+		}
+	}
+
+	fieldCipher = nil
+	if _, err := getUser(db, plain.Id); err == nil || !strings.Contains(err.Error(), errNoEncryptionKey.Error()) {
+		t.Errorf("encrypted user loaded without key. err=%v", err)
+	}
+	if err := encryptStoredSecrets(db); err == nil {
+		t.Errorf("encrypted tokens accepted without key")
+	}
+}
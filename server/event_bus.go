@@ -0,0 +1,158 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// DeploymentEvent is published when a deployment changes its state.
+type DeploymentEvent struct {
+	State       models.DeploymentState
+	Deployment  *models.Deployment
+	Application *models.Application
+	Target      *models.Target
+	User        *models.User
+}
+
+func (de *DeploymentEvent) DeploymentURL() string {
+	path := fmt.Sprintf("/%v/deployments/%v", de.Application.GitHubRepo, de.Deployment.Id)
+	return getConfig().URL(path)
+}
+
+// Subscriber is called with the events of a listener. A returned error, e.g.
+// of an API that's down, counts toward the circuit of the listener for the
+// target of the deployment.
+type Subscriber func(*DeploymentEvent) error
+
+type stateListener struct {
+	name   string
+	states []models.DeploymentState
+	fn     Subscriber
+}
+
+// EventBus connects the lifecycle of deployments to the listeners interested
+// in it, e.g. notifiers, metrics and the log entry saver, so they can be
+// added without touching the code running the deployments. There are two
+// kinds of events:
+//
+//   - *DeploymentEvent, published when a deployment changes its state. The
+//     listeners are called by a fixed number of workers, see
+//     StartListenerWorkers, so notifiers of a hanging API can't pile up.
+//   - deploy.LogEntry, the output of deployments routed by the LogRouter.
+//     Every listener reads the entries of all deployments from its channel,
+//     in order.
+type EventBus struct {
+	db     *sql.DB
+	router *deploy.LogRouter
+
+	mu             sync.RWMutex
+	stateListeners []stateListener
+
+	calls    chan listenerCall
+	circuits *listenerCircuits
+}
+
+func NewEventBus(db *sql.DB, router *deploy.LogRouter) *EventBus {
+	return &EventBus{
+		db:       db,
+		router:   router,
+		calls:    make(chan listenerCall, listenerQueueSize),
+		circuits: newListenerCircuits(),
+	}
+}
+
+// OnDeploymentState registers the listener for the changes of deployments to
+// one of the states. The name identifies it in the logs.
+func (b *EventBus) OnDeploymentState(name string, states []models.DeploymentState, fn Subscriber) {
+	b.mu.Lock()
+	b.stateListeners = append(b.stateListeners, stateListener{name, states, fn})
+	b.mu.Unlock()
+}
+
+// OnLogEntries registers the listener for the log entries of all deployments.
+// The log router has to be started.
+func (b *EventBus) OnLogEntries(l deploy.Listener) {
+	b.router.SubscribeAll(l)
+}
+
+// OnDeploymentLogEntries registers the listener for the log entries of a
+// running deployment, e.g. to stream them to a browser. Returns
+// deploy.ErrNoDeployment if the deployment isn't running.
+func (b *EventBus) OnDeploymentLogEntries(deploymentId int, l deploy.Listener) error {
+	return b.router.Subscribe(deploymentId, l)
+}
+
+// IsDeploymentRunning returns whether the log entries of the deployment are
+// still being routed. A listener of a running deployment whose channel was
+// closed fell behind and was dropped.
+func (b *EventBus) IsDeploymentRunning(deploymentId int) bool {
+	return b.router.IsRunning(deploymentId)
+}
+
+// listenersFor returns the listeners registered for the state.
+func (b *EventBus) listenersFor(state models.DeploymentState) []stateListener {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	var listeners []stateListener
+	for _, l := range b.stateListeners {
+		for _, s := range l.states {
+			if s == state {
+				listeners = append(listeners, l)
+				break
+			}
+		}
+	}
+	return listeners
+}
+
+// PublishDeploymentState queues the calls of the listeners registered for the
+// state with the deployment, its application, target and user.
+func (b *EventBus) PublishDeploymentState(state models.DeploymentState, d *models.Deployment) {
+	listeners := b.listenersFor(state)
+	if len(listeners) == 0 {
+		return
+	}
+
+	event, err := b.buildDeploymentEvent(state, d)
+	if err != nil {
+		deploymentLogger(d).Error("building deployment event failed", "err", err)
+		return
+	}
+
+	for _, l := range listeners {
+		b.queueListenerCall(l, event)
+	}
+}
+
+func (b *EventBus) buildDeploymentEvent(s models.DeploymentState, d *models.Deployment) (*DeploymentEvent, error) {
+	user, err := getUser(b.db, d.UserId)
+	if err != nil {
+		return nil, err
+	}
+	d.User = user
+
+	application, err := findApplication(d.ApplicationName)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := findTarget(application, d.TargetName)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &DeploymentEvent{
+		State:       s,
+		Deployment:  d,
+		Application: application,
+		Target:      target,
+		User:        user,
+	}
+
+	return event, nil
+}
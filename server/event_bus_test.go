@@ -0,0 +1,286 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestOnDeploymentState(t *testing.T) {
+	testSubscriber := func(ev *DeploymentEvent) error { return nil }
+
+	bus := NewEventBus(&sql.DB{}, nil)
+	bus.OnDeploymentState("test", []models.DeploymentState{models.DEPLOYMENT_NEW, models.DEPLOYMENT_FAILED}, testSubscriber)
+
+	tests := []struct {
+		state    models.DeploymentState
+		expected int
+	}{
+		{models.DEPLOYMENT_NEW, 1},
+		{models.DEPLOYMENT_ACTIVE, 0},
+		{models.DEPLOYMENT_FAILED, 1},
+	}
+
+	for _, tt := range tests {
+		if got := len(bus.listenersFor(tt.state)); got != tt.expected {
+			t.Errorf("wrong number of listeners for %s. want=%d, got=%d", tt.state, tt.expected, got)
+		}
+	}
+}
+
+func TestPublishDeploymentState(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(12345, "mrnugget")
+	err := createUser(db, user)
+	checkErr(t, err)
+
+	deployment := buildDeployment(user.Id)
+	err = createDeployment(db, deployment)
+	checkErr(t, err)
+
+	target := &models.Target{Name: deployment.TargetName}
+	application := &models.Application{
+		Name:    deployment.ApplicationName,
+		Targets: []*models.Target{target},
+	}
+	setConfig(&Configuration{Applications: []*models.Application{application}})
+
+	testDone := make(chan struct{})
+	testSubscriber := func(ev *DeploymentEvent) error {
+		if ev.State != models.DEPLOYMENT_NEW {
+			t.Errorf("deployment event has wrong state")
+		}
+
+		if ev.User.Id != user.Id {
+			t.Errorf("deployment event has wrong user")
+		}
+
+		if ev.Deployment.Id != deployment.Id {
+			t.Errorf("subscriber called with wrong deployment event")
+		}
+
+		if ev.Deployment.User == nil {
+			t.Errorf("deployment user in event not set")
+		}
+		if ev.Deployment.User.Id != user.Id {
+			t.Errorf("deployment user is wrong")
+		}
+
+		if ev.Application != application {
+			t.Errorf("deployment event has wrong application")
+		}
+
+		if ev.Target.Name != target.Name {
+			t.Errorf("deployment event has wrong target.")
+		}
+
+		testDone <- struct{}{}
+		return nil
+	}
+
+	bus := NewEventBus(db, nil)
+	bus.OnDeploymentState("test", []models.DeploymentState{models.DEPLOYMENT_NEW}, testSubscriber)
+	bus.StartListenerWorkers(1, time.Second)
+
+	bus.PublishDeploymentState(models.DEPLOYMENT_NEW, deployment)
+
+	<-testDone
+}
+
+func TestDeploymentEventDeploymentURL(t *testing.T) {
+	setConfig(&Configuration{
+		Host:       "example.com",
+		SSLEnabled: true,
+	})
+
+	deployment := &models.Deployment{
+		Id:              999999,
+		UserId:          888888,
+		CommitSha:       "f133742",
+		Branch:          "master",
+		Comment:         "Deploying a hotfix",
+		ApplicationName: "my-web-app",
+		TargetName:      "production",
+	}
+	user := &models.User{
+		Name: "mrnugget",
+		Id:   deployment.UserId,
+	}
+	target := &models.Target{Name: "production"}
+	application := &models.Application{
+		Name:        "my-web-app",
+		GitHubRepo:  "my-web-app",
+		GitHubOwner: "shipping-co",
+	}
+
+	event := &DeploymentEvent{
+		State:       models.DEPLOYMENT_SUCCESSFUL,
+		Deployment:  deployment,
+		Application: application,
+		Target:      target,
+		User:        user,
+	}
+
+	deploymentURL := event.DeploymentURL()
+	if deploymentURL != "https://example.com/my-web-app/deployments/999999" {
+		t.Errorf("DeploymentURL() returned wrong url. got=%q", deploymentURL)
+	}
+}
+
+func TestOnLogEntries(t *testing.T) {
+	router := deploy.NewLogRouter()
+	router.Start()
+	defer router.Stop()
+
+	bus := NewEventBus(&sql.DB{}, router)
+
+	received := make(chan deploy.LogEntry)
+	bus.OnLogEntries(func(entries <-chan deploy.LogEntry) {
+		for entry := range entries {
+			received <- entry
+		}
+	})
+
+	router.Announce(42)
+	err := bus.OnDeploymentLogEntries(42, func(entries <-chan deploy.LogEntry) {
+		for entry := range entries {
+			received <- entry
+		}
+	})
+	checkErr(t, err)
+
+	go func() {
+		router.Broadcast <- deploy.LogEntry{DeploymentId: 42, Message: "deploying"}
+	}()
+
+	for i := 0; i < 2; i++ {
+		if entry := <-received; entry.Message != "deploying" {
+			t.Errorf("wrong log entry. want=%s, got=%s", "deploying", entry.Message)
+		}
+	}
+
+	err = bus.OnDeploymentLogEntries(43, func(<-chan deploy.LogEntry) {})
+	if err != deploy.ErrNoDeployment {
+		t.Errorf("wrong error for a deployment that isn't running. want=%v, got=%v", deploy.ErrNoDeployment, err)
+	}
+}
+
+func TestListenerTimeout(t *testing.T) {
+	bus := NewEventBus(&sql.DB{}, nil)
+	event := &DeploymentEvent{State: models.DEPLOYMENT_SUCCESSFUL, Deployment: &models.Deployment{Id: 1}}
+
+	release := make(chan struct{})
+	defer close(release)
+	hanging := stateListener{name: "hanging", fn: func(*DeploymentEvent) error {
+		<-release
+		return nil
+	}}
+
+	called := make(chan struct{}, 1)
+	next := stateListener{name: "next", fn: func(*DeploymentEvent) error {
+		called <- struct{}{}
+		return nil
+	}}
+
+	bus.queueListenerCall(hanging, event)
+	bus.queueListenerCall(next, event)
+	bus.StartListenerWorkers(1, 10*time.Millisecond)
+
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatalf("hanging listener blocked the worker")
+	}
+}
+
+func TestListenerCircuits(t *testing.T) {
+	circuits := newListenerCircuits()
+	now := time.Now()
+
+	for i := 0; i < listenerCircuitThreshold-1; i++ {
+		if circuits.Failed("slack", now) {
+			t.Fatalf("circuit opened after %d failures", i+1)
+		}
+	}
+	if !circuits.Allow("slack", now) {
+		t.Errorf("listener not allowed before the circuit opened")
+	}
+
+	if !circuits.Failed("slack", now) {
+		t.Fatalf("circuit not opened after %d failures", listenerCircuitThreshold)
+	}
+	if circuits.Allow("slack", now.Add(listenerCircuitCooldown/2)) {
+		t.Errorf("listener allowed while the circuit is open")
+	}
+	if !circuits.Allow("bugsnag", now) {
+		t.Errorf("other listeners should not be affected")
+	}
+
+	later := now.Add(listenerCircuitCooldown + time.Second)
+	if !circuits.Allow("slack", later) {
+		t.Errorf("listener not allowed after the cooldown")
+	}
+	if !circuits.Failed("slack", later) {
+		t.Errorf("circuit not opened again after a failure following the cooldown")
+	}
+
+	circuits.Succeeded("slack")
+	if circuits.Failed("slack", later) {
+		t.Errorf("circuit not closed after a successful call")
+	}
+}
+
+func TestListenerCircuitsHangingCalls(t *testing.T) {
+	circuits := newListenerCircuits()
+	now := time.Now()
+
+	for i := 0; i < listenerCircuitThreshold; i++ {
+		circuits.TimedOut("webhook", now)
+	}
+	circuits.Succeeded("webhook")
+
+	later := now.Add(listenerCircuitCooldown + time.Second)
+	if circuits.Allow("webhook", later) {
+		t.Errorf("listener allowed while %d calls hang", listenerCircuitThreshold)
+	}
+
+	circuits.Returned("webhook")
+	if !circuits.Allow("webhook", later) {
+		t.Errorf("listener not allowed after a hanging call returned")
+	}
+}
+
+func TestListenerErrorsOpenCircuitOfTarget(t *testing.T) {
+	bus := NewEventBus(&sql.DB{}, nil)
+
+	calls := map[string]int{}
+	failing := stateListener{name: "webhook", fn: func(ev *DeploymentEvent) error {
+		calls[ev.Deployment.TargetName]++
+		if ev.Deployment.TargetName == "production" {
+			return errors.New("unexpected status 500")
+		}
+		return nil
+	}}
+	call := func(target string) {
+		d := &models.Deployment{Id: 1, ApplicationName: "web", TargetName: target}
+		bus.callListener(listenerCall{failing, &DeploymentEvent{Deployment: d}}, time.Second)
+	}
+
+	for i := 0; i < listenerCircuitThreshold+1; i++ {
+		call("production")
+		call("staging")
+	}
+
+	if calls["production"] != listenerCircuitThreshold {
+		t.Errorf("failing listener called after the circuit opened. want=%d, got=%d", listenerCircuitThreshold, calls["production"])
+	}
+	if calls["staging"] != listenerCircuitThreshold+1 {
+		t.Errorf("listener skipped for other targets. want=%d, got=%d", listenerCircuitThreshold+1, calls["staging"])
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	defaultApiEventsLimit = 50
+	maxApiEventsLimit     = 500
+)
+
+// apiEvent is the normalized record of a change of a deployment, returned by
+// /api/v1/events and posted to the event_webhooks. It's flat, so automation
+// platforms like Zapier or n8n can map its fields without custom code.
+type apiEvent struct {
+	Id           int                    `json:"id"`
+	Type         models.EventType       `json:"type"`
+	OccurredAt   time.Time              `json:"occurred_at"`
+	Application  string                 `json:"application"`
+	Target       string                 `json:"target"`
+	DeploymentId int                    `json:"deployment_id"`
+	State        models.DeploymentState `json:"state"`
+	CommitSha    string                 `json:"commit_sha"`
+	Branch       string                 `json:"branch"`
+	Tag          string                 `json:"tag"`
+	Comment      string                 `json:"comment"`
+	Deployer     string                 `json:"deployer"`
+	// The absolute URLs of the deployment page and of the deployment in the
+	// API
+	DeploymentURL    string `json:"deployment_url"`
+	DeploymentApiURL string `json:"deployment_api_url"`
+}
+
+// eventFilter narrows down the events. Empty fields don't filter.
+type eventFilter struct {
+	// The applications whose events are returned, the ones the user can read
+	ApplicationNames []string
+	TargetName       string
+	Type             models.EventType
+	// Only the events after the event with this ID, oldest first
+	After int
+	Limit int
+}
+
+func newApiEvent(e *models.EventRecord) *apiEvent {
+	config := getConfig()
+	a := &models.Application{Name: e.ApplicationName}
+	d := &models.Deployment{Id: e.DeploymentId}
+
+	return &apiEvent{
+		Id:               e.Id,
+		Type:             e.Type,
+		OccurredAt:       e.CreatedAt,
+		Application:      e.ApplicationName,
+		Target:           e.TargetName,
+		DeploymentId:     e.DeploymentId,
+		State:            e.State,
+		CommitSha:        e.CommitSha,
+		Branch:           e.Branch,
+		Tag:              e.Tag,
+		Comment:          e.Comment,
+		Deployer:         e.UserName,
+		DeploymentURL:    config.URL(deploymentUrl(a, d)),
+		DeploymentApiURL: config.URL(apiDeploymentUrl(a, d)),
+	}
+}
+
+// RecordEvent saves the change of the deployment for /api/v1/events and posts
+// it to the event_webhooks of the application.
+func RecordEvent(ev *DeploymentEvent) error {
+	e := models.NewEventRecord(ev.Deployment, ev.State, time.Now())
+	e.ApplicationName = ev.Application.Name
+	if e.UserName == "" && ev.User != nil {
+		e.UserName = ev.User.Name
+	}
+
+	if err := createEventRecord(db, e); err != nil {
+		deploymentLogger(ev.Deployment).Error("saving event failed", "type", e.Type, "err", err)
+		metrics.NotifierFailed("events")
+		return err
+	}
+
+	return sendToWebhooks(ev.Application.EventWebhooks, func(hook string) error {
+		return sendEventWebhook(hook, e)
+	})
+}
+
+func sendEventWebhook(hook string, e *models.EventRecord) error {
+	logger := deploymentLogger(&models.Deployment{Id: e.DeploymentId})
+
+	payload, err := json.Marshal(newApiEvent(e))
+	if err != nil {
+		logger.Error("error creating event webhook message", "err", err)
+		metrics.NotifierFailed("webhook")
+		return err
+	}
+
+	header := http.Header{
+		"Content-Type":        {"application/json"},
+		"X-Applikatoni-Event": {string(e.Type)},
+	}
+	d := newDelivery("webhook", e.DeploymentId, "POST", hook, header, payload, 0)
+	if err := deliver(d); err != nil {
+		logger.Error("posting event to webhook failed", "url", hook, "status", d.StatusCode, "err", err)
+		metrics.NotifierFailed("webhook")
+		return err
+	}
+
+	logger.Info("posted event to webhook", "url", hook, "type", e.Type, "status", d.StatusCode)
+	return nil
+}
+
+// apiEventsHandler lists the events of the applications the user can read.
+// Without after, it returns the latest events, newest first. With after, the
+// ID of the last event a client has seen, it returns the events since then,
+// oldest first, so clients can poll without missing events.
+func apiEventsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	query := r.URL.Query()
+
+	filter := &eventFilter{
+		TargetName: query.Get("target"),
+		Type:       models.EventType(query.Get("type")),
+		Limit:      defaultApiEventsLimit,
+	}
+
+	if l := query.Get("limit"); l != "" {
+		limit, err := strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxApiEventsLimit {
+			renderApiErrorDetails(w, 422, apiErrValidationFailed, "limit must be a number between 1 and 500", map[string]string{"parameter": "limit"})
+			return
+		}
+		filter.Limit = limit
+	}
+	if a := query.Get("after"); a != "" {
+		after, err := strconv.Atoi(a)
+		if err != nil || after < 0 {
+			renderApiErrorDetails(w, 422, apiErrValidationFailed, "after must be the ID of an event", map[string]string{"parameter": "after"})
+			return
+		}
+		filter.After = after
+	}
+	if filter.Type != "" && !models.IsEventType(filter.Type) {
+		renderApiErrorDetails(w, 422, apiErrValidationFailed, "unknown event type", map[string]string{"parameter": "type"})
+		return
+	}
+
+	if name := query.Get("application"); name != "" {
+		application, err := findApplication(name)
+		if err != nil || !application.CanRead(currentUser) {
+			renderApiError(w, http.StatusNotFound, "application not found")
+			return
+		}
+		filter.ApplicationNames = []string{application.Name}
+	} else {
+		for _, a := range getConfig().Applications {
+			if a.CanRead(currentUser) {
+				filter.ApplicationNames = append(filter.ApplicationNames, a.Name)
+			}
+		}
+	}
+
+	events, err := getFilteredEventRecords(db, filter)
+	if err != nil {
+		requestLogger(r).Error("error loading events", "err", err)
+		renderApiError(w, http.StatusInternalServerError, "could not load events")
+		return
+	}
+
+	result := []*apiEvent{}
+	for _, e := range events {
+		result = append(result, newApiEvent(e))
+	}
+
+	renderApiData(w, http.StatusOK, result)
+}
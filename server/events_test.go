@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func TestApiEventsHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{Host: "applikatoni.example.com", Applications: []*models.Application{
+		{Name: "flincOnRails", ReadUsernames: []string{"mrnugget"}, Targets: []*models.Target{{Name: "production"}}},
+		{Name: "secret", ReadUsernames: []string{"fabrik42"}, Targets: []*models.Target{{Name: "production"}}},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+	checkErr(t, setApiToken(db, user, "t0k3n"))
+
+	deployment := buildDeployment(user.Id)
+	deployment.User = user
+	checkErr(t, createDeployment(db, deployment))
+	other := buildDeployment(user.Id)
+	other.ApplicationName = "secret"
+	checkErr(t, createDeployment(db, other))
+
+	for _, state := range []models.DeploymentState{models.DEPLOYMENT_NEW, models.DEPLOYMENT_ACTIVE, models.DEPLOYMENT_SUCCESSFUL} {
+		RecordEvent(&DeploymentEvent{State: state, Deployment: deployment, Application: getConfig().Applications[0], User: user})
+	}
+	RecordEvent(&DeploymentEvent{State: models.DEPLOYMENT_NEW, Deployment: other, Application: getConfig().Applications[1], User: user})
+
+	router := mux.NewRouter()
+	setupApiRoutes(router)
+
+	get := func(path string) ([]*apiEvent, int) {
+		req := httptest.NewRequest("GET", path, nil)
+		req.Header.Set("X-Api-Token", "t0k3n")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		var body struct {
+			Data []*apiEvent `json:"data"`
+		}
+		if rec.Code == 200 {
+			checkErr(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		}
+		return body.Data, rec.Code
+	}
+
+	events, status := get("/api/v1/events")
+	if status != 200 || len(events) != 3 {
+		t.Fatalf("wrong events. status=%d, got=%+v", status, events)
+	}
+	latest := events[0]
+	if latest.Type != models.EVENT_DEPLOYMENT_SUCCEEDED || latest.State != models.DEPLOYMENT_SUCCESSFUL || latest.Deployer != "mrnugget" || latest.Application != "flincOnRails" {
+		t.Errorf("wrong latest event. got=%+v", latest)
+	}
+	if latest.DeploymentURL != "http://applikatoni.example.com/flincOnRails/deployments/"+strconv.Itoa(deployment.Id) {
+		t.Errorf("wrong deployment URL. got=%s", latest.DeploymentURL)
+	}
+	if time.Since(latest.OccurredAt) > time.Minute {
+		t.Errorf("wrong time. got=%s", latest.OccurredAt)
+	}
+
+	// Polling after the first event returns the newer ones, oldest first
+	events, _ = get("/api/v1/events?after=" + strconv.Itoa(events[2].Id))
+	if len(events) != 2 || events[0].Type != models.EVENT_DEPLOYMENT_STARTED || events[1].Type != models.EVENT_DEPLOYMENT_SUCCEEDED {
+		t.Errorf("wrong events after the first. got=%+v", events)
+	}
+
+	events, _ = get("/api/v1/events?type=deployment.created&application=flincOnRails&target=production&limit=1")
+	if len(events) != 1 || events[0].Type != models.EVENT_DEPLOYMENT_CREATED {
+		t.Errorf("wrong filtered events. got=%+v", events)
+	}
+
+	for path, expectedStatus := range map[string]int{
+		"/api/v1/events?application=secret":  404,
+		"/api/v1/events?type=deployment.won": 422,
+		"/api/v1/events?limit=1000":          422,
+		"/api/v1/events?after=first":         422,
+	} {
+		if _, status := get(path); status != expectedStatus {
+			t.Errorf("wrong status for %s. want=%d, got=%d", path, expectedStatus, status)
+		}
+	}
+}
@@ -1,60 +1,58 @@
 package main
 
 import (
-	"log"
 	"net/http"
 	"net/url"
-	"text/template"
 
 	"github.com/applikatoni/applikatoni/models"
 )
 
 const flowdockTmplStr = `{{.GitHubRepo}} {{if .Success}}Successfully Deployed{{else}}Deploy Failed{{end}}:
-**{{.Username}}** deployed **{{.Branch}}** on **{{.Target}}** :pizza:
+**{{.Username}}** deployed **{{if .Tag}}{{.Tag}}{{else}}{{.Branch}}{{end}}** on **{{.Target}}**{{with .ApprovedBy}}, approved by **{{.}}**{{end}} :pizza:
 
 {{range $idx, $line := .CommentLines}}
 > {{$line}}
 {{end}}
-
-[View latest commit on GitHub]({{.GitHubUrl}})
+{{range .Changelog}}* {{.ShortSha}} {{.Summary}} ({{.Author}})
+{{end}}{{if .Issues}}
+Issues: {{range $i, $issue := .Issues}}{{if $i}}, {{end}}[{{$issue.Text}}]({{$issue.URL}}){{end}}
+{{end}}
+[View latest commit]({{.GitHubUrl}})
 [Open deployment in Applikatoni]({{.DeploymentURL}})
 `
 
-var flowdockTemplate = template.Must(template.New("flowdockSummary").Parse(flowdockTmplStr))
+var flowdockTemplate = notifierTemplate("flowdockSummary", flowdockTmplStr)
 
-func NotifyFlowdock(ev *DeploymentEvent) {
+func NotifyFlowdock(ev *DeploymentEvent) error {
 	if ev.Target.FlowdockEndpoint == "" {
-		return
+		return nil
 	}
 
 	summary, err := generateSummary(flowdockTemplate, ev)
 	if err != nil {
-		log.Printf("Could not generate deployment summary, %s\n", err)
-		return
+		deploymentLogger(ev.Deployment).Error("could not generate Flowdock deployment summary", "err", err)
+		metrics.NotifierFailed("flowdock")
+		return err
 	}
 
-	SendFlowdockRequest(ev.Target.FlowdockEndpoint, ev.Deployment, summary)
+	return SendFlowdockRequest(ev.Target.FlowdockEndpoint, ev.Deployment, summary)
 }
 
-func SendFlowdockRequest(endpoint string, d *models.Deployment, summary string) {
+func SendFlowdockRequest(endpoint string, d *models.Deployment, summary string) error {
 	params := url.Values{
 		"event":   {"message"},
 		"content": {summary},
 		"tags":    {"deploy,applikatoni"},
 	}
 
-	resp, err := http.PostForm(endpoint, params)
-	if err != nil {
-		log.Printf("Notifying Flowdock failed (%s on %s, %s): err=%s\n",
-			d.ApplicationName, d.TargetName, d.CommitSha, err)
-		return
-	}
-	if resp.StatusCode != 201 {
-		log.Printf("Notifying Flowdock failed (%s on %s, %s): status=%d\n",
-			d.ApplicationName, d.TargetName, d.CommitSha, resp.StatusCode)
-		return
+	header := http.Header{"Content-Type": {"application/x-www-form-urlencoded"}}
+	delivery := newDelivery("flowdock", d.Id, "POST", endpoint, header, []byte(params.Encode()), 201)
+	if err := deliver(delivery); err != nil {
+		deploymentLogger(d).Error("notifying Flowdock failed", "err", err)
+		metrics.NotifierFailed("flowdock")
+		return err
 	}
 
-	log.Printf("Successfully notified Flowdock about deployment of %s on %s, %s!\n",
-		d.ApplicationName, d.TargetName, d.CommitSha)
+	deploymentLogger(d).Info("notified Flowdock")
+	return nil
 }
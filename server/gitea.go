@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/oauth2"
+)
+
+// ErrGiteaUnauthorized is returned if Gitea rejects the access token.
+var ErrGiteaUnauthorized = errors.New("Gitea rejected the access token")
+
+// GiteaClient accesses the repositories of a Gitea or Forgejo instance. Its
+// pull requests and commits have the same format as the ones of GitHub.
+type GiteaClient struct {
+	*http.Client
+	apiURL string
+}
+
+func NewGiteaClient(baseURL, accessToken string) *GiteaClient {
+	token := &oauth2.Token{AccessToken: accessToken}
+	client := oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(token))
+
+	return &GiteaClient{Client: client, apiURL: baseURL + "/api/v1"}
+}
+
+func (gc *GiteaClient) repositoryURL(a *models.Application) string {
+	return fmt.Sprintf("%s/repos/%s/%s", gc.apiURL, a.GitHubOwner, a.GitHubRepo)
+}
+
+func (gc *GiteaClient) GetPullRequests(a *models.Application) ([]PullRequest, error) {
+	pulls := []PullRequest{}
+
+	err := gc.GetDecode(gc.repositoryURL(a)+"/pulls?state=open", &pulls)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setPullRequestsTravisImages(a, pulls)
+	if err != nil {
+		return nil, err
+	}
+
+	return pulls, nil
+}
+
+func (gc *GiteaClient) GetPullRequest(a *models.Application, number int) (*PullRequest, error) {
+	pull := &PullRequest{}
+
+	err := gc.GetDecode(fmt.Sprintf("%s/pulls/%d", gc.repositoryURL(a), number), pull)
+	if err != nil {
+		return nil, err
+	}
+
+	return pull, nil
+}
+
+// CommentOnPullRequest adds a comment to the conversation of the pull request.
+func (gc *GiteaClient) CommentOnPullRequest(a *models.Application, number int, body string) error {
+	url := fmt.Sprintf("%s/issues/%d/comments", gc.repositoryURL(a), number)
+
+	return gc.PostJSON(url, map[string]string{"body": body})
+}
+
+// GetTags returns the most recently created tags of the repository.
+func (gc *GiteaClient) GetTags(a *models.Application) ([]Tag, error) {
+	tags := []Tag{}
+
+	err := gc.GetDecode(gc.repositoryURL(a)+"/tags?limit=30", &tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+func (gc *GiteaClient) GetTag(a *models.Application, name string) (*Tag, error) {
+	tag := &Tag{}
+
+	err := gc.GetDecode(fmt.Sprintf("%s/tags/%s", gc.repositoryURL(a), url.PathEscape(name)), tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+func (gc *GiteaClient) GetBranches(a *models.Application) ([]Branch, error) {
+	branches := []Branch{}
+
+	for _, branchName := range a.GitHubBranches {
+		branch, err := gc.GetBranch(a, branchName)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, *branch)
+	}
+
+	err := setBranchesTravisImages(a, branches)
+	if err != nil {
+		return nil, err
+	}
+
+	return branches, nil
+}
+
+func (gc *GiteaClient) GetBranch(a *models.Application, name string) (*Branch, error) {
+	giteaBranch := struct {
+		Name   string `json:"name"`
+		Commit struct {
+			Id        string    `json:"id"`
+			Message   string    `json:"message"`
+			URL       string    `json:"url"`
+			Timestamp time.Time `json:"timestamp"`
+			Author    struct {
+				Name     string `json:"name"`
+				Username string `json:"username"`
+			} `json:"author"`
+		} `json:"commit"`
+	}{}
+
+	endpoint := fmt.Sprintf("%s/branches/%s", gc.repositoryURL(a), url.PathEscape(name))
+	err := gc.GetDecode(endpoint, &giteaBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	author := giteaBranch.Commit.Author.Username
+	if author == "" {
+		author = giteaBranch.Commit.Author.Name
+	}
+
+	branch := Branch{Name: giteaBranch.Name}
+	branch.CurrentCommit.Author = &models.User{Name: author}
+	branch.CurrentCommit.Sha = giteaBranch.Commit.Id
+	branch.CurrentCommit.HtmlURL = giteaBranch.Commit.URL
+	branch.CurrentCommit.Commit.Message = strings.TrimRight(giteaBranch.Commit.Message, "\n")
+	branch.CurrentCommit.Commit.Committer.ComittedAt = giteaBranch.Commit.Timestamp
+
+	return &branch, nil
+}
+
+func (gc *GiteaClient) Compare(a *models.Application, oldSha, newSha string) (*Diff, error) {
+	comparison := struct {
+		TotalCommits int      `json:"total_commits"`
+		Commits      []Commit `json:"commits"`
+	}{}
+
+	endpoint := fmt.Sprintf("%s/compare/%s...%s", gc.repositoryURL(a), oldSha, newSha)
+	err := gc.GetDecode(endpoint, &comparison)
+	if err != nil {
+		return nil, err
+	}
+
+	// Gitea only returns the commits newSha is ahead of oldSha
+	diff := &Diff{
+		CompareURL: fmt.Sprintf("%s/compare/%s...%s", repositoryWebURL(a), oldSha, newSha),
+		Status:     "identical",
+		AheadBy:    comparison.TotalCommits,
+		Commits:    comparison.Commits,
+	}
+	if diff.AheadBy > 0 {
+		diff.Status = "ahead"
+	}
+
+	// Authors without account on the instance are null
+	for i := range diff.Commits {
+		if diff.Commits[i].Author == nil {
+			diff.Commits[i].Author = &models.User{}
+		}
+	}
+
+	return diff, nil
+}
+
+func (gc *GiteaClient) GetDecode(url string, v interface{}) error {
+	res, err := gc.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrGiteaUnauthorized
+	}
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("Gitea responded with %d instead of 200", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (gc *GiteaClient) PostJSON(url string, v interface{}) error {
+	jsonPayload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	res, err := gc.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrGiteaUnauthorized
+	}
+
+	if res.StatusCode != 201 {
+		return fmt.Errorf("Gitea responded with %d instead of 201", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/oauth2"
+)
+
+func TestGiteaClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			t.Errorf("wrong Authorization header. got=%s", r.Header.Get("Authorization"))
+		}
+
+		switch r.URL.Path {
+		case "/api/v1/repos/shipping-co/web-app/pulls":
+			fmt.Fprintln(w, `[{"id": 7, "title": "Add pizza", "html_url": "http://example.com/pulls/7", "user": {"login": "mrnugget"}, "head": {"ref": "pizza", "sha": "f00b4r"}}]`)
+		case "/api/v1/repos/shipping-co/web-app/branches/master":
+			fmt.Fprintln(w, `{"name": "master", "commit": {"id": "f00b4r", "message": "Add pizza\n", "author": {"name": "Mr Nugget", "username": "mrnugget"}, "timestamp": "2016-10-16T10:00:00Z"}}`)
+		case "/api/v1/repos/shipping-co/web-app/compare/0ld...f00b4r":
+			fmt.Fprintln(w, `{"total_commits": 1, "commits": [{"sha": "f00b4r", "commit": {"message": "Add pizza"}, "author": null}]}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	setConfig(&Configuration{GiteaURL: ts.URL})
+	defer func() { setConfig(&Configuration{}) }()
+
+	a := &models.Application{
+		SCM:            models.SCM_GITEA,
+		GitHubOwner:    "shipping-co",
+		GitHubRepo:     "web-app",
+		GitHubBranches: []string{"master"},
+	}
+	client := NewGiteaClient(ts.URL, "t0k3n")
+
+	pulls, err := client.GetPullRequests(a)
+	if err != nil {
+		t.Fatalf("loading pull requests failed: %s", err)
+	}
+	if len(pulls) != 1 || pulls[0].Head.CommitSha != "f00b4r" || pulls[0].User.Name != "mrnugget" {
+		t.Errorf("wrong pull requests. got=%+v", pulls)
+	}
+
+	branches, err := client.GetBranches(a)
+	if err != nil {
+		t.Fatalf("loading branches failed: %s", err)
+	}
+	if len(branches) != 1 || branches[0].CurrentCommit.Sha != "f00b4r" {
+		t.Errorf("wrong branches. got=%+v", branches)
+	}
+	if branches[0].CurrentCommit.Author.Name != "mrnugget" {
+		t.Errorf("wrong author. want=%s, got=%s", "mrnugget", branches[0].CurrentCommit.Author.Name)
+	}
+
+	diff, err := client.Compare(a, "0ld", "f00b4r")
+	if err != nil {
+		t.Fatalf("comparing failed: %s", err)
+	}
+	if diff.AheadBy != 1 || diff.Commits[0].Author == nil {
+		t.Errorf("wrong diff. got=%+v", diff)
+	}
+	expected := ts.URL + "/shipping-co/web-app/compare/0ld...f00b4r"
+	if diff.CompareURL != expected {
+		t.Errorf("wrong compare URL. want=%s, got=%s", expected, diff.CompareURL)
+	}
+}
+
+func TestGiteaFetchUser(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/user" {
+			t.Errorf("wrong path. want=%s, got=%s", "/api/v1/user", r.URL.Path)
+		}
+		fmt.Fprintln(w, `{"id": 42, "login": "mrnugget", "avatar_url": "http://example.com/a.png"}`)
+	}))
+	defer ts.Close()
+
+	c := &Configuration{
+		Host:          "applikatoni.example.com",
+		GiteaURL:      ts.URL + "/",
+		GiteaClientId: "id",
+		GiteaTitle:    "Forgejo",
+	}
+
+	providers, err := setupAuthProviders(c)
+	if err != nil {
+		t.Fatalf("setting up providers failed: %s", err)
+	}
+	if len(providers) != 1 || providers[0].Name() != GITEA_PROVIDER {
+		t.Fatalf("wrong providers. got=%v", providers)
+	}
+
+	provider := providers[0]
+	if provider.Title() != "Forgejo" {
+		t.Errorf("wrong title. want=%s, got=%s", "Forgejo", provider.Title())
+	}
+	if provider.OAuth2Config().Endpoint.TokenURL != ts.URL+"/login/oauth/access_token" {
+		t.Errorf("wrong token URL. got=%s", provider.OAuth2Config().Endpoint.TokenURL)
+	}
+
+	user, err := provider.FetchUser(&oauth2.Token{AccessToken: "t0k3n"})
+	if err != nil {
+		t.Fatalf("fetching user failed: %s", err)
+	}
+	if user.Name != "mrnugget" || user.ProviderId != "42" {
+		t.Errorf("wrong user. name=%s, provider id=%s", user.Name, user.ProviderId)
+	}
+
+	a := &models.Application{ReadUsernames: []string{"mrnugget"}}
+	if a.CanRead(user) {
+		t.Errorf("Gitea user can read as GitHub user %s", user.Name)
+	}
+	a.ReadUsernames = []string{"gitea:mrnugget"}
+	if !a.CanRead(user) {
+		t.Errorf("Gitea user listed as %s can't read", "gitea:mrnugget")
+	}
+}
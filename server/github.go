@@ -14,49 +14,14 @@ import (
 	"golang.org/x/oauth2"
 )
 
-const gitHubAPI = "https://api.github.com"
-
-type GitHubCommit struct {
-	Author  *models.User `json:"author"`
-	Sha     string       `json:"sha"`
-	HtmlURL string       `json:"html_url"`
-	Commit  struct {
-		Message   string `json:"message"`
-		Committer struct {
-			ComittedAt time.Time `json:"date"`
-		} `json:"committer"`
-	} `json:"commit"`
-}
-
-type GitHubPullRequest struct {
-	Id        int64        `json:"id"`
-	Url       string       `json:"html_url"`
-	Title     string       `json:"title"`
-	User      *models.User `json:"user"`
-	CreatedAt time.Time    `json:"created_at"`
-	UpdatedAt time.Time    `json:"updated_at"`
-	Head      struct {
-		Branch    string `json:"ref"`
-		CommitSha string `json:"sha"`
-	} `json:"head"`
-	TravisImageURL  string `json:"travis_image_url"`
-	TravisImageLink string `json:"travis_image_link"`
-}
-
-type GitHubBranch struct {
-	Name            string       `json:"name"`
-	CurrentCommit   GitHubCommit `json:"commit"`
-	TravisImageURL  string       `json:"travis_image_url"`
-	TravisImageLink string       `json:"travis_image_link"`
-}
-
-type GitHubDiff struct {
-	GitHubCompareURL string         `json:"html_url"`
-	Status           string         `json:"status"`
-	AheadBy          int            `json:"ahead_by"`
-	BehindBy         int            `json:"behind_by"`
-	Commits          []GitHubCommit `json:"commits"`
-}
+const (
+	defaultGitHubURL = "https://github.com"
+	defaultGitHubAPI = "https://api.github.com"
+)
+
+// ErrGitHubUnauthorized is returned if GitHub rejects the access token of
+// the user, e.g. because the user revoked the authorization.
+var ErrGitHubUnauthorized = errors.New("GitHub rejected the access token")
 
 type GitHubDeployment struct {
 	Id          int64  `json:"id"`
@@ -70,83 +35,94 @@ type GitHubDeploymentStatus struct {
 	TargetURL string `json:"target_url"`
 }
 
-type GitHubClient struct{ *http.Client }
+// GitHubCheckRun is a check run on a commit, which only GitHub Apps can
+// create. Unset fields aren't changed when it's updated.
+type GitHubCheckRun struct {
+	Id          int64                 `json:"id,omitempty"`
+	Name        string                `json:"name,omitempty"`
+	HeadSha     string                `json:"head_sha,omitempty"`
+	ExternalId  string                `json:"external_id,omitempty"`
+	DetailsURL  string                `json:"details_url,omitempty"`
+	Status      string                `json:"status,omitempty"`
+	Conclusion  string                `json:"conclusion,omitempty"`
+	CompletedAt *time.Time            `json:"completed_at,omitempty"`
+	Output      *GitHubCheckRunOutput `json:"output,omitempty"`
+}
+
+type GitHubCheckRunOutput struct {
+	Title   string `json:"title"`
+	Summary string `json:"summary"`
+}
+
+type GitHubClient struct {
+	*http.Client
+	apiURL string
+}
 
 func NewGitHubClient(u *models.User) *GitHubClient {
-	token := &oauth2.Token{AccessToken: u.AccessToken}
+	return newGitHubTokenClient(u.AccessToken)
+}
+
+func newGitHubTokenClient(accessToken string) *GitHubClient {
+	token := &oauth2.Token{AccessToken: accessToken}
 	client := oauthCfg.Client(oauth2.NoContext, token)
 
-	return &GitHubClient{client}
+	return &GitHubClient{Client: client, apiURL: getConfig().GitHubAPIBaseURL()}
 }
 
-func (gc *GitHubClient) GetPullRequests(a *models.Application) ([]GitHubPullRequest, error) {
-	pulls := []GitHubPullRequest{}
+func (gc *GitHubClient) GetPullRequests(a *models.Application) ([]PullRequest, error) {
+	pulls := []PullRequest{}
 
-	url := fmt.Sprintf("%s/repos/%s/%s/pulls?state=open", gitHubAPI, a.GitHubOwner, a.GitHubRepo)
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls?state=open", gc.apiURL, a.GitHubOwner, a.GitHubRepo)
 	err := gc.GetDecode(url, &pulls)
 	if err != nil {
 		return nil, err
 	}
 
-	if a.TravisImageURL != "" {
-		link, err := buildTravisLink(a.TravisImageURL)
-		if err != nil {
-			return nil, err
-		}
-
-		for i, _ := range pulls {
-			imageURL, err := addBranchTravisURL(a.TravisImageURL, pulls[i].Head.Branch)
-			if err != nil {
-				return nil, err
-			}
-			pulls[i].TravisImageURL = imageURL
-			pulls[i].TravisImageLink = link
-		}
+	err = setPullRequestsTravisImages(a, pulls)
+	if err != nil {
+		return nil, err
 	}
 
 	return pulls, nil
 }
 
-func (gc *GitHubClient) GetBranches(a *models.Application) ([]GitHubBranch, error) {
-	branches := []GitHubBranch{}
+func (gc *GitHubClient) GetBranches(a *models.Application) ([]Branch, error) {
+	branches := []Branch{}
 
-	var travisLink string
-	if a.TravisImageURL != "" {
-		var err error
-		travisLink, err = buildTravisLink(a.TravisImageURL)
+	for _, branchName := range a.GitHubBranches {
+		branch, err := gc.GetBranch(a, branchName)
 		if err != nil {
 			return nil, err
 		}
+
+		branches = append(branches, *branch)
 	}
 
-	for _, branchName := range a.GitHubBranches {
-		branch := GitHubBranch{}
-		url := fmt.Sprintf("%s/repos/%s/%s/branches/%s", gitHubAPI, a.GitHubOwner, a.GitHubRepo, branchName)
+	err := setBranchesTravisImages(a, branches)
+	if err != nil {
+		return nil, err
+	}
 
-		err := gc.GetDecode(url, &branch)
-		if err != nil {
-			return nil, err
-		}
+	return branches, nil
+}
 
-		if a.TravisImageURL != "" {
-			imageURL, err := addBranchTravisURL(a.TravisImageURL, branchName)
-			if err != nil {
-				return nil, err
-			}
-			branch.TravisImageURL = imageURL
-			branch.TravisImageLink = travisLink
-		}
+func (gc *GitHubClient) GetBranch(a *models.Application, name string) (*Branch, error) {
+	branch := &Branch{}
+	url := fmt.Sprintf("%s/repos/%s/%s/branches/%s", gc.apiURL, a.GitHubOwner, a.GitHubRepo, name)
 
-		branches = append(branches, branch)
+	err := gc.GetDecode(url, branch)
+	if err != nil {
+		return nil, err
 	}
 
-	return branches, nil
+	return branch, nil
 }
 
-func (gc *GitHubClient) Compare(a *models.Application, oldSha, newSha string) (*GitHubDiff, error) {
-	diff := &GitHubDiff{}
+func (gc *GitHubClient) Compare(a *models.Application, oldSha, newSha string) (*Diff, error) {
+	diff := &Diff{}
 	url := fmt.Sprintf("%s/repos/%s/%s/compare/%s...%s",
-		gitHubAPI, a.GitHubOwner, a.GitHubRepo, oldSha, newSha)
+		gc.apiURL, a.GitHubOwner, a.GitHubRepo, oldSha, newSha)
 
 	err := gc.GetDecode(url, diff)
 	if err != nil {
@@ -156,16 +132,204 @@ func (gc *GitHubClient) Compare(a *models.Application, oldSha, newSha string) (*
 	return diff, nil
 }
 
+func (gc *GitHubClient) GetPullRequest(a *models.Application, number int) (*PullRequest, error) {
+	pull := &PullRequest{}
+
+	url := fmt.Sprintf("%s/repos/%s/%s/pulls/%d", gc.apiURL, a.GitHubOwner, a.GitHubRepo, number)
+	err := gc.GetDecode(url, pull)
+	if err != nil {
+		return nil, err
+	}
+
+	return pull, nil
+}
+
+// CommentOnPullRequest adds a comment to the conversation of the pull request.
+func (gc *GitHubClient) CommentOnPullRequest(a *models.Application, number int, body string) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/issues/%d/comments", gc.apiURL, a.GitHubOwner, a.GitHubRepo, number)
+
+	return gc.PostJSON(url, map[string]string{"body": body})
+}
+
+// GetTags returns the most recently created tags of the repository.
+func (gc *GitHubClient) GetTags(a *models.Application) ([]Tag, error) {
+	tags := []Tag{}
+
+	url := fmt.Sprintf("%s/repos/%s/%s/tags?per_page=30", gc.apiURL, a.GitHubOwner, a.GitHubRepo)
+	err := gc.GetDecode(url, &tags)
+	if err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// GetTag resolves the tag to the commit it points to, also for annotated
+// tags.
+func (gc *GitHubClient) GetTag(a *models.Application, name string) (*Tag, error) {
+	commit := Commit{}
+
+	endpoint := fmt.Sprintf("%s/repos/%s/%s/commits/%s", gc.apiURL, a.GitHubOwner, a.GitHubRepo, url.PathEscape(name))
+	err := gc.GetDecode(endpoint, &commit)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := &Tag{Name: name}
+	tag.Commit.Sha = commit.Sha
+	return tag, nil
+}
+
 func (gc *GitHubClient) UpdateUser(u *models.User) error {
-	url := fmt.Sprintf("%s/user", gitHubAPI)
+	url := fmt.Sprintf("%s/user", gc.apiURL)
 
 	err := gc.GetDecode(url, u)
 	return err
 }
 
+// HasTwoFactorAuth checks whether the user has enabled two-factor
+// authentication on GitHub.
+func (gc *GitHubClient) HasTwoFactorAuth() (bool, error) {
+	user := struct {
+		TwoFactorAuth *bool `json:"two_factor_authentication"`
+	}{}
+
+	url := fmt.Sprintf("%s/user", gc.apiURL)
+	err := gc.GetDecode(url, &user)
+	if err != nil {
+		return false, err
+	}
+
+	// GitHub only includes the field with the "user" scope
+	if user.TwoFactorAuth == nil {
+		return false, errors.New("GitHub did not report the two-factor authentication status")
+	}
+
+	return *user.TwoFactorAuth, nil
+}
+
+// GetOrganizations returns the logins of the organizations of the user.
+func (gc *GitHubClient) GetOrganizations() ([]string, error) {
+	orgs := []struct {
+		Login string `json:"login"`
+	}{}
+
+	url := fmt.Sprintf("%s/user/orgs?per_page=100", gc.apiURL)
+	err := gc.GetDecode(url, &orgs)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, org := range orgs {
+		names = append(names, org.Login)
+	}
+
+	return names, nil
+}
+
+// GetTeams returns the teams of the user as "organization/team-slug".
+func (gc *GitHubClient) GetTeams() ([]string, error) {
+	teams := []struct {
+		Slug         string `json:"slug"`
+		Organization struct {
+			Login string `json:"login"`
+		} `json:"organization"`
+	}{}
+
+	url := fmt.Sprintf("%s/user/teams?per_page=100", gc.apiURL)
+	err := gc.GetDecode(url, &teams)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, team := range teams {
+		names = append(names, team.Organization.Login+"/"+team.Slug)
+	}
+
+	return names, nil
+}
+
+// CIStatus is the combined result of the commit statuses and check runs of
+// a commit. State is "success", "pending", "failure" or "none" if no CI
+// reported anything for the commit.
+type CIStatus struct {
+	State string
+	// The names of the statuses and check runs that failed
+	Failed []string
+}
+
+// GetCIStatus combines the commit statuses and the check runs of the commit.
+func (gc *GitHubClient) GetCIStatus(a *models.Application, sha string) (*CIStatus, error) {
+	combined := struct {
+		State      string `json:"state"`
+		TotalCount int    `json:"total_count"`
+		Statuses   []struct {
+			State   string `json:"state"`
+			Context string `json:"context"`
+		} `json:"statuses"`
+	}{}
+
+	url := fmt.Sprintf("%s/repos/%s/%s/commits/%s/status", gc.apiURL, a.GitHubOwner, a.GitHubRepo, sha)
+	err := gc.GetDecode(url, &combined)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := struct {
+		TotalCount int `json:"total_count"`
+		CheckRuns  []struct {
+			Name       string `json:"name"`
+			Status     string `json:"status"`
+			Conclusion string `json:"conclusion"`
+		} `json:"check_runs"`
+	}{}
+
+	url = fmt.Sprintf("%s/repos/%s/%s/commits/%s/check-runs?per_page=100", gc.apiURL, a.GitHubOwner, a.GitHubRepo, sha)
+	err = gc.GetDecode(url, &checks)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &CIStatus{State: "none", Failed: []string{}}
+	pending := false
+
+	for _, s := range combined.Statuses {
+		status.State = "success"
+		switch s.State {
+		case "failure", "error":
+			status.Failed = append(status.Failed, s.Context)
+		case "pending":
+			pending = true
+		}
+	}
+
+	for _, c := range checks.CheckRuns {
+		status.State = "success"
+		if c.Status != "completed" {
+			pending = true
+			continue
+		}
+		switch c.Conclusion {
+		case "failure", "timed_out", "cancelled", "action_required", "startup_failure":
+			status.Failed = append(status.Failed, c.Name)
+		}
+	}
+
+	switch {
+	case len(status.Failed) > 0:
+		status.State = "failure"
+	case pending:
+		status.State = "pending"
+	}
+
+	return status, nil
+}
+
 func (gc *GitHubClient) CreateDeployment(a *models.Application, d *models.Deployment) (*GitHubDeployment, error) {
 	url := fmt.Sprintf("%s/repos/%s/%s/deployments",
-		gitHubAPI, a.GitHubOwner, a.GitHubRepo)
+		gc.apiURL, a.GitHubOwner, a.GitHubRepo)
 
 	createDeploymentPayload := struct {
 		AutoMerge        bool     `json:"auto_merge"`
@@ -225,12 +389,64 @@ func (gc *GitHubClient) CreateDeploymentStatus(statusesURL string, status *GitHu
 	return nil
 }
 
+func (gc *GitHubClient) CreateCheckRun(a *models.Application, run *GitHubCheckRun) (*GitHubCheckRun, error) {
+	url := fmt.Sprintf("%s/repos/%s/%s/check-runs", gc.apiURL, a.GitHubOwner, a.GitHubRepo)
+
+	created := &GitHubCheckRun{}
+	if err := gc.sendJSON("POST", url, run, 201, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (gc *GitHubClient) UpdateCheckRun(a *models.Application, id int64, run *GitHubCheckRun) error {
+	url := fmt.Sprintf("%s/repos/%s/%s/check-runs/%d", gc.apiURL, a.GitHubOwner, a.GitHubRepo, id)
+	return gc.sendJSON("PATCH", url, run, 200, nil)
+}
+
+// sendJSON sends v as JSON and decodes the answer into result, unless it's
+// nil.
+func (gc *GitHubClient) sendJSON(method, url string, v interface{}, expectedStatus int, result interface{}) error {
+	jsonPayload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, url, bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := gc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrGitHubUnauthorized
+	}
+	if res.StatusCode != expectedStatus {
+		return fmt.Errorf("GitHub responded with %d instead of %d", res.StatusCode, expectedStatus)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
+
 func (gc *GitHubClient) GetDecode(url string, v interface{}) error {
 	res, err := gc.Get(url)
 	if err != nil {
 		return err
 	}
 
+	if res.StatusCode == 401 {
+		return ErrGitHubUnauthorized
+	}
+
 	if res.StatusCode != 200 {
 		msg := fmt.Sprintf("GitHub responded with %d instead of 200", res.StatusCode)
 		return errors.New(msg)
@@ -246,6 +462,75 @@ func (gc *GitHubClient) GetDecode(url string, v interface{}) error {
 	return nil
 }
 
+func (gc *GitHubClient) PostJSON(url string, v interface{}) error {
+	jsonPayload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	res, err := gc.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrGitHubUnauthorized
+	}
+
+	if res.StatusCode != 201 {
+		return fmt.Errorf("GitHub responded with %d instead of 201", res.StatusCode)
+	}
+
+	return nil
+}
+
+// setPullRequestsTravisImages adds the Travis CI status badges of the head
+// branches to the pull requests, if the application has a travis_image_url.
+func setPullRequestsTravisImages(a *models.Application, pulls []PullRequest) error {
+	if a.TravisImageURL == "" {
+		return nil
+	}
+
+	link, err := buildTravisLink(a.TravisImageURL)
+	if err != nil {
+		return err
+	}
+
+	for i := range pulls {
+		imageURL, err := addBranchTravisURL(a.TravisImageURL, pulls[i].Head.Branch)
+		if err != nil {
+			return err
+		}
+		pulls[i].TravisImageURL = imageURL
+		pulls[i].TravisImageLink = link
+	}
+
+	return nil
+}
+
+func setBranchesTravisImages(a *models.Application, branches []Branch) error {
+	if a.TravisImageURL == "" {
+		return nil
+	}
+
+	link, err := buildTravisLink(a.TravisImageURL)
+	if err != nil {
+		return err
+	}
+
+	for i := range branches {
+		imageURL, err := addBranchTravisURL(a.TravisImageURL, branches[i].Name)
+		if err != nil {
+			return err
+		}
+		branches[i].TravisImageURL = imageURL
+		branches[i].TravisImageLink = link
+	}
+
+	return nil
+}
+
 func addBranchTravisURL(travisURL string, branch string) (string, error) {
 	u, err := url.Parse(travisURL)
 	if err != nil {
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Installation tokens are valid for an hour. They're renewed a bit earlier,
+// so a token doesn't expire in the middle of loading the deploy form.
+const gitHubInstallationTokenRenewal = 5 * time.Minute
+
+// githubApp is set if Applikatoni runs as a GitHub App. The repositories are
+// then accessed with the tokens of the installations of the app instead of
+// the tokens of the users.
+var githubApp *GitHubApp
+
+// GitHubApp authenticates as a GitHub App and creates the installation
+// tokens of the repositories it's installed on.
+type GitHubApp struct {
+	*http.Client
+	apiURL string
+	appId  int64
+	key    *rsa.PrivateKey
+
+	mu sync.Mutex
+	// The installation tokens by "owner/repo"
+	tokens map[string]*gitHubInstallationToken
+}
+
+type gitHubInstallationToken struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func NewGitHubApp(apiURL string, appId int64, key *rsa.PrivateKey) *GitHubApp {
+	return &GitHubApp{
+		Client: &http.Client{Timeout: 30 * time.Second},
+		apiURL: apiURL,
+		appId:  appId,
+		key:    key,
+		tokens: make(map[string]*gitHubInstallationToken),
+	}
+}
+
+// newGitHubAppFromConfig sets up the GitHub App of the github_app_id, nil if
+// none is configured.
+func newGitHubAppFromConfig(c *Configuration) (*GitHubApp, error) {
+	if c.GitHubAppId == 0 {
+		return nil, nil
+	}
+
+	path := c.GitHubAppPrivateKeyFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.dir, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseGitHubAppKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewGitHubApp(c.GitHubAPIBaseURL(), c.GitHubAppId, key), nil
+}
+
+// parseGitHubAppKey parses the private key GitHub generates for an app, a
+// PKCS #1 RSA key, or the same key converted to PKCS #8.
+func parseGitHubAppKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("the key is not an RSA key")
+	}
+	return key, nil
+}
+
+// jwt returns the JSON Web Token the app authenticates itself with. It's
+// valid for 10 minutes, the longest GitHub accepts, and issued a minute in
+// the past in case the clocks differ.
+func (app *GitHubApp) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": app.appId,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, app.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+// InstallationToken returns a token of the installation of the app on the
+// repository, which has the permissions granted to the app. Tokens are
+// reused until shortly before they expire.
+func (app *GitHubApp) InstallationToken(owner, repo string) (string, error) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	name := owner + "/" + repo
+	if t, ok := app.tokens[name]; ok && time.Now().Add(gitHubInstallationTokenRenewal).Before(t.ExpiresAt) {
+		return t.Token, nil
+	}
+
+	var installation struct {
+		Id int64 `json:"id"`
+	}
+	url := fmt.Sprintf("%s/repos/%s/%s/installation", app.apiURL, owner, repo)
+	if err := app.request("GET", url, 200, &installation); err != nil {
+		return "", fmt.Errorf("the GitHub App is not installed on %s: %s", name, err)
+	}
+
+	token := &gitHubInstallationToken{}
+	url = fmt.Sprintf("%s/app/installations/%d/access_tokens", app.apiURL, installation.Id)
+	if err := app.request("POST", url, 201, token); err != nil {
+		return "", fmt.Errorf("creating an installation token for %s failed: %s", name, err)
+	}
+
+	app.tokens[name] = token
+	return token.Token, nil
+}
+
+func (app *GitHubApp) request(method, url string, expectedStatus int, v interface{}) error {
+	token, err := app.jwt(time.Now())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	res, err := app.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectedStatus {
+		return fmt.Errorf("GitHub responded with %d instead of %d", res.StatusCode, expectedStatus)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
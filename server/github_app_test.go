@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGitHubAppKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	checkErr(t, err)
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	checkErr(t, err)
+
+	for _, block := range []*pem.Block{
+		{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		{Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		parsed, err := parseGitHubAppKey(pem.EncodeToMemory(block))
+		if err != nil || !parsed.Equal(key) {
+			t.Errorf("%s not parsed. got err=%v", block.Type, err)
+		}
+	}
+
+	if _, err := parseGitHubAppKey([]byte("not a key")); err == nil {
+		t.Errorf("invalid key parsed")
+	}
+}
+
+func TestGitHubAppJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	checkErr(t, err)
+
+	app := NewGitHubApp("", 4711, key)
+	now := time.Unix(1700000000, 0)
+
+	token, err := app.jwt(now)
+	checkErr(t, err)
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("wrong token. got=%s", token)
+	}
+
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	checkErr(t, err)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		t.Errorf("wrong signature: %s", err)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	checkErr(t, err)
+	var claims map[string]int64
+	checkErr(t, json.Unmarshal(payload, &claims))
+	if claims["iss"] != 4711 || claims["iat"] != 1699999940 || claims["exp"] != 1700000540 {
+		t.Errorf("wrong claims. got=%v", claims)
+	}
+}
+
+func TestGitHubAppInstallationToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	checkErr(t, err)
+
+	tokensCreated := 0
+	expiresAt := time.Now().Add(time.Hour)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			t.Errorf("request without JWT to %s", r.URL.Path)
+		}
+
+		switch r.URL.Path {
+		case "/repos/shipping-co/web-app/installation":
+			fmt.Fprintln(w, `{"id": 42}`)
+		case "/app/installations/42/access_tokens":
+			tokensCreated++
+			w.WriteHeader(201)
+			fmt.Fprintf(w, `{"token": "ghs_%d", "expires_at": %q}`, tokensCreated, expiresAt.Format(time.RFC3339))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	app := NewGitHubApp(ts.URL, 4711, key)
+
+	for i := 0; i < 2; i++ {
+		token, err := app.InstallationToken("shipping-co", "web-app")
+		checkErr(t, err)
+		if token != "ghs_1" {
+			t.Errorf("token not reused. got=%s", token)
+		}
+	}
+
+	// Tokens about to expire are renewed
+	expiresAt = time.Now().Add(time.Minute)
+	app.tokens["shipping-co/web-app"].ExpiresAt = expiresAt
+	token, err := app.InstallationToken("shipping-co", "web-app")
+	checkErr(t, err)
+	if token != "ghs_2" {
+		t.Errorf("token not renewed. got=%s", token)
+	}
+
+	if _, err := app.InstallationToken("shipping-co", "other"); err == nil {
+		t.Errorf("expected error for repository without installation")
+	}
+}
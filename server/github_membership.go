@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const defaultGitHubMembershipSyncInterval = 1 * time.Hour
+
+// fetchGitHubGroups returns the organizations and teams of the user. They
+// are saved as the groups of the user, teams as "organization/team-slug".
+func fetchGitHubGroups(gc *GitHubClient) ([]string, error) {
+	orgs, err := gc.GetOrganizations()
+	if err != nil {
+		return nil, err
+	}
+
+	teams, err := gc.GetTeams()
+	if err != nil {
+		return nil, err
+	}
+
+	return append(orgs, teams...), nil
+}
+
+// isAllowedUser checks whether the user is a member of one of the
+// github_organizations, if these are configured. Users of other login
+// providers are only allowed if their provider is one of the
+// exempt_login_providers, since their memberships can't be checked. Service
+// accounts are always allowed.
+func isAllowedUser(u *models.User) bool {
+	config := getConfig()
+	if u.ServiceAccount != nil || len(config.GitHubOrganizations) == 0 {
+		return true
+	}
+	if u.Provider != GITHUB_PROVIDER {
+		return containsString(config.ExemptLoginProviders, u.Provider)
+	}
+
+	for _, group := range u.Groups {
+		for _, org := range config.GitHubOrganizations {
+			if group == org {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTwoFactorAuth returns an error if two-factor authentication is not
+// enabled for the user. It can only be checked for GitHub users.
+func checkTwoFactorAuth(u *models.User) error {
+	if u.Provider != GITHUB_PROVIDER {
+		return errors.New("two-factor authentication is required, but can only be verified for users logged in with GitHub")
+	}
+
+	enabled, err := NewGitHubClient(u).HasTwoFactorAuth()
+	if err != nil {
+		return fmt.Errorf("could not verify two-factor authentication: %s", err)
+	}
+	if !enabled {
+		return errors.New("two-factor authentication has to be enabled on GitHub to deploy to this target")
+	}
+
+	return nil
+}
+
+// SyncGitHubMemberships periodically updates the cached organizations and
+// teams of all GitHub users, so that users who left an organization or team
+// lose their access without having to log in again.
+func SyncGitHubMemberships(db *sql.DB, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+
+		err := syncGitHubMemberships(db)
+		if err != nil {
+			slog.Error("syncing GitHub memberships failed", "err", err)
+		}
+	}
+}
+
+func syncGitHubMemberships(db *sql.DB) error {
+	users, err := getUsersByProvider(db, GITHUB_PROVIDER)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		groups, err := fetchGitHubGroups(NewGitHubClient(u))
+		switch {
+		case err == ErrGitHubUnauthorized:
+			// Without a valid token we can't know the memberships anymore
+			slog.Info("GitHub rejected the token, removing memberships", "user", u.Name)
+			groups = []string{}
+		case err != nil:
+			slog.Error("fetching the GitHub memberships failed", "user", u.Name, "err", err)
+			continue
+		}
+
+		u.Groups = groups
+		err = setUserGroups(db, u)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestIsAllowedUser(t *testing.T) {
+	setConfig(&Configuration{
+		GitHubOrganizations:  []string{"shipping-co"},
+		ExemptLoginProviders: []string{SAML_PROVIDER},
+	})
+
+	tests := []struct {
+		user     *models.User
+		expected bool
+	}{
+		{&models.User{Provider: GITHUB_PROVIDER, Groups: []string{"shipping-co", "shipping-co/ops"}}, true},
+		{&models.User{Provider: GITHUB_PROVIDER, Groups: []string{"other-co"}}, false},
+		{&models.User{Provider: GITHUB_PROVIDER}, false},
+		{&models.User{Provider: GITLAB_PROVIDER}, false},
+		{&models.User{Provider: SAML_PROVIDER}, true},
+		{&models.User{Provider: SERVICE_ACCOUNT_PROVIDER, ServiceAccount: &models.ServiceAccount{Name: "ci"}}, true},
+	}
+
+	for _, tt := range tests {
+		got := isAllowedUser(tt.user)
+		if got != tt.expected {
+			t.Errorf("wrong result for %s user with groups %v. want=%t, got=%t",
+				tt.user.Provider, tt.user.Groups, tt.expected, got)
+		}
+	}
+
+	setConfig(&Configuration{})
+	if !isAllowedUser(&models.User{Provider: GITHUB_PROVIDER}) {
+		t.Errorf("expected all users to be allowed without github_organizations")
+	}
+}
+
+func TestCheckTwoFactorAuthWithoutGitHub(t *testing.T) {
+	err := checkTwoFactorAuth(&models.User{Name: "mrnugget", Provider: GITLAB_PROVIDER})
+	if err == nil {
+		t.Errorf("expected error for user without GitHub login")
+	}
+}
+
+func TestExemptLoginProvidersValidation(t *testing.T) {
+	path := writeTestConfiguration(t, &Configuration{ExemptLoginProviders: []string{"saml", "github"}})
+	defer os.Remove(path)
+
+	if _, err := readConfiguration(path); err == nil {
+		t.Errorf("expected error for exempting GitHub")
+	}
+}
@@ -1,51 +1,98 @@
 package main
 
 import (
-	"log"
+	"fmt"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 )
 
 type GitHubNotifier struct {
 	deployments map[int]*GitHubDeployment
-	mutex       *sync.Mutex
+	// The IDs of the check runs of the deployments, only created by the
+	// GitHub App
+	checkRuns map[int]int64
+	mutex     *sync.Mutex
 }
 
 func NewGitHubNotifier() *GitHubNotifier {
 	return &GitHubNotifier{
 		deployments: make(map[int]*GitHubDeployment),
+		checkRuns:   make(map[int]int64),
 		mutex:       &sync.Mutex{},
 	}
 }
 
-func (notifier *GitHubNotifier) Notify(ev *DeploymentEvent) {
+// Notify creates a GitHub deployment of the deployed commit and updates its
+// status. If Applikatoni runs as a GitHub App, they're created by the app,
+// which also shows the deployment as a check run on the commit. Otherwise
+// they're created with the token of the deployer.
+func (notifier *GitHubNotifier) Notify(ev *DeploymentEvent) error {
+	// Only GitHub repositories have the Deployments API
+	if ev.Application.SCMName() != models.SCM_GITHUB {
+		return nil
+	}
+
 	notifier.mutex.Lock()
 	defer notifier.mutex.Unlock()
 
-	ghClient := NewGitHubClient(ev.User)
+	ghClient, err := gitHubNotifierClient(ev.Application, ev.User)
+	if err != nil {
+		deploymentLogger(ev.Deployment).Error("creating GitHub client failed", "err", err)
+		metrics.NotifierFailed("github")
+		return err
+	}
+
+	// A failed deployment doesn't keep the check run from being updated
+	err = notifier.notifyDeployment(ghClient, ev)
+	if githubApp != nil {
+		if checkRunErr := notifier.notifyCheckRun(ghClient, ev); err == nil {
+			err = checkRunErr
+		}
+	}
+	return err
+}
+
+func gitHubNotifierClient(a *models.Application, u *models.User) (*GitHubClient, error) {
+	if githubApp == nil {
+		return NewGitHubClient(u), nil
+	}
+
+	token, err := githubApp.InstallationToken(a.GitHubOwner, a.GitHubRepo)
+	if err != nil {
+		return nil, err
+	}
+	return newGitHubTokenClient(token), nil
+}
 
+func (notifier *GitHubNotifier) notifyDeployment(ghClient *GitHubClient, ev *DeploymentEvent) error {
 	if ev.State == models.DEPLOYMENT_NEW {
 		githubDeployment, err := ghClient.CreateDeployment(ev.Application, ev.Deployment)
 		if err != nil {
-			log.Printf("Creating GitHub deployment failed: %s\n", err)
-			return
+			deploymentLogger(ev.Deployment).Error("creating GitHub deployment failed", "err", err)
+			metrics.NotifierFailed("github")
+			return err
 		}
 		notifier.deployments[ev.Deployment.Id] = githubDeployment
 	} else {
 		githubDeployment, ok := notifier.deployments[ev.Deployment.Id]
 		if !ok {
-			log.Printf("No GitHubDeployment for %d found\n", ev.Deployment.Id)
-			return
+			deploymentLogger(ev.Deployment).Error("no GitHub deployment found")
+			metrics.NotifierFailed("github")
+			return fmt.Errorf("no GitHub deployment found")
 		}
 
 		status := notifier.NewStatus(ev)
 		err := ghClient.CreateDeploymentStatus(githubDeployment.StatusesURL, status)
 		if err != nil {
-			log.Printf("Creating GitHub deployment status failed: %s\n", err)
-			return
+			deploymentLogger(ev.Deployment).Error("creating GitHub deployment status failed", "err", err)
+			metrics.NotifierFailed("github")
+			return err
 		}
 	}
+	return nil
 }
 
 func (notifier *GitHubNotifier) NewStatus(ev *DeploymentEvent) *GitHubDeploymentStatus {
@@ -64,3 +111,63 @@ func (notifier *GitHubNotifier) NewStatus(ev *DeploymentEvent) *GitHubDeployment
 
 	return deploymentStatus
 }
+
+func (notifier *GitHubNotifier) notifyCheckRun(ghClient *GitHubClient, ev *DeploymentEvent) error {
+	run := notifier.NewCheckRun(ev)
+
+	if ev.State == models.DEPLOYMENT_NEW {
+		created, err := ghClient.CreateCheckRun(ev.Application, run)
+		if err != nil {
+			deploymentLogger(ev.Deployment).Error("creating GitHub check run failed", "err", err)
+			metrics.NotifierFailed("github")
+			return err
+		}
+		notifier.checkRuns[ev.Deployment.Id] = created.Id
+		return nil
+	}
+
+	id, ok := notifier.checkRuns[ev.Deployment.Id]
+	if !ok {
+		deploymentLogger(ev.Deployment).Error("no GitHub check run found")
+		metrics.NotifierFailed("github")
+		return fmt.Errorf("no GitHub check run found")
+	}
+	if err := ghClient.UpdateCheckRun(ev.Application, id, run); err != nil {
+		deploymentLogger(ev.Deployment).Error("updating GitHub check run failed", "err", err)
+		metrics.NotifierFailed("github")
+		return err
+	}
+	if run.Status == "completed" {
+		delete(notifier.checkRuns, ev.Deployment.Id)
+	}
+	return nil
+}
+
+// NewCheckRun returns the check run of the deployment in its current state,
+// named after the target, e.g. "Applikatoni: production".
+func (notifier *GitHubNotifier) NewCheckRun(ev *DeploymentEvent) *GitHubCheckRun {
+	run := &GitHubCheckRun{DetailsURL: ev.DeploymentURL()}
+
+	switch ev.State {
+	case models.DEPLOYMENT_NEW:
+		run.Name = "Applikatoni: " + ev.Deployment.TargetName
+		run.HeadSha = ev.Deployment.CommitSha
+		run.ExternalId = strconv.Itoa(ev.Deployment.Id)
+		run.Status = "queued"
+	case models.DEPLOYMENT_ACTIVE:
+		run.Status = "in_progress"
+	case models.DEPLOYMENT_SUCCESSFUL, models.DEPLOYMENT_FAILED:
+		now := time.Now()
+		run.Status = "completed"
+		run.CompletedAt = &now
+		run.Conclusion = "success"
+		title := fmt.Sprintf("Deployed to %s", ev.Deployment.TargetName)
+		if ev.State == models.DEPLOYMENT_FAILED {
+			run.Conclusion = "failure"
+			title = fmt.Sprintf("Deployment to %s failed", ev.Deployment.TargetName)
+		}
+		run.Output = &GitHubCheckRunOutput{Title: title, Summary: ev.Deployment.Comment}
+	}
+
+	return run
+}
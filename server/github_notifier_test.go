@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestNewCheckRun(t *testing.T) {
+	setConfig(&Configuration{Host: "applikatoni.example.com"})
+	defer func() { setConfig(&Configuration{}) }()
+
+	ev := &DeploymentEvent{
+		State:       models.DEPLOYMENT_NEW,
+		Deployment:  &models.Deployment{Id: 42, TargetName: "production", CommitSha: "f133742", Comment: "Fix the login"},
+		Application: &models.Application{GitHubRepo: "web-app"},
+	}
+	notifier := NewGitHubNotifier()
+
+	run := notifier.NewCheckRun(ev)
+	if run.Name != "Applikatoni: production" || run.HeadSha != "f133742" || run.ExternalId != "42" || run.Status != "queued" {
+		t.Errorf("wrong new check run. got=%+v", run)
+	}
+	if run.DetailsURL != "http://applikatoni.example.com/web-app/deployments/42" {
+		t.Errorf("wrong details URL. got=%s", run.DetailsURL)
+	}
+
+	ev.State = models.DEPLOYMENT_ACTIVE
+	if run := notifier.NewCheckRun(ev); run.Status != "in_progress" || run.Name != "" {
+		t.Errorf("wrong active check run. got=%+v", run)
+	}
+
+	tests := []struct {
+		state              models.DeploymentState
+		expectedConclusion string
+		expectedTitle      string
+	}{
+		{models.DEPLOYMENT_SUCCESSFUL, "success", "Deployed to production"},
+		{models.DEPLOYMENT_FAILED, "failure", "Deployment to production failed"},
+	}
+
+	for _, tt := range tests {
+		ev.State = tt.state
+		run := notifier.NewCheckRun(ev)
+		if run.Status != "completed" || run.CompletedAt == nil || run.Conclusion != tt.expectedConclusion {
+			t.Errorf("wrong %s check run. got=%+v", tt.state, run)
+		}
+		if run.Output == nil || run.Output.Title != tt.expectedTitle || run.Output.Summary != "Fix the login" {
+			t.Errorf("wrong output of %s check run. got=%+v", tt.state, run.Output)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+type gitHubPushEvent struct {
+	Ref     string `json:"ref"`
+	After   string `json:"after"`
+	Deleted bool   `json:"deleted"`
+	Pusher  struct {
+		Name string `json:"name"`
+	} `json:"pusher"`
+	HeadCommit struct {
+		Message string `json:"message"`
+	} `json:"head_commit"`
+	Commits []struct {
+		Added    []string `json:"added"`
+		Removed  []string `json:"removed"`
+		Modified []string `json:"modified"`
+	} `json:"commits"`
+}
+
+type gitHubCheckSuiteEvent struct {
+	Action     string `json:"action"`
+	CheckSuite struct {
+		HeadBranch string `json:"head_branch"`
+		HeadSha    string `json:"head_sha"`
+		Conclusion string `json:"conclusion"`
+		App        struct {
+			Slug string `json:"slug"`
+		} `json:"app"`
+		HeadCommit struct {
+			Message string `json:"message"`
+		} `json:"head_commit"`
+	} `json:"check_suite"`
+	Sender struct {
+		Login string `json:"login"`
+	} `json:"sender"`
+}
+
+// autoDeployCommit is a commit a webhook deploys automatically.
+type autoDeployCommit struct {
+	Branch     string
+	CommitSha  string
+	Message    string
+	OnBehalfOf string
+	// The webhook, shown as the name of the token the deployment was made
+	// with, e.g. "GitHub webhook"
+	Source string
+}
+
+// changedFiles returns the files added, removed or modified by the commits
+// of the push.
+func (p *gitHubPushEvent) changedFiles() []string {
+	files := []string{}
+	for _, c := range p.Commits {
+		files = append(files, c.Added...)
+		files = append(files, c.Removed...)
+		files = append(files, c.Modified...)
+	}
+	return files
+}
+
+// gitHubWebhookHandler receives the push and check suite events of the
+// repository of an application and deploys the pushed commit, or the commit
+// the check suite succeeded on, to the target configured for the branch in
+// its auto_deploy settings.
+func gitHubWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	application, err := findApplication(mux.Vars(r)["application"])
+	if err != nil || application.AutoDeploy == nil || !application.AutoDeploy.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isValidWebhookSignature(application.AutoDeploy.WebhookSecret, r.Header.Get("X-Hub-Signature-256"), body) {
+		requestLogger(r).Warn("GitHub webhook with invalid signature", "application", application.Name, "remote_addr", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	event := r.Header.Get("X-GitHub-Event")
+	if event == "push" {
+		// The pushed branch has a new head
+		scmLookupCache.Invalidate(application)
+	}
+
+	switch {
+	case event == "ping":
+		fmt.Fprintln(w, "pong")
+		return
+	case event == "push" && !application.AutoDeploy.OnCISuccess:
+	case event == "check_suite" && application.AutoDeploy.OnCISuccess:
+		gitHubCheckSuiteDeploy(w, r, application, body)
+		return
+	default:
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "ignoring event")
+		return
+	}
+
+	push := &gitHubPushEvent{}
+	err = json.Unmarshal(body, push)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	branch := strings.TrimPrefix(push.Ref, "refs/heads/")
+	targetName := application.AutoDeploy.TargetName(branch)
+	if push.Deleted || targetName == "" || branch == push.Ref {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying %s\n", push.Ref)
+		return
+	}
+
+	if !application.AutoDeploy.MatchesPaths(push.changedFiles()) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying %s, no changes in %v\n", push.Ref, application.AutoDeploy.Paths)
+		return
+	}
+
+	renderAutoDeploy(w, r, application, targetName, &autoDeployCommit{
+		Branch:     branch,
+		CommitSha:  push.After,
+		Message:    push.HeadCommit.Message,
+		OnBehalfOf: push.Pusher.Name,
+		Source:     "GitHub webhook",
+	})
+}
+
+// gitHubCheckSuiteDeploy deploys the head commit of a check suite that
+// completed successfully.
+func gitHubCheckSuiteDeploy(w http.ResponseWriter, r *http.Request, a *models.Application, body []byte) {
+	event := &gitHubCheckSuiteEvent{}
+	if err := json.Unmarshal(body, event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	suite := event.CheckSuite
+	if event.Action != "completed" || suite.Conclusion != "success" {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying, check suite %s %s\n", event.Action, suite.Conclusion)
+		return
+	}
+
+	deployOnCISuccess(w, r, a, suite.App.Slug, &autoDeployCommit{
+		Branch:     suite.HeadBranch,
+		CommitSha:  suite.HeadSha,
+		Message:    suite.HeadCommit.Message,
+		OnBehalfOf: event.Sender.Login,
+		Source:     "GitHub webhook",
+	})
+}
+
+// deployOnCISuccess deploys the commit a pipeline succeeded on, unless the
+// pipeline isn't watched or the commit has already been deployed to the
+// target, e.g. after another pipeline succeeded on it.
+func deployOnCISuccess(w http.ResponseWriter, r *http.Request, a *models.Application, pipeline string, c *autoDeployCommit) {
+	targetName := a.AutoDeploy.TargetName(c.Branch)
+	if targetName == "" {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying %s\n", c.Branch)
+		return
+	}
+	if !a.AutoDeploy.WatchesPipeline(pipeline) {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying, pipeline %q is not in %v\n", pipeline, a.AutoDeploy.Pipelines)
+		return
+	}
+
+	latest, err := getLatestTargetDeployment(db, a, targetName)
+	if err != nil {
+		requestLogger(r).Error("loading the latest deployment failed", "application", a.Name, "target", targetName, "err", err)
+		http.Error(w, "could not load the latest deployment", http.StatusInternalServerError)
+		return
+	}
+	if latest != nil && latest.CommitSha == c.CommitSha && latest.State != models.DEPLOYMENT_FAILED {
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "not deploying, %s has already been deployed to %s\n", c.CommitSha, targetName)
+		return
+	}
+
+	renderAutoDeploy(w, r, a, targetName, c)
+}
+
+// renderAutoDeploy deploys the commit and responds with the URL of the
+// deployment.
+func renderAutoDeploy(w http.ResponseWriter, r *http.Request, a *models.Application, targetName string, c *autoDeployCommit) {
+	deployment, err := autoDeploy(a, targetName, c, r)
+	if err != nil {
+		requestLogger(r).Error("auto-deploying failed", "branch", c.Branch, "application", a.Name, "target", targetName, "err", err)
+		status := 422
+		if err == errShuttingDown {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	fmt.Fprintln(w, getConfig().URL(deploymentUrl(a, deployment)))
+}
+
+func autoDeploy(a *models.Application, targetName string, c *autoDeployCommit, r *http.Request) (*models.Deployment, error) {
+	target, err := findTarget(a, targetName)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := getUserByProvider(db, SERVICE_ACCOUNT_PROVIDER, a.AutoDeploy.ServiceAccount)
+	if err != nil {
+		return nil, fmt.Errorf("loading service account %s failed: %s", a.AutoDeploy.ServiceAccount, err)
+	}
+	if !loadServiceAccount(user) || !a.CanDeploy(target, user) {
+		return nil, fmt.Errorf("service account %s can't deploy to %s", a.AutoDeploy.ServiceAccount, target.Name)
+	}
+	if reqErr := checkDeployFreeze(a, target, user, false); reqErr != nil {
+		return nil, errors.New(reqErr.Message)
+	}
+	if reqErr := checkDeployWindow(target, user, "", time.Now()); reqErr != nil {
+		return nil, errors.New(reqErr.Message)
+	}
+
+	if !isValidCommitSha(c.CommitSha) {
+		return nil, errors.New("invalid commit sha")
+	}
+	if !target.IsDeployableBranch(c.Branch) {
+		return nil, fmt.Errorf("branch %q can't be deployed to %s", c.Branch, target.Name)
+	}
+	if _, err := checkCIStatus(a, target, user, c.CommitSha, ""); err != nil {
+		return nil, err
+	}
+
+	comment := fmt.Sprintf("Automatic deployment of %s", c.Branch)
+	if message := strings.SplitN(c.Message, "\n", 2)[0]; message != "" {
+		comment += ": " + message
+	}
+
+	sourceIP := remoteIP(r)
+
+	deployment := &models.Deployment{
+		UserId:          user.Id,
+		CommitSha:       c.CommitSha,
+		Branch:          c.Branch,
+		Comment:         comment,
+		ApplicationName: a.Name,
+		TargetName:      target.Name,
+		RequestId:       r.Header.Get(requestIdHeader),
+		Initiator: &models.DeploymentInitiator{
+			TokenName:  c.Source + " (" + user.Name + ")",
+			SourceIP:   sourceIP,
+			OnBehalfOf: c.OnBehalfOf,
+		},
+	}
+
+	deployment.Changelog, err = buildChangelog(a, target, user, c.CommitSha)
+	if err != nil {
+		requestLogger(r).Warn("could not build changelog", "commit_sha", c.CommitSha, "target", target.Name, "err", err)
+	}
+
+	err = startDeployment(a, target, deployment, target.DefaultStages)
+	if err != nil {
+		return nil, err
+	}
+	recordAuditEvent(r, user, models.AUDIT_DEPLOYMENT_CREATE, deploymentAuditSubject(deployment))
+
+	return deployment, nil
+}
+
+// isValidWebhookSignature checks the HMAC-SHA256 signature GitHub sends in
+// the X-Hub-Signature-256 header.
+func isValidWebhookSignature(secret, signature string, body []byte) bool {
+	if secret == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+
+	sent, err := hex.DecodeString(strings.TrimPrefix(signature, "sha256="))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(sent, mac.Sum(nil))
+}
+
+func validateAutoDeploy(c *Configuration, a *models.Application) error {
+	ad := a.AutoDeploy
+	if ad == nil || !ad.Enabled {
+		return nil
+	}
+
+	if a.SCMName() != models.SCM_GITHUB && !ad.OnCISuccess {
+		return errors.New("deploying pushes is only supported on GitHub")
+	}
+	if ad.OnCISuccess && len(ad.Paths) > 0 {
+		return errors.New("paths are not supported with on_ci_success")
+	}
+	if ad.WebhookSecret == "" {
+		return errors.New("webhook_secret is required")
+	}
+
+	var account *models.ServiceAccount
+	for _, s := range c.ServiceAccounts {
+		if s.Name == ad.ServiceAccount {
+			account = s
+		}
+	}
+	if account == nil {
+		return fmt.Errorf("unknown service_account %q", ad.ServiceAccount)
+	}
+
+	for branch, targetName := range ad.Branches {
+		if _, err := findTarget(a, targetName); err != nil {
+			return fmt.Errorf("unknown target %s for branch %s", targetName, branch)
+		}
+		if !account.CanDeploy(a.Name, targetName) {
+			return fmt.Errorf("service account %s can't deploy to %s", account.Name, targetName)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func signWebhookBody(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestIsValidWebhookSignature(t *testing.T) {
+	body := []byte(`{"ref": "refs/heads/master"}`)
+
+	tests := []struct {
+		secret    string
+		signature string
+		expected  bool
+	}{
+		{"s3cr3t", signWebhookBody("s3cr3t", string(body)), true},
+		{"s3cr3t", signWebhookBody("wrong", string(body)), false},
+		{"s3cr3t", strings.TrimPrefix(signWebhookBody("s3cr3t", string(body)), "sha256="), false},
+		{"s3cr3t", "sha256=nothex", false},
+		{"s3cr3t", "", false},
+		{"", signWebhookBody("", string(body)), false},
+	}
+
+	for _, tt := range tests {
+		if got := isValidWebhookSignature(tt.secret, tt.signature, body); got != tt.expected {
+			t.Errorf("wrong result for %q. want=%t, got=%t", tt.signature, tt.expected, got)
+		}
+	}
+}
+
+func TestGitHubWebhookHandler(t *testing.T) {
+	setConfig(&Configuration{Applications: []*models.Application{
+		{
+			Name:    "web",
+			Targets: []*models.Target{{Name: "staging"}},
+			AutoDeploy: &models.AutoDeploy{
+				Enabled:        true,
+				WebhookSecret:  "s3cr3t",
+				ServiceAccount: "github",
+				Branches:       map[string]string{"master": "staging"},
+			},
+		},
+		{Name: "api"},
+		{
+			Name:    "monorepo-api",
+			Targets: []*models.Target{{Name: "staging"}},
+			AutoDeploy: &models.AutoDeploy{
+				Enabled:        true,
+				WebhookSecret:  "s3cr3t",
+				ServiceAccount: "github",
+				Branches:       map[string]string{"master": "staging"},
+				Paths:          []string{"services/api/"},
+			},
+		},
+		{
+			Name:    "web-ci",
+			Targets: []*models.Target{{Name: "staging"}},
+			AutoDeploy: &models.AutoDeploy{
+				Enabled:        true,
+				WebhookSecret:  "s3cr3t",
+				ServiceAccount: "github",
+				Branches:       map[string]string{"master": "staging"},
+				OnCISuccess:    true,
+				Pipelines:      []string{"github-actions"},
+			},
+		},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	webPush := `{"ref": "refs/heads/master", "after": "f00b4rf00b4rf00b4rf00b4rf00b4rf00b4rf00b", "commits": [{"added": ["services/web/index.html"], "modified": ["README.md"]}]}`
+	suiteFailed := `{"action": "completed", "check_suite": {"head_branch": "master", "conclusion": "failure", "app": {"slug": "github-actions"}}}`
+	suiteOnFeature := `{"action": "completed", "check_suite": {"head_branch": "feature", "conclusion": "success", "app": {"slug": "github-actions"}}}`
+	suiteOfOtherApp := `{"action": "completed", "check_suite": {"head_branch": "master", "conclusion": "success", "app": {"slug": "circleci-checks"}}}`
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{application}/webhooks/github", gitHubWebhookHandler)
+
+	tests := []struct {
+		application    string
+		event          string
+		body           string
+		signature      string
+		expectedStatus int
+	}{
+		{"web", "ping", `{}`, signWebhookBody("s3cr3t", `{}`), 200},
+		{"web", "ping", `{}`, signWebhookBody("wrong", `{}`), 403},
+		{"web", "issues", `{}`, signWebhookBody("s3cr3t", `{}`), 202},
+		{"web", "push", `{"ref": "refs/heads/feature"}`, signWebhookBody("s3cr3t", `{"ref": "refs/heads/feature"}`), 202},
+		{"web", "push", `{"ref": "refs/tags/master"}`, signWebhookBody("s3cr3t", `{"ref": "refs/tags/master"}`), 202},
+		{"web", "push", `{"ref": "refs/heads/master", "deleted": true}`, signWebhookBody("s3cr3t", `{"ref": "refs/heads/master", "deleted": true}`), 202},
+		{"api", "ping", `{}`, signWebhookBody("s3cr3t", `{}`), 404},
+		{"monorepo-api", "push", webPush, signWebhookBody("s3cr3t", webPush), 202},
+		{"web", "check_suite", suiteOfOtherApp, signWebhookBody("s3cr3t", suiteOfOtherApp), 202},
+		{"web-ci", "push", webPush, signWebhookBody("s3cr3t", webPush), 202},
+		{"web-ci", "check_suite", suiteFailed, signWebhookBody("s3cr3t", suiteFailed), 202},
+		{"web-ci", "check_suite", suiteOnFeature, signWebhookBody("s3cr3t", suiteOnFeature), 202},
+		{"web-ci", "check_suite", suiteOfOtherApp, signWebhookBody("s3cr3t", suiteOfOtherApp), 202},
+		{"unknown", "ping", `{}`, signWebhookBody("s3cr3t", `{}`), 404},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("POST", "/"+tt.application+"/webhooks/github", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-GitHub-Event", tt.event)
+		req.Header.Set("X-Hub-Signature-256", tt.signature)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s event %s. want=%d, got=%d", tt.application, tt.body, tt.expectedStatus, rec.Code)
+		}
+	}
+}
+
+func TestValidateAutoDeploy(t *testing.T) {
+	c := &Configuration{ServiceAccounts: []*models.ServiceAccount{
+		{Name: "github", Targets: map[string][]string{"web": {"staging"}}},
+	}}
+
+	tests := []struct {
+		application *models.Application
+		expectedErr bool
+	}{
+		{&models.Application{Name: "web"}, false},
+		{&models.Application{Name: "web", AutoDeploy: &models.AutoDeploy{Enabled: false}}, false},
+		{&models.Application{Name: "web", SCM: models.SCM_GITLAB, AutoDeploy: &models.AutoDeploy{Enabled: true, WebhookSecret: "s3cr3t", ServiceAccount: "github"}}, true},
+		{&models.Application{Name: "web", SCM: models.SCM_GITLAB, AutoDeploy: &models.AutoDeploy{Enabled: true, WebhookSecret: "s3cr3t", ServiceAccount: "github", OnCISuccess: true}}, false},
+		{&models.Application{Name: "web", AutoDeploy: &models.AutoDeploy{Enabled: true, WebhookSecret: "s3cr3t", ServiceAccount: "github", OnCISuccess: true, Paths: []string{"services/api/"}}}, true},
+		{&models.Application{Name: "web", AutoDeploy: &models.AutoDeploy{Enabled: true, ServiceAccount: "github"}}, true},
+		{&models.Application{Name: "web", AutoDeploy: &models.AutoDeploy{Enabled: true, WebhookSecret: "s3cr3t", ServiceAccount: "travis"}}, true},
+		{
+			&models.Application{
+				Name:       "web",
+				Targets:    []*models.Target{{Name: "staging"}, {Name: "production"}},
+				AutoDeploy: &models.AutoDeploy{Enabled: true, WebhookSecret: "s3cr3t", ServiceAccount: "github", Branches: map[string]string{"master": "staging"}},
+			},
+			false,
+		},
+		{
+			&models.Application{
+				Name:       "web",
+				Targets:    []*models.Target{{Name: "staging"}, {Name: "production"}},
+				AutoDeploy: &models.AutoDeploy{Enabled: true, WebhookSecret: "s3cr3t", ServiceAccount: "github", Branches: map[string]string{"master": "production"}},
+			},
+			true,
+		},
+		{
+			&models.Application{
+				Name:       "web",
+				Targets:    []*models.Target{{Name: "staging"}},
+				AutoDeploy: &models.AutoDeploy{Enabled: true, WebhookSecret: "s3cr3t", ServiceAccount: "github", Branches: map[string]string{"master": "qa"}},
+			},
+			true,
+		},
+	}
+
+	for i, tt := range tests {
+		err := validateAutoDeploy(c, tt.application)
+		if tt.expectedErr && err == nil {
+			t.Errorf("expected error for case %d", i)
+		}
+		if !tt.expectedErr && err != nil {
+			t.Errorf("unexpected error for case %d: %s", i, err)
+		}
+	}
+}
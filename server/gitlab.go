@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"golang.org/x/oauth2"
+)
+
+// ErrGitLabUnauthorized is returned if GitLab rejects the access token.
+var ErrGitLabUnauthorized = errors.New("GitLab rejected the access token")
+
+type gitLabCommit struct {
+	Id            string    `json:"id"`
+	Message       string    `json:"message"`
+	AuthorName    string    `json:"author_name"`
+	CommittedDate time.Time `json:"committed_date"`
+	WebURL        string    `json:"web_url"`
+}
+
+type gitLabMergeRequest struct {
+	Id           int64     `json:"id"`
+	Iid          int       `json:"iid"`
+	Title        string    `json:"title"`
+	WebURL       string    `json:"web_url"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	SourceBranch string    `json:"source_branch"`
+	Sha          string    `json:"sha"`
+	Author       struct {
+		Username  string `json:"username"`
+		AvatarUrl string `json:"avatar_url"`
+	} `json:"author"`
+}
+
+type gitLabTag struct {
+	Name   string       `json:"name"`
+	Commit gitLabCommit `json:"commit"`
+}
+
+// GitLabClient accesses the repositories of a GitLab instance. Projects are
+// identified by "github_owner/github_repo", the namespace and the project.
+type GitLabClient struct {
+	*http.Client
+	apiURL string
+}
+
+func NewGitLabClient(baseURL, accessToken string) *GitLabClient {
+	token := &oauth2.Token{AccessToken: accessToken}
+	client := oauth2.NewClient(oauth2.NoContext, oauth2.StaticTokenSource(token))
+
+	return &GitLabClient{Client: client, apiURL: baseURL + "/api/v4"}
+}
+
+func (gc *GitLabClient) projectURL(a *models.Application) string {
+	project := url.PathEscape(a.GitHubOwner + "/" + a.GitHubRepo)
+	return fmt.Sprintf("%s/projects/%s", gc.apiURL, project)
+}
+
+// GetPullRequests returns the open merge requests of the project.
+func (gc *GitLabClient) GetPullRequests(a *models.Application) ([]PullRequest, error) {
+	mergeRequests := []gitLabMergeRequest{}
+
+	url := gc.projectURL(a) + "/merge_requests?state=opened"
+	err := gc.GetDecode(url, &mergeRequests)
+	if err != nil {
+		return nil, err
+	}
+
+	pulls := []PullRequest{}
+	for _, mr := range mergeRequests {
+		pulls = append(pulls, mr.toPullRequest())
+	}
+
+	err = setPullRequestsTravisImages(a, pulls)
+	if err != nil {
+		return nil, err
+	}
+
+	return pulls, nil
+}
+
+// GetPullRequest returns the merge request with the project-internal ID.
+func (gc *GitLabClient) GetPullRequest(a *models.Application, number int) (*PullRequest, error) {
+	mr := gitLabMergeRequest{}
+
+	url := fmt.Sprintf("%s/merge_requests/%d", gc.projectURL(a), number)
+	err := gc.GetDecode(url, &mr)
+	if err != nil {
+		return nil, err
+	}
+
+	pull := mr.toPullRequest()
+	return &pull, nil
+}
+
+// CommentOnPullRequest adds a note to the merge request. This requires a
+// token with the "api" scope.
+func (gc *GitLabClient) CommentOnPullRequest(a *models.Application, number int, body string) error {
+	url := fmt.Sprintf("%s/merge_requests/%d/notes", gc.projectURL(a), number)
+
+	return gc.PostJSON(url, map[string]string{"body": body})
+}
+
+// GetTags returns the most recently updated tags of the project.
+func (gc *GitLabClient) GetTags(a *models.Application) ([]Tag, error) {
+	gitLabTags := []gitLabTag{}
+
+	err := gc.GetDecode(gc.projectURL(a)+"/repository/tags?order_by=updated&per_page=30", &gitLabTags)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []Tag{}
+	for _, t := range gitLabTags {
+		tags = append(tags, t.toTag())
+	}
+
+	return tags, nil
+}
+
+func (gc *GitLabClient) GetTag(a *models.Application, name string) (*Tag, error) {
+	gitLabTag := gitLabTag{}
+
+	endpoint := fmt.Sprintf("%s/repository/tags/%s", gc.projectURL(a), url.PathEscape(name))
+	err := gc.GetDecode(endpoint, &gitLabTag)
+	if err != nil {
+		return nil, err
+	}
+
+	tag := gitLabTag.toTag()
+	return &tag, nil
+}
+
+func (gc *GitLabClient) GetBranches(a *models.Application) ([]Branch, error) {
+	branches := []Branch{}
+
+	for _, branchName := range a.GitHubBranches {
+		branch, err := gc.GetBranch(a, branchName)
+		if err != nil {
+			return nil, err
+		}
+
+		branches = append(branches, *branch)
+	}
+
+	err := setBranchesTravisImages(a, branches)
+	if err != nil {
+		return nil, err
+	}
+
+	return branches, nil
+}
+
+func (gc *GitLabClient) GetBranch(a *models.Application, name string) (*Branch, error) {
+	gitLabBranch := struct {
+		Name   string       `json:"name"`
+		Commit gitLabCommit `json:"commit"`
+	}{}
+
+	url := fmt.Sprintf("%s/repository/branches/%s", gc.projectURL(a), url.PathEscape(name))
+	err := gc.GetDecode(url, &gitLabBranch)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Branch{
+		Name:          gitLabBranch.Name,
+		CurrentCommit: gitLabBranch.Commit.toCommit(),
+	}, nil
+}
+
+func (gc *GitLabClient) Compare(a *models.Application, oldSha, newSha string) (*Diff, error) {
+	comparison := struct {
+		Commits []gitLabCommit `json:"commits"`
+	}{}
+
+	query := url.Values{"from": {oldSha}, "to": {newSha}}
+	url := fmt.Sprintf("%s/repository/compare?%s", gc.projectURL(a), query.Encode())
+	err := gc.GetDecode(url, &comparison)
+	if err != nil {
+		return nil, err
+	}
+
+	// GitLab only returns the commits newSha is ahead of oldSha
+	diff := &Diff{
+		CompareURL: fmt.Sprintf("%s/-/compare/%s...%s", repositoryWebURL(a), oldSha, newSha),
+		Status:     "identical",
+		AheadBy:    len(comparison.Commits),
+		Commits:    []Commit{},
+	}
+	if diff.AheadBy > 0 {
+		diff.Status = "ahead"
+	}
+
+	for _, c := range comparison.Commits {
+		diff.Commits = append(diff.Commits, c.toCommit())
+	}
+
+	return diff, nil
+}
+
+func (gc *GitLabClient) GetDecode(url string, v interface{}) error {
+	res, err := gc.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrGitLabUnauthorized
+	}
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("GitLab responded with %d instead of 200", res.StatusCode)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+func (gc *GitLabClient) PostJSON(url string, v interface{}) error {
+	jsonPayload, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	res, err := gc.Post(url, "application/json", bytes.NewBuffer(jsonPayload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 401 {
+		return ErrGitLabUnauthorized
+	}
+
+	if res.StatusCode != 201 {
+		return fmt.Errorf("GitLab responded with %d instead of 201", res.StatusCode)
+	}
+
+	return nil
+}
+
+func (mr gitLabMergeRequest) toPullRequest() PullRequest {
+	pull := PullRequest{
+		Id:        mr.Id,
+		Number:    mr.Iid,
+		Url:       mr.WebURL,
+		Title:     mr.Title,
+		User:      &models.User{Name: mr.Author.Username, AvatarUrl: mr.Author.AvatarUrl},
+		CreatedAt: mr.CreatedAt,
+		UpdatedAt: mr.UpdatedAt,
+	}
+	pull.Head.Branch = mr.SourceBranch
+	pull.Head.CommitSha = mr.Sha
+
+	return pull
+}
+
+func (t gitLabTag) toTag() Tag {
+	tag := Tag{Name: t.Name}
+	tag.Commit.Sha = t.Commit.Id
+	return tag
+}
+
+func (c gitLabCommit) toCommit() Commit {
+	commit := Commit{
+		Author:  &models.User{Name: c.AuthorName},
+		Sha:     c.Id,
+		HtmlURL: c.WebURL,
+	}
+	commit.Commit.Message = strings.TrimRight(c.Message, "\n")
+	commit.Commit.Committer.ComittedAt = c.CommittedDate
+
+	return commit
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestGitLabClient(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			t.Errorf("wrong Authorization header. got=%s", r.Header.Get("Authorization"))
+		}
+
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/shipping-co%2Fweb-app/merge_requests":
+			fmt.Fprintln(w, `[{"id": 7, "title": "Add pizza", "web_url": "http://example.com/mr/7", "source_branch": "pizza", "sha": "f00b4r", "author": {"username": "mrnugget"}}]`)
+		case "/api/v4/projects/shipping-co%2Fweb-app/repository/branches/feature%2Fpizza":
+			fmt.Fprintln(w, `{"name": "feature/pizza", "commit": {"id": "f00b4r", "message": "Add pizza\n", "author_name": "mrnugget", "committed_date": "2016-10-16T10:00:00Z"}}`)
+		case "/api/v4/projects/shipping-co%2Fweb-app/repository/tags":
+			fmt.Fprintln(w, `[{"name": "v1.1.0", "commit": {"id": "f00b4r"}}, {"name": "v1.0.0", "commit": {"id": "0ld"}}]`)
+		case "/api/v4/projects/shipping-co%2Fweb-app/repository/tags/v1.0.0":
+			fmt.Fprintln(w, `{"name": "v1.0.0", "commit": {"id": "0ld"}}`)
+		case "/api/v4/projects/shipping-co%2Fweb-app/repository/compare":
+			if r.URL.Query().Get("from") != "0ld" || r.URL.Query().Get("to") != "f00b4r" {
+				t.Errorf("wrong compare query. got=%s", r.URL.RawQuery)
+			}
+			fmt.Fprintln(w, `{"commits": [{"id": "f00b4r", "message": "Add pizza", "author_name": "mrnugget"}]}`)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.EscapedPath())
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	setConfig(&Configuration{GitLabURL: ts.URL})
+	defer func() { setConfig(&Configuration{}) }()
+
+	a := &models.Application{
+		SCM:            models.SCM_GITLAB,
+		GitHubOwner:    "shipping-co",
+		GitHubRepo:     "web-app",
+		GitHubBranches: []string{"feature/pizza"},
+	}
+	client := NewGitLabClient(ts.URL, "t0k3n")
+
+	pulls, err := client.GetPullRequests(a)
+	if err != nil {
+		t.Fatalf("loading merge requests failed: %s", err)
+	}
+	if len(pulls) != 1 || pulls[0].Head.Branch != "pizza" || pulls[0].Head.CommitSha != "f00b4r" {
+		t.Errorf("wrong merge requests. got=%+v", pulls)
+	}
+	if pulls[0].User.Name != "mrnugget" {
+		t.Errorf("wrong author. want=%s, got=%s", "mrnugget", pulls[0].User.Name)
+	}
+
+	branches, err := client.GetBranches(a)
+	if err != nil {
+		t.Fatalf("loading branches failed: %s", err)
+	}
+	if len(branches) != 1 || branches[0].CurrentCommit.Sha != "f00b4r" {
+		t.Errorf("wrong branches. got=%+v", branches)
+	}
+	if branches[0].CurrentCommit.Commit.Message != "Add pizza" {
+		t.Errorf("wrong commit message. want=%q, got=%q", "Add pizza", branches[0].CurrentCommit.Commit.Message)
+	}
+
+	branch, err := client.GetBranch(a, "feature/pizza")
+	if err != nil {
+		t.Fatalf("loading branch failed: %s", err)
+	}
+	if branch.Name != "feature/pizza" || branch.CurrentCommit.Sha != "f00b4r" {
+		t.Errorf("wrong branch. got=%+v", branch)
+	}
+
+	tags, err := client.GetTags(a)
+	if err != nil {
+		t.Fatalf("loading tags failed: %s", err)
+	}
+	if len(tags) != 2 || tags[0].Name != "v1.1.0" || tags[0].Commit.Sha != "f00b4r" {
+		t.Errorf("wrong tags. got=%+v", tags)
+	}
+
+	tag, err := client.GetTag(a, "v1.0.0")
+	if err != nil {
+		t.Fatalf("loading tag failed: %s", err)
+	}
+	if tag.Commit.Sha != "0ld" {
+		t.Errorf("wrong tag commit. want=%s, got=%s", "0ld", tag.Commit.Sha)
+	}
+
+	diff, err := client.Compare(a, "0ld", "f00b4r")
+	if err != nil {
+		t.Fatalf("comparing failed: %s", err)
+	}
+	if diff.AheadBy != 1 || diff.Status != "ahead" {
+		t.Errorf("wrong diff. got=%+v", diff)
+	}
+	expected := ts.URL + "/shipping-co/web-app/-/compare/0ld...f00b4r"
+	if diff.CompareURL != expected {
+		t.Errorf("wrong compare URL. want=%s, got=%s", expected, diff.CompareURL)
+	}
+}
+
+func TestGitLabClientUnauthorized(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(401)
+	}))
+	defer ts.Close()
+
+	a := &models.Application{GitHubOwner: "shipping-co", GitHubRepo: "web-app"}
+	_, err := NewGitLabClient(ts.URL, "t0k3n").GetPullRequests(a)
+	if err != ErrGitLabUnauthorized {
+		t.Errorf("wrong error. want=%s, got=%v", ErrGitLabUnauthorized, err)
+	}
+}
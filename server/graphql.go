@@ -0,0 +1,822 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A small GraphQL implementation for the read-only /api/graphql endpoint. It
+// supports queries with nested selections, aliases, arguments and variables.
+// Mutations, fragments, directives and introspection are not supported.
+
+type gqlTokenKind int
+
+const (
+	gqlEOF gqlTokenKind = iota
+	gqlPunctuator
+	gqlName
+	gqlInt
+	gqlFloat
+	gqlString
+)
+
+type gqlToken struct {
+	kind  gqlTokenKind
+	value string
+	pos   int
+}
+
+type gqlLexer struct {
+	src string
+	pos int
+}
+
+func (l *gqlLexer) next() (gqlToken, error) {
+	l.skipIgnored()
+
+	if l.pos >= len(l.src) {
+		return gqlToken{kind: gqlEOF, pos: l.pos}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		l.pos += 3
+		return gqlToken{gqlPunctuator, "...", start}, nil
+	case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+		l.pos++
+		return gqlToken{gqlPunctuator, string(c), start}, nil
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return gqlToken{gqlName, l.src[start:l.pos], start}, nil
+	case c == '-' || isDigit(c):
+		return l.readNumber()
+	case c == '"':
+		return l.readString()
+	}
+
+	return gqlToken{}, fmt.Errorf("syntax error: unexpected character %q at position %d", c, start)
+}
+
+// skipIgnored skips whitespace, commas and comments.
+func (l *gqlLexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case ' ', '\t', '\n', '\r', ',':
+			l.pos++
+		case '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			if strings.HasPrefix(l.src[l.pos:], "\ufeff") {
+				l.pos += len("\ufeff")
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (l *gqlLexer) readNumber() (gqlToken, error) {
+	start := l.pos
+	kind := gqlInt
+
+	if l.src[l.pos] == '-' {
+		l.pos++
+	}
+	if !l.readDigits() {
+		return gqlToken{}, fmt.Errorf("syntax error: invalid number at position %d", start)
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		kind = gqlFloat
+		l.pos++
+		if !l.readDigits() {
+			return gqlToken{}, fmt.Errorf("syntax error: invalid number at position %d", start)
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		kind = gqlFloat
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		if !l.readDigits() {
+			return gqlToken{}, fmt.Errorf("syntax error: invalid number at position %d", start)
+		}
+	}
+
+	return gqlToken{kind, l.src[start:l.pos], start}, nil
+}
+
+func (l *gqlLexer) readDigits() bool {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.pos++
+	}
+	return l.pos > start
+}
+
+func (l *gqlLexer) readString() (gqlToken, error) {
+	start := l.pos
+	l.pos++
+
+	var buf bytes.Buffer
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return gqlToken{gqlString, buf.String(), start}, nil
+		case c == '\n' || c == '\r':
+			return gqlToken{}, fmt.Errorf("syntax error: unterminated string at position %d", start)
+		case c == '\\':
+			if l.pos+1 >= len(l.src) {
+				return gqlToken{}, fmt.Errorf("syntax error: unterminated string at position %d", start)
+			}
+			escaped := l.src[l.pos+1]
+			l.pos += 2
+			switch escaped {
+			case '"', '\\', '/':
+				buf.WriteByte(escaped)
+			case 'b':
+				buf.WriteByte('\b')
+			case 'f':
+				buf.WriteByte('\f')
+			case 'n':
+				buf.WriteByte('\n')
+			case 'r':
+				buf.WriteByte('\r')
+			case 't':
+				buf.WriteByte('\t')
+			case 'u':
+				if l.pos+4 > len(l.src) {
+					return gqlToken{}, fmt.Errorf("syntax error: invalid unicode escape at position %d", l.pos)
+				}
+				r, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 32)
+				if err != nil {
+					return gqlToken{}, fmt.Errorf("syntax error: invalid unicode escape at position %d", l.pos)
+				}
+				buf.WriteRune(rune(r))
+				l.pos += 4
+			default:
+				return gqlToken{}, fmt.Errorf("syntax error: invalid escape \\%c at position %d", escaped, l.pos-2)
+			}
+		default:
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			buf.WriteRune(r)
+			l.pos += size
+		}
+	}
+
+	return gqlToken{}, fmt.Errorf("syntax error: unterminated string at position %d", start)
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// gqlVariable is a reference to a variable in an argument value.
+type gqlVariable string
+
+type gqlVariableDefinition struct {
+	Name         string
+	Required     bool
+	DefaultValue interface{}
+}
+
+type gqlField struct {
+	Alias      string
+	Name       string
+	Arguments  map[string]interface{}
+	Selections []*gqlField
+}
+
+// ResponseKey is the key of the field in the result.
+func (f *gqlField) ResponseKey() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+type gqlOperation struct {
+	Name       string
+	Variables  []*gqlVariableDefinition
+	Selections []*gqlField
+}
+
+type gqlParser struct {
+	lexer *gqlLexer
+	tok   gqlToken
+}
+
+// parseGraphQL parses a query document into its operations.
+func parseGraphQL(query string) ([]*gqlOperation, error) {
+	p := &gqlParser{lexer: &gqlLexer{src: query}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	operations := []*gqlOperation{}
+	for p.tok.kind != gqlEOF {
+		op, err := p.parseOperation()
+		if err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	if len(operations) == 0 {
+		return nil, errors.New("syntax error: the document contains no operation")
+	}
+
+	return operations, nil
+}
+
+func (p *gqlParser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *gqlParser) peek(value string) bool {
+	return p.tok.kind == gqlPunctuator && p.tok.value == value
+}
+
+func (p *gqlParser) expect(value string) error {
+	if !p.peek(value) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *gqlParser) expectName() (string, error) {
+	if p.tok.kind != gqlName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *gqlParser) unexpected() error {
+	if p.tok.kind == gqlEOF {
+		return errors.New("syntax error: unexpected end of the document")
+	}
+	return fmt.Errorf("syntax error: unexpected %q at position %d", p.tok.value, p.tok.pos)
+}
+
+func (p *gqlParser) parseOperation() (*gqlOperation, error) {
+	op := &gqlOperation{}
+
+	if p.tok.kind == gqlName {
+		switch p.tok.value {
+		case "query":
+		case "mutation", "subscription":
+			return nil, fmt.Errorf("%ss are not supported, only queries", p.tok.value)
+		case "fragment":
+			return nil, errors.New("fragments are not supported")
+		default:
+			return nil, p.unexpected()
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		if p.tok.kind == gqlName {
+			op.Name = p.tok.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+		}
+
+		if p.peek("(") {
+			variables, err := p.parseVariableDefinitions()
+			if err != nil {
+				return nil, err
+			}
+			op.Variables = variables
+		}
+	}
+
+	if p.peek("@") {
+		return nil, errors.New("directives are not supported")
+	}
+
+	selections, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.Selections = selections
+
+	return op, nil
+}
+
+func (p *gqlParser) parseVariableDefinitions() ([]*gqlVariableDefinition, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	definitions := []*gqlVariableDefinition{}
+	for !p.peek(")") {
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		required, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+
+		definition := &gqlVariableDefinition{Name: name, Required: required}
+		if p.peek("=") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			definition.DefaultValue, err = p.parseValue(true)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, p.advance()
+}
+
+// parseType skips a type reference like [String!]! and returns whether it's
+// non-null. The types of variables are checked by the resolvers.
+func (p *gqlParser) parseType() (bool, error) {
+	if p.peek("[") {
+		if err := p.advance(); err != nil {
+			return false, err
+		}
+		if _, err := p.parseType(); err != nil {
+			return false, err
+		}
+		if err := p.expect("]"); err != nil {
+			return false, err
+		}
+	} else if _, err := p.expectName(); err != nil {
+		return false, err
+	}
+
+	if p.peek("!") {
+		return true, p.advance()
+	}
+	return false, nil
+}
+
+func (p *gqlParser) parseSelectionSet() ([]*gqlField, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	selections := []*gqlField{}
+	for !p.peek("}") {
+		if p.peek("...") {
+			return nil, errors.New("fragments are not supported")
+		}
+
+		field, err := p.parseField()
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, field)
+	}
+
+	if len(selections) == 0 {
+		return nil, p.unexpected()
+	}
+
+	return selections, p.advance()
+}
+
+func (p *gqlParser) parseField() (*gqlField, error) {
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+
+	field := &gqlField{Name: name, Arguments: map[string]interface{}{}}
+	if p.peek(":") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		field.Alias = name
+		field.Name, err = p.expectName()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek("(") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		for !p.peek(")") {
+			argName, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			field.Arguments[argName], err = p.parseValue(false)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+
+	if p.peek("@") {
+		return nil, errors.New("directives are not supported")
+	}
+
+	if p.peek("{") {
+		field.Selections, err = p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return field, nil
+}
+
+// parseValue parses an argument value. Enum values are returned as strings.
+// Variables aren't allowed in constant values, e.g. defaults of variables.
+func (p *gqlParser) parseValue(constant bool) (interface{}, error) {
+	tok := p.tok
+
+	switch {
+	case tok.kind == gqlInt:
+		n, err := strconv.Atoi(tok.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Int %s at position %d", tok.value, tok.pos)
+		}
+		return n, p.advance()
+	case tok.kind == gqlFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Float %s at position %d", tok.value, tok.pos)
+		}
+		return f, p.advance()
+	case tok.kind == gqlString:
+		return tok.value, p.advance()
+	case tok.kind == gqlName:
+		var value interface{}
+		switch tok.value {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			value = nil
+		default:
+			value = tok.value
+		}
+		return value, p.advance()
+	case p.peek("$") && !constant:
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.expectName()
+		return gqlVariable(name), err
+	case p.peek("["):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		list := []interface{}{}
+		for !p.peek("]") {
+			item, err := p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, p.advance()
+	case p.peek("{"):
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		object := map[string]interface{}{}
+		for !p.peek("}") {
+			name, err := p.expectName()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(":"); err != nil {
+				return nil, err
+			}
+			object[name], err = p.parseValue(constant)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return object, p.advance()
+	}
+
+	return nil, p.unexpected()
+}
+
+// gqlResolver resolves a field of the source object. It returns a scalar, an
+// object of the type of the field or a slice of them.
+type gqlResolver func(source interface{}, args map[string]interface{}) (interface{}, error)
+
+type gqlFieldDefinition struct {
+	// Type is the name of the object type of the field. Empty for scalars.
+	Type string
+	// Args maps the names of the arguments to whether they're required.
+	Args    map[string]bool
+	Resolve gqlResolver
+}
+
+// gqlSchema maps the names of the object types to their fields. The root type
+// is "Query".
+type gqlSchema map[string]map[string]*gqlFieldDefinition
+
+type gqlError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+type gqlResponse struct {
+	Data   *gqlObject  `json:"data,omitempty"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+// gqlObject is a result object, which keeps the order of the selected fields.
+type gqlObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newGqlObject() *gqlObject {
+	return &gqlObject{values: map[string]interface{}{}}
+}
+
+func (o *gqlObject) set(key string, value interface{}) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+func (o *gqlObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(o.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// executeGraphQL parses, validates and executes the query. Errors of the
+// document are returned, errors of single fields are part of the response.
+func (s gqlSchema) executeGraphQL(query, operationName string, variables map[string]interface{}) (*gqlResponse, error) {
+	operations, err := parseGraphQL(query)
+	if err != nil {
+		return nil, err
+	}
+
+	op, err := selectOperation(operations, operationName)
+	if err != nil {
+		return nil, err
+	}
+
+	vars, err := operationVariables(op, variables)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.validate("Query", op.Selections)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &gqlResponse{}
+	response.Data = s.executeSelections("Query", nil, op.Selections, vars, nil, response)
+
+	return response, nil
+}
+
+func selectOperation(operations []*gqlOperation, name string) (*gqlOperation, error) {
+	if name == "" {
+		if len(operations) > 1 {
+			return nil, errors.New("operationName is required for documents with multiple operations")
+		}
+		return operations[0], nil
+	}
+
+	for _, op := range operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %q", name)
+}
+
+func operationVariables(op *gqlOperation, values map[string]interface{}) (map[string]interface{}, error) {
+	vars := map[string]interface{}{}
+
+	for _, definition := range op.Variables {
+		value, ok := values[definition.Name]
+		if !ok {
+			value = definition.DefaultValue
+		}
+		if value == nil && definition.Required {
+			return nil, fmt.Errorf("variable $%s is required", definition.Name)
+		}
+		vars[definition.Name] = value
+	}
+
+	return vars, nil
+}
+
+// validate checks the selections against the schema before anything is
+// resolved.
+func (s gqlSchema) validate(typeName string, selections []*gqlField) error {
+	for _, field := range selections {
+		if field.Name == "__typename" {
+			if len(field.Selections) > 0 {
+				return errors.New(`field "__typename" must not have a selection`)
+			}
+			continue
+		}
+
+		definition, ok := s[typeName][field.Name]
+		if !ok {
+			return fmt.Errorf("cannot query field %q on type %q", field.Name, typeName)
+		}
+
+		for name := range field.Arguments {
+			if _, ok := definition.Args[name]; !ok {
+				return fmt.Errorf("unknown argument %q on field %q", name, field.Name)
+			}
+		}
+		for name, required := range definition.Args {
+			if _, ok := field.Arguments[name]; required && !ok {
+				return fmt.Errorf("argument %q of field %q is required", name, field.Name)
+			}
+		}
+
+		if definition.Type == "" && len(field.Selections) > 0 {
+			return fmt.Errorf("field %q must not have a selection", field.Name)
+		}
+		if definition.Type != "" {
+			if len(field.Selections) == 0 {
+				return fmt.Errorf("field %q of type %q must have a selection", field.Name, definition.Type)
+			}
+			err := s.validate(definition.Type, field.Selections)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (s gqlSchema) executeSelections(typeName string, source interface{}, selections []*gqlField, vars map[string]interface{}, path []interface{}, response *gqlResponse) *gqlObject {
+	result := newGqlObject()
+
+	for _, field := range selections {
+		key := field.ResponseKey()
+		fieldPath := append(append([]interface{}{}, path...), key)
+
+		if field.Name == "__typename" {
+			result.set(key, typeName)
+			continue
+		}
+
+		definition := s[typeName][field.Name]
+		args := substituteVariables(field.Arguments, vars).(map[string]interface{})
+
+		value, err := definition.Resolve(source, args)
+		if err != nil {
+			response.Errors = append(response.Errors, &gqlError{Message: err.Error(), Path: fieldPath})
+			result.set(key, nil)
+			continue
+		}
+
+		result.set(key, s.completeValue(definition.Type, value, field, vars, fieldPath, response))
+	}
+
+	return result
+}
+
+// completeValue executes the selections on resolved objects and lists of
+// objects. Scalars are returned as they are.
+func (s gqlSchema) completeValue(typeName string, value interface{}, field *gqlField, vars map[string]interface{}, path []interface{}, response *gqlResponse) interface{} {
+	if value == nil {
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Slice) && v.IsNil() {
+		return nil
+	}
+
+	if typeName == "" {
+		return value
+	}
+
+	if v.Kind() == reflect.Slice {
+		list := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			itemPath := append(append([]interface{}{}, path...), i)
+			list[i] = s.completeValue(typeName, v.Index(i).Interface(), field, vars, itemPath, response)
+		}
+		return list
+	}
+
+	return s.executeSelections(typeName, value, field.Selections, vars, path, response)
+}
+
+func substituteVariables(value interface{}, vars map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case gqlVariable:
+		return vars[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = substituteVariables(item, vars)
+		}
+		return list
+	case map[string]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			object[key] = substituteVariables(item, vars)
+		}
+		return object
+	}
+	return value
+}
+
+// gqlIntArg returns the Int argument or def if it's missing or null. Int
+// variables are decoded from JSON as float64.
+func gqlIntArg(args map[string]interface{}, name string, def int) (int, error) {
+	switch n := args[name].(type) {
+	case nil:
+		return def, nil
+	case int:
+		return n, nil
+	case float64:
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return 0, fmt.Errorf("argument %q must be an Int", name)
+}
+
+// gqlStringArg returns the String argument or "" if it's missing or null.
+func gqlStringArg(args map[string]interface{}, name string) (string, error) {
+	switch s := args[name].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return s, nil
+	}
+	return "", fmt.Errorf("argument %q must be a String", name)
+}
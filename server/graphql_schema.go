@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const maxGraphQLDeploymentsLimit = 100
+
+var graphQLDeploymentFilterArgs = []string{"target", "state", "branch", "user", "from", "to", "sort"}
+
+type graphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphQLHandler answers GraphQL queries for dashboards, sent as JSON with
+// POST or as query parameters with GET.
+func graphQLHandler(w http.ResponseWriter, r *http.Request) {
+	var req graphQLRequest
+
+	if r.Method == "POST" {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			renderGraphQLError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+			return
+		}
+	} else {
+		req.Query = r.URL.Query().Get("query")
+		req.OperationName = r.URL.Query().Get("operationName")
+		if v := r.URL.Query().Get("variables"); v != "" {
+			err := json.Unmarshal([]byte(v), &req.Variables)
+			if err != nil {
+				renderGraphQLError(w, http.StatusBadRequest, "invalid variables: "+err.Error())
+				return
+			}
+		}
+	}
+
+	if req.Query == "" {
+		renderGraphQLError(w, http.StatusBadRequest, "query is missing")
+		return
+	}
+
+	schema := newGraphQLSchema(getCurrentUser(r))
+	response, err := schema.executeGraphQL(req.Query, req.OperationName, req.Variables)
+	if err != nil {
+		renderGraphQLError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	renderApiJSON(w, http.StatusOK, response)
+}
+
+func renderGraphQLError(w http.ResponseWriter, status int, message string) {
+	renderApiJSON(w, status, &gqlResponse{Errors: []*gqlError{{Message: message}}})
+}
+
+// newGraphQLSchema builds the schema for the current user, who only sees the
+// applications they can read.
+func newGraphQLSchema(currentUser *models.User) gqlSchema {
+	readableApplication := func(name string) *models.Application {
+		a, err := findApplication(name)
+		if err != nil || !a.CanRead(currentUser) {
+			return nil
+		}
+		return a
+	}
+
+	return gqlSchema{
+		"Query": {
+			"viewer": {Type: "User", Resolve: func(_ interface{}, _ map[string]interface{}) (interface{}, error) {
+				return currentUser, nil
+			}},
+			"user": {Type: "User", Args: map[string]bool{"id": true}, Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+				id, err := gqlIntArg(args, "id", 0)
+				if err != nil {
+					return nil, err
+				}
+				users, err := getUsers(db, []int{id})
+				if err != nil || len(users) == 0 {
+					return nil, err
+				}
+				return users[0], nil
+			}},
+			"applications": {Type: "Application", Resolve: func(_ interface{}, _ map[string]interface{}) (interface{}, error) {
+				applications := []*models.Application{}
+				for _, a := range getConfig().Applications {
+					if a.CanRead(currentUser) {
+						applications = append(applications, a)
+					}
+				}
+				return applications, nil
+			}},
+			"application": {Type: "Application", Args: map[string]bool{"name": true}, Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+				name, err := gqlStringArg(args, "name")
+				if err != nil {
+					return nil, err
+				}
+				return readableApplication(name), nil
+			}},
+		},
+		"Application": {
+			"name": gqlApplicationField(func(a *models.Application) interface{} { return a.Name }),
+			"scm":  gqlApplicationField(func(a *models.Application) interface{} { return a.SCMName() }),
+			"targets": {Type: "Target", Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return newApiApplication(source.(*models.Application), currentUser).Targets, nil
+			}},
+			"target": {Type: "Target", Args: map[string]bool{"name": true}, Resolve: func(source interface{}, args map[string]interface{}) (interface{}, error) {
+				name, err := gqlStringArg(args, "name")
+				if err != nil {
+					return nil, err
+				}
+				for _, t := range newApiApplication(source.(*models.Application), currentUser).Targets {
+					if t.Name == name {
+						return t, nil
+					}
+				}
+				return nil, nil
+			}},
+			"deployments": {Type: "Deployment", Args: graphQLDeploymentsArgs(), Resolve: func(source interface{}, args map[string]interface{}) (interface{}, error) {
+				return resolveGraphQLDeployments(source.(*models.Application), args)
+			}},
+			"deployment": {Type: "Deployment", Args: map[string]bool{"id": true}, Resolve: func(source interface{}, args map[string]interface{}) (interface{}, error) {
+				id, err := gqlIntArg(args, "id", 0)
+				if err != nil {
+					return nil, err
+				}
+				d, err := getDeployment(db, id)
+				if err != nil || d == nil || d.ApplicationName != source.(*models.Application).Name {
+					return nil, err
+				}
+				return d, nil
+			}},
+		},
+		"Target": {
+			"name":            gqlTargetField(func(t *apiTarget) interface{} { return t.Name }),
+			"availableStages": gqlTargetField(func(t *apiTarget) interface{} { return t.AvailableStages }),
+			"defaultStages":   gqlTargetField(func(t *apiTarget) interface{} { return t.DefaultStages }),
+			"deployable":      gqlTargetField(func(t *apiTarget) interface{} { return t.Deployable }),
+		},
+		"Deployment": {
+			"id":               gqlDeploymentField(func(d *models.Deployment) interface{} { return d.Id }),
+			"application":      gqlDeploymentField(func(d *models.Deployment) interface{} { return d.ApplicationName }),
+			"target":           gqlDeploymentField(func(d *models.Deployment) interface{} { return d.TargetName }),
+			"state":            gqlDeploymentField(func(d *models.Deployment) interface{} { return d.State }),
+			"commitSha":        gqlDeploymentField(func(d *models.Deployment) interface{} { return d.CommitSha }),
+			"branch":           gqlDeploymentField(func(d *models.Deployment) interface{} { return d.Branch }),
+			"tag":              gqlDeploymentField(func(d *models.Deployment) interface{} { return d.Tag }),
+			"pullRequest":      gqlDeploymentField(func(d *models.Deployment) interface{} { return d.PullRequest }),
+			"comment":          gqlDeploymentField(func(d *models.Deployment) interface{} { return d.Comment }),
+			"createdAt":        gqlDeploymentField(func(d *models.Deployment) interface{} { return d.CreatedAt.Format(time.RFC3339) }),
+			"retryOf":          gqlDeploymentField(func(d *models.Deployment) interface{} { return d.RetryOf }),
+			"redeployOf":       gqlDeploymentField(func(d *models.Deployment) interface{} { return d.RedeployOf }),
+			"hostGroup":        gqlDeploymentField(func(d *models.Deployment) interface{} { return d.HostGroup }),
+			"ciOverrideReason": gqlDeploymentField(func(d *models.Deployment) interface{} { return d.CIOverrideReason }),
+			"url": gqlDeploymentField(func(d *models.Deployment) interface{} {
+				return deploymentUrl(&models.Application{Name: d.ApplicationName}, d)
+			}),
+			"user": {Type: "User", Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+				d := source.(*models.Deployment)
+				if d.User == nil {
+					users, err := getUsers(db, []int{d.UserId})
+					if err != nil || len(users) == 0 {
+						return nil, err
+					}
+					d.User = users[0]
+				}
+				return d.User, nil
+			}},
+			"initiator": {Type: "Initiator", Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+				d := source.(*models.Deployment)
+				err := loadDeploymentInitiator(db, d)
+				if err != nil {
+					return nil, err
+				}
+				return newApiDeployment(&models.Application{Name: d.ApplicationName}, d).Initiator, nil
+			}},
+			"changelog": {Type: "ChangelogEntry", Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+				d := source.(*models.Deployment)
+				err := loadDeploymentChangelog(db, d)
+				return d.Changelog, err
+			}},
+			"logEntries": {Type: "LogEntry", Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+				entries, err := getDeploymentLogEntries(db, source.(*models.Deployment))
+				if err == nil && entries == nil {
+					entries = []*deploy.LogEntry{}
+				}
+				return entries, err
+			}},
+		},
+		"Initiator": {
+			"tokenName":   gqlInitiatorField(func(i *apiInitiator) interface{} { return i.TokenName }),
+			"sourceIp":    gqlInitiatorField(func(i *apiInitiator) interface{} { return i.SourceIP }),
+			"buildUrl":    gqlInitiatorField(func(i *apiInitiator) interface{} { return i.BuildURL }),
+			"buildNumber": gqlInitiatorField(func(i *apiInitiator) interface{} { return i.BuildNumber }),
+			"onBehalfOf":  gqlInitiatorField(func(i *apiInitiator) interface{} { return i.OnBehalfOf }),
+		},
+		"ChangelogEntry": {
+			"commitSha": gqlChangelogEntryField(func(e *models.ChangelogEntry) interface{} { return e.CommitSha }),
+			"author":    gqlChangelogEntryField(func(e *models.ChangelogEntry) interface{} { return e.Author }),
+			"message":   gqlChangelogEntryField(func(e *models.ChangelogEntry) interface{} { return e.Message }),
+		},
+		"LogEntry": {
+			"id":        gqlLogEntryField(func(e *deploy.LogEntry) interface{} { return e.Id }),
+			"timestamp": gqlLogEntryField(func(e *deploy.LogEntry) interface{} { return e.Timestamp.Format(time.RFC3339Nano) }),
+			"origin":    gqlLogEntryField(func(e *deploy.LogEntry) interface{} { return e.Origin }),
+			"entryType": gqlLogEntryField(func(e *deploy.LogEntry) interface{} { return e.EntryType }),
+			"message":   gqlLogEntryField(func(e *deploy.LogEntry) interface{} { return e.Message }),
+			"exitCode":  gqlLogEntryField(func(e *deploy.LogEntry) interface{} { return e.ExitCode }),
+			// In milliseconds, nanoseconds exceed the range of GraphQL's Int
+			"duration": gqlLogEntryField(func(e *deploy.LogEntry) interface{} { return int(e.Duration / time.Millisecond) }),
+		},
+		"User": {
+			"id":          gqlUserField(func(u *models.User) interface{} { return u.Id }),
+			"name":        gqlUserField(func(u *models.User) interface{} { return u.Name }),
+			"avatarUrl":   gqlUserField(func(u *models.User) interface{} { return u.AvatarUrl }),
+			"provider":    gqlUserField(func(u *models.User) interface{} { return u.Provider }),
+			"deactivated": gqlUserField(func(u *models.User) interface{} { return u.IsDeactivated() }),
+		},
+	}
+}
+
+func resolveGraphQLDeployments(a *models.Application, args map[string]interface{}) (interface{}, error) {
+	limit, err := gqlIntArg(args, "limit", defaultApiDeploymentsLimit)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > maxGraphQLDeploymentsLimit {
+		return nil, errors.New("limit must be between 1 and 100")
+	}
+
+	// The filter arguments are the query parameters of the JSON API
+	q := url.Values{}
+	for _, name := range graphQLDeploymentFilterArgs {
+		value, err := gqlStringArg(args, name)
+		if err != nil {
+			return nil, err
+		}
+		q.Set(name, value)
+	}
+
+	filter, err := parseDeploymentFilter(a, q, time.Local)
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit = limit
+
+	deployments, err := getFilteredApplicationDeployments(db, a, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range deployments {
+		d.ApplicationName = a.Name
+	}
+
+	// Loads the users of all deployments with one query
+	err = loadDeploymentsUsers(db, deployments)
+	if err != nil {
+		slog.Error("error loading the users of the deployments", "err", err)
+		return nil, err
+	}
+
+	return deployments, nil
+}
+
+func graphQLDeploymentsArgs() map[string]bool {
+	args := map[string]bool{"limit": false}
+	for _, name := range graphQLDeploymentFilterArgs {
+		args[name] = false
+	}
+	return args
+}
+
+func gqlApplicationField(fn func(*models.Application) interface{}) *gqlFieldDefinition {
+	return &gqlFieldDefinition{Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return fn(source.(*models.Application)), nil
+	}}
+}
+
+func gqlTargetField(fn func(*apiTarget) interface{}) *gqlFieldDefinition {
+	return &gqlFieldDefinition{Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return fn(source.(*apiTarget)), nil
+	}}
+}
+
+func gqlDeploymentField(fn func(*models.Deployment) interface{}) *gqlFieldDefinition {
+	return &gqlFieldDefinition{Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return fn(source.(*models.Deployment)), nil
+	}}
+}
+
+func gqlInitiatorField(fn func(*apiInitiator) interface{}) *gqlFieldDefinition {
+	return &gqlFieldDefinition{Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return fn(source.(*apiInitiator)), nil
+	}}
+}
+
+func gqlChangelogEntryField(fn func(*models.ChangelogEntry) interface{}) *gqlFieldDefinition {
+	return &gqlFieldDefinition{Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return fn(source.(*models.ChangelogEntry)), nil
+	}}
+}
+
+func gqlLogEntryField(fn func(*deploy.LogEntry) interface{}) *gqlFieldDefinition {
+	return &gqlFieldDefinition{Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return fn(source.(*deploy.LogEntry)), nil
+	}}
+}
+
+func gqlUserField(fn func(*models.User) interface{}) *gqlFieldDefinition {
+	return &gqlFieldDefinition{Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+		return fn(source.(*models.User)), nil
+	}}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+type testBook struct {
+	Title  string
+	Author *testAuthor
+}
+
+type testAuthor struct {
+	Name string
+}
+
+var testGraphQLSchema = gqlSchema{
+	"Query": {
+		"books": {Type: "Book", Args: map[string]bool{"limit": false}, Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			limit, err := gqlIntArg(args, "limit", 2)
+			if err != nil {
+				return nil, err
+			}
+			books := []*testBook{
+				{"Gödel, Escher, Bach", &testAuthor{"Hofstadter"}},
+				{"Anathem", nil},
+				{"Snow Crash", &testAuthor{"Stephenson"}},
+			}
+			return books[:limit], nil
+		}},
+		"book": {Type: "Book", Args: map[string]bool{"title": true}, Resolve: func(_ interface{}, args map[string]interface{}) (interface{}, error) {
+			title, err := gqlStringArg(args, "title")
+			if err != nil {
+				return nil, err
+			}
+			if title == "" {
+				return (*testBook)(nil), nil
+			}
+			return &testBook{Title: title}, nil
+		}},
+		"broken": {Resolve: func(_ interface{}, _ map[string]interface{}) (interface{}, error) {
+			return nil, errors.New("out of pizza")
+		}},
+	},
+	"Book": {
+		"title": {Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*testBook).Title, nil
+		}},
+		"author": {Type: "Author", Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*testBook).Author, nil
+		}},
+	},
+	"Author": {
+		"name": {Resolve: func(source interface{}, _ map[string]interface{}) (interface{}, error) {
+			return source.(*testAuthor).Name, nil
+		}},
+	},
+}
+
+func TestExecuteGraphQL(t *testing.T) {
+	tests := []struct {
+		query         string
+		operationName string
+		variables     map[string]interface{}
+		expected      string
+		expectedErr   string
+	}{
+		{
+			query:    `{ books { title } }`,
+			expected: `{"data":{"books":[{"title":"Gödel, Escher, Bach"},{"title":"Anathem"}]}}`,
+		},
+		{
+			query:    `query Books { books(limit: 3) { name: title author { name } } }`,
+			expected: `{"data":{"books":[{"name":"Gödel, Escher, Bach","author":{"name":"Hofstadter"}},{"name":"Anathem","author":null},{"name":"Snow Crash","author":{"name":"Stephenson"}}]}}`,
+		},
+		{
+			query:     `query ($limit: Int = 2) { books(limit: $limit) { title } }`,
+			variables: map[string]interface{}{"limit": float64(1)},
+			expected:  `{"data":{"books":[{"title":"Gödel, Escher, Bach"}]}}`,
+		},
+		{
+			query:    "# comment\n{ a: book(title: \"Dune\\n\") { title __typename } b: book(title: \"\") { title } }",
+			expected: `{"data":{"a":{"title":"Dune\n","__typename":"Book"},"b":null}}`,
+		},
+		{
+			query:    `{ broken books(limit: 1) { title } }`,
+			expected: `{"data":{"broken":null,"books":[{"title":"Gödel, Escher, Bach"}]},"errors":[{"message":"out of pizza","path":["broken"]}]}`,
+		},
+		{
+			query:    `{ books(limit: "one") { title } }`,
+			expected: `{"data":{"books":null},"errors":[{"message":"argument \"limit\" must be an Int","path":["books"]}]}`,
+		},
+		{
+			query:         `query A { books { title } } query B { broken }`,
+			operationName: "B",
+			expected:      `{"data":{"broken":null},"errors":[{"message":"out of pizza","path":["broken"]}]}`,
+		},
+		{query: `query A { broken } query B { broken }`, expectedErr: "operationName is required for documents with multiple operations"},
+		{query: `{ books { isbn } }`, expectedErr: `cannot query field "isbn" on type "Book"`},
+		{query: `{ books }`, expectedErr: `field "books" of type "Book" must have a selection`},
+		{query: `{ broken { title } }`, expectedErr: `field "broken" must not have a selection`},
+		{query: `{ book { title } }`, expectedErr: `argument "title" of field "book" is required`},
+		{query: `{ books(order: ASC) { title } }`, expectedErr: `unknown argument "order" on field "books"`},
+		{query: `query ($limit: Int!) { books(limit: $limit) { title } }`, expectedErr: "variable $limit is required"},
+		{query: `mutation { deploy }`, expectedErr: "mutations are not supported, only queries"},
+		{query: `{ books { ...bookFields } }`, expectedErr: "fragments are not supported"},
+		{query: `{ books @skip(if: true) { title } }`, expectedErr: "directives are not supported"},
+		{query: `{ books { title }`, expectedErr: "syntax error: unexpected end of the document"},
+		{query: `{ book(title: "unterminated) { title } }`, expectedErr: "syntax error: unterminated string at position 14"},
+	}
+
+	for _, tt := range tests {
+		response, err := testGraphQLSchema.executeGraphQL(tt.query, tt.operationName, tt.variables)
+		if tt.expectedErr != "" {
+			if err == nil || err.Error() != tt.expectedErr {
+				t.Errorf("wrong error for %s. want=%s, got=%v", tt.query, tt.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %s: %s", tt.query, err)
+			continue
+		}
+
+		js, err := json.Marshal(response)
+		checkErr(t, err)
+
+		if string(js) != tt.expected {
+			t.Errorf("wrong response for %s.\nwant=%s\ngot=%s", tt.query, tt.expected, js)
+		}
+	}
+}
+
+func TestGraphQLHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{Applications: []*models.Application{
+		{
+			Name:          "flincOnRails",
+			ReadUsernames: []string{"mrnugget"},
+			Targets:       []*models.Target{{Name: "production", DeployUsernames: []string{"mrnugget"}}},
+		},
+		{Name: "secret", ReadUsernames: []string{"fabrik42"}},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+	checkErr(t, setApiToken(db, user, "t0k3n"))
+
+	deployment := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, deployment))
+	checkErr(t, createLogEntry(db, &deploy.LogEntry{
+		DeploymentId: deployment.Id,
+		EntryType:    deploy.COMMAND_SUCCESS,
+		Message:      "bundle install",
+		Timestamp:    time.Now(),
+		Duration:     1500 * time.Millisecond,
+	}))
+
+	router := mux.NewRouter()
+	setupApiRoutes(router)
+
+	query := `{
+		viewer { name }
+		applications {
+			name
+			targets { name deployable }
+			deployments(limit: 5) { branch user { name } logEntries { message duration } }
+		}
+		secret: application(name: "secret") { name }
+	}`
+	body, err := json.Marshal(map[string]interface{}{"query": query})
+	checkErr(t, err)
+
+	tests := []struct {
+		token          string
+		body           string
+		expectedStatus int
+		expected       string
+	}{
+		{"", string(body), 401, ""},
+		{"t0k3n", string(body), 200, `{"data":{"viewer":{"name":"mrnugget"},"applications":[{"name":"flincOnRails","targets":[{"name":"production","deployable":true}],"deployments":[{"branch":"master","user":{"name":"mrnugget"},"logEntries":[{"message":"bundle install","duration":1500}]}]}],"secret":null}}`},
+		{"t0k3n", `{"query": "{ viewer { password } }"}`, 400, `{"errors":[{"message":"cannot query field \"password\" on type \"User\""}]}`},
+		{"t0k3n", `{"query": ""}`, 400, `{"errors":[{"message":"query is missing"}]}`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/graphql", strings.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("X-Api-Token", tt.token)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s. want=%d, got=%d", tt.body, tt.expectedStatus, rec.Code)
+		}
+		if tt.expected != "" && rec.Body.String() != tt.expected {
+			t.Errorf("wrong response for %s.\nwant=%s\ngot=%s", tt.body, tt.expected, rec.Body.String())
+		}
+	}
+}
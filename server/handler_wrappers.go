@@ -1,9 +1,10 @@
 package main
 
 import (
-	"log"
 	"net/http"
+	"strconv"
 
+	"github.com/applikatoni/applikatoni/models"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
 )
@@ -13,6 +14,7 @@ func requireAuthorizedUser(h http.HandlerFunc) http.HandlerFunc {
 	h = authenticated(h)
 	h = applicationScoped(h)
 	h = authenticate(h)
+	h = apiTokenRateLimited(h)
 	return h
 }
 
@@ -20,20 +22,38 @@ func authenticate(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		currentUser, err := loadUserFromSession(r)
 		if err != nil {
-			log.Println("error when trying to get current user", err)
+			requestLogger(r).Error("error when trying to get current user", "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
+		// Requests with an API token can't be sent by other sites, only the
+		// ones authenticated with the session cookie need a CSRF token
+		if currentUser != nil && !validCSRFToken(r) {
+			requestLogger(r).Warn("request without valid CSRF token", "user", currentUser.Name)
+			http.Error(w, "invalid CSRF token, please reload the page", http.StatusForbidden)
+			return
+		}
+
 		if currentUser == nil {
 			currentUser, err = loadUserWithApiToken(r)
+			if err == errLoginLockedOut {
+				w.Header().Set("Retry-After", strconv.Itoa(lockedOutSeconds(loginLockedOut(r))))
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
 			if err != nil {
-				log.Println("error when trying to get current user via Api Token", err)
+				requestLogger(r).Error("error when trying to get current user via Api Token", "err", err)
 				http.Error(w, "wrong API token", http.StatusInternalServerError)
 				return
 			}
+			if currentUser != nil {
+				context.Set(r, ApiTokenRequest, true)
+			}
 		}
 
+		currentUser = verifyUser(w, r, currentUser)
+
 		if currentUser != nil {
 			context.Set(r, CurrentUser, currentUser)
 		}
@@ -42,6 +62,37 @@ func authenticate(fn http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// verifyUser returns nil if the authenticated user may no longer use
+// Applikatoni, e.g. because the user has been deactivated.
+func verifyUser(w http.ResponseWriter, r *http.Request, currentUser *models.User) *models.User {
+	if currentUser != nil && currentUser.IsDeactivated() {
+		requestLogger(r).Info("user has been deactivated", "user", currentUser.Name)
+		logOutUser(w, r)
+		currentUser = nil
+	}
+
+	if currentUser != nil && !loadServiceAccount(currentUser) {
+		requestLogger(r).Info("user is no longer a configured service account", "user", currentUser.Name)
+		currentUser = nil
+	}
+
+	if currentUser != nil && !isAllowedUser(currentUser) {
+		requestLogger(r).Info("user is not a member of the github_organizations", "user", currentUser.Name)
+		currentUser = nil
+	}
+
+	if currentUser != nil && tokenExpired(currentUser) {
+		err := refreshUserToken(db, currentUser)
+		if err != nil {
+			requestLogger(r).Warn("refreshing the access token failed", "user", currentUser.Name, "err", err)
+			logOutUser(w, r)
+			currentUser = nil
+		}
+	}
+
+	return currentUser
+}
+
 func applicationScoped(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
@@ -76,10 +127,34 @@ func authorizedReaders(fn http.HandlerFunc) http.HandlerFunc {
 		currentUser := getCurrentUser(r)
 		application := getCurrentApplication(r)
 
-		if application.IsReader(currentUser.Name) {
+		if application.CanRead(currentUser) {
 			fn(w, r)
 		} else {
 			http.Redirect(w, r, "/", http.StatusFound)
 		}
 	}
 }
+
+// interactiveUsers rejects service accounts, which can only use the API.
+func interactiveUsers(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		currentUser := getCurrentUser(r)
+		if currentUser.ServiceAccount != nil {
+			http.Error(w, "not available for service accounts", http.StatusForbidden)
+			return
+		}
+
+		fn(w, r)
+	}
+}
+
+func admins(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !getConfig().IsAdmin(getCurrentUser(r)) {
+			http.Error(w, "only admins can do this", http.StatusForbidden)
+			return
+		}
+
+		fn(w, r)
+	}
+}
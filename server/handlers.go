@@ -1,23 +1,23 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"regexp"
 	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"golang.org/x/oauth2"
 
-	"github.com/applikatoni/applikatoni/deploy"
 	"github.com/applikatoni/applikatoni/models"
 	"github.com/gorilla/context"
 	"github.com/gorilla/mux"
-	"github.com/gorilla/websocket"
 )
 
 type contextKey int
@@ -25,13 +25,22 @@ type contextKey int
 const (
 	CurrentUser contextKey = iota + 1
 	CurrentApplication
+	ApiTokenRequest
 )
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := getCurrentUser(r)
 
-	renderTemplate(w, "home.tmpl", map[string]interface{}{
-		"Applications": config.Applications,
+	if currentUser != nil && currentUser.Preferences.DefaultApplication != "" {
+		a, err := findApplication(currentUser.Preferences.DefaultApplication)
+		if err == nil && a.CanRead(currentUser) {
+			http.Redirect(w, r, "/"+a.Name, http.StatusFound)
+			return
+		}
+	}
+
+	renderTemplate(w, r, "home.tmpl", map[string]interface{}{
+		"Applications": getConfig().Applications,
 		"currentUser":  currentUser,
 	})
 }
@@ -42,27 +51,92 @@ func applicationHandler(w http.ResponseWriter, r *http.Request) {
 
 	deployments, err := getRecentApplicationDeployments(db, application)
 	if err != nil {
-		log.Println("error loading deployments", err)
+		requestLogger(r).Error("error loading deployments", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	err = loadDeploymentsUsers(db, deployments)
 	if err != nil {
-		log.Println("error loading the users of the deployments", err)
+		requestLogger(r).Error("error loading the users of the deployments", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "application.tmpl", map[string]interface{}{
-		"Applications": config.Applications,
-		"Application":  application,
-		"Deployments":  deployments,
-		"currentUser":  currentUser,
+	liveHostGroups := make(map[string]string)
+	for _, t := range application.Targets {
+		if !t.IsBlueGreen() {
+			continue
+		}
+		liveHostGroups[t.Name], err = getLiveHostGroup(db, application.Name, t.Name)
+		if err != nil {
+			requestLogger(r).Error("error loading the live host groups", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// The deploy form is prefilled with the commit, target and stages of the
+	// deployment in ?redeploy=<id>
+	var redeploy *models.Deployment
+	if id, err := strconv.Atoi(r.URL.Query().Get("redeploy")); err == nil {
+		redeploy, err = getDeployment(db, id)
+		if err != nil {
+			requestLogger(r).Error("error loading deployment", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if redeploy != nil && redeploy.ApplicationName != application.Name {
+			redeploy = nil
+		}
+		if redeploy != nil {
+			err = loadDeploymentOverrides(db, redeploy)
+			if err != nil {
+				requestLogger(r).Error("error loading deployment overrides", "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	// A failed deployment is resumed by redeploying it from ?resume_from=<stage>
+	var resumeFrom models.DeploymentStage
+	if stage := r.URL.Query().Get("resume_from"); redeploy != nil && stage != "" {
+		resumeFrom = models.DeploymentStage(stage)
+		redeploy.Stages = stagesFrom(redeploy.Stages, resumeFrom)
+	}
+
+	freezes, err := getDeployFreezes(db, application)
+	if err == nil {
+		err = loadDeployFreezesUsers(db, freezes)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy freezes", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	presets, err := getDeployPresets(db, application)
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy presets", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "application.tmpl", map[string]interface{}{
+		"Applications":   getConfig().Applications,
+		"Application":    application,
+		"Deployments":    deployments,
+		"LiveHostGroups": liveHostGroups,
+		"Redeploy":       redeploy,
+		"ResumeFrom":     resumeFrom,
+		"DeployFreezes":  freezes,
+		"DeployPresets":  presets,
+		"currentUser":    currentUser,
 	})
 }
 
 func toniConfigurationHandler(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
 	currentUser := getCurrentUser(r)
 	application := getCurrentApplication(r)
 
@@ -95,7 +169,7 @@ func toniConfigurationHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 
-	renderTemplate(w, "toni_configuration.tmpl", map[string]interface{}{
+	renderTemplate(w, r, "toni_configuration.tmpl", map[string]interface{}{
 		"Applications":  config.Applications,
 		"Application":   application,
 		"currentUser":   currentUser,
@@ -107,10 +181,20 @@ func pullRequestsHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := getCurrentUser(r)
 	application := getCurrentApplication(r)
 
-	ghClient := NewGitHubClient(currentUser)
-	pulls, err := ghClient.GetPullRequests(application)
+	scmClient, err := NewSCMClient(application, currentUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	pulls, err := scmClient.GetPullRequests(application)
 	if err != nil {
-		log.Println("error loading pull requests", err)
+		// A rejected scm_access_token is not the fault of the user
+		if isSCMUnauthorized(err) && usesUserSCMToken(application) {
+			requireLogin(w, r)
+			return
+		}
+		requestLogger(r).Error("error loading pull requests", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -128,10 +212,19 @@ func branchesHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := getCurrentUser(r)
 	application := getCurrentApplication(r)
 
-	ghClient := NewGitHubClient(currentUser)
-	branches, err := ghClient.GetBranches(application)
+	scmClient, err := NewCachedSCMClient(application, currentUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	branches, err := scmClient.GetBranches(application)
 	if err != nil {
-		log.Println("error loading branches", err)
+		if isSCMUnauthorized(err) && usesUserSCMToken(application) {
+			requireLogin(w, r)
+			return
+		}
+		requestLogger(r).Error("error loading branches", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -146,6 +239,37 @@ func branchesHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(js)
 }
 
+func tagsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	scmClient, err := NewCachedSCMClient(application, currentUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	tags, err := scmClient.GetTags(application)
+	if err != nil {
+		if isSCMUnauthorized(err) && usesUserSCMToken(application) {
+			requireLogin(w, r)
+			return
+		}
+		requestLogger(r).Error("error loading tags", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
+
 func diffHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := getCurrentUser(r)
 	application := getCurrentApplication(r)
@@ -160,7 +284,7 @@ func diffHandler(w http.ResponseWriter, r *http.Request) {
 
 	d, err := getLastTargetDeployment(db, application, targetName)
 	if err != nil {
-		log.Println("getLastTargetDeployment failed", err)
+		requestLogger(r).Error("getLastTargetDeployment failed", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -170,10 +294,19 @@ func diffHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ghClient := NewGitHubClient(currentUser)
-	diff, err := ghClient.Compare(application, d.CommitSha, sha)
+	scmClient, err := NewCachedSCMClient(application, currentUser)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	diff, err := scmClient.Compare(application, d.CommitSha, sha)
 	if err != nil {
-		log.Println("error loading diff from github", err)
+		if isSCMUnauthorized(err) && usesUserSCMToken(application) {
+			requireLogin(w, r)
+			return
+		}
+		requestLogger(r).Error("error loading diff from github", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -193,34 +326,147 @@ func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := getCurrentUser(r)
 	application := getCurrentApplication(r)
 
-	target, err := findTarget(application, r.FormValue("target"))
-	if err != nil {
-		log.Printf("error: %s\n", err)
-		http.NotFound(w, r)
+	deployment, target, stages, reqErr := newDeploymentFromRequest(r, application, currentUser)
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
 		return
 	}
 
-	if !target.IsDeployer(currentUser.Name) {
-		http.Error(w, "not authorized to deploy to this target", 403)
+	err := startDeployment(application, target, deployment, stages)
+	if err != nil {
+		requestLogger(r).Error("could not start deployment", "err", err)
+		http.Error(w, err.Error(), startDeploymentErrorStatus(err))
 		return
 	}
+	recordDeploymentAuditEvents(r, currentUser, deployment)
+
+	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+}
+
+// requestError is an error caused by the request, answered with Status.
+type requestError struct {
+	Status  int
+	Message string
+}
+
+// newDeploymentFromRequest validates the deployment form sent by the UI or the
+// API and builds the deployment, resolving pull requests and tags. The
+// fields left empty are filled from the preset, if one is given.
+func newDeploymentFromRequest(r *http.Request, application *models.Application, currentUser *models.User) (*models.Deployment, *models.Target, []models.DeploymentStage, *requestError) {
+	if reqErr := applyDeployPreset(r, application); reqErr != nil {
+		return nil, nil, nil, reqErr
+	}
+
+	target, err := findTarget(application, r.FormValue("target"))
+	if err != nil {
+		requestLogger(r).Warn("target not found", "target", r.FormValue("target"), "err", err)
+		return nil, nil, nil, &requestError{http.StatusNotFound, "target not found"}
+	}
+
+	if !application.CanDeploy(target, currentUser) {
+		return nil, nil, nil, &requestError{403, "not authorized to deploy to this target"}
+	}
+
+	if target.RequireTwoFactorAuth {
+		err = checkTwoFactorAuth(currentUser)
+		if err != nil {
+			requestLogger(r).Warn("deploying without two-factor authentication refused", "user", currentUser.Name, "target", target.Name, "err", err)
+			return nil, nil, nil, &requestError{403, err.Error()}
+		}
+	}
+
+	if reqErr := checkDeployFreeze(application, target, currentUser, r.FormValue("freeze_override") != ""); reqErr != nil {
+		return nil, nil, nil, reqErr
+	}
+
+	now := time.Now()
+	windowOverrideReason := strings.TrimSpace(r.FormValue("deploy_window_override_reason"))
+	if reqErr := checkDeployWindow(target, currentUser, windowOverrideReason, now); reqErr != nil {
+		return nil, nil, nil, reqErr
+	}
 
 	comment := r.FormValue("comment")
 	if comment == "" {
-		http.Error(w, "comment is empty", 422)
-		return
+		return nil, nil, nil, &requestError{422, "comment is empty"}
 	}
 
 	commitSha := r.FormValue("commitsha")
+	branch := r.FormValue("branch")
+
+	pullRequest := 0
+	if r.FormValue("pull_request") != "" {
+		pullRequest, err = strconv.Atoi(r.FormValue("pull_request"))
+		if err != nil || pullRequest <= 0 {
+			return nil, nil, nil, &requestError{422, "invalid pull request number"}
+		}
+
+		pull, err := getPullRequest(application, currentUser, pullRequest)
+		if err != nil {
+			requestLogger(r).Error("loading pull request failed", "pull_request", pullRequest, "application", application.Name, "err", err)
+			return nil, nil, nil, &requestError{422, fmt.Sprintf("could not load pull request #%d: %s", pullRequest, err)}
+		}
+
+		if commitSha != "" && commitSha != pull.Head.CommitSha {
+			return nil, nil, nil, &requestError{422, fmt.Sprintf("commit sha is not the head of pull request #%d", pullRequest)}
+		}
+		commitSha = pull.Head.CommitSha
+		branch = pull.Head.Branch
+	}
+
+	tagName := r.FormValue("tag")
+	if tagName != "" {
+		if pullRequest != 0 {
+			return nil, nil, nil, &requestError{422, "either a pull request or a tag can be deployed"}
+		}
+
+		tag, err := getTag(application, currentUser, tagName)
+		if err != nil {
+			requestLogger(r).Error("loading tag failed", "tag", tagName, "application", application.Name, "err", err)
+			return nil, nil, nil, &requestError{422, fmt.Sprintf("could not load tag %s: %s", tagName, err)}
+		}
+
+		if commitSha != "" && commitSha != tag.Commit.Sha {
+			return nil, nil, nil, &requestError{422, fmt.Sprintf("commit sha is not the commit of tag %s", tagName)}
+		}
+		commitSha = tag.Commit.Sha
+	}
+
+	if !target.IsDeployableBranch(branch) {
+		msg := "branch %q can't be deployed to %s. Deployable branches: %v"
+		return nil, nil, nil, &requestError{422, fmt.Sprintf(msg, branch, target.Name, target.DeployableBranches)}
+	}
+
+	// Clients like toni can deploy a branch without knowing its head
+	if commitSha == "" && branch != "" && tagName == "" {
+		b, err := getBranch(application, currentUser, branch)
+		if err != nil {
+			requestLogger(r).Error("loading branch failed", "branch", branch, "application", application.Name, "err", err)
+			return nil, nil, nil, &requestError{422, fmt.Sprintf("could not load branch %s: %s", branch, err)}
+		}
+		commitSha = b.CurrentCommit.Sha
+	} else if isValidCommitSha(commitSha) {
+		// The commits of pull requests and tags have to be on the branch too
+		err = checkDeployableCommit(application, target, currentUser, branch, commitSha)
+		if err != nil {
+			requestLogger(r).Warn("deployment refused", "user", currentUser.Name, "commit_sha", commitSha, "branch", branch, "target", target.Name, "err", err)
+			return nil, nil, nil, &requestError{422, err.Error()}
+		}
+	}
+
 	if !isValidCommitSha(commitSha) {
-		http.Error(w, "invalid commit sha", 422)
-		return
+		return nil, nil, nil, &requestError{422, "invalid commit sha"}
+	}
+
+	overrideReason := strings.TrimSpace(r.FormValue("ci_override_reason"))
+	overridden, err := checkCIStatus(application, target, currentUser, commitSha, overrideReason)
+	if err != nil {
+		requestLogger(r).Warn("deployment refused", "user", currentUser.Name, "commit_sha", commitSha, "target", target.Name, "err", err)
+		return nil, nil, nil, &requestError{422, err.Error()}
 	}
 
 	formStages := r.Form["stages[]"]
 	if len(formStages) == 0 {
-		http.Error(w, "no stages selected", 422)
-		return
+		return nil, nil, nil, &requestError{422, "no stages selected"}
 	}
 
 	stages := []models.DeploymentStage{}
@@ -230,118 +476,219 @@ func createDeploymentHandler(w http.ResponseWriter, r *http.Request) {
 
 	if !target.AreValidStages(stages) {
 		msg := "stages have wrong order or contain invalid stages. Available stages: %v"
-		http.Error(w, fmt.Sprintf(msg, target.AvailableStages), 422)
-		return
+		return nil, nil, nil, &requestError{422, fmt.Sprintf(msg, target.AvailableStages)}
+	}
+
+	overrides, err := parseOverrides(target, r.FormValue("overrides"))
+	if err != nil {
+		return nil, nil, nil, &requestError{422, err.Error()}
+	}
+
+	redeployOf := 0
+	if r.FormValue("redeploy_of") != "" {
+		redeployOf, err = strconv.Atoi(r.FormValue("redeploy_of"))
+		if err != nil || redeployOf <= 0 {
+			return nil, nil, nil, &requestError{422, "invalid redeploy_of"}
+		}
+
+		original, err := getDeployment(db, redeployOf)
+		if err != nil {
+			requestLogger(r).Error("loading deployment failed", "deployment.id", redeployOf, "err", err)
+			return nil, nil, nil, &requestError{http.StatusInternalServerError, err.Error()}
+		}
+		if original == nil || original.ApplicationName != application.Name {
+			return nil, nil, nil, &requestError{422, fmt.Sprintf("deployment #%d to redeploy not found", redeployOf)}
+		}
 	}
 
 	deployment := &models.Deployment{
 		UserId:          currentUser.Id,
 		CommitSha:       commitSha,
-		Branch:          r.FormValue("branch"),
+		Branch:          branch,
 		Comment:         r.FormValue("comment"),
 		ApplicationName: application.Name,
 		TargetName:      target.Name,
+		PullRequest:     pullRequest,
+		Tag:             tagName,
+		RedeployOf:      redeployOf,
+		RequestId:       r.Header.Get(requestIdHeader),
+		Overrides:       overrides,
+	}
+	if overridden {
+		deployment.CIOverrideReason = overrideReason
+	}
+	if !target.InDeployWindow(now) {
+		deployment.DeployWindowOverrideReason = windowOverrideReason
 	}
 
-	err = createDeployment(db, deployment)
+	// Deployments don't depend on the SCM being reachable
+	deployment.Changelog, err = buildChangelog(application, target, currentUser, commitSha)
 	if err != nil {
-		log.Println("Could not save to database", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		requestLogger(r).Warn("could not build changelog", "commit_sha", commitSha, "target", target.Name, "err", err)
+	}
+
+	if isApiTokenRequest(r) {
+		deployment.Initiator = newDeploymentInitiator(r, currentUser)
 	}
 
-	eventHub.Publish(deployment.State, deployment)
-	killChan := killRegistry.Add(deployment.Id)
+	return deployment, target, stages, nil
+}
 
-	deploymentConfig := models.NewDeploymentConfig(deployment, target, stages)
-	manager, err := deploy.NewManager(deploymentConfig, logRouter, killChan)
+func killDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["deploymentId"])
 	if err != nil {
-		log.Println("Could not build Manager", err)
+		requestLogger(r).Error("error converting ID passed to server", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	manager.AnnounceStart()
-
-	err = updateDeploymentState(db, deployment, models.DEPLOYMENT_ACTIVE)
+	deployment, err := getDeployment(db, id)
 	if err != nil {
-		log.Println("Could not update deployment state")
-		killRegistry.Remove(deployment.Id)
+		requestLogger(r).Error("error loading deployment", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	eventHub.Publish(models.DEPLOYMENT_ACTIVE, deployment)
-
-	go func() {
-		newState := models.DEPLOYMENT_SUCCESSFUL
-		err = manager.Start()
-		if err != nil {
-			newState = models.DEPLOYMENT_FAILED
-		}
+	if deployment == nil {
+		http.NotFound(w, r)
+		return
+	}
 
-		err = updateDeploymentState(db, deployment, newState)
-		if err != nil {
-			log.Println("Could not update deployment state")
-		} else {
-			eventHub.Publish(newState, deployment)
-		}
+	err = cancelDeployment(deployment)
+	if err == errNotCancelable {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+	if err != nil {
+		requestLogger(r).Error("could not cancel deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, getCurrentUser(r), models.AUDIT_DEPLOYMENT_CANCEL, deploymentAuditSubject(deployment))
+}
 
-		killRegistry.Remove(deployment.Id)
-	}()
+func continueDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	decideApproval(w, r, false)
+}
 
-	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+func rejectDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+	decideApproval(w, r, true)
 }
 
-func killDeploymentHandler(w http.ResponseWriter, r *http.Request) {
+// decideApproval approves or rejects the deployment waiting in a pause stage
+// or for its second approval if the user can deploy to its target. The
+// decision is recorded in the audit log.
+func decideApproval(w http.ResponseWriter, r *http.Request, reject bool) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["deploymentId"])
 	if err != nil {
-		log.Println("error converting ID passed to server", err)
+		requestLogger(r).Error("error converting ID passed to server", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	killChan, err := killRegistry.Get(id)
+	deployment, err := getDeployment(db, id)
 	if err != nil {
-		http.Error(w, err.Error(), 422)
+		requestLogger(r).Error("error loading deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil || deployment.ApplicationName != application.Name {
+		http.NotFound(w, r)
 		return
 	}
 
-	killChan <- struct{}{}
+	if reqErr := decideDeployment(r, currentUser, application, deployment, reject); reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+	}
+}
+
+// decideDeploymentById approves or rejects the deployment with the id from a
+// chat, where the user might not be allowed to read its application.
+func decideDeploymentById(r *http.Request, u *models.User, id int, reject bool) (*models.Application, *models.Deployment, *requestError) {
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		return nil, nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	if deployment == nil {
+		return nil, nil, &requestError{http.StatusNotFound, "deployment not found"}
+	}
+	application, err := findApplication(deployment.ApplicationName)
+	if err != nil || !application.CanRead(u) {
+		return nil, nil, &requestError{http.StatusNotFound, "deployment not found"}
+	}
+
+	if reqErr := decideDeployment(r, u, application, deployment, reject); reqErr != nil {
+		return nil, nil, reqErr
+	}
+	return application, deployment, nil
+}
+
+// decideDeployment approves or rejects the deployment in the name of the user,
+// e.g. from the deployment page or a chat.
+func decideDeployment(r *http.Request, u *models.User, application *models.Application, deployment *models.Deployment, reject bool) *requestError {
+	target, err := findTarget(application, deployment.TargetName)
+	if err != nil {
+		return &requestError{http.StatusNotFound, "target not found"}
+	}
+
+	if !application.CanDeploy(target, u) {
+		return &requestError{403, "not authorized to approve deployments to this target"}
+	}
+
+	decide, action := approvalRegistry.Approve, models.AUDIT_DEPLOYMENT_APPROVE
+	if reject {
+		decide, action = approvalRegistry.Reject, models.AUDIT_DEPLOYMENT_REJECT
+	} else if !approvalRegistry.CanApprove(deployment.Id, u.Id) {
+		return &requestError{403, ErrOwnDeployment.Error()}
+	}
+
+	err = decide(deployment.Id, u.Name)
+	if err != nil {
+		return &requestError{422, err.Error()}
+	}
+
+	recordAuditEvent(r, u, action, deploymentAuditSubject(deployment))
+	return nil
 }
 
 func listDeploymentsHandler(w http.ResponseWriter, r *http.Request) {
 	currentUser := getCurrentUser(r)
 	application := getCurrentApplication(r)
-	target, err := getTarget(application, r.URL.Query().Get("target"))
-
-	var deployments []*models.Deployment
 
+	filter, err := parseDeploymentFilter(application, r.URL.Query(), currentUser.Preferences.Location())
 	if err != nil {
-		deployments, err = getAllApplicationDeployments(db, application)
-	} else {
-		deployments, err = getApplicationDeploymentsByTarget(db, application, target)
+		http.Error(w, err.Error(), 422)
+		return
 	}
+	target, _ := getTarget(application, filter.TargetName)
 
+	deployments, err := getFilteredApplicationDeployments(db, application, filter)
 	if err != nil {
-		log.Println("error loading deployments", err)
+		requestLogger(r).Error("error loading deployments", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	err = loadDeploymentsUsers(db, deployments)
 	if err != nil {
-		log.Println("error loading the users of the deployments", err)
+		requestLogger(r).Error("error loading the users of the deployments", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "deployments.tmpl", map[string]interface{}{
-		"Applications":   config.Applications,
-		"Application":    application,
-		"Deployments":    deployments,
-		"currentUser":    currentUser,
-		"selectedTarget": target,
+	renderTemplate(w, r, "deployments.tmpl", map[string]interface{}{
+		"Applications":     getConfig().Applications,
+		"Application":      application,
+		"Deployments":      deployments,
+		"DeploymentStates": models.DeploymentStates,
+		"Query":            r.URL.Query(),
+		"currentUser":      currentUser,
+		"selectedTarget":   target,
 	})
 }
 
@@ -352,97 +699,246 @@ func deploymentHandler(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	id, err := strconv.Atoi(vars["deploymentId"])
 	if err != nil {
-		log.Println("error converting ID passed to server", err)
+		requestLogger(r).Error("error converting ID passed to server", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	deployment, err := getDeployment(db, id)
 	if err != nil {
-		log.Println("error loading deployment", err)
+		requestLogger(r).Error("error loading deployment", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	deploymentUser, err := getUser(db, deployment.UserId)
 	if err != nil {
-		log.Println("error loading deployment user", err)
+		requestLogger(r).Error("error loading deployment user", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 	deployment.User = deploymentUser
 
-	logEntries, err := getDeploymentLogEntries(db, deployment)
+	err = loadDeploymentInitiator(db, deployment)
 	if err != nil {
-		log.Println("error loading logentries", err)
+		requestLogger(r).Error("error loading deployment initiator", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	renderTemplate(w, "deployment.tmpl", map[string]interface{}{
-		"Applications": config.Applications,
-		"Application":  application,
-		"Deployment":   deployment,
-		"LogEntries":   logEntries,
-		"currentUser":  currentUser,
-		"Host":         r.Host,
-	})
-}
-
-func deploymentWsHandler(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id, err := strconv.Atoi(vars["deploymentId"])
+	err = loadDeploymentOverrides(db, deployment)
 	if err != nil {
-		log.Println("error converting ID passed to server", err)
+		requestLogger(r).Error("error loading deployment overrides", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	deployment, err := getDeployment(db, id)
+	err = loadDeploymentChangelog(db, deployment)
 	if err != nil {
-		log.Println("error loading deployment", err)
+		requestLogger(r).Error("error loading deployment changelog", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	upgrader := &websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
-	ws, err := upgrader.Upgrade(w, r, nil)
+	outputAfter, err := deploymentLogOutputAfter(deployment)
 	if err != nil {
-		log.Println("error upgrading the connection to websocket", err)
+		requestLogger(r).Error("error counting logentries", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	go keepWsAlive(ws)
+	// The rows of the progress matrix, filled by applikatoni.js
+	var hosts []*models.Host
+	// Only the deployment whose commit is deployed can be rolled back
+	var rb *rollback
+	if target, err := findTarget(application, deployment.TargetName); err == nil {
+		hosts = target.DeploymentHosts(deployment)
 
-	doneStreaming := make(chan struct{})
+		if deployment.State == models.DEPLOYMENT_SUCCESSFUL && application.CanDeploy(target, currentUser) {
+			rb, err = getRollback(application, target)
+			if err != nil {
+				requestLogger(r).Error("error loading the rollback", "err", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if rb != nil && rb.Current.Id != deployment.Id {
+				rb = nil
+			}
+		}
+	}
 
-	err = logRouter.Subscribe(id, makeWebsocketListener(ws, doneStreaming))
-	if err == deploy.ErrNoDeployment {
+	// Failed deployments can be resumed from the first stage that didn't
+	// succeed, e.g. after they were interrupted by a restart
+	var resumeFrom models.DeploymentStage
+	if deployment.State == models.DEPLOYMENT_FAILED {
 		logEntries, err := getDeploymentLogEntries(db, deployment)
 		if err != nil {
-			log.Println("error loading logentries", err)
+			requestLogger(r).Error("error loading logentries", "err", err)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		completed, _ := stageProgress(logEntries)
+		resumeFrom = resumeStage(deployment, completed)
+	}
+
+	renderTemplate(w, r, "deployment.tmpl", map[string]interface{}{
+		"Applications":   getConfig().Applications,
+		"Application":    application,
+		"Deployment":     deployment,
+		"LogOutputAfter": outputAfter,
+		"Hosts":          hosts,
+		"Rollback":       rb,
+		"ResumeFrom":     resumeFrom,
+		"QueuePosition":  deploymentQueue.Position(deployment.Id),
+		"currentUser":    currentUser,
+		"Host":           r.Host,
+	})
+}
+
+// apiTokenHandler redirects to the API token on the profile page, where it
+// used to have a page of its own.
+func apiTokenHandler(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/user/profile#api-token", http.StatusMovedPermanently)
+}
+
+func regenerateApiTokenHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	err := regenerateApiToken(db, currentUser)
+	if err != nil {
+		requestLogger(r).Error("error regenerating API token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, currentUser, models.AUDIT_API_TOKEN_REGENERATE, currentUser.Name)
+
+	addFlash(w, r, "Your API token has been regenerated.")
+	http.Redirect(w, r, "/user/profile#api-token", http.StatusSeeOther)
+}
+
+func revokeApiTokenHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
 
-		go streamLogEntries(ws, doneStreaming, logEntries)
+	err := revokeApiToken(db, currentUser)
+	if err != nil {
+		requestLogger(r).Error("error revoking API token", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, currentUser, models.AUDIT_API_TOKEN_REVOKE, currentUser.Name)
+
+	addFlash(w, r, "Your API token has been revoked.")
+	http.Redirect(w, r, "/user/profile#api-token", http.StatusSeeOther)
+}
+
+func adminUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := getAllUsers(db)
+	if err != nil {
+		requestLogger(r).Error("error loading users", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
 
-	<-doneStreaming
-	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
-	ws.WriteMessage(websocket.CloseMessage, closeMsg)
-	ws.Close()
+	renderTemplate(w, r, "admin_users.tmpl", map[string]interface{}{
+		"Applications": getConfig().Applications,
+		"Users":        users,
+		"currentUser":  getCurrentUser(r),
+	})
+}
+
+func deactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	changeUserActivation(w, r, deactivateUser, models.AUDIT_USER_DEACTIVATE)
+}
+
+func reactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	changeUserActivation(w, r, reactivateUser, models.AUDIT_USER_REACTIVATE)
+}
+
+func changeUserActivation(w http.ResponseWriter, r *http.Request, change func(*sql.DB, *models.User) error, action models.AuditAction) {
+	userId, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	if userId == getCurrentUser(r).Id {
+		http.Error(w, "you can't deactivate yourself", http.StatusBadRequest)
+		return
+	}
+
+	user, err := getUser(db, userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	err = change(db, user)
+	if err != nil {
+		requestLogger(r).Error("error changing activation of user", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, getCurrentUser(r), action, user.Name)
+
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// revokeUserSessionsHandler logs the user out everywhere, e.g. when their
+// laptop has been lost. Unlike deactivating, the user can log in again.
+func revokeUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userId, err := strconv.Atoi(mux.Vars(r)["userId"])
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	user, err := getUser(db, userId)
+	if err != nil {
+		http.Error(w, "user not found", http.StatusNotFound)
+		return
+	}
+
+	err = deleteUserSessions(db, user.Id)
+	if err != nil {
+		requestLogger(r).Error("error deleting the sessions of user", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, getCurrentUser(r), models.AUDIT_SESSIONS_REVOKE, user.Name)
+
+	addFlash(w, r, "All sessions of %s have been logged out.", user.Name)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 }
 
 func oauth2authorizeHandler(w http.ResponseWriter, r *http.Request) {
-	url := oauthCfg.AuthCodeURL(config.Oauth2StateString)
+	_, chosen := mux.Vars(r)["provider"]
+	provider := requestAuthProvider(r)
+	if provider == nil && samlProvider != nil && len(authProviders) == 0 {
+		http.Redirect(w, r, "/saml/login", http.StatusFound)
+		return
+	}
+	if provider == nil || (!chosen && len(authProviders) > 1) {
+		// Let the user choose between the login buttons
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	url := provider.OAuth2Config().AuthCodeURL(getConfig().Oauth2StateString)
 	http.Redirect(w, r, url, http.StatusFound)
 }
 
 func oauth2callbackHandler(w http.ResponseWriter, r *http.Request) {
+	provider := requestAuthProvider(r)
+	if provider == nil {
+		requestLogger(r).Warn("oauth2 callback for unknown provider")
+		http.Error(w, "unknown login provider", http.StatusNotFound)
+		return
+	}
+
 	// Check if state is the same as our saved state string
 	state := r.FormValue("state")
-	if state != config.Oauth2StateString {
-		log.Println("oauth2 state string does not match")
+	if state != getConfig().Oauth2StateString {
+		requestLogger(r).Warn("oauth2 state string does not match")
+		loginFailed(r, "oauth2 callback")
 		http.Error(w, "oauth2 state string does not match", http.StatusInternalServerError)
 		return
 	}
@@ -451,55 +947,235 @@ func oauth2callbackHandler(w http.ResponseWriter, r *http.Request) {
 	code := r.FormValue("code")
 
 	// Exchange the received code for a token
-	token, err := oauthCfg.Exchange(oauth2.NoContext, code)
+	token, err := provider.OAuth2Config().Exchange(oauth2.NoContext, code)
 	if err != nil {
-		log.Println("could not exchange code for access token", err)
+		requestLogger(r).Error("could not exchange code for access token", "err", err)
+		loginFailed(r, "oauth2 callback")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	user := &models.User{AccessToken: token.AccessToken}
-	ghClient := NewGitHubClient(user)
-	err = ghClient.UpdateUser(user)
+	user, err := provider.FetchUser(token)
 	if err != nil {
-		log.Println("could not fetch user information from github: ", err)
+		requestLogger(r).Error("could not fetch user information", "provider", provider.Name(), "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	setUserToken(user, token)
 
-	err = createOrUpdateUser(db, user)
+	if !isAllowedUser(user) {
+		requestLogger(r).Warn("login refused, not a member of the github_organizations", "user", user.Name)
+		http.Error(w, "not a member of the required GitHub organizations", http.StatusForbidden)
+		return
+	}
+
+	logInUser(w, r, user)
+}
+
+// logInUser saves the user and its groups (if the provider reported any) and
+// starts a session for it.
+func logInUser(w http.ResponseWriter, r *http.Request, user *models.User) {
+	err := createOrUpdateUser(db, user)
 	if err != nil {
-		log.Println("insertUser failed", err)
+		requestLogger(r).Error("insertUser failed", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if user.IsDeactivated() {
+		http.Error(w, "your account has been deactivated", http.StatusForbidden)
+		return
+	}
+
+	if user.Groups != nil {
+		err = setUserGroups(db, user)
+		if err != nil {
+			requestLogger(r).Error("saving the groups of the user failed", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	ttl, _ := getConfig().SessionTimeout()
+	err = deleteExpiredUserSessions(db, ttl)
+	if err != nil {
+		requestLogger(r).Error("deleting expired sessions failed", "err", err)
+	}
+
+	userSession := &models.UserSession{UserId: user.Id, SourceIP: remoteIP(r), UserAgent: r.UserAgent()}
+	err = createUserSession(db, userSession)
+	if err != nil {
+		requestLogger(r).Error("saving the session failed", "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	session, _ := sessionStore.Get(r, sessionName)
 	session.Values["user_id"] = user.Id
+	session.Values["session_id"] = userSession.Id
+	// A token known before the login isn't valid afterwards
+	delete(session.Values, "csrf_token")
 	session.Save(r, w)
 
+	recordAuditEvent(r, user, models.AUDIT_LOGIN, user.Provider)
+
 	http.Redirect(w, r, "/", http.StatusFound)
 }
 
+func samlLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if samlProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	url, requestId, err := samlProvider.AuthenticationRequestURL()
+	if err != nil {
+		requestLogger(r).Error("could not create SAML authentication request", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The response of the IdP has to belong to this request. The IdP posts
+	// its response cross-site, so the id can't be kept in the session cookie.
+	http.SetCookie(w, &http.Cookie{
+		Name:     samlRequestCookie,
+		Value:    requestId,
+		Path:     "/saml/acs",
+		MaxAge:   300,
+		HttpOnly: true,
+		Secure:   getConfig().SSLEnabled,
+		SameSite: http.SameSiteNoneMode,
+	})
+
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func samlACSHandler(w http.ResponseWriter, r *http.Request) {
+	if samlProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	cookie, err := r.Cookie(samlRequestCookie)
+	if err != nil {
+		requestLogger(r).Warn("SAML response without authentication request")
+		http.Error(w, "no SAML authentication request found", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: samlRequestCookie, Path: "/saml/acs", MaxAge: -1})
+
+	user, err := samlProvider.ParseResponse(r, cookie.Value)
+	if err != nil {
+		requestLogger(r).Error("invalid SAML response", "err", err)
+		loginFailed(r, "SAML response")
+		http.Error(w, "invalid SAML response", http.StatusForbidden)
+		return
+	}
+
+	logInUser(w, r, user)
+}
+
+func samlMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	if samlProvider == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	metadata, err := samlProvider.Metadata()
+	if err != nil {
+		requestLogger(r).Error("could not render SAML metadata", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/samlmetadata+xml")
+	w.Write(metadata)
+}
+
+// requestAuthProvider returns the AuthProvider named in the URL of r. The
+// URLs without a provider belong to GitHub, or to the only configured
+// provider.
+func requestAuthProvider(r *http.Request) AuthProvider {
+	name, ok := mux.Vars(r)["provider"]
+	if ok {
+		return findAuthProvider(name)
+	}
+
+	if len(authProviders) == 1 {
+		return authProviders[0]
+	}
+	return findAuthProvider(GITHUB_PROVIDER)
+}
+
 func oauth2logoutHandler(w http.ResponseWriter, r *http.Request) {
+	logOutUser(w, r)
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func logOutUser(w http.ResponseWriter, r *http.Request) {
 	session, _ := sessionStore.Get(r, sessionName)
+
+	userId, _ := session.Values["user_id"].(int)
+	sessionId, _ := session.Values["session_id"].(string)
+	if sessionId != "" {
+		err := deleteUserSession(db, userId, sessionId)
+		if err != nil {
+			requestLogger(r).Error("deleting the session failed", "err", err)
+		}
+	}
+
 	delete(session.Values, "user_id")
+	delete(session.Values, "session_id")
 	session.Save(r, w)
-	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// currentSessionId returns the id of the saved session of the request, empty
+// if it has none.
+func currentSessionId(r *http.Request) string {
+	session, _ := sessionStore.Get(r, sessionName)
+	id, _ := session.Values["session_id"].(string)
+	return id
+}
+
+// requireLogin logs out the user, whose access token has been rejected by
+// the login provider, so that a new token is requested on the next login.
+func requireLogin(w http.ResponseWriter, r *http.Request) {
+	logOutUser(w, r)
+	http.Error(w, "access token invalid, please log in again", http.StatusUnauthorized)
 }
 
 func loadUserFromSession(r *http.Request) (*models.User, error) {
 	session, _ := sessionStore.Get(r, sessionName)
 
-	if id, ok := session.Values["user_id"].(int); ok {
-		user, err := getUser(db, id)
+	id, ok := session.Values["user_id"].(int)
+	if !ok {
+		return nil, nil
+	}
+
+	// Sessions that have been logged out on the profile page have been
+	// deleted, so the cookie isn't enough
+	ttl, _ := getConfig().SessionTimeout()
+	sessionId, _ := session.Values["session_id"].(string)
+	userSession, err := getUserSession(db, sessionId, ttl)
+	if err != nil {
+		return nil, err
+	}
+	if userSession == nil || userSession.UserId != id {
+		return nil, nil
+	}
+
+	if time.Since(userSession.LastSeenAt) > userSessionTouchInterval {
+		err = touchUserSession(db, userSession, remoteIP(r))
 		if err != nil {
 			return nil, err
 		}
-		return user, nil
 	}
 
-	return nil, nil
+	user, err := getUser(db, id)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
 }
 
 func loadUserWithApiToken(r *http.Request) (*models.User, error) {
@@ -508,14 +1184,94 @@ func loadUserWithApiToken(r *http.Request) (*models.User, error) {
 		return nil, nil
 	}
 
+	if until := loginLockedOut(r); !until.IsZero() {
+		return nil, errLoginLockedOut
+	}
+
 	user, err := getUserByApiToken(db, token)
+	if err == sql.ErrNoRows {
+		loginFailed(r, "API token")
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	err = touchApiToken(db, user)
+	if err != nil {
+		requestLogger(r).Error("could not save last usage of API token", "err", err)
+	}
+
 	return user, nil
 }
 
+// isApiTokenRequest checks whether the current user authenticated with an API
+// token instead of a session.
+func isApiTokenRequest(r *http.Request) bool {
+	_, ok := context.GetOk(r, ApiTokenRequest)
+	return ok
+}
+
+// newDeploymentInitiator describes the system creating a deployment with an
+// API token. CI pipelines can pass their build and the person they deploy for.
+func newDeploymentInitiator(r *http.Request, u *models.User) *models.DeploymentInitiator {
+	tokenName := "personal API token"
+	if u.ServiceAccount != nil {
+		tokenName = u.ServiceAccount.Name
+	}
+
+	sourceIP := remoteIP(r)
+
+	return &models.DeploymentInitiator{
+		TokenName:   tokenName,
+		SourceIP:    sourceIP,
+		BuildURL:    r.FormValue("build_url"),
+		BuildNumber: r.FormValue("build_number"),
+		OnBehalfOf:  r.FormValue("on_behalf_of"),
+	}
+}
+
+// overrideValuePattern matches the values variables can be overridden with.
+// The scripts get them unquoted, so characters the shell interprets, like ;,
+// | or $, aren't allowed.
+var overrideValuePattern = regexp.MustCompile(`^[A-Za-z0-9._:/@+=,-]*$`)
+
+// parseOverrides reads the variables overridden for a deployment from lines
+// like "MIGRATION_TIMEOUT=600". Only the OverridableVariables of the target
+// can be overridden. Returns nil if there are no overrides.
+func parseOverrides(t *models.Target, text string) (map[string]string, error) {
+	var overrides map[string]string
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid override %q, use NAME=value", line)
+		}
+		if !t.IsOverridable(name) {
+			return nil, fmt.Errorf("%s can't be overridden on %s. Overridable variables: %v", name, t.Name, t.OverridableVariables)
+		}
+		if _, ok := overrides[name]; ok {
+			return nil, fmt.Errorf("%s is overridden twice", name)
+		}
+		value = strings.TrimSpace(value)
+		if !overrideValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("invalid value of %s, only letters, digits and . _ : / @ + = , - are allowed", name)
+		}
+
+		if overrides == nil {
+			overrides = make(map[string]string)
+		}
+		overrides[name] = value
+	}
+
+	return overrides, nil
+}
+
 func getCurrentUser(r *http.Request) *models.User {
 	u := context.Get(r, CurrentUser)
 	if u != nil {
@@ -542,7 +1298,7 @@ func getTarget(a *models.Application, t string) (*models.Target, error) {
 }
 
 func findApplication(name string) (*models.Application, error) {
-	for _, a := range config.Applications {
+	for _, a := range getConfig().Applications {
 		if a.Name == name {
 			return a, nil
 		}
@@ -563,48 +1319,8 @@ func deploymentUrl(a *models.Application, d *models.Deployment) string {
 	return fmt.Sprintf("/%s/deployments/%d", a.Name, d.Id)
 }
 
-func makeWebsocketListener(ws *websocket.Conn, done chan struct{}) deploy.Listener {
-	return func(logs <-chan deploy.LogEntry) {
-		defer func() {
-			done <- struct{}{}
-		}()
-		for entry := range logs {
-			err := ws.WriteJSON(entry)
-			if err != nil {
-				log.Printf("error writing to websocket: %s. (remote address=%s)\n", err, ws.RemoteAddr())
-				return
-			}
-		}
-	}
-}
-
-func streamLogEntries(ws *websocket.Conn, done chan struct{}, logs []*deploy.LogEntry) {
-	defer func() {
-		done <- struct{}{}
-	}()
-	for _, entry := range logs {
-		err := ws.WriteJSON(entry)
-		if err != nil {
-			return
-		}
-	}
-}
-
 func isValidCommitSha(sha string) bool {
 	validSha := regexp.MustCompile(`^[0-9a-f]{40}$`)
 
 	return validSha.MatchString(sha)
 }
-
-func keepWsAlive(ws *websocket.Conn) {
-	// We repeatedly read from the websocket connections and discard
-	// the reader in order to process the underlying ping/pong messages
-	// of the websocket connection
-	for {
-		_, _, err := ws.NextReader()
-		if err != nil {
-			ws.Close()
-			break
-		}
-	}
-}
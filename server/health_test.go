@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestHealthzHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	healthzHandler(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	if rec.Code != 200 || rec.Body.String() != "ok\n" {
+		t.Errorf("wrong response. want=200 ok, got=%d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestReadyzHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	key := encryptedTestKey(t, "s3cret")
+
+	tests := []struct {
+		passphrase     string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{"s3cret", 200, `{"checks":{"database":"ok","migrations":"ok","ssh_keys":"ok"},"status":"OK"}`},
+		{"wrong", 503, `{"checks":{"database":"ok","migrations":"ok","ssh_keys":"failed"},"status":"Service Unavailable"}`},
+	}
+
+	for _, tt := range tests {
+		setConfig(&Configuration{Applications: []*models.Application{
+			{Name: "web", Targets: []*models.Target{
+				{Name: "staging", DeploymentSshKey: key, SshKeyPassphrase: tt.passphrase},
+				{Name: "production", DeploymentSshKey: key, SshKeyPassphrase: tt.passphrase},
+			}},
+		}})
+
+		rec := httptest.NewRecorder()
+		readyzHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for passphrase %s. want=%d, got=%d", tt.passphrase, tt.expectedStatus, rec.Code)
+		}
+		if body := strings.TrimSpace(rec.Body.String()); body != tt.expectedBody {
+			t.Errorf("wrong body for passphrase %s.\nwant=%s\ngot=%s", tt.passphrase, tt.expectedBody, body)
+		}
+	}
+	setConfig(&Configuration{})
+}
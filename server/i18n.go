@@ -0,0 +1,386 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is used if neither the user nor the browser prefer one of
+// the supported languages. Its messages are the keys of the translations.
+const defaultLanguage = "en"
+
+// languages are the supported languages with their names in the language.
+var languages = []struct {
+	Code string
+	Name string
+}{
+	{"en", "English"},
+	{"de", "Deutsch"},
+}
+
+// translations maps the messages of the default language to the messages of
+// the other languages. Messages without a translation are shown in English.
+var translations = map[string]map[string]string{
+	"de": {
+		// Layout
+		"Users":                "Benutzer",
+		"Applications":         "Anwendungen",
+		"Audit log":            "Audit-Log",
+		"Deliveries":           "Zustellungen",
+		"Active deployments":   "Laufende Deployments",
+		"Approvals":            "Freigaben",
+		"Profile":              "Profil",
+		"Preferences":          "Einstellungen",
+		"Log out":              "Abmelden",
+		"Login With %s":        "Anmelden mit %s",
+		"Deployments Al Forno": "Deployments Al Forno",
+
+		// Preferences
+		"Theme":                 "Design",
+		"Light":                 "Hell",
+		"Dark":                  "Dunkel",
+		"Language":              "Sprache",
+		"Browser language (%s)": "Sprache des Browsers (%s)",
+		"Time zone":             "Zeitzone",
+		"Server time zone (%s)": "Zeitzone des Servers (%s)",
+		"An IANA time zone like <code>Europe/Berlin</code>, used for timestamps and the days of the calendar and the filters.": "Eine IANA-Zeitzone wie <code>Europe/Berlin</code>, für Zeitangaben und die Tage des Kalenders und der Filter.",
+		"Default application": "Standard-Anwendung",
+		"None":                "Keine",
+		"Shown instead of the list of applications after logging in.": "Wird nach dem Anmelden statt der Liste der Anwendungen angezeigt.",
+		"Save": "Speichern",
+
+		// Profile
+		"Logged in with %s": "Angemeldet mit %s",
+		"API Token":         "API-Token",
+		"The API token is used by <code>toni</code> and other clients to deploy in your name. Regenerate it if it leaked, the old token stops working immediately.": "Das API-Token wird von <code>toni</code> und anderen Clients verwendet, um in deinem Namen zu deployen. Erzeuge es neu, falls es bekannt geworden ist, das alte Token wird sofort ungültig.",
+		"Token":      "Token",
+		"Revoked":    "Widerrufen",
+		"Created":    "Erstellt",
+		"Unknown":    "Unbekannt",
+		"Last used":  "Zuletzt verwendet",
+		"Never":      "Nie",
+		"Regenerate": "Neu erzeugen",
+		"Revoke":     "Widerrufen",
+		"Sessions":   "Sitzungen",
+		"The browsers you're logged in with. Log out sessions you don't recognize and regenerate your API token.": "Die Browser, in denen du angemeldet bist. Melde Sitzungen ab, die du nicht kennst, und erzeuge dein API-Token neu.",
+		"Log out all other sessions": "Alle anderen Sitzungen abmelden",
+		"Log out everywhere":         "Überall abmelden",
+		"Browser":                    "Browser",
+		"IP":                         "IP",
+		"Logged in":                  "Angemeldet",
+		"Last seen":                  "Zuletzt gesehen",
+		"This session":               "Diese Sitzung",
+		"Notifications":              "Benachrichtigungen",
+		"Desktop notifications about my deployments": "Desktop-Benachrichtigungen zu meinen Deployments",
+		"When they fail":   "Wenn sie fehlschlagen",
+		"When they finish": "Wenn sie beendet sind",
+		"Shown while the page of the deployment is open, e.g. in a background tab.": "Werden angezeigt, solange die Seite des Deployments geöffnet ist, z.B. in einem Tab im Hintergrund.",
+		"My last deployments":                "Meine letzten Deployments",
+		"Deployment":                         "Deployment",
+		"Commit":                             "Commit",
+		"Comment":                            "Kommentar",
+		"State":                              "Status",
+		"You haven't deployed anything yet.": "Du hast noch nichts deployt.",
+
+		// Digests
+		"Digests": "Digests",
+		"Get an email with the deployments of an application when its digest is sent. Every email has a link to unsubscribe.": "Erhalte eine E-Mail mit den Deployments einer Anwendung, wenn ihr Digest verschickt wird. Jede E-Mail enthält einen Link zum Abbestellen.",
+		"Email address":                             "E-Mail-Adresse",
+		"Subscribe":                                 "Abonnieren",
+		"Unsubscribe":                               "Abbestellen",
+		"Unsubscribe from digests":                  "Digests abbestellen",
+		"Stop sending the digests of %s to %s?":     "Die Digests von %s nicht mehr an %s schicken?",
+		"%s doesn't get the digests of %s anymore.": "%s erhält die Digests von %s nicht mehr.",
+		"This link is no longer valid, you have already been unsubscribed.": "Dieser Link ist nicht mehr gültig, du hast die Digests bereits abbestellt.",
+
+		// Approvals
+		"Waiting for approval": "Warten auf Freigabe",
+		"Deployments paused in a pause stage until a deployer of their target continues or rejects them. Rejected deployments fail and aren't retried.": "Deployments, die in einer Pause-Stage warten, bis jemand, der auf ihr Ziel deployen darf, sie fortsetzt oder ablehnt. Abgelehnte Deployments schlagen fehl und werden nicht wiederholt.",
+		"Deployments waiting in the SECOND_APPROVAL stage only start once a second deployer approves them.":                                             "Deployments in der SECOND_APPROVAL-Stage starten erst, wenn eine zweite Person, die deployen darf, sie freigibt.",
+		"Requested by":                       "Angefordert von",
+		"Stage":                              "Stage",
+		"Waiting since":                      "Wartet seit",
+		"Approve":                            "Freigeben",
+		"Reject":                             "Ablehnen",
+		"Reject deployment #%d of %s to %s?": "Deployment #%d von %s nach %s ablehnen?",
+		"No deployments are waiting for approval.": "Keine Deployments warten auf Freigabe.",
+		// Application
+		"Search deployments":      "Deployments durchsuchen",
+		"Targets":                 "Ziele",
+		"Calendar":                "Kalender",
+		"Dashboard":               "Dashboard",
+		"View .toni.yml":          ".toni.yml ansehen",
+		"New Deployment":          "Neues Deployment",
+		"Redeploy of":             "Erneutes Deployment von",
+		"from stage %s":           "ab Stage %s",
+		"What are you deploying?": "Was deployst du?",
+		"Deploy!":                 "Deployen!",
+		"Preset name":             "Name der Vorlage",
+		"Save as preset":          "Als Vorlage speichern",
+		"Saves the target, branch, stages and overrides, and the comment as the comment prefix.": "Speichert das Ziel, den Branch, die Stages und die Überschreibungen, und den Kommentar als Präfix der Kommentare.",
+		"Preset":                                 "Vorlage",
+		"Target":                                 "Ziel",
+		"Commit SHA":                             "Commit-SHA",
+		"Branch":                                 "Branch",
+		"Tag":                                    "Tag",
+		"Pull request":                           "Pull-Request",
+		"Number, sets commit and branch":         "Nummer, setzt Commit und Branch",
+		"Override the deploy freeze":             "Den Deploy-Freeze übergehen",
+		"Deploy window override":                 "Deploy-Fenster übergehen",
+		"Why deploy outside the deploy windows?": "Warum außerhalb der Deploy-Fenster deployen?",
+		"CI override":                            "CI übergehen",
+		"Why deploy without passing CI?":         "Warum ohne erfolgreiche CI deployen?",
+		"Overrides":                              "Überschreibungen",
+		"NAME=value, one per line":               "NAME=Wert, einer pro Zeile",
+		"Show advanced options":                  "Erweiterte Optionen anzeigen",
+		"Stages":                                 "Stages",
+		"Deploy Freeze":                          "Deploy-Freeze",
+		"All targets":                            "Alle Ziele",
+		"Why freeze deployments?":                "Warum Deployments einfrieren?",
+		"Freeze":                                 "Einfrieren",
+		"Deploy Presets":                         "Deploy-Vorlagen",
+		"Name":                                   "Name",
+		"Comment prefix":                         "Kommentar-Präfix",
+		"All":                                    "Alle",
+		"Delete":                                 "Löschen",
+		"Live Host Groups":                       "Live-Host-Gruppen",
+		"Live":                                   "Live",
+		"Next deployment goes to":                "Nächstes Deployment geht an",
+		"Open Pull Requests":                     "Offene Pull-Requests",
+		"User":                                   "Benutzer",
+		"Description":                            "Beschreibung",
+		"Last update":                            "Letzte Änderung",
+		"CI status":                              "CI-Status",
+		"Actions":                                "Aktionen",
+		"Branches and tags are cached, load them from the repository again": "Branches und Tags werden zwischengespeichert, lade sie neu aus dem Repository",
+		"Reload":              "Neu laden",
+		"Branches":            "Branches",
+		"Last commit":         "Letzter Commit",
+		"Last 10 Deployments": "Letzte 10 Deployments",
+		"See all":             "Alle anzeigen",
+
+		// Deployments
+		"Deployed at":                       "Deployt am",
+		"Position in the queue":             "Position in der Warteschlange",
+		"Automatic retry of deployment #%d": "Automatische Wiederholung von Deployment #%d",
+		"retry":                             "Wiederholung",
+		"Redeploy of deployment #%d":        "Erneutes Deployment von Deployment #%d",
+		"redeploy":                          "erneut",
+		"View":                              "Ansehen",
+		"All states":                        "Alle Status",
+		"From":                              "Von",
+		"To":                                "Bis",
+		"Newest first":                      "Neueste zuerst",
+		"Oldest first":                      "Älteste zuerst",
+		"Filter":                            "Filtern",
+		"%s Deployments":                    "Deployments von %s",
+		"Export the filtered deployments":   "Die gefilterten Deployments exportieren",
+		"Export CSV":                        "Als CSV exportieren",
+		"Export JSON":                       "Als JSON exportieren",
+
+		// Deployment
+		"Deployment #%d": "Deployment #%d",
+		"Deploy the same commit with the same stages again": "Denselben Commit mit denselben Stages erneut deployen",
+		"Redeploy": "Erneut deployen",
+		"Deploy the same commit with the stages from %s on": "Denselben Commit mit den Stages ab %s deployen",
+		"Resume from %s":                   "Ab %s fortsetzen",
+		"Roll back to previous successful": "Auf das vorherige erfolgreiche zurücksetzen",
+		"position %d in the queue":         "Position %d in der Warteschlange",
+		"Deployed":                         "Deployt",
+		"Host group":                       "Host-Gruppe",
+		"Automatic retry of":               "Automatische Wiederholung von",
+		"CI overridden":                    "CI übergangen",
+		"Request ID":                       "Request-ID",
+		"Triggered by":                     "Ausgelöst von",
+		"on behalf of %s":                  "im Auftrag von %s",
+		"from %s":                          "von %s",
+		"Changelog (%d commits since the last deployment)": "Änderungen (%d Commits seit dem letzten Deployment)",
+		"All hosts":               "Alle Hosts",
+		"All entries":             "Alle Einträge",
+		"Only failures":           "Nur Fehler",
+		"Only output":             "Nur Ausgaben",
+		"Download log:":           "Log herunterladen:",
+		"Text":                    "Text",
+		"Text (gzip)":             "Text (gzip)",
+		"Host":                    "Host",
+		"Show only the log of %s": "Nur das Log von %s anzeigen",
+		"Load earlier output":     "Frühere Ausgaben laden",
+		"KILL!":                   "ABBRECHEN!",
+		"CONTINUE":                "FORTSETZEN",
+		"REJECT":                  "ABLEHNEN",
+		"REMOVE FROM QUEUE":       "AUS DER WARTESCHLANGE ENTFERNEN",
+
+		// Targets
+		"%s Targets": "Ziele von %s",
+		"Could not compare the targets with <code>%s</code>: %s": "Die Ziele konnten nicht mit <code>%s</code> verglichen werden: %s",
+		"Deployed commit":                       "Deployter Commit",
+		"Deployed by":                           "Deployt von",
+		"Compared with <code>%s</code>":         "Verglichen mit <code>%s</code>",
+		"Drift":                                 "Abweichung",
+		"Latest deployment":                     "Letztes Deployment",
+		"Never deployed":                        "Nie deployt",
+		"up to date":                            "aktuell",
+		"Commits of %s that aren't deployed":    "Commits von %s, die nicht deployt sind",
+		"%d behind":                             "%d zurück",
+		"Deployed commits that aren't on %s":    "Deployte Commits, die nicht auf %s sind",
+		"%d ahead":                              "%d voraus",
+		"by %s":                                 "von %s",
+		"by user #%d":                           "von Benutzer #%d",
+		"Roll back":                             "Zurücksetzen",
+		"Roll back %s?":                         "%s zurücksetzen?",
+		"Deployed now":                          "Aktuell deployt",
+		"Rolls back to":                         "Zurück auf",
+		"Why roll back? (optional)":             "Warum zurücksetzen? (optional)",
+		"Cancel":                                "Abbrechen",
+		"Roll back to %.7s":                     "Auf %.7s zurücksetzen",
+		"Unfreeze":                              "Auftauen",
+		"Deployments to %s are frozen":          "Deployments nach %s sind eingefroren",
+		"Deployments to all targets are frozen": "Deployments auf alle Ziele sind eingefroren",
+
+		// Dashboard
+		"%s Deployments in the last %d days": "Deployments von %s in den letzten %d Tagen",
+		"%d days":                            "%d Tage",
+		"successful deployments per day":     "erfolgreiche Deployments pro Tag",
+		"change failure rate":                "Fehlerquote der Änderungen",
+		"mean time to restore":               "mittlere Zeit bis zur Wiederherstellung",
+		"average duration":                   "durchschnittliche Dauer",
+		"Successful":                         "Erfolgreich",
+		"Failed":                             "Fehlgeschlagen",
+		"Per day":                            "Pro Tag",
+		"Change failure rate":                "Fehlerquote der Änderungen",
+		"Mean time to restore":               "Mittlere Zeit bis zur Wiederherstellung",
+		"Average duration":                   "Durchschnittliche Dauer",
+
+		// Flash messages
+		"Your preferences have been saved.":        "Deine Einstellungen wurden gespeichert.",
+		"Your notifications have been saved.":      "Deine Benachrichtigungen wurden gespeichert.",
+		"Your API token has been regenerated.":     "Dein API-Token wurde neu erzeugt.",
+		"Your API token has been revoked.":         "Dein API-Token wurde widerrufen.",
+		"The session has been logged out.":         "Die Sitzung wurde abgemeldet.",
+		"All other sessions have been logged out.": "Alle anderen Sitzungen wurden abgemeldet.",
+		"You have been logged out everywhere.":     "Du wurdest überall abgemeldet.",
+		"All sessions of %s have been logged out.": "Alle Sitzungen von %s wurden abgemeldet.",
+		"Deployments to %s have been frozen.":      "Deployments nach %s wurden eingefroren.",
+		"Deployments to %s are no longer frozen.":  "Deployments nach %s sind nicht mehr eingefroren.",
+		"Preset %s has been saved.":                "Vorlage %s wurde gespeichert.",
+		"Preset %s has been deleted.":              "Vorlage %s wurde gelöscht.",
+		"Application %s has been saved.":           "Anwendung %s wurde gespeichert.",
+		"Application %s has been deleted.":         "Anwendung %s wurde gelöscht.",
+		"Delivery %d has been retried.":            "Zustellung %d wurde wiederholt.",
+		"Retrying delivery %d failed: %s":          "Wiederholen der Zustellung %d fehlgeschlagen: %s",
+		"all targets":                              "alle Ziele",
+
+		"Deployment %d of %s to %s is being canceled.":                      "Deployment %d von %s nach %s wird abgebrochen.",
+		"Deployment %d can't be canceled, it's not running on this server.": "Deployment %d kann nicht abgebrochen werden, es läuft nicht auf diesem Server.",
+
+		"The digests of %s are sent to %s.":                       "Die Digests von %s werden an %s geschickt.",
+		"You have been unsubscribed from the digests of %s.":      "Du hast die Digests von %s abbestellt.",
+		"A new calendar feed link has been created.":              "Ein neuer Link für den Kalender-Feed wurde erstellt.",
+		"The branches and tags are reloaded from the repository.": "Die Branches und Tags werden neu aus dem Repository geladen.",
+	},
+}
+
+// translate returns the message in the language, formatted with the args if
+// there are any.
+func translate(language, message string, args ...interface{}) string {
+	if translated, ok := translations[language][message]; ok {
+		message = translated
+	}
+	if len(args) == 0 {
+		return message
+	}
+	return fmt.Sprintf(message, args...)
+}
+
+func isSupportedLanguage(code string) bool {
+	for _, l := range languages {
+		if l.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateLanguage picks the supported language the Accept-Language header
+// prefers most, the default language if there is none. Regional variants
+// like de-AT count for their language.
+func negotiateLanguage(header string) string {
+	type preference struct {
+		code    string
+		quality float64
+	}
+	preferences := []preference{}
+
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		code := strings.ToLower(strings.TrimSpace(fields[0]))
+		if i := strings.Index(code, "-"); i != -1 {
+			code = code[:i]
+		}
+		if !isSupportedLanguage(code) {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				if err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			preferences = append(preferences, preference{code, quality})
+		}
+	}
+
+	if len(preferences) == 0 {
+		return defaultLanguage
+	}
+	sort.SliceStable(preferences, func(i, j int) bool {
+		return preferences[i].quality > preferences[j].quality
+	})
+	return preferences[0].code
+}
+
+// requestLanguage returns the language the response to the request is shown
+// in: the one chosen in the preferences of the current user, otherwise the one
+// preferred by the browser.
+func requestLanguage(r *http.Request) string {
+	if u := getCurrentUser(r); u != nil && isSupportedLanguage(u.Preferences.Language) {
+		return u.Preferences.Language
+	}
+	return negotiateLanguage(r.Header.Get("Accept-Language"))
+}
+
+// addFlash stores a message shown once on the next rendered page, translated
+// to the language of the request.
+func addFlash(w http.ResponseWriter, r *http.Request, message string, args ...interface{}) {
+	session, _ := sessionStore.Get(r, sessionName)
+	session.AddFlash(translate(requestLanguage(r), message, args...))
+	session.Save(r, w)
+}
+
+// takeFlashes returns the stored flash messages and removes them from the
+// session.
+func takeFlashes(w http.ResponseWriter, r *http.Request) []string {
+	session, _ := sessionStore.Get(r, sessionName)
+	flashes := session.Flashes()
+	if len(flashes) == 0 {
+		return nil
+	}
+	session.Save(r, w)
+
+	messages := make([]string, 0, len(flashes))
+	for _, f := range flashes {
+		if m, ok := f.(string); ok {
+			messages = append(messages, m)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"io/fs"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestNegotiateLanguage(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-DE,de;q=0.9,en;q=0.8", "de"},
+		{"en-US,en;q=0.9,de;q=0.8", "en"},
+		{"fr-FR,fr;q=0.9,de;q=0.5", "de"},
+		{"fr, en;q=0.3, de;q=0.7", "de"},
+		{"de;q=0, en;q=0.1", "en"},
+		{"fr, it", "en"},
+		{"DE-at", "de"},
+	}
+
+	for _, tt := range tests {
+		got := negotiateLanguage(tt.header)
+		if got != tt.expected {
+			t.Errorf("wrong language for %q. want=%s, got=%s", tt.header, tt.expected, got)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		language string
+		message  string
+		args     []interface{}
+		expected string
+	}{
+		{"en", "Log out", nil, "Log out"},
+		{"de", "Log out", nil, "Abmelden"},
+		{"de", "Login With %s", []interface{}{"GitHub"}, "Anmelden mit GitHub"},
+		{"de", "Not translated %d", []interface{}{5}, "Not translated 5"},
+		{"xx", "Log out", nil, "Log out"},
+	}
+
+	for _, tt := range tests {
+		got := translate(tt.language, tt.message, tt.args...)
+		if got != tt.expected {
+			t.Errorf("wrong translation of %q to %s. want=%q, got=%q", tt.message, tt.language, tt.expected, got)
+		}
+	}
+}
+
+func TestTranslateHTML(t *testing.T) {
+	got := translateHTML("en", "Deployments to %s have been frozen.", "<b>production</b>")
+	expected := "Deployments to &lt;b&gt;production&lt;/b&gt; have been frozen."
+	if string(got) != expected {
+		t.Errorf("wrong translation. want=%q, got=%q", expected, got)
+	}
+}
+
+func TestTranslationsHaveFormatVerbs(t *testing.T) {
+	for language, messages := range translations {
+		for message, translated := range messages {
+			if countVerbs(message) != countVerbs(translated) {
+				t.Errorf("wrong format verbs in %s translation of %q: %q", language, message, translated)
+			}
+		}
+	}
+}
+
+var templateMessage = regexp.MustCompile(`{{ *tHTML "((?:[^"\\]|\\.)*)"|{{ *t "((?:[^"\\]|\\.)*)"`)
+
+func TestTemplateMessagesAreTranslated(t *testing.T) {
+	paths, err := fs.Glob(embeddedAssets, "assets/templates/*.tmpl")
+	checkErr(t, err)
+
+	for _, path := range paths {
+		content, err := fs.ReadFile(embeddedAssets, path)
+		checkErr(t, err)
+
+		for _, match := range templateMessage.FindAllStringSubmatch(string(content), -1) {
+			message, err := strconv.Unquote(`"` + match[1] + match[2] + `"`)
+			checkErr(t, err)
+			for language, messages := range translations {
+				if _, ok := messages[message]; !ok {
+					t.Errorf("%s: %q isn't translated to %s", path, message, language)
+				}
+			}
+		}
+	}
+}
+
+func countVerbs(s string) int {
+	n := 0
+	for i := 0; i < len(s)-1; i++ {
+		if s[i] == '%' {
+			n++
+			i++
+		}
+	}
+	return n
+}
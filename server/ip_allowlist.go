@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The groups of endpoints ip_allowlists can restrict.
+const (
+	ipAllowlistAPI      = "api"
+	ipAllowlistWebhooks = "webhooks"
+	ipAllowlistAdmin    = "admin"
+)
+
+// parseIPAllowlists parses the CIDR ranges of the ip_allowlists. Single IP
+// addresses are allowed too, e.g. 10.1.2.3 for 10.1.2.3/32.
+func parseIPAllowlists(lists map[string][]string) (map[string][]*net.IPNet, error) {
+	parsed := map[string][]*net.IPNet{}
+
+	for group, ranges := range lists {
+		switch group {
+		case ipAllowlistAPI, ipAllowlistWebhooks, ipAllowlistAdmin:
+		default:
+			return nil, fmt.Errorf("unknown group %q, has to be %s, %s or %s", group, ipAllowlistAPI, ipAllowlistWebhooks, ipAllowlistAdmin)
+		}
+		if len(ranges) == 0 {
+			return nil, fmt.Errorf("%s allows no IPs, leave it out to allow all of them", group)
+		}
+
+		for _, r := range ranges {
+			cidr := r
+			if ip := net.ParseIP(r); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else if ip != nil {
+				cidr += "/128"
+			}
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %q is no IP address or CIDR range", group, r)
+			}
+			parsed[group] = append(parsed[group], network)
+		}
+	}
+
+	return parsed, nil
+}
+
+// IsAllowedIP checks whether the IP may call the endpoints of the group. All
+// IPs are allowed if the group has no allowlist.
+func (c *Configuration) IsAllowedIP(group, ip string) bool {
+	networks, ok := c.ipAllowlists[group]
+	if !ok {
+		return true
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range networks {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// endpointGroup returns the group of the endpoints the request belongs to,
+// empty if it's in none. Requests with an API token count as API requests on
+// every path, e.g. deployments created with POST /{application}/deployments.
+func endpointGroup(r *http.Request) string {
+	path := r.URL.Path
+	switch {
+	case strings.HasPrefix(path, "/api/"), r.Header.Get("X-Api-Token") != "":
+		return ipAllowlistAPI
+	// The commands and buttons of Slack and Teams can start and approve
+	// deployments, like the webhooks
+	case strings.HasPrefix(path, "/slack/"), strings.HasPrefix(path, "/teams/"):
+		return ipAllowlistWebhooks
+	case strings.HasPrefix(path, "/admin/"), strings.HasPrefix(path, "/debug/"):
+		return ipAllowlistAdmin
+	}
+
+	// /{application}/webhooks/github, /{application}/webhooks/ci, ...
+	parts := strings.Split(path, "/")
+	if len(parts) >= 4 && parts[2] == "webhooks" {
+		return ipAllowlistWebhooks
+	}
+	return ""
+}
+
+// allowlistedIPs rejects requests to the API, the webhooks and the admin
+// pages from IPs outside their ip_allowlists with a 403, so a leaked token
+// can't be used from anywhere else.
+func allowlistedIPs(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		group := endpointGroup(r)
+		if group == "" || getConfig().IsAllowedIP(group, remoteIP(r)) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		requestLogger(r).Warn("request from IP outside the allowlist", "group", group, "ip", remoteIP(r))
+		if group == ipAllowlistAPI {
+			renderApiError(w, http.StatusForbidden, "IP address not allowed")
+			return
+		}
+		http.Error(w, "IP address not allowed", http.StatusForbidden)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseIPAllowlists(t *testing.T) {
+	tests := []struct {
+		lists map[string][]string
+		valid bool
+	}{
+		{nil, true},
+		{map[string][]string{"api": {"10.0.0.0/8", "192.168.1.10", "2001:db8::/32", "2001:db8::1"}}, true},
+		{map[string][]string{"webhooks": {"10.0.0.0/33"}}, false},
+		{map[string][]string{"admin": {"office"}}, false},
+		{map[string][]string{"admin": {}}, false},
+		{map[string][]string{"dashboard": {"10.0.0.0/8"}}, false},
+	}
+
+	for _, tt := range tests {
+		_, err := parseIPAllowlists(tt.lists)
+		if (err == nil) != tt.valid {
+			t.Errorf("wrong validation of %v. want valid=%v, got err=%v", tt.lists, tt.valid, err)
+		}
+	}
+}
+
+func TestAllowlistedIPs(t *testing.T) {
+	allowlists, err := parseIPAllowlists(map[string][]string{
+		"api":      {"10.0.0.0/8"},
+		"webhooks": {"192.168.1.10"},
+	})
+	checkErr(t, err)
+	setConfig(&Configuration{ipAllowlists: allowlists})
+	defer func() { setConfig(&Configuration{}) }()
+
+	h := allowlistedIPs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		path           string
+		ip             string
+		expectedStatus int
+	}{
+		{"/api/v1/applications", "10.1.2.3", 200},
+		{"/api/graphql", "172.16.0.1", 403},
+		{"/web/webhooks/ci", "192.168.1.10", 200},
+		{"/web/webhooks/github", "192.168.1.11", 403},
+		{"/slack/commands/deploy", "192.168.1.10", 200},
+		{"/slack/interactions", "172.16.0.1", 403},
+		{"/teams/actions", "172.16.0.1", 403},
+		// No allowlist for the admin pages and the frontend
+		{"/admin/users", "172.16.0.1", 200},
+		{"/web/deployments", "172.16.0.1", 200},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = tt.ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status of %s from %s. want=%d, got=%d", tt.path, tt.ip, tt.expectedStatus, rec.Code)
+		}
+	}
+
+	// API tokens are only accepted from the api allowlist, also outside /api/
+	for _, tt := range []struct {
+		ip             string
+		expectedStatus int
+	}{
+		{"10.1.2.3", 200},
+		{"172.16.0.1", 403},
+	} {
+		req := httptest.NewRequest("POST", "/web/deployments", nil)
+		req.Header.Set("X-Api-Token", "token")
+		req.RemoteAddr = tt.ip + ":1234"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status of API token request from %s. want=%d, got=%d", tt.ip, tt.expectedStatus, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// linkedIssue is an issue the deployed commits refer to, as listed in the
+// notifications.
+type linkedIssue struct {
+	Text string
+	URL  string
+}
+
+// issueLink returns the URL of the issue the reference points to in the
+// issue_tracker of the application.
+func issueLink(a *models.Application, ref *models.IssueReference) string {
+	it := a.IssueTracker
+	switch it.Type {
+	case models.ISSUE_TRACKER_LINEAR:
+		return fmt.Sprintf("https://linear.app/%s/issue/%s", it.Workspace, ref.Id)
+	case models.ISSUE_TRACKER_SHORTCUT:
+		return fmt.Sprintf("https://app.shortcut.com/%s/story/%s", it.Workspace, ref.Id)
+	default:
+		repo := ref.Repo
+		if repo == "" {
+			repo = a.GitHubOwner + "/" + a.GitHubRepo
+		}
+		return fmt.Sprintf("%s/%s/issues/%s", getConfig().GitHubBaseURL(), repo, ref.Id)
+	}
+}
+
+// linkIssues escapes the text and links the references to issues in it.
+func linkIssues(a *models.Application, text string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, ref := range a.IssueTracker.References(text) {
+		b.WriteString(template.HTMLEscapeString(text[last:ref.Start]))
+		fmt.Fprintf(&b, `<a href="%s">%s</a>`, template.HTMLEscapeString(issueLink(a, ref)), template.HTMLEscapeString(ref.Text))
+		last = ref.End
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// changelogIssues returns the issues the commit messages of the changelog
+// refer to, each once.
+func changelogIssues(a *models.Application, changelog []*models.ChangelogEntry) []*linkedIssue {
+	issues := []*linkedIssue{}
+	seen := map[string]bool{}
+	for _, e := range changelog {
+		for _, ref := range a.IssueTracker.References(e.Message) {
+			url := issueLink(a, ref)
+			if !seen[url] {
+				seen[url] = true
+				issues = append(issues, &linkedIssue{Text: ref.Text, URL: url})
+			}
+		}
+	}
+	return issues
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"html/template"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestLinkIssues(t *testing.T) {
+	setConfig(&Configuration{})
+
+	tests := []struct {
+		tracker  *models.IssueTracker
+		text     string
+		expected template.HTML
+	}{
+		{
+			&models.IssueTracker{Type: models.ISSUE_TRACKER_GITHUB},
+			"Fix <script> (#42), see shipping-co/api#7",
+			`Fix &lt;script&gt; (<a href="https://github.com/shipping-co/web/issues/42">#42</a>), see <a href="https://github.com/shipping-co/api/issues/7">shipping-co/api#7</a>`,
+		},
+		{
+			&models.IssueTracker{Type: models.ISSUE_TRACKER_LINEAR, Workspace: "acme", Teams: []string{"ENG"}},
+			"ENG-12: Fix the login",
+			`<a href="https://linear.app/acme/issue/ENG-12">ENG-12</a>: Fix the login`,
+		},
+		{
+			&models.IssueTracker{Type: models.ISSUE_TRACKER_SHORTCUT, Workspace: "acme"},
+			"Fix the login [sc-12]",
+			`Fix the login [<a href="https://app.shortcut.com/acme/story/12">sc-12</a>]`,
+		},
+		{nil, "Fix the login & #42", `Fix the login &amp; #42`},
+	}
+
+	for _, tt := range tests {
+		a := &models.Application{GitHubOwner: "shipping-co", GitHubRepo: "web", IssueTracker: tt.tracker}
+		if got := linkIssues(a, tt.text); got != tt.expected {
+			t.Errorf("wrong links in %q.\nwant=%s\ngot= %s", tt.text, tt.expected, got)
+		}
+	}
+}
+
+func TestChangelogIssues(t *testing.T) {
+	a := &models.Application{IssueTracker: &models.IssueTracker{Type: models.ISSUE_TRACKER_LINEAR, Workspace: "acme", Teams: []string{"ENG"}}}
+	changelog := []*models.ChangelogEntry{
+		{Message: "ENG-12: Fix the login"},
+		{Message: "Merge ENG-12 and ENG-13\n\nCloses ENG-14"},
+	}
+
+	expected := []*linkedIssue{
+		{"ENG-12", "https://linear.app/acme/issue/ENG-12"},
+		{"ENG-13", "https://linear.app/acme/issue/ENG-13"},
+		{"ENG-14", "https://linear.app/acme/issue/ENG-14"},
+	}
+	if got := changelogIssues(a, changelog); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wrong issues. got=%+v", got)
+	}
+
+	ev := &DeploymentEvent{
+		State:       models.DEPLOYMENT_SUCCESSFUL,
+		Deployment:  &models.Deployment{Id: 1, Branch: "master", Changelog: changelog},
+		Application: a,
+		User:        &models.User{Name: "mrnugget"},
+	}
+	summary, err := generateSummary(slackTemplate, ev)
+	checkErr(t, err)
+	if !strings.Contains(summary, "Issues: <https://linear.app/acme/issue/ENG-12|ENG-12>, <https://linear.app/acme/issue/ENG-13|ENG-13>") {
+		t.Errorf("issues missing in the Slack summary. got=%s", summary)
+	}
+}
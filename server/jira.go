@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// errNoJiraTransition is returned if an issue can't be moved to a status,
+// e.g. because it's already in it.
+var errNoJiraTransition = errors.New("no transition to the status")
+
+// JiraClient talks to the REST API of a Jira site, authenticated with the
+// email and API token of an account.
+type JiraClient struct {
+	*http.Client
+	baseURL  string
+	email    string
+	apiToken string
+}
+
+type JiraVersion struct {
+	Id          string `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Project     string `json:"project,omitempty"`
+	Released    bool   `json:"released"`
+	ReleaseDate string `json:"releaseDate,omitempty"`
+}
+
+type jiraTransition struct {
+	Id string `json:"id"`
+	To struct {
+		Name string `json:"name"`
+	} `json:"to"`
+}
+
+func NewJiraClient(j *models.JiraRelease) *JiraClient {
+	return &JiraClient{
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		baseURL:  strings.TrimRight(j.URL, "/"),
+		email:    j.Email,
+		apiToken: j.ApiToken,
+	}
+}
+
+// GetVersions returns the versions of the project.
+func (jc *JiraClient) GetVersions(project string) ([]*JiraVersion, error) {
+	versions := []*JiraVersion{}
+	path := fmt.Sprintf("/rest/api/3/project/%s/versions", url.PathEscape(project))
+	err := jc.request("GET", path, nil, 200, &versions)
+	return versions, err
+}
+
+func (jc *JiraClient) CreateVersion(v *JiraVersion) (*JiraVersion, error) {
+	created := &JiraVersion{}
+	err := jc.request("POST", "/rest/api/3/version", v, 201, created)
+	return created, err
+}
+
+func (jc *JiraClient) UpdateVersion(v *JiraVersion) error {
+	path := fmt.Sprintf("/rest/api/3/version/%s", url.PathEscape(v.Id))
+	return jc.request("PUT", path, v, 200, nil)
+}
+
+// AddFixVersion adds the version to the fix versions of the issue.
+func (jc *JiraClient) AddFixVersion(issue, version string) error {
+	body := map[string]interface{}{
+		"update": map[string]interface{}{
+			"fixVersions": []interface{}{
+				map[string]interface{}{"add": map[string]string{"name": version}},
+			},
+		},
+	}
+	path := fmt.Sprintf("/rest/api/3/issue/%s", url.PathEscape(issue))
+	return jc.request("PUT", path, body, 204, nil)
+}
+
+// TransitionIssue moves the issue to the status with the transition leading
+// to it.
+func (jc *JiraClient) TransitionIssue(issue, status string) error {
+	var transitions struct {
+		Transitions []*jiraTransition `json:"transitions"`
+	}
+	path := fmt.Sprintf("/rest/api/3/issue/%s/transitions", url.PathEscape(issue))
+	if err := jc.request("GET", path, nil, 200, &transitions); err != nil {
+		return err
+	}
+
+	for _, t := range transitions.Transitions {
+		if strings.EqualFold(t.To.Name, status) {
+			body := map[string]interface{}{"transition": map[string]string{"id": t.Id}}
+			return jc.request("POST", path, body, 204, nil)
+		}
+	}
+	return errNoJiraTransition
+}
+
+func (jc *JiraClient) request(method, path string, v interface{}, expectedStatus int, result interface{}) error {
+	var body io.Reader
+	if v != nil {
+		payload, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequest(method, jc.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(jc.email, jc.apiToken)
+	req.Header.Set("Accept", "application/json")
+	if v != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := jc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectedStatus {
+		return fmt.Errorf("Jira responded with %d instead of %d", res.StatusCode, expectedStatus)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
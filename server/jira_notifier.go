@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// NotifyJira releases the Jira version of the tag or release branch a
+// deployment deployed: the version is created or marked as released, and
+// the issues mentioned in the changelog get it as fix version and are moved
+// to the released status.
+func NotifyJira(ev *DeploymentEvent) error {
+	j := ev.Application.JiraRelease
+	if !j.ReleasesTarget(ev.Target.Name) {
+		return nil
+	}
+	name := j.VersionName(ev.Deployment)
+	if name == "" {
+		return nil
+	}
+
+	if err := releaseJiraVersion(NewJiraClient(j), j, ev.Deployment, name, time.Now()); err != nil {
+		deploymentLogger(ev.Deployment).Error("releasing Jira version failed", "version", name, "err", err)
+		metrics.NotifierFailed("jira")
+		return err
+	}
+
+	deploymentLogger(ev.Deployment).Info("released Jira version", "version", name)
+	return nil
+}
+
+func releaseJiraVersion(jc *JiraClient, j *models.JiraRelease, d *models.Deployment, name string, now time.Time) error {
+	versions, err := jc.GetVersions(j.Project)
+	if err != nil {
+		return fmt.Errorf("loading versions failed: %s", err)
+	}
+
+	var version *JiraVersion
+	for _, v := range versions {
+		if v.Name == name {
+			version = v
+		}
+	}
+
+	releaseDate := now.Format("2006-01-02")
+	if version == nil {
+		_, err = jc.CreateVersion(&JiraVersion{Name: name, Project: j.Project, Released: true, ReleaseDate: releaseDate})
+		if err != nil {
+			return fmt.Errorf("creating version failed: %s", err)
+		}
+	} else if !version.Released {
+		err = jc.UpdateVersion(&JiraVersion{Id: version.Id, Name: name, Released: true, ReleaseDate: releaseDate})
+		if err != nil {
+			return fmt.Errorf("updating version failed: %s", err)
+		}
+	}
+
+	// Failing issues, e.g. deleted ones, don't keep the others from being
+	// released
+	status := j.ReleasedStatusName()
+	for _, issue := range j.IssueKeys(d) {
+		if err := jc.AddFixVersion(issue, name); err != nil {
+			deploymentLogger(d).Warn("setting Jira fix version failed", "issue", issue, "err", err)
+			continue
+		}
+		err := jc.TransitionIssue(issue, status)
+		if err == errNoJiraTransition {
+			deploymentLogger(d).Info("Jira issue can't be moved to the released status", "issue", issue, "status", status)
+		} else if err != nil {
+			deploymentLogger(d).Warn("moving Jira issue failed", "issue", issue, "err", err)
+		}
+	}
+
+	return nil
+}
+
+func validateJiraRelease(a *models.Application) error {
+	j := a.JiraRelease
+	if j == nil {
+		return nil
+	}
+
+	if j.URL == "" || j.Email == "" || j.ApiToken == "" {
+		return errors.New("url, email and api_token are required")
+	}
+	if j.Project == "" {
+		return errors.New("project is required")
+	}
+	for _, name := range j.Targets {
+		if _, err := findTarget(a, name); err != nil {
+			return fmt.Errorf("unknown target %s", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestReleaseJiraVersion(t *testing.T) {
+	var mu sync.Mutex
+	requests := []string{}
+	versions := `[{"id": "10", "name": "1.1.0", "released": true}]`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, token, ok := r.BasicAuth(); !ok || user != "bot@example.com" || token != "t0k3n" {
+			t.Errorf("request without credentials to %s", r.URL.Path)
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+
+		switch r.Method + " " + r.URL.Path {
+		case "GET /rest/api/3/project/WEB/versions":
+			fmt.Fprint(w, versions)
+		case "POST /rest/api/3/version":
+			var v JiraVersion
+			json.Unmarshal(body, &v)
+			if v.Name != "1.2.0" || v.Project != "WEB" || !v.Released || v.ReleaseDate != "2026-10-16" {
+				t.Errorf("wrong new version. got=%+v", v)
+			}
+			w.WriteHeader(201)
+			fmt.Fprint(w, `{"id": "11", "name": "1.2.0", "released": true}`)
+		case "PUT /rest/api/3/version/11":
+			fmt.Fprint(w, `{}`)
+		case "PUT /rest/api/3/issue/WEB-1", "PUT /rest/api/3/issue/WEB-2",
+			"POST /rest/api/3/issue/WEB-1/transitions":
+			w.WriteHeader(204)
+		case "GET /rest/api/3/issue/WEB-1/transitions":
+			fmt.Fprint(w, `{"transitions": [{"id": "5", "to": {"name": "Done"}}, {"id": "7", "to": {"name": "Released"}}]}`)
+		case "GET /rest/api/3/issue/WEB-2/transitions":
+			fmt.Fprint(w, `{"transitions": [{"id": "5", "to": {"name": "Done"}}]}`)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer ts.Close()
+
+	j := &models.JiraRelease{URL: ts.URL + "/", Email: "bot@example.com", ApiToken: "t0k3n", Project: "WEB"}
+	d := &models.Deployment{
+		Id:        42,
+		Tag:       "1.2.0",
+		Comment:   "Release WEB-1",
+		Changelog: []*models.ChangelogEntry{{Message: "WEB-2: Fix the login"}, {Message: "WEB-404: Gone"}},
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	checkErr(t, releaseJiraVersion(NewJiraClient(j), j, d, "1.2.0", now))
+
+	expected := []string{
+		"GET /rest/api/3/project/WEB/versions",
+		"POST /rest/api/3/version",
+		"PUT /rest/api/3/issue/WEB-1",
+		"GET /rest/api/3/issue/WEB-1/transitions",
+		"POST /rest/api/3/issue/WEB-1/transitions",
+		"PUT /rest/api/3/issue/WEB-2",
+		"GET /rest/api/3/issue/WEB-2/transitions",
+		"PUT /rest/api/3/issue/WEB-404",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("wrong requests.\nwant=%v\ngot= %v", expected, requests)
+	}
+
+	// Existing unreleased versions are marked as released
+	requests = []string{}
+	versions = `[{"id": "11", "name": "1.2.0", "released": false}]`
+	d.Comment, d.Changelog = "", nil
+	checkErr(t, releaseJiraVersion(NewJiraClient(j), j, d, "1.2.0", now))
+	expected = []string{"GET /rest/api/3/project/WEB/versions", "PUT /rest/api/3/version/11"}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("wrong requests for existing version.\nwant=%v\ngot= %v", expected, requests)
+	}
+}
+
+func TestValidateJiraRelease(t *testing.T) {
+	targets := []*models.Target{{Name: "production"}}
+
+	tests := []struct {
+		jira        *models.JiraRelease
+		expectedErr bool
+	}{
+		{nil, false},
+		{&models.JiraRelease{URL: "https://example.atlassian.net", Email: "bot@example.com", ApiToken: "t0k3n", Project: "WEB", Targets: []string{"production"}}, false},
+		{&models.JiraRelease{URL: "https://example.atlassian.net", Email: "bot@example.com", Project: "WEB"}, true},
+		{&models.JiraRelease{URL: "https://example.atlassian.net", Email: "bot@example.com", ApiToken: "t0k3n"}, true},
+		{&models.JiraRelease{URL: "https://example.atlassian.net", Email: "bot@example.com", ApiToken: "t0k3n", Project: "WEB", Targets: []string{"staging"}}, true},
+	}
+
+	for i, tt := range tests {
+		err := validateJiraRelease(&models.Application{Name: "web", Targets: targets, JiraRelease: tt.jira})
+		if tt.expectedErr && err == nil {
+			t.Errorf("expected error for case %d", i)
+		}
+		if !tt.expectedErr && err != nil {
+			t.Errorf("unexpected error for case %d: %s", i, err)
+		}
+	}
+}
@@ -45,3 +45,11 @@ func (kr *KillRegistry) Get(deploymentId int) (chan struct{}, error) {
 	}
 	return c, nil
 }
+
+// Len returns the number of running deployments.
+func (kr *KillRegistry) Len() int {
+	kr.RLock()
+	defer kr.RUnlock()
+
+	return len(kr.m)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	defaultNotifierWorkers = 8
+	defaultNotifierTimeout = 30 * time.Second
+
+	// Events waiting for a worker. Once the queue is full, further events
+	// are dropped instead of blocking the deployments publishing them
+	listenerQueueSize = 1000
+
+	// After this many failed or timed out calls in a row, a listener isn't
+	// called for the target for listenerCircuitCooldown. It's called again afterwards, and
+	// the circuit opens again on the next failure.
+	listenerCircuitThreshold = 5
+	listenerCircuitCooldown  = 1 * time.Minute
+)
+
+type listenerCall struct {
+	listener stateListener
+	event    *DeploymentEvent
+}
+
+// listenerCircuit is the state of the circuit breaker of a listener for a
+// target.
+type listenerCircuit struct {
+	failures  int
+	openUntil time.Time
+	// calls that timed out but didn't return yet
+	hanging int
+}
+
+// listenerCircuits keeps a listener whose calls fail or hang, e.g. because
+// the API of a notifier is down, from being called over and over again. The
+// circuits are keyed by listener and target, see listenerCircuitKey, so the
+// broken webhook of one target doesn't turn the notifier off for the others.
+type listenerCircuits struct {
+	circuits map[string]*listenerCircuit
+	mutex    *sync.Mutex
+}
+
+func newListenerCircuits() *listenerCircuits {
+	return &listenerCircuits{
+		circuits: make(map[string]*listenerCircuit),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+func (c *listenerCircuits) circuit(key string) *listenerCircuit {
+	circuit, ok := c.circuits[key]
+	if !ok {
+		circuit = &listenerCircuit{}
+		c.circuits[key] = circuit
+	}
+	return circuit
+}
+
+func listenerCircuitKey(name string, d *models.Deployment) string {
+	return name + ":" + d.ApplicationName + "/" + d.TargetName
+}
+
+// Allow returns whether the listener may be called. It may not while its
+// circuit is open, or while listenerCircuitThreshold of its calls hang, so a
+// hanging API doesn't pile up goroutines.
+func (c *listenerCircuits) Allow(key string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	circuit := c.circuit(key)
+	return now.After(circuit.openUntil) && circuit.hanging < listenerCircuitThreshold
+}
+
+// Succeeded closes the circuit of the listener.
+func (c *listenerCircuits) Succeeded(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.circuit(key).failures = 0
+}
+
+// Failed counts a failed call and returns whether the circuit of the
+// listener opened.
+func (c *listenerCircuits) Failed(key string, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	circuit := c.circuit(key)
+	circuit.failures++
+	if circuit.failures < listenerCircuitThreshold {
+		return false
+	}
+	circuit.openUntil = now.Add(listenerCircuitCooldown)
+	return true
+}
+
+// TimedOut counts a call that timed out as failed and as hanging until
+// Returned is called.
+func (c *listenerCircuits) TimedOut(key string, now time.Time) bool {
+	c.mutex.Lock()
+	c.circuit(key).hanging++
+	c.mutex.Unlock()
+
+	return c.Failed(key, now)
+}
+
+// Returned is called once a call that timed out returned after all.
+func (c *listenerCircuits) Returned(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.circuit(key).hanging--
+}
+
+// StartListenerWorkers starts the workers calling the listeners of the
+// deployment states, each call limited to the timeout. The events published
+// before are queued until then.
+func (b *EventBus) StartListenerWorkers(workers int, timeout time.Duration) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for call := range b.calls {
+				b.callListener(call, timeout)
+			}
+		}()
+	}
+}
+
+// queueListenerCall queues the call for the workers, or drops it if the
+// queue is full.
+func (b *EventBus) queueListenerCall(l stateListener, event *DeploymentEvent) {
+	select {
+	case b.calls <- listenerCall{l, event}:
+	default:
+		deploymentLogger(event.Deployment).Error("listener queue full, dropping event", "listener", l.name, "state", event.State)
+		metrics.NotifierFailed(l.name)
+	}
+}
+
+// callListener calls the listener unless its circuit for the target is open.
+// Returning an error, panicking and timing out count as failed calls.
+// Listeners can't be cancelled, so a call that takes longer than the timeout
+// is left running and the worker moves on to the next one.
+func (b *EventBus) callListener(call listenerCall, timeout time.Duration) {
+	name, d := call.listener.name, call.event.Deployment
+	logger := deploymentLogger(d).With("listener", name)
+	key := listenerCircuitKey(name, d)
+
+	if !b.circuits.Allow(key, time.Now()) {
+		logger.Warn("listener circuit open, skipping event", "state", call.event.State)
+		metrics.NotifierFailed(name)
+		return
+	}
+
+	// Buffered, so a call that timed out can still return
+	failed := make(chan bool, 1)
+	go func() {
+		// Stays false if the listener panics
+		notified := false
+		defer func() { failed <- !notified }()
+		defer recoverListenerPanic(name, d)
+
+		// The listeners log their errors themselves
+		notified = call.listener.fn(call.event) == nil
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case fail := <-failed:
+		if !fail {
+			b.circuits.Succeeded(key)
+			return
+		}
+		if b.circuits.Failed(key, time.Now()) {
+			logCircuitOpened(logger)
+		}
+	case <-timer.C:
+		logger.Error("listener timed out", "state", call.event.State, "timeout", timeout)
+		metrics.NotifierFailed(name)
+		if b.circuits.TimedOut(key, time.Now()) {
+			logCircuitOpened(logger)
+		}
+
+		go func() {
+			<-failed
+			b.circuits.Returned(key)
+		}()
+	}
+}
+
+func logCircuitOpened(logger *slog.Logger) {
+	logger.Error("listener failed repeatedly, circuit opened", "failures", listenerCircuitThreshold, "cooldown", listenerCircuitCooldown)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+var (
+	successfulStates = []models.DeploymentState{
+		models.DEPLOYMENT_SUCCESSFUL,
+	}
+	finishedStates = []models.DeploymentState{
+		models.DEPLOYMENT_SUCCESSFUL,
+		models.DEPLOYMENT_FAILED,
+	}
+	allStates = []models.DeploymentState{
+		models.DEPLOYMENT_NEW,
+		models.DEPLOYMENT_ACTIVE,
+		models.DEPLOYMENT_SUCCESSFUL,
+		models.DEPLOYMENT_FAILED,
+	}
+)
+
+// registerListeners registers the notifiers and hooks of the server. Add new
+// ones here, the deployments publish their events on the bus.
+func registerListeners(bus *EventBus) {
+	// Print the logs of all deployments
+	bus.OnLogEntries(deploy.ConsoleLogger)
+	// Persist all log entries
+	bus.OnLogEntries(newLogEntrySaver(db))
+	// Keep track of the deployments waiting for approval
+	bus.OnLogEntries(approvalRegistry.Listener())
+	// Post the deployments waiting for approval to Slack and Microsoft Teams
+	bus.OnLogEntries(approvalRequestListener)
+	// Persist how far every host got, for the recovery after a crash
+	bus.OnLogEntries(newHostStageTracker(db))
+
+	bus.OnDeploymentState("bugsnag", successfulStates, NotifyBugsnag)
+	bus.OnDeploymentState("new_relic", successfulStates, NotifyNewRelic)
+	bus.OnDeploymentState("flowdock", finishedStates, NotifyFlowdock)
+	bus.OnDeploymentState("slack", finishedStates, NotifySlack)
+	// Use the Deployments API of GitHub
+	bus.OnDeploymentState("github", allStates, NewGitHubNotifier().Notify)
+	// Comment on deployed pull requests
+	bus.OnDeploymentState("pull_request", finishedStates, NotifyPullRequest)
+	// Release the Jira versions of deployed tags and release branches
+	bus.OnDeploymentState("jira", successfulStates, NotifyJira)
+	// Announce running deployments on the status page of the target
+	bus.OnDeploymentState("statuspage", allStates, NewStatuspageNotifier(statuspageAPIURL).Notify)
+	bus.OnDeploymentState("webhook", allStates, NotifyWebhooks)
+	// Keep the changes for /api/v1/events and post them to the event_webhooks
+	bus.OnDeploymentState("events", allStates, RecordEvent)
+
+	// Count the outcomes of deployments for /metrics
+	bus.OnDeploymentState("metrics", finishedStates, metrics.CountDeploymentOutcome)
+}
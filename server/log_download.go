@@ -0,0 +1,162 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/gorilla/mux"
+)
+
+const logDownloadTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// deploymentLogDownloadHandler streams the complete log of a deployment as
+// plain text (log.txt) or as one JSON object per line (log.ndjson), e.g. to
+// attach it to an incident report. The entries are written while they're
+// read from the database, so long logs aren't loaded completely. With .gz,
+// e.g. log.txt.gz, they're written through gzip into a compressed file. The
+// origin and type parameters download only a part of the log, e.g. the
+// failures.
+func deploymentLogDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+	vars := mux.Vars(r)
+
+	id, err := strconv.Atoi(vars["deploymentId"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil || deployment.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	filter, err := parseLogEntryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	format := vars["format"]
+	gzipped := strings.HasSuffix(format, ".gz")
+
+	var contentType string
+	var write func(io.Writer, *deploy.LogEntry) error
+	switch strings.TrimSuffix(format, ".gz") {
+	case "txt":
+		contentType, write = "text/plain; charset=utf-8", writeLogEntryText
+	case "ndjson":
+		contentType, write = "application/x-ndjson", writeLogEntryJSON
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	var out io.Writer = w
+	if gzipped {
+		// Not compressible, so the compressed middleware passes it through
+		contentType = "application/gzip"
+
+		gz := gzipWriterPool.Get().(*gzip.Writer)
+		gz.Reset(w)
+		defer func() {
+			gz.Close()
+			gzipWriterPool.Put(gz)
+		}()
+		out = gz
+	}
+
+	filename := fmt.Sprintf("%s-deployment-%d.%s", application.Name, deployment.Id, format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// The status is already sent once the first entry is written, so errors
+	// can only be logged and end the download early
+	err = eachDeploymentLogEntry(db, deployment, func(e *deploy.LogEntry) error {
+		if !filter.Matches(e) {
+			return nil
+		}
+		return write(out, e)
+	})
+	if err != nil {
+		requestLogger(r).Error("error streaming log", "deployment", deployment, "err", err)
+	}
+}
+
+// writeLogEntryText writes the entry as lines like
+// "2015-01-26T10:00:00.000Z [web01] COMMAND_START bundle install". Every line
+// of a multi-line message gets the prefix.
+func writeLogEntryText(w io.Writer, e *deploy.LogEntry) error {
+	prefix := e.Timestamp.UTC().Format(logDownloadTimeLayout)
+	if e.Origin != "" {
+		prefix += " [" + e.Origin + "]"
+	}
+	prefix += " " + string(e.EntryType)
+
+	message := strings.TrimRight(stripANSI(e.Message), "\n")
+	if e.EntryType == deploy.COMMAND_SUCCESS || e.EntryType == deploy.COMMAND_FAIL {
+		message += fmt.Sprintf(" (exit code %d, %s)", e.ExitCode, e.Duration)
+	}
+
+	for _, line := range strings.Split(message, "\n") {
+		if _, err := fmt.Fprintf(w, "%s %s\n", prefix, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ansiEscapePattern matches the escape sequences of colored command output,
+// like the ones the deployment page converts in applikatoni.js.
+var ansiEscapePattern = regexp.MustCompile("\x1b\\[[0-9;?]*[ -/]*[@-~]|\x1b\\][^\x07\x1b]*(?:\x07|\x1b\\\\)|\x1b[@-Z\\\\-_]")
+
+// stripANSI removes colors and other escape sequences, which are garbage in
+// text files.
+func stripANSI(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+	return ansiEscapePattern.ReplaceAllString(s, "")
+}
+
+// logDownloadEntry is a log entry with the duration in milliseconds, like in
+// the GraphQL endpoint, instead of nanoseconds.
+type logDownloadEntry struct {
+	Timestamp time.Time           `json:"timestamp"`
+	Origin    string              `json:"origin"`
+	EntryType deploy.LogEntryType `json:"entry_type"`
+	Message   string              `json:"message"`
+	ExitCode  int                 `json:"exit_code"`
+	Duration  int64               `json:"duration"`
+}
+
+func writeLogEntryJSON(w io.Writer, e *deploy.LogEntry) error {
+	js, err := json.Marshal(&logDownloadEntry{
+		Timestamp: e.Timestamp,
+		Origin:    e.Origin,
+		EntryType: e.EntryType,
+		Message:   e.Message,
+		ExitCode:  e.ExitCode,
+		Duration:  int64(e.Duration / time.Millisecond),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(append(js, '\n'))
+	return err
+}
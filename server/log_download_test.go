@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+func TestWriteLogEntryText(t *testing.T) {
+	timestamp := time.Date(2015, 1, 26, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		entry    *deploy.LogEntry
+		expected string
+	}{
+		{
+			&deploy.LogEntry{Timestamp: timestamp, EntryType: deploy.STAGE_START, Message: "CHECKOUT"},
+			"2015-01-26T10:00:00.000Z STAGE_START CHECKOUT\n",
+		},
+		{
+			&deploy.LogEntry{Timestamp: timestamp, Origin: "web01", EntryType: deploy.COMMAND_STDOUT_OUTPUT, Message: "first\nsecond\n"},
+			"2015-01-26T10:00:00.000Z [web01] COMMAND_STDOUT_OUTPUT first\n2015-01-26T10:00:00.000Z [web01] COMMAND_STDOUT_OUTPUT second\n",
+		},
+		{
+			&deploy.LogEntry{Timestamp: timestamp, Origin: "web01", EntryType: deploy.COMMAND_FAIL, Message: "bundle install", ExitCode: 1, Duration: 1500 * time.Millisecond},
+			"2015-01-26T10:00:00.000Z [web01] COMMAND_FAIL bundle install (exit code 1, 1.5s)\n",
+		},
+		{
+			&deploy.LogEntry{Timestamp: timestamp, Origin: "web01", EntryType: deploy.COMMAND_STDERR_OUTPUT, Message: "\x1b]0;npm\x07\x1b[1;31merror\x1b[0m \x1b[38;5;208mlint\x1b[m\x1b[2K"},
+			"2015-01-26T10:00:00.000Z [web01] COMMAND_STDERR_OUTPUT error lint\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		checkErr(t, writeLogEntryText(&buf, tt.entry))
+
+		if buf.String() != tt.expected {
+			t.Errorf("wrong text.\nwant=%q\ngot=%q", tt.expected, buf.String())
+		}
+	}
+}
+
+func TestDeploymentLogDownloadHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	deployment := buildDeployment(1)
+	checkErr(t, createDeployment(db, deployment))
+	checkErr(t, createLogEntry(db, &deploy.LogEntry{
+		DeploymentId: deployment.Id,
+		Origin:       "web01",
+		EntryType:    deploy.COMMAND_SUCCESS,
+		Message:      "bundle install",
+		Timestamp:    time.Date(2015, 1, 26, 10, 0, 0, 0, time.UTC),
+		Duration:     1500 * time.Millisecond,
+	}))
+
+	tests := []struct {
+		application    string
+		format         string
+		acceptEncoding string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{deployment.ApplicationName, "txt", "", 200, "2015-01-26T10:00:00.000Z [web01] COMMAND_SUCCESS bundle install (exit code 0, 1.5s)\n"},
+		{deployment.ApplicationName, "ndjson", "gzip, deflate", 200, `{"timestamp":"2015-01-26T10:00:00Z","origin":"web01","entry_type":"COMMAND_SUCCESS","message":"bundle install","exit_code":0,"duration":1500}` + "\n"},
+		{deployment.ApplicationName, "xml", "", 404, ""},
+		{"otherApplication", "txt", "", 404, ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+		req = mux.SetURLVars(req, map[string]string{"deploymentId": strconv.Itoa(deployment.Id), "format": tt.format})
+		context.Set(req, CurrentApplication, &models.Application{Name: tt.application})
+
+		rec := httptest.NewRecorder()
+		compressed(http.HandlerFunc(deploymentLogDownloadHandler)).ServeHTTP(rec, req)
+		context.Clear(req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s log.%s. want=%d, got=%d", tt.application, tt.format, tt.expectedStatus, rec.Code)
+		}
+		if tt.expectedStatus != 200 {
+			continue
+		}
+
+		body := rec.Body.Bytes()
+		if tt.acceptEncoding != "" {
+			if rec.Header().Get("Content-Encoding") != "gzip" {
+				t.Errorf("download of log.%s not gzip-compressed", tt.format)
+				continue
+			}
+			gz, err := gzip.NewReader(rec.Body)
+			checkErr(t, err)
+			body, err = ioutil.ReadAll(gz)
+			checkErr(t, err)
+		}
+
+		if string(body) != tt.expectedBody {
+			t.Errorf("wrong body for log.%s.\nwant=%s\ngot=%s", tt.format, tt.expectedBody, body)
+		}
+	}
+}
+
+func TestDeploymentLogDownloadHandlerGzipFile(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	deployment := buildDeployment(1)
+	checkErr(t, createDeployment(db, deployment))
+	for _, message := range []string{"first", "second"} {
+		checkErr(t, createLogEntry(db, &deploy.LogEntry{
+			DeploymentId: deployment.Id,
+			EntryType:    deploy.COMMAND_STDOUT_OUTPUT,
+			Message:      message,
+			Timestamp:    time.Date(2015, 1, 26, 10, 0, 0, 0, time.UTC),
+		}))
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	// The file is gzipped already, it must not be compressed twice
+	req.Header.Set("Accept-Encoding", "gzip")
+	req = mux.SetURLVars(req, map[string]string{"deploymentId": strconv.Itoa(deployment.Id), "format": "txt.gz"})
+	context.Set(req, CurrentApplication, &models.Application{Name: deployment.ApplicationName})
+	defer context.Clear(req)
+
+	rec := httptest.NewRecorder()
+	compressed(http.HandlerFunc(deploymentLogDownloadHandler)).ServeHTTP(rec, req)
+
+	if rec.Code != 200 {
+		t.Fatalf("wrong status. want=200, got=%d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/gzip" {
+		t.Errorf("wrong content type. got=%s", ct)
+	}
+	if ce := rec.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("gzip file compressed again. got Content-Encoding=%s", ce)
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	checkErr(t, err)
+	body, err := ioutil.ReadAll(gz)
+	checkErr(t, err)
+
+	expected := "2015-01-26T10:00:00.000Z COMMAND_STDOUT_OUTPUT first\n2015-01-26T10:00:00.000Z COMMAND_STDOUT_OUTPUT second\n"
+	if string(body) != expected {
+		t.Errorf("wrong body.\nwant=%q\ngot=%q", expected, body)
+	}
+}
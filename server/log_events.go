@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/gorilla/mux"
+)
+
+// Proxies close connections that are idle for too long, so a comment is sent
+// while no log entries are written, e.g. during long running commands.
+var logEventsKeepAliveInterval = 15 * time.Second
+
+// deploymentEventsHandler streams the log entries of a deployment as
+// Server-Sent Events, for clients behind proxies that break websockets. The
+// entries written so far are replayed first. The entries can be filtered like
+// the websocket stream, see parseLogEntryFilter. A "done" event is sent after the
+// last entry, so clients know not to reconnect.
+//
+// The ID of every event is the sequence of its entry. Clients that fell
+// behind are disconnected without "done"; EventSource reconnects and sends
+// the ID of the last event it received as Last-Event-ID, so only the entries
+// after it are sent.
+func deploymentEventsHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["deploymentId"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil || deployment.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	filter, err := parseLogEntryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	if lastId := r.Header.Get("Last-Event-ID"); lastId != "" {
+		if after, err := strconv.Atoi(lastId); err == nil && after > filter.After {
+			filter.After = after
+		}
+	}
+
+	entries := make(chan deploy.LogEntry)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	err = eventBus.OnDeploymentLogEntries(id, forwardLogEntries(entries, stop, filter))
+	if err == deploy.ErrNoDeployment {
+		logEntries, err := getDeploymentLogEntries(db, deployment)
+		if err != nil {
+			requestLogger(r).Error("error loading logentries", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		go replayLogEntries(entries, stop, filter.Filter(logEntries))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	metrics.EventStreamConnected()
+	defer metrics.EventStreamDisconnected()
+
+	keepAlive := time.NewTicker(logEventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok {
+				if eventBus.IsDeploymentRunning(id) {
+					requestLogger(r).Warn("event stream client fell behind, disconnecting", "remote_addr", r.RemoteAddr)
+					return
+				}
+				writeServerSentEvent(w, "done", 0, struct{}{})
+				flusher.Flush()
+				return
+			}
+			err = writeServerSentEvent(w, "log_entry", entry.Sequence, entry)
+			if err != nil {
+				requestLogger(r).Warn("error writing log event", "remote_addr", r.RemoteAddr, "err", err)
+				return
+			}
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// forwardLogEntries returns a listener sending the routed log entries matching
+// the filter to entries until stop is closed. entries is closed when the
+// deployment is done.
+func forwardLogEntries(entries chan<- deploy.LogEntry, stop <-chan struct{}, filter *logEntryFilter) deploy.Listener {
+	return func(logs <-chan deploy.LogEntry) {
+		defer close(entries)
+		for entry := range logs {
+			if !filter.Matches(&entry) {
+				continue
+			}
+			select {
+			case entries <- entry:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+func replayLogEntries(entries chan<- deploy.LogEntry, stop <-chan struct{}, logs []*deploy.LogEntry) {
+	defer close(entries)
+	for _, entry := range logs {
+		select {
+		case entries <- *entry:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// writeServerSentEvent writes the event with the ID, if it isn't 0.
+func writeServerSentEvent(w io.Writer, event string, id int, data interface{}) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if id != 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, js)
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+func TestWriteServerSentEvent(t *testing.T) {
+	var buf bytes.Buffer
+	entry := deploy.LogEntry{Id: 1, Sequence: 7, DeploymentId: 2, Message: "line\nbreak"}
+
+	err := writeServerSentEvent(&buf, "log_entry", entry.Sequence, entry)
+	checkErr(t, err)
+
+	expected := "id: 7\nevent: log_entry\ndata: {\"id\":1,"
+	if !strings.HasPrefix(buf.String(), expected) {
+		t.Errorf("wrong event. want prefix=%q, got=%q", expected, buf.String())
+	}
+	// Newlines in the data would end the event early
+	if strings.Count(buf.String(), "\n") != 4 {
+		t.Errorf("event contains unescaped newlines. got=%q", buf.String())
+	}
+}
+
+func TestDeploymentEventsHandlerReplaysFinishedDeployments(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	eventBus = NewEventBus(db, deploy.NewLogRouter())
+	defer func() { eventBus = nil }()
+
+	deployment := buildDeployment(1)
+	checkErr(t, createDeployment(db, deployment))
+
+	for _, message := range []string{"first", "second"} {
+		entry := &deploy.LogEntry{
+			DeploymentId: deployment.Id,
+			EntryType:    deploy.COMMAND_STDOUT_OUTPUT,
+			Message:      message,
+			Timestamp:    time.Now(),
+		}
+		checkErr(t, createLogEntry(db, entry))
+	}
+
+	tests := []struct {
+		application     string
+		lastEventId     string
+		expectedStatus  int
+		expectedEntries int
+	}{
+		{deployment.ApplicationName, "", 200, 2},
+		// Reconnects only get the entries after the last one received
+		{deployment.ApplicationName, "1", 200, 1},
+		{"otherApplication", "", 404, 0},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if tt.lastEventId != "" {
+			req.Header.Set("Last-Event-ID", tt.lastEventId)
+		}
+		req = mux.SetURLVars(req, map[string]string{"deploymentId": strconv.Itoa(deployment.Id)})
+		context.Set(req, CurrentApplication, &models.Application{Name: tt.application})
+
+		rec := httptest.NewRecorder()
+		deploymentEventsHandler(rec, req)
+		context.Clear(req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s. want=%d, got=%d", tt.application, tt.expectedStatus, rec.Code)
+		}
+		if tt.expectedStatus != 200 {
+			continue
+		}
+
+		body := rec.Body.String()
+		if strings.Count(body, "event: log_entry\n") != tt.expectedEntries {
+			t.Errorf("wrong number of log entries. want=%d, got=%q", tt.expectedEntries, body)
+		}
+		if !strings.Contains(body, "id: 2\nevent: log_entry\n") {
+			t.Errorf("log entry without sequence as ID. got=%q", body)
+		}
+		if strings.Index(body, "first") > strings.Index(body, "second") {
+			t.Errorf("log entries in wrong order. got=%q", body)
+		}
+		if !strings.HasSuffix(body, "event: done\ndata: {}\n\n") {
+			t.Errorf("stream not finished with done event. got=%q", body)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("wrong content type. want=%s, got=%s", "text/event-stream", ct)
+		}
+	}
+}
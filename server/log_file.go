@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The suffix of rotated log files, e.g. applikatoni.log.2026-10-16T09-30-05.000.
+// It sorts in the order the files were rotated.
+const logFileRotatedFormat = "2006-01-02T15-04-05.000"
+
+// LogFile is the -log-file the logs are written to. It's rotated once it's
+// larger than maxSize or older than interval, if they're set, and can be
+// reopened after an external tool like logrotate moved it.
+type LogFile struct {
+	path     string
+	maxSize  int64
+	interval time.Duration
+	// The number of rotated files kept, the older ones are removed. 0 keeps
+	// all of them.
+	maxFiles int
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// OpenLogFile opens the file at path to append the logs to it. A maxSize or
+// interval of 0 turns off rotating by size or by time.
+func OpenLogFile(path string, maxSize int64, interval time.Duration, maxFiles int) (*LogFile, error) {
+	f := &LogFile{path: path, maxSize: maxSize, interval: interval, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *LogFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.openedAt = time.Now()
+	return nil
+}
+
+// Write appends the log lines to the file, after rotating it if the lines
+// would make it too large or it's too old.
+func (f *LogFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.needsRotation(len(p)) {
+		// The logs can't report it, so it goes to stderr. The lines are
+		// written to the current file instead.
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "rotating log file %s failed: %s\n", f.path, err)
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *LogFile) needsRotation(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.maxSize > 0 && f.size+int64(n) > f.maxSize {
+		return true
+	}
+	return f.interval > 0 && time.Since(f.openedAt) >= f.interval
+}
+
+// rotate renames the file, opens a new one and removes the rotated files
+// beyond maxFiles.
+func (f *LogFile) rotate() error {
+	rotated := f.path + "." + time.Now().Format(logFileRotatedFormat)
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+
+	f.file.Close()
+	if err := f.open(); err != nil {
+		// Keep logging to the rotated file
+		file, reopenErr := os.OpenFile(rotated, os.O_WRONLY|os.O_APPEND, 0640)
+		if reopenErr == nil {
+			f.file = file
+		}
+		return err
+	}
+
+	return f.removeOldFiles()
+}
+
+func (f *LogFile) removeOldFiles() error {
+	if f.maxFiles == 0 {
+		return nil
+	}
+
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return err
+	}
+	rotated := []string{}
+	for _, path := range matches {
+		// Leave other files alone, e.g. applikatoni.log.old
+		if _, err := time.Parse(logFileRotatedFormat, strings.TrimPrefix(path, f.path+".")); err == nil {
+			rotated = append(rotated, path)
+		}
+	}
+	if len(rotated) <= f.maxFiles {
+		return nil
+	}
+
+	sort.Strings(rotated)
+	for _, path := range rotated[:len(rotated)-f.maxFiles] {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reopen closes the file and opens the one at the path again, e.g. after
+// logrotate moved it.
+func (f *LogFile) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	old := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	return old.Close()
+}
+
+// reopenLogFileOnSignal reopens the file whenever the server receives
+// SIGUSR1, which logrotate can send in a postrotate script.
+func reopenLogFileOnSignal(f *LogFile) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+
+	for range signals {
+		if err := f.Reopen(); err != nil {
+			fmt.Fprintf(os.Stderr, "reopening log file %s failed: %s\n", f.path, err)
+			continue
+		}
+		slog.Info("reopened log file", "path", f.path)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogFileRotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "applikatoni.log")
+
+	f, err := OpenLogFile(path, 10, 0, 2)
+	checkErr(t, err)
+
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n", "five\n", "six\n"} {
+		_, err := f.Write([]byte(line))
+		checkErr(t, err)
+		// The rotated files are told apart by the millisecond
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	content, err := os.ReadFile(path)
+	checkErr(t, err)
+	if string(content) != "six\n" {
+		t.Errorf("wrong content of current file. got=%q", content)
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	checkErr(t, err)
+	if len(rotated) != 2 {
+		t.Fatalf("wrong number of rotated files. want=2, got=%v", rotated)
+	}
+	content, err = os.ReadFile(rotated[1])
+	checkErr(t, err)
+	if string(content) != "four\nfive\n" {
+		t.Errorf("wrong content of last rotated file. got=%q", content)
+	}
+}
+
+func TestLogFileRotatesByTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "applikatoni.log")
+
+	f, err := OpenLogFile(path, 0, time.Hour, 7)
+	checkErr(t, err)
+
+	f.Write([]byte("yesterday\n"))
+	f.openedAt = time.Now().Add(-2 * time.Hour)
+	f.Write([]byte("today\n"))
+
+	content, err := os.ReadFile(path)
+	checkErr(t, err)
+	if string(content) != "today\n" {
+		t.Errorf("wrong content of current file. got=%q", content)
+	}
+
+	rotated, err := filepath.Glob(path + ".*")
+	checkErr(t, err)
+	if len(rotated) != 1 {
+		t.Errorf("wrong number of rotated files. want=1, got=%v", rotated)
+	}
+}
+
+func TestLogFileKeepsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "applikatoni.log")
+	checkErr(t, os.WriteFile(path+".old", []byte("old\n"), 0640))
+
+	f, err := OpenLogFile(path, 5, 0, 1)
+	checkErr(t, err)
+
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		f.Write([]byte(line))
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	if _, err := os.Stat(path + ".old"); err != nil {
+		t.Errorf("other file removed: %s", err)
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "applikatoni.log")
+
+	f, err := OpenLogFile(path, 0, 0, 0)
+	checkErr(t, err)
+
+	f.Write([]byte("before\n"))
+	// Like logrotate
+	checkErr(t, os.Rename(path, path+".1"))
+	f.Write([]byte("moved\n"))
+
+	checkErr(t, f.Reopen())
+	f.Write([]byte("after\n"))
+
+	content, err := os.ReadFile(path + ".1")
+	checkErr(t, err)
+	if string(content) != "before\nmoved\n" {
+		t.Errorf("wrong content of moved file. got=%q", content)
+	}
+	content, err = os.ReadFile(path)
+	checkErr(t, err)
+	if !strings.HasPrefix(string(content), "after\n") {
+		t.Errorf("wrong content of reopened file. got=%q", content)
+	}
+}
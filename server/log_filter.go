@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/deploy"
+)
+
+// logEntryTypeGroups are shortcuts for the type parameter of the log filter.
+var logEntryTypeGroups = map[string][]deploy.LogEntryType{
+	"failures": {
+		deploy.COMMAND_FAIL,
+		deploy.STAGE_FAIL,
+		deploy.DEPLOYMENT_FAIL,
+		deploy.KILL_RECEIVED,
+	},
+	"output": {
+		deploy.COMMAND_STDOUT_OUTPUT,
+		deploy.COMMAND_STDERR_OUTPUT,
+	},
+}
+
+// logEntryFilter selects the log entries of a deployment that are streamed or
+// downloaded. Empty fields match every entry.
+type logEntryFilter struct {
+	// The hosts the entries come from, "applikatoni" for the entries of the
+	// deployment itself
+	Origins []string
+	Types   []deploy.LogEntryType
+	// Only the entries after this sequence, for clients that reconnect
+	After int
+	// Only the command output after this sequence, all other entries. The
+	// deployment page loads the earlier output on demand, but needs all
+	// stages and commands for the progress matrix.
+	OutputAfter int
+}
+
+// parseLogEntryFilter reads the filter from the query parameters origin and
+// type. Both take comma separated lists. type also takes the names of
+// logEntryTypeGroups, e.g. "type=failures". after takes the sequence of the
+// last entry a client received, output_after the sequence from which on the
+// command output is included.
+func parseLogEntryFilter(q url.Values) (*logEntryFilter, error) {
+	f := &logEntryFilter{Origins: splitFilterList(q["origin"])}
+
+	var err error
+	if f.After, err = parseSequence(q, "after"); err != nil {
+		return nil, err
+	}
+	if f.OutputAfter, err = parseSequence(q, "output_after"); err != nil {
+		return nil, err
+	}
+
+	for _, t := range splitFilterList(q["type"]) {
+		if group, ok := logEntryTypeGroups[t]; ok {
+			f.Types = append(f.Types, group...)
+			continue
+		}
+		if !isLogEntryType(deploy.LogEntryType(t)) {
+			return nil, fmt.Errorf("unknown log entry type %q", t)
+		}
+		f.Types = append(f.Types, deploy.LogEntryType(t))
+	}
+
+	return f, nil
+}
+
+// parseSequence reads the sequence of a log entry from the query parameter,
+// 0 if it's not set.
+func parseSequence(q url.Values, name string) (int, error) {
+	s := q.Get(name)
+	if s == "" {
+		return 0, nil
+	}
+	sequence, err := strconv.Atoi(s)
+	if err != nil || sequence < 0 {
+		return 0, fmt.Errorf("%s must be the sequence of a log entry, got %q", name, s)
+	}
+	return sequence, nil
+}
+
+// splitFilterList splits repeated and comma separated values, so
+// "?origin=web01,web02" and "?origin=web01&origin=web02" are the same.
+func splitFilterList(values []string) []string {
+	var list []string
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func isLogEntryType(entryType deploy.LogEntryType) bool {
+	for _, t := range deploy.LogEntryTypes {
+		if t == entryType {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *logEntryFilter) Matches(e *deploy.LogEntry) bool {
+	if f.After > 0 && e.Sequence <= f.After {
+		return false
+	}
+	if f.OutputAfter > 0 && e.Sequence <= f.OutputAfter && isOutputEntry(e) {
+		return false
+	}
+	if len(f.Origins) > 0 && !containsString(f.Origins, e.Origin) {
+		return false
+	}
+	if len(f.Types) == 0 {
+		return true
+	}
+	for _, t := range f.Types {
+		if t == e.EntryType {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter returns the matching entries, e.g. for replaying the stored log of a
+// finished deployment.
+func (f *logEntryFilter) Filter(entries []*deploy.LogEntry) []*deploy.LogEntry {
+	if len(f.Origins) == 0 && len(f.Types) == 0 && f.After == 0 && f.OutputAfter == 0 {
+		return entries
+	}
+
+	var matching []*deploy.LogEntry
+	for _, e := range entries {
+		if f.Matches(e) {
+			matching = append(matching, e)
+		}
+	}
+	return matching
+}
+
+func isOutputEntry(e *deploy.LogEntry) bool {
+	return e.EntryType == deploy.COMMAND_STDOUT_OUTPUT || e.EntryType == deploy.COMMAND_STDERR_OUTPUT
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
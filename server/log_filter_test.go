@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/deploy"
+)
+
+func TestLogEntryFilter(t *testing.T) {
+	entries := []*deploy.LogEntry{
+		{Id: 1, Sequence: 1, Origin: "applikatoni", EntryType: deploy.STAGE_START},
+		{Id: 2, Sequence: 2, Origin: "web01", EntryType: deploy.COMMAND_STDOUT_OUTPUT},
+		{Id: 3, Sequence: 3, Origin: "web02", EntryType: deploy.COMMAND_STDERR_OUTPUT},
+		{Id: 4, Sequence: 4, Origin: "web02", EntryType: deploy.COMMAND_FAIL},
+		{Id: 5, Sequence: 5, Origin: "applikatoni", EntryType: deploy.STAGE_FAIL},
+	}
+
+	tests := []struct {
+		query       string
+		expectedIds []int
+	}{
+		{"", []int{1, 2, 3, 4, 5}},
+		{"origin=web02", []int{3, 4}},
+		{"origin=web01,applikatoni", []int{1, 2, 5}},
+		{"origin=web01&origin=web02", []int{2, 3, 4}},
+		{"type=failures", []int{4, 5}},
+		{"type=output,STAGE_START", []int{1, 2, 3}},
+		{"origin=web02&type=failures", []int{4}},
+		{"origin=db01", []int{}},
+		{"after=0", []int{1, 2, 3, 4, 5}},
+		{"after=3", []int{4, 5}},
+		{"after=2&origin=web02", []int{3, 4}},
+		{"after=5", []int{}},
+		{"output_after=2", []int{1, 3, 4, 5}},
+		{"output_after=3&type=output", []int{}},
+	}
+
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		checkErr(t, err)
+
+		f, err := parseLogEntryFilter(q)
+		if err != nil {
+			t.Errorf("parsing %q failed: %s", tt.query, err)
+			continue
+		}
+
+		filtered := f.Filter(entries)
+		if len(filtered) != len(tt.expectedIds) {
+			t.Errorf("wrong number of entries for %q. want=%d, got=%d", tt.query, len(tt.expectedIds), len(filtered))
+			continue
+		}
+		for i, e := range filtered {
+			if e.Id != tt.expectedIds[i] {
+				t.Errorf("wrong entry for %q. want=%d, got=%d", tt.query, tt.expectedIds[i], e.Id)
+			}
+		}
+	}
+}
+
+func TestParseLogEntryFilterUnknownType(t *testing.T) {
+	_, err := parseLogEntryFilter(url.Values{"type": {"COMMAND_START,EVERYTHING"}})
+	if err == nil {
+		t.Errorf("unknown type accepted")
+	}
+}
+
+func TestParseLogEntryFilterInvalidAfter(t *testing.T) {
+	for _, after := range []string{"-1", "last"} {
+		if _, err := parseLogEntryFilter(url.Values{"after": {after}}); err == nil {
+			t.Errorf("invalid after %q accepted", after)
+		}
+		if _, err := parseLogEntryFilter(url.Values{"output_after": {after}}); err == nil {
+			t.Errorf("invalid output_after %q accepted", after)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+const (
+	// The deployment page shows the output of the last log entries and
+	// loads the earlier ones in pages of this size
+	deploymentLogPageSize = 500
+	maxLogPageSize        = 5000
+)
+
+// parseLogPage reads the page of a log from the query parameters before, the
+// sequence of the first entry a client has, and limit. paged is false if
+// neither is set.
+func parseLogPage(q url.Values) (before, limit int, paged bool, err error) {
+	before, err = parseSequence(q, "before")
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	limit = deploymentLogPageSize
+	if l := q.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxLogPageSize {
+			return 0, 0, false, errors.New("limit must be a number between 1 and 5000")
+		}
+	}
+
+	return before, limit, q.Get("before") != "" || q.Get("limit") != "", nil
+}
+
+// deploymentLogOutputAfter returns the sequence after which the deployment
+// page shows the command output right away, so long logs don't have to be
+// rendered completely.
+func deploymentLogOutputAfter(d *models.Deployment) (int, error) {
+	count, _, err := getLogEntriesVersion(db, d)
+	if err != nil || count <= deploymentLogPageSize {
+		return 0, err
+	}
+	return count - deploymentLogPageSize, nil
+}
+
+// deploymentLogEntriesHandler returns a page of the log of a deployment as
+// JSON, for the deployment page to load the earlier entries on demand. It
+// takes the filter of parseLogEntryFilter and the page of parseLogPage.
+func deploymentLogEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	id, err := strconv.Atoi(mux.Vars(r)["deploymentId"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if deployment == nil || deployment.ApplicationName != application.Name {
+		http.NotFound(w, r)
+		return
+	}
+
+	filter, err := parseLogEntryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	before, limit, _, err := parseLogPage(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	logEntries, err := getDeploymentLogEntriesPage(db, deployment, filter, before, limit)
+	if err != nil {
+		requestLogger(r).Error("error loading logentries", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	js, err := json.Marshal(logEntries)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(js)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParseLogPage(t *testing.T) {
+	tests := []struct {
+		query          string
+		expectedBefore int
+		expectedLimit  int
+		expectedPaged  bool
+		expectedErr    bool
+	}{
+		{"", 0, deploymentLogPageSize, false, false},
+		{"before=1200", 1200, deploymentLogPageSize, true, false},
+		{"before=1200&limit=100", 1200, 100, true, false},
+		{"limit=100", 0, 100, true, false},
+		{"before=first", 0, 0, false, true},
+		{"limit=0", 0, 0, false, true},
+		{"limit=5001", 0, 0, false, true},
+	}
+
+	for _, tt := range tests {
+		q, err := url.ParseQuery(tt.query)
+		checkErr(t, err)
+
+		before, limit, paged, err := parseLogPage(q)
+		if (err != nil) != tt.expectedErr {
+			t.Errorf("wrong error for %q. got=%v", tt.query, err)
+			continue
+		}
+		if before != tt.expectedBefore || limit != tt.expectedLimit || paged != tt.expectedPaged {
+			t.Errorf("wrong page for %q. want=%d, %d, %t, got=%d, %d, %t", tt.query,
+				tt.expectedBefore, tt.expectedLimit, tt.expectedPaged, before, limit, paged)
+		}
+	}
+}
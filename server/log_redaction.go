@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// redactedMask replaces the secrets in the logs of deployments.
+const redactedMask = "[REDACTED]"
+
+// Shorter secrets aren't masked, they'd mask ordinary words and numbers.
+const minRedactedSecretLength = 6
+
+// The longest match of the redaction patterns that's masked even if the
+// output of a command splits it across log entries.
+const redactionPatternOverlap = 256
+
+// defaultRedactionPatterns match common credentials in the output of
+// commands. Only the first group is masked if the pattern has one, the whole
+// match otherwise.
+var defaultRedactionPatterns = []string{
+	// Passwords in URLs, e.g. DATABASE_URL=postgres://app:password@db/app
+	`[a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]+:([^@\s]+)@`,
+	// Settings like DB_PASSWORD=..., --api-key=... or "secret": "..."
+	`(?i)[a-z0-9_.-]*(?:password|passwd|secret|token|api_?key|access_?key)[a-z0-9_.-]*["']?\s*[=:]\s*["']?([^\s"',]+)`,
+	// Authorization headers
+	`(?i)\bbearer\s+([a-z0-9._~+/-]{8,}=*)`,
+	// Tokens of GitHub, GitLab and Slack
+	`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`,
+	`\bglpat-[A-Za-z0-9_-]{20,}\b`,
+	`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`,
+	// AWS access key IDs
+	`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+}
+
+// compileRedactionPatterns compiles the default patterns and the
+// log_redaction_patterns.
+func compileRedactionPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := []*regexp.Regexp{}
+	for _, p := range defaultRedactionPatterns {
+		compiled = append(compiled, regexp.MustCompile(p))
+	}
+
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %s", p, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// LogRedactor masks secrets in the messages of log entries before they're
+// saved and broadcast: the values of known secrets and everything matching
+// one of the patterns.
+type LogRedactor struct {
+	patterns []*regexp.Regexp
+
+	mu sync.RWMutex
+	// The longest secret first, so it's masked before a secret it contains
+	secrets []string
+}
+
+func NewLogRedactor(patterns []*regexp.Regexp) *LogRedactor {
+	return &LogRedactor{patterns: patterns}
+}
+
+// AddSecrets adds secrets whose values are masked. Empty and short ones are
+// left out.
+func (r *LogRedactor) AddSecrets(secrets ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, s := range secrets {
+		if len(s) < minRedactedSecretLength || containsString(r.secrets, s) {
+			continue
+		}
+		r.secrets = append(r.secrets, s)
+	}
+	sort.SliceStable(r.secrets, func(i, j int) bool {
+		return len(r.secrets[i]) > len(r.secrets[j])
+	})
+}
+
+// Redact returns the message with the secrets masked.
+func (r *LogRedactor) Redact(msg string) string {
+	r.mu.RLock()
+	for _, s := range r.secrets {
+		msg = strings.Replace(msg, s, redactedMask, -1)
+	}
+	r.mu.RUnlock()
+
+	for _, p := range r.patterns {
+		msg = maskPattern(p, msg)
+	}
+	return msg
+}
+
+// Overlap returns how much of the output of commands has to be held back to
+// mask secrets split across log entries: the length of the longest secret,
+// at least redactionPatternOverlap.
+func (r *LogRedactor) Overlap() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.secrets) > 0 && len(r.secrets[0]) > redactionPatternOverlap {
+		return len(r.secrets[0])
+	}
+	return redactionPatternOverlap
+}
+
+// maskPattern masks the first group of every match of p, or the whole match
+// if p has no groups.
+func maskPattern(p *regexp.Regexp, msg string) string {
+	matches := p.FindAllStringSubmatchIndex(msg, -1)
+	if matches == nil {
+		return msg
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		start, end := m[0], m[1]
+		if len(m) >= 4 && m[2] >= 0 {
+			start, end = m[2], m[3]
+		}
+		b.WriteString(msg[last:start])
+		b.WriteString(redactedMask)
+		last = end
+	}
+	b.WriteString(msg[last:])
+	return b.String()
+}
+
+// The secrets resolved from references like vault:secret/applikatoni#token,
+// which are masked in the logs of every deployment.
+var (
+	resolvedSecretsMu sync.Mutex
+	resolvedSecrets   = map[string]bool{}
+)
+
+func rememberResolvedSecret(secret string) {
+	resolvedSecretsMu.Lock()
+	resolvedSecrets[secret] = true
+	resolvedSecretsMu.Unlock()
+}
+
+func resolvedSecretValues() []string {
+	resolvedSecretsMu.Lock()
+	defer resolvedSecretsMu.Unlock()
+
+	values := make([]string, 0, len(resolvedSecrets))
+	for s := range resolvedSecrets {
+		values = append(values, s)
+	}
+	return values
+}
+
+// newDeploymentRedactor returns the redactor of the logs of a deployment to
+// the target. Besides the log_redaction_secrets it masks the secrets of the
+// configuration, the target and its hosts and every resolved secret.
+func newDeploymentRedactor(c *Configuration, t *models.Target) *LogRedactor {
+	r := NewLogRedactor(c.redactionPatterns)
+
+	r.AddSecrets(c.LogRedactionSecrets...)
+	r.AddSecrets(c.SessionSecretList()...)
+	r.AddSecrets(c.Oauth2StateString, c.EncryptionKey, c.MetricsToken, c.SlackBotToken, c.SlackSigningSecret,
+		c.GitHubClientSecret, c.GitLabClientSecret, c.BitbucketClientSecret, c.GiteaClientSecret, c.OIDCClientSecret,
+		c.MandrillAPIKey, c.MailgunAPIKey, c.VaultToken, c.VaultSecretId)
+	r.AddSecrets(t.SudoPassword, t.SshKeyPassphrase, t.BugsnagApiKey, t.NewRelicApiKey, t.SlackUrl, t.DigestSlackUrl, t.ApprovalSlackUrl, t.ApprovalTeamsUrl)
+	if t.Statuspage != nil {
+		r.AddSecrets(t.Statuspage.ApiKey)
+	}
+	for _, h := range t.Hosts {
+		r.AddSecrets(h.SshKeyPassphrase)
+	}
+	r.AddSecrets(resolvedSecretValues()...)
+
+	return r
+}
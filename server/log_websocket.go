@@ -0,0 +1,169 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// Writing a log entry to a client that doesn't read fails after this
+	// time, so it doesn't keep the log of the deployment from being routed
+	websocketWriteTimeout = 10 * time.Second
+	// Clients have to answer a ping within this time, otherwise the
+	// connection is closed
+	websocketPongTimeout  = 60 * time.Second
+	websocketPingInterval = websocketPongTimeout * 9 / 10
+)
+
+// The close reason telling clients that fell behind to reconnect
+const websocketFellBehindReason = "fell behind, reconnect with after"
+
+// deploymentWsHandler streams the log entries of a deployment over a
+// websocket. Every client subscribes to the log of its deployment only, so
+// chatty deployments don't slow down the clients of other deployments. The
+// entries written so far are replayed first. The entries can be filtered,
+// see parseLogEntryFilter.
+//
+// Clients that can't keep up are disconnected, instead of holding up the
+// log: either their buffer in the log router fills up or writing an entry
+// takes longer than websocketWriteTimeout. While the deployment is running,
+// the connection is closed with websocket.CloseTryAgainLater then, and
+// clients reconnect with the sequence of the last entry they received as
+// after parameter.
+func deploymentWsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["deploymentId"])
+	if err != nil {
+		requestLogger(r).Error("error converting ID passed to server", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deployment, err := getDeployment(db, id)
+	if err != nil {
+		requestLogger(r).Error("error loading deployment", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	filter, err := parseLogEntryFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	upgrader := &websocket.Upgrader{ReadBufferSize: 1024, WriteBufferSize: 1024}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		requestLogger(r).Error("error upgrading the connection to websocket", "err", err)
+		return
+	}
+	defer ws.Close()
+	metrics.WebsocketConnected()
+	defer metrics.WebsocketDisconnected()
+
+	go keepWsAlive(ws)
+
+	// Buffered, so the listener can return after the handler did
+	doneStreaming := make(chan error, 1)
+
+	err = eventBus.OnDeploymentLogEntries(id, makeWebsocketListener(ws, doneStreaming, filter))
+	if err == deploy.ErrNoDeployment {
+		logEntries, err := getDeploymentLogEntries(db, deployment)
+		if err != nil {
+			requestLogger(r).Error("error loading logentries", "err", err)
+			closeWs(ws, websocket.CloseInternalServerErr, "could not load log")
+			return
+		}
+
+		go streamLogEntries(ws, doneStreaming, filter.Filter(logEntries))
+	}
+
+	ping := time.NewTicker(websocketPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case err := <-doneStreaming:
+			if err != nil {
+				requestLogger(r).Warn("error writing to websocket", "remote_addr", ws.RemoteAddr(), "err", err)
+				return
+			}
+			if eventBus.IsDeploymentRunning(id) {
+				requestLogger(r).Warn("websocket client fell behind, disconnecting", "remote_addr", ws.RemoteAddr())
+				closeWs(ws, websocket.CloseTryAgainLater, websocketFellBehindReason)
+				return
+			}
+			closeWs(ws, websocket.CloseNormalClosure, "")
+			return
+		case <-ping.C:
+			err := ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketWriteTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// makeWebsocketListener returns a listener writing the routed log entries
+// matching the filter to the websocket. It sends nil to done once the log
+// router closed its channel, or the error if writing failed.
+func makeWebsocketListener(ws *websocket.Conn, done chan<- error, filter *logEntryFilter) deploy.Listener {
+	return func(logs <-chan deploy.LogEntry) {
+		for entry := range logs {
+			// Entries are still received, so the router doesn't time out
+			// sending to this listener
+			if !filter.Matches(&entry) {
+				continue
+			}
+			if err := writeWsLogEntry(ws, &entry); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}
+}
+
+func streamLogEntries(ws *websocket.Conn, done chan<- error, logs []*deploy.LogEntry) {
+	for _, entry := range logs {
+		if err := writeWsLogEntry(ws, entry); err != nil {
+			done <- err
+			return
+		}
+	}
+	done <- nil
+}
+
+// writeWsLogEntry writes the entry, giving up after websocketWriteTimeout.
+func writeWsLogEntry(ws *websocket.Conn, entry *deploy.LogEntry) error {
+	ws.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+	return ws.WriteJSON(entry)
+}
+
+func closeWs(ws *websocket.Conn, code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(websocketWriteTimeout))
+}
+
+func keepWsAlive(ws *websocket.Conn) {
+	ws.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	ws.SetPongHandler(func(string) error {
+		return ws.SetReadDeadline(time.Now().Add(websocketPongTimeout))
+	})
+
+	// We repeatedly read from the websocket connections and discard
+	// the reader in order to process the underlying ping/pong messages
+	// of the websocket connection
+	for {
+		_, _, err := ws.NextReader()
+		if err != nil {
+			ws.Close()
+			break
+		}
+	}
+}
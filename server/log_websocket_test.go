@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+func TestDeploymentWsHandlerReplaysFinishedDeployments(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	eventBus = NewEventBus(db, deploy.NewLogRouter())
+	defer func() { eventBus = nil }()
+
+	deployment := buildDeployment(1)
+	checkErr(t, createDeployment(db, deployment))
+
+	for _, message := range []string{"first", "second", "third"} {
+		entry := &deploy.LogEntry{
+			DeploymentId: deployment.Id,
+			EntryType:    deploy.COMMAND_STDOUT_OUTPUT,
+			Message:      message,
+			Timestamp:    time.Now(),
+		}
+		checkErr(t, createLogEntry(db, entry))
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{application}/deployments/{deploymentId}/log", deploymentWsHandler)
+	ts := httptest.NewServer(router)
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/" + deployment.ApplicationName + "/deployments/" + strconv.Itoa(deployment.Id) + "/log?after=1"
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	checkErr(t, err)
+	defer ws.Close()
+
+	received := []deploy.LogEntry{}
+	for {
+		var entry deploy.LogEntry
+		err := ws.ReadJSON(&entry)
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				t.Errorf("connection not closed normally. got=%s", err)
+			}
+			break
+		}
+		received = append(received, entry)
+	}
+
+	if len(received) != 2 {
+		t.Fatalf("wrong number of log entries. want=%d, got=%d", 2, len(received))
+	}
+	if received[0].Message != "second" || received[0].Sequence != 2 || received[1].Message != "third" {
+		t.Errorf("wrong log entries after sequence 1. got=%+v", received)
+	}
+}
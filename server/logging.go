@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// The formats of the logs, picked with -log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// newLogHandler returns the handler writing the logs to w, as key=value pairs
+// or as one JSON object per line, which log aggregation can index.
+func newLogHandler(w io.Writer, level, format string) (slog.Handler, error) {
+	l, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return nil, fmt.Errorf("invalid log level %q, use debug, info, warn or error", level)
+	}
+	opts := &slog.HandlerOptions{Level: l}
+
+	switch strings.ToLower(format) {
+	case logFormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case logFormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q, use text or json", format)
+	}
+}
+
+// setupLogging makes the handler the default of slog and of the log package,
+// so the logs of libraries end up in the same format.
+func setupLogging(w io.Writer, level, format string) error {
+	h, err := newLogHandler(w, level, format)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal logs the error and exits, like log.Fatal.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	if sentryClient != nil {
+		sentryClient.Flush(sentryFlushTimeout)
+	}
+	os.Exit(1)
+}
+
+// requestLogger returns the logger for the logs of a request, which adds its
+// ID, method and path, so the logs of a failed request can be found.
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.With(
+		"request_id", r.Header.Get(requestIdHeader),
+		"method", r.Method,
+		"path", r.URL.Path,
+	)
+}
+
+// deploymentLogger returns the logger for the logs about a deployment, which
+// adds its ID, application, target and commit.
+func deploymentLogger(d *models.Deployment) *slog.Logger {
+	return slog.With("deployment", d)
+}
+
+// logRequests logs every request with its status and duration. It replaces
+// the access log, so requests are logged in the same format.
+func logRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(rec, r)
+
+		requestLogger(r).Info("request",
+			"status", rec.status,
+			"duration", time.Since(start),
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestNewLogHandler(t *testing.T) {
+	tests := []struct {
+		level  string
+		format string
+		err    string
+	}{
+		{"info", "text", ""},
+		{"DEBUG", "json", ""},
+		{"verbose", "text", `invalid log level "verbose"`},
+		{"warn", "xml", `invalid log format "xml"`},
+	}
+
+	for _, tt := range tests {
+		_, err := newLogHandler(&bytes.Buffer{}, tt.level, tt.format)
+		if tt.err == "" && err != nil {
+			t.Errorf("unexpected error for %s/%s: %s", tt.level, tt.format, err)
+		}
+		if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("wrong error for %s/%s. want=%q, got=%v", tt.level, tt.format, tt.err, err)
+		}
+	}
+}
+
+// captureLogs makes a JSON handler writing to the returned buffer the default
+// until the returned function is called.
+func captureLogs(t *testing.T, level string) (*bytes.Buffer, func()) {
+	var out bytes.Buffer
+	h, err := newLogHandler(&out, level, logFormatJSON)
+	checkErr(t, err)
+
+	old := slog.Default()
+	slog.SetDefault(slog.New(h))
+	return &out, func() { slog.SetDefault(old) }
+}
+
+func decodeLogLine(t *testing.T, out *bytes.Buffer) map[string]interface{} {
+	var line map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("invalid log line %q: %s", out.String(), err)
+	}
+	return line
+}
+
+func TestLogRequests(t *testing.T) {
+	out, restore := captureLogs(t, "info")
+	defer restore()
+
+	h := withRequestId(logRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	})))
+
+	r := httptest.NewRequest("GET", "/web/deployments/1", nil)
+	r.Header.Set(requestIdHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := decodeLogLine(t, out)
+	expected := map[string]interface{}{
+		"level":      "INFO",
+		"msg":        "request",
+		"request_id": "req-1",
+		"method":     "GET",
+		"path":       "/web/deployments/1",
+		"status":     float64(http.StatusNotFound),
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("wrong %s. want=%v, got=%v", key, value, line[key])
+		}
+	}
+}
+
+func TestDeploymentLogger(t *testing.T) {
+	out, restore := captureLogs(t, "warn")
+	defer restore()
+
+	d := &models.Deployment{Id: 42, ApplicationName: "web", TargetName: "production", CommitSha: "f00b4r"}
+	deploymentLogger(d).Info("filtered by the level")
+	if out.Len() != 0 {
+		t.Fatalf("info logged at level warn. got=%s", out.String())
+	}
+
+	deploymentLogger(d).Warn("deployment failed, giving up", "retries", 3)
+
+	line := decodeLogLine(t, out)
+	deployment, _ := line["deployment"].(map[string]interface{})
+	expected := map[string]interface{}{
+		"id":          float64(42),
+		"application": "web",
+		"target":      "production",
+		"commit_sha":  "f00b4r",
+	}
+	for key, value := range expected {
+		if deployment[key] != value {
+			t.Errorf("wrong deployment.%s. want=%v, got=%v", key, value, deployment[key])
+		}
+	}
+	if line["retries"] != float64(3) {
+		t.Errorf("wrong retries. want=3, got=%v", line["retries"])
+	}
+}
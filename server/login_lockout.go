@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	defaultLoginMaxFailures     = 10
+	defaultLoginLockoutDuration = 15 * time.Minute
+)
+
+// The lockout of IPs after failed logins and API token checks. nil if
+// disabled.
+var loginLockout *LoginLockout
+
+var errLoginLockedOut = errors.New("too many failed logins, try again later")
+
+// LoginLockout locks out an IP for a while once it failed to log in or to
+// authenticate with an API token too often, so tokens and OAuth2 callbacks
+// can't be guessed.
+type LoginLockout struct {
+	// The failures allowed within Duration, and how long the IP is locked
+	// out after them
+	MaxFailures int
+	Duration    time.Duration
+
+	mu        sync.Mutex
+	ips       map[string]*loginFailures
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type loginFailures struct {
+	first       time.Time
+	count       int
+	lockedUntil time.Time
+}
+
+func NewLoginLockout(maxFailures int, duration time.Duration) *LoginLockout {
+	return &LoginLockout{
+		MaxFailures: maxFailures,
+		Duration:    duration,
+		ips:         make(map[string]*loginFailures),
+		now:         time.Now,
+	}
+}
+
+// Locked checks whether the IP is locked out and returns until when.
+func (l *LoginLockout) Locked(ip string) (bool, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.ips[ip]
+	if !ok || !l.now().Before(f.lockedUntil) {
+		return false, time.Time{}
+	}
+	return true, f.lockedUntil
+}
+
+// Fail counts a failure of the IP. It returns true if the failure locked the
+// IP out.
+func (l *LoginLockout) Fail(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	f, ok := l.ips[ip]
+	if !ok || now.Sub(f.first) >= l.Duration {
+		f = &loginFailures{first: now}
+		l.ips[ip] = f
+	}
+
+	f.count++
+	if f.count < l.MaxFailures || now.Before(f.lockedUntil) {
+		return false
+	}
+
+	// Count the failures anew once the lockout ends
+	f.lockedUntil = now.Add(l.Duration)
+	f.first = f.lockedUntil
+	f.count = 0
+	return true
+}
+
+// sweep forgets the IPs without recent failures once per Duration, like the
+// RateLimiter.
+func (l *LoginLockout) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Duration {
+		return
+	}
+	for ip, f := range l.ips {
+		if now.Sub(f.first) >= l.Duration && !now.Before(f.lockedUntil) {
+			delete(l.ips, ip)
+		}
+	}
+	l.lastSweep = now
+}
+
+// setupLoginLockout creates the lockout configured by login_max_failures and
+// login_lockout.
+func setupLoginLockout(c *Configuration) {
+	duration, _ := c.LoginLockoutDuration()
+
+	loginLockout = nil
+	if max := rateLimit(c.LoginMaxFailures, defaultLoginMaxFailures); max > 0 {
+		loginLockout = NewLoginLockout(max, duration)
+	}
+}
+
+// loginLockedOut returns until when the IP of the request is locked out, the
+// zero time if it isn't.
+func loginLockedOut(r *http.Request) time.Time {
+	if loginLockout == nil {
+		return time.Time{}
+	}
+	_, until := loginLockout.Locked(remoteIP(r))
+	return until
+}
+
+// loginFailed counts a failed login or API token check of the IP of the
+// request. Locking the IP out is recorded in the audit log.
+func loginFailed(r *http.Request, what string) {
+	if loginLockout == nil {
+		return
+	}
+
+	ip := remoteIP(r)
+	if !loginLockout.Fail(ip) {
+		return
+	}
+
+	requestLogger(r).Warn("locked out IP after failed logins", "ip", ip, "failed", what, "failures", loginLockout.MaxFailures)
+	subject := fmt.Sprintf("%s, %d failures", what, loginLockout.MaxFailures)
+	recordAuditEvent(r, &models.User{}, models.AUDIT_LOGIN_LOCKOUT, subject)
+}
+
+// lockedOutSeconds returns the seconds until the lockout ends, for the
+// Retry-After header.
+func lockedOutSeconds(until time.Time) int {
+	seconds := int(time.Until(until).Seconds() + 0.5)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// loginLockedOutIPs rejects the logins of locked out IPs with a 429.
+func loginLockedOutIPs(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if until := loginLockedOut(r); !until.IsZero() {
+			w.Header().Set("Retry-After", strconv.Itoa(lockedOutSeconds(until)))
+			http.Error(w, errLoginLockedOut.Error(), http.StatusTooManyRequests)
+			return
+		}
+
+		fn(w, r)
+	}
+}
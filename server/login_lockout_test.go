@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLoginLockout(t *testing.T) {
+	start := time.Date(2015, 1, 26, 10, 0, 0, 0, time.UTC)
+	now := start
+
+	l := NewLoginLockout(3, 10*time.Minute)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		ip             string
+		after          time.Duration
+		expectedLocked bool
+	}{
+		{"10.0.0.1", 0, false},
+		{"10.0.0.1", time.Minute, false},
+		// Another IP doesn't count
+		{"10.0.0.2", 2 * time.Minute, false},
+		{"10.0.0.1", 3 * time.Minute, true},
+		// The failures of 10.0.0.2 are forgotten after 10 minutes
+		{"10.0.0.2", 12 * time.Minute, false},
+		{"10.0.0.2", 13 * time.Minute, false},
+	}
+
+	for _, tt := range tests {
+		now = start.Add(tt.after)
+		if locked := l.Fail(tt.ip); locked != tt.expectedLocked {
+			t.Errorf("wrong lockout of %s after %s. want=%t, got=%t", tt.ip, tt.after, tt.expectedLocked, locked)
+		}
+	}
+
+	now = start.Add(12 * time.Minute)
+	if locked, until := l.Locked("10.0.0.1"); !locked || !until.Equal(start.Add(13*time.Minute)) {
+		t.Errorf("10.0.0.1 not locked out until 10:13. got=%t until %s", locked, until)
+	}
+	if locked, _ := l.Locked("10.0.0.2"); locked {
+		t.Errorf("10.0.0.2 locked out")
+	}
+
+	now = start.Add(13 * time.Minute)
+	if locked, _ := l.Locked("10.0.0.1"); locked {
+		t.Errorf("10.0.0.1 still locked out after the lockout")
+	}
+}
+
+func TestLoginLockedOutIPs(t *testing.T) {
+	setupLoginLockout(&Configuration{LoginMaxFailures: 1})
+	defer setupLoginLockout(&Configuration{LoginMaxFailures: -1})
+
+	h := loginLockedOutIPs(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	newRequest := func(ip string) *http.Request {
+		req := httptest.NewRequest("GET", "/oauth2/callback", nil)
+		req.RemoteAddr = ip + ":1234"
+		return req
+	}
+
+	loginLockout.Fail("10.0.0.1")
+
+	rec := httptest.NewRecorder()
+	h(rec, newRequest("10.0.0.1"))
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "900" {
+		t.Errorf("locked out IP not rejected. got=%d, Retry-After=%q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	h(rec, newRequest("10.0.0.2"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("other IP rejected. got=%d", rec.Code)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 type MailgunClient struct {
 	*http.Client
+	baseURL    string
 	requestURL string
 	apiKey     string
 }
@@ -19,6 +20,7 @@ func NewMailgunClient(baseURL, apiKey string) *MailgunClient {
 
 	return &MailgunClient{
 		Client:     &http.Client{},
+		baseURL:    baseURL,
 		requestURL: requestURL,
 		apiKey:     apiKey,
 	}
@@ -43,6 +45,27 @@ func (m *MailgunClient) SendDigest(digest *DailyDigest) error {
 	return nil
 }
 
+// Ping checks the API key and domain by loading the latest event, without
+// sending anything.
+func (m *MailgunClient) Ping() error {
+	req, err := http.NewRequest("GET", m.baseURL+"/events?limit=1", nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth("api", m.apiKey)
+
+	resp, err := m.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("mailgun status code not 200. got=%d", resp.StatusCode)
+	}
+	return nil
+}
+
 func (m *MailgunClient) newRequestBody(digest *DailyDigest) io.Reader {
 	params := url.Values{
 		"from":    {fmt.Sprintf("%s <%s>", digestFromName, digestFromEmail)},
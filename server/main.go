@@ -1,22 +1,24 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"syscall"
 
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/github"
 
 	"github.com/applikatoni/applikatoni/deploy"
-	"github.com/applikatoni/applikatoni/models"
 
-	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 
@@ -50,24 +52,38 @@ const BANNER = `
 
 var (
 	outputVersion         = flag.Bool("v", false, "output the version of Applikatoni")
-	configurationFilePath = flag.String("conf", "configuration.json", "path to configuration file")
+	configurationFilePath = flag.String("conf", "configuration.json", "path to configuration file, JSON, YAML (.yml, .yaml) or TOML (.toml)")
 	port                  = flag.String("port", ":8080", "port to listen on")
 	databasePath          = flag.String("db", "./db/development.db", "path to sqlite3 database file")
-	templatesPath         = flag.String("templates", "./assets/templates", "path to template files")
+	assetsPath            = flag.String("assets", "", "path to the assets to serve instead of the ones built in, e.g. ./assets during development")
+	templatesPath         = flag.String("templates", "", "path to template files, defaults to the templates of the assets")
 	env                   = flag.String("env", "development", "environment applikatoni is used in")
 	dbConfDir             = flag.String("dbconfdir", "./db", "path to directory of dbconf.yml")
 	migrationDir          = flag.String("migrationdir", "./db/migrations", "path to migrations files")
+	logLevel              = flag.String("log-level", "info", "minimum level of the logs: debug, info, warn or error")
+	logFormat             = flag.String("log-format", "text", "format of the logs: text or json")
+	logFilePath           = flag.String("log-file", "", "path of the file to write the logs to instead of stderr, reopened on SIGUSR1")
+	logMaxSize            = flag.Int("log-max-size", 0, "size in megabytes at which the -log-file is rotated, 0 to not rotate by size")
+	logRotateInterval     = flag.Duration("log-rotate-interval", 0, "age at which the -log-file is rotated, e.g. 24h, 0 to not rotate by age")
+	logMaxFiles           = flag.Int("log-max-files", 7, "number of rotated log files to keep, 0 to keep all")
+	debugAddr             = flag.String("debug-addr", "", "localhost address to serve /debug/pprof/ and /debug/vars on without login, e.g. localhost:6060")
 )
 
 var (
-	logRouter    *deploy.LogRouter
-	config       *Configuration
-	db           *sql.DB
-	sessionStore *sessions.CookieStore
-	templates    map[string]*template.Template
-	oauthCfg     *oauth2.Config
-	killRegistry *KillRegistry
-	eventHub     *DeploymentEventHub
+	logRouter        *deploy.LogRouter
+	currentConfig    atomic.Pointer[Configuration]
+	db               *sql.DB
+	sessionStore     *sessions.CookieStore
+	assetsFS         fs.FS
+	templatesFS      fs.FS
+	templates        map[string]map[string]*template.Template
+	oauthCfg         *oauth2.Config
+	authProviders    []AuthProvider
+	samlProvider     *SAMLProvider
+	killRegistry     *KillRegistry
+	approvalRegistry *ApprovalRegistry
+	deploymentQueue  *DeploymentQueue
+	eventBus         *EventBus
 )
 
 var (
@@ -78,11 +94,28 @@ var (
 		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "toni_configuration.tmpl"},
 		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "application.tmpl"},
 		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "deployments.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "calendar.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "dashboard.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "targets.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "approvals.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "search.tmpl"},
 		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "deployment.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "profile.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "preferences.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "admin_users.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "admin_audit.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "admin_applications.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "admin_deliveries.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "admin_active_deployments.tmpl"},
+		{"layout.tmpl", "hogan_templates.tmpl", "partials.tmpl", "digest_unsubscribe.tmpl"},
 	}
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-config" {
+		os.Exit(checkConfigCommand(os.Stdout, os.Args[2:]))
+	}
+
 	flag.Parse()
 
 	if *outputVersion {
@@ -90,132 +123,295 @@ func main() {
 		return
 	}
 
+	var logOutput io.Writer = os.Stderr
+	if *logFilePath != "" {
+		logFile, err := OpenLogFile(*logFilePath, int64(*logMaxSize)*1024*1024, *logRotateInterval, *logMaxFiles)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		go reopenLogFileOnSignal(logFile)
+		logOutput = logFile
+	}
+	if err := setupLogging(logOutput, *logLevel, *logFormat); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *debugAddr != "" {
+		if err := checkDebugAddr(*debugAddr); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
 	var err error
-	config, err = readConfiguration(*configurationFilePath)
+	// Reloads only change the applications, service accounts and admins, the
+	// settings used here stay the same
+	config, err := readConfiguration(*configurationFilePath)
+	if err != nil {
+		fatal("could not read configuration", "err", err)
+	}
+	setConfig(config)
+	logConfigurationWarnings(config)
+
+	// Report the errors of the server to Sentry
+	if config.SentryDSN != "" {
+		environment := config.SentryEnvironment
+		if environment == "" {
+			environment = *env
+		}
+		// The DSN has been validated when reading the configuration
+		sentryClient, _ = NewSentryClient(config.SentryDSN, environment, VERSION)
+		sentryClient.Start()
+		slog.SetDefault(slog.New(newSentryHandler(slog.Default().Handler(), sentryClient)))
+	}
+
+	err = readSshKeyPassphrases(config, promptPassphrase)
+	if err != nil {
+		fatal("could not decrypt SSH keys", "err", err)
+	}
+
+	if config.EncryptionKey != "" {
+		fieldCipher, err = newFieldCipher(config.EncryptionKey)
+		if err != nil {
+			fatal("invalid encryption_key", "err", err)
+		}
+	}
+
+	assetsFS, err = openAssets(*assetsPath)
+	if err != nil {
+		fatal("could not open assets", "err", err)
+	}
+	templatesFS, err = openTemplates(assetsFS, *templatesPath)
 	if err != nil {
-		log.Fatal("could not read configuration", err)
+		fatal("could not open templates", "err", err)
 	}
 
-	templates, err = parseTemplates(*templatesPath, templatesFiles)
+	templates, err = parseTemplates(templatesFS, templatesFiles)
 	if err != nil {
-		log.Fatal("Parsing templates failed", err)
+		fatal("parsing templates failed", "err", err)
 	}
 
 	dbPath := fmt.Sprintf("%s?cache=shared&_busy_timeout=%s",
 		*databasePath, dbBusyTimeout)
 	db, err = sql.Open("sqlite3", dbPath)
 	if err != nil {
-		log.Fatal("could not open sqlite3 database file", err)
+		fatal("could not open sqlite3 database file", "err", err)
 	}
 	defer db.Close()
 
 	migrated, err := isMigrated(db)
 	if err != nil {
-		log.Fatal("could not check if database is migrated. Error: ", err)
+		fatal("could not check if database is migrated", "err", err)
 	}
 	if !migrated {
-		log.Fatal("please migrate the database to the newest version")
+		fatal("please migrate the database to the newest version")
+	}
+
+	// If there are deployments in state 'new'/'active'/'queued' when booting up
+	// Applikatoni probably crashed with a deployment running. Report how far
+	// the active ones got and set all of them to 'failed' so we can start
+	// other deployments.
+	err = recoverUnfinishedDeployments(db)
+	if err != nil {
+		fatal("setting unfinished deployments to 'failed' failed", "err", err)
+	}
+
+	// Encrypt the tokens saved before the encryption_key was configured
+	err = encryptStoredSecrets(db)
+	if err != nil {
+		fatal("encrypting the stored tokens failed", "err", err)
 	}
 
-	// If there are deployments in state 'new'/'active' when booting up
-	// Applikatoni probably crashed with a deployment running. Set these to
-	// 'failed' so we can start other deployments.
-	err = failUnfinishedDeployments(db)
+	err = syncServiceAccounts(db, config.ServiceAccounts)
 	if err != nil {
-		log.Fatal("setting unfinished deployments to 'failed' failed", err)
+		fatal("setting up service accounts failed", "err", err)
 	}
 
+	// Add the applications managed by admins to the ones of the file
+	managedApplications, err := loadManagedApplications(db, config)
+	if err != nil {
+		fatal("loading the applications from the database failed", "err", err)
+	}
+	applyManagedApplications(config, managedApplications)
+
 	oauthCfg = &oauth2.Config{
 		ClientID:     config.GitHubClientId,
 		ClientSecret: config.GitHubClientSecret,
-		Scopes:       []string{"user", "repo"},
-		Endpoint:     github.Endpoint,
+		Scopes:       []string{"user", "repo", "read:org"},
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  config.GitHubBaseURL() + "/login/oauth/authorize",
+			TokenURL: config.GitHubBaseURL() + "/login/oauth/access_token",
+		},
+	}
+	authProviders, err = setupAuthProviders(config)
+	if err != nil {
+		fatal("setting up login providers failed", "err", err)
+	}
+	if config.SAMLIDPMetadataURL != "" {
+		samlProvider, err = NewSAMLProvider(config)
+		if err != nil {
+			fatal("setting up SAML failed", "err", err)
+		}
+	}
+	githubApp, err = newGitHubAppFromConfig(config)
+	if err != nil {
+		fatal("setting up the GitHub App failed", "err", err)
+	}
+	if len(authProviders) == 0 && samlProvider == nil {
+		fatal("no login provider configured. Set github_client_id, gitlab_client_id, bitbucket_client_id, gitea_client_id, oidc_issuer_url or saml_idp_metadata_url")
 	}
 
 	// Setup the killRegistry to connect deployment managers to the kill button
 	killRegistry = NewKillRegistry()
+	// Setup the approvalRegistry to connect paused deployments to the continue button
+	approvalRegistry = NewApprovalRegistry()
+	// Setup the deploymentQueue holding deployments waiting for their target
+	deploymentQueue = NewDeploymentQueue()
+
+	// Limit the requests of API clients and webhooks
+	setupRateLimiters(config)
+	setupLoginLockout(config)
+
+	// Keep the cached GitHub organizations and teams of the users up to date
+	if findAuthProvider(GITHUB_PROVIDER) != nil {
+		interval, _ := config.MembershipSyncInterval()
+		go SyncGitHubMemberships(db, interval)
+	}
 
-	// Run the daily digest sending in the background
+	// Run the digest sending in the background. Digests are posted to Slack
+	// even without an email sender.
 	digestSender := config.DailyDigestSender()
+	go SendDailyDigests(db, digestSender)
 	if digestSender != nil {
-		go SendDailyDigests(db, digestSender)
+		go SendWeeklySummaries(db, digestSender)
 	}
 
+	// Reload the applications on SIGHUP without dropping running deployments
+	go reloadConfigurationOnSignal(*configurationFilePath)
+
 	// Setup session store
-	sessionStore = sessions.NewCookieStore([]byte(config.SessionSecret))
+	sessionStore = sessions.NewCookieStore(sessionKeyPairs(config.SessionSecretList())...)
+	sessionTTL, _ := config.SessionTimeout()
+	sessionStore.MaxAge(int(sessionTTL.Seconds()))
 
-	// Initialize global LogRouter
+	// Initialize global LogRouter, closed when shutting down
 	logRouter = deploy.NewLogRouter()
-	defer logRouter.Stop()
 	logRouter.Start()
 
-	// Setup a basic listener that prints the logs of all deployments
-	logRouter.SubscribeAll(deploy.ConsoleLogger)
-	// Setup the listener that persists all log entries
-	logRouter.SubscribeAll(newLogEntrySaver(db))
-
-	// Initialize global DeploymentEventHub
-	eventHub = NewDeploymentEventHub(db)
-	// Subscribe the Bugsnag notifier
-	bugsnagStates := []models.DeploymentState{models.DEPLOYMENT_SUCCESSFUL}
-	eventHub.Subscribe(bugsnagStates, NotifyBugsnag)
-	// Subscribe the NewRelic notifier
-	newRelicStates := []models.DeploymentState{models.DEPLOYMENT_SUCCESSFUL}
-	eventHub.Subscribe(newRelicStates, NotifyNewRelic)
-	// Subscribe the Flowdock notifier
-	flowdockStates := []models.DeploymentState{
-		models.DEPLOYMENT_SUCCESSFUL,
-		models.DEPLOYMENT_FAILED,
-	}
-	eventHub.Subscribe(flowdockStates, NotifyFlowdock)
-	// Subscribe the Slack notifier
-	slackStates := []models.DeploymentState{
-		models.DEPLOYMENT_SUCCESSFUL,
-		models.DEPLOYMENT_FAILED,
-	}
-	eventHub.Subscribe(slackStates, NotifySlack)
-	// Subscribe the GitHub notifier to use the Deployments API
-	githubNotifier := NewGitHubNotifier()
-	githubStates := []models.DeploymentState{
-		models.DEPLOYMENT_NEW,
-		models.DEPLOYMENT_ACTIVE,
-		models.DEPLOYMENT_SUCCESSFUL,
-		models.DEPLOYMENT_FAILED,
-	}
-	eventHub.Subscribe(githubStates, githubNotifier.Notify)
-
-	// Subscribe the webhooks
-	webhookStates := []models.DeploymentState{
-		models.DEPLOYMENT_NEW,
-		models.DEPLOYMENT_ACTIVE,
-		models.DEPLOYMENT_SUCCESSFUL,
-		models.DEPLOYMENT_FAILED,
-	}
-	eventHub.Subscribe(webhookStates, NotifyWebhooks)
+	// Initialize global EventBus and the listeners of the deployments
+	eventBus = NewEventBus(db, logRouter)
+	registerListeners(eventBus)
+	notifierTimeout, _ := config.NotifierTimeoutDuration()
+	eventBus.StartListenerWorkers(config.NotifierWorkerCount(), notifierTimeout)
 
 	// Setup the router and the routes
 	r := mux.NewRouter()
 
 	// Assets
-	fsServer := http.FileServer(http.Dir("assets/"))
+	fsServer := http.FileServer(http.FS(assetsFS))
 	assetsServer := http.StripPrefix("/assets/", fsServer)
 
 	r.PathPrefix("/assets/").Handler(assetsServer)
 	r.Handle("/favicon.ico", fsServer)
 
+	// Health checks
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/readyz", readyzHandler).Methods("GET", "HEAD")
+	r.HandleFunc("/metrics", metricsHandler).Methods("GET")
+
 	// OAuth & Login
 	r.HandleFunc("/oauth2/authorize", oauth2authorizeHandler)
-	r.HandleFunc("/oauth2/callback", oauth2callbackHandler)
+	r.HandleFunc("/oauth2/callback", rateLimited(loginLockedOutIPs(oauth2callbackHandler)))
+	r.HandleFunc("/oauth2/{provider}/authorize", oauth2authorizeHandler)
+	r.HandleFunc("/oauth2/{provider}/callback", rateLimited(loginLockedOutIPs(oauth2callbackHandler)))
 	r.HandleFunc("/oauth2/logout", oauth2logoutHandler)
+	r.HandleFunc("/saml/login", samlLoginHandler).Methods("GET")
+	r.HandleFunc("/saml/acs", rateLimited(loginLockedOutIPs(samlACSHandler))).Methods("POST")
+	r.HandleFunc("/saml/metadata", samlMetadataHandler).Methods("GET")
+
+	// API Token
+	r.HandleFunc("/user/api_token", authenticate(authenticated(interactiveUsers(apiTokenHandler)))).Methods("GET")
+	r.HandleFunc("/user/api_token/regenerate", authenticate(authenticated(interactiveUsers(regenerateApiTokenHandler)))).Methods("POST")
+	r.HandleFunc("/user/api_token/revoke", authenticate(authenticated(interactiveUsers(revokeApiTokenHandler)))).Methods("POST")
+
+	// Profile
+	r.HandleFunc("/user/profile", authenticate(authenticated(interactiveUsers(profileHandler)))).Methods("GET")
+	r.HandleFunc("/user/profile/notifications", authenticate(authenticated(interactiveUsers(updateNotificationsHandler)))).Methods("POST")
+	r.HandleFunc("/user/sessions/revoke_others", authenticate(authenticated(interactiveUsers(revokeOtherSessionsHandler)))).Methods("POST")
+	r.HandleFunc("/user/sessions/revoke_all", authenticate(authenticated(interactiveUsers(revokeAllSessionsHandler)))).Methods("POST")
+	r.HandleFunc("/user/sessions/{sessionId}/revoke", authenticate(authenticated(interactiveUsers(revokeSessionHandler)))).Methods("POST")
+
+	// Digest subscriptions
+	r.HandleFunc("/user/digests/{application}/subscribe", authenticate(authenticated(interactiveUsers(subscribeDigestHandler)))).Methods("POST")
+	r.HandleFunc("/user/digests/{application}/unsubscribe", authenticate(authenticated(interactiveUsers(unsubscribeDigestHandler)))).Methods("POST")
+	r.HandleFunc("/digests/unsubscribe/{token}", authenticate(digestUnsubscribeLinkHandler)).Methods("GET", "POST")
+
+	// Calendar feeds, authenticated with the token in the URL
+	r.HandleFunc("/calendar/{token}/{application}.ics", calendarFeedHandler).Methods("GET")
+
+	// Preferences
+	r.HandleFunc("/user/preferences", authenticate(authenticated(interactiveUsers(preferencesHandler)))).Methods("GET")
+	r.HandleFunc("/user/preferences", authenticate(authenticated(interactiveUsers(updatePreferencesHandler)))).Methods("POST")
+
+	// Approvals
+	r.HandleFunc("/approvals", authenticate(authenticated(interactiveUsers(approvalsHandler)))).Methods("GET")
+
+	// Admin
+	r.HandleFunc("/admin/users", authenticate(authenticated(admins(adminUsersHandler)))).Methods("GET")
+	r.HandleFunc("/admin/users/{userId}/deactivate", authenticate(authenticated(admins(deactivateUserHandler)))).Methods("POST")
+	r.HandleFunc("/admin/users/{userId}/reactivate", authenticate(authenticated(admins(reactivateUserHandler)))).Methods("POST")
+	r.HandleFunc("/admin/users/{userId}/revoke_sessions", authenticate(authenticated(admins(revokeUserSessionsHandler)))).Methods("POST")
+	r.HandleFunc("/admin/audit", authenticate(authenticated(admins(adminAuditHandler)))).Methods("GET")
+	r.HandleFunc("/admin/applications", authenticate(authenticated(admins(adminApplicationsHandler)))).Methods("GET")
+	r.HandleFunc("/admin/applications", authenticate(authenticated(admins(saveApplicationHandler)))).Methods("POST")
+	r.HandleFunc("/admin/applications/{name}/delete", authenticate(authenticated(admins(deleteApplicationHandler)))).Methods("POST")
+	r.HandleFunc("/admin/deliveries", authenticate(authenticated(admins(adminDeliveriesHandler)))).Methods("GET")
+	r.HandleFunc("/admin/deliveries/{deliveryId}/retry", authenticate(authenticated(admins(retryDeliveryHandler)))).Methods("POST")
+	r.HandleFunc("/admin/deployments", authenticate(authenticated(admins(adminActiveDeploymentsHandler)))).Methods("GET")
+	r.HandleFunc("/admin/deployments/{deploymentId}/cancel", authenticate(authenticated(admins(cancelActiveDeploymentHandler)))).Methods("POST")
+	r.PathPrefix("/debug/").Handler(authenticate(authenticated(admins(debugHandler().ServeHTTP))))
+
+	// Slack
+	r.HandleFunc("/slack/commands/deploy", rateLimited(slackDeployHandler)).Methods("POST")
+	r.HandleFunc("/slack/interactions", rateLimited(slackInteractionHandler)).Methods("POST")
+	// Microsoft Teams
+	r.HandleFunc("/teams/actions", rateLimited(teamsActionHandler)).Methods("POST")
+
+	// JSON API
+	setupApiRoutes(r)
 
 	// Application
+	r.HandleFunc("/{application}/webhooks/github", rateLimited(gitHubWebhookHandler)).Methods("POST")
+	r.HandleFunc("/{application}/webhooks/ci", rateLimited(ciTriggerHandler)).Methods("POST")
+	r.HandleFunc("/{application}/webhooks/gitlab", rateLimited(gitLabWebhookHandler)).Methods("POST")
+	r.HandleFunc("/{application}/webhooks/ci_status", rateLimited(ciStatusWebhookHandler)).Methods("POST")
+	r.HandleFunc("/{application}/targets/{target}/badge.svg", statusBadgeHandler).Methods("GET")
 	r.HandleFunc("/{application}/deployments", requireAuthorizedUser(createDeploymentHandler)).Methods("POST")
 	r.HandleFunc("/{application}/deployments", requireAuthorizedUser(listDeploymentsHandler)).Methods("GET")
+	r.HandleFunc("/{application}/deployments/report.{format:csv|json}", requireAuthorizedUser(deploymentReportHandler)).Methods("GET")
 	r.HandleFunc("/{application}/deployments/{deploymentId}", requireAuthorizedUser(deploymentHandler)).Methods("GET")
+	r.HandleFunc("/{application}/calendar", requireAuthorizedUser(calendarHandler)).Methods("GET")
+	r.HandleFunc("/{application}/calendar/token", requireAuthorizedUser(calendarTokenHandler)).Methods("POST")
+	r.HandleFunc("/{application}/scm/refresh", requireAuthorizedUser(scmRefreshHandler)).Methods("POST")
+	r.HandleFunc("/{application}/dashboard", requireAuthorizedUser(dashboardHandler)).Methods("GET")
+	r.HandleFunc("/{application}/targets", requireAuthorizedUser(targetsHandler)).Methods("GET")
+	r.HandleFunc("/{application}/targets/{target}/rollback", requireAuthorizedUser(rollbackHandler)).Methods("POST")
+	r.HandleFunc("/{application}/freezes", requireAuthorizedUser(freezeHandler)).Methods("POST")
+	r.HandleFunc("/{application}/freezes/{freezeId}/delete", requireAuthorizedUser(unfreezeHandler)).Methods("POST")
+	r.HandleFunc("/{application}/presets", requireAuthorizedUser(presetHandler)).Methods("POST")
+	r.HandleFunc("/{application}/presets/{presetId}/delete", requireAuthorizedUser(deletePresetHandler)).Methods("POST")
+	r.HandleFunc("/{application}/search", requireAuthorizedUser(searchHandler)).Methods("GET")
 	r.HandleFunc("/{application}/deployments/{deploymentId}/log", requireAuthorizedUser(deploymentWsHandler)).Methods("GET")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/events", requireAuthorizedUser(deploymentEventsHandler)).Methods("GET")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/log.{format:txt|ndjson|txt\\.gz|ndjson\\.gz}", requireAuthorizedUser(deploymentLogDownloadHandler)).Methods("GET")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/log/entries", requireAuthorizedUser(deploymentLogEntriesHandler)).Methods("GET")
 	r.HandleFunc("/{application}/deployments/{deploymentId}/kill", requireAuthorizedUser(killDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/continue", requireAuthorizedUser(continueDeploymentHandler)).Methods("POST")
+	r.HandleFunc("/{application}/deployments/{deploymentId}/reject", requireAuthorizedUser(rejectDeploymentHandler)).Methods("POST")
 	r.HandleFunc("/{application}/pulls", requireAuthorizedUser(pullRequestsHandler)).Methods("GET")
 	r.HandleFunc("/{application}/branches", requireAuthorizedUser(branchesHandler)).Methods("GET")
+	r.HandleFunc("/{application}/tags", requireAuthorizedUser(tagsHandler)).Methods("GET")
 	r.HandleFunc("/{application}/diff", requireAuthorizedUser(diffHandler)).Methods("GET")
 	r.HandleFunc("/{application}/toni", requireAuthorizedUser(toniConfigurationHandler))
 	r.HandleFunc("/{application}", requireAuthorizedUser(applicationHandler))
@@ -227,9 +423,72 @@ func main() {
 		fmt.Println(BANNER)
 	}
 
-	log.Printf("Applikatoni is fully booted. Listening on localhost%s ...\n", *port)
-	err = http.ListenAndServe(*port, handlers.LoggingHandler(os.Stdout, r))
+	if *debugAddr != "" {
+		go func() {
+			slog.Info("serving profiles and runtime stats", "addr", *debugAddr)
+			if err := http.ListenAndServe(*debugAddr, debugHandler()); err != nil {
+				slog.Error("debug listener failed", "addr", *debugAddr, "err", err)
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:    *port,
+		Handler: withRequestId(logRequests(instrumented(recoverPanics(compressed(securityHeaders(allowCORS(withRequestIdInErrors(allowlistedIPs(r))))))))),
+	}
+
+	// Wait for the running deployments on SIGTERM before exiting
+	shutdownTimeout, _ := config.ShutdownTimeoutDuration()
+	shutdownDone := make(chan struct{})
+	go func() {
+		shutdownOnSignal(server, shutdownTimeout)
+		close(shutdownDone)
+	}()
+
+	// Use the socket passed by systemd when it's socket activated
+	listener, err := listen(*port)
 	if err != nil {
-		log.Fatal("ListenAndServe:", err)
+		fatal("could not listen", "port", *port, "err", err)
 	}
+
+	// Serve HTTPS with the configured certificate or one of Let's Encrypt
+	tlsConfig, certManager, err := serverTLSConfig(config)
+	if err != nil {
+		fatal("could not set up TLS", "err", err)
+	}
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	if config.HTTPRedirectAddr != "" {
+		slog.Info("redirecting HTTP to HTTPS", "addr", config.HTTPRedirectAddr)
+		go serveHTTPRedirect(config.HTTPRedirectAddr, *port, certManager)
+	}
+
+	// Tell systemd the database has been migrated and requests are served
+	notifySystemd("READY=1\nSTATUS=Serving on " + listener.Addr().String())
+	if interval, ok := watchdogInterval(); ok {
+		go feedWatchdog(interval)
+	}
+
+	slog.Info("Applikatoni is fully booted", "addr", listener.Addr().String())
+	err = server.Serve(listener)
+	if err != http.ErrServerClosed {
+		fatal("Serve failed", "err", err)
+	}
+
+	<-shutdownDone
+	slog.Info("Applikatoni has shut down")
+}
+
+// promptPassphrase reads a passphrase from the terminal without echoing it.
+func promptPassphrase(prompt string) (string, error) {
+	if !terminal.IsTerminal(syscall.Stdin) {
+		return "", errors.New("not running in a terminal, use deployment_ssh_key_passphrase")
+	}
+
+	fmt.Print(prompt)
+	passphrase, err := terminal.ReadPassword(syscall.Stdin)
+	fmt.Println()
+
+	return string(passphrase), err
 }
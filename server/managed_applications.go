@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// managedApplicationName restricts the names of applications added at
+// runtime to what can be used in the URLs without escaping.
+var managedApplicationName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+var errManagedPassphrase = errors.New("passphrases can't be asked for, set deployment_ssh_key_passphrase")
+
+// parseManagedApplication parses the JSON definition of an application saved
+// by an admin and checks it like the applications of the configuration file.
+// References to secrets are resolved, so the database only contains them if
+// the definition does.
+func parseManagedApplication(c *Configuration, definition string) (*models.Application, error) {
+	a := &models.Application{}
+	if _, err := parseConfiguration([]byte(definition), configFormatJSON, a); err != nil {
+		return nil, err
+	}
+
+	if !managedApplicationName.MatchString(a.Name) {
+		return nil, fmt.Errorf("invalid name %q, use letters, digits, dots, dashes and underscores", a.Name)
+	}
+	if len(a.Targets) == 0 {
+		return nil, fmt.Errorf("application %s has no targets", a.Name)
+	}
+
+	if err := resolveSecretsIn(c, a); err != nil {
+		return nil, err
+	}
+	if err := validateApplication(c, a); err != nil {
+		return nil, err
+	}
+
+	// Encrypted SSH keys need their passphrase in the definition, since
+	// there's nobody to ask for it
+	only := &Configuration{Applications: []*models.Application{a}}
+	err := readSshKeyPassphrases(only, func(string) (string, error) {
+		return "", errManagedPassphrase
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// loadManagedApplications parses the applications saved in the database.
+// Applications that became invalid, e.g. because a secret is gone, are
+// skipped, so they can't keep Applikatoni from starting.
+func loadManagedApplications(db *sql.DB, c *Configuration) ([]*models.Application, error) {
+	saved, err := getManagedApplications(db)
+	if err != nil {
+		return nil, err
+	}
+
+	applications := []*models.Application{}
+	for _, m := range saved {
+		a, err := parseManagedApplication(c, m.Definition)
+		if err != nil {
+			slog.Warn("skipping application saved in the database", "application", m.Name, "err", err)
+			continue
+		}
+		applications = append(applications, a)
+	}
+
+	return applications, nil
+}
+
+// applyManagedApplications sets the Applications of c to the ones of the
+// configuration file followed by the managed ones. The configuration file
+// wins if both contain an application with the same name.
+func applyManagedApplications(c *Configuration, managed []*models.Application) {
+	names := make(map[string]bool)
+	applications := []*models.Application{}
+
+	for _, a := range c.fileApplications {
+		names[a.Name] = true
+		applications = append(applications, a)
+	}
+	for _, a := range managed {
+		if names[a.Name] {
+			slog.Warn("application is in the configuration file and the database, using the configuration file", "application", a.Name)
+			continue
+		}
+		applications = append(applications, a)
+	}
+
+	c.Applications = applications
+}
+
+// refreshApplications switches to the applications currently saved in the
+// database. Like reloads, it doesn't affect running deployments.
+func refreshApplications() error {
+	configReloadMutex.Lock()
+	defer configReloadMutex.Unlock()
+
+	managed, err := loadManagedApplications(db, getConfig())
+	if err != nil {
+		return err
+	}
+
+	reloaded := *getConfig()
+	applyManagedApplications(&reloaded, managed)
+	setConfig(&reloaded)
+
+	return nil
+}
+
+func isFileApplication(c *Configuration, name string) bool {
+	for _, a := range c.fileApplications {
+		if a.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// saveManagedApplicationFromRequest adds or replaces the application with the
+// definition. If name isn't empty, it has to be the name in the definition.
+// Applications of the configuration file can't be changed at runtime.
+func saveManagedApplicationFromRequest(r *http.Request, u *models.User, name, definition string) (*models.ManagedApplication, *requestError) {
+	config := getConfig()
+	if !config.IsAdmin(u) {
+		return nil, &requestError{http.StatusForbidden, "only admins can manage applications"}
+	}
+
+	a, err := parseManagedApplication(config, definition)
+	if err != nil {
+		return nil, &requestError{422, err.Error()}
+	}
+	if name != "" && a.Name != name {
+		return nil, &requestError{422, fmt.Sprintf("the definition is named %s instead of %s", a.Name, name)}
+	}
+	if isFileApplication(config, a.Name) {
+		return nil, &requestError{http.StatusConflict, fmt.Sprintf("application %s is in the configuration file and can only be changed there", a.Name)}
+	}
+
+	managed := &models.ManagedApplication{
+		Name:       a.Name,
+		Definition: definition,
+		UserId:     u.Id,
+		User:       u,
+	}
+	err = saveManagedApplication(db, managed)
+	if err != nil {
+		requestLogger(r).Error("error saving the application", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	recordAuditEvent(r, u, models.AUDIT_APPLICATION_SAVE, managed.Name)
+
+	err = refreshApplications()
+	if err != nil {
+		requestLogger(r).Error("error loading the applications", "err", err)
+		return nil, &requestError{http.StatusInternalServerError, err.Error()}
+	}
+
+	return managed, nil
+}
+
+// deleteManagedApplicationFromRequest removes the application. Its
+// deployments are kept and show up again if it's added with the same name.
+func deleteManagedApplicationFromRequest(r *http.Request, u *models.User, name string) *requestError {
+	config := getConfig()
+	if !config.IsAdmin(u) {
+		return &requestError{http.StatusForbidden, "only admins can manage applications"}
+	}
+	if isFileApplication(config, name) {
+		return &requestError{http.StatusConflict, fmt.Sprintf("application %s is in the configuration file and can only be changed there", name)}
+	}
+
+	err := deleteManagedApplication(db, name)
+	if err == sql.ErrNoRows {
+		return &requestError{http.StatusNotFound, "application not found"}
+	}
+	if err != nil {
+		requestLogger(r).Error("error deleting the application", "err", err)
+		return &requestError{http.StatusInternalServerError, err.Error()}
+	}
+	recordAuditEvent(r, u, models.AUDIT_APPLICATION_DELETE, name)
+
+	err = refreshApplications()
+	if err != nil {
+		requestLogger(r).Error("error loading the applications", "err", err)
+		return &requestError{http.StatusInternalServerError, err.Error()}
+	}
+
+	return nil
+}
+
+func adminApplicationsHandler(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
+	managed, err := getManagedApplications(db)
+	if err == nil {
+		err = loadManagedApplicationsUsers(db, managed)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading the applications", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "admin_applications.tmpl", map[string]interface{}{
+		"Applications":        config.Applications,
+		"FileApplications":    config.fileApplications,
+		"ManagedApplications": managed,
+		"currentUser":         getCurrentUser(r),
+	})
+}
+
+func saveApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	managed, reqErr := saveManagedApplicationFromRequest(r, getCurrentUser(r), "", r.FormValue("definition"))
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	addFlash(w, r, "Application %s has been saved.", managed.Name)
+	http.Redirect(w, r, "/admin/applications", http.StatusSeeOther)
+}
+
+func deleteApplicationHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	reqErr := deleteManagedApplicationFromRequest(r, getCurrentUser(r), name)
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	addFlash(w, r, "Application %s has been deleted.", name)
+	http.Redirect(w, r, "/admin/applications", http.StatusSeeOther)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const testManagedApplication = `{
+  "name": "shop",
+  "github_owner": "applikatoni",
+  "github_repo": "shop",
+  "targets": [{"name": "production", "deployment_user": "deploy", "deployment_timeout": "10m"}]
+}`
+
+func TestParseManagedApplication(t *testing.T) {
+	c := &Configuration{}
+
+	a, err := parseManagedApplication(c, testManagedApplication)
+	checkErr(t, err)
+	if a.Name != "shop" || len(a.Targets) != 1 || a.Targets[0].Name != "production" {
+		t.Errorf("wrong application. got=%+v", a)
+	}
+
+	tests := []struct {
+		definition string
+		err        string
+	}{
+		{`{"name": "shop",`, "invalid JSON in line 1"},
+		{`{"name": "", "targets": [{"name": "production"}]}`, `invalid name ""`},
+		{`{"name": "my shop", "targets": [{"name": "production"}]}`, `invalid name "my shop"`},
+		{`{"name": "shop"}`, "application shop has no targets"},
+		{`{"name": "shop", "targets": [{"name": "production", "deployment_timeout": "soon"}]}`, "invalid deployment_timeout for target production of shop"},
+		{`{"name": "shop", "scm": "svn", "targets": [{"name": "production"}]}`, `invalid scm "svn" for shop`},
+	}
+
+	for _, tt := range tests {
+		_, err := parseManagedApplication(c, tt.definition)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("wrong error for %s. want=%q, got=%v", tt.definition, tt.err, err)
+		}
+	}
+}
+
+func TestApplyManagedApplications(t *testing.T) {
+	web := &models.Application{Name: "web"}
+	c := &Configuration{fileApplications: []*models.Application{web}}
+
+	shop := &models.Application{Name: "shop"}
+	otherWeb := &models.Application{Name: "web"}
+	applyManagedApplications(c, []*models.Application{otherWeb, shop})
+
+	expected := []*models.Application{web, shop}
+	if len(c.Applications) != len(expected) {
+		t.Fatalf("wrong number of applications. want=%d, got=%d", len(expected), len(c.Applications))
+	}
+	for i, a := range expected {
+		if c.Applications[i] != a {
+			t.Errorf("wrong application %d. want=%s, got=%s", i, a.Name, c.Applications[i].Name)
+		}
+	}
+}
+
+func TestManagedApplicationsDatabase(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	a := &models.ManagedApplication{Name: "shop", Definition: testManagedApplication, UserId: 1}
+	checkErr(t, saveManagedApplication(db, a))
+	createdAt := a.CreatedAt
+
+	a.Definition = `{"name": "shop"}`
+	a.UserId = 2
+	checkErr(t, saveManagedApplication(db, a))
+
+	saved, err := getManagedApplications(db)
+	checkErr(t, err)
+	if len(saved) != 1 {
+		t.Fatalf("wrong number of applications. want=1, got=%d", len(saved))
+	}
+	if saved[0].Definition != a.Definition || saved[0].UserId != 2 {
+		t.Errorf("application not replaced. got=%+v", saved[0])
+	}
+	if !saved[0].CreatedAt.Equal(createdAt) {
+		t.Errorf("wrong created_at. want=%v, got=%v", createdAt, saved[0].CreatedAt)
+	}
+
+	checkErr(t, deleteManagedApplication(db, "shop"))
+	if err := deleteManagedApplication(db, "shop"); err != sql.ErrNoRows {
+		t.Errorf("wrong error deleting twice. want=%v, got=%v", sql.ErrNoRows, err)
+	}
+}
+
+func TestManagedApplicationsEncrypted(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	var err error
+	fieldCipher, err = newFieldCipher(strings.Repeat("k", minEncryptionKeyLength))
+	checkErr(t, err)
+	defer func() { fieldCipher = nil }()
+
+	a := &models.ManagedApplication{Name: "shop", Definition: testManagedApplication, UserId: 1}
+	checkErr(t, saveManagedApplication(db, a))
+
+	var stored string
+	checkErr(t, db.QueryRow("SELECT definition FROM managed_applications WHERE name = ?", "shop").Scan(&stored))
+	if !strings.HasPrefix(stored, encryptedValuePrefix) {
+		t.Errorf("definition not encrypted. got=%q", stored)
+	}
+
+	saved, err := getManagedApplications(db)
+	checkErr(t, err)
+	if len(saved) != 1 || saved[0].Definition != testManagedApplication {
+		t.Errorf("definition not decrypted. got=%+v", saved)
+	}
+}
+
+func TestManagedApplicationsFromRequest(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	web := &models.Application{Name: "web"}
+	setConfig(&Configuration{
+		AdminUsernames:   []string{"fabrik42"},
+		Applications:     []*models.Application{web},
+		fileApplications: []*models.Application{web},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	admin := buildUser(1, "fabrik42")
+	checkErr(t, createUser(db, admin))
+	deployer := buildUser(2, "mrnugget")
+	checkErr(t, createUser(db, deployer))
+
+	r := httptest.NewRequest("PUT", "/api/v1/admin/applications/shop", nil)
+
+	tests := []struct {
+		user       *models.User
+		name       string
+		definition string
+		status     int
+	}{
+		{deployer, "shop", testManagedApplication, http.StatusForbidden},
+		{admin, "shop", `{"name": "shop"}`, 422},
+		{admin, "other", testManagedApplication, 422},
+		{admin, "web", strings.Replace(testManagedApplication, `"shop"`, `"web"`, 1), http.StatusConflict},
+		{admin, "shop", testManagedApplication, 0},
+	}
+
+	for _, tt := range tests {
+		_, reqErr := saveManagedApplicationFromRequest(r, tt.user, tt.name, tt.definition)
+		status := 0
+		if reqErr != nil {
+			status = reqErr.Status
+		}
+		if status != tt.status {
+			t.Errorf("wrong status saving %s as %s. want=%d, got=%d (%v)", tt.name, tt.user.Name, tt.status, status, reqErr)
+		}
+	}
+
+	shop, err := findApplication("shop")
+	if err != nil {
+		t.Fatalf("saved application not found: %s", err)
+	}
+	if len(shop.Targets) != 1 {
+		t.Errorf("wrong targets of the saved application. got=%+v", shop.Targets)
+	}
+	if getConfig().Applications[0] != web {
+		t.Errorf("application of the configuration file replaced. got=%s", getConfig().Applications[0].Name)
+	}
+
+	if reqErr := deleteManagedApplicationFromRequest(r, admin, "web"); reqErr == nil || reqErr.Status != http.StatusConflict {
+		t.Errorf("wrong error deleting an application of the file. want=%d, got=%v", http.StatusConflict, reqErr)
+	}
+	if reqErr := deleteManagedApplicationFromRequest(r, admin, "shop"); reqErr != nil {
+		t.Errorf("deleting the application failed: %v", reqErr)
+	}
+	if _, err := findApplication("shop"); err == nil {
+		t.Errorf("deleted application still configured")
+	}
+	if reqErr := deleteManagedApplicationFromRequest(r, admin, "shop"); reqErr == nil || reqErr.Status != http.StatusNotFound {
+		t.Errorf("wrong error deleting twice. want=%d, got=%v", http.StatusNotFound, reqErr)
+	}
+
+	events, err := getFilteredAuditEvents(db, &auditFilter{Limit: 10})
+	checkErr(t, err)
+	if len(events) != 2 {
+		t.Errorf("wrong number of audit events. want=2, got=%d", len(events))
+	}
+}
+
+func TestRefreshApplicationsWhileReading(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	web := &models.Application{Name: "web"}
+	setConfig(&Configuration{
+		Applications:     []*models.Application{web},
+		fileApplications: []*models.Application{web},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	checkErr(t, saveManagedApplication(db, &models.ManagedApplication{Name: "shop", Definition: testManagedApplication, UserId: 1}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			if getConfig().Applications[0] != web {
+				t.Errorf("application of the configuration file replaced while refreshing")
+			}
+		}
+	}()
+
+	for i := 0; i < 10; i++ {
+		checkErr(t, refreshApplications())
+	}
+	<-done
+
+	if _, err := findApplication("shop"); err != nil {
+		t.Errorf("managed application not added: %s", err)
+	}
+}
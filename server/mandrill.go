@@ -6,10 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 )
 
 const mandrillMessagesEndpoint = "https://mandrillapp.com/api/1.0/messages/send.json"
 
+// The ping endpoint is next to the messages endpoint
+const mandrillPingPath = "/users/ping.json"
+
 type MandrillMessage struct {
 	Html      string             `json:"html"`
 	Text      string             `json:"text"`
@@ -65,6 +69,27 @@ func (m *MandrillClient) SendDigest(digest *DailyDigest) error {
 	return m.checkResponseStatus(resp)
 }
 
+// Ping checks the API key without sending anything.
+func (m *MandrillClient) Ping() error {
+	endpoint := strings.TrimSuffix(m.endpoint, "/messages/send.json") + mandrillPingPath
+
+	data, err := json.Marshal(map[string]string{"key": m.apiKey})
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.Post(endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("mandrill response code is %d", resp.StatusCode)
+	}
+	return nil
+}
+
 func (m *MandrillClient) newRequestBody(digest *DailyDigest) (io.Reader, error) {
 	to := []MandrillReceiver{}
 	for _, email := range digest.Receivers {
@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -93,3 +94,17 @@ func TestMandrillSendDigest(t *testing.T) {
 		t.Errorf("SendDigest error: %s", err)
 	}
 }
+
+func TestMandrillPing(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users/ping.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `"PONG!"`)
+	}))
+	defer ts.Close()
+
+	err := NewMandrillClient(ts.URL+"/messages/send.json", "mandrillapikey").Ping()
+	checkErr(t, err)
+}
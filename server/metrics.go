@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// The upper bounds of the request duration histogram in seconds, the default
+// buckets of the Prometheus client libraries.
+var requestDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metrics collects the counters served at /metrics. It's ready to use without
+// main, so notifiers can count their failures in tests.
+var metrics = NewMetrics()
+
+// Metrics collects the counters and gauges of the server that are exported in
+// the Prometheus text format. Gauges that can be read from other places, like
+// the number of active deployments and the database pool, are collected when
+// the metrics are scraped.
+type Metrics struct {
+	mu                 sync.Mutex
+	requestDurations   map[requestLabels]*histogram
+	deploymentOutcomes map[deploymentOutcomeLabels]int
+	notifierFailures   map[string]int
+	websocketClients   int
+	eventStreamClients int
+}
+
+type requestLabels struct {
+	method string
+	code   string
+}
+
+type deploymentOutcomeLabels struct {
+	application string
+	target      string
+	state       models.DeploymentState
+}
+
+type histogram struct {
+	counts []int
+	sum    float64
+	count  int
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{
+		requestDurations:   make(map[requestLabels]*histogram),
+		deploymentOutcomes: make(map[deploymentOutcomeLabels]int),
+		notifierFailures:   make(map[string]int),
+	}
+}
+
+func (m *Metrics) ObserveRequest(method string, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := requestLabels{method, strconv.Itoa(code)}
+	h, ok := m.requestDurations[labels]
+	if !ok {
+		h = &histogram{counts: make([]int, len(requestDurationBuckets))}
+		m.requestDurations[labels] = h
+	}
+
+	seconds := d.Seconds()
+	for i, bound := range requestDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// CountDeploymentOutcome is subscribed to the finished states of
+// deployments.
+func (m *Metrics) CountDeploymentOutcome(ev *DeploymentEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	labels := deploymentOutcomeLabels{ev.Application.Name, ev.Target.Name, ev.State}
+	m.deploymentOutcomes[labels]++
+	return nil
+}
+
+// NotifierFailed counts a failed notification, e.g. "slack" or "webhook".
+func (m *Metrics) NotifierFailed(notifier string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.notifierFailures[notifier]++
+}
+
+func (m *Metrics) WebsocketConnected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.websocketClients++
+}
+
+func (m *Metrics) WebsocketDisconnected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.websocketClients--
+}
+
+func (m *Metrics) EventStreamConnected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.eventStreamClients++
+}
+
+func (m *Metrics) EventStreamDisconnected() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.eventStreamClients--
+}
+
+// writeExposition writes the metrics in the Prometheus text exposition format. Series
+// are sorted, so the output is stable.
+func (m *Metrics) writeExposition(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	writeHeader(w, "applikatoni_http_request_duration_seconds", "histogram", "Duration of HTTP requests.")
+	requests := []requestLabels{}
+	for labels := range m.requestDurations {
+		requests = append(requests, labels)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].method != requests[j].method {
+			return requests[i].method < requests[j].method
+		}
+		return requests[i].code < requests[j].code
+	})
+	for _, labels := range requests {
+		h := m.requestDurations[labels]
+		l := fmt.Sprintf("method=%q,code=%q", labels.method, labels.code)
+		for i, bound := range requestDurationBuckets {
+			fmt.Fprintf(w, "applikatoni_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n",
+				l, strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "applikatoni_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, h.count)
+		fmt.Fprintf(w, "applikatoni_http_request_duration_seconds_sum{%s} %s\n", l, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "applikatoni_http_request_duration_seconds_count{%s} %d\n", l, h.count)
+	}
+
+	writeHeader(w, "applikatoni_deployments_total", "counter", "Finished deployments by application, target and state.")
+	outcomes := []deploymentOutcomeLabels{}
+	for labels := range m.deploymentOutcomes {
+		outcomes = append(outcomes, labels)
+	}
+	sort.Slice(outcomes, func(i, j int) bool {
+		a, b := outcomes[i], outcomes[j]
+		if a.application != b.application {
+			return a.application < b.application
+		}
+		if a.target != b.target {
+			return a.target < b.target
+		}
+		return a.state < b.state
+	})
+	for _, labels := range outcomes {
+		fmt.Fprintf(w, "applikatoni_deployments_total{application=%q,target=%q,state=%q} %d\n",
+			labels.application, labels.target, labels.state, m.deploymentOutcomes[labels])
+	}
+
+	writeHeader(w, "applikatoni_notifier_failures_total", "counter", "Failed notifications by notifier.")
+	notifiers := []string{}
+	for n := range m.notifierFailures {
+		notifiers = append(notifiers, n)
+	}
+	sort.Strings(notifiers)
+	for _, n := range notifiers {
+		fmt.Fprintf(w, "applikatoni_notifier_failures_total{notifier=%q} %d\n", n, m.notifierFailures[n])
+	}
+
+	writeHeader(w, "applikatoni_websocket_clients", "gauge", "Connected websocket clients streaming deployment logs.")
+	fmt.Fprintf(w, "applikatoni_websocket_clients %d\n", m.websocketClients)
+
+	writeHeader(w, "applikatoni_event_stream_clients", "gauge", "Connected clients streaming deployment logs via Server-Sent Events.")
+	fmt.Fprintf(w, "applikatoni_event_stream_clients %d\n", m.eventStreamClients)
+}
+
+// writeLogRouterStats writes the numbers of the router broadcasting the log
+// entries to the listeners, e.g. the websocket clients and the log entry
+// saver. Dropped entries and a growing wait mean the listeners fall behind.
+func writeLogRouterStats(w io.Writer, stats deploy.RouterStats) {
+	writeGauge(w, "applikatoni_log_listeners", "Listeners subscribed to the log entries of deployments.", int64(stats.Listeners))
+	writeGauge(w, "applikatoni_log_backlog_entries", "Log entries of running deployments kept for new listeners.", int64(stats.BacklogEntries))
+	writeGauge(w, "applikatoni_log_buffered_entries", "Log entries waiting in the buffers of the router and the listeners.", int64(stats.BufferedEntries))
+	writeHeader(w, "applikatoni_log_entries_total", "counter", "Log entries broadcast to the listeners.")
+	fmt.Fprintf(w, "applikatoni_log_entries_total %d\n", stats.RoutedEntries)
+	writeHeader(w, "applikatoni_log_entries_dropped_total", "counter", "Log entries that didn't fit into the buffer of a listener.")
+	fmt.Fprintf(w, "applikatoni_log_entries_dropped_total %d\n", stats.DroppedEntries)
+	writeHeader(w, "applikatoni_log_listener_wait_seconds_total", "counter", "Time spent waiting for listeners with full buffers to receive log entries.")
+	fmt.Fprintf(w, "applikatoni_log_listener_wait_seconds_total %s\n", strconv.FormatFloat(stats.ListenerWait.Seconds(), 'g', -1, 64))
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeGauge(w io.Writer, name, help string, value int64) {
+	writeHeader(w, name, "gauge", help)
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// metricsHandler serves the metrics for Prometheus. If metrics_token is
+// configured, scrapers have to send it as bearer token.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
+	if config.MetricsToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(config.MetricsToken)) != 1 {
+			http.Error(w, "missing or wrong metrics token", http.StatusUnauthorized)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	metrics.writeExposition(w)
+
+	writeGauge(w, "applikatoni_active_deployments", "Deployments that are currently running.", int64(killRegistry.Len()))
+	writeLogRouterStats(w, logRouter.Stats())
+
+	stats := db.Stats()
+	writeGauge(w, "applikatoni_db_open_connections", "Open connections to the database.", int64(stats.OpenConnections))
+	writeGauge(w, "applikatoni_db_in_use_connections", "Database connections currently in use.", int64(stats.InUse))
+	writeGauge(w, "applikatoni_db_idle_connections", "Idle database connections.", int64(stats.Idle))
+	writeHeader(w, "applikatoni_db_wait_count_total", "counter", "Times a query waited for a database connection.")
+	fmt.Fprintf(w, "applikatoni_db_wait_count_total %d\n", stats.WaitCount)
+	writeHeader(w, "applikatoni_db_wait_duration_seconds_total", "counter", "Time spent waiting for database connections.")
+	fmt.Fprintf(w, "applikatoni_db_wait_duration_seconds_total %s\n", strconv.FormatFloat(stats.WaitDuration.Seconds(), 'g', -1, 64))
+}
+
+// instrumented measures the duration of every request by method and status
+// code. Paths aren't used as label, since every deployment has its own.
+func instrumented(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(rec, r)
+
+		metrics.ObserveRequest(r.Method, rec.status, time.Since(start))
+	})
+}
+
+// statusRecorder remembers the status code of a response. It keeps the
+// response streamable and hijackable for Server-Sent Events and websockets.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if !rec.wroteHeader {
+		rec.status = status
+		rec.wroteHeader = true
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	rec.wroteHeader = true
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can't be hijacked")
+	}
+	// Hijacked connections are upgraded to websockets
+	rec.status = http.StatusSwitchingProtocols
+	rec.wroteHeader = true
+	return hijacker.Hijack()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestMetricsWriteExposition(t *testing.T) {
+	m := NewMetrics()
+
+	m.ObserveRequest("GET", 200, 30*time.Millisecond)
+	m.ObserveRequest("GET", 200, 3*time.Second)
+	m.ObserveRequest("POST", 422, time.Millisecond)
+
+	application := &models.Application{Name: "web"}
+	target := &models.Target{Name: "production"}
+	for _, state := range []models.DeploymentState{models.DEPLOYMENT_SUCCESSFUL, models.DEPLOYMENT_SUCCESSFUL, models.DEPLOYMENT_FAILED} {
+		m.CountDeploymentOutcome(&DeploymentEvent{State: state, Application: application, Target: target})
+	}
+
+	m.NotifierFailed("slack")
+	m.WebsocketConnected()
+	m.WebsocketConnected()
+	m.WebsocketDisconnected()
+
+	var buf bytes.Buffer
+	m.writeExposition(&buf)
+	out := buf.String()
+
+	expected := []string{
+		`applikatoni_http_request_duration_seconds_bucket{method="GET",code="200",le="0.025"} 0`,
+		`applikatoni_http_request_duration_seconds_bucket{method="GET",code="200",le="0.05"} 1`,
+		`applikatoni_http_request_duration_seconds_bucket{method="GET",code="200",le="5"} 2`,
+		`applikatoni_http_request_duration_seconds_bucket{method="GET",code="200",le="+Inf"} 2`,
+		`applikatoni_http_request_duration_seconds_count{method="GET",code="200"} 2`,
+		`applikatoni_http_request_duration_seconds_bucket{method="POST",code="422",le="0.005"} 1`,
+		`applikatoni_deployments_total{application="web",target="production",state="failed"} 1`,
+		`applikatoni_deployments_total{application="web",target="production",state="successful"} 2`,
+		`applikatoni_notifier_failures_total{notifier="slack"} 1`,
+		`applikatoni_websocket_clients 1`,
+		`applikatoni_event_stream_clients 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics don't contain %q.\ngot=%s", line, out)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	db = newTestDb(t)
+	killRegistry = NewKillRegistry()
+	killRegistry.Add(1)
+	logRouter = deploy.NewLogRouter()
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+		logRouter = nil
+		setConfig(&Configuration{})
+	}()
+
+	tests := []struct {
+		token          string
+		authorization  string
+		expectedStatus int
+	}{
+		{"", "", 200},
+		{"s3cret", "Bearer s3cret", 200},
+		{"s3cret", "Bearer wrong", 401},
+		{"s3cret", "", 401},
+	}
+
+	for _, tt := range tests {
+		setConfig(&Configuration{MetricsToken: tt.token})
+
+		req := httptest.NewRequest("GET", "/metrics", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rec := httptest.NewRecorder()
+		metricsHandler(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %q. want=%d, got=%d", tt.authorization, tt.expectedStatus, rec.Code)
+		}
+		if rec.Code == 200 && !strings.Contains(rec.Body.String(), "applikatoni_active_deployments 1\n") {
+			t.Errorf("active deployments missing. got=%s", rec.Body.String())
+		}
+	}
+}
+
+func TestWriteLogRouterStats(t *testing.T) {
+	var buf bytes.Buffer
+	writeLogRouterStats(&buf, deploy.RouterStats{
+		Listeners:       3,
+		BacklogEntries:  120,
+		RoutedEntries:   500,
+		DroppedEntries:  2,
+		ListenerWait:    1500 * time.Millisecond,
+		BufferedEntries: 7,
+	})
+
+	expected := []string{
+		`applikatoni_log_listeners 3`,
+		`applikatoni_log_backlog_entries 120`,
+		`applikatoni_log_buffered_entries 7`,
+		`applikatoni_log_entries_total 500`,
+		`applikatoni_log_entries_dropped_total 2`,
+		`applikatoni_log_listener_wait_seconds_total 1.5`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("stats don't contain %q.\ngot=%s", line, buf.String())
+		}
+	}
+}
+
+func TestInstrumented(t *testing.T) {
+	original := metrics
+	metrics = NewMetrics()
+	defer func() { metrics = original }()
+
+	h := instrumented(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/unknown", nil))
+
+	var buf bytes.Buffer
+	metrics.writeExposition(&buf)
+	line := `applikatoni_http_request_duration_seconds_count{method="GET",code="404"} 1`
+	if !strings.Contains(buf.String(), line) {
+		t.Errorf("request not observed. got=%s", buf.String())
+	}
+}
@@ -1,36 +1,37 @@
 package main
 
 import (
-	"bytes"
-	"log"
 	"net/http"
 	"net/url"
-	"text/template"
 )
 
 const (
 	newRelicNotifyEndpoint = "https://api.newrelic.com/deployments.xml"
 )
 
-const newRelicTmplStr = `Deployed {{.GitHubRepo}}/{{.Branch}} on {{.Target}} by {{.Username}} :pizza:
+const newRelicTmplStr = `Deployed {{.GitHubRepo}}/{{if .Tag}}{{.Tag}}{{else}}{{.Branch}}{{end}} on {{.Target}} by {{.Username}} :pizza:
 {{.Comment}}
-SHA: {{.GitHubUrl}}
+{{range .Changelog}}{{.ShortSha}} {{.Summary}} ({{.Author}})
+{{end}}{{range .Issues}}{{.Text}}: {{.URL}}
+{{end}}SHA: {{.GitHubUrl}}
 URL: {{.DeploymentURL}}
 `
 
-var newRelicTemplate = template.Must(template.New("newRelicSummary").Parse(newRelicTmplStr))
+var newRelicTemplate = notifierTemplate("newRelicSummary", newRelicTmplStr)
 
-func NotifyNewRelic(ev *DeploymentEvent) {
-	if ev.Target.NewRelicApiKey != "" && ev.Target.NewRelicAppId != "" {
-		SendNewRelicRequest(newRelicNotifyEndpoint, ev)
+func NotifyNewRelic(ev *DeploymentEvent) error {
+	if ev.Target.NewRelicApiKey == "" || ev.Target.NewRelicAppId == "" {
+		return nil
 	}
+	return SendNewRelicRequest(newRelicNotifyEndpoint, ev)
 }
 
-func SendNewRelicRequest(endpoint string, ev *DeploymentEvent) {
+func SendNewRelicRequest(endpoint string, ev *DeploymentEvent) error {
 	summary, err := generateSummary(newRelicTemplate, ev)
 	if err != nil {
-		log.Printf("Could not generate deployment summary, %s\n", err)
-		return
+		deploymentLogger(ev.Deployment).Error("could not generate New Relic deployment summary", "err", err)
+		metrics.NotifierFailed("new_relic")
+		return err
 	}
 
 	data := url.Values{}
@@ -40,25 +41,14 @@ func SendNewRelicRequest(endpoint string, ev *DeploymentEvent) {
 	data.Set("deployment[user]", ev.User.Name)
 	data.Set("deployment[changelog]", summary)
 
-	client := &http.Client{}
-
-	// post URL-encoded payload, must satisfy io interface
-	req, err := http.NewRequest("POST", endpoint, bytes.NewBufferString(data.Encode()))
-	req.Header.Set("x-api-key", ev.Target.NewRelicApiKey)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Printf("Notifying NewRelic failed (%s on %s, %s): err=%s\n",
-			ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha, err)
-		return
-	}
-	if resp.StatusCode != 201 {
-		log.Printf("Notifying NewRelic failed (%s on %s, %s): status=%d\n",
-			ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha,
-			resp.StatusCode)
-		return
+	header := http.Header{"X-Api-Key": {ev.Target.NewRelicApiKey}}
+	d := newDelivery("new_relic", ev.Deployment.Id, "POST", endpoint, header, []byte(data.Encode()), 201)
+	if err := deliver(d); err != nil {
+		deploymentLogger(ev.Deployment).Error("notifying New Relic failed", "err", err)
+		metrics.NotifierFailed("new_relic")
+		return err
 	}
 
-	log.Printf("Successfully notified New Relic about deployment of %v on %v, %v!\n",
-		ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha)
+	deploymentLogger(ev.Deployment).Info("notified New Relic")
+	return nil
 }
@@ -11,7 +11,7 @@ import (
 )
 
 func TestSendNewRelicRequest(t *testing.T) {
-	config = &Configuration{Host: "example.com", SSLEnabled: true}
+	setConfig(&Configuration{Host: "example.com", SSLEnabled: true})
 
 	user := &models.User{Name: "mrnugget"}
 	target := &models.Target{
@@ -2,13 +2,19 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 )
 
+// notifierTemplate parses the template of a notifier, which can use the
+// functions of script templates, like shortSha.
+func notifierTemplate(name, text string) *template.Template {
+	return template.Must(template.New(name).Funcs(models.TemplateFuncs(time.Time{}, nil)).Parse(text))
+}
+
 func generateSummary(t *template.Template, ev *DeploymentEvent) (string, error) {
 	var success bool
 	if ev.State == models.DEPLOYMENT_SUCCESSFUL {
@@ -17,19 +23,29 @@ func generateSummary(t *template.Template, ev *DeploymentEvent) (string, error)
 		success = false
 	}
 
-	gitHubUrl := fmt.Sprintf("https://github.com/%v/%v/commit/%v",
-		ev.Application.GitHubOwner, ev.Application.GitHubRepo,
-		ev.Deployment.CommitSha)
+	gitHubUrl := commitLink(ev.Application, ev.Deployment.CommitSha)
+
+	// The functions are bound to the time and secrets of this summary
+	t, err := t.Clone()
+	if err != nil {
+		return "", err
+	}
+	t.Funcs(models.TemplateFuncs(time.Now(), secretLookup(getConfig())))
 
 	var summary bytes.Buffer
-	err := t.Execute(&summary, map[string]interface{}{
+	err = t.Execute(&summary, map[string]interface{}{
 		"GitHubRepo":    ev.Application.GitHubRepo,
 		"Success":       success,
 		"Branch":        ev.Deployment.Branch,
+		"Tag":           ev.Deployment.Tag,
+		"CommitSha":     ev.Deployment.CommitSha,
 		"Target":        ev.Deployment.TargetName,
 		"Username":      ev.User.Name,
+		"ApprovedBy":    ev.Deployment.ApprovedBy,
 		"Comment":       ev.Deployment.Comment,
 		"CommentLines":  strings.Split(ev.Deployment.Comment, "\n"),
+		"Changelog":     ev.Deployment.Changelog,
+		"Issues":        changelogIssues(ev.Application, ev.Deployment.Changelog),
 		"GitHubUrl":     gitHubUrl,
 		"DeploymentURL": ev.DeploymentURL(),
 	})
@@ -26,10 +26,10 @@ func TestGenerateSummary(t *testing.T) {
 		Name: "Foo Bar",
 	}
 
-	config = &Configuration{
+	setConfig(&Configuration{
 		Host:       "example.com",
 		SSLEnabled: true,
-	}
+	})
 
 	event := &DeploymentEvent{
 		Deployment:  deployment,
@@ -42,14 +42,14 @@ func TestGenerateSummary(t *testing.T) {
 Foo Bar deployed master on staging :pizza:
 
 > hi
-<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit on GitHub>
+<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit>
 <https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
 
 	expectedFailMsg := `main-web-app Deploy Failed:
 Foo Bar deployed master on staging :pizza:
 
 > hi
-<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit on GitHub>
+<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit>
 <https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
 
 	event.State = models.DEPLOYMENT_SUCCESSFUL
@@ -71,4 +71,70 @@ Foo Bar deployed master on staging :pizza:
 	if expectedFailMsg != actualFailMsg {
 		t.Errorf("sent wrong message expected=%v got=%v", expectedFailMsg, actualFailMsg)
 	}
+
+	deployment.Changelog = []*models.ChangelogEntry{
+		{CommitSha: "0ld0ld0ld", Author: "alice", Message: "Add pizza\n\nWith cheese"},
+		{CommitSha: "f00b4r", Author: "bob", Message: "Add more pizza"},
+	}
+
+	expectedChangelogMsg := `main-web-app Deploy Failed:
+Foo Bar deployed master on staging :pizza:
+
+> hi
+• 0ld0ld0 Add pizza (alice)
+• f00b4r Add more pizza (bob)
+<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit>
+<https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
+
+	actualChangelogMsg, err := generateSummary(slackTemplate, event)
+	if err != nil {
+		t.Errorf("generateSummary returned err: %s\n", err)
+	}
+
+	if expectedChangelogMsg != actualChangelogMsg {
+		t.Errorf("sent wrong message expected=%v got=%v", expectedChangelogMsg, actualChangelogMsg)
+	}
+
+	deployment.Changelog = nil
+	deployment.ApprovedBy = "fabrik42"
+
+	expectedApprovedMsg := `main-web-app Deploy Failed:
+Foo Bar deployed master on staging, approved by fabrik42 :pizza:
+
+> hi
+<https://github.com/shipping-co/main-web-app/commit/f00b4r|View latest commit>
+<https://example.com/main-web-app/deployments/0|Open deployment in Applikatoni>`
+
+	actualApprovedMsg, err := generateSummary(slackTemplate, event)
+	if err != nil {
+		t.Errorf("generateSummary returned err: %s\n", err)
+	}
+
+	if expectedApprovedMsg != actualApprovedMsg {
+		t.Errorf("sent wrong message expected=%v got=%v", expectedApprovedMsg, actualApprovedMsg)
+	}
+}
+
+func TestGenerateSummaryTemplateFuncs(t *testing.T) {
+	setConfig(&Configuration{Host: "example.com"})
+	defer func() { setConfig(&Configuration{}) }()
+
+	event := &DeploymentEvent{
+		Deployment: &models.Deployment{
+			TargetName: "staging",
+			CommitSha:  "f0e4c2f76c58916ec258f246851bea091d14d424",
+		},
+		Application: &models.Application{GitHubRepo: "main-web-app"},
+		Target:      &models.Target{Name: "staging"},
+		User:        &models.User{Name: "Foo Bar"},
+	}
+
+	tmpl := notifierTemplate("test", `{{shortSha .CommitSha}} on {{.Target}}: {{.Comment | default "no comment"}}`)
+	summary, err := generateSummary(tmpl, event)
+	checkErr(t, err)
+
+	expected := "f0e4c2f on staging: no comment"
+	if summary != expected {
+		t.Errorf("wrong summary. want=%q, got=%q", expected, summary)
+	}
 }
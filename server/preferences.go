@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// preferenceTimeZones are offered in the preferences form. Other IANA time
+// zones can be saved, too.
+var preferenceTimeZones = []string{
+	"UTC",
+	"America/Los_Angeles",
+	"America/New_York",
+	"America/Sao_Paulo",
+	"Europe/London",
+	"Europe/Berlin",
+	"Europe/Moscow",
+	"Asia/Kolkata",
+	"Asia/Singapore",
+	"Asia/Tokyo",
+	"Australia/Sydney",
+}
+
+// parseUserPreferences reads the preferences from the form values theme,
+// language, time_zone and default_application. The default application has
+// to be readable by the user.
+func parseUserPreferences(form url.Values, u *models.User) (models.UserPreferences, error) {
+	p := models.UserPreferences{
+		Theme:              form.Get("theme"),
+		Language:           form.Get("language"),
+		TimeZone:           form.Get("time_zone"),
+		DefaultApplication: form.Get("default_application"),
+	}
+
+	if p.Theme != "" && p.Theme != models.THEME_DARK {
+		return p, fmt.Errorf("unknown theme %q", p.Theme)
+	}
+
+	if p.Language != "" && !isSupportedLanguage(p.Language) {
+		return p, fmt.Errorf("unknown language %q", p.Language)
+	}
+
+	if p.TimeZone != "" {
+		if _, err := time.LoadLocation(p.TimeZone); err != nil {
+			return p, fmt.Errorf("unknown time zone %q", p.TimeZone)
+		}
+	}
+
+	if p.DefaultApplication != "" {
+		a, err := findApplication(p.DefaultApplication)
+		if err != nil || !a.CanRead(u) {
+			return p, fmt.Errorf("unknown application %q", p.DefaultApplication)
+		}
+	}
+
+	return p, nil
+}
+
+func preferencesHandler(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
+	currentUser := getCurrentUser(r)
+
+	readable := []*models.Application{}
+	for _, a := range config.Applications {
+		if a.CanRead(currentUser) {
+			readable = append(readable, a)
+		}
+	}
+
+	renderTemplate(w, r, "preferences.tmpl", map[string]interface{}{
+		"Applications":         config.Applications,
+		"ReadableApplications": readable,
+		"Languages":            languages,
+		"BrowserLanguage":      negotiateLanguage(r.Header.Get("Accept-Language")),
+		"TimeZones":            preferenceTimeZones,
+		"ServerTimeZone":       time.Now().Format("MST"),
+		"currentUser":          currentUser,
+	})
+}
+
+func updatePreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	preferences, err := parseUserPreferences(r.PostForm, currentUser)
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	// The notifications are set on the profile page
+	preferences.DeploymentNotifications = currentUser.Preferences.DeploymentNotifications
+	currentUser.Preferences = preferences
+	err = setUserPreferences(db, currentUser)
+	if err != nil {
+		requestLogger(r).Error("error saving the preferences", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addFlash(w, r, "Your preferences have been saved.")
+	http.Redirect(w, r, "/user/preferences", http.StatusSeeOther)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestParseUserPreferences(t *testing.T) {
+	setConfig(&Configuration{Applications: []*models.Application{
+		{Name: "web", ReadUsernames: []string{"mrnugget"}},
+		{Name: "secret", ReadUsernames: []string{"fabrik42"}},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	user := buildUser(1, "mrnugget")
+
+	tests := []struct {
+		form     url.Values
+		expected models.UserPreferences
+		valid    bool
+	}{
+		{url.Values{}, models.UserPreferences{}, true},
+		{
+			url.Values{"theme": {"dark"}, "time_zone": {"Europe/Berlin"}, "default_application": {"web"}},
+			models.UserPreferences{Theme: models.THEME_DARK, TimeZone: "Europe/Berlin", DefaultApplication: "web"},
+			true,
+		},
+		{url.Values{"language": {"de"}}, models.UserPreferences{Language: "de"}, true},
+		{url.Values{"language": {"fr"}}, models.UserPreferences{}, false},
+		{url.Values{"theme": {"pink"}}, models.UserPreferences{}, false},
+		{url.Values{"time_zone": {"Mars/Olympus_Mons"}}, models.UserPreferences{}, false},
+		{url.Values{"default_application": {"secret"}}, models.UserPreferences{}, false},
+		{url.Values{"default_application": {"unknown"}}, models.UserPreferences{}, false},
+	}
+
+	for _, tt := range tests {
+		p, err := parseUserPreferences(tt.form, user)
+		if (err == nil) != tt.valid {
+			t.Errorf("wrong validation of %v. want valid=%t, got err=%v", tt.form, tt.valid, err)
+			continue
+		}
+		if tt.valid && p != tt.expected {
+			t.Errorf("wrong preferences. want=%+v, got=%+v", tt.expected, p)
+		}
+	}
+}
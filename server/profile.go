@@ -0,0 +1,141 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// The last use of a session is saved at most once per interval
+const userSessionTouchInterval = time.Minute
+
+// How many of the own deployments are shown on the profile page
+const profileDeploymentsLimit = 10
+
+// profileHandler shows the account of the user: the API token, the sessions
+// in other browsers, the notification preferences, the digest subscriptions
+// and the last deployments.
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	config := getConfig()
+	currentUser := getCurrentUser(r)
+
+	err := loadApiTokenUsage(db, currentUser)
+	if err != nil {
+		requestLogger(r).Error("error loading API token usage", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ttl, _ := config.SessionTimeout()
+	sessions, err := getUserSessions(db, currentUser.Id, ttl)
+	if err != nil {
+		requestLogger(r).Error("error loading the sessions", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	deployments, err := getRecentUserDeployments(db, currentUser.Id, profileDeploymentsLimit)
+	if err != nil {
+		requestLogger(r).Error("error loading the deployments of the user", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Access to an application can have been revoked since
+	readable := []*models.Deployment{}
+	for _, d := range deployments {
+		if a, err := findApplication(d.ApplicationName); err == nil && a.CanRead(currentUser) {
+			readable = append(readable, d)
+		}
+	}
+
+	subscriptions, err := getUserDigestSubscriptions(db, currentUser.Id)
+	if err != nil {
+		requestLogger(r).Error("error loading the digest subscriptions", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderTemplate(w, r, "profile.tmpl", map[string]interface{}{
+		"Applications":        config.Applications,
+		"Sessions":            sessions,
+		"CurrentSessionId":    currentSessionId(r),
+		"Deployments":         readable,
+		"DigestApplications":  digestApplications(currentUser),
+		"DigestSubscriptions": subscriptions,
+		"currentUser":         currentUser,
+	})
+}
+
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	err := deleteUserSession(db, currentUser.Id, mux.Vars(r)["sessionId"])
+	if err != nil {
+		requestLogger(r).Error("error deleting the session", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addFlash(w, r, "The session has been logged out.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+func revokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	err := deleteOtherUserSessions(db, currentUser.Id, currentSessionId(r))
+	if err != nil {
+		requestLogger(r).Error("error deleting the sessions", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addFlash(w, r, "All other sessions have been logged out.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// revokeAllSessionsHandler logs the user out everywhere, including the
+// current session.
+func revokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	err := deleteUserSessions(db, currentUser.Id)
+	if err != nil {
+		requestLogger(r).Error("error deleting the sessions", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordAuditEvent(r, currentUser, models.AUDIT_SESSIONS_REVOKE, currentUser.Name)
+
+	logOutUser(w, r)
+	addFlash(w, r, "You have been logged out everywhere.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// updateNotificationsHandler saves which of the own deployments the user is
+// notified about on the deployment page.
+func updateNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+
+	notifications := r.FormValue("deployment_notifications")
+	switch notifications {
+	case "", models.NOTIFY_DEPLOYMENTS_FAILED, models.NOTIFY_DEPLOYMENTS_FINISHED:
+	default:
+		http.Error(w, "unknown deployment notifications", 422)
+		return
+	}
+
+	currentUser.Preferences.DeploymentNotifications = notifications
+	err := setUserPreferences(db, currentUser)
+	if err != nil {
+		requestLogger(r).Error("error saving the preferences", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	addFlash(w, r, "Your notifications have been saved.")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
@@ -0,0 +1,45 @@
+package main
+
+const pullRequestSummaryTmplStr = `{{if .Success}}Successfully deployed{{else}}Deployment failed{{end}} on **{{.Target}}** by {{.Username}}.
+{{range .CommentLines}}
+> {{.}}{{end}}
+{{range .Changelog}}
+* {{.ShortSha}} {{.Summary}} ({{.Author}}){{end}}{{if .Issues}}
+
+Issues: {{range $i, $issue := .Issues}}{{if $i}}, {{end}}[{{$issue.Text}}]({{$issue.URL}}){{end}}{{end}}
+
+[View latest commit]({{.GitHubUrl}}) | [Open deployment in Applikatoni]({{.DeploymentURL}})`
+
+var pullRequestTemplate = notifierTemplate("pullRequestSummary", pullRequestSummaryTmplStr)
+
+// NotifyPullRequest comments the result of deployments created from a pull
+// request on the pull request.
+func NotifyPullRequest(ev *DeploymentEvent) error {
+	if ev.Deployment.PullRequest == 0 {
+		return nil
+	}
+
+	summary, err := generateSummary(pullRequestTemplate, ev)
+	if err != nil {
+		deploymentLogger(ev.Deployment).Error("could not generate pull request deployment summary", "err", err)
+		metrics.NotifierFailed("pull_request")
+		return err
+	}
+
+	client, err := NewSCMClient(ev.Application, ev.User)
+	if err != nil {
+		deploymentLogger(ev.Deployment).Error("commenting on pull request failed", "pull_request", ev.Deployment.PullRequest, "err", err)
+		metrics.NotifierFailed("pull_request")
+		return err
+	}
+
+	err = client.CommentOnPullRequest(ev.Application, ev.Deployment.PullRequest, summary)
+	if err != nil {
+		deploymentLogger(ev.Deployment).Error("commenting on pull request failed", "pull_request", ev.Deployment.PullRequest, "err", err)
+		metrics.NotifierFailed("pull_request")
+		return err
+	}
+
+	deploymentLogger(ev.Deployment).Info("commented on pull request", "pull_request", ev.Deployment.PullRequest)
+	return nil
+}
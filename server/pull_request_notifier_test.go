@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestNotifyPullRequest(t *testing.T) {
+	var comment string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/api/v4/projects/shipping-co%2Fweb-app/merge_requests/7":
+			fmt.Fprintln(w, `{"id": 1007, "iid": 7, "source_branch": "pizza", "sha": "f00b4r"}`)
+		case "/api/v4/projects/shipping-co%2Fweb-app/merge_requests/7/notes":
+			payload := map[string]string{}
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("decoding comment failed: %s", err)
+			}
+			comment = payload["body"]
+			w.WriteHeader(201)
+		default:
+			t.Errorf("unexpected request to %s", r.URL.EscapedPath())
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	setConfig(&Configuration{Host: "applikatoni.example.com", GitLabURL: ts.URL})
+	defer func() { setConfig(&Configuration{}) }()
+
+	application := &models.Application{
+		SCM:            models.SCM_GITLAB,
+		SCMAccessToken: "t0k3n",
+		GitHubOwner:    "shipping-co",
+		GitHubRepo:     "web-app",
+	}
+	user := &models.User{Name: "mrnugget", Provider: GITHUB_PROVIDER}
+
+	pull, err := getPullRequest(application, user, 7)
+	if err != nil {
+		t.Fatalf("loading merge request failed: %s", err)
+	}
+	if pull.Number != 7 || pull.Head.CommitSha != "f00b4r" || pull.Head.Branch != "pizza" {
+		t.Errorf("wrong merge request. got=%+v", pull)
+	}
+
+	event := &DeploymentEvent{
+		State:       models.DEPLOYMENT_SUCCESSFUL,
+		Application: application,
+		Target:      &models.Target{Name: "production"},
+		User:        user,
+		Deployment: &models.Deployment{
+			Id:          42,
+			CommitSha:   "f00b4r",
+			Branch:      "pizza",
+			Comment:     "Add pizza",
+			TargetName:  "production",
+			PullRequest: 7,
+		},
+	}
+
+	NotifyPullRequest(event)
+
+	expected := []string{
+		"Successfully deployed on **production** by mrnugget.",
+		"> Add pizza",
+		ts.URL + "/shipping-co/web-app/-/commit/f00b4r",
+		"/web-app/deployments/42",
+	}
+	for _, e := range expected {
+		if !strings.Contains(comment, e) {
+			t.Errorf("comment does not contain %q. got=%q", e, comment)
+		}
+	}
+
+	comment = ""
+	event.Deployment.PullRequest = 0
+	NotifyPullRequest(event)
+	if comment != "" {
+		t.Errorf("commented on deployment without pull request. got=%q", comment)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRateLimitPerToken = 120
+	defaultRateLimitPerIP    = 300
+	defaultRateLimitWindow   = time.Minute
+)
+
+// The limiters of the requests per API token and per IP. nil if disabled.
+var (
+	tokenRateLimiter *RateLimiter
+	ipRateLimiter    *RateLimiter
+)
+
+// RateLimiter allows a fixed number of requests per key in every window.
+type RateLimiter struct {
+	Limit  int
+	Window time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*rateLimitWindow
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type rateLimitWindow struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		Limit:   limit,
+		Window:  window,
+		windows: make(map[string]*rateLimitWindow),
+		now:     time.Now,
+	}
+}
+
+// Allow counts a request of the key and checks whether it's within the limit.
+// It returns the number of remaining requests and when the window resets.
+func (l *RateLimiter) Allow(key string) (bool, int, time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	w, ok := l.windows[key]
+	if !ok || now.Sub(w.start) >= l.Window {
+		w = &rateLimitWindow{start: now}
+		l.windows[key] = w
+	}
+
+	reset := w.start.Add(l.Window)
+	if w.count >= l.Limit {
+		return false, 0, reset
+	}
+
+	w.count++
+	return true, l.Limit - w.count, reset
+}
+
+// sweep removes the expired windows once per window, so keys that aren't seen
+// anymore don't pile up.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Window {
+		return
+	}
+	for key, w := range l.windows {
+		if now.Sub(w.start) >= l.Window {
+			delete(l.windows, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// setupRateLimiters creates the limiters configured by rate_limit_per_token,
+// rate_limit_per_ip and rate_limit_window.
+func setupRateLimiters(c *Configuration) {
+	window, _ := c.RateLimitWindowDuration()
+
+	tokenRateLimiter, ipRateLimiter = nil, nil
+	if limit := rateLimit(c.RateLimitPerToken, defaultRateLimitPerToken); limit > 0 {
+		tokenRateLimiter = NewRateLimiter(limit, window)
+	}
+	if limit := rateLimit(c.RateLimitPerIP, defaultRateLimitPerIP); limit > 0 {
+		ipRateLimiter = NewRateLimiter(limit, window)
+	}
+}
+
+// rateLimit returns the default for 0. Negative limits disable limiting.
+func rateLimit(configured, def int) int {
+	if configured == 0 {
+		return def
+	}
+	return configured
+}
+
+// rateLimited limits the requests per API token and per IP, to protect the
+// server from runaway scripts. Requests over the limit get a 429.
+func rateLimited(fn http.HandlerFunc) http.HandlerFunc {
+	return limitRequests(fn, true)
+}
+
+// apiTokenRateLimited only limits requests authenticated with an API token,
+// so the web frontend isn't limited.
+func apiTokenRateLimited(fn http.HandlerFunc) http.HandlerFunc {
+	return limitRequests(fn, false)
+}
+
+func limitRequests(fn http.HandlerFunc, limitIPs bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type check struct {
+			limiter *RateLimiter
+			key     string
+		}
+		checks := []check{}
+
+		if token := r.Header.Get("X-Api-Token"); token != "" && tokenRateLimiter != nil {
+			// Don't keep the tokens themselves in memory
+			sum := sha256.Sum256([]byte(token))
+			checks = append(checks, check{tokenRateLimiter, hex.EncodeToString(sum[:])})
+		}
+		if limitIPs && ipRateLimiter != nil {
+			checks = append(checks, check{ipRateLimiter, remoteIP(r)})
+		}
+		if len(checks) == 0 {
+			fn(w, r)
+			return
+		}
+
+		allowed := true
+		var limit, remaining int
+		var reset time.Time
+
+		// The headers describe the exceeded limit or the one closest to it
+		for i, c := range checks {
+			ok, left, resetAt := c.limiter.Allow(c.key)
+			if i == 0 || left < remaining || (!ok && allowed) {
+				limit, remaining, reset = c.limiter.Limit, left, resetAt
+			}
+			if !ok {
+				allowed = false
+			}
+		}
+
+		resetSeconds := int(time.Until(reset).Seconds() + 0.5)
+		if resetSeconds < 0 {
+			resetSeconds = 0
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(resetSeconds))
+
+		if !allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(resetSeconds))
+			renderApiErrorDetails(w, http.StatusTooManyRequests, apiErrRateLimited, "rate limit exceeded", map[string]int{"retry_after": resetSeconds})
+			return
+		}
+
+		fn(w, r)
+	}
+}
+
+// remoteIP returns the IP address of the client without the port.
+func remoteIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	start := time.Date(2015, 1, 26, 10, 0, 0, 0, time.UTC)
+	now := start
+
+	l := NewRateLimiter(2, time.Minute)
+	l.now = func() time.Time { return now }
+
+	tests := []struct {
+		key               string
+		after             time.Duration
+		expectedAllowed   bool
+		expectedRemaining int
+		expectedReset     time.Time
+	}{
+		{"a", 0, true, 1, start.Add(time.Minute)},
+		{"a", 10 * time.Second, true, 0, start.Add(time.Minute)},
+		{"a", 20 * time.Second, false, 0, start.Add(time.Minute)},
+		{"b", 20 * time.Second, true, 1, start.Add(80 * time.Second)},
+		{"a", 60 * time.Second, true, 1, start.Add(2 * time.Minute)},
+	}
+
+	for _, tt := range tests {
+		now = start.Add(tt.after)
+
+		allowed, remaining, reset := l.Allow(tt.key)
+		if allowed != tt.expectedAllowed {
+			t.Errorf("wrong allowed for %s after %s. want=%t, got=%t", tt.key, tt.after, tt.expectedAllowed, allowed)
+		}
+		if remaining != tt.expectedRemaining {
+			t.Errorf("wrong remaining for %s after %s. want=%d, got=%d", tt.key, tt.after, tt.expectedRemaining, remaining)
+		}
+		if !reset.Equal(tt.expectedReset) {
+			t.Errorf("wrong reset for %s after %s. want=%s, got=%s", tt.key, tt.after, tt.expectedReset, reset)
+		}
+	}
+
+	// The expired window of "b" is swept, the one of "a" isn't
+	now = start.Add(2 * time.Minute)
+	l.Allow("c")
+	if _, ok := l.windows["b"]; ok {
+		t.Errorf("expired window of b not removed")
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	setupRateLimiters(&Configuration{RateLimitPerToken: 1, RateLimitPerIP: 2})
+	defer setupRateLimiters(&Configuration{RateLimitPerToken: -1, RateLimitPerIP: -1})
+
+	h := rateLimited(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		token             string
+		expectedStatus    int
+		expectedLimit     string
+		expectedRemaining string
+	}{
+		{"t0k3n", 200, "1", "0"},
+		{"t0k3n", 429, "1", "0"},
+		{"", 429, "2", "0"},
+		{"other", 429, "2", "0"},
+	}
+
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/user", nil)
+		req.RemoteAddr = "10.0.0.1:4242"
+		if tt.token != "" {
+			req.Header.Set("X-Api-Token", tt.token)
+		}
+
+		rec := httptest.NewRecorder()
+		h(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for request %d. want=%d, got=%d", i, tt.expectedStatus, rec.Code)
+		}
+		if got := rec.Header().Get("RateLimit-Limit"); got != tt.expectedLimit {
+			t.Errorf("wrong RateLimit-Limit for request %d. want=%s, got=%s", i, tt.expectedLimit, got)
+		}
+		if got := rec.Header().Get("RateLimit-Remaining"); got != tt.expectedRemaining {
+			t.Errorf("wrong RateLimit-Remaining for request %d. want=%s, got=%s", i, tt.expectedRemaining, got)
+		}
+		if tt.expectedStatus == 429 && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("missing Retry-After for request %d", i)
+		}
+	}
+}
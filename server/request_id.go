@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const requestIdHeader = "X-Request-Id"
+
+// The longest request ID accepted from a proxy in front of Applikatoni.
+const maxRequestIdLength = 128
+
+// withRequestId sets the X-Request-Id header of every response, so errors
+// reported by users and API clients can be found in the logs. The ID of a
+// proxy in front of Applikatoni is kept, otherwise a new one is generated.
+func withRequestId(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIdHeader)
+		if !isValidRequestId(id) {
+			id = newRequestId()
+			r.Header.Set(requestIdHeader, id)
+		}
+		w.Header().Set(requestIdHeader, id)
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// withRequestIdInErrors appends the request ID to the plain text error pages
+// written with http.Error, so users can include it when reporting the error.
+// It has to wrap the router inside of compressed, which compresses the ID
+// along with the error.
+func withRequestIdInErrors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ew := &errorPageWriter{ResponseWriter: w}
+
+		h.ServeHTTP(ew, r)
+
+		if ew.plainTextError {
+			fmt.Fprintln(w, fmtRequestId(r))
+		}
+	})
+}
+
+// fmtRequestId returns the line with the request ID shown on error pages.
+func fmtRequestId(r *http.Request) string {
+	return "Request ID: " + r.Header.Get(requestIdHeader)
+}
+
+// errorPageWriter notices if the status of the response is an error and its
+// body is plain text, like the one of http.Error.
+type errorPageWriter struct {
+	http.ResponseWriter
+	wroteHeader    bool
+	plainTextError bool
+}
+
+func (ew *errorPageWriter) WriteHeader(status int) {
+	if !ew.wroteHeader {
+		ew.wroteHeader = true
+		ew.plainTextError = status >= 400 && strings.HasPrefix(ew.Header().Get("Content-Type"), "text/plain")
+	}
+	ew.ResponseWriter.WriteHeader(status)
+}
+
+func (ew *errorPageWriter) Write(b []byte) (int, error) {
+	ew.wroteHeader = true
+	return ew.ResponseWriter.Write(b)
+}
+
+func (ew *errorPageWriter) Flush() {
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (ew *errorPageWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := ew.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can't be hijacked")
+	}
+	ew.wroteHeader = true
+	return hijacker.Hijack()
+}
+
+func newRequestId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		slog.Error("error generating request ID", "err", err)
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// isValidRequestId only accepts IDs that can't mess up the logs.
+func isValidRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
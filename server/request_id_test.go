@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWithRequestId(t *testing.T) {
+	h := withRequestId(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renderApiError(w, http.StatusNotFound, "deployment not found")
+	}))
+
+	tests := []struct {
+		requestId string
+		keep      bool
+	}{
+		{"", false},
+		{"a7f3c2e1-proxy", true},
+		{"with spaces", false},
+		{strings.Repeat("x", maxRequestIdLength+1), false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/v1/applications", nil)
+		if tt.requestId != "" {
+			req.Header.Set(requestIdHeader, tt.requestId)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		id := rec.Header().Get(requestIdHeader)
+		if id == "" {
+			t.Errorf("no request ID for %q", tt.requestId)
+			continue
+		}
+		if (id == tt.requestId) != tt.keep {
+			t.Errorf("wrong request ID for %q. want kept=%t, got=%q", tt.requestId, tt.keep, id)
+		}
+		if !strings.Contains(rec.Body.String(), `"request_id":"`+id+`"`) {
+			t.Errorf("request ID missing in error. got=%s", rec.Body.String())
+		}
+	}
+}
+
+func TestWithRequestIdInErrors(t *testing.T) {
+	h := withRequestId(withRequestIdInErrors(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/error":
+			http.Error(w, "deployment not found", http.StatusNotFound)
+		case "/api":
+			renderApiError(w, http.StatusNotFound, "deployment not found")
+		default:
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("ok\n"))
+		}
+	})))
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/error", "deployment not found\nRequest ID: f00b4r\n"},
+		// The errors of the API contain the ID already
+		{"/api", `{"error":{"status":404,"code":"not_found","message":"deployment not found","request_id":"f00b4r"}}`},
+		{"/", "ok\n"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.Header.Set(requestIdHeader, "f00b4r")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Body.String() != tt.expected {
+			t.Errorf("wrong body for %s. want=%q, got=%q", tt.path, tt.expected, rec.Body.String())
+		}
+	}
+}
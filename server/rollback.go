@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+// rollback redeploys the previous successful deployment to a target with
+// another commit than the deployed one.
+type rollback struct {
+	Application *models.Application
+	Target      *models.Target
+	// The deployment whose commit is deployed to the target
+	Current *models.Deployment
+	// The deployment whose commit, branch, tag and stages are redeployed
+	Previous *models.Deployment
+}
+
+// rollbackDialog is the rollback shown in the rollbackDialog template, with
+// the CSRF token its form is sent with.
+type rollbackDialog struct {
+	*rollback
+	CSRFToken string
+}
+
+func newRollbackDialog(rb *rollback, csrfToken string) *rollbackDialog {
+	return &rollbackDialog{rollback: rb, CSRFToken: csrfToken}
+}
+
+// getRollback returns the rollback of the target, nil if nothing was deployed
+// to it yet or the deployed commit is the only one.
+func getRollback(a *models.Application, t *models.Target) (*rollback, error) {
+	current, err := getLastTargetDeployment(db, a, t.Name)
+	if err != nil || current == nil {
+		return nil, err
+	}
+
+	previous, err := getPreviousTargetDeployment(db, a, current)
+	if err != nil || previous == nil {
+		return nil, err
+	}
+
+	err = loadDeploymentsUsers(db, []*models.Deployment{previous})
+	if err != nil {
+		return nil, err
+	}
+
+	return &rollback{Application: a, Target: t, Current: current, Previous: previous}, nil
+}
+
+// formValues converts the rollback to the values of the deployment form.
+// Deployments created before their stages were saved are redeployed with the
+// default stages of the target.
+func (rb *rollback) formValues(comment string) url.Values {
+	stages := rb.Previous.Stages
+	if len(stages) == 0 {
+		stages = rb.Target.DefaultStages
+	}
+
+	values := url.Values{
+		"target":      {rb.Target.Name},
+		"commitsha":   {rb.Previous.CommitSha},
+		"branch":      {rb.Previous.Branch},
+		"tag":         {rb.Previous.Tag},
+		"redeploy_of": {strconv.Itoa(rb.Previous.Id)},
+		"comment":     {rollbackComment(rb.Previous, comment)},
+	}
+	for _, s := range stages {
+		values.Add("stages[]", string(s))
+	}
+	return values
+}
+
+func rollbackComment(previous *models.Deployment, reason string) string {
+	comment := fmt.Sprintf("Roll back to deployment #%d", previous.Id)
+	if reason = strings.TrimSpace(reason); reason != "" {
+		comment += ": " + reason
+	}
+	return comment
+}
+
+// rollbackHandler rolls the target back. The form sends the commit shown in
+// the confirmation dialog, so nothing else is deployed if the target has
+// been deployed to in the meantime.
+func rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	target, err := findTarget(application, mux.Vars(r)["target"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	rb, err := getRollback(application, target)
+	if err != nil {
+		requestLogger(r).Error("error loading the rollback", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rb == nil {
+		http.Error(w, "there is no previous deployment to roll back to", 422)
+		return
+	}
+	if r.FormValue("commitsha") != rb.Previous.CommitSha {
+		http.Error(w, "the deployment to roll back to has changed, reload the page", http.StatusConflict)
+		return
+	}
+
+	r.Form = rb.formValues(r.FormValue("comment"))
+
+	deployment, target, stages, reqErr := newDeploymentFromRequest(r, application, currentUser)
+	if reqErr != nil {
+		http.Error(w, reqErr.Message, reqErr.Status)
+		return
+	}
+
+	err = startDeployment(application, target, deployment, stages)
+	if err != nil {
+		requestLogger(r).Error("could not start rollback", "err", err)
+		http.Error(w, err.Error(), startDeploymentErrorStatus(err))
+		return
+	}
+	recordDeploymentAuditEvents(r, currentUser, deployment)
+
+	http.Redirect(w, r, deploymentUrl(application, deployment), http.StatusSeeOther)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestRollbackFormValues(t *testing.T) {
+	target := &models.Target{
+		Name:          "production",
+		DefaultStages: []models.DeploymentStage{"CHECK_CONNECTION", "DEPLOY"},
+	}
+
+	tests := []struct {
+		previous       *models.Deployment
+		comment        string
+		expectedStages []string
+		expectedCmt    string
+	}{
+		{
+			&models.Deployment{Id: 12, CommitSha: "aaa", Branch: "master", Stages: []models.DeploymentStage{"DEPLOY"}},
+			"",
+			[]string{"DEPLOY"},
+			"Roll back to deployment #12",
+		},
+		{
+			&models.Deployment{Id: 7, CommitSha: "bbb", Tag: "v1.2.0"},
+			"  broke the checkout ",
+			[]string{"CHECK_CONNECTION", "DEPLOY"},
+			"Roll back to deployment #7: broke the checkout",
+		},
+	}
+
+	for _, tt := range tests {
+		rb := &rollback{Target: target, Previous: tt.previous}
+		values := rb.formValues(tt.comment)
+
+		if values.Get("commitsha") != tt.previous.CommitSha || values.Get("tag") != tt.previous.Tag || values.Get("branch") != tt.previous.Branch {
+			t.Errorf("wrong commit values. got=%v", values)
+		}
+		if !reflect.DeepEqual(values["stages[]"], tt.expectedStages) {
+			t.Errorf("wrong stages. want=%v, got=%v", tt.expectedStages, values["stages[]"])
+		}
+		if values.Get("comment") != tt.expectedCmt {
+			t.Errorf("wrong comment. want=%q, got=%q", tt.expectedCmt, values.Get("comment"))
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/crewjam/saml"
+)
+
+const (
+	SAML_PROVIDER     = "saml"
+	defaultSAMLTitle  = "SAML"
+	samlRequestCookie = "applikatoni_saml_request"
+)
+
+// SAMLProvider logs users in with a SAML 2.0 identity provider. Applikatoni
+// is the service provider.
+type SAMLProvider struct {
+	title             string
+	usernameAttribute string
+	avatarAttribute   string
+	groupsAttribute   string
+	sp                *saml.ServiceProvider
+}
+
+func NewSAMLProvider(c *Configuration) (*SAMLProvider, error) {
+	keyPair, err := tls.LoadX509KeyPair(c.SAMLCertificateFile, c.SAMLKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("saml_key_file is not a RSA key")
+	}
+	cert, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	idpMetadata, err := fetchIDPMetadata(c.SAMLIDPMetadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching IdP metadata failed: %s", err)
+	}
+
+	metadataURL, err := url.Parse(c.URL("/saml/metadata"))
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(c.URL("/saml/acs"))
+	if err != nil {
+		return nil, err
+	}
+
+	title := c.SAMLTitle
+	if title == "" {
+		title = defaultSAMLTitle
+	}
+
+	provider := &SAMLProvider{
+		title:             title,
+		usernameAttribute: c.SAMLUsernameAttribute,
+		avatarAttribute:   c.SAMLAvatarAttribute,
+		groupsAttribute:   c.SAMLGroupsAttribute,
+		sp: &saml.ServiceProvider{
+			EntityID:    metadataURL.String(),
+			Key:         key,
+			Certificate: cert,
+			MetadataURL: *metadataURL,
+			AcsURL:      *acsURL,
+			IDPMetadata: idpMetadata,
+			// Users are identified by their NameID, so it has to stay the same
+			AuthnNameIDFormat: saml.PersistentNameIDFormat,
+		},
+	}
+
+	return provider, nil
+}
+
+func fetchIDPMetadata(metadataURL string) (*saml.EntityDescriptor, error) {
+	res, err := http.Get(metadataURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("IdP responded with %d instead of 200", res.StatusCode)
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := &saml.EntityDescriptor{}
+	err = xml.Unmarshal(data, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return metadata, nil
+}
+
+func (p *SAMLProvider) Title() string { return p.title }
+
+// AuthenticationRequestURL returns the URL of the IdP the user is redirected
+// to and the id of the request, which has to be passed to ParseResponse.
+func (p *SAMLProvider) AuthenticationRequestURL() (string, string, error) {
+	idpURL := p.sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	req, err := p.sp.MakeAuthenticationRequest(idpURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+
+	redirectURL, err := req.Redirect("", p.sp)
+	if err != nil {
+		return "", "", err
+	}
+
+	return redirectURL.String(), req.ID, nil
+}
+
+// ParseResponse verifies the SAML response posted by the IdP and returns the
+// user it authenticates.
+func (p *SAMLProvider) ParseResponse(r *http.Request, requestId string) (*models.User, error) {
+	assertion, err := p.sp.ParseResponse(r, []string{requestId})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.userFromAssertion(assertion)
+}
+
+func (p *SAMLProvider) Metadata() ([]byte, error) {
+	return xml.MarshalIndent(p.sp.Metadata(), "", "  ")
+}
+
+func (p *SAMLProvider) userFromAssertion(assertion *saml.Assertion) (*models.User, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		return nil, errors.New("SAML assertion contains no NameID")
+	}
+	nameId := assertion.Subject.NameID.Value
+
+	// Without a username attribute the NameID is the user name
+	name := nameId
+	if p.usernameAttribute != "" {
+		values := assertionAttribute(assertion, p.usernameAttribute)
+		if len(values) == 0 {
+			return nil, fmt.Errorf("SAML assertion contains no %s attribute", p.usernameAttribute)
+		}
+		name = values[0]
+	}
+
+	user := &models.User{
+		Name:       name,
+		Provider:   SAML_PROVIDER,
+		ProviderId: nameId,
+		Groups:     []string{},
+	}
+
+	if p.avatarAttribute != "" {
+		if values := assertionAttribute(assertion, p.avatarAttribute); len(values) > 0 {
+			user.AvatarUrl = values[0]
+		}
+	}
+
+	if p.groupsAttribute != "" {
+		user.Groups = assertionAttribute(assertion, p.groupsAttribute)
+	}
+
+	return user, nil
+}
+
+// assertionAttribute returns the values of the attribute with the given
+// name or friendly name.
+func assertionAttribute(assertion *saml.Assertion, name string) []string {
+	values := []string{}
+
+	for _, statement := range assertion.AttributeStatements {
+		for _, attr := range statement.Attributes {
+			if attr.Name != name && attr.FriendlyName != name {
+				continue
+			}
+			for _, v := range attr.Values {
+				values = append(values, v.Value)
+			}
+		}
+	}
+
+	return values
+}
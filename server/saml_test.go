@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/crewjam/saml"
+)
+
+func buildAssertion(nameId string, attributes map[string][]string) *saml.Assertion {
+	statement := saml.AttributeStatement{}
+	for name, values := range attributes {
+		attr := saml.Attribute{Name: name}
+		for _, v := range values {
+			attr.Values = append(attr.Values, saml.AttributeValue{Value: v})
+		}
+		statement.Attributes = append(statement.Attributes, attr)
+	}
+
+	return &saml.Assertion{
+		Subject:             &saml.Subject{NameID: &saml.NameID{Value: nameId}},
+		AttributeStatements: []saml.AttributeStatement{statement},
+	}
+}
+
+func TestSAMLUserFromAssertion(t *testing.T) {
+	provider := &SAMLProvider{
+		usernameAttribute: "uid",
+		avatarAttribute:   "avatar",
+		groupsAttribute:   "memberOf",
+	}
+
+	assertion := buildAssertion("00u1", map[string][]string{
+		"uid":      {"mrnugget"},
+		"avatar":   {"http://example.com/a.png"},
+		"memberOf": {"ops", "developers"},
+	})
+
+	user, err := provider.userFromAssertion(assertion)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if user.Name != "mrnugget" {
+		t.Errorf("wrong name. want=%s, got=%s", "mrnugget", user.Name)
+	}
+	if user.Provider != SAML_PROVIDER {
+		t.Errorf("wrong provider. want=%s, got=%s", SAML_PROVIDER, user.Provider)
+	}
+	if user.ProviderId != "00u1" {
+		t.Errorf("wrong provider id. want=%s, got=%s", "00u1", user.ProviderId)
+	}
+	if user.AvatarUrl != "http://example.com/a.png" {
+		t.Errorf("wrong avatar url. want=%s, got=%s", "http://example.com/a.png", user.AvatarUrl)
+	}
+	if len(user.Groups) != 2 || user.Groups[0] != "ops" || user.Groups[1] != "developers" {
+		t.Errorf("wrong groups. want=%v, got=%v", []string{"ops", "developers"}, user.Groups)
+	}
+
+	missing := buildAssertion("00u1", map[string][]string{})
+	_, err = provider.userFromAssertion(missing)
+	if err == nil {
+		t.Errorf("expected error for missing username attribute")
+	}
+
+	// Without a username attribute the NameID is used
+	provider = &SAMLProvider{}
+	user, err = provider.userFromAssertion(missing)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if user.Name != "00u1" {
+		t.Errorf("wrong name. want=%s, got=%s", "00u1", user.Name)
+	}
+
+	// NameIDs don't match GitHub users with that name
+	target := &models.Target{DeployUsernames: []string{"00u1"}}
+	if target.CanDeploy(user) {
+		t.Errorf("SAML user can deploy as GitHub user %s", user.Name)
+	}
+	target.DeployUsernames = []string{"saml:00u1"}
+	if !target.CanDeploy(user) {
+		t.Errorf("SAML user listed as %s can't deploy", "saml:00u1")
+	}
+	if user.Groups == nil || len(user.Groups) != 0 {
+		t.Errorf("expected empty groups, got=%v", user.Groups)
+	}
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// The commits, pull requests, branches and diffs of all SCMs are returned in
+// the format of the GitHub API, which applikatoni.js renders.
+
+type Commit struct {
+	Author  *models.User `json:"author"`
+	Sha     string       `json:"sha"`
+	HtmlURL string       `json:"html_url"`
+	Commit  struct {
+		Message   string `json:"message"`
+		Committer struct {
+			ComittedAt time.Time `json:"date"`
+		} `json:"committer"`
+	} `json:"commit"`
+}
+
+type PullRequest struct {
+	Id        int64        `json:"id"`
+	Number    int          `json:"number"`
+	Url       string       `json:"html_url"`
+	Title     string       `json:"title"`
+	User      *models.User `json:"user"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Head      struct {
+		Branch    string `json:"ref"`
+		CommitSha string `json:"sha"`
+	} `json:"head"`
+	TravisImageURL  string `json:"travis_image_url"`
+	TravisImageLink string `json:"travis_image_link"`
+}
+
+type Branch struct {
+	Name            string `json:"name"`
+	CurrentCommit   Commit `json:"commit"`
+	TravisImageURL  string `json:"travis_image_url"`
+	TravisImageLink string `json:"travis_image_link"`
+}
+
+type Tag struct {
+	Name   string `json:"name"`
+	Commit struct {
+		Sha string `json:"sha"`
+	} `json:"commit"`
+}
+
+type Diff struct {
+	CompareURL string   `json:"html_url"`
+	Status     string   `json:"status"`
+	AheadBy    int      `json:"ahead_by"`
+	BehindBy   int      `json:"behind_by"`
+	Commits    []Commit `json:"commits"`
+}
+
+// SCMClient loads the data shown in the deploy form from the code hosting
+// service of an application.
+type SCMClient interface {
+	GetPullRequests(a *models.Application) ([]PullRequest, error)
+	GetBranches(a *models.Application) ([]Branch, error)
+	GetBranch(a *models.Application, name string) (*Branch, error)
+	Compare(a *models.Application, oldSha, newSha string) (*Diff, error)
+	GetPullRequest(a *models.Application, number int) (*PullRequest, error)
+	CommentOnPullRequest(a *models.Application, number int, body string) error
+	GetTags(a *models.Application) ([]Tag, error)
+	GetTag(a *models.Application, name string) (*Tag, error)
+}
+
+// ErrSCMLoginRequired is returned if the user can't access the repository,
+// because the user logged in with another provider and the application has
+// no scm_access_token.
+var ErrSCMLoginRequired = errors.New("log in with the code hosting service of the application to load its repository")
+
+// NewSCMClient returns a client for the SCM of the application. It uses the
+// scm_access_token of the application or, if none is configured, the access
+// token of the user.
+func NewSCMClient(a *models.Application, u *models.User) (SCMClient, error) {
+	config := getConfig()
+	switch a.SCMName() {
+	case models.SCM_GITHUB:
+		token, err := scmAccessToken(a, u, GITHUB_PROVIDER)
+		if err != nil {
+			return nil, err
+		}
+		return newGitHubTokenClient(token), nil
+	case models.SCM_GITLAB:
+		token, err := scmAccessToken(a, u, GITLAB_PROVIDER)
+		if err != nil {
+			return nil, err
+		}
+		return NewGitLabClient(config.GitLabBaseURL(), token), nil
+	case models.SCM_BITBUCKET:
+		token, err := scmAccessToken(a, u, BITBUCKET_PROVIDER)
+		if err != nil {
+			return nil, err
+		}
+		return NewBitbucketClient(bitbucketAPI, token), nil
+	case models.SCM_GITEA:
+		token, err := scmAccessToken(a, u, GITEA_PROVIDER)
+		if err != nil {
+			return nil, err
+		}
+		return NewGiteaClient(config.GiteaBaseURL(), token), nil
+	}
+
+	return nil, fmt.Errorf("unknown scm %q", a.SCM)
+}
+
+// getPullRequest loads a pull request of the application by its number.
+func getPullRequest(a *models.Application, u *models.User, number int) (*PullRequest, error) {
+	client, err := NewSCMClient(a, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetPullRequest(a, number)
+}
+
+// getBranch loads a branch of the application by its name. Unlike
+// GetBranches, it isn't limited to the github_branches of the application.
+func getBranch(a *models.Application, u *models.User, name string) (*Branch, error) {
+	client, err := NewSCMClient(a, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetBranch(a, name)
+}
+
+// checkCommitOnBranch returns an error if the commit isn't the head of the
+// branch or one of its ancestors. Otherwise any commit could be deployed under
+// the name of one of the deployable_branches of a target.
+func checkCommitOnBranch(client SCMClient, a *models.Application, branch, commitSha string) error {
+	b, err := client.GetBranch(a, branch)
+	if err != nil {
+		return fmt.Errorf("could not load branch %s: %s", branch, err)
+	}
+	if b.CurrentCommit.Sha == commitSha {
+		return nil
+	}
+
+	// The commits of the branch aren't ahead of its head
+	diff, err := client.Compare(a, b.CurrentCommit.Sha, commitSha)
+	if err != nil {
+		return fmt.Errorf("could not compare commit %s with branch %s: %s", commitSha, branch, err)
+	}
+	if diff.AheadBy > 0 {
+		return fmt.Errorf("commit %s is not on branch %s", commitSha, branch)
+	}
+	return nil
+}
+
+// checkDeployableCommit checks that the commit is on the branch if the target
+// is restricted to deployable_branches.
+func checkDeployableCommit(a *models.Application, t *models.Target, u *models.User, branch, commitSha string) error {
+	if len(t.DeployableBranches) == 0 {
+		return nil
+	}
+
+	client, err := NewSCMClient(a, u)
+	if err != nil {
+		return err
+	}
+	return checkCommitOnBranch(client, a, branch, commitSha)
+}
+
+// getTag loads a tag of the application by its name.
+func getTag(a *models.Application, u *models.User, name string) (*Tag, error) {
+	client, err := NewSCMClient(a, u)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.GetTag(a, name)
+}
+
+// scmAccessToken returns the scm_access_token of the application, the token of
+// the installation of the GitHub App on its repository or the access token of
+// the user, in that order.
+func scmAccessToken(a *models.Application, u *models.User, provider string) (string, error) {
+	if a.SCMAccessToken != "" {
+		return a.SCMAccessToken, nil
+	}
+	if provider == GITHUB_PROVIDER && githubApp != nil {
+		return githubApp.InstallationToken(a.GitHubOwner, a.GitHubRepo)
+	}
+	if u.Provider != provider {
+		return "", ErrSCMLoginRequired
+	}
+	return u.AccessToken, nil
+}
+
+// usesUserSCMToken checks whether the repository of the application is
+// accessed with the access token of the user, who has to log in again if the
+// token is rejected.
+func usesUserSCMToken(a *models.Application) bool {
+	if a.SCMAccessToken != "" {
+		return false
+	}
+	return a.SCMName() != models.SCM_GITHUB || githubApp == nil
+}
+
+// isSCMUnauthorized checks whether the SCM rejected the access token.
+func isSCMUnauthorized(err error) bool {
+	return err == ErrGitHubUnauthorized || err == ErrGitLabUnauthorized ||
+		err == ErrBitbucketUnauthorized || err == ErrGiteaUnauthorized
+}
+
+func isValidSCM(name string) bool {
+	switch name {
+	case models.SCM_GITHUB, models.SCM_GITLAB, models.SCM_BITBUCKET, models.SCM_GITEA:
+		return true
+	}
+	return false
+}
+
+// repositoryWebURL returns the URL of the repository on the web interface of
+// its SCM.
+func repositoryWebURL(a *models.Application) string {
+	config := getConfig()
+	switch a.SCMName() {
+	case models.SCM_GITLAB:
+		return fmt.Sprintf("%s/%s/%s", config.GitLabBaseURL(), a.GitHubOwner, a.GitHubRepo)
+	case models.SCM_BITBUCKET:
+		return fmt.Sprintf("%s/%s/%s", bitbucketURL, a.GitHubOwner, a.GitHubRepo)
+	case models.SCM_GITEA:
+		return fmt.Sprintf("%s/%s/%s", config.GiteaBaseURL(), a.GitHubOwner, a.GitHubRepo)
+	default:
+		return fmt.Sprintf("%s/%s/%s", config.GitHubBaseURL(), a.GitHubOwner, a.GitHubRepo)
+	}
+}
+
+func commitLink(a *models.Application, sha string) string {
+	switch a.SCMName() {
+	case models.SCM_GITLAB:
+		return fmt.Sprintf("%s/-/commit/%s", repositoryWebURL(a), sha)
+	case models.SCM_BITBUCKET:
+		return fmt.Sprintf("%s/commits/%s", repositoryWebURL(a), sha)
+	default:
+		return fmt.Sprintf("%s/commit/%s", repositoryWebURL(a), sha)
+	}
+}
+
+// pullRequestLink returns the URL of a pull request or merge request.
+func pullRequestLink(a *models.Application, number int) string {
+	switch a.SCMName() {
+	case models.SCM_GITLAB:
+		return fmt.Sprintf("%s/-/merge_requests/%d", repositoryWebURL(a), number)
+	case models.SCM_BITBUCKET:
+		return fmt.Sprintf("%s/pull-requests/%d", repositoryWebURL(a), number)
+	case models.SCM_GITEA:
+		return fmt.Sprintf("%s/pulls/%d", repositoryWebURL(a), number)
+	default:
+		return fmt.Sprintf("%s/pull/%d", repositoryWebURL(a), number)
+	}
+}
+
+// repositoryURL returns the SSH clone URL of the repository.
+func repositoryURL(a *models.Application) string {
+	config := getConfig()
+	switch a.SCMName() {
+	case models.SCM_GITLAB:
+		return fmt.Sprintf("git@%s:%s/%s.git", urlHost(config.GitLabBaseURL()), a.GitHubOwner, a.GitHubRepo)
+	case models.SCM_BITBUCKET:
+		return fmt.Sprintf("git@bitbucket.org:%s/%s.git", a.GitHubOwner, a.GitHubRepo)
+	case models.SCM_GITEA:
+		return fmt.Sprintf("git@%s:%s/%s.git", urlHost(config.GiteaBaseURL()), a.GitHubOwner, a.GitHubRepo)
+	default:
+		return fmt.Sprintf("git@%s:%s/%s.git", urlHost(config.GitHubBaseURL()), a.GitHubOwner, a.GitHubRepo)
+	}
+}
+
+// urlHost returns the host of a base URL like https://gitlab.example.com
+func urlHost(baseURL string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(baseURL, "https://"), "http://")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const defaultSCMCacheTTL = 1 * time.Minute
+
+// scmLookupCache keeps the branches, tags and comparisons loaded from the
+// SCMs, so loading the deploy form doesn't call the SCM every time and use up
+// its rate limit.
+var scmLookupCache = newSCMCache()
+
+type scmCacheEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// scmCache is an in-memory cache of SCM lookups. Its keys start with the
+// repository they belong to, so all lookups of a repository can be
+// invalidated at once.
+type scmCache struct {
+	entries map[string]*scmCacheEntry
+	mutex   *sync.Mutex
+}
+
+func newSCMCache() *scmCache {
+	return &scmCache{
+		entries: make(map[string]*scmCacheEntry),
+		mutex:   &sync.Mutex{},
+	}
+}
+
+func (c *scmCache) get(key string, now time.Time) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !now.Before(e.expiresAt) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+func (c *scmCache) set(key string, value interface{}, expiresAt time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries[key] = &scmCacheEntry{value: value, expiresAt: expiresAt}
+}
+
+// Invalidate drops the cached lookups of the repository of the application,
+// including the ones of other applications deployed from it.
+func (c *scmCache) Invalidate(a *models.Application) {
+	prefix := scmRepositoryKey(a)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// scmRepositoryKey identifies the repository of the application, e.g.
+// "github:shipping-company/web/".
+func scmRepositoryKey(a *models.Application) string {
+	return a.SCMName() + ":" + a.GitHubOwner + "/" + a.GitHubRepo + "/"
+}
+
+// cachingSCMClient answers the lookups of branches, tags and comparisons from
+// the cache and only passes them to the SCM once they expired. Pull requests
+// aren't cached, new ones should show up right away.
+type cachingSCMClient struct {
+	SCMClient
+	cache *scmCache
+	ttl   time.Duration
+}
+
+func newCachingSCMClient(client SCMClient, cache *scmCache, ttl time.Duration) *cachingSCMClient {
+	return &cachingSCMClient{SCMClient: client, cache: cache, ttl: ttl}
+}
+
+// NewCachedSCMClient returns the client of NewSCMClient, with its lookups
+// cached for scm_cache_ttl. It's only meant for showing the repository, e.g.
+// in the deploy form: the commit that is deployed is always resolved with
+// NewSCMClient.
+func NewCachedSCMClient(a *models.Application, u *models.User) (SCMClient, error) {
+	client, err := NewSCMClient(a, u)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl, _ := getConfig().SCMCacheTTLDuration()
+	if ttl == 0 {
+		return client, nil
+	}
+	return newCachingSCMClient(client, scmLookupCache, ttl), nil
+}
+
+// lookup returns the cached value of the key or saves the value returned by
+// load. Errors aren't cached.
+func (c *cachingSCMClient) lookup(key string, load func() (interface{}, error)) (interface{}, error) {
+	now := time.Now()
+	if value, ok := c.cache.get(key, now); ok {
+		return value, nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+	c.cache.set(key, value, now.Add(c.ttl))
+	return value, nil
+}
+
+func (c *cachingSCMClient) GetBranches(a *models.Application) ([]Branch, error) {
+	// The branches and their CI images depend on the configuration of the
+	// application, not only on the repository
+	key := scmRepositoryKey(a) + "branches/" + a.Name
+	value, err := c.lookup(key, func() (interface{}, error) {
+		return c.SCMClient.GetBranches(a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]Branch), nil
+}
+
+func (c *cachingSCMClient) GetBranch(a *models.Application, name string) (*Branch, error) {
+	value, err := c.lookup(scmRepositoryKey(a)+"branch/"+name, func() (interface{}, error) {
+		return c.SCMClient.GetBranch(a, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Branch), nil
+}
+
+func (c *cachingSCMClient) Compare(a *models.Application, oldSha, newSha string) (*Diff, error) {
+	value, err := c.lookup(scmRepositoryKey(a)+"compare/"+oldSha+"..."+newSha, func() (interface{}, error) {
+		return c.SCMClient.Compare(a, oldSha, newSha)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Diff), nil
+}
+
+func (c *cachingSCMClient) GetTags(a *models.Application) ([]Tag, error) {
+	value, err := c.lookup(scmRepositoryKey(a)+"tags", func() (interface{}, error) {
+		return c.SCMClient.GetTags(a)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.([]Tag), nil
+}
+
+func (c *cachingSCMClient) GetTag(a *models.Application, name string) (*Tag, error) {
+	value, err := c.lookup(scmRepositoryKey(a)+"tag/"+name, func() (interface{}, error) {
+		return c.SCMClient.GetTag(a, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Tag), nil
+}
+
+// scmRefreshHandler drops the cached branches, tags and comparisons of the
+// repository of the application, e.g. right after pushing a branch.
+func scmRefreshHandler(w http.ResponseWriter, r *http.Request) {
+	application := getCurrentApplication(r)
+
+	scmLookupCache.Invalidate(application)
+
+	addFlash(w, r, "The branches and tags are reloaded from the repository.")
+	http.Redirect(w, r, "/"+application.Name, http.StatusSeeOther)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// countingSCMClient counts the branch lookups that reach the SCM.
+type countingSCMClient struct {
+	SCMClient
+	calls int
+	err   error
+}
+
+func (c *countingSCMClient) GetBranch(a *models.Application, name string) (*Branch, error) {
+	c.calls++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return &Branch{Name: name}, nil
+}
+
+func TestCachingSCMClient(t *testing.T) {
+	web := &models.Application{Name: "web", GitHubOwner: "shipping-company", GitHubRepo: "web"}
+	worker := &models.Application{Name: "worker", GitHubOwner: "shipping-company", GitHubRepo: "web"}
+	api := &models.Application{Name: "api", GitHubOwner: "shipping-company", GitHubRepo: "api"}
+
+	cache := newSCMCache()
+	scm := &countingSCMClient{}
+	client := newCachingSCMClient(scm, cache, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		branch, err := client.GetBranch(web, "master")
+		checkErr(t, err)
+		if branch.Name != "master" {
+			t.Errorf("wrong branch. got=%s", branch.Name)
+		}
+	}
+	if scm.calls != 1 {
+		t.Errorf("branch not cached. want=1 call, got=%d", scm.calls)
+	}
+
+	// Applications deployed from the same repository share its lookups
+	for _, a := range []*models.Application{worker, api} {
+		_, err := client.GetBranch(a, "master")
+		checkErr(t, err)
+	}
+	if scm.calls != 2 {
+		t.Errorf("wrong number of calls. want=2, got=%d", scm.calls)
+	}
+
+	cache.Invalidate(worker)
+	for _, a := range []*models.Application{web, api} {
+		_, err := client.GetBranch(a, "master")
+		checkErr(t, err)
+	}
+	if scm.calls != 3 {
+		t.Errorf("only the invalidated repository should be loaded again. want=3 calls, got=%d", scm.calls)
+	}
+
+	scm.err = errors.New("rate limit exceeded")
+	for i := 0; i < 2; i++ {
+		if _, err := client.GetBranch(web, "production"); err != scm.err {
+			t.Errorf("wrong error. want=%v, got=%v", scm.err, err)
+		}
+	}
+	if scm.calls != 5 {
+		t.Errorf("errors should not be cached. want=5 calls, got=%d", scm.calls)
+	}
+}
+
+func TestSCMCacheExpiry(t *testing.T) {
+	cache := newSCMCache()
+	now := time.Now()
+
+	cache.set("github:shipping-company/web/tags", []Tag{}, now.Add(time.Minute))
+
+	if _, ok := cache.get("github:shipping-company/web/tags", now.Add(59*time.Second)); !ok {
+		t.Errorf("entry expired too early")
+	}
+	if _, ok := cache.get("github:shipping-company/web/tags", now.Add(time.Minute)); ok {
+		t.Errorf("expired entry returned")
+	}
+	if len(cache.entries) != 0 {
+		t.Errorf("expired entry kept. got=%v", cache.entries)
+	}
+}
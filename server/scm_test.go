@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestNewSCMClient(t *testing.T) {
+	setConfig(&Configuration{})
+
+	gitHubUser := &models.User{Provider: GITHUB_PROVIDER, AccessToken: "t0k3n"}
+	gitLabUser := &models.User{Provider: GITLAB_PROVIDER, AccessToken: "t0k3n"}
+
+	gitHubApp := &models.Application{}
+	gitLabApp := &models.Application{SCM: models.SCM_GITLAB}
+	gitLabAppWithToken := &models.Application{SCM: models.SCM_GITLAB, SCMAccessToken: "4pp"}
+
+	tests := []struct {
+		application *models.Application
+		user        *models.User
+		expectedErr error
+	}{
+		{gitHubApp, gitHubUser, nil},
+		{gitHubApp, gitLabUser, ErrSCMLoginRequired},
+		{gitLabApp, gitLabUser, nil},
+		{gitLabApp, gitHubUser, ErrSCMLoginRequired},
+		{gitLabAppWithToken, gitHubUser, nil},
+	}
+
+	for _, tt := range tests {
+		_, err := NewSCMClient(tt.application, tt.user)
+		if err != tt.expectedErr {
+			t.Errorf("wrong error for %s user on %s. want=%v, got=%v",
+				tt.user.Provider, tt.application.SCMName(), tt.expectedErr, err)
+		}
+	}
+
+	_, err := NewSCMClient(&models.Application{SCM: "svn"}, gitHubUser)
+	if err == nil {
+		t.Errorf("expected error for unknown scm")
+	}
+}
+
+func TestCommitLink(t *testing.T) {
+	setConfig(&Configuration{GitLabURL: "https://gitlab.example.com/"})
+	defer func() { setConfig(&Configuration{}) }()
+
+	tests := []struct {
+		application *models.Application
+		expected    string
+	}{
+		{
+			&models.Application{GitHubOwner: "shipping-co", GitHubRepo: "web-app"},
+			"https://github.com/shipping-co/web-app/commit/f00b4r",
+		},
+		{
+			&models.Application{SCM: models.SCM_GITLAB, GitHubOwner: "shipping-co", GitHubRepo: "web-app"},
+			"https://gitlab.example.com/shipping-co/web-app/-/commit/f00b4r",
+		},
+		{
+			&models.Application{SCM: models.SCM_BITBUCKET, GitHubOwner: "shipping-co", GitHubRepo: "web-app"},
+			"https://bitbucket.org/shipping-co/web-app/commits/f00b4r",
+		},
+	}
+
+	for _, tt := range tests {
+		got := commitLink(tt.application, "f00b4r")
+		if got != tt.expected {
+			t.Errorf("wrong commit link. want=%s, got=%s", tt.expected, got)
+		}
+	}
+
+	gitLabApp := &models.Application{SCM: models.SCM_GITLAB, GitHubOwner: "shipping-co", GitHubRepo: "web-app"}
+	expected := "git@gitlab.example.com:shipping-co/web-app.git"
+	if got := repositoryURL(gitLabApp); got != expected {
+		t.Errorf("wrong repository URL. want=%s, got=%s", expected, got)
+	}
+
+	expected = "https://gitlab.example.com/shipping-co/web-app/-/merge_requests/7"
+	if got := pullRequestLink(gitLabApp, 7); got != expected {
+		t.Errorf("wrong pull request link. want=%s, got=%s", expected, got)
+	}
+}
+
+// branchSCMClient has a branch with the commits in onBranch, head first.
+type branchSCMClient struct {
+	driftSCMClient
+	onBranch []string
+}
+
+func (c *branchSCMClient) Compare(a *models.Application, oldSha, newSha string) (*Diff, error) {
+	if oldSha != c.head {
+		return nil, errors.New("not found")
+	}
+	for _, sha := range c.onBranch {
+		if sha == newSha {
+			return &Diff{Status: "behind"}, nil
+		}
+	}
+	return &Diff{Status: "diverged", AheadBy: 1}, nil
+}
+
+func TestCheckCommitOnBranch(t *testing.T) {
+	client := &branchSCMClient{
+		driftSCMClient: driftSCMClient{head: "c3"},
+		onBranch:       []string{"c3", "c2", "c1"},
+	}
+
+	tests := []struct {
+		commitSha string
+		valid     bool
+	}{
+		{"c3", true},
+		{"c1", true},
+		{"feature", false},
+	}
+
+	for _, tt := range tests {
+		err := checkCommitOnBranch(client, &models.Application{}, "master", tt.commitSha)
+		if tt.valid && err != nil {
+			t.Errorf("commit %s rejected: %s", tt.commitSha, err)
+		}
+		if !tt.valid && (err == nil || !strings.Contains(err.Error(), "is not on branch master")) {
+			t.Errorf("wrong error for commit %s. got=%v", tt.commitSha, err)
+		}
+	}
+}
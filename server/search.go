@@ -0,0 +1,75 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const (
+	searchResultsLimit = 100
+	// Shorter queries match almost every deployment
+	minSearchQueryLength = 2
+)
+
+// searchHandler finds the deployments of the application whose commit SHA
+// starts with the query, or whose branch, tag, comment or deployer contains
+// it.
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	var results interface{}
+	if len(query) >= minSearchQueryLength {
+		deployments, err := searchApplicationDeployments(db, application, query, searchResultsLimit)
+		if err != nil {
+			requestLogger(r).Error("error searching deployments", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = loadDeploymentsUsers(db, deployments)
+		if err != nil {
+			requestLogger(r).Error("error loading the users of the deployments", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		results = deployments
+	}
+
+	renderTemplate(w, r, "search.tmpl", map[string]interface{}{
+		"Applications":   getConfig().Applications,
+		"Application":    application,
+		"Query":          query,
+		"MinQueryLength": minSearchQueryLength,
+		"Deployments":    results,
+		"Limit":          searchResultsLimit,
+		"currentUser":    currentUser,
+	})
+}
+
+// highlight escapes the text and marks the case-insensitive matches of the
+// query in it.
+func highlight(text, query string) template.HTML {
+	if query == "" {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	re := regexp.MustCompile("(?i)" + regexp.QuoteMeta(query))
+
+	var out strings.Builder
+	last := 0
+	for _, match := range re.FindAllStringIndex(text, -1) {
+		out.WriteString(template.HTMLEscapeString(text[last:match[0]]))
+		out.WriteString("<mark>")
+		out.WriteString(template.HTMLEscapeString(text[match[0]:match[1]]))
+		out.WriteString("</mark>")
+		last = match[1]
+	}
+	out.WriteString(template.HTMLEscapeString(text[last:]))
+
+	return template.HTML(out.String())
+}
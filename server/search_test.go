@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		text     string
+		query    string
+		expected string
+	}{
+		{"Fix the login", "login", "Fix the <mark>login</mark>"},
+		{"Release v1.2", "RELEASE", "<mark>Release</mark> v1.2"},
+		{"a.b a.b", "a.b", "<mark>a.b</mark> <mark>a.b</mark>"},
+		{"<script>", "script", "&lt;<mark>script</mark>&gt;"},
+		{"no match", "xyz", "no match"},
+		{"<b>", "", "&lt;b&gt;"},
+	}
+
+	for _, tt := range tests {
+		if got := string(highlight(tt.text, tt.query)); got != tt.expected {
+			t.Errorf("wrong highlighting of %q in %q. want=%q, got=%q", tt.query, tt.text, tt.expected, got)
+		}
+	}
+}
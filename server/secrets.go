@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// SecretResolver fetches the secret a reference in the configuration points
+// to. The reference doesn't include the prefix of the resolver.
+type SecretResolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// secretPrefixes are the prefixes of the references to secrets. Values with
+// these prefixes can't be used as they are.
+var secretPrefixes = []string{vaultSecretPrefix, secretsManagerSecretPrefix, ssmSecretPrefix, kmsSecretPrefix}
+
+// newSecretResolvers returns the resolvers for the secret backends configured
+// in c, keyed by the prefix of their references, e.g. "vault:". AWS is
+// always available, its credentials come from the environment.
+func newSecretResolvers(c *Configuration) (map[string]SecretResolver, error) {
+	resolvers := make(map[string]SecretResolver)
+
+	vault, err := NewVaultClient(c)
+	if err != nil {
+		return nil, fmt.Errorf("setting up vault failed: %s", err)
+	}
+	if vault != nil {
+		resolvers[vaultSecretPrefix] = vault
+	}
+
+	aws, err := NewAWSSecretsClient(c)
+	if err != nil {
+		return nil, fmt.Errorf("setting up AWS failed: %s", err)
+	}
+	resolvers[secretsManagerSecretPrefix] = aws.SecretsManager()
+	resolvers[ssmSecretPrefix] = aws.ParameterStore()
+	resolvers[kmsSecretPrefix] = aws.KMS()
+
+	return resolvers, nil
+}
+
+// resolveSecrets replaces every value of the configuration that references
+// a secret, like vault:secret/applikatoni#ssh_key, with the secret. It fails
+// if a reference can't be resolved, so Applikatoni never runs with the
+// reference in place of the secret. The backends are only set up if the
+// configuration references secrets.
+func resolveSecrets(c *Configuration) error {
+	return resolveSecretsIn(c, c)
+}
+
+// resolveSecretsIn replaces the references to secrets in v, e.g. an
+// application added at runtime, using the backends configured in c.
+func resolveSecretsIn(c *Configuration, v interface{}) error {
+	lookup := secretLookup(c)
+
+	return walkStrings(reflect.ValueOf(v), func(value string) (string, error) {
+		if secretPrefix(value) == "" {
+			return value, nil
+		}
+		return lookup(value)
+	})
+}
+
+// secretLookup returns a function resolving references to secrets with the
+// backends configured in c, e.g. for the secret function of script
+// templates. The backends are set up on the first reference.
+func secretLookup(c *Configuration) models.SecretLookup {
+	var resolvers map[string]SecretResolver
+
+	return func(ref string) (string, error) {
+		prefix := secretPrefix(ref)
+		if prefix == "" {
+			return "", fmt.Errorf("%s isn't a reference to a secret, it has to start with one of %s", ref, strings.Join(secretPrefixes, ", "))
+		}
+
+		if resolvers == nil {
+			var err error
+			resolvers, err = newSecretResolvers(c)
+			if err != nil {
+				return "", err
+			}
+		}
+		resolver, ok := resolvers[prefix]
+		if !ok {
+			return "", fmt.Errorf("can't resolve %s, the %s backend isn't configured", ref, strings.TrimSuffix(prefix, ":"))
+		}
+		secret, err := resolver.Resolve(strings.TrimPrefix(ref, prefix))
+		if err != nil {
+			return "", fmt.Errorf("resolving %s failed: %s", ref, err)
+		}
+		rememberResolvedSecret(secret)
+		return secret, nil
+	}
+}
+
+// secretPrefix returns the prefix of the reference to a secret, or "" if the
+// value isn't one.
+func secretPrefix(value string) string {
+	for _, prefix := range secretPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return prefix
+		}
+	}
+	return ""
+}
+
+// walkStrings replaces the strings in the structs, pointers, slices and maps
+// reachable from v with the result of fn.
+func walkStrings(v reflect.Value, fn func(string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return walkStrings(v.Elem(), fn)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).CanSet() {
+				continue
+			}
+			if err := walkStrings(v.Field(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkStrings(v.Index(i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			for _, key := range v.MapKeys() {
+				if err := walkStrings(v.MapIndex(key), fn); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, key := range v.MapKeys() {
+			resolved, err := fn(v.MapIndex(key).String())
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(key, reflect.ValueOf(resolved).Convert(v.Type().Elem()))
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		resolved, err := fn(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(resolved)
+	}
+	return nil
+}
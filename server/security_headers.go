@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// The sources of the default Content-Security-Policy besides the own host:
+// the CDNs of the layout and the avatars of GitHub and Gravatar. Hogan.js
+// compiles its templates with new Function(), which needs 'unsafe-eval', and
+// some pages set inline styles.
+var (
+	cspScriptSources = []string{"'self'", "'unsafe-eval'", "ajax.googleapis.com", "cdnjs.cloudflare.com", "maxcdn.bootstrapcdn.com"}
+	cspStyleSources  = []string{"'self'", "'unsafe-inline'", "maxcdn.bootstrapcdn.com"}
+	cspFontSources   = []string{"'self'", "maxcdn.bootstrapcdn.com"}
+	cspImageSources  = []string{"'self'", "data:", "avatars.githubusercontent.com", "secure.gravatar.com", "www.gravatar.com"}
+)
+
+// ContentSecurityPolicyHeader returns the content_security_policy, or the
+// default policy which allows the avatars of the configured GitHub
+// Enterprise, GitLab and Gitea too.
+func (c *Configuration) ContentSecurityPolicyHeader() string {
+	if c.ContentSecurityPolicy != "" {
+		return c.ContentSecurityPolicy
+	}
+
+	images := append([]string{}, cspImageSources...)
+	for _, u := range []string{c.GitHubURL, c.GitLabURL, c.GiteaURL} {
+		if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+			images = append(images, parsed.Host)
+		}
+	}
+
+	directives := []string{
+		"default-src 'self'",
+		"script-src " + strings.Join(cspScriptSources, " "),
+		"style-src " + strings.Join(cspStyleSources, " "),
+		"font-src " + strings.Join(cspFontSources, " "),
+		"img-src " + strings.Join(images, " "),
+		"connect-src 'self'",
+		"frame-ancestors 'none'",
+		"base-uri 'self'",
+		"object-src 'none'",
+	}
+	return strings.Join(directives, "; ")
+}
+
+// securityHeaders sets the Content-Security-Policy, X-Frame-Options and
+// Referrer-Policy of HTML responses, so injected scripts can't run and pages
+// can't be framed, and forbids browsers to guess the content type of any
+// response. Whether a response is HTML is decided by its content type when
+// the header is written.
+func securityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		h.ServeHTTP(&securityHeadersWriter{ResponseWriter: w}, r)
+	})
+}
+
+type securityHeadersWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (sw *securityHeadersWriter) WriteHeader(status int) {
+	if sw.wroteHeader {
+		return
+	}
+	sw.wroteHeader = true
+
+	header := sw.Header()
+	if strings.HasPrefix(header.Get("Content-Type"), "text/html") {
+		header.Set("Content-Security-Policy", getConfig().ContentSecurityPolicyHeader())
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "same-origin")
+	}
+
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *securityHeadersWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		if sw.Header().Get("Content-Type") == "" {
+			sw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		sw.WriteHeader(http.StatusOK)
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *securityHeadersWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *securityHeadersWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer can't be hijacked")
+	}
+	sw.wroteHeader = true
+	return hijacker.Hijack()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	setConfig(&Configuration{})
+	defer func() { setConfig(&Configuration{}) }()
+
+	tests := []struct {
+		contentType string
+		body        string
+		expectedCSP bool
+	}{
+		{"text/html; charset=utf-8", "<p>Hello</p>", true},
+		// Detected when it's written
+		{"", "<!DOCTYPE html><html></html>", true},
+		{"application/json", `{"id": 1}`, false},
+		{"text/plain; charset=utf-8", "not found", false},
+	}
+
+	for _, tt := range tests {
+		h := securityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.contentType != "" {
+				w.Header().Set("Content-Type", tt.contentType)
+			}
+			io.WriteString(w, tt.body)
+		}))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+		if rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("%q: X-Content-Type-Options not set", tt.body)
+		}
+		csp := rec.Header().Get("Content-Security-Policy")
+		if (csp != "") != tt.expectedCSP {
+			t.Errorf("%q: wrong Content-Security-Policy. want set=%t, got=%q", tt.body, tt.expectedCSP, csp)
+		}
+		if tt.expectedCSP && (rec.Header().Get("X-Frame-Options") != "DENY" || rec.Header().Get("Referrer-Policy") != "same-origin") {
+			t.Errorf("%q: X-Frame-Options or Referrer-Policy not set. got=%v", tt.body, rec.Header())
+		}
+	}
+}
+
+func TestContentSecurityPolicyHeader(t *testing.T) {
+	c := &Configuration{GitHubURL: "https://github.shipping-company.com"}
+	csp := c.ContentSecurityPolicyHeader()
+	if !strings.Contains(csp, "img-src 'self' data: avatars.githubusercontent.com") || !strings.Contains(csp, "github.shipping-company.com") {
+		t.Errorf("avatars of GitHub not allowed. got=%q", csp)
+	}
+	if !strings.Contains(csp, "frame-ancestors 'none'") {
+		t.Errorf("framing not forbidden. got=%q", csp)
+	}
+
+	c.ContentSecurityPolicy = "default-src 'self'; img-src *"
+	if csp := c.ContentSecurityPolicyHeader(); csp != c.ContentSecurityPolicy {
+		t.Errorf("content_security_policy not used. got=%q", csp)
+	}
+}
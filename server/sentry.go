@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// The number of events waiting to be sent before new ones are dropped, so
+// errors in a loop don't pile up while Sentry is slow.
+const sentryQueueSize = 100
+
+// How long to wait for the events to be sent before exiting.
+const sentryFlushTimeout = 5 * time.Second
+
+// The attributes of log records that become tags in Sentry, so the errors
+// can be searched by them. All others are sent as extra data.
+var sentryTags = map[string]bool{
+	"request_id":             true,
+	"method":                 true,
+	"deployment.id":          true,
+	"deployment.application": true,
+	"deployment.target":      true,
+	"deployment.commit_sha":  true,
+	"deployment.request_id":  true,
+	"notifier":               true,
+	"listener":               true,
+	"host":                   true,
+}
+
+// sentryClient reports the errors of the server if sentry_dsn is set.
+var sentryClient *SentryClient
+
+// SentryClient reports errors of the server to Sentry, in the background.
+type SentryClient struct {
+	endpoint    string
+	auth        string
+	environment string
+	release     string
+	serverName  string
+
+	client  *http.Client
+	events  chan *sentryEvent
+	pending sync.WaitGroup
+}
+
+// NewSentryClient returns the client for the project of the DSN, e.g.
+// https://public@o1.ingest.sentry.io/2. It's not sending until Start is
+// called.
+func NewSentryClient(dsn, environment, release string) (*SentryClient, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, errors.New("the public key is missing")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	if i == -1 || u.Path[i+1:] == "" {
+		return nil, errors.New("the project ID is missing")
+	}
+
+	serverName, _ := os.Hostname()
+
+	return &SentryClient{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/envelope/", u.Scheme, u.Host, u.Path[:i], u.Path[i+1:]),
+		auth: fmt.Sprintf("Sentry sentry_version=7, sentry_client=applikatoni/%s, sentry_key=%s",
+			release, u.User.Username()),
+		environment: environment,
+		release:     release,
+		serverName:  serverName,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		events:      make(chan *sentryEvent, sentryQueueSize),
+	}, nil
+}
+
+// Start sends the captured events until the process exits.
+func (c *SentryClient) Start() {
+	go func() {
+		for ev := range c.events {
+			if err := c.send(ev); err != nil {
+				// Only a warning, an error would be reported again
+				slog.Warn("reporting to Sentry failed", "event_id", ev.EventId, "err", err)
+			}
+			c.pending.Done()
+		}
+	}()
+}
+
+// Capture queues the event, or drops it if too many events are waiting.
+func (c *SentryClient) Capture(ev *sentryEvent) {
+	ev.Environment = c.environment
+	ev.Release = c.release
+	ev.ServerName = c.serverName
+
+	c.pending.Add(1)
+	select {
+	case c.events <- ev:
+	default:
+		c.pending.Done()
+		slog.Warn("too many events waiting for Sentry, dropping event", "event_id", ev.EventId)
+	}
+}
+
+// Flush waits up to timeout for the captured events to be sent, e.g. before
+// exiting.
+func (c *SentryClient) Flush(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+// send posts the event as an envelope, see
+// https://develop.sentry.dev/sdk/envelopes/
+func (c *SentryClient) send(ev *sentryEvent) error {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "{\"event_id\":%q,\"sent_at\":%q}\n", ev.EventId, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&body, "{\"type\":\"event\",\"length\":%d}\n", len(payload))
+	body.Write(payload)
+	body.WriteString("\n")
+
+	req, err := http.NewRequest("POST", c.endpoint, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-sentry-envelope")
+	req.Header.Set("X-Sentry-Auth", c.auth)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Sentry answered with %d", resp.StatusCode)
+	}
+	return nil
+}
+
+type sentryEvent struct {
+	EventId     string                 `json:"event_id"`
+	Timestamp   time.Time              `json:"timestamp"`
+	Platform    string                 `json:"platform"`
+	Level       string                 `json:"level"`
+	Logger      string                 `json:"logger"`
+	ServerName  string                 `json:"server_name,omitempty"`
+	Release     string                 `json:"release,omitempty"`
+	Environment string                 `json:"environment,omitempty"`
+	Message     sentryMessage          `json:"message"`
+	Exception   sentryExceptions       `json:"exception"`
+	Tags        map[string]string      `json:"tags,omitempty"`
+	Extra       map[string]interface{} `json:"extra,omitempty"`
+}
+
+type sentryMessage struct {
+	Formatted string `json:"formatted"`
+}
+
+type sentryExceptions struct {
+	Values []sentryException `json:"values"`
+}
+
+type sentryException struct {
+	Type       string           `json:"type"`
+	Value      string           `json:"value"`
+	Stacktrace sentryStacktrace `json:"stacktrace"`
+}
+
+type sentryStacktrace struct {
+	Frames []sentryFrame `json:"frames"`
+}
+
+type sentryFrame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// newSentryEvent builds the event of an error log record. The exception is
+// the err or panic attribute of the record, if it has one.
+func newSentryEvent(r slog.Record, attrs []slog.Attr, stack sentryStacktrace) *sentryEvent {
+	ev := &sentryEvent{
+		EventId:   newSentryEventId(),
+		Timestamp: r.Time.UTC(),
+		Platform:  "go",
+		Level:     "error",
+		Logger:    "applikatoni",
+		Message:   sentryMessage{r.Message},
+		Tags:      make(map[string]string),
+		Extra:     make(map[string]interface{}),
+	}
+	exception := sentryException{Type: r.Message, Value: r.Message, Stacktrace: stack}
+	for _, a := range attrs {
+		switch {
+		case a.Key == "err":
+			if err, ok := a.Value.Any().(error); ok {
+				exception.Type = fmt.Sprintf("%T", err)
+				exception.Value = err.Error()
+				continue
+			}
+		case a.Key == "panic":
+			exception.Type = "panic"
+			exception.Value = a.Value.String()
+			continue
+		case sentryTags[a.Key]:
+			ev.Tags[a.Key] = a.Value.String()
+			continue
+		}
+		ev.Extra[a.Key] = a.Value.String()
+	}
+	ev.Exception.Values = []sentryException{exception}
+
+	return ev
+}
+
+func newSentryEventId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// callerStacktrace returns the stack of the goroutine logging the error, in
+// a deferred function also the frames of the panic. The frames of the
+// handler, slog and the runtime are left out.
+func callerStacktrace() sentryStacktrace {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []sentryFrame
+	for {
+		f, more := frames.Next()
+		// The frames up to slog belong to the handler
+		if strings.HasPrefix(f.Function, "log/slog.") {
+			stack = stack[:0]
+		} else if !strings.HasPrefix(f.Function, "runtime.") {
+			stack = append(stack, sentryFrame{
+				Function: f.Function,
+				Module:   funcPackage(f.Function),
+				AbsPath:  f.File,
+				Lineno:   f.Line,
+				InApp:    strings.HasPrefix(f.Function, "main.") || strings.HasPrefix(f.Function, "github.com/applikatoni/"),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+
+	// Sentry expects the innermost frame last
+	for i, j := 0, len(stack)-1; i < j; i, j = i+1, j-1 {
+		stack[i], stack[j] = stack[j], stack[i]
+	}
+	return sentryStacktrace{stack}
+}
+
+// funcPackage returns the package of a function name like
+// github.com/applikatoni/applikatoni/deploy.(*Worker).Run.
+func funcPackage(function string) string {
+	slash := strings.LastIndex(function, "/")
+	if dot := strings.Index(function[slash+1:], "."); dot != -1 {
+		return function[:slash+1+dot]
+	}
+	return function
+}
+
+// sentryHandler reports the records of level error to Sentry, with their
+// attributes, e.g. the deployment, as tags and extra data. The records are
+// passed on to the wrapped handler.
+type sentryHandler struct {
+	slog.Handler
+	client *SentryClient
+	attrs  []slog.Attr
+	group  string
+}
+
+func newSentryHandler(h slog.Handler, client *SentryClient) *sentryHandler {
+	return &sentryHandler{Handler: h, client: client}
+}
+
+func (h *sentryHandler) Handle(ctx context.Context, r slog.Record) error {
+	err := h.Handler.Handle(ctx, r)
+	if r.Level < slog.LevelError {
+		return err
+	}
+
+	attrs := append([]slog.Attr{}, h.attrs...)
+	deploymentOutput := false
+	r.Attrs(func(a slog.Attr) bool {
+		// The log entries of deployments, e.g. DEPLOYMENT_FAIL, are the
+		// output of their scripts, not errors of the server
+		if a.Key == "entry_type" {
+			deploymentOutput = true
+		}
+		attrs = appendFlatAttrs(attrs, h.group, a)
+		return true
+	})
+	if !deploymentOutput {
+		h.client.Capture(newSentryEvent(r, attrs, callerStacktrace()))
+	}
+
+	return err
+}
+
+func (h *sentryHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	flat := append([]slog.Attr{}, h.attrs...)
+	for _, a := range attrs {
+		flat = appendFlatAttrs(flat, h.group, a)
+	}
+	return &sentryHandler{h.Handler.WithAttrs(attrs), h.client, flat, h.group}
+}
+
+func (h *sentryHandler) WithGroup(name string) slog.Handler {
+	return &sentryHandler{h.Handler.WithGroup(name), h.client, h.attrs, h.group + name + "."}
+}
+
+// appendFlatAttrs appends the attribute with the keys of groups joined with
+// dots, e.g. deployment.id.
+func appendFlatAttrs(attrs []slog.Attr, prefix string, a slog.Attr) []slog.Attr {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() != slog.KindGroup {
+		a.Key = prefix + a.Key
+		return append(attrs, a)
+	}
+	for _, ga := range a.Value.Group() {
+		attrs = appendFlatAttrs(attrs, prefix+a.Key+".", ga)
+	}
+	return attrs
+}
+
+// recoverPanics answers requests whose handler panicked with 500 and logs
+// the panic as an error, which reports it to Sentry if it's configured.
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			// Aborted on purpose, e.g. by httputil.ReverseProxy
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			requestLogger(r).Error("panic while handling request", "panic", p)
+			msg := http.StatusText(http.StatusInternalServerError) + "\n" + fmtRequestId(r)
+			http.Error(w, msg, http.StatusInternalServerError)
+		}()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// recoverListenerPanic logs a panic of a listener, e.g. a notifier, as an
+// error instead of crashing the server with all running deployments. Call it
+// deferred.
+func recoverListenerPanic(name string, d *models.Deployment) {
+	if p := recover(); p != nil {
+		deploymentLogger(d).Error("panic in listener", "listener", name, "panic", p)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestNewSentryClient(t *testing.T) {
+	tests := []struct {
+		dsn      string
+		endpoint string
+		err      string
+	}{
+		{"https://public@o1.ingest.sentry.io/2", "https://o1.ingest.sentry.io/api/2/envelope/", ""},
+		{"https://public@sentry.shipping-company.com/sentry/5", "https://sentry.shipping-company.com/sentry/api/5/envelope/", ""},
+		{"https://o1.ingest.sentry.io/2", "", "the public key is missing"},
+		{"https://public@o1.ingest.sentry.io/", "", "the project ID is missing"},
+	}
+
+	for _, tt := range tests {
+		c, err := NewSentryClient(tt.dsn, "production", VERSION)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("wrong error for %s. want=%q, got=%v", tt.dsn, tt.err, err)
+			}
+			continue
+		}
+		checkErr(t, err)
+		if c.endpoint != tt.endpoint {
+			t.Errorf("wrong endpoint for %s. want=%s, got=%s", tt.dsn, tt.endpoint, c.endpoint)
+		}
+		if !strings.Contains(c.auth, "sentry_key=public") {
+			t.Errorf("wrong auth for %s. got=%s", tt.dsn, c.auth)
+		}
+	}
+}
+
+// startSentry returns a client sending to a test server, which passes the
+// events it receives to the returned channel.
+func startSentry(t *testing.T) (*SentryClient, <-chan *sentryEvent, func()) {
+	received := make(chan *sentryEvent, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("X-Sentry-Auth"), "sentry_key=public") {
+			t.Errorf("wrong X-Sentry-Auth header. got=%s", r.Header.Get("X-Sentry-Auth"))
+		}
+
+		// The envelope header, the item header and the event
+		scanner := bufio.NewScanner(r.Body)
+		var lines []string
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		if len(lines) != 3 {
+			t.Errorf("wrong number of envelope lines. want=3, got=%d", len(lines))
+			return
+		}
+		ev := &sentryEvent{}
+		checkErr(t, json.Unmarshal([]byte(lines[2]), ev))
+		received <- ev
+	}))
+
+	c, err := NewSentryClient(strings.Replace(ts.URL, "://", "://public@", 1)+"/1", "test", VERSION)
+	checkErr(t, err)
+	c.Start()
+
+	return c, received, ts.Close
+}
+
+func TestSentryHandler(t *testing.T) {
+	client, received, stop := startSentry(t)
+	defer stop()
+
+	var out bytes.Buffer
+	h, err := newLogHandler(&out, "info", logFormatJSON)
+	checkErr(t, err)
+	logger := slog.New(newSentryHandler(h, client))
+
+	d := &models.Deployment{Id: 42, ApplicationName: "web", TargetName: "production", CommitSha: "f00b4r"}
+	logger.Info("notified Slack", "deployment", d)
+	logger.Error("DEPLOYMENT_FAIL", "deployment", d, "entry_type", "DEPLOYMENT_FAIL")
+	logger.With("deployment", d).Error("notifying Slack failed", "notifier", "slack", "status", 500, "err", errors.New("boom"))
+	client.Flush(time.Second)
+
+	if lines := strings.Count(out.String(), "\n"); lines != 3 {
+		t.Errorf("records not passed on. want=3 lines, got=%d", lines)
+	}
+
+	var ev *sentryEvent
+	select {
+	case ev = <-received:
+	default:
+		t.Fatalf("no event reported")
+	}
+	select {
+	case other := <-received:
+		t.Errorf("unexpected event reported: %s", other.Message.Formatted)
+	default:
+	}
+
+	if ev.Message.Formatted != "notifying Slack failed" || ev.Environment != "test" {
+		t.Errorf("wrong message or environment. got=%s %s", ev.Message.Formatted, ev.Environment)
+	}
+	expectedTags := map[string]string{
+		"deployment.id":          "42",
+		"deployment.application": "web",
+		"deployment.target":      "production",
+		"deployment.commit_sha":  "f00b4r",
+		"notifier":               "slack",
+	}
+	for key, value := range expectedTags {
+		if ev.Tags[key] != value {
+			t.Errorf("wrong tag %s. want=%s, got=%s", key, value, ev.Tags[key])
+		}
+	}
+	if ev.Extra["status"] != "500" {
+		t.Errorf("wrong extra status. want=500, got=%v", ev.Extra["status"])
+	}
+
+	exception := ev.Exception.Values[0]
+	if exception.Type != "*errors.errorString" || exception.Value != "boom" {
+		t.Errorf("wrong exception. got=%s %s", exception.Type, exception.Value)
+	}
+	frames := exception.Stacktrace.Frames
+	if len(frames) == 0 || !strings.HasSuffix(frames[len(frames)-1].Function, ".TestSentryHandler") {
+		t.Errorf("wrong innermost frame. got=%+v", frames)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	out, restore := captureLogs(t, "info")
+	defer restore()
+
+	h := recoverPanics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var d *models.Deployment
+		w.Write([]byte(d.CommitSha))
+	}))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/web/deployments/1", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("wrong status. want=%d, got=%d", http.StatusInternalServerError, rec.Code)
+	}
+	line := decodeLogLine(t, out)
+	if line["level"] != "ERROR" || !strings.Contains(line["panic"].(string), "nil pointer dereference") {
+		t.Errorf("panic not logged as error. got=%s", out.String())
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const SERVICE_ACCOUNT_PROVIDER = "service_account"
+
+func findServiceAccount(name string) *models.ServiceAccount {
+	for _, s := range getConfig().ServiceAccounts {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// loadServiceAccount attaches the configuration of the service account to the
+// user. It returns false if the user is a service account that has been
+// removed from the configuration.
+func loadServiceAccount(u *models.User) bool {
+	if u.Provider != SERVICE_ACCOUNT_PROVIDER {
+		return true
+	}
+
+	u.ServiceAccount = findServiceAccount(u.ProviderId)
+	return u.ServiceAccount != nil
+}
+
+// syncServiceAccounts creates a user for every configured service account and
+// sets its API token. The tokens of service accounts that have been removed
+// from the configuration are revoked.
+func syncServiceAccounts(db *sql.DB, accounts []*models.ServiceAccount) error {
+	configured := make(map[string]bool)
+
+	for _, s := range accounts {
+		configured[s.Name] = true
+
+		u, err := getUserByProvider(db, SERVICE_ACCOUNT_PROVIDER, s.Name)
+		if err == sql.ErrNoRows {
+			u = &models.User{
+				Name:       s.Name,
+				Provider:   SERVICE_ACCOUNT_PROVIDER,
+				ProviderId: s.Name,
+			}
+			err = createUser(db, u)
+		}
+		if err != nil {
+			return err
+		}
+
+		if u.ApiToken != s.ApiToken {
+			err = setApiToken(db, u, s.ApiToken)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	users, err := getUsersByProvider(db, SERVICE_ACCOUNT_PROVIDER)
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if !configured[u.ProviderId] && u.ApiToken != "" {
+			err = revokeApiToken(db, u)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateServiceAccounts(c *Configuration) error {
+	names := make(map[string]bool)
+
+	for _, s := range c.ServiceAccounts {
+		if s.Name == "" {
+			return errors.New("service account without name")
+		}
+		if names[s.Name] {
+			return fmt.Errorf("service account %s is configured twice", s.Name)
+		}
+		names[s.Name] = true
+
+		if s.ApiToken == "" {
+			return fmt.Errorf("service account %s has no api_token", s.Name)
+		}
+
+		for appName, targetNames := range s.Targets {
+			var application *models.Application
+			for _, a := range c.Applications {
+				if a.Name == appName {
+					application = a
+				}
+			}
+			if application == nil {
+				return fmt.Errorf("unknown application %s for service account %s", appName, s.Name)
+			}
+
+			for _, targetName := range targetNames {
+				if _, err := findTarget(application, targetName); err != nil {
+					return fmt.Errorf("unknown target %s of %s for service account %s", targetName, appName, s.Name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
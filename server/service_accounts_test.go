@@ -0,0 +1,100 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestSyncServiceAccounts(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	ci := &models.ServiceAccount{
+		Name:     "ci",
+		ApiToken: "c1-t0k3n",
+		Targets:  map[string][]string{"web": []string{"staging"}},
+	}
+
+	err := syncServiceAccounts(db, []*models.ServiceAccount{ci})
+	checkErr(t, err)
+
+	user, err := getUserByApiToken(db, "c1-t0k3n")
+	if err != nil {
+		t.Fatalf("service account user not found: %s", err)
+	}
+	if user.Name != "ci" || user.Provider != SERVICE_ACCOUNT_PROVIDER {
+		t.Errorf("wrong user. name=%s, provider=%s", user.Name, user.Provider)
+	}
+
+	// Changing the token in the configuration replaces the old one
+	ci.ApiToken = "n3w-t0k3n"
+	err = syncServiceAccounts(db, []*models.ServiceAccount{ci})
+	checkErr(t, err)
+
+	updated, err := getUserByApiToken(db, "n3w-t0k3n")
+	if err != nil {
+		t.Fatalf("service account user not found: %s", err)
+	}
+	if updated.Id != user.Id {
+		t.Errorf("service account user was created twice. want=%d, got=%d", user.Id, updated.Id)
+	}
+
+	// Removing the service account revokes its token
+	err = syncServiceAccounts(db, []*models.ServiceAccount{})
+	checkErr(t, err)
+
+	_, err = getUserByApiToken(db, "n3w-t0k3n")
+	if err == nil {
+		t.Errorf("expected token of removed service account to be revoked")
+	}
+}
+
+func TestLoadServiceAccount(t *testing.T) {
+	ci := &models.ServiceAccount{Name: "ci", ApiToken: "c1-t0k3n"}
+	setConfig(&Configuration{ServiceAccounts: []*models.ServiceAccount{ci}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	user := &models.User{Name: "ci", Provider: SERVICE_ACCOUNT_PROVIDER, ProviderId: "ci"}
+	if !loadServiceAccount(user) || user.ServiceAccount != ci {
+		t.Errorf("service account not loaded. got=%v", user.ServiceAccount)
+	}
+
+	removed := &models.User{Name: "deploybot", Provider: SERVICE_ACCOUNT_PROVIDER, ProviderId: "deploybot"}
+	if loadServiceAccount(removed) {
+		t.Errorf("expected removed service account to be rejected")
+	}
+
+	human := &models.User{Name: "ci", Provider: GITHUB_PROVIDER, ProviderId: "ci"}
+	if !loadServiceAccount(human) || human.ServiceAccount != nil {
+		t.Errorf("GitHub user was treated as service account")
+	}
+}
+
+func TestValidateServiceAccounts(t *testing.T) {
+	applications := []*models.Application{
+		{Name: "web", Targets: []*models.Target{{Name: "staging"}}},
+	}
+
+	tests := []struct {
+		account *models.ServiceAccount
+		valid   bool
+	}{
+		{&models.ServiceAccount{Name: "ci", ApiToken: "t", Targets: map[string][]string{"web": {"staging"}}}, true},
+		{&models.ServiceAccount{Name: "ci", Targets: map[string][]string{"web": {"staging"}}}, false},
+		{&models.ServiceAccount{ApiToken: "t"}, false},
+		{&models.ServiceAccount{Name: "ci", ApiToken: "t", Targets: map[string][]string{"api": {"staging"}}}, false},
+		{&models.ServiceAccount{Name: "ci", ApiToken: "t", Targets: map[string][]string{"web": {"production"}}}, false},
+	}
+
+	for _, tt := range tests {
+		c := &Configuration{
+			Applications:    applications,
+			ServiceAccounts: []*models.ServiceAccount{tt.account},
+		}
+		err := validateServiceAccounts(c)
+		if (err == nil) != tt.valid {
+			t.Errorf("wrong result for %+v. want valid=%t, got err=%v", tt.account, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// sessionKeyPairs returns the keys of the session cookies for the secrets,
+// the current one first. Every secret gets a key to authenticate and an
+// AES-256 key to encrypt the cookies, both derived from it, so the secret can
+// be any random string. New cookies are encrypted with the first secret, the
+// others are only used to read the cookies created with them, so a secret
+// can be rotated without logging out everyone.
+//
+// The cookies created before they were encrypted are only signed with the
+// secret itself. They are still read, and encrypted once they're saved again.
+func sessionKeyPairs(secrets []string) [][]byte {
+	pairs := [][]byte{}
+	for _, s := range secrets {
+		pairs = append(pairs, deriveSessionKey(s, "authentication"), deriveSessionKey(s, "encryption"))
+	}
+	for _, s := range secrets {
+		pairs = append(pairs, []byte(s), nil)
+	}
+	return pairs
+}
+
+func deriveSessionKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("applikatoni session " + purpose))
+	return mac.Sum(nil)
+}
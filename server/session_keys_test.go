@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+)
+
+func TestSessionKeyPairsRotation(t *testing.T) {
+	saveSession := func(store *sessions.CookieStore) *http.Cookie {
+		req := httptest.NewRequest("GET", "/", nil)
+		rec := httptest.NewRecorder()
+		session, _ := store.Get(req, sessionName)
+		session.Values["user_id"] = 42
+		checkErr(t, session.Save(req, rec))
+		return rec.Result().Cookies()[0]
+	}
+	loadUserId := func(store *sessions.CookieStore, cookie *http.Cookie) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		session, _ := store.Get(req, sessionName)
+		id, _ := session.Values["user_id"].(int)
+		return id
+	}
+
+	old := sessions.NewCookieStore(sessionKeyPairs([]string{"old-secret"})...)
+	rotated := sessions.NewCookieStore(sessionKeyPairs([]string{"new-secret", "old-secret"})...)
+	removed := sessions.NewCookieStore(sessionKeyPairs([]string{"new-secret"})...)
+	// The store before the cookies were encrypted
+	signed := sessions.NewCookieStore([]byte("old-secret"))
+
+	oldCookie := saveSession(old)
+	if id := loadUserId(rotated, oldCookie); id != 42 {
+		t.Errorf("cookie of the old secret not read after rotating. got user_id=%d", id)
+	}
+	if id := loadUserId(removed, oldCookie); id != 0 {
+		t.Errorf("cookie of a removed secret read. got user_id=%d", id)
+	}
+	if id := loadUserId(signed, oldCookie); id != 0 {
+		t.Errorf("encrypted cookie read as a signed one. got user_id=%d", id)
+	}
+
+	if id := loadUserId(rotated, saveSession(signed)); id != 42 {
+		t.Errorf("signed cookie not read. got user_id=%d", id)
+	}
+
+	newCookie := saveSession(rotated)
+	if id := loadUserId(removed, newCookie); id != 42 {
+		t.Errorf("new cookie not created with the new secret. got user_id=%d", id)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultShutdownTimeout = 30 * time.Minute
+
+// The time the HTTP server gets to finish the requests in flight once the
+// deployments have been drained.
+const httpShutdownTimeout = 10 * time.Second
+
+var errShuttingDown = errors.New("Applikatoni is shutting down, try again once it's back")
+
+// deploymentDrain tracks the running deployments. It's ready to use without
+// main, so deployments can be started in tests.
+var deploymentDrain = &DeploymentDrain{}
+
+// DeploymentDrain tracks the running deployments, so the server can wait for
+// them to finish before exiting. Once it's draining, no new deployments can
+// be started.
+type DeploymentDrain struct {
+	mu       sync.Mutex
+	draining bool
+	running  sync.WaitGroup
+}
+
+// Start registers a deployment that is about to run. It returns
+// errShuttingDown if the server is draining.
+func (d *DeploymentDrain) Start() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return errShuttingDown
+	}
+	d.running.Add(1)
+	return nil
+}
+
+// Done unregisters a deployment registered with Start once it has finished.
+func (d *DeploymentDrain) Done() {
+	d.running.Done()
+}
+
+// Draining returns whether new deployments are refused.
+func (d *DeploymentDrain) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.draining
+}
+
+// Drain refuses new deployments and waits up to timeout for the running ones
+// to finish. Returns false if they didn't finish in time.
+func (d *DeploymentDrain) Drain(timeout time.Duration) bool {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// startDeploymentErrorStatus returns the status to answer with if a
+// deployment could not be started.
+func startDeploymentErrorStatus(err error) int {
+	if err == errShuttingDown {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// shutdownOnSignal waits for SIGTERM or SIGINT, then waits for the running
+// deployments to finish, stops the HTTP server and saves the remaining log
+// entries. Deployments still running after the shutdown_timeout are cut off
+// and marked as failed on the next start, as are the queued ones.
+func shutdownOnSignal(server *http.Server, timeout time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	// A second signal exits right away
+	signal.Reset(syscall.SIGTERM, syscall.SIGINT)
+
+	slog.Info("shutting down, waiting for the running deployments", "signal", sig.String(), "running", killRegistry.Len(), "timeout", timeout)
+	notifySystemd(fmt.Sprintf("STOPPING=1\nSTATUS=Waiting for %d running deployments", killRegistry.Len()))
+	if !deploymentDrain.Drain(timeout) {
+		slog.Warn("deployments still running after the shutdown timeout, exiting anyway", "running", killRegistry.Len())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("could not finish the requests in flight", "err", err)
+	}
+
+	if err := logRouter.Close(httpShutdownTimeout); err != nil {
+		slog.Warn("could not save all log entries", "err", err)
+	}
+
+	if sentryClient != nil {
+		sentryClient.Flush(sentryFlushTimeout)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestDeploymentDrain(t *testing.T) {
+	d := &DeploymentDrain{}
+
+	checkErr(t, d.Start())
+	finished := make(chan struct{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(finished)
+		d.Done()
+	}()
+
+	if !d.Drain(time.Second) {
+		t.Fatalf("Drain timed out with the deployment finished")
+	}
+	select {
+	case <-finished:
+	default:
+		t.Errorf("Drain returned before the deployment finished")
+	}
+
+	if err := d.Start(); err != errShuttingDown {
+		t.Errorf("wrong error while draining. want=%v, got=%v", errShuttingDown, err)
+	}
+}
+
+func TestDeploymentDrainTimeout(t *testing.T) {
+	d := &DeploymentDrain{}
+
+	checkErr(t, d.Start())
+	defer d.Done()
+
+	if d.Drain(10 * time.Millisecond) {
+		t.Errorf("Drain didn't time out with a deployment running")
+	}
+	if !d.Draining() {
+		t.Errorf("not draining after Drain")
+	}
+}
+
+func TestStartDeploymentShuttingDown(t *testing.T) {
+	deploymentDrain = &DeploymentDrain{}
+	defer func() { deploymentDrain = &DeploymentDrain{} }()
+	deploymentDrain.Drain(0)
+
+	application := &models.Application{Name: "web"}
+	target := &models.Target{Name: "production"}
+	err := startDeployment(application, target, &models.Deployment{}, nil)
+	if err != errShuttingDown {
+		t.Errorf("wrong error. want=%v, got=%v", errShuttingDown, err)
+	}
+	if status := startDeploymentErrorStatus(err); status != 503 {
+		t.Errorf("wrong status. want=%d, got=%d", 503, status)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	slackApproveAction = "approve"
+	slackRejectAction  = "reject"
+)
+
+// slackBlock is a block of a Slack message, see
+// https://api.slack.com/reference/block-kit/blocks
+type slackBlock struct {
+	Type     string          `json:"type"`
+	BlockId  string          `json:"block_id,omitempty"`
+	Text     *slackText      `json:"text,omitempty"`
+	Elements []*slackElement `json:"elements,omitempty"`
+}
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackElement struct {
+	Type     string       `json:"type"`
+	Text     *slackText   `json:"text"`
+	ActionId string       `json:"action_id"`
+	Value    string       `json:"value"`
+	Style    string       `json:"style,omitempty"`
+	Confirm  *slackDialog `json:"confirm,omitempty"`
+}
+
+type slackDialog struct {
+	Title   *slackText `json:"title"`
+	Text    *slackText `json:"text"`
+	Confirm *slackText `json:"confirm"`
+	Deny    *slackText `json:"deny"`
+}
+
+// slackApprovalMsg is posted to the approval_slack_url. Text is shown in
+// notifications, the blocks in the channel.
+type slackApprovalMsg struct {
+	Text   string        `json:"text"`
+	Blocks []*slackBlock `json:"blocks"`
+}
+
+// slackInteraction is the payload Slack sends when a button is clicked.
+type slackInteraction struct {
+	Type        string                    `json:"type"`
+	User        slackInteractionUser      `json:"user"`
+	ResponseURL string                    `json:"response_url"`
+	Actions     []*slackInteractionAction `json:"actions"`
+}
+
+type slackInteractionUser struct {
+	Id       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type slackInteractionAction struct {
+	ActionId string `json:"action_id"`
+	Value    string `json:"value"`
+}
+
+// slackResponse replaces the message with the buttons or, if it's
+// ephemeral, is only shown to the user who clicked.
+type slackResponse struct {
+	ResponseType    string `json:"response_type,omitempty"`
+	ReplaceOriginal bool   `json:"replace_original"`
+	Text            string `json:"text"`
+}
+
+// postSlackApprovalRequest posts the deployment waiting for approval to the
+// approval_slack_url of its target.
+func postSlackApprovalRequest(application *models.Application, target *models.Target, deployment *models.Deployment, stage models.DeploymentStage) {
+	payload, err := json.Marshal(newSlackApprovalMsg(application, target, deployment, stage))
+	if err != nil {
+		deploymentLogger(deployment).Error("error creating Slack approval request", "err", err)
+		return
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	d := newDelivery("slack", deployment.Id, "POST", target.ApprovalSlackUrl, header, payload, 200)
+	if err := deliver(d); err != nil {
+		deploymentLogger(deployment).Error("posting approval request to Slack failed", "err", err)
+		metrics.NotifierFailed("slack")
+		return
+	}
+
+	deploymentLogger(deployment).Info("posted approval request to Slack", "stage", stage)
+}
+
+func newSlackApprovalMsg(a *models.Application, t *models.Target, d *models.Deployment, stage models.DeploymentStage) *slackApprovalMsg {
+	waitingFor := fmt.Sprintf("waits for approval to continue with %s", stage)
+	if stage == models.SECOND_APPROVAL_STAGE {
+		waitingFor = "waits for the approval of a second user"
+	}
+	ref := d.Branch
+	if d.Tag != "" {
+		ref = d.Tag
+	}
+	summary := fmt.Sprintf("%s's deployment of %s %s to %s %s", d.User.DisplayName(), a.Name, ref, t.Name, waitingFor)
+
+	text := fmt.Sprintf("*%s*\n> %s\n<%s|Open deployment in Applikatoni>", summary, d.Comment, getConfig().URL(deploymentUrl(a, d)))
+	value := strconv.Itoa(d.Id)
+
+	return &slackApprovalMsg{
+		Text: summary,
+		Blocks: []*slackBlock{
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: text}},
+			{
+				Type:    "actions",
+				BlockId: "approval",
+				Elements: []*slackElement{
+					{
+						Type:     "button",
+						Text:     &slackText{Type: "plain_text", Text: "Approve"},
+						ActionId: slackApproveAction,
+						Value:    value,
+						Style:    "primary",
+					},
+					{
+						Type:     "button",
+						Text:     &slackText{Type: "plain_text", Text: "Reject"},
+						ActionId: slackRejectAction,
+						Value:    value,
+						Style:    "danger",
+						Confirm: &slackDialog{
+							Title:   &slackText{Type: "plain_text", Text: "Reject the deployment?"},
+							Text:    &slackText{Type: "plain_text", Text: "The deployment fails and isn't retried."},
+							Confirm: &slackText{Type: "plain_text", Text: "Reject"},
+							Deny:    &slackText{Type: "plain_text", Text: "Cancel"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// slackInteractionHandler approves or rejects a deployment when one of the
+// buttons of the approval request is clicked. Like on the deployment page, the
+// user needs to be allowed to deploy to the target. The answer is posted to
+// the response_url of the interaction: the message is replaced by the decision,
+// errors are only shown to the user.
+func slackInteractionHandler(w http.ResponseWriter, r *http.Request) {
+	values, ok := readSlackRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var interaction slackInteraction
+	if err := json.Unmarshal([]byte(values.Get("payload")), &interaction); err != nil {
+		http.Error(w, "invalid payload", http.StatusBadRequest)
+		return
+	}
+	if interaction.Type != "block_actions" || len(interaction.Actions) == 0 {
+		return
+	}
+
+	resp := &slackResponse{ReplaceOriginal: true}
+	text, reqErr := slackDecideApproval(r, interaction.User.Id, interaction.Actions[0])
+	if reqErr != nil {
+		resp = &slackResponse{ResponseType: "ephemeral", Text: reqErr.Message}
+	} else {
+		resp.Text = text
+	}
+
+	if err := postSlackResponse(interaction.ResponseURL, resp); err != nil {
+		requestLogger(r).Warn("answering Slack interaction failed", "err", err)
+	}
+}
+
+func slackDecideApproval(r *http.Request, slackUserId string, action *slackInteractionAction) (string, *requestError) {
+	user, reqErr := slackUser(r, slackUserId)
+	if reqErr != nil {
+		return "", reqErr
+	}
+
+	if action.ActionId != slackApproveAction && action.ActionId != slackRejectAction {
+		return "", &requestError{422, fmt.Sprintf("unknown action %q", action.ActionId)}
+	}
+	reject := action.ActionId == slackRejectAction
+
+	id, err := strconv.Atoi(action.Value)
+	if err != nil {
+		return "", &requestError{422, "invalid deployment id"}
+	}
+	application, deployment, reqErr := decideDeploymentById(r, user, id, reject)
+	if reqErr != nil {
+		return "", reqErr
+	}
+
+	decision := "approved"
+	if reject {
+		decision = "rejected"
+	}
+	text := fmt.Sprintf("%s %s <%s|deployment #%d> of %s to %s.", user.Name, decision,
+		getConfig().URL(deploymentUrl(application, deployment)), deployment.Id, application.Name, deployment.TargetName)
+	return text, nil
+}
+
+func postSlackResponse(url string, resp *slackResponse) error {
+	payload, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	res, err := http.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("slack status code not 200. got=%d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestNewSlackApprovalMsg(t *testing.T) {
+	setConfig(&Configuration{Host: "applikatoni.example.com"})
+	defer func() { setConfig(&Configuration{}) }()
+
+	a := &models.Application{Name: "web"}
+	target := &models.Target{Name: "production"}
+	d := &models.Deployment{Id: 42, Branch: "master", Comment: "Fix the login", User: &models.User{Name: "mrnugget"}}
+
+	msg := newSlackApprovalMsg(a, target, d, "MIGRATE_DATABASE")
+	if msg.Text != "mrnugget's deployment of web master to production waits for approval to continue with MIGRATE_DATABASE" {
+		t.Errorf("wrong text. got=%q", msg.Text)
+	}
+	if len(msg.Blocks) != 2 || !strings.Contains(msg.Blocks[0].Text.Text, "http://applikatoni.example.com/web/deployments/42") {
+		t.Fatalf("link to the deployment missing. got=%+v", msg.Blocks)
+	}
+
+	buttons := msg.Blocks[1].Elements
+	if len(buttons) != 2 || buttons[0].ActionId != slackApproveAction || buttons[1].ActionId != slackRejectAction {
+		t.Fatalf("wrong buttons. got=%+v", buttons)
+	}
+	for _, b := range buttons {
+		if b.Value != "42" {
+			t.Errorf("wrong deployment id of %s. got=%s", b.ActionId, b.Value)
+		}
+	}
+
+	msg = newSlackApprovalMsg(a, target, d, models.SECOND_APPROVAL_STAGE)
+	if !strings.HasSuffix(msg.Text, "waits for the approval of a second user") {
+		t.Errorf("wrong text for the second approval. got=%q", msg.Text)
+	}
+}
+
+func TestSlackInteractionHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{
+		SlackSigningSecret: "s3cr3t",
+		SlackUsers:         map[string]string{"U1": "mrnugget", "U2": "fabrik42"},
+		Applications: []*models.Application{
+			{
+				Name:          "flincOnRails",
+				ReadUsernames: []string{"mrnugget", "fabrik42"},
+				Targets: []*models.Target{
+					{Name: "production", DeployUsernames: []string{"mrnugget"}},
+				},
+			},
+		},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	approvalRegistry = NewApprovalRegistry()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+	checkErr(t, createUser(db, buildUser(2, "fabrik42")))
+
+	deployment := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, deployment))
+	paused := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, paused))
+	approvalRegistry.Add(paused.Id)
+	approvalRegistry.RequireSecondApproval(paused.Id, user.Id)
+	defer approvalRegistry.Remove(paused.Id)
+
+	responses := make(chan slackResponse, 1)
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp slackResponse
+		body, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(body, &resp)
+		responses <- resp
+	}))
+	defer slack.Close()
+
+	tests := []struct {
+		userId          string
+		actionId        string
+		deploymentId    int
+		expectedMessage string
+	}{
+		{"U3", "approve", deployment.Id, "Your Slack user U3 isn't mapped to an Applikatoni user"},
+		{"U1", "deploy", deployment.Id, `unknown action "deploy"`},
+		{"U1", "approve", 9999, "deployment not found"},
+		{"U2", "approve", deployment.Id, "not authorized to approve deployments to this target"},
+		{"U1", "approve", deployment.Id, ErrNotWaitingForApproval.Error()},
+		{"U1", "approve", paused.Id, ErrOwnDeployment.Error()},
+	}
+
+	for _, tt := range tests {
+		payload, _ := json.Marshal(&slackInteraction{
+			Type:        "block_actions",
+			User:        slackInteractionUser{Id: tt.userId},
+			ResponseURL: slack.URL,
+			Actions:     []*slackInteractionAction{{ActionId: tt.actionId, Value: strconv.Itoa(tt.deploymentId)}},
+		})
+		body := url.Values{"payload": {string(payload)}}.Encode()
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+		req := httptest.NewRequest("POST", "/slack/interactions", strings.NewReader(body))
+		req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		req.Header.Set("X-Slack-Signature", signSlackBody("s3cr3t", timestamp, body))
+
+		rec := httptest.NewRecorder()
+		slackInteractionHandler(rec, req)
+
+		if rec.Code != 200 {
+			t.Errorf("wrong status for %s %s. want=200, got=%d", tt.userId, tt.actionId, rec.Code)
+		}
+		resp := <-responses
+		if resp.ResponseType != "ephemeral" || resp.ReplaceOriginal || !strings.Contains(resp.Text, tt.expectedMessage) {
+			t.Errorf("wrong response for %s %s. want=%s, got=%+v", tt.userId, tt.actionId, tt.expectedMessage, resp)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	slackSignatureVersion = "v0"
+	// Older requests are rejected, so a request that has been sniffed can't
+	// be replayed
+	slackRequestMaxAge = 5 * time.Minute
+
+	slackDeployUsage   = "Usage: /deploy APPLICATION TARGET BRANCH [COMMENT]"
+	slackDeployComment = "Deployed from Slack"
+)
+
+// slackCommandResponse is the message Slack shows as the answer to a slash
+// command. Ephemeral messages are only shown to the user who sent it.
+type slackCommandResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+// slackDeployHandler answers the /deploy slash command of Slack, e.g.
+// "/deploy web production master Fix the login". The deployment is started in
+// the name of the Applikatoni user the Slack user is mapped to in
+// slack_users. Slack signs its requests with the slack_signing_secret.
+func slackDeployHandler(w http.ResponseWriter, r *http.Request) {
+	values, ok := readSlackRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Slack only shows answers with a status of 200, so errors are
+	// answered with one too
+	text, reqErr := slackDeploy(r, values)
+	if reqErr != nil {
+		renderSlackResponse(w, "ephemeral", reqErr.Message)
+		return
+	}
+	renderSlackResponse(w, "in_channel", text)
+}
+
+// readSlackRequest reads the form values of a request sent by Slack. Requests
+// without a valid signature are answered with a 403.
+func readSlackRequest(w http.ResponseWriter, r *http.Request) (url.Values, bool) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+	if !isValidSlackSignature(getConfig().SlackSigningSecret, timestamp, r.Header.Get("X-Slack-Signature"), body, time.Now()) {
+		requestLogger(r).Warn("Slack request with invalid signature", "path", r.URL.Path, "remote_addr", r.RemoteAddr)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return nil, false
+	}
+
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return values, true
+}
+
+// slackUser loads the Applikatoni user the Slack user is mapped to in
+// slack_users.
+func slackUser(r *http.Request, slackUserId string) (*models.User, *requestError) {
+	return chatUser(r, "Slack", "slack_users", getConfig().SlackUsers, slackUserId)
+}
+
+// chatUser returns the Applikatoni user the user of a chat is mapped to in
+// the setting, e.g. slack_users.
+func chatUser(r *http.Request, chat, setting string, users map[string]string, chatUserId string) (*models.User, *requestError) {
+	name, ok := users[chatUserId]
+	if !ok {
+		msg := fmt.Sprintf("Your %s user %s isn't mapped to an Applikatoni user. Ask an admin to add it to %s.", chat, chatUserId, setting)
+		return nil, &requestError{http.StatusForbidden, msg}
+	}
+
+	user, err := getUserByName(db, name)
+	if err == sql.ErrNoRows {
+		msg := fmt.Sprintf("User %s not found. Log in to Applikatoni once before using it from %s.", name, chat)
+		return nil, &requestError{http.StatusForbidden, msg}
+	}
+	if err != nil {
+		requestLogger(r).Error("loading chat user failed", "chat", chat, "user", name, "err", err)
+		return nil, &requestError{http.StatusInternalServerError, "could not load your user"}
+	}
+	if user.IsDeactivated() || !isAllowedUser(user) {
+		return nil, &requestError{http.StatusForbidden, fmt.Sprintf("User %s has no access to Applikatoni.", name)}
+	}
+	return user, nil
+}
+
+func slackDeploy(r *http.Request, values url.Values) (string, *requestError) {
+	user, reqErr := slackUser(r, values.Get("user_id"))
+	if reqErr != nil {
+		return "", reqErr
+	}
+
+	args := strings.Fields(values.Get("text"))
+	if len(args) < 3 {
+		return "", &requestError{422, slackDeployUsage}
+	}
+
+	application, err := findApplication(args[0])
+	if err != nil || !application.CanRead(user) {
+		return "", &requestError{http.StatusNotFound, fmt.Sprintf("application %s not found", args[0])}
+	}
+	target, err := findTarget(application, args[1])
+	if err != nil {
+		return "", &requestError{http.StatusNotFound, fmt.Sprintf("target %s not found", args[1])}
+	}
+
+	comment := strings.Join(args[3:], " ")
+	if comment == "" {
+		comment = slackDeployComment
+	}
+	req := &apiDeploymentRequest{Target: target.Name, Branch: args[2], Comment: comment}
+	for _, s := range target.DefaultStages {
+		req.Stages = append(req.Stages, string(s))
+	}
+	r.Form = req.formValues()
+
+	deployment, target, stages, reqErr := newDeploymentFromRequest(r, application, user)
+	if reqErr != nil {
+		return "", reqErr
+	}
+	deployment.Initiator = &models.DeploymentInitiator{
+		TokenName:  "Slack /deploy",
+		SourceIP:   remoteIP(r),
+		OnBehalfOf: "@" + values.Get("user_name"),
+	}
+
+	err = startDeployment(application, target, deployment, stages)
+	if err != nil {
+		if err != errShuttingDown {
+			requestLogger(r).Error("could not start deployment", "err", err)
+		}
+		return "", &requestError{startDeploymentErrorStatus(err), err.Error()}
+	}
+	recordDeploymentAuditEvents(r, user, deployment)
+
+	text := fmt.Sprintf("%s is deploying %s of %s to %s: %s", user.Name, deployment.Branch, application.Name, target.Name,
+		getConfig().URL(deploymentUrl(application, deployment)))
+	return text, nil
+}
+
+func renderSlackResponse(w http.ResponseWriter, responseType, text string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&slackCommandResponse{ResponseType: responseType, Text: text})
+}
+
+// isValidSlackSignature checks the X-Slack-Signature of a request, the HMAC
+// of the version, the X-Slack-Request-Timestamp and the body.
+func isValidSlackSignature(secret, timestamp, signature string, body []byte, now time.Time) bool {
+	prefix := slackSignatureVersion + "="
+	if secret == "" || !strings.HasPrefix(signature, prefix) {
+		return false
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	age := now.Sub(time.Unix(seconds, 0))
+	if age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return false
+	}
+
+	sent, err := hex.DecodeString(strings.TrimPrefix(signature, prefix))
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s:%s:", slackSignatureVersion, timestamp)
+	mac.Write(body)
+
+	return hmac.Equal(sent, mac.Sum(nil))
+}
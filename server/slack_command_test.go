@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func signSlackBody(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestIsValidSlackSignature(t *testing.T) {
+	body := "command=%2Fdeploy&text=web+production+master"
+	now := time.Unix(1531420618, 0)
+	timestamp := "1531420618"
+
+	tests := []struct {
+		secret    string
+		timestamp string
+		signature string
+		expected  bool
+	}{
+		{"s3cr3t", timestamp, signSlackBody("s3cr3t", timestamp, body), true},
+		{"s3cr3t", timestamp, signSlackBody("wrong", timestamp, body), false},
+		{"", timestamp, signSlackBody("", timestamp, body), false},
+		{"s3cr3t", timestamp, strings.TrimPrefix(signSlackBody("s3cr3t", timestamp, body), "v0="), false},
+		{"s3cr3t", timestamp, "v0=nothex", false},
+		// Replayed after more than 5 minutes
+		{"s3cr3t", "1531420000", signSlackBody("s3cr3t", "1531420000", body), false},
+		{"s3cr3t", "yesterday", signSlackBody("s3cr3t", "yesterday", body), false},
+	}
+
+	for i, tt := range tests {
+		if got := isValidSlackSignature(tt.secret, tt.timestamp, tt.signature, []byte(body), now); got != tt.expected {
+			t.Errorf("%d: wrong result. want=%t, got=%t", i, tt.expected, got)
+		}
+	}
+}
+
+func TestSlackDeployHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{
+		SlackSigningSecret: "s3cr3t",
+		SlackUsers:         map[string]string{"U1": "mrnugget", "U2": "fabrik42", "U4": "gitlab:fabrik42"},
+		Applications: []*models.Application{
+			{
+				Name:          "web",
+				ReadUsernames: []string{"mrnugget"},
+				Targets: []*models.Target{
+					{Name: "production", DeployUsernames: []string{"mrnugget"}, DeployableBranches: []string{"master"},
+						DefaultStages: []models.DeploymentStage{"CHECK_CONNECTION"}},
+					{Name: "staging"},
+				},
+			},
+			{Name: "secret"},
+		},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	checkErr(t, createUser(db, buildUser(1, "mrnugget")))
+	// fabrik42 only logged in with GitLab
+	gitLabUser := buildUser(2, "fabrik42")
+	gitLabUser.Provider = GITLAB_PROVIDER
+	checkErr(t, createUser(db, gitLabUser))
+
+	tests := []struct {
+		userId          string
+		text            string
+		secret          string
+		expectedStatus  int
+		expectedMessage string
+	}{
+		{"U1", "web production master", "wrong", 403, "invalid signature"},
+		{"U3", "web production master", "s3cr3t", 200, "Your Slack user U3 isn't mapped to an Applikatoni user"},
+		{"U2", "web production master", "s3cr3t", 200, "User fabrik42 not found"},
+		{"U4", "web production master", "s3cr3t", 200, "application web not found"},
+		{"U1", "web production", "s3cr3t", 200, slackDeployUsage},
+		{"U1", "api production master", "s3cr3t", 200, "application api not found"},
+		{"U1", "secret production master", "s3cr3t", 200, "application secret not found"},
+		{"U1", "web qa master", "s3cr3t", 200, "target qa not found"},
+		{"U1", "web staging master", "s3cr3t", 200, "not authorized to deploy to this target"},
+		{"U1", "web production feature Try it", "s3cr3t", 200, `branch \"feature\" can't be deployed to production`},
+	}
+
+	for _, tt := range tests {
+		body := url.Values{"command": {"/deploy"}, "user_id": {tt.userId}, "user_name": {"jane"}, "text": {tt.text}}.Encode()
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+		req := httptest.NewRequest("POST", "/slack/commands/deploy", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+		req.Header.Set("X-Slack-Signature", signSlackBody(tt.secret, timestamp, body))
+
+		rec := httptest.NewRecorder()
+		slackDeployHandler(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %q. want=%d, got=%d", tt.userId, tt.text, tt.expectedStatus, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), tt.expectedMessage) {
+			t.Errorf("wrong response for %s %q. want=%s, got=%s", tt.userId, tt.text, tt.expectedMessage, rec.Body.String())
+		}
+		if rec.Code == 200 {
+			var resp slackCommandResponse
+			checkErr(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			if resp.ResponseType != "ephemeral" {
+				t.Errorf("error shown to the whole channel. got=%+v", resp)
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"text/template"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	slackAPIBaseURL    = "https://slack.com/api"
+	slackDigestTmplStr = `*Applikatoni {{.Schedule.Title}} Digest - {{.Application.Name}}*
+Check out what the team behind {{.Application.Name}} deployed to {{.Target.Name}} in the {{.Schedule.Period}}:
+{{ range .Deployments }}
+• {{.CreatedAt.Format "02.01.2006 15:04 (MST)"}} -- {{.User.DisplayName}}{{if eq .State "failed"}} (failed){{end}}: {{.Comment}}
+{{- end }}
+{{ with .Stats }}
+{{.Deployments}} deployments ({{.DeploymentsChange}}), {{.Current.Failed}} failed ({{printf "%.1f" .Current.ChangeFailureRate}}%), {{fmtDuration .Current.AverageDuration}} on average{{with .DurationChange}} ({{.}}){{end}}
+{{- end }}`
+)
+
+var slackDigestTemplate = template.Must(template.New("slackDigest").Funcs(template.FuncMap{"fmtDuration": fmtDuration}).Parse(slackDigestTmplStr))
+
+// SlackDigestClient posts digests to Slack, with an incoming webhook or as
+// the bot of the slack_bot_token.
+type SlackDigestClient struct {
+	*http.Client
+	apiBaseURL string
+	botToken   string
+}
+
+func NewSlackDigestClient(apiBaseURL, botToken string) *SlackDigestClient {
+	return &SlackDigestClient{
+		Client:     &http.Client{},
+		apiBaseURL: apiBaseURL,
+		botToken:   botToken,
+	}
+}
+
+// slackPostMessageResponse is the answer of chat.postMessage, which fails
+// with a status of 200 too.
+type slackPostMessageResponse struct {
+	Ok    bool   `json:"ok"`
+	Error string `json:"error"`
+}
+
+// SendDigest posts the digest of the target to its digest_slack_url and its
+// digest_slack_channel, if they're set.
+func (c *SlackDigestClient) SendDigest(t *models.Target, text string) error {
+	if t.DigestSlackUrl != "" {
+		if err := c.postWebhook(t.DigestSlackUrl, text); err != nil {
+			return err
+		}
+	}
+	if t.DigestSlackChannel != "" {
+		if err := c.postMessage(t.DigestSlackChannel, text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *SlackDigestClient) postWebhook(url, text string) error {
+	payload, err := json.Marshal(slackMsg{Text: text})
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("slack status code not 200. got=%d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (c *SlackDigestClient) postMessage(channel, text string) error {
+	if c.botToken == "" {
+		return fmt.Errorf("slack_bot_token is required to post to %s", channel)
+	}
+
+	payload, err := json.Marshal(map[string]string{"channel": channel, "text": text})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.apiBaseURL+"/chat.postMessage", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+c.botToken)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("slack status code not 200. got=%d", resp.StatusCode)
+	}
+
+	var result slackPostMessageResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Ok {
+		return fmt.Errorf("posting to %s failed: %s", channel, result.Error)
+	}
+	return nil
+}
+
+func generateSlackDigest(c *digestContent) (string, error) {
+	var text bytes.Buffer
+
+	if err := slackDigestTemplate.Execute(&text, digestVars(c)); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
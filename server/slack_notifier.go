@@ -1,62 +1,57 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-
-	"log"
 	"net/http"
-	"text/template"
 )
 
 const slackSummaryTmplStr = `{{.GitHubRepo}} {{if .Success}}Successfully Deployed{{else}}Deploy Failed{{end}}:
-{{.Username}} deployed {{.Branch}} on {{.Target}} :pizza:
+{{.Username}} deployed {{if .Tag}}{{.Tag}}{{else}}{{.Branch}}{{end}} on {{.Target}}{{with .ApprovedBy}}, approved by {{.}}{{end}} :pizza:
 
-> {{.Comment}}
-<{{.GitHubUrl}}|View latest commit on GitHub>
+> {{.Comment}}{{range .Changelog}}
+• {{.ShortSha}} {{.Summary}} ({{.Author}}){{end}}{{if .Issues}}
+Issues: {{range $i, $issue := .Issues}}{{if $i}}, {{end}}<{{$issue.URL}}|{{$issue.Text}}>{{end}}{{end}}
+<{{.GitHubUrl}}|View latest commit>
 <{{.DeploymentURL}}|Open deployment in Applikatoni>`
 
-var slackTemplate = template.Must(template.New("slackSummary").Parse(slackSummaryTmplStr))
+var slackTemplate = notifierTemplate("slackSummary", slackSummaryTmplStr)
 
 type slackMsg struct {
 	Text string `json:"text"`
 }
 
-func NotifySlack(ev *DeploymentEvent) {
+func NotifySlack(ev *DeploymentEvent) error {
 	if ev.Target.SlackUrl == "" {
-		return
+		return nil
 	}
 
 	summary, err := generateSummary(slackTemplate, ev)
 	if err != nil {
-		log.Printf("Could not generate Slack deployment summary, %s\n", err)
-		return
+		deploymentLogger(ev.Deployment).Error("could not generate Slack deployment summary", "err", err)
+		metrics.NotifierFailed("slack")
+		return err
 	}
 
-	SendSlackRequest(ev, summary)
+	return SendSlackRequest(ev, summary)
 }
 
-func SendSlackRequest(ev *DeploymentEvent, summary string) {
+func SendSlackRequest(ev *DeploymentEvent, summary string) error {
 	payload, err := json.Marshal(slackMsg{Text: summary})
 
 	if err != nil {
-		log.Printf("Error creating Slack notification %s\n", err)
-		return
+		deploymentLogger(ev.Deployment).Error("error creating Slack notification", "err", err)
+		metrics.NotifierFailed("slack")
+		return err
 	}
 
-	resp, err := http.Post(ev.Target.SlackUrl, "application/json", bytes.NewBuffer(payload))
-	if err != nil {
-		log.Printf("Notifying Slack failed (%s on %s, %s): err=%s\n",
-			ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha, err)
-		return
-	}
-	if resp.StatusCode != 200 {
-		log.Printf("Notifying Slack failed (%s on %s, %s): status=%d\n",
-			ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha,
-			resp.StatusCode)
-		return
+	header := http.Header{"Content-Type": {"application/json"}}
+	d := newDelivery("slack", ev.Deployment.Id, "POST", ev.Target.SlackUrl, header, payload, 200)
+	if err := deliver(d); err != nil {
+		deploymentLogger(ev.Deployment).Error("notifying Slack failed", "err", err)
+		metrics.NotifierFailed("slack")
+		return err
 	}
 
-	log.Printf("Successfully notified Slack about deployment of %s on %s, %s!\n",
-		ev.Application.Name, ev.Target.Name, ev.Deployment.CommitSha)
+	deploymentLogger(ev.Deployment).Info("notified Slack")
+	return nil
 }
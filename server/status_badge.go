@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/http"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+const statusBadgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[3]s: %[4]s">
+<title>%[3]s: %[4]s</title>
+<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[5]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="%[7]d" y="15" fill="#010101" fill-opacity=".3">%[3]s</text><text x="%[7]d" y="14">%[3]s</text>
+<text x="%[8]d" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[8]d" y="14">%[4]s</text>
+</g>
+</svg>
+`
+
+var statusBadgeColors = map[models.DeploymentState]string{
+	models.DEPLOYMENT_SUCCESSFUL: "#4c1",
+	models.DEPLOYMENT_FAILED:     "#e05d44",
+	models.DEPLOYMENT_ACTIVE:     "#dfb317",
+	models.DEPLOYMENT_NEW:        "#dfb317",
+	models.DEPLOYMENT_QUEUED:     "#dfb317",
+}
+
+// statusBadgeHandler renders an SVG badge with the state and commit of the
+// last deployment to a target, to embed it in READMEs and dashboards. Only
+// targets with public_badge have a badge.
+func statusBadgeHandler(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+
+	application, err := findApplication(vars["application"])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	target, err := findTarget(application, vars["target"])
+	if err != nil || !target.PublicBadge {
+		http.NotFound(w, r)
+		return
+	}
+
+	deployment, err := getLatestTargetDeployment(db, application, target.Name)
+	if err != nil {
+		requestLogger(r).Error("could not load the last deployment", "application", application.Name, "target", target.Name, "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	badge := renderStatusBadge(target.Name, deployment)
+
+	sum := sha256.Sum256(badge)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	// Proxies like GitHub's camo cache the badge for a minute at most
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml; charset=utf-8")
+	w.Write(badge)
+}
+
+// renderStatusBadge renders the badge of the deployment in the style of the
+// usual CI badges, e.g. "production | successful f00b4r1".
+func renderStatusBadge(label string, d *models.Deployment) []byte {
+	message, color := "never deployed", "#9f9f9f"
+	if d != nil {
+		message = string(d.State)
+		if c, ok := statusBadgeColors[d.State]; ok {
+			color = c
+		}
+		if sha := d.CommitSha; sha != "" {
+			if len(sha) > 7 {
+				sha = sha[:7]
+			}
+			message += " " + sha
+		}
+	}
+
+	labelWidth := statusBadgeTextWidth(label)
+	messageWidth := statusBadgeTextWidth(message)
+
+	return []byte(fmt.Sprintf(statusBadgeTemplate,
+		labelWidth+messageWidth, labelWidth,
+		html.EscapeString(label), html.EscapeString(message),
+		messageWidth, color,
+		labelWidth/2, labelWidth+messageWidth/2))
+}
+
+// statusBadgeTextWidth estimates the width of the text in 11px Verdana, plus
+// the padding.
+func statusBadgeTextWidth(text string) int {
+	return len([]rune(text))*7 + 10
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+	"github.com/gorilla/mux"
+)
+
+func TestRenderStatusBadge(t *testing.T) {
+	tests := []struct {
+		deployment *models.Deployment
+		expected   []string
+	}{
+		{nil, []string{"production: never deployed", `fill="#9f9f9f"`}},
+		{
+			&models.Deployment{State: models.DEPLOYMENT_SUCCESSFUL, CommitSha: "f00b4r1f00b4r"},
+			[]string{"production: successful f00b4r1", `fill="#4c1"`},
+		},
+		{
+			&models.Deployment{State: models.DEPLOYMENT_FAILED, CommitSha: "f00b4r"},
+			[]string{"production: failed f00b4r", `fill="#e05d44"`},
+		},
+	}
+
+	for _, tt := range tests {
+		badge := string(renderStatusBadge("production", tt.deployment))
+		for _, e := range tt.expected {
+			if !strings.Contains(badge, e) {
+				t.Errorf("badge doesn't contain %s. got=%s", e, badge)
+			}
+		}
+	}
+}
+
+func TestStatusBadgeHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{Applications: []*models.Application{
+		{
+			Name: "flincOnRails",
+			Targets: []*models.Target{
+				{Name: "production", PublicBadge: true},
+				{Name: "staging"},
+			},
+		},
+	}})
+	defer func() { setConfig(&Configuration{}) }()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+	deployment := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, deployment))
+
+	router := mux.NewRouter()
+	router.HandleFunc("/{application}/targets/{target}/badge.svg", statusBadgeHandler)
+
+	tests := []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/flincOnRails/targets/production/badge.svg", 200},
+		{"/flincOnRails/targets/staging/badge.svg", 404},
+		{"/flincOnRails/targets/qa/badge.svg", 404},
+		{"/unknown/targets/production/badge.svg", 404},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s. want=%d, got=%d", tt.path, tt.expectedStatus, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("GET", "/flincOnRails/targets/production/badge.svg", nil))
+	if ct := rec.Header().Get("Content-Type"); ct != "image/svg+xml; charset=utf-8" {
+		t.Errorf("wrong content type. got=%s", ct)
+	}
+	if !strings.Contains(rec.Body.String(), string(deployment.State)) {
+		t.Errorf("badge doesn't show the state %s. got=%s", deployment.State, rec.Body.String())
+	}
+
+	req := httptest.NewRequest("GET", "/flincOnRails/targets/production/badge.svg", nil)
+	req.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != 304 {
+		t.Errorf("wrong status for a cached badge. want=304, got=%d", rec.Code)
+	}
+}
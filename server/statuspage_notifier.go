@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const statuspageAPIURL = "https://api.statuspage.io/v1"
+
+// StatuspageIncident is an incident or a scheduled maintenance of a
+// Statuspage.io page.
+type StatuspageIncident struct {
+	Id     string `json:"id,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	Body   string `json:"body,omitempty"`
+	// Only set for scheduled maintenances
+	ScheduledFor   *time.Time `json:"scheduled_for,omitempty"`
+	ScheduledUntil *time.Time `json:"scheduled_until,omitempty"`
+	ComponentIds   []string   `json:"component_ids,omitempty"`
+	// The status of the components by their ID, e.g. "under_maintenance"
+	Components           map[string]string `json:"components,omitempty"`
+	DeliverNotifications bool              `json:"deliver_notifications"`
+}
+
+// StatuspageNotifier posts a scheduled maintenance or an incident to the
+// status page of the target when a deployment starts and resolves it when
+// the deployment finished. The incidents of the running deployments are only
+// kept in memory: if the server restarts in between, they have to be
+// resolved by hand.
+type StatuspageNotifier struct {
+	*http.Client
+	baseURL   string
+	incidents map[int]string
+	mutex     *sync.Mutex
+}
+
+func NewStatuspageNotifier(baseURL string) *StatuspageNotifier {
+	return &StatuspageNotifier{
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		baseURL:   baseURL,
+		incidents: make(map[int]string),
+		mutex:     &sync.Mutex{},
+	}
+}
+
+func (notifier *StatuspageNotifier) Notify(ev *DeploymentEvent) error {
+	s := ev.Target.Statuspage
+	if s == nil {
+		return nil
+	}
+
+	notifier.mutex.Lock()
+	defer notifier.mutex.Unlock()
+
+	switch ev.State {
+	case models.DEPLOYMENT_ACTIVE:
+		incident := notifier.NewIncident(ev, time.Now())
+		created := &StatuspageIncident{}
+		if err := notifier.request(s, "POST", "/pages/"+s.PageId+"/incidents", incident, 201, created); err != nil {
+			deploymentLogger(ev.Deployment).Error("creating Statuspage incident failed", "err", err)
+			metrics.NotifierFailed("statuspage")
+			return err
+		}
+		notifier.incidents[ev.Deployment.Id] = created.Id
+		deploymentLogger(ev.Deployment).Info("created Statuspage incident", "incident", created.Id)
+	case models.DEPLOYMENT_SUCCESSFUL, models.DEPLOYMENT_FAILED:
+		id, ok := notifier.incidents[ev.Deployment.Id]
+		if !ok {
+			// The deployment failed before it started or the server
+			// restarted while it was running
+			return nil
+		}
+		delete(notifier.incidents, ev.Deployment.Id)
+
+		incident := notifier.ResolvedIncident(ev)
+		if err := notifier.request(s, "PATCH", "/pages/"+s.PageId+"/incidents/"+id, incident, 200, nil); err != nil {
+			deploymentLogger(ev.Deployment).Error("resolving Statuspage incident failed", "incident", id, "err", err)
+			metrics.NotifierFailed("statuspage")
+			return err
+		}
+		deploymentLogger(ev.Deployment).Info("resolved Statuspage incident", "incident", id)
+	}
+	return nil
+}
+
+// NewIncident returns the incident of the deployment that just started. A
+// scheduled maintenance is scheduled until the deployment_timeout of the
+// target, or for an hour.
+func (notifier *StatuspageNotifier) NewIncident(ev *DeploymentEvent, now time.Time) *StatuspageIncident {
+	s := ev.Target.Statuspage
+
+	ref := ev.Deployment.Branch
+	if ev.Deployment.Tag != "" {
+		ref = ev.Deployment.Tag
+	}
+	incident := &StatuspageIncident{
+		Name:                 fmt.Sprintf("Deployment of %s to %s", ev.Application.Name, ev.Target.Name),
+		Body:                 fmt.Sprintf("We are deploying %s of %s to %s.", ref, ev.Application.Name, ev.Target.Name),
+		ComponentIds:         s.ComponentIds,
+		DeliverNotifications: s.NotifySubscribers,
+	}
+
+	if !s.IsMaintenance() {
+		incident.Status = "identified"
+		return incident
+	}
+
+	duration, err := ev.Target.Timeout()
+	if err != nil || duration == 0 {
+		duration = time.Hour
+	}
+	until := now.Add(duration)
+	incident.Status = "in_progress"
+	incident.ScheduledFor = &now
+	incident.ScheduledUntil = &until
+	incident.Components = statuspageComponents(s, "under_maintenance")
+	return incident
+}
+
+// ResolvedIncident returns the update that resolves the incident of the
+// finished deployment.
+func (notifier *StatuspageNotifier) ResolvedIncident(ev *DeploymentEvent) *StatuspageIncident {
+	s := ev.Target.Statuspage
+
+	incident := &StatuspageIncident{
+		Status:               "resolved",
+		Body:                 fmt.Sprintf("The deployment of %s to %s has been completed.", ev.Application.Name, ev.Target.Name),
+		DeliverNotifications: s.NotifySubscribers,
+	}
+	if ev.State == models.DEPLOYMENT_FAILED {
+		incident.Body = fmt.Sprintf("The deployment of %s to %s has been stopped.", ev.Application.Name, ev.Target.Name)
+	}
+
+	if s.IsMaintenance() {
+		incident.Status = "completed"
+		incident.Components = statuspageComponents(s, "operational")
+	}
+	return incident
+}
+
+func statuspageComponents(s *models.Statuspage, status string) map[string]string {
+	if len(s.ComponentIds) == 0 {
+		return nil
+	}
+	components := make(map[string]string, len(s.ComponentIds))
+	for _, id := range s.ComponentIds {
+		components[id] = status
+	}
+	return components
+}
+
+func (notifier *StatuspageNotifier) request(s *models.Statuspage, method, path string, incident *StatuspageIncident, expectedStatus int, result interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{"incident": incident})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, notifier.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "OAuth "+s.ApiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := notifier.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != expectedStatus {
+		return fmt.Errorf("Statuspage responded with %d instead of %d", res.StatusCode, expectedStatus)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(result)
+}
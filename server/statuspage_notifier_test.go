@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestStatuspageNotifier(t *testing.T) {
+	type request struct {
+		method   string
+		path     string
+		incident *StatuspageIncident
+	}
+	requests := []*request{}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "OAuth keykeykey" {
+			t.Errorf("wrong authorization. got=%s", r.Header.Get("Authorization"))
+		}
+
+		var body struct {
+			Incident *StatuspageIncident `json:"incident"`
+		}
+		checkErr(t, json.NewDecoder(r.Body).Decode(&body))
+		requests = append(requests, &request{r.Method, r.URL.Path, body.Incident})
+
+		if r.Method == "POST" {
+			w.WriteHeader(201)
+			fmt.Fprintln(w, `{"id": "p31zjtct2jer"}`)
+		}
+	}))
+	defer ts.Close()
+
+	target := &models.Target{
+		Name:              "production",
+		DeploymentTimeout: "30m",
+		Statuspage:        &models.Statuspage{ApiKey: "keykeykey", PageId: "page", ComponentIds: []string{"api"}},
+	}
+	ev := &DeploymentEvent{
+		Deployment:  &models.Deployment{Id: 42, Branch: "master", TargetName: "production"},
+		Application: &models.Application{Name: "web"},
+		Target:      target,
+	}
+	notifier := NewStatuspageNotifier(ts.URL)
+
+	for _, state := range []models.DeploymentState{models.DEPLOYMENT_NEW, models.DEPLOYMENT_ACTIVE, models.DEPLOYMENT_SUCCESSFUL} {
+		ev.State = state
+		notifier.Notify(ev)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("wrong number of requests. got=%d", len(requests))
+	}
+
+	created := requests[0]
+	if created.method != "POST" || created.path != "/pages/page/incidents" {
+		t.Errorf("wrong request to create incident. got=%s %s", created.method, created.path)
+	}
+	if created.incident.Status != "in_progress" || created.incident.Components["api"] != "under_maintenance" || created.incident.Name != "Deployment of web to production" {
+		t.Errorf("wrong incident. got=%+v", created.incident)
+	}
+	if created.incident.ScheduledUntil.Sub(*created.incident.ScheduledFor) != 30*time.Minute {
+		t.Errorf("wrong schedule. got=%s - %s", created.incident.ScheduledFor, created.incident.ScheduledUntil)
+	}
+
+	resolved := requests[1]
+	if resolved.method != "PATCH" || resolved.path != "/pages/page/incidents/p31zjtct2jer" {
+		t.Errorf("wrong request to resolve incident. got=%s %s", resolved.method, resolved.path)
+	}
+	if resolved.incident.Status != "completed" || resolved.incident.Components["api"] != "operational" {
+		t.Errorf("wrong resolved incident. got=%+v", resolved.incident)
+	}
+	if len(notifier.incidents) != 0 {
+		t.Errorf("incident of finished deployment kept. got=%v", notifier.incidents)
+	}
+}
+
+func TestStatuspageNotifierIncident(t *testing.T) {
+	ev := &DeploymentEvent{
+		State:       models.DEPLOYMENT_ACTIVE,
+		Deployment:  &models.Deployment{Id: 42, Tag: "v1.2.0"},
+		Application: &models.Application{Name: "web"},
+		Target: &models.Target{
+			Name:       "production",
+			Statuspage: &models.Statuspage{Type: models.STATUSPAGE_INCIDENT, NotifySubscribers: true},
+		},
+	}
+	notifier := NewStatuspageNotifier("")
+
+	incident := notifier.NewIncident(ev, time.Now())
+	if incident.Status != "identified" || incident.ScheduledFor != nil || incident.Components != nil || !incident.DeliverNotifications {
+		t.Errorf("wrong incident. got=%+v", incident)
+	}
+	if incident.Body != "We are deploying v1.2.0 of web to production." {
+		t.Errorf("wrong body. got=%s", incident.Body)
+	}
+
+	ev.State = models.DEPLOYMENT_FAILED
+	resolved := notifier.ResolvedIncident(ev)
+	if resolved.Status != "resolved" || resolved.Body != "The deployment of web to production has been stopped." {
+		t.Errorf("wrong resolved incident. got=%+v", resolved)
+	}
+}
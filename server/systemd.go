@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// The first file descriptor passed by systemd, after stdin, stdout and stderr.
+const sdListenFdsStart = 3
+
+// systemdListener returns the socket systemd passed to the process if it's
+// socket activated, otherwise nil. The variables are unset, so child
+// processes don't use the socket too.
+func systemdListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", os.Getenv("LISTEN_FDS"))
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("got %d sockets from systemd, only one is supported", n)
+	}
+
+	f := os.NewFile(sdListenFdsStart, "systemd socket")
+	defer f.Close()
+	return net.FileListener(f)
+}
+
+// listen returns the socket passed by systemd or listens on addr.
+func listen(addr string) (net.Listener, error) {
+	l, err := systemdListener()
+	if err != nil || l != nil {
+		return l, err
+	}
+	return net.Listen("tcp", addr)
+}
+
+// sdNotify sends the state, e.g. READY=1, to systemd if the service is of
+// Type=notify. Without NOTIFY_SOCKET it does nothing.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// Abstract sockets start with a null byte, systemd passes them with @
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// notifySystemd is sdNotify for states that are fine to lose, the errors are
+// only logged.
+func notifySystemd(state string) {
+	if err := sdNotify(state); err != nil {
+		slog.Warn("could not notify systemd", "state", state, "err", err)
+	}
+}
+
+// watchdogInterval returns how often systemd expects WATCHDOG=1 if
+// WatchdogSec= is set for the service. It's half of the watchdog timeout,
+// as recommended by sd_watchdog_enabled(3).
+func watchdogInterval() (time.Duration, bool) {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond / 2, true
+}
+
+// feedWatchdog sends WATCHDOG=1 to systemd as long as the database can be
+// reached, so systemd restarts the server if it hangs.
+func feedWatchdog(interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := db.Ping(); err != nil {
+			slog.Warn("not notifying the systemd watchdog, the database can't be reached", "err", err)
+			continue
+		}
+		notifySystemd("WATCHDOG=1")
+	}
+}
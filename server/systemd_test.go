@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	checkErr(t, err)
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", path)
+	checkErr(t, sdNotify("READY=1\nSTATUS=Serving"))
+
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	checkErr(t, err)
+	if got := string(buf[:n]); got != "READY=1\nSTATUS=Serving" {
+		t.Errorf("wrong state. want=%q, got=%q", "READY=1\nSTATUS=Serving", got)
+	}
+}
+
+func TestSdNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := sdNotify("READY=1"); err != nil {
+		t.Errorf("unexpected error without NOTIFY_SOCKET: %s", err)
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+
+	tests := []struct {
+		usec     string
+		pid      string
+		interval time.Duration
+		ok       bool
+	}{
+		{"", "", 0, false},
+		{"30000000", "", 15 * time.Second, true},
+		{"30000000", pid, 15 * time.Second, true},
+		{"30000000", "1", 0, false},
+		{"invalid", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+
+		interval, ok := watchdogInterval()
+		if interval != tt.interval || ok != tt.ok {
+			t.Errorf("wrong interval for %s/%s. want=%s %t, got=%s %t", tt.usec, tt.pid, tt.interval, tt.ok, interval, ok)
+		}
+	}
+}
+
+func TestSystemdListener(t *testing.T) {
+	tests := []struct {
+		pid string
+		fds string
+		err string
+	}{
+		{"", "", ""},
+		{"1", "1", ""},
+		{strconv.Itoa(os.Getpid()), "two", `invalid LISTEN_FDS "two"`},
+		{strconv.Itoa(os.Getpid()), "2", "got 2 sockets from systemd"},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("LISTEN_PID", tt.pid)
+		t.Setenv("LISTEN_FDS", tt.fds)
+
+		l, err := systemdListener()
+		if l != nil {
+			t.Errorf("unexpected listener for %s/%s", tt.pid, tt.fds)
+		}
+		if tt.err == "" && err != nil {
+			t.Errorf("unexpected error for %s/%s: %s", tt.pid, tt.fds, err)
+		}
+		if tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+			t.Errorf("wrong error for %s/%s. want=%q, got=%v", tt.pid, tt.fds, tt.err, err)
+		}
+		if os.Getenv("LISTEN_PID") != "" || os.Getenv("LISTEN_FDS") != "" {
+			t.Errorf("LISTEN_PID and LISTEN_FDS not unset")
+		}
+	}
+}
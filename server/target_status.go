@@ -0,0 +1,171 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// targetStatus is what is currently deployed to a target and how far it has
+// drifted from the default branch of the application.
+type targetStatus struct {
+	Target *models.Target
+	// The last successful deployment, nil if nothing was deployed yet
+	Deployed *models.Deployment
+	// The last deployment if it didn't succeed, e.g. because it's still
+	// running or failed. Nil otherwise.
+	Latest *models.Deployment
+	// The commits of the default branch that aren't deployed. Nil if there
+	// is no default branch, nothing was deployed or the SCM failed.
+	Drift *Diff
+	// Nil if there is no previous commit to roll back to
+	Rollback *rollback
+}
+
+// UpToDate is true if the deployed commit is the head of the default branch
+// or contains it.
+func (s *targetStatus) UpToDate() bool {
+	return s.Drift != nil && s.Drift.AheadBy == 0
+}
+
+// getTargetStatuses loads the deployments of every target of the
+// application. The drift isn't compared, see compareTargetDrift.
+func getTargetStatuses(a *models.Application) ([]*targetStatus, error) {
+	statuses := []*targetStatus{}
+	deployments := []*models.Deployment{}
+
+	for _, t := range a.Targets {
+		s := &targetStatus{Target: t}
+
+		var err error
+		s.Deployed, err = getLastTargetDeployment(db, a, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		if s.Deployed != nil {
+			deployments = append(deployments, s.Deployed)
+		}
+
+		latest, err := getLatestTargetDeployment(db, a, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		if latest != nil && (s.Deployed == nil || latest.Id != s.Deployed.Id) {
+			s.Latest = latest
+			deployments = append(deployments, latest)
+		}
+
+		s.Rollback, err = getRollback(a, t)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, s)
+	}
+
+	err := loadDeploymentsUsers(db, deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+// compareTargetDrift compares the deployed commits with the head of the
+// branch, once per commit. Errors of the SCM are returned after comparing
+// the other targets, whose drift is still shown.
+func compareTargetDrift(client SCMClient, a *models.Application, branch string, statuses []*targetStatus) error {
+	branches, err := client.GetBranches(a)
+	if err != nil {
+		return err
+	}
+
+	var head string
+	for _, b := range branches {
+		if b.Name == branch {
+			head = b.CurrentCommit.Sha
+		}
+	}
+	if head == "" {
+		return nil
+	}
+
+	var lastErr error
+	diffs := map[string]*Diff{}
+	for _, s := range statuses {
+		if s.Deployed == nil {
+			continue
+		}
+
+		sha := s.Deployed.CommitSha
+		if sha == head {
+			diffs[sha] = &Diff{Status: "identical"}
+		}
+		if _, ok := diffs[sha]; !ok {
+			diff, err := client.Compare(a, sha, head)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			diffs[sha] = diff
+		}
+		s.Drift = diffs[sha]
+	}
+
+	return lastErr
+}
+
+// targetsHandler shows a status board of the targets of the application: the
+// deployed commit, who deployed it and when, running or failed deployments
+// and how many commits of the default branch are missing on the target.
+func targetsHandler(w http.ResponseWriter, r *http.Request) {
+	currentUser := getCurrentUser(r)
+	application := getCurrentApplication(r)
+
+	statuses, err := getTargetStatuses(application)
+	if err != nil {
+		requestLogger(r).Error("error loading the target deployments", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	freezes, err := getDeployFreezes(db, application)
+	if err == nil {
+		err = loadDeployFreezesUsers(db, freezes)
+	}
+	if err != nil {
+		requestLogger(r).Error("error loading the deploy freezes", "err", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// The default branch is the first of the configured branches
+	var branch string
+	if len(application.GitHubBranches) > 0 {
+		branch = application.GitHubBranches[0]
+	}
+
+	// The board is still useful without the drift, so SCM errors are only
+	// shown above it
+	var driftError error
+	if branch != "" {
+		client, err := NewCachedSCMClient(application, currentUser)
+		if err == nil {
+			err = compareTargetDrift(client, application, branch, statuses)
+		}
+		if err != nil {
+			requestLogger(r).Warn("error comparing the targets with the branch", "application", application.Name, "branch", branch, "err", err)
+			driftError = err
+		}
+	}
+
+	renderTemplate(w, r, "targets.tmpl", map[string]interface{}{
+		"Applications":  getConfig().Applications,
+		"Application":   application,
+		"Statuses":      statuses,
+		"DefaultBranch": branch,
+		"DriftError":    driftError,
+		"DeployFreezes": freezes,
+		"currentUser":   currentUser,
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+// driftSCMClient answers the comparisons with the head of the branch with
+// the number of commits in aheadBy, or an error if a SHA is missing.
+type driftSCMClient struct {
+	head     string
+	aheadBy  map[string]int
+	compared []string
+}
+
+func (c *driftSCMClient) GetPullRequests(a *models.Application) ([]PullRequest, error) {
+	return nil, nil
+}
+
+func (c *driftSCMClient) GetBranches(a *models.Application) ([]Branch, error) {
+	b := Branch{Name: "master"}
+	b.CurrentCommit.Sha = c.head
+	return []Branch{b}, nil
+}
+
+func (c *driftSCMClient) GetBranch(a *models.Application, name string) (*Branch, error) {
+	b := &Branch{Name: name}
+	b.CurrentCommit.Sha = c.head
+	return b, nil
+}
+
+func (c *driftSCMClient) Compare(a *models.Application, oldSha, newSha string) (*Diff, error) {
+	c.compared = append(c.compared, oldSha)
+	aheadBy, ok := c.aheadBy[oldSha]
+	if !ok || newSha != c.head {
+		return nil, errors.New("not found")
+	}
+	return &Diff{AheadBy: aheadBy}, nil
+}
+
+func (c *driftSCMClient) GetPullRequest(a *models.Application, number int) (*PullRequest, error) {
+	return nil, nil
+}
+
+func (c *driftSCMClient) CommentOnPullRequest(a *models.Application, number int, body string) error {
+	return nil
+}
+
+func (c *driftSCMClient) GetTags(a *models.Application) ([]Tag, error) {
+	return nil, nil
+}
+
+func (c *driftSCMClient) GetTag(a *models.Application, name string) (*Tag, error) {
+	return nil, nil
+}
+
+func TestCompareTargetDrift(t *testing.T) {
+	client := &driftSCMClient{head: "head", aheadBy: map[string]int{"old": 3}}
+
+	statuses := []*targetStatus{
+		{Deployed: &models.Deployment{CommitSha: "head"}},
+		{Deployed: &models.Deployment{CommitSha: "old"}},
+		{Deployed: &models.Deployment{CommitSha: "old"}},
+		{Deployed: &models.Deployment{CommitSha: "unknown"}},
+		{},
+	}
+
+	err := compareTargetDrift(client, &models.Application{}, "master", statuses)
+	if err == nil {
+		t.Errorf("error of the unknown commit not returned")
+	}
+
+	tests := []struct {
+		status   *targetStatus
+		upToDate bool
+		aheadBy  int
+	}{
+		{statuses[0], true, 0},
+		{statuses[1], false, 3},
+		{statuses[2], false, 3},
+		{statuses[3], false, -1},
+		{statuses[4], false, -1},
+	}
+
+	for i, tt := range tests {
+		if tt.status.UpToDate() != tt.upToDate {
+			t.Errorf("wrong up to date of status %d. want=%t, got=%t", i, tt.upToDate, tt.status.UpToDate())
+		}
+		if tt.aheadBy == -1 {
+			if tt.status.Drift != nil {
+				t.Errorf("drift of status %d set", i)
+			}
+		} else if tt.status.Drift == nil || tt.status.Drift.AheadBy != tt.aheadBy {
+			t.Errorf("wrong drift of status %d. want=%d, got=%v", i, tt.aheadBy, tt.status.Drift)
+		}
+	}
+
+	// The head isn't compared and every other commit only once
+	if len(client.compared) != 2 {
+		t.Errorf("wrong comparisons. want=%v, got=%v", []string{"old", "unknown"}, client.compared)
+	}
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	// Microsoft signs the tokens of actionable message actions with the keys
+	// published at teamsKeysURL
+	teamsKeysURL     = "https://substrate.office.com/sts/common/discovery/keys"
+	teamsTokenIssuer = "https://substrate.office.com/sts/"
+	// Unknown key ids are looked up at most this often, in case Microsoft
+	// rotated its keys
+	teamsKeysRefetchInterval = time.Minute
+
+	teamsApproveAction = "approve"
+	teamsRejectAction  = "reject"
+)
+
+// teamsMessageCard is an actionable message card, see
+// https://learn.microsoft.com/outlook/actionable-messages/message-card-reference
+type teamsMessageCard struct {
+	Type            string         `json:"@type"`
+	Context         string         `json:"@context"`
+	Summary         string         `json:"summary"`
+	ThemeColor      string         `json:"themeColor"`
+	Title           string         `json:"title"`
+	Text            string         `json:"text"`
+	PotentialAction []*teamsAction `json:"potentialAction"`
+}
+
+// teamsAction is a button of a card. HttpPOST actions send the Body to the
+// Target, OpenUri actions open one of the Targets.
+type teamsAction struct {
+	Type            string               `json:"@type"`
+	Name            string               `json:"name"`
+	Target          string               `json:"target,omitempty"`
+	Body            string               `json:"body,omitempty"`
+	BodyContentType string               `json:"bodyContentType,omitempty"`
+	Targets         []*teamsActionTarget `json:"targets,omitempty"`
+}
+
+type teamsActionTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+// teamsActionRequest is the body of the HttpPOST actions of the approval
+// cards.
+type teamsActionRequest struct {
+	Action       string `json:"action"`
+	DeploymentId int    `json:"deployment_id"`
+}
+
+// teamsActionClaims are the claims of the token Microsoft sends with the
+// action. Sub is the email address of the user who clicked.
+type teamsActionClaims struct {
+	Iss string `json:"iss"`
+	Aud string `json:"aud"`
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp"`
+}
+
+// actionableMessageKeys caches the public keys the action tokens are signed
+// with by their key id.
+type actionableMessageKeys struct {
+	*http.Client
+	url string
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+var teamsActionKeys = newActionableMessageKeys(teamsKeysURL)
+
+func newActionableMessageKeys(url string) *actionableMessageKeys {
+	return &actionableMessageKeys{
+		Client: &http.Client{Timeout: 30 * time.Second},
+		url:    url,
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// Key returns the key with the id, fetching the keys again if it's unknown.
+func (k *actionableMessageKeys) Key(kid string) (*rsa.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(k.fetchedAt) < teamsKeysRefetchInterval {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	if err := k.fetch(); err != nil {
+		return nil, fmt.Errorf("loading the keys failed: %s", err)
+	}
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+func (k *actionableMessageKeys) fetch() error {
+	k.fetchedAt = time.Now()
+
+	res, err := k.Get(k.url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return fmt.Errorf("status code not 200. got=%d", res.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&set); err != nil {
+		return err
+	}
+
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return err
+		}
+		k.keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return nil
+}
+
+// verifyTeamsActionToken checks the signature, issuer, audience and expiry of
+// the bearer token of an action and returns its claims. The audience is the
+// URL of Applikatoni the action was posted to, without a path.
+func verifyTeamsActionToken(keys *actionableMessageKeys, token, audience string, now time.Time) (*teamsActionClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeTokenPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	key, err := keys.Key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+		return nil, errors.New("invalid signature")
+	}
+
+	claims := &teamsActionClaims{}
+	if err := decodeTokenPart(parts[1], claims); err != nil {
+		return nil, err
+	}
+	switch {
+	case claims.Iss != teamsTokenIssuer:
+		return nil, fmt.Errorf("wrong issuer %q", claims.Iss)
+	case claims.Aud != audience:
+		return nil, fmt.Errorf("wrong audience %q", claims.Aud)
+	case now.Unix() >= claims.Exp:
+		return nil, errors.New("token expired")
+	case claims.Sub == "":
+		return nil, errors.New("token without user")
+	}
+	return claims, nil
+}
+
+func decodeTokenPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// postTeamsApprovalRequest posts the deployment waiting for approval to the
+// approval_teams_url of its target.
+func postTeamsApprovalRequest(application *models.Application, target *models.Target, deployment *models.Deployment, stage models.DeploymentStage) {
+	payload, err := json.Marshal(newTeamsApprovalCard(application, target, deployment, stage))
+	if err != nil {
+		deploymentLogger(deployment).Error("error creating Teams approval request", "err", err)
+		return
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	d := newDelivery("teams", deployment.Id, "POST", target.ApprovalTeamsUrl, header, payload, 200)
+	if err := deliver(d); err != nil {
+		deploymentLogger(deployment).Error("posting approval request to Teams failed", "err", err)
+		metrics.NotifierFailed("teams")
+		return
+	}
+
+	deploymentLogger(deployment).Info("posted approval request to Teams", "stage", stage)
+}
+
+func newTeamsApprovalCard(a *models.Application, t *models.Target, d *models.Deployment, stage models.DeploymentStage) *teamsMessageCard {
+	config := getConfig()
+	waitingFor := fmt.Sprintf("waits for approval to continue with %s", stage)
+	if stage == models.SECOND_APPROVAL_STAGE {
+		waitingFor = "waits for the approval of a second user"
+	}
+	ref := d.Branch
+	if d.Tag != "" {
+		ref = d.Tag
+	}
+	summary := fmt.Sprintf("%s's deployment of %s %s to %s %s", d.User.DisplayName(), a.Name, ref, t.Name, waitingFor)
+
+	actionURL := config.URL("/teams/actions")
+	body := func(action string) string {
+		data, _ := json.Marshal(&teamsActionRequest{Action: action, DeploymentId: d.Id})
+		return string(data)
+	}
+
+	return &teamsMessageCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		Summary:    summary,
+		ThemeColor: "FFA500",
+		Title:      summary,
+		Text:       d.Comment,
+		PotentialAction: []*teamsAction{
+			{Type: "HttpPOST", Name: "Approve", Target: actionURL, Body: body(teamsApproveAction), BodyContentType: "application/json"},
+			{Type: "HttpPOST", Name: "Reject", Target: actionURL, Body: body(teamsRejectAction), BodyContentType: "application/json"},
+			{
+				Type:    "OpenUri",
+				Name:    "Open deployment in Applikatoni",
+				Targets: []*teamsActionTarget{{OS: "default", URI: config.URL(deploymentUrl(a, d))}},
+			},
+		},
+	}
+}
+
+// teamsActionHandler approves or rejects a deployment when one of the
+// buttons of the approval card is clicked. Microsoft authenticates the user
+// with a signed token; the email address in it is mapped to an Applikatoni
+// user with teams_users. The outcome is shown to the user in the
+// CARD-ACTION-STATUS header.
+func teamsActionHandler(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	claims, err := verifyTeamsActionToken(teamsActionKeys, token, getConfig().URL(""), time.Now())
+	if err != nil {
+		requestLogger(r).Warn("Teams action with invalid token", "remote_addr", r.RemoteAddr, "err", err)
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	var action teamsActionRequest
+	if err := json.NewDecoder(r.Body).Decode(&action); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+
+	status, reqErr := teamsDecideApproval(r, claims.Sub, &action)
+	if reqErr != nil {
+		status = reqErr.Message
+	}
+	w.Header().Set("CARD-ACTION-STATUS", status)
+	w.WriteHeader(http.StatusOK)
+}
+
+func teamsDecideApproval(r *http.Request, email string, action *teamsActionRequest) (string, *requestError) {
+	user, reqErr := chatUser(r, "Microsoft Teams", "teams_users", getConfig().TeamsUsers, email)
+	if reqErr != nil {
+		return "", reqErr
+	}
+
+	if action.Action != teamsApproveAction && action.Action != teamsRejectAction {
+		return "", &requestError{422, fmt.Sprintf("unknown action %q", action.Action)}
+	}
+	reject := action.Action == teamsRejectAction
+
+	application, deployment, reqErr := decideDeploymentById(r, user, action.DeploymentId, reject)
+	if reqErr != nil {
+		return "", reqErr
+	}
+
+	decision := "approved"
+	if reject {
+		decision = "rejected"
+	}
+	return fmt.Sprintf("%s %s deployment #%d of %s to %s.", user.Name, decision, deployment.Id, application.Name, deployment.TargetName), nil
+}
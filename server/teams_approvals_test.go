@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func signTeamsToken(t *testing.T, key *rsa.PrivateKey, kid string, claims interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "kid": kid})
+	checkErr(t, err)
+	payload, err := json.Marshal(claims)
+	checkErr(t, err)
+
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hash[:])
+	checkErr(t, err)
+	return unsigned + "." + enc.EncodeToString(signature)
+}
+
+func TestActionableMessageKeys(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	checkErr(t, err)
+
+	fetches := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		n := base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+		e := base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		fmt.Fprintf(w, `{"keys": [{"kid": "k1", "kty": "RSA", "n": %q, "e": %q}]}`, n, e)
+	}))
+	defer ts.Close()
+
+	keys := newActionableMessageKeys(ts.URL)
+
+	for i := 0; i < 2; i++ {
+		public, err := keys.Key("k1")
+		checkErr(t, err)
+		if !public.Equal(&key.PublicKey) {
+			t.Errorf("wrong key")
+		}
+	}
+	if _, err := keys.Key("k2"); err == nil {
+		t.Errorf("expected error for unknown key")
+	}
+	if fetches != 1 {
+		t.Errorf("keys not cached. fetches=%d", fetches)
+	}
+}
+
+func TestVerifyTeamsActionToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	checkErr(t, err)
+	other, err := rsa.GenerateKey(rand.Reader, 1024)
+	checkErr(t, err)
+
+	keys := &actionableMessageKeys{keys: map[string]*rsa.PublicKey{"k1": &key.PublicKey}, fetchedAt: time.Now()}
+	now := time.Unix(1700000000, 0)
+	audience := "https://applikatoni.example.com"
+
+	claims := func(iss, aud, sub string, exp int64) *teamsActionClaims {
+		return &teamsActionClaims{Iss: iss, Aud: aud, Sub: sub, Exp: exp}
+	}
+	valid := claims(teamsTokenIssuer, audience, "jane@example.com", 1700000300)
+
+	tests := []struct {
+		token    string
+		expected bool
+	}{
+		{signTeamsToken(t, key, "k1", valid), true},
+		{signTeamsToken(t, other, "k1", valid), false},
+		{signTeamsToken(t, key, "k2", valid), false},
+		{signTeamsToken(t, key, "k1", claims("https://evil.example.com/", audience, "jane@example.com", 1700000300)), false},
+		{signTeamsToken(t, key, "k1", claims(teamsTokenIssuer, "https://other.example.com", "jane@example.com", 1700000300)), false},
+		{signTeamsToken(t, key, "k1", claims(teamsTokenIssuer, audience, "jane@example.com", 1700000000)), false},
+		{signTeamsToken(t, key, "k1", claims(teamsTokenIssuer, audience, "", 1700000300)), false},
+		{"not.a-token", false},
+		{"", false},
+	}
+
+	for i, tt := range tests {
+		got, err := verifyTeamsActionToken(keys, tt.token, audience, now)
+		if tt.expected && (err != nil || got.Sub != "jane@example.com") {
+			t.Errorf("%d: expected valid token. got err=%v", i, err)
+		}
+		if !tt.expected && err == nil {
+			t.Errorf("%d: expected invalid token", i)
+		}
+	}
+}
+
+func TestNewTeamsApprovalCard(t *testing.T) {
+	setConfig(&Configuration{Host: "applikatoni.example.com"})
+	defer func() { setConfig(&Configuration{}) }()
+
+	a := &models.Application{Name: "web"}
+	target := &models.Target{Name: "production"}
+	d := &models.Deployment{Id: 42, Branch: "master", Comment: "Fix the login", User: &models.User{Name: "mrnugget"}}
+
+	card := newTeamsApprovalCard(a, target, d, "MIGRATE_DATABASE")
+	if card.Summary != "mrnugget's deployment of web master to production waits for approval to continue with MIGRATE_DATABASE" {
+		t.Errorf("wrong summary. got=%q", card.Summary)
+	}
+	if len(card.PotentialAction) != 3 {
+		t.Fatalf("wrong actions. got=%+v", card.PotentialAction)
+	}
+
+	for i, expected := range []string{teamsApproveAction, teamsRejectAction} {
+		action := card.PotentialAction[i]
+		var body teamsActionRequest
+		checkErr(t, json.Unmarshal([]byte(action.Body), &body))
+		if action.Target != "http://applikatoni.example.com/teams/actions" || body.Action != expected || body.DeploymentId != 42 {
+			t.Errorf("wrong %s action. got=%+v", expected, action)
+		}
+	}
+
+	open := card.PotentialAction[2]
+	if len(open.Targets) != 1 || open.Targets[0].URI != "http://applikatoni.example.com/web/deployments/42" {
+		t.Errorf("link to the deployment missing. got=%+v", open)
+	}
+}
+
+func TestTeamsActionHandler(t *testing.T) {
+	db = newTestDb(t)
+	defer func() {
+		cleanCloseTestDb(db, t)
+		db = nil
+	}()
+
+	setConfig(&Configuration{
+		Host:       "applikatoni.example.com",
+		TeamsUsers: map[string]string{"mrnugget@example.com": "mrnugget", "fabrik42@example.com": "fabrik42"},
+		Applications: []*models.Application{
+			{
+				Name:          "flincOnRails",
+				ReadUsernames: []string{"mrnugget", "fabrik42"},
+				Targets: []*models.Target{
+					{Name: "production", DeployUsernames: []string{"mrnugget"}},
+				},
+			},
+		},
+	})
+	defer func() { setConfig(&Configuration{}) }()
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	checkErr(t, err)
+	teamsActionKeys = &actionableMessageKeys{keys: map[string]*rsa.PublicKey{"k1": &key.PublicKey}, fetchedAt: time.Now()}
+	defer func() { teamsActionKeys = newActionableMessageKeys(teamsKeysURL) }()
+
+	approvalRegistry = NewApprovalRegistry()
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+	checkErr(t, createUser(db, buildUser(2, "fabrik42")))
+
+	deployment := buildDeployment(user.Id)
+	checkErr(t, createDeployment(db, deployment))
+
+	tests := []struct {
+		email          string
+		action         string
+		deploymentId   int
+		expectedStatus int
+		expectedHeader string
+	}{
+		{"", "approve", deployment.Id, 401, ""},
+		{"jane@example.com", "approve", deployment.Id, 200, "Your Microsoft Teams user jane@example.com isn't mapped to an Applikatoni user"},
+		{"mrnugget@example.com", "deploy", deployment.Id, 200, `unknown action "deploy"`},
+		{"mrnugget@example.com", "approve", 9999, 200, "deployment not found"},
+		{"fabrik42@example.com", "approve", deployment.Id, 200, "not authorized to approve deployments to this target"},
+		{"mrnugget@example.com", "reject", deployment.Id, 200, ErrNotWaitingForApproval.Error()},
+	}
+
+	for _, tt := range tests {
+		body, _ := json.Marshal(&teamsActionRequest{Action: tt.action, DeploymentId: tt.deploymentId})
+		token := signTeamsToken(t, key, "k1", &teamsActionClaims{
+			Iss: teamsTokenIssuer,
+			Aud: "http://applikatoni.example.com",
+			Sub: tt.email,
+			Exp: time.Now().Add(time.Minute).Unix(),
+		})
+
+		req := httptest.NewRequest("POST", "/teams/actions", strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer "+token)
+
+		rec := httptest.NewRecorder()
+		teamsActionHandler(rec, req)
+
+		if rec.Code != tt.expectedStatus {
+			t.Errorf("wrong status for %s %s. want=%d, got=%d", tt.email, tt.action, tt.expectedStatus, rec.Code)
+		}
+		if status := rec.Header().Get("CARD-ACTION-STATUS"); !strings.Contains(status, tt.expectedHeader) {
+			t.Errorf("wrong status message for %s %s. want=%s, got=%s", tt.email, tt.action, tt.expectedHeader, status)
+		}
+	}
+}
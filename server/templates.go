@@ -3,27 +3,25 @@ package main
 import (
 	"fmt"
 	"html/template"
-	"log"
+	"io/fs"
 	"net/http"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/applikatoni/applikatoni/models"
 )
 
-func commitLink(a *models.Application, sha string) string {
-	return fmt.Sprintf("https://github.com/%s/%s/commit/%s",
-		a.GitHubOwner, a.GitHubRepo, sha)
-}
-
 func fmtCommit(a *models.Application, d *models.Deployment) template.HTML {
 	sha := d.CommitSha[:6]
 	href := commitLink(a, d.CommitSha)
 
-	if d.Branch == "" {
+	switch {
+	case d.Tag != "":
+		return template.HTML("<a href=\"" + href + "\"><code>" + sha + " (" + template.HTMLEscapeString(d.Tag) + ")</code></a>")
+	case d.Branch == "":
 		return template.HTML("<a href=\"" + href + "\"><code>" + sha + "</code></a>")
-	} else {
-		return template.HTML("<a href=\"" + href + "\"><code>" + sha + " (" + d.Branch + ")</code></a>")
+	default:
+		return template.HTML("<a href=\"" + href + "\"><code>" + sha + " (" + template.HTMLEscapeString(d.Branch) + ")</code></a>")
 	}
 }
 
@@ -39,41 +37,116 @@ func fmtDeploymentState(state models.DeploymentState) template.HTML {
 		s = `<span data-attr="state-info" class="label label-success">Successful</span>`
 	case models.DEPLOYMENT_FAILED:
 		s = `<span data-attr="state-info" class="label label-danger">Failed</span>`
+	case models.DEPLOYMENT_QUEUED:
+		s = `<span data-attr="state-info" class="label label-warning">Queued</span>`
 	}
 
 	return template.HTML(s)
 }
 
+func fmtHostGroup(group string) template.HTML {
+	switch group {
+	case models.BLUE_GROUP:
+		return template.HTML(`<span class="label host-group-blue">blue</span>`)
+	case models.GREEN_GROUP:
+		return template.HTML(`<span class="label host-group-green">green</span>`)
+	}
+	return template.HTML(`<span class="label label-default">none</span>`)
+}
+
+// queuePosition returns the position of a queued deployment in the queue of
+// its target or 0 if it's not queued.
+func queuePosition(d *models.Deployment) int {
+	if d.State != models.DEPLOYMENT_QUEUED {
+		return 0
+	}
+	return deploymentQueue.Position(d.Id)
+}
+
+// fmtDuration rounds the duration to seconds, or minutes if it's longer than
+// an hour, e.g. "4m12s" or "26h5m". Zero durations are shown as a dash.
+func fmtDuration(d time.Duration) string {
+	switch {
+	case d == 0:
+		return "\u2013"
+	case d >= time.Hour:
+		d = (d + time.Minute/2) / time.Minute * time.Minute
+		return strings.TrimSuffix(d.String(), "0s")
+	default:
+		d = (d + time.Second/2) / time.Second * time.Second
+		return d.String()
+	}
+}
+
+// isPrefilledStage checks whether the stage is checked in the deploy form:
+// the stages of the deployment to redeploy, if it went to the target and its
+// stages were saved, or the default stages of the target.
+func isPrefilledStage(t *models.Target, s models.DeploymentStage, redeploy *models.Deployment) bool {
+	if redeploy != nil && redeploy.TargetName == t.Name && len(redeploy.Stages) > 0 {
+		for _, stage := range redeploy.Stages {
+			if stage == s {
+				return true
+			}
+		}
+		return false
+	}
+	return t.IsDefaultStage(s)
+}
+
 func newlineToBreak(input string) template.HTML {
 	output := template.HTMLEscapeString(input)
 	return template.HTML(strings.Replace(output, "\n", "\n<br/>", -1))
 }
 
-func renderTemplate(w http.ResponseWriter, name string, data map[string]interface{}) {
-	tmpl := templates[name]
+// translateHTML is like translate, for trusted messages containing markup.
+// The args are escaped.
+func translateHTML(language, message string, args ...interface{}) template.HTML {
+	escaped := make([]interface{}, len(args))
+	for i, a := range args {
+		escaped[i] = template.HTMLEscapeString(fmt.Sprint(a))
+	}
+	return template.HTML(translate(language, message, escaped...))
+}
+
+// renderTemplate renders the template in the language of the request and
+// shows the flash messages stored in the session.
+func renderTemplate(w http.ResponseWriter, r *http.Request, name string, data map[string]interface{}) {
+	language := requestLanguage(r)
+	tmpl := templates[language][name]
 	if tmpl == nil {
-		log.Printf("template %s not found\n", name)
+		requestLogger(r).Error("template not found", "template", name)
 		return
 	}
 
 	data["Version"] = VERSION
+	data["Language"] = language
+	data["Flashes"] = takeFlashes(w, r)
+	data["CSRFToken"] = csrfToken(w, r)
 
 	err := tmpl.Execute(w, data)
 	if err != nil {
-		log.Printf("rendering %s failed: %s\n", name, err)
+		requestLogger(r).Error("rendering template failed", "template", name, "err", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
 
-func joinTemplatePaths(dir string, files []string) []string {
-	joined := make([]string, len(files))
-	for i := range files {
-		joined[i] = filepath.Join(dir, files[i])
+// parseTemplates parses the template sets once per supported language. The
+// result maps the language and the name of the template to the template.
+func parseTemplates(fsys fs.FS, templateSets [][]string) (map[string]map[string]*template.Template, error) {
+	parsed := map[string]map[string]*template.Template{}
+
+	for _, l := range languages {
+		localized, err := parseLocalizedTemplates(fsys, templateSets, l.Code)
+		if err != nil {
+			return nil, err
+		}
+		parsed[l.Code] = localized
 	}
-	return joined
+
+	return parsed, nil
 }
 
-func parseTemplates(base string, templateSets [][]string) (map[string]*template.Template, error) {
+func parseLocalizedTemplates(fsys fs.FS, templateSets [][]string, language string) (map[string]*template.Template, error) {
 	parsed := map[string]*template.Template{}
 
 	for _, set := range templateSets {
@@ -81,14 +154,31 @@ func parseTemplates(base string, templateSets [][]string) (map[string]*template.
 		t := template.New(templateName)
 
 		t.Funcs(template.FuncMap{
+			"authProviders":      func() []AuthProvider { return authProviders },
+			"commitLink":         commitLink,
 			"fmtCommit":          fmtCommit,
 			"fmtDeploymentState": fmtDeploymentState,
+			"fmtHostGroup":       fmtHostGroup,
+			"fmtDuration":        fmtDuration,
+			"highlight":          highlight,
+			"inactiveGroup":      models.InactiveGroup,
+			"isAdmin":            func(u *models.User) bool { return getConfig().IsAdmin(u) },
+			"isPrefilledStage":   isPrefilledStage,
+			"linkIssues":         linkIssues,
 			"newlineToBreak":     newlineToBreak,
+			"pullRequestLink":    pullRequestLink,
+			"samlProvider":       func() *SAMLProvider { return samlProvider },
+			"queuePosition":      queuePosition,
+			"rollbackDialog":     newRollbackDialog,
+			"t": func(message string, args ...interface{}) string {
+				return translate(language, message, args...)
+			},
+			"tHTML": func(message string, args ...interface{}) template.HTML {
+				return translateHTML(language, message, args...)
+			},
 		})
 
-		paths := joinTemplatePaths(base, set)
-
-		_, err := t.ParseFiles(paths...)
+		_, err := t.ParseFS(fsys, set...)
 		if err != nil {
 			return nil, err
 		}
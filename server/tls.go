@@ -0,0 +1,127 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+const defaultACMECacheDir = "acme-cache"
+
+// validateTLS checks the tls_* and acme_* settings. Serving HTTPS turns
+// ssl_enabled on, since every URL Applikatoni builds is an https one then.
+func validateTLS(c *Configuration) error {
+	if (c.TLSCertificateFile == "") != (c.TLSKeyFile == "") {
+		return errors.New("set both tls_certificate_file and tls_key_file")
+	}
+	if c.TLSCertificateFile != "" && len(c.ACMEHosts) > 0 {
+		return errors.New("set either tls_certificate_file or acme_hosts")
+	}
+	if c.HTTPRedirectAddr != "" && !c.TLSEnabled() {
+		return errors.New("http_redirect_addr requires tls_certificate_file or acme_hosts")
+	}
+
+	if c.TLSEnabled() {
+		c.SSLEnabled = true
+	}
+	return nil
+}
+
+// TLSEnabled checks whether Applikatoni serves HTTPS itself.
+func (c *Configuration) TLSEnabled() bool {
+	return c.TLSCertificateFile != "" || len(c.ACMEHosts) > 0
+}
+
+// ACMECacheDirectory returns the directory the certificates of Let's Encrypt
+// are kept in, relative to the configuration file unless it's absolute.
+func (c *Configuration) ACMECacheDirectory() string {
+	dir := c.ACMECacheDir
+	if dir == "" {
+		dir = defaultACMECacheDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.dir, dir)
+	}
+	return dir
+}
+
+// serverTLSConfig returns the TLS configuration of the server, nil if it
+// serves plain HTTP. The certificates are either read from tls_certificate_file
+// and tls_key_file or requested from Let's Encrypt for the acme_hosts, in
+// which case the autocert.Manager is returned too.
+func serverTLSConfig(c *Configuration) (*tls.Config, *autocert.Manager, error) {
+	if c.TLSCertificateFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.TLSCertificateFile, c.TLSKeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		return tlsConfig, nil, nil
+	}
+
+	if len(c.ACMEHosts) > 0 {
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(c.ACMEHosts...),
+			Cache:      autocert.DirCache(c.ACMECacheDirectory()),
+			Email:      c.ACMEEmail,
+		}
+		// The TLS configuration of the manager answers the tls-alpn-01
+		// challenges of Let's Encrypt
+		tlsConfig := m.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, m, nil
+	}
+
+	return nil, nil, nil
+}
+
+// redirectToHTTPS redirects every request to the same URL on https. port is
+// the port the HTTPS server listens on, left out of the URLs if it's 443.
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		// 308 keeps the method and body of the request, 301 is understood
+		// by older clients
+		status := http.StatusPermanentRedirect
+		if r.Method == "GET" || r.Method == "HEAD" {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// serveHTTPRedirect serves the redirects to HTTPS on addr. With Let's Encrypt
+// it answers the http-01 challenges too.
+func serveHTTPRedirect(addr, httpsAddr string, m *autocert.Manager) {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+
+	var h http.Handler = redirectToHTTPS(port)
+	if m != nil {
+		h = m.HTTPHandler(h)
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		fatal("could not serve the redirects to HTTPS", "addr", addr, "err", err)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		method           string
+		url              string
+		port             string
+		expectedStatus   int
+		expectedLocation string
+	}{
+		{"GET", "http://example.com/applikatoni?tab=logs", "443", http.StatusMovedPermanently, "https://example.com/applikatoni?tab=logs"},
+		{"GET", "http://example.com:80/", "", http.StatusMovedPermanently, "https://example.com/"},
+		{"HEAD", "http://example.com:8080/", "8443", http.StatusMovedPermanently, "https://example.com:8443/"},
+		{"POST", "http://example.com/api/deployments", "443", http.StatusPermanentRedirect, "https://example.com/api/deployments"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(tt.port).ServeHTTP(rec, httptest.NewRequest(tt.method, tt.url, nil))
+
+		if rec.Code != tt.expectedStatus || rec.Header().Get("Location") != tt.expectedLocation {
+			t.Errorf("wrong redirect of %s %s. want=%d %s, got=%d %s", tt.method, tt.url,
+				tt.expectedStatus, tt.expectedLocation, rec.Code, rec.Header().Get("Location"))
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	tests := []struct {
+		config        Configuration
+		expectedError bool
+		expectedSSL   bool
+	}{
+		{Configuration{}, false, false},
+		{Configuration{TLSCertificateFile: "cert.pem", TLSKeyFile: "key.pem"}, false, true},
+		{Configuration{ACMEHosts: []string{"example.com"}, HTTPRedirectAddr: ":80"}, false, true},
+		{Configuration{TLSCertificateFile: "cert.pem"}, true, false},
+		{Configuration{TLSCertificateFile: "cert.pem", TLSKeyFile: "key.pem", ACMEHosts: []string{"example.com"}}, true, false},
+		{Configuration{HTTPRedirectAddr: ":80"}, true, false},
+	}
+
+	for i, tt := range tests {
+		err := validateTLS(&tt.config)
+		if (err != nil) != tt.expectedError {
+			t.Errorf("%d: wrong error. want error=%t, got=%v", i, tt.expectedError, err)
+		}
+		if err == nil && tt.config.SSLEnabled != tt.expectedSSL {
+			t.Errorf("%d: wrong ssl_enabled. want=%t, got=%t", i, tt.expectedSSL, tt.config.SSLEnabled)
+		}
+	}
+}
+
+func TestACMECacheDirectory(t *testing.T) {
+	c := &Configuration{dir: "/etc/applikatoni"}
+	if dir := c.ACMECacheDirectory(); dir != "/etc/applikatoni/acme-cache" {
+		t.Errorf("wrong default directory. got=%s", dir)
+	}
+
+	c.ACMECacheDir = "/var/lib/applikatoni/acme"
+	if dir := c.ACMECacheDirectory(); dir != "/var/lib/applikatoni/acme" {
+		t.Errorf("absolute directory changed. got=%s", dir)
+	}
+}
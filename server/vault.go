@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// References to secrets in Vault look like vault:secret/applikatoni#ssh_key,
+// the path of the secret and the key of the value.
+const vaultSecretPrefix = "vault:"
+
+const vaultRequestTimeout = 10 * time.Second
+
+// VaultClient reads secrets from HashiCorp Vault with a token, either
+// configured or obtained by logging in with an AppRole.
+type VaultClient struct {
+	*http.Client
+	address string
+	token   string
+	// The secrets read so far, by path
+	secrets map[string]map[string]interface{}
+}
+
+// NewVaultClient returns a client for the Vault configured with
+// vault_address or VAULT_ADDR, nil if there is none. It authenticates with
+// vault_role_id and vault_secret_id if set, otherwise with vault_token or
+// VAULT_TOKEN.
+func NewVaultClient(c *Configuration) (*VaultClient, error) {
+	address := c.VaultAddress
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return nil, nil
+	}
+
+	vc := &VaultClient{
+		Client:  &http.Client{Timeout: vaultRequestTimeout},
+		address: strings.TrimRight(address, "/"),
+		token:   c.VaultToken,
+		secrets: make(map[string]map[string]interface{}),
+	}
+	if vc.token == "" {
+		vc.token = os.Getenv("VAULT_TOKEN")
+	}
+
+	if c.VaultRoleId != "" {
+		err := vc.loginWithAppRole(c.VaultRoleId, c.VaultSecretId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if vc.token == "" {
+		return nil, errors.New("no vault_token or vault_role_id configured")
+	}
+
+	return vc, nil
+}
+
+func (vc *VaultClient) loginWithAppRole(roleId, secretId string) error {
+	body, err := json.Marshal(map[string]string{"role_id": roleId, "secret_id": secretId})
+	if err != nil {
+		return err
+	}
+
+	resp, err := vc.Post(vc.address+"/v1/auth/approle/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("approle login failed with status %d", resp.StatusCode)
+	}
+
+	login := struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&login)
+	if err != nil {
+		return err
+	}
+	if login.Auth.ClientToken == "" {
+		return errors.New("approle login returned no token")
+	}
+
+	vc.token = login.Auth.ClientToken
+	return nil
+}
+
+// Resolve returns the value of the key of the secret referenced by
+// path#key. Secrets of the key/value engine in version 2 are unwrapped, their
+// path has to include data/, e.g. secret/data/applikatoni#ssh_key.
+func (vc *VaultClient) Resolve(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i == -1 {
+		return "", errors.New("missing #key")
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	secret, err := vc.readSecret(path)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secret[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no key %s", path, key)
+	}
+	return value, nil
+}
+
+func (vc *VaultClient) readSecret(path string) (map[string]interface{}, error) {
+	if secret, ok := vc.secrets[path]; ok {
+		return secret, nil
+	}
+
+	req, err := http.NewRequest("GET", vc.address+"/v1/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", vc.token)
+
+	resp, err := vc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reading secret %s failed with status %d", path, resp.StatusCode)
+	}
+
+	body := struct {
+		Data map[string]interface{} `json:"data"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := body.Data
+	// Version 2 of the key/value engine nests the values and adds metadata
+	if data, ok := secret["data"].(map[string]interface{}); ok {
+		if _, ok := secret["metadata"]; ok {
+			secret = data
+		}
+	}
+
+	vc.secrets[path] = secret
+	return secret, nil
+}
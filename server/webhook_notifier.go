@@ -1,10 +1,11 @@
 package main
 
 import (
-	"bytes"
 	"encoding/json"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/applikatoni/applikatoni/models"
@@ -20,6 +21,7 @@ type WebhookDeployment struct {
 	Id             int                    `json:"deployment_id"`
 	CommitSha      string                 `json:"commit_sha"`
 	Branch         string                 `json:"branch"`
+	Tag            string                 `json:"tag"`
 	State          models.DeploymentState `json:"state"`
 	Comment        string                 `json:"comment"`
 	CreatedAt      time.Time              `json:"created_at"`
@@ -27,13 +29,24 @@ type WebhookDeployment struct {
 	DeployerID     int                    `json:"deployer_id"`
 	DeployerName   string                 `json:"deployer_name"`
 	DeployerAvatar string                 `json:"deployer_avatar"`
+	// Only set on targets with require_second_approval
+	ApprovedBy string `json:"approved_by,omitempty"`
+
+	Changelog []*models.ChangelogEntry `json:"changelog"`
+}
+
+// WebhookHost is a host without its SSH settings, so the SSH keys of hosts
+// aren't sent to the webhooks.
+type WebhookHost struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
 }
 
 type WebhookTarget struct {
 	Name            string                   `json:"target_name"`
 	DeploymentUser  string                   `json:"deployment_user"`
 	DeployUsernames []string                 `json:"deploy_usernames"`
-	Hosts           []*models.Host           `json:"hosts"`
+	Hosts           []WebhookHost            `json:"hosts"`
 	Roles           []*models.Role           `json:"roles"`
 	AvailableStages []models.DeploymentStage `json:"available_stages"`
 	DefaultStages   []models.DeploymentStage `json:"default_stages"`
@@ -48,9 +61,9 @@ type WebhookMsg struct {
 	Target      WebhookTarget      `json:"target"`
 }
 
-func NotifyWebhooks(ev *DeploymentEvent) {
+func NotifyWebhooks(ev *DeploymentEvent) error {
 	if len(ev.Target.Webhooks) == 0 {
-		return
+		return nil
 	}
 
 	msg := WebhookMsg{
@@ -65,6 +78,7 @@ func NotifyWebhooks(ev *DeploymentEvent) {
 			Id:             ev.Deployment.Id,
 			CommitSha:      ev.Deployment.CommitSha,
 			Branch:         ev.Deployment.Branch,
+			Tag:            ev.Deployment.Tag,
 			State:          ev.Deployment.State,
 			Comment:        ev.Deployment.Comment,
 			CreatedAt:      ev.Deployment.CreatedAt,
@@ -72,37 +86,67 @@ func NotifyWebhooks(ev *DeploymentEvent) {
 			DeployerID:     ev.Deployment.UserId,
 			DeployerName:   ev.Deployment.User.Name,
 			DeployerAvatar: ev.Deployment.User.AvatarUrl,
+			ApprovedBy:     ev.Deployment.ApprovedBy,
+			Changelog:      ev.Deployment.Changelog,
 		},
 		Target: WebhookTarget{
 			Name:            ev.Target.Name,
 			DeploymentUser:  ev.Target.DeploymentUser,
 			DeployUsernames: ev.Target.DeployUsernames,
-			Hosts:           ev.Target.Hosts,
+			Hosts:           newWebhookHosts(ev.Target.Hosts),
 			Roles:           ev.Target.Roles,
 			AvailableStages: ev.Target.AvailableStages,
 			DefaultStages:   ev.Target.DefaultStages,
 		},
 	}
 
-	for _, w := range ev.Target.Webhooks {
-		go sendWebhookMsg(w, msg)
+	return sendToWebhooks(ev.Target.Webhooks, func(hook string) error {
+		return sendWebhookMsg(hook, msg)
+	})
+}
+
+// sendToWebhooks calls send for all webhooks in parallel and returns their
+// errors.
+func sendToWebhooks(hooks []string, send func(hook string) error) error {
+	errs := make([]error, len(hooks))
+	var wg sync.WaitGroup
+	for i, hook := range hooks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = send(hook)
+		}()
 	}
+	wg.Wait()
+	return errors.Join(errs...)
 }
 
-func sendWebhookMsg(hook string, msg WebhookMsg) {
+func newWebhookHosts(hosts []*models.Host) []WebhookHost {
+	webhookHosts := []WebhookHost{}
+	for _, h := range hosts {
+		webhookHosts = append(webhookHosts, WebhookHost{Name: h.Name, Roles: h.Roles})
+	}
+	return webhookHosts
+}
+
+func sendWebhookMsg(hook string, msg WebhookMsg) error {
 	payload, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error creating WebhookMsg %s\n", err)
-		return
+		slog.Error("error creating webhook message", "deployment.id", msg.Deployment.Id, "err", err)
+		metrics.NotifierFailed("webhook")
+		return err
 	}
 
-	resp, err := http.Post(hook, "application/json", bytes.NewBuffer(payload))
-	if err != nil {
-		log.Printf("Error while notifying Webhook %s about deployment of %v on %v! err: %s\n",
-			hook, msg.Application.Name, msg.Target.Name, err)
-		return
+	header := http.Header{"Content-Type": {"application/json"}}
+	d := newDelivery("webhook", msg.Deployment.Id, "POST", hook, header, payload, 0)
+	// Answers without 2xx status are failed too, they're also listed as
+	// failed deliveries on the admin page
+	if err := deliver(d); err != nil {
+		slog.Error("notifying webhook failed", "url", hook, "deployment.id", msg.Deployment.Id, "status", d.StatusCode, "err", err)
+		metrics.NotifierFailed("webhook")
+		return err
 	}
 
-	log.Printf("Notified Webhook %s about deployment of %v on %v! Response: %v",
-		hook, msg.Application.Name, msg.Target.Name, resp.Status)
+	slog.Info("notified webhook", "url", hook, "deployment.id", msg.Deployment.Id, "status", d.StatusCode)
+	return nil
 }
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+const (
+	weeklySummaryWeekday              = time.Monday
+	weeklySummaryHourOfDay            = 8
+	weeklySummarySubjectFmt           = " 🍕 Applikatoni Weekly Summary - %s"
+	weeklySummaryHtmlTemplateFilename = "weekly_summary.tmpl"
+	// The number of slowest stages and most active deployers listed
+	weeklySummaryTopCount     = 5
+	weeklySummaryTextTemplate = `Hello there!
+
+Here's what was deployed between {{.From.Format "02.01.2006"}} and {{.To.Format "02.01.2006"}}:
+
+{{.Deployments}} deployments, {{.Failed}} failed ({{printf "%.1f" .FailureRate}}%)
+{{ range .Applications }}
+    {{.Name}}: {{.Deployments}} deployments, {{.Failed}} failed ({{printf "%.1f" .FailureRate}}%)
+{{- end }}
+{{ if .SlowestStages }}
+Slowest stages, on average:
+{{ range .SlowestStages }}
+    {{.Application}}/{{.Target}} {{.Stage}}: {{.Average}} ({{.Runs}} runs, at most {{.Max}})
+{{- end }}
+{{ end }}
+Most active deployers:
+{{ range .TopDeployers }}
+    {{.User.DisplayName}}: {{.Deployments}} deployments
+{{- end }}
+
+Always at your service:
+your Applikatoni Weekly Summary Team
+
+Applikatoni - Deployments Al Forno
+`
+)
+
+var (
+	nextWeeklySummary time.Time
+)
+
+// WeeklySummary aggregates the finished deployments of all applications in a
+// week for the people running them.
+type WeeklySummary struct {
+	From         time.Time
+	To           time.Time
+	Deployments  int
+	Failed       int
+	Applications []*applicationActivity
+	// The stages that took longest on average, slowest first
+	SlowestStages []*stageTiming
+	// The users with the most deployments, most active first
+	TopDeployers []*deployerActivity
+}
+
+func (s *WeeklySummary) FailureRate() float64 {
+	return failureRate(s.Failed, s.Deployments)
+}
+
+type applicationActivity struct {
+	Name        string
+	Deployments int
+	Failed      int
+}
+
+func (a *applicationActivity) FailureRate() float64 {
+	return failureRate(a.Failed, a.Deployments)
+}
+
+// failureRate returns the percentage of failed deployments.
+func failureRate(failed, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(failed) / float64(total) * 100
+}
+
+// stageTiming is the duration of the successful runs of a stage on a target.
+type stageTiming struct {
+	Application string
+	Target      string
+	Stage       models.DeploymentStage
+	Runs        int
+	Total       time.Duration
+	Max         time.Duration
+}
+
+func (s *stageTiming) Average() time.Duration {
+	return (s.Total / time.Duration(s.Runs)).Round(time.Second)
+}
+
+type deployerActivity struct {
+	User        *models.User
+	Deployments int
+}
+
+// SendWeeklySummaries sends the weekly summary to the weekly_summary_receivers
+// every week, if any are configured.
+func SendWeeklySummaries(db *sql.DB, sender DailyDigestSender) {
+	nextWeeklySummary = calcNextWeeklySummary(time.Now(), weeklySummaryWeekday, weeklySummaryHourOfDay)
+
+	for {
+		now := time.Now()
+
+		if now.After(nextWeeklySummary) {
+			from := nextWeeklySummary.AddDate(0, 0, -7)
+			err := sendWeeklySummary(db, sender, getConfig().WeeklySummaryReceivers, from, nextWeeklySummary)
+			if err != nil {
+				slog.Error("sending weekly summary failed", "err", err)
+			}
+
+			nextWeeklySummary = nextWeeklySummary.AddDate(0, 0, 7)
+		}
+
+		time.Sleep(digestSleepTime)
+	}
+}
+
+// calcNextWeeklySummary returns the next time after now that is on the
+// weekday at hourOfDay.
+func calcNextWeeklySummary(now time.Time, weekday time.Weekday, hourOfDay int) time.Time {
+	year, month, day := now.Date()
+	days := (int(weekday) - int(now.Weekday()) + 7) % 7
+
+	next := time.Date(year, month, day+days, hourOfDay, 0, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+func sendWeeklySummary(db *sql.DB, sender DailyDigestSender, receivers []string, from, to time.Time) error {
+	if len(receivers) == 0 {
+		return nil
+	}
+
+	summary, err := buildWeeklySummary(db, from, to)
+	if err != nil {
+		return err
+	}
+
+	if summary.Deployments == 0 {
+		slog.Info("skipping weekly summary, no deployments")
+		return nil
+	}
+
+	digest, err := NewWeeklySummaryDigest(receivers, summary)
+	if err != nil {
+		return err
+	}
+
+	err = sender.SendDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("sent weekly summary", "receivers", receivers)
+	return nil
+}
+
+// buildWeeklySummary aggregates the deployments finished between from and
+// to.
+func buildWeeklySummary(db *sql.DB, from, to time.Time) (*WeeklySummary, error) {
+	deployments, err := getWeeklySummaryDeployments(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	err = loadDeploymentsUsers(db, deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := getWeeklySummaryStageEntries(db, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	timezone, err := time.LoadLocation(digestTimezone)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &WeeklySummary{
+		From:          from.In(timezone),
+		To:            to.In(timezone),
+		Deployments:   len(deployments),
+		Applications:  countApplicationActivity(deployments),
+		SlowestStages: slowestStages(deployments, entries, weeklySummaryTopCount),
+		TopDeployers:  topDeployers(deployments, weeklySummaryTopCount),
+	}
+	for _, d := range deployments {
+		if d.State == models.DEPLOYMENT_FAILED {
+			summary.Failed++
+		}
+	}
+
+	return summary, nil
+}
+
+// countApplicationActivity returns the deployments and failures by
+// application, ordered by name.
+func countApplicationActivity(deployments []*models.Deployment) []*applicationActivity {
+	byName := map[string]*applicationActivity{}
+	applications := []*applicationActivity{}
+
+	for _, d := range deployments {
+		a, ok := byName[d.ApplicationName]
+		if !ok {
+			a = &applicationActivity{Name: d.ApplicationName}
+			byName[d.ApplicationName] = a
+			applications = append(applications, a)
+		}
+		a.Deployments++
+		if d.State == models.DEPLOYMENT_FAILED {
+			a.Failed++
+		}
+	}
+
+	sort.Slice(applications, func(i, j int) bool {
+		return applications[i].Name < applications[j].Name
+	})
+	return applications
+}
+
+// slowestStages measures the stages from their STAGE_START to their
+// STAGE_SUCCESS log entry and returns the n slowest by average. Failed stages
+// are left out, they often fail early.
+func slowestStages(deployments []*models.Deployment, entries []*deploy.LogEntry, n int) []*stageTiming {
+	byId := map[int]*models.Deployment{}
+	for _, d := range deployments {
+		byId[d.Id] = d
+	}
+
+	type stageKey struct {
+		application string
+		target      string
+		stage       string
+	}
+	timings := map[stageKey]*stageTiming{}
+	started := map[int]map[string]time.Time{}
+
+	for _, e := range entries {
+		d, ok := byId[e.DeploymentId]
+		if !ok {
+			continue
+		}
+
+		switch e.EntryType {
+		case deploy.STAGE_START:
+			if started[d.Id] == nil {
+				started[d.Id] = map[string]time.Time{}
+			}
+			started[d.Id][e.Message] = e.Timestamp
+		case deploy.STAGE_SUCCESS:
+			start, ok := started[d.Id][e.Message]
+			if !ok {
+				continue
+			}
+			duration := e.Timestamp.Sub(start)
+
+			key := stageKey{d.ApplicationName, d.TargetName, e.Message}
+			t, ok := timings[key]
+			if !ok {
+				t = &stageTiming{Application: d.ApplicationName, Target: d.TargetName, Stage: models.DeploymentStage(e.Message)}
+				timings[key] = t
+			}
+			t.Runs++
+			t.Total += duration
+			if duration > t.Max {
+				t.Max = duration
+			}
+		}
+	}
+
+	stages := []*stageTiming{}
+	for _, t := range timings {
+		t.Max = t.Max.Round(time.Second)
+		stages = append(stages, t)
+	}
+	sort.Slice(stages, func(i, j int) bool {
+		a, b := stages[i], stages[j]
+		if a.Average() != b.Average() {
+			return a.Average() > b.Average()
+		}
+		if a.Application != b.Application {
+			return a.Application < b.Application
+		}
+		if a.Target != b.Target {
+			return a.Target < b.Target
+		}
+		return a.Stage < b.Stage
+	})
+
+	if len(stages) > n {
+		stages = stages[:n]
+	}
+	return stages
+}
+
+// topDeployers returns the n users with the most deployments. The users of
+// the deployments have to be loaded.
+func topDeployers(deployments []*models.Deployment, n int) []*deployerActivity {
+	byUser := map[int]*deployerActivity{}
+	deployers := []*deployerActivity{}
+
+	for _, d := range deployments {
+		if d.User == nil {
+			continue
+		}
+		a, ok := byUser[d.UserId]
+		if !ok {
+			a = &deployerActivity{User: d.User}
+			byUser[d.UserId] = a
+			deployers = append(deployers, a)
+		}
+		a.Deployments++
+	}
+
+	sort.SliceStable(deployers, func(i, j int) bool {
+		if deployers[i].Deployments != deployers[j].Deployments {
+			return deployers[i].Deployments > deployers[j].Deployments
+		}
+		return deployers[i].User.Name < deployers[j].User.Name
+	})
+
+	if len(deployers) > n {
+		deployers = deployers[:n]
+	}
+	return deployers
+}
+
+func NewWeeklySummaryDigest(receivers []string, s *WeeklySummary) (*DailyDigest, error) {
+	var textBody bytes.Buffer
+	tmpl, err := template.New("weeklySummaryTextBody").Parse(weeklySummaryTextTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if err = tmpl.Execute(&textBody, s); err != nil {
+		return nil, err
+	}
+
+	var htmlBody bytes.Buffer
+	htmlTmpl, err := htmltemplate.New("").ParseFS(templatesFS, "email_head.tmpl", weeklySummaryHtmlTemplateFilename)
+	if err != nil {
+		return nil, err
+	}
+	if err = htmlTmpl.ExecuteTemplate(&htmlBody, weeklySummaryHtmlTemplateFilename, s); err != nil {
+		return nil, err
+	}
+
+	digest := &DailyDigest{
+		FromName:  digestFromName,
+		FromEmail: digestFromEmail,
+		Receivers: receivers,
+		TextBody:  textBody,
+		HtmlBody:  htmlBody,
+		Subject:   fmt.Sprintf(weeklySummarySubjectFmt, s.From.Format("02.01.2006")),
+	}
+	return digest, nil
+}
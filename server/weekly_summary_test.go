@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/applikatoni/applikatoni/deploy"
+	"github.com/applikatoni/applikatoni/models"
+)
+
+func TestCalcNextWeeklySummary(t *testing.T) {
+	tests := []struct {
+		now      time.Time
+		expected time.Time
+	}{
+		// Wednesday
+		{time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+		// Monday before and after 8:00
+		{time.Date(2026, 10, 19, 7, 59, 0, 0, time.UTC), time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC), time.Date(2026, 10, 26, 8, 0, 0, 0, time.UTC)},
+		// Sunday, across the end of the month
+		{time.Date(2026, 11, 29, 23, 0, 0, 0, time.UTC), time.Date(2026, 11, 30, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got := calcNextWeeklySummary(tt.now, time.Monday, 8)
+		if !got.Equal(tt.expected) {
+			t.Errorf("wrong next summary after %s. want=%s, got=%s", tt.now, tt.expected, got)
+		}
+	}
+}
+
+func TestSlowestStages(t *testing.T) {
+	deployments := []*models.Deployment{
+		{Id: 1, ApplicationName: "web", TargetName: "production"},
+		{Id: 2, ApplicationName: "web", TargetName: "production"},
+		{Id: 3, ApplicationName: "api", TargetName: "staging"},
+	}
+
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	entry := func(id int, entryType deploy.LogEntryType, stage string, after time.Duration) *deploy.LogEntry {
+		return &deploy.LogEntry{DeploymentId: id, EntryType: entryType, Message: stage, Timestamp: start.Add(after)}
+	}
+	entries := []*deploy.LogEntry{
+		entry(1, deploy.STAGE_START, "checkout", 0),
+		entry(1, deploy.STAGE_SUCCESS, "checkout", 10*time.Second),
+		entry(1, deploy.STAGE_START, "migrate", 10*time.Second),
+		entry(1, deploy.STAGE_SUCCESS, "migrate", 70*time.Second),
+		entry(2, deploy.STAGE_START, "checkout", 0),
+		entry(2, deploy.STAGE_SUCCESS, "checkout", 30*time.Second),
+		// Failed stages are left out
+		entry(3, deploy.STAGE_START, "checkout", 0),
+		// Unknown deployments are ignored
+		entry(4, deploy.STAGE_START, "checkout", 0),
+		entry(4, deploy.STAGE_SUCCESS, "checkout", time.Hour),
+	}
+
+	stages := slowestStages(deployments, entries, 5)
+	if len(stages) != 2 {
+		t.Fatalf("wrong number of stages. want=2, got=%d", len(stages))
+	}
+
+	migrate, checkout := stages[0], stages[1]
+	if migrate.Stage != "migrate" || migrate.Runs != 1 || migrate.Average() != time.Minute {
+		t.Errorf("wrong slowest stage. got=%+v", migrate)
+	}
+	if checkout.Stage != "checkout" || checkout.Runs != 2 || checkout.Average() != 20*time.Second || checkout.Max != 30*time.Second {
+		t.Errorf("wrong checkout stage. got=%+v", checkout)
+	}
+
+	if stages := slowestStages(deployments, entries, 1); len(stages) != 1 || stages[0].Stage != "migrate" {
+		t.Errorf("wrong stages limited to 1. got=%v", stages)
+	}
+}
+
+func TestTopDeployers(t *testing.T) {
+	alice := buildUser(1, "alice")
+	bob := buildUser(2, "bob")
+	carol := buildUser(3, "carol")
+
+	deployments := []*models.Deployment{
+		{UserId: 2, User: bob},
+		{UserId: 3, User: carol},
+		{UserId: 1, User: alice},
+		{UserId: 3, User: carol},
+		{UserId: 2, User: bob},
+		{UserId: 3, User: carol},
+	}
+
+	deployers := topDeployers(deployments, 2)
+	if len(deployers) != 2 {
+		t.Fatalf("wrong number of deployers. want=2, got=%d", len(deployers))
+	}
+	if deployers[0].User != carol || deployers[0].Deployments != 3 {
+		t.Errorf("wrong most active deployer. got=%s with %d", deployers[0].User.Name, deployers[0].Deployments)
+	}
+	if deployers[1].User != bob || deployers[1].Deployments != 2 {
+		t.Errorf("wrong second deployer. got=%s with %d", deployers[1].User.Name, deployers[1].Deployments)
+	}
+}
+
+func TestBuildWeeklySummary(t *testing.T) {
+	db := newTestDb(t)
+	defer cleanCloseTestDb(db, t)
+
+	user := buildUser(1, "mrnugget")
+	checkErr(t, createUser(db, user))
+
+	to := time.Now()
+	from := to.AddDate(0, 0, -7)
+
+	stmt := `INSERT INTO deployments
+	(user_id, application_name, target_name, commit_sha, branch, comment, state, created_at)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?);`
+
+	existingDeployments := []struct {
+		applicationName string
+		createdAt       time.Time
+		state           models.DeploymentState
+	}{
+		{"web", to.Add(-time.Hour), models.DEPLOYMENT_SUCCESSFUL},
+		{"web", to.Add(-48 * time.Hour), models.DEPLOYMENT_FAILED},
+		{"api", to.Add(-72 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
+		// these should not be included
+		{"web", to.Add(-8 * 24 * time.Hour), models.DEPLOYMENT_SUCCESSFUL},
+		{"web", to.Add(-time.Hour), models.DEPLOYMENT_ACTIVE},
+	}
+
+	for _, ed := range existingDeployments {
+		_, err := db.Exec(stmt, user.Id, ed.applicationName, "production",
+			"f00b4r", "master", "foo", string(ed.state), ed.createdAt)
+		checkErr(t, err)
+	}
+
+	deployments, err := getWeeklySummaryDeployments(db, from, to)
+	checkErr(t, err)
+	stageStart := &deploy.LogEntry{DeploymentId: deployments[0].Id, EntryType: deploy.STAGE_START, Message: "checkout", Timestamp: to.Add(-time.Hour)}
+	checkErr(t, createLogEntry(db, stageStart))
+	stageSuccess := &deploy.LogEntry{DeploymentId: deployments[0].Id, EntryType: deploy.STAGE_SUCCESS, Message: "checkout", Timestamp: to.Add(-time.Hour + 42*time.Second)}
+	checkErr(t, createLogEntry(db, stageSuccess))
+
+	summary, err := buildWeeklySummary(db, from, to)
+	checkErr(t, err)
+
+	if summary.Deployments != 3 || summary.Failed != 1 {
+		t.Errorf("wrong number of deployments. want=3 with 1 failed, got=%d with %d failed", summary.Deployments, summary.Failed)
+	}
+	if len(summary.Applications) != 2 || summary.Applications[0].Name != "api" || summary.Applications[1].Failed != 1 {
+		t.Errorf("wrong applications. got=%+v", summary.Applications)
+	}
+	if len(summary.SlowestStages) != 1 || summary.SlowestStages[0].Average() != 42*time.Second {
+		t.Errorf("wrong slowest stages. got=%+v", summary.SlowestStages)
+	}
+	if len(summary.TopDeployers) != 1 || summary.TopDeployers[0].User.Name != "mrnugget" || summary.TopDeployers[0].Deployments != 3 {
+		t.Errorf("wrong top deployers. got=%+v", summary.TopDeployers)
+	}
+}
+
+func TestNewWeeklySummaryDigest(t *testing.T) {
+	templatesFS = os.DirFS("assets/templates")
+	defer func() { templatesFS = nil }()
+
+	summary := &WeeklySummary{
+		From:         time.Date(2026, 10, 12, 8, 0, 0, 0, time.UTC),
+		To:           time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+		Deployments:  4,
+		Failed:       1,
+		Applications: []*applicationActivity{{Name: "web", Deployments: 4, Failed: 1}},
+		SlowestStages: []*stageTiming{
+			{Application: "web", Target: "production", Stage: "migrate", Runs: 2, Total: 3 * time.Minute, Max: 2 * time.Minute},
+		},
+		TopDeployers: []*deployerActivity{{User: buildUser(1, "mrnugget"), Deployments: 4}},
+	}
+
+	digest, err := NewWeeklySummaryDigest([]string{"ops@shipping-company.com"}, summary)
+	checkErr(t, err)
+
+	if !strings.Contains(digest.Subject, "Weekly Summary - 12.10.2026") {
+		t.Errorf("wrong subject. got=%q", digest.Subject)
+	}
+
+	expected := []string{
+		"4 deployments, 1 failed (25.0%)",
+		"web/production migrate: 1m30s (2 runs, at most 2m0s)",
+		"mrnugget: 4 deployments",
+	}
+	for _, s := range expected {
+		if !strings.Contains(digest.TextBody.String(), s) {
+			t.Errorf("text body doesn't contain %q. got=%s", s, digest.TextBody.String())
+		}
+	}
+	if !strings.Contains(digest.HtmlBody.String(), "1m30s") || !strings.Contains(digest.HtmlBody.String(), "<style") {
+		t.Errorf("wrong html body. got=%s", digest.HtmlBody.String())
+	}
+}